@@ -0,0 +1,164 @@
+package veneur
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Readers are added when they're busier than this fraction of the time
+// on average, and removed when they're less busy than the other.
+const (
+	adaptiveReadersScaleUp   = 0.75
+	adaptiveReadersScaleDown = 0.25
+)
+
+// errReaderRetired is the error that the socket of a reader that
+// adaptiveReaders removed returns, so that the reader stops.
+var errReaderRetired = errors.New("the reader was retired")
+
+// adaptiveReaders scales the readers of a UDP address, which each read
+// from their own SO_REUSEPORT socket, between a minimum and a maximum
+// number, depending on how busy they are.
+type adaptiveReaders struct {
+	s        *Server
+	protocol string
+	addr     *net.UDPAddr
+	pool     *sync.Pool
+	proc     udpProcessor
+	min, max int
+
+	// the sockets of the running readers, by reader index; only the
+	// control loop touches it once it's started.
+	socks []*busyConn
+	// busy is the time each reader spent handling datagrams since the
+	// last adjustment, in nanoseconds, by reader index; accessed
+	// atomically.
+	busy []int64
+}
+
+// newAdaptiveReaders returns the readers of addr, whose first socks are
+// already bound. Nothing reads from them until start is called.
+func newAdaptiveReaders(s *Server, protocol string, addr *net.UDPAddr, pool *sync.Pool, proc udpProcessor, socks []net.PacketConn) *adaptiveReaders {
+	ar := &adaptiveReaders{
+		s:        s,
+		protocol: protocol,
+		addr:     addr,
+		pool:     pool,
+		proc:     proc,
+		min:      s.adaptiveReadersMin,
+		max:      s.adaptiveReadersMax,
+		busy:     make([]int64, s.adaptiveReadersMax),
+	}
+	for _, sock := range socks {
+		ar.socks = append(ar.socks, &busyConn{PacketConn: sock, busy: &ar.busy[len(ar.socks)]})
+	}
+	return ar
+}
+
+// start starts the readers, and adjusts their number every interval
+// until the server shuts down.
+func (ar *adaptiveReaders) start(interval time.Duration) {
+	for i, sock := range ar.socks {
+		ar.run(sock, i)
+	}
+	go func() {
+		defer func() {
+			ConsumePanic(ar.s.TraceClient, ar.s.Hostname, recover())
+		}()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-ar.s.shutdown:
+				return
+			case now := <-ticker.C:
+				ar.adjust(now.Sub(last))
+				last = now
+			}
+		}
+	}()
+}
+
+// run starts the reader with the given index on sock.
+func (ar *adaptiveReaders) run(sock *busyConn, reader int) {
+	go func() {
+		defer func() {
+			ConsumePanic(ar.s.TraceClient, ar.s.Hostname, recover())
+		}()
+		ar.proc(sock, ar.pool, reader)
+	}()
+}
+
+// adjust adds or removes a reader depending on how busy the readers
+// were over the elapsed time, and reports their number.
+func (ar *adaptiveReaders) adjust(elapsed time.Duration) {
+	var busy int64
+	for i := range ar.busy {
+		busy += atomic.SwapInt64(&ar.busy[i], 0)
+	}
+	n := len(ar.socks)
+	utilization := float64(busy) / float64(elapsed) / float64(n)
+
+	switch {
+	case utilization > adaptiveReadersScaleUp && n < ar.max:
+		sock, _, err := NewSizedSocket(ar.addr, ar.s.RcvbufBytes, ar.s.rcvbufAutosize, true)
+		if err != nil {
+			log.WithError(err).WithField("address", ar.addr).Error("Couldn't add a UDP reader")
+			break
+		}
+		bc := &busyConn{PacketConn: sock, busy: &ar.busy[n]}
+		ar.socks = append(ar.socks, bc)
+		ar.run(bc, n)
+	case utilization < adaptiveReadersScaleDown && n > ar.min:
+		// Datagrams still queued on the socket are dropped when it's
+		// closed.
+		ar.socks[n-1].retire()
+		ar.socks = ar.socks[:n-1]
+	}
+	if len(ar.socks) != n {
+		log.WithFields(logrus.Fields{
+			"address":     ar.addr,
+			"protocol":    ar.protocol,
+			"readers":     len(ar.socks),
+			"utilization": utilization,
+		}).Info("Changed the number of UDP readers")
+	}
+
+	tags := []string{"protocol:" + ar.protocol, "listener:" + ar.addr.String()}
+	ar.s.Statsd.Gauge("listener.readers", float64(len(ar.socks)), tags, 1.0)
+	ar.s.Statsd.Gauge("listener.reader_utilization", utilization, tags, 1.0)
+}
+
+// busyConn is the socket of a single reader, which counts the time that
+// the reader spends between reads, handling the datagrams it read.
+type busyConn struct {
+	net.PacketConn
+	busy    *int64
+	retired int32
+	// when the last read returned; only the reader touches it
+	since time.Time
+}
+
+func (c *busyConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if !c.since.IsZero() {
+		atomic.AddInt64(c.busy, int64(time.Since(c.since)))
+	}
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	c.since = time.Now()
+	if err != nil && atomic.LoadInt32(&c.retired) == 1 {
+		return n, addr, errReaderRetired
+	}
+	return n, addr, err
+}
+
+// retire closes the socket, which stops its reader.
+func (c *busyConn) retire() {
+	atomic.StoreInt32(&c.retired, 1)
+	c.Close()
+}
@@ -0,0 +1,65 @@
+package veneur
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveReadersAdjust(t *testing.T) {
+	if !ReusePortSupported() {
+		t.Skip("SO_REUSEPORT is not supported")
+	}
+	s := &Server{adaptiveReadersMin: 1, adaptiveReadersMax: 2}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	sock, err := NewSocket(addr, 0, true)
+	require.NoError(t, err)
+	addr.Port = sock.LocalAddr().(*net.UDPAddr).Port
+
+	stopped := make(chan int, 2)
+	proc := func(sock net.PacketConn, pool *sync.Pool, reader int) {
+		buf := make([]byte, 16)
+		for {
+			if _, _, err := sock.ReadFrom(buf); err == errReaderRetired {
+				stopped <- reader
+				return
+			}
+		}
+	}
+	ar := newAdaptiveReaders(s, "statsd", addr, nil, proc, []net.PacketConn{sock})
+	ar.run(ar.socks[0], 0)
+	defer ar.socks[0].retire()
+
+	// a busy reader gets company, up to the maximum
+	ar.busy[0] = int64(time.Second)
+	ar.adjust(time.Second)
+	assert.Len(t, ar.socks, 2)
+	ar.busy[0], ar.busy[1] = int64(time.Second), int64(time.Second)
+	ar.adjust(time.Second)
+	assert.Len(t, ar.socks, 2)
+
+	// idle readers are retired, down to the minimum
+	ar.adjust(time.Second)
+	assert.Len(t, ar.socks, 1)
+	select {
+	case reader := <-stopped:
+		assert.Equal(t, 1, reader)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the retired reader didn't stop")
+	}
+	ar.adjust(time.Second)
+	assert.Len(t, ar.socks, 1)
+}
+
+func TestNumReadersAdaptiveInvalid(t *testing.T) {
+	config := localConfig()
+	config.NumReadersAdaptiveMin = 4
+	config.NumReadersAdaptiveMax = 2
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+}
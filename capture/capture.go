@@ -0,0 +1,262 @@
+// Package capture records the raw statsd packets that veneur receives
+// over UDP to a file, and reads and replays them, for load tests and for
+// reproducing problems with real traffic.
+//
+// A capture file starts with the 8 bytes "VNRCAP01", followed by one
+// record per packet: the time it was received, in nanoseconds since the
+// UNIX epoch, as a big-endian int64, the length of the packet, as a
+// big-endian uint32, and the packet itself.
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// magic starts every capture file.
+var magic = []byte("VNRCAP01")
+
+// recordHeaderSize is the size of the timestamp and length that precede
+// each packet.
+const recordHeaderSize = 12
+
+// ErrFull is returned by Writer.Write once the capture file has reached
+// its maximum size.
+var ErrFull = errors.New("the capture file reached its maximum size")
+
+// ErrClosed is returned by Writer.Write once the capture file is closed.
+var ErrClosed = errors.New("the capture file is closed")
+
+// queueSize is how many packets a Writer queues for writing at most.
+const queueSize = 4096
+
+// Packet is a captured packet.
+type Packet struct {
+	// Time is when the packet was received.
+	Time time.Time
+	Data []byte
+}
+
+// Writer appends packets to a capture file. It's safe to use from
+// multiple goroutines: Write only queues the packet for a goroutine that
+// writes the file, so the goroutines that receive packets don't wait on
+// each other, or on the file.
+type Writer struct {
+	f        *os.File
+	buf      *bufio.Writer
+	maxBytes int64
+	// size is how big the file will be once the queued packets are
+	// written; accessed atomically.
+	size int64
+	// queue holds the packets to write, and the requests to flush or
+	// close the file, in order.
+	queue chan record
+	// dropped is the number of packets that Write dropped because the
+	// queue was full; accessed atomically.
+	dropped int64
+
+	// err holds the first error that Write returned, as a stopError,
+	// which it keeps returning.
+	err     atomic.Value
+	errOnce sync.Once
+
+	// reqMtx serializes Flush and Close.
+	reqMtx sync.Mutex
+	closed bool
+}
+
+// record is a packet in a Writer's queue, or, if done is set, a request
+// to flush the file, or to close it if close is set, whose outcome is
+// sent to done.
+type record struct {
+	t      time.Time
+	packet []byte
+	done   chan error
+	close  bool
+}
+
+type stopError struct {
+	err error
+}
+
+// Create creates the capture file at path, truncating it if it exists.
+// The file stops growing at maxBytes, unless it's 0.
+func Create(path string, maxBytes int64) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		f:        f,
+		buf:      bufio.NewWriter(f),
+		maxBytes: maxBytes,
+		size:     int64(len(magic)),
+		queue:    make(chan record, queueSize),
+	}
+	if _, err := w.buf.Write(magic); err != nil {
+		f.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Write queues a packet that was received at t to be appended to the
+// file. The packet is copied, so it can be reused once Write returns. If
+// the queue is full, because the file can't keep up, the packet is
+// dropped and counted in Dropped. Once Write fails, it keeps returning
+// the same error: ErrFull if the file reached its maximum size,
+// ErrClosed if it was closed, or the error that writing it failed with.
+func (w *Writer) Write(t time.Time, packet []byte) error {
+	if err := w.Err(); err != nil {
+		return err
+	}
+	size := int64(recordHeaderSize + len(packet))
+	if w.maxBytes > 0 && atomic.AddInt64(&w.size, size) > w.maxBytes {
+		w.stop(ErrFull)
+		return w.Err()
+	}
+	select {
+	case w.queue <- record{t: t, packet: append([]byte(nil), packet...)}:
+	default:
+		atomic.AddInt64(&w.size, -size)
+		atomic.AddInt64(&w.dropped, 1)
+	}
+	return nil
+}
+
+// Err returns the error that Write keeps returning, or nil if it still
+// accepts packets.
+func (w *Writer) Err() error {
+	if stopped, ok := w.err.Load().(stopError); ok {
+		return stopped.err
+	}
+	return nil
+}
+
+// Dropped returns the number of packets that Write dropped because the
+// file couldn't keep up with them.
+func (w *Writer) Dropped() int64 {
+	return atomic.LoadInt64(&w.dropped)
+}
+
+func (w *Writer) stop(err error) {
+	w.errOnce.Do(func() {
+		w.err.Store(stopError{err})
+	})
+}
+
+// Flush writes the queued packets to the file.
+func (w *Writer) Flush() error {
+	return w.request(false)
+}
+
+// Close writes the queued packets and closes the file.
+func (w *Writer) Close() error {
+	w.stop(ErrClosed)
+	return w.request(true)
+}
+
+// request asks the writing goroutine to flush the file, and to close it
+// if close is true, once it wrote the packets that are queued before the
+// request.
+func (w *Writer) request(close bool) error {
+	w.reqMtx.Lock()
+	defer w.reqMtx.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	w.closed = close
+	done := make(chan error, 1)
+	w.queue <- record{done: done, close: close}
+	return <-done
+}
+
+// run writes the queued packets to the file, until it's closed.
+func (w *Writer) run() {
+	var writeErr error
+	for r := range w.queue {
+		if r.done != nil {
+			err := writeErr
+			if err == nil {
+				err = w.buf.Flush()
+			}
+			if r.close {
+				if closeErr := w.f.Close(); err == nil {
+					err = closeErr
+				}
+				r.done <- err
+				return
+			}
+			r.done <- err
+			continue
+		}
+		if writeErr != nil {
+			continue
+		}
+
+		var header [recordHeaderSize]byte
+		binary.BigEndian.PutUint64(header[:8], uint64(r.t.UnixNano()))
+		binary.BigEndian.PutUint32(header[8:], uint32(len(r.packet)))
+		if _, writeErr = w.buf.Write(header[:]); writeErr == nil {
+			_, writeErr = w.buf.Write(r.packet)
+		}
+		if writeErr != nil {
+			w.stop(writeErr)
+		}
+	}
+}
+
+// Reader reads the packets of a capture file, in order.
+type Reader interface {
+	// Next returns the next packet, or io.EOF after the last one.
+	Next() (Packet, error)
+}
+
+// NewReader returns a Reader for a capture file, which is either in
+// veneur's own format, or in the pcap format that tcpdump writes, in
+// which case it reads the UDP datagrams sent to port, or all of them if
+// port is 0.
+func NewReader(r io.Reader, port int) (Reader, error) {
+	br := bufio.NewReader(r)
+	start, err := br.Peek(len(magic))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the capture file's header: %v", err)
+	}
+	if bytes.Equal(start, magic) {
+		br.Discard(len(magic))
+		return &fileReader{r: br}, nil
+	}
+	return newPcapReader(br, port)
+}
+
+// fileReader reads capture files in veneur's own format.
+type fileReader struct {
+	r *bufio.Reader
+}
+
+func (fr *fileReader) Next() (Packet, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(fr.r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return Packet{}, fmt.Errorf("truncated packet header")
+		}
+		return Packet{}, err
+	}
+	p := Packet{
+		Time: time.Unix(0, int64(binary.BigEndian.Uint64(header[:8]))),
+		Data: make([]byte, binary.BigEndian.Uint32(header[8:])),
+	}
+	if _, err := io.ReadFull(fr.r, p.Data); err != nil {
+		return Packet{}, fmt.Errorf("truncated packet: %v", err)
+	}
+	return p, nil
+}
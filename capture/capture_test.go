@@ -0,0 +1,280 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, r Reader) []Packet {
+	var packets []Packet
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return packets
+		}
+		require.NoError(t, err)
+		packets = append(packets, p)
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "capture")
+
+	start := time.Unix(1600000000, 123456789)
+	packets := []Packet{
+		{Time: start, Data: []byte("a.b.c:1|c")},
+		{Time: start.Add(time.Millisecond), Data: []byte("a.b.c:2|c\nd.e.f:3|g")},
+		{Time: start.Add(time.Second), Data: []byte{}},
+	}
+	// the last packet doesn't fit
+	maxBytes := int64(len(magic) + 3*recordHeaderSize + 9 + 19)
+	w, err := Create(path, maxBytes)
+	require.NoError(t, err)
+	for _, p := range packets {
+		require.NoError(t, w.Write(p.Time, p.Data))
+	}
+	assert.Equal(t, ErrFull, w.Write(start, []byte("g:1|c")))
+	assert.Equal(t, ErrFull, w.Write(start, nil), "the capture should stay stopped")
+	require.NoError(t, w.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	r, err := NewReader(f, 0)
+	require.NoError(t, err)
+	read := readAll(t, r)
+	require.Len(t, read, len(packets))
+	for i, p := range packets {
+		assert.True(t, p.Time.Equal(read[i].Time), "packet %d time", i)
+		assert.Equal(t, p.Data, read[i].Data, "packet %d data", i)
+	}
+}
+
+func TestWriteConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "capture")
+
+	w, err := Create(path, 0)
+	require.NoError(t, err)
+	const writers, packets = 4, 100
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < packets; j++ {
+				assert.NoError(t, w.Write(time.Now(), []byte(fmt.Sprintf("w%d.p%d:1|c", i, j))))
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.NoError(t, w.Close())
+	assert.Equal(t, ErrClosed, w.Write(time.Now(), []byte("a:1|c")), "the capture should stop once it's closed")
+	assert.Equal(t, ErrClosed, w.Close())
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	r, err := NewReader(f, 0)
+	require.NoError(t, err)
+	assert.Len(t, readAll(t, r), writers*packets-int(w.Dropped()))
+}
+
+func TestReadTruncated(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(magic)
+	buf.Write([]byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 10})
+	buf.WriteString("a:1|c")
+	r, err := NewReader(&buf, 0)
+	require.NoError(t, err)
+	_, err = r.Next()
+	assert.Error(t, err)
+	assert.NotEqual(t, io.EOF, err)
+}
+
+func TestReadUnknownFormat(t *testing.T) {
+	_, err := NewReader(bytes.NewBufferString("this is not a capture file at all"), 0)
+	assert.Error(t, err)
+}
+
+// pcapFile writes a little-endian pcap file with microsecond timestamps.
+func pcapFile(linkType uint32, frames ...[]byte) []byte {
+	var buf bytes.Buffer
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], linkType)
+	buf.Write(header)
+	for i, frame := range frames {
+		record := make([]byte, 16)
+		binary.LittleEndian.PutUint32(record[0:], 1600000000)
+		binary.LittleEndian.PutUint32(record[4:], uint32(i*1000))
+		binary.LittleEndian.PutUint32(record[8:], uint32(len(frame)))
+		binary.LittleEndian.PutUint32(record[12:], uint32(len(frame)))
+		buf.Write(record)
+		buf.Write(frame)
+	}
+	return buf.Bytes()
+}
+
+func udpDatagram(port uint16, payload string) []byte {
+	udp := make([]byte, 8+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], 54321)
+	binary.BigEndian.PutUint16(udp[2:], port)
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	copy(udp[8:], payload)
+	return udp
+}
+
+func ipv4Packet(protocol byte, payload []byte) []byte {
+	ip := make([]byte, 20+len(payload))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)))
+	ip[8] = 64
+	ip[9] = protocol
+	copy(ip[12:], []byte{127, 0, 0, 1})
+	copy(ip[16:], []byte{127, 0, 0, 1})
+	copy(ip[20:], payload)
+	return ip
+}
+
+func ipv6Packet(payload []byte) []byte {
+	ip := make([]byte, 40+len(payload))
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:], uint16(len(payload)))
+	ip[6] = protocolUDP
+	ip[7] = 64
+	ip[23] = 1
+	ip[39] = 1
+	copy(ip[40:], payload)
+	return ip
+}
+
+func ethernetFrame(etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 14+len(payload))
+	binary.BigEndian.PutUint16(frame[12:], etherType)
+	copy(frame[14:], payload)
+	return frame
+}
+
+func TestReadPcap(t *testing.T) {
+	fragment := ipv4Packet(protocolUDP, udpDatagram(8125, "frag:1|c"))
+	binary.BigEndian.PutUint16(fragment[6:], 0x2000)
+
+	file := pcapFile(linkTypeEthernet,
+		ethernetFrame(etherTypeIPv4, ipv4Packet(protocolUDP, udpDatagram(8125, "a.b.c:1|c"))),
+		ethernetFrame(etherTypeIPv4, ipv4Packet(protocolUDP, udpDatagram(9999, "other:1|c"))),
+		ethernetFrame(etherTypeIPv4, ipv4Packet(6, udpDatagram(8125, "tcp:1|c"))),
+		ethernetFrame(etherTypeIPv4, fragment),
+		ethernetFrame(etherTypeIPv6, ipv6Packet(udpDatagram(8125, "d.e.f:2|g"))),
+		append([]byte{0x08, 0x06}, make([]byte, 40)...),
+	)
+	r, err := NewReader(bytes.NewReader(file), 8125)
+	require.NoError(t, err)
+	packets := readAll(t, r)
+	require.Len(t, packets, 2)
+	assert.Equal(t, "a.b.c:1|c", string(packets[0].Data))
+	assert.Equal(t, time.Unix(1600000000, 0), packets[0].Time)
+	assert.Equal(t, "d.e.f:2|g", string(packets[1].Data))
+	assert.Equal(t, time.Unix(1600000000, 4*int64(time.Millisecond)), packets[1].Time)
+
+	r, err = NewReader(bytes.NewReader(file), 0)
+	require.NoError(t, err)
+	assert.Len(t, readAll(t, r), 3, "every port should be read")
+}
+
+func TestReadPcapLinkTypes(t *testing.T) {
+	ip := ipv4Packet(protocolUDP, udpDatagram(8125, "a.b.c:1|c"))
+	sll := make([]byte, 16)
+	binary.BigEndian.PutUint16(sll[14:], etherTypeIPv4)
+	null := []byte{2, 0, 0, 0}
+
+	for name, file := range map[string][]byte{
+		"raw":      pcapFile(linkTypeRaw, ip),
+		"sll":      pcapFile(linkTypeLinuxSLL, append(sll, ip...)),
+		"null":     pcapFile(linkTypeNull, append(null, ip...)),
+		"ethernet": pcapFile(linkTypeEthernet, ethernetFrame(etherTypeVLAN, append([]byte{0, 1, 0x08, 0}, ip...))),
+	} {
+		t.Run(name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(file), 8125)
+			require.NoError(t, err)
+			packets := readAll(t, r)
+			require.Len(t, packets, 1)
+			assert.Equal(t, "a.b.c:1|c", string(packets[0].Data))
+		})
+	}
+
+	_, err := NewReader(bytes.NewReader(pcapFile(147)), 8125)
+	assert.Error(t, err, "unknown link types should be rejected")
+}
+
+type sliceReader []Packet
+
+func (r *sliceReader) Next() (Packet, error) {
+	if len(*r) == 0 {
+		return Packet{}, io.EOF
+	}
+	p := (*r)[0]
+	*r = (*r)[1:]
+	return p, nil
+}
+
+type timedWriter struct {
+	start time.Time
+	sent  []time.Duration
+	data  []string
+}
+
+func (w *timedWriter) Write(p []byte) (int, error) {
+	w.sent = append(w.sent, time.Since(w.start))
+	w.data = append(w.data, string(p))
+	return len(p), nil
+}
+
+func TestReplay(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	packets := func() *sliceReader {
+		return &sliceReader{
+			{Time: start, Data: []byte("a:1|c")},
+			{Time: start.Add(200 * time.Millisecond), Data: []byte("b:1|c")},
+		}
+	}
+
+	w := &timedWriter{start: time.Now()}
+	sent, err := Replay(context.Background(), packets(), w, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, []string{"a:1|c", "b:1|c"}, w.data)
+	assert.True(t, w.sent[1] >= 100*time.Millisecond, "the second packet should wait for half its original delay, waited %v", w.sent[1])
+
+	w = &timedWriter{start: time.Now()}
+	sent, err = Replay(context.Background(), packets(), w, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.True(t, w.sent[1] < 100*time.Millisecond, "packets should be sent right away, waited %v", w.sent[1])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sent, err = Replay(ctx, packets(), &timedWriter{start: time.Now()}, 1)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, sent)
+}
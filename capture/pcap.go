@@ -0,0 +1,191 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The link-layer header types of pcap files that pcapReader understands,
+// see https://www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull      = 0
+	linkTypeEthernet  = 1
+	linkTypeRaw       = 101
+	linkTypeLinuxSLL  = 113
+	linkTypeIPv4      = 228
+	linkTypeIPv6      = 229
+	linkTypeLinuxSLL2 = 276
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+
+	protocolUDP = 17
+)
+
+// pcapReader reads the UDP datagrams of a pcap file (not pcapng). It
+// skips the other packets, the IP fragments, and the packets that were
+// truncated by the capture's snapshot length.
+type pcapReader struct {
+	r        *bufio.Reader
+	order    binary.ByteOrder
+	nanos    bool
+	linkType uint32
+	port     int
+}
+
+func newPcapReader(r *bufio.Reader, port int) (*pcapReader, error) {
+	var header [24]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, fmt.Errorf("couldn't read the pcap header: %v", err)
+	}
+	pr := &pcapReader{r: r, port: port}
+	switch binary.LittleEndian.Uint32(header[:4]) {
+	case 0xa1b2c3d4:
+		pr.order = binary.LittleEndian
+	case 0xa1b23c4d:
+		pr.order, pr.nanos = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		pr.order = binary.BigEndian
+	case 0x4d3cb2a1:
+		pr.order, pr.nanos = binary.BigEndian, true
+	default:
+		return nil, fmt.Errorf("not a capture file: neither veneur's format nor pcap (pcapng isn't supported)")
+	}
+	pr.linkType = pr.order.Uint32(header[20:]) & 0xffff
+	switch pr.linkType {
+	case linkTypeNull, linkTypeEthernet, linkTypeRaw, linkTypeLinuxSLL, linkTypeIPv4, linkTypeIPv6, linkTypeLinuxSLL2:
+	default:
+		return nil, fmt.Errorf("unsupported pcap link type %d", pr.linkType)
+	}
+	return pr, nil
+}
+
+func (pr *pcapReader) Next() (Packet, error) {
+	for {
+		var header [16]byte
+		if _, err := io.ReadFull(pr.r, header[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return Packet{}, fmt.Errorf("truncated pcap record header")
+			}
+			return Packet{}, err
+		}
+		sec := int64(pr.order.Uint32(header[0:]))
+		frac := int64(pr.order.Uint32(header[4:]))
+		if !pr.nanos {
+			frac *= int64(time.Microsecond)
+		}
+		captured := pr.order.Uint32(header[8:])
+		original := pr.order.Uint32(header[12:])
+		frame := make([]byte, captured)
+		if _, err := io.ReadFull(pr.r, frame); err != nil {
+			return Packet{}, fmt.Errorf("truncated pcap record: %v", err)
+		}
+		if captured < original {
+			continue
+		}
+		if data, ok := pr.udpPayload(frame); ok {
+			return Packet{Time: time.Unix(sec, frac), Data: data}, nil
+		}
+	}
+}
+
+// udpPayload returns the payload of the frame, if it holds a whole UDP
+// datagram sent to the reader's port.
+func (pr *pcapReader) udpPayload(frame []byte) ([]byte, bool) {
+	var etherType uint16
+	switch pr.linkType {
+	case linkTypeNull:
+		if len(frame) < 4 {
+			return nil, false
+		}
+		// the address family is in the byte order of the host that
+		// captured the packets, which isn't recorded, but IPv4 is 2
+		// everywhere, while IPv6 varies
+		family := binary.LittleEndian.Uint32(frame)
+		if family > 0xffff {
+			family = binary.BigEndian.Uint32(frame)
+		}
+		etherType = etherTypeIPv6
+		if family == 2 {
+			etherType = etherTypeIPv4
+		}
+		frame = frame[4:]
+	case linkTypeEthernet:
+		if len(frame) < 14 {
+			return nil, false
+		}
+		etherType = binary.BigEndian.Uint16(frame[12:])
+		frame = frame[14:]
+		for etherType == etherTypeVLAN && len(frame) >= 4 {
+			etherType = binary.BigEndian.Uint16(frame[2:])
+			frame = frame[4:]
+		}
+	case linkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return nil, false
+		}
+		etherType = binary.BigEndian.Uint16(frame[14:])
+		frame = frame[16:]
+	case linkTypeLinuxSLL2:
+		if len(frame) < 20 {
+			return nil, false
+		}
+		etherType = binary.BigEndian.Uint16(frame)
+		frame = frame[20:]
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+		if len(frame) == 0 {
+			return nil, false
+		}
+		etherType = etherTypeIPv4
+		if frame[0]>>4 == 6 {
+			etherType = etherTypeIPv6
+		}
+	}
+
+	var udp []byte
+	switch etherType {
+	case etherTypeIPv4:
+		if len(frame) < 20 || frame[0]>>4 != 4 {
+			return nil, false
+		}
+		headerLen := int(frame[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(frame[2:]))
+		fragment := binary.BigEndian.Uint16(frame[6:])
+		// skip the fragments: the "more fragments" flag, or an offset
+		if fragment&0x3fff != 0 || frame[9] != protocolUDP ||
+			headerLen < 20 || totalLen < headerLen || totalLen > len(frame) {
+			return nil, false
+		}
+		udp = frame[headerLen:totalLen]
+	case etherTypeIPv6:
+		if len(frame) < 40 || frame[0]>>4 != 6 {
+			return nil, false
+		}
+		// extension headers aren't followed
+		payloadLen := int(binary.BigEndian.Uint16(frame[4:]))
+		if frame[6] != protocolUDP || 40+payloadLen > len(frame) {
+			return nil, false
+		}
+		udp = frame[40 : 40+payloadLen]
+	default:
+		return nil, false
+	}
+
+	if len(udp) < 8 {
+		return nil, false
+	}
+	if pr.port != 0 && int(binary.BigEndian.Uint16(udp[2:])) != pr.port {
+		return nil, false
+	}
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+	if length < 8 || length > len(udp) {
+		return nil, false
+	}
+	return udp[8:length], true
+}
@@ -0,0 +1,53 @@
+package capture
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Replay sends the packets that r reads to w, with one Write each. With a
+// positive speed, it keeps the packets' original timing, sped up by that
+// factor: 2 replays them twice as fast, and 0.5 at half speed. With a
+// speed of 0, it sends them as fast as it can. It stops at the first
+// error, or when ctx is done, and returns how many packets it sent.
+func Replay(ctx context.Context, r Reader, w io.Writer, speed float64) (int, error) {
+	var first time.Time
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+
+	sent := 0
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return sent, nil
+		}
+		if err != nil {
+			return sent, err
+		}
+
+		if speed > 0 {
+			if first.IsZero() {
+				first = p.Time
+			}
+			due := start.Add(time.Duration(float64(p.Time.Sub(first)) / speed))
+			if wait := time.Until(due); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					return sent, ctx.Err()
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		if _, err := w.Write(p.Data); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+}
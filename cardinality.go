@@ -0,0 +1,189 @@
+package veneur
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// cardinalityLimiter caps the number of series (distinct combinations of
+// type and tags) that each metric name can have in a flush interval.
+// It's shared by all the workers, which only ask it to admit the series
+// that they haven't seen yet in the interval.
+type cardinalityLimiter struct {
+	limit int
+
+	mtx   sync.Mutex
+	names map[string]*nameCardinality
+	// last is the cardinality of each name in the last flush interval,
+	// most series first.
+	last []cardinalityEntry
+}
+
+type nameCardinality struct {
+	series  int
+	dropped int64
+	// values collects the distinct values of each tag key, up to one
+	// more than the limit, to find the key that's responsible for the
+	// excess series.
+	values map[string]map[string]struct{}
+}
+
+// cardinalityEntry describes the cardinality of a metric name in a flush
+// interval: the number of series it had, and, if it exceeded the limit,
+// how many samples of its excess series were dropped, and the tag key
+// with the most distinct values.
+type cardinalityEntry struct {
+	Metric  string `json:"metric"`
+	Series  int    `json:"series"`
+	Dropped int64  `json:"dropped_samples,omitempty"`
+	TagKey  string `json:"tag_key,omitempty"`
+}
+
+func newCardinalityLimiter(limit int) *cardinalityLimiter {
+	return &cardinalityLimiter{
+		limit: limit,
+		names: map[string]*nameCardinality{},
+	}
+}
+
+// admit returns whether a new series of the metric fits under the
+// limit. If it doesn't, the series should be dropped.
+func (c *cardinalityLimiter) admit(name string, tags []string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	nc, ok := c.names[name]
+	if !ok {
+		nc = &nameCardinality{}
+		c.names[name] = nc
+	}
+	if nc.values == nil {
+		nc.values = map[string]map[string]struct{}{}
+	}
+	for _, tag := range tags {
+		key := tagKey(tag)
+		values, ok := nc.values[key]
+		if !ok {
+			values = map[string]struct{}{}
+			nc.values[key] = values
+		}
+		if len(values) <= c.limit {
+			values[tag] = struct{}{}
+		}
+	}
+
+	if nc.series < c.limit {
+		nc.series++
+		return true
+	}
+	nc.dropped++
+	return false
+}
+
+// flush starts a new flush interval, and returns the metrics that
+// exceeded the limit in the one that ended.
+func (c *cardinalityLimiter) flush() []cardinalityEntry {
+	c.mtx.Lock()
+	names := c.names
+	c.names = map[string]*nameCardinality{}
+	c.mtx.Unlock()
+
+	entries := make([]cardinalityEntry, 0, len(names))
+	var exceeded []cardinalityEntry
+	for name, nc := range names {
+		entry := cardinalityEntry{Metric: name, Series: nc.series, Dropped: nc.dropped}
+		if nc.dropped > 0 {
+			distinct := 0
+			for key, values := range nc.values {
+				if len(values) > distinct || (len(values) == distinct && key < entry.TagKey) {
+					entry.TagKey = key
+					distinct = len(values)
+				}
+			}
+			exceeded = append(exceeded, entry)
+		}
+		entries = append(entries, entry)
+	}
+	sortCardinality(entries)
+
+	c.mtx.Lock()
+	c.last = entries
+	c.mtx.Unlock()
+	return exceeded
+}
+
+// sortCardinality sorts the entries by their number of series, most
+// first.
+func sortCardinality(entries []cardinalityEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Series != entries[j].Series {
+			return entries[i].Series > entries[j].Series
+		}
+		return entries[i].Metric < entries[j].Metric
+	})
+}
+
+// top returns the n metric names that had the most series in the last
+// flush interval.
+func (c *cardinalityLimiter) top(n int) []cardinalityEntry {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if n > len(c.last) {
+		n = len(c.last)
+	}
+	return c.last[:n]
+}
+
+// reportCardinality starts a new interval for the cardinality limit, and
+// reports the metrics that exceeded it.
+func (s *Server) reportCardinality() {
+	var exceeded []cardinalityEntry
+	for _, c := range s.cardinality {
+		exceeded = append(exceeded, c.flush()...)
+	}
+	for _, entry := range exceeded {
+		tags := []string{"metric:" + entry.Metric, "tag_key:" + entry.TagKey}
+		s.Statsd.Count("cardinality.dropped_samples_total", entry.Dropped, tags, 1.0)
+		log.WithField("metric", entry.Metric).WithField("tag_key", entry.TagKey).
+			WithField("dropped_samples", entry.Dropped).
+			Warn("Dropped the excess series of a metric with too many series")
+	}
+}
+
+// handleDebugCardinality responds with the metrics that had the most
+// series in the last flush interval, 10 unless the number is given as the
+// query parameter n.
+func (s *Server) handleDebugCardinality(w http.ResponseWriter, r *http.Request) {
+	n := 10
+	if param := r.URL.Query().Get("n"); param != "" {
+		var err error
+		n, err = strconv.Atoi(param)
+		if err != nil || n < 0 {
+			http.Error(w, "n must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.topCardinality(n))
+}
+
+// topCardinality returns the n metric names that had the most series in
+// the last flush interval, across the limiters. Each name only has series
+// in one of them when there are several, since then the workers shard
+// metrics by name.
+func (s *Server) topCardinality(n int) []cardinalityEntry {
+	if len(s.cardinality) == 1 {
+		return s.cardinality[0].top(n)
+	}
+	var entries []cardinalityEntry
+	for _, c := range s.cardinality {
+		entries = append(entries, c.top(n)...)
+	}
+	sortCardinality(entries)
+	if n > len(entries) {
+		n = len(entries)
+	}
+	return entries[:n]
+}
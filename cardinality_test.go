@@ -0,0 +1,84 @@
+package veneur
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func TestCardinalityLimiter(t *testing.T) {
+	c := newCardinalityLimiter(2)
+	assert.True(t, c.admit("a.b.c", []string{"env:prod", "user:1"}))
+	assert.True(t, c.admit("a.b.c", []string{"env:prod", "user:2"}))
+	assert.False(t, c.admit("a.b.c", []string{"env:prod", "user:3"}))
+	assert.False(t, c.admit("a.b.c", []string{"env:prod", "user:4"}))
+	assert.True(t, c.admit("d.e.f", nil))
+
+	assert.Equal(t, []cardinalityEntry{
+		{Metric: "a.b.c", Series: 2, Dropped: 2, TagKey: "user"},
+	}, c.flush())
+	assert.Equal(t, []cardinalityEntry{
+		{Metric: "a.b.c", Series: 2, Dropped: 2, TagKey: "user"},
+		{Metric: "d.e.f", Series: 1},
+	}, c.top(10))
+	assert.Len(t, c.top(1), 1)
+
+	assert.True(t, c.admit("a.b.c", []string{"env:prod", "user:3"}), "the limit should start over in each interval")
+	assert.Empty(t, c.flush())
+}
+
+func TestCardinalityLimit(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	config.MetricCardinalityLimit = 2
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	for i := 0; i < 5; i++ {
+		tags := []string{"env:prod", fmt.Sprintf("user:%d", i%3)}
+		global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+			MetricKey: samplers.MetricKey{
+				Name:       "a.b.c",
+				Type:       counterTypeName,
+				JoinedTags: tags[0] + "," + tags[1],
+			},
+			Tags:       tags,
+			Value:      1.0,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+	}
+	global.Flush(context.Background())
+
+	results := <-rcv
+	var values []float64
+	for _, m := range results {
+		values = append(values, m.Value)
+	}
+	assert.ElementsMatch(t, []float64{2, 2}, values, "the series past the limit should be dropped")
+
+	srv := httptest.NewServer(global.Handler())
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/debug/cardinality?n=5")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var entries []cardinalityEntry
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&entries))
+	assert.Equal(t, []cardinalityEntry{
+		{Metric: "a.b.c", Series: 2, Dropped: 1, TagKey: "user"},
+	}, entries)
+}
@@ -33,6 +33,8 @@ func main() {
 		}
 	}
 
+	logrus.WithFields(conf.LogFields()).Info("Effective configuration")
+
 	logger := logrus.StandardLogger()
 	proxy, err := veneur.NewProxyFromConfig(logger, conf)
 	veneur.SetLogger(logger)
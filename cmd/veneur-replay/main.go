@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14/capture"
+	"github.com/stripe/veneur/v14/protocol"
+)
+
+var (
+	file     = flag.String("f", "", "The capture file to replay: one that veneur wrote with udp_capture_file, or a pcap file that tcpdump wrote.")
+	hostport = flag.String("hostport", "udp://127.0.0.1:8125", "The address to send the packets to, like 'udp://127.0.0.1:8125' or 'unixgram:///var/run/veneur/statsd.sock'.")
+	speed    = flag.Float64("speed", 1, "How much faster than they were captured to send the packets: 2 is twice as fast, 0.5 half as fast, and 0 as fast as possible.")
+	port     = flag.Int("port", 8125, "In pcap files, only replay the UDP datagrams sent to this port, or all of them if it's 0.")
+)
+
+func main() {
+	flag.Parse()
+
+	if *file == "" {
+		logrus.Fatal("-f is required")
+	}
+	if *speed < 0 {
+		logrus.WithField("speed", *speed).Fatal("-speed must not be negative")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		logrus.WithError(err).Fatal("could not open the capture file")
+	}
+	defer f.Close()
+	reader, err := capture.NewReader(f, *port)
+	if err != nil {
+		logrus.WithError(err).Fatal("could not read the capture file")
+	}
+
+	addr, err := protocol.ResolveAddr(*hostport)
+	if err != nil {
+		logrus.WithError(err).WithField("hostport", *hostport).Fatal("could not resolve the address")
+	}
+	conn, err := net.Dial(addr.Network(), addr.String())
+	if err != nil {
+		logrus.WithError(err).WithField("hostport", *hostport).Fatal("could not connect")
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	start := time.Now()
+	sent, err := capture.Replay(ctx, reader, conn, *speed)
+	log := logrus.WithFields(logrus.Fields{
+		"packets":  sent,
+		"duration": time.Since(start).String(),
+	})
+	if err != nil && err != context.Canceled {
+		log.WithError(err).Fatal("replay failed")
+	}
+	log.Info("replay done")
+}
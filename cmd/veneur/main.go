@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
+	"io"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/getsentry/sentry-go"
 	"github.com/sirupsen/logrus"
 	"github.com/stripe/veneur/v14"
+	"github.com/stripe/veneur/v14/samplers"
 	"github.com/stripe/veneur/v14/ssf"
 	"github.com/stripe/veneur/v14/trace"
 )
@@ -15,6 +21,7 @@ var (
 	configFile           = flag.String("f", "", "The config file to read for settings.")
 	validateConfig       = flag.Bool("validate-config", false, "Validate the config file is valid YAML with correct value types, then immediately exit.")
 	validateConfigStrict = flag.Bool("validate-config-strict", false, "Validate as with -validate-config, but also fail if there are any unknown fields.")
+	readStdin            = flag.Bool("stdin", false, "Instead of listening, read statsd metrics from stdin, one per line, then print the metrics that one flush of them produces as JSON lines and exit. Nothing is forwarded or flushed to sinks.")
 )
 
 func init() {
@@ -45,6 +52,8 @@ func main() {
 		os.Exit(0)
 	}
 
+	logrus.WithFields(conf.LogFields()).Info("Effective configuration")
+
 	logger := logrus.StandardLogger()
 	server, err := veneur.NewFromConfig(logger, conf)
 	veneur.SetLogger(logger)
@@ -83,12 +92,62 @@ func main() {
 		}
 		trace.DefaultClient = server.TraceClient
 	}
+	if *readStdin {
+		metrics, err := server.ReadMetrics(context.Background(), os.Stdin)
+		if err != nil {
+			logrus.WithError(err).Fatal("Could not read metrics from stdin")
+		}
+		if err := printMetrics(os.Stdout, metrics); err != nil {
+			logrus.WithError(err).Fatal("Could not print the metrics")
+		}
+		return
+	}
+
 	go server.FlushWatchdog()
 	server.Start()
 
+	// Reload the config on SIGHUP; ReloadConfig logs the outcome.
+	server.ReloadOnSIGHUP(*configFile)
+
 	if conf.HTTPAddress != "" || conf.GrpcAddress != "" {
 		server.Serve()
 	} else {
 		select {}
 	}
 }
+
+// printedMetric is how -stdin prints each flushed metric.
+type printedMetric struct {
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Value     float64   `json:"value"`
+	Values    []float64 `json:"values,omitempty"`
+	Tags      []string  `json:"tags,omitempty"`
+	Timestamp int64     `json:"timestamp"`
+}
+
+// printMetrics writes the metrics to w as JSON lines, sorted by name and
+// tags, so that the output of the same input is always the same.
+func printMetrics(w io.Writer, metrics []samplers.InterMetric) error {
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].Name != metrics[j].Name {
+			return metrics[i].Name < metrics[j].Name
+		}
+		return strings.Join(metrics[i].Tags, ",") < strings.Join(metrics[j].Tags, ",")
+	})
+	enc := json.NewEncoder(w)
+	for _, m := range metrics {
+		err := enc.Encode(printedMetric{
+			Name:      m.Name,
+			Type:      strings.ToLower(strings.TrimSuffix(m.Type.String(), "Metric")),
+			Value:     m.Value,
+			Values:    m.Values,
+			Tags:      m.Tags,
+			Timestamp: m.Timestamp,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
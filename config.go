@@ -1,97 +1,225 @@
 package veneur
 
 type Config struct {
-	Aggregates                             []string `yaml:"aggregates"`
-	AwsAccessKeyID                         string   `yaml:"aws_access_key_id"`
-	AwsRegion                              string   `yaml:"aws_region"`
-	AwsS3Bucket                            string   `yaml:"aws_s3_bucket"`
-	AwsSecretAccessKey                     string   `yaml:"aws_secret_access_key"`
-	BlockProfileRate                       int      `yaml:"block_profile_rate"`
-	CountUniqueTimeseries                  bool     `yaml:"count_unique_timeseries"`
-	DatadogAPIHostname                     string   `yaml:"datadog_api_hostname"`
-	DatadogAPIKey                          string   `yaml:"datadog_api_key"`
+	Aggregates            []string `yaml:"aggregates"`
+	AwsAccessKeyID        string   `yaml:"aws_access_key_id"`
+	AwsRegion             string   `yaml:"aws_region"`
+	AwsS3Bucket           string   `yaml:"aws_s3_bucket"`
+	AwsSecretAccessKey    string   `yaml:"aws_secret_access_key"`
+	BlockProfileRate      int      `yaml:"block_profile_rate"`
+	CountUniqueTimeseries bool     `yaml:"count_unique_timeseries"`
+	CounterSinkOverrides  []struct {
+		Sink string `yaml:"sink"`
+		Mode string `yaml:"mode"`
+	} `yaml:"counter_sink_overrides"`
+	CsvFileBufferSize                      int    `yaml:"csv_file_buffer_size"`
+	CsvFileDirectory                       string `yaml:"csv_file_directory"`
+	CsvFileFormat                          string `yaml:"csv_file_format"`
+	CsvFileMaxAge                          string `yaml:"csv_file_max_age"`
+	CsvFileMaxSizeBytes                    int64  `yaml:"csv_file_max_size_bytes"`
+	DatadogAPIHostname                     string `yaml:"datadog_api_hostname"`
+	DatadogAPIKey                          string `yaml:"datadog_api_key"`
 	DatadogExcludeTagsPrefixByPrefixMetric []struct {
 		MetricPrefix string   `yaml:"metric_prefix"`
 		Tags         []string `yaml:"tags"`
 	} `yaml:"datadog_exclude_tags_prefix_by_prefix_metric"`
-	DatadogFlushMaxPerBody                    int       `yaml:"datadog_flush_max_per_body"`
-	DatadogMetricNamePrefixDrops              []string  `yaml:"datadog_metric_name_prefix_drops"`
-	DatadogSpanBufferSize                     int       `yaml:"datadog_span_buffer_size"`
-	DatadogTraceAPIAddress                    string    `yaml:"datadog_trace_api_address"`
-	Debug                                     bool      `yaml:"debug"`
-	DebugFlushedMetrics                       bool      `yaml:"debug_flushed_metrics"`
-	DebugIngestedSpans                        bool      `yaml:"debug_ingested_spans"`
-	EnableProfiling                           bool      `yaml:"enable_profiling"`
-	FalconerAddress                           string    `yaml:"falconer_address"`
-	FlushFile                                 string    `yaml:"flush_file"`
-	FlushMaxPerBody                           int       `yaml:"flush_max_per_body"`
-	FlushWatchdogMissedFlushes                int       `yaml:"flush_watchdog_missed_flushes"`
-	ForwardAddress                            string    `yaml:"forward_address"`
-	ForwardUseGrpc                            bool      `yaml:"forward_use_grpc"`
-	GrpcAddress                               string    `yaml:"grpc_address"`
-	GrpcListenAddresses                       []string  `yaml:"grpc_listen_addresses"`
-	Hostname                                  string    `yaml:"hostname"`
-	HTTPAddress                               string    `yaml:"http_address"`
-	HTTPQuit                                  bool      `yaml:"http_quit"`
-	IndicatorSpanTimerName                    string    `yaml:"indicator_span_timer_name"`
-	Interval                                  string    `yaml:"interval"`
-	KafkaBroker                               string    `yaml:"kafka_broker"`
-	KafkaCheckTopic                           string    `yaml:"kafka_check_topic"`
-	KafkaEventTopic                           string    `yaml:"kafka_event_topic"`
-	KafkaMetricBufferBytes                    int       `yaml:"kafka_metric_buffer_bytes"`
-	KafkaMetricBufferFrequency                string    `yaml:"kafka_metric_buffer_frequency"`
-	KafkaMetricBufferMessages                 int       `yaml:"kafka_metric_buffer_messages"`
-	KafkaMetricRequireAcks                    string    `yaml:"kafka_metric_require_acks"`
-	KafkaMetricTopic                          string    `yaml:"kafka_metric_topic"`
-	KafkaPartitioner                          string    `yaml:"kafka_partitioner"`
-	KafkaRetryMax                             int       `yaml:"kafka_retry_max"`
-	KafkaSpanBufferBytes                      int       `yaml:"kafka_span_buffer_bytes"`
-	KafkaSpanBufferFrequency                  string    `yaml:"kafka_span_buffer_frequency"`
-	KafkaSpanBufferMesages                    int       `yaml:"kafka_span_buffer_mesages"`
-	KafkaSpanRequireAcks                      string    `yaml:"kafka_span_require_acks"`
-	KafkaSpanSampleRatePercent                float64   `yaml:"kafka_span_sample_rate_percent"`
-	KafkaSpanSampleTag                        string    `yaml:"kafka_span_sample_tag"`
-	KafkaSpanSerializationFormat              string    `yaml:"kafka_span_serialization_format"`
-	KafkaSpanTopic                            string    `yaml:"kafka_span_topic"`
-	LightstepAccessToken                      string    `yaml:"lightstep_access_token"`
-	LightstepCollectorHost                    string    `yaml:"lightstep_collector_host"`
-	LightstepMaximumSpans                     int       `yaml:"lightstep_maximum_spans"`
-	LightstepNumClients                       int       `yaml:"lightstep_num_clients"`
-	LightstepReconnectPeriod                  string    `yaml:"lightstep_reconnect_period"`
-	MetricMaxLength                           int       `yaml:"metric_max_length"`
-	MutexProfileFraction                      int       `yaml:"mutex_profile_fraction"`
-	NewrelicAccountID                         int       `yaml:"newrelic_account_id"`
-	NewrelicCommonTags                        []string  `yaml:"newrelic_common_tags"`
-	NewrelicEventType                         string    `yaml:"newrelic_event_type"`
-	NewrelicInsertKey                         string    `yaml:"newrelic_insert_key"`
-	NewrelicRegion                            string    `yaml:"newrelic_region"`
-	NewrelicServiceCheckEventType             string    `yaml:"newrelic_service_check_event_type"`
-	NewrelicTraceObserverURL                  string    `yaml:"newrelic_trace_observer_url"`
-	NumReaders                                int       `yaml:"num_readers"`
-	NumSpanWorkers                            int       `yaml:"num_span_workers"`
-	NumWorkers                                int       `yaml:"num_workers"`
-	ObjectiveSpanTimerName                    string    `yaml:"objective_span_timer_name"`
-	OmitEmptyHostname                         bool      `yaml:"omit_empty_hostname"`
-	Percentiles                               []float64 `yaml:"percentiles"`
-	PrometheusNetworkType                     string    `yaml:"prometheus_network_type"`
-	PrometheusRepeaterAddress                 string    `yaml:"prometheus_repeater_address"`
-	ReadBufferSizeBytes                       int       `yaml:"read_buffer_size_bytes"`
-	SentryDsn                                 string    `yaml:"sentry_dsn"`
-	SignalfxAPIKey                            string    `yaml:"signalfx_api_key"`
-	SignalfxDynamicPerTagAPIKeysEnable        bool      `yaml:"signalfx_dynamic_per_tag_api_keys_enable"`
-	SignalfxDynamicPerTagAPIKeysRefreshPeriod string    `yaml:"signalfx_dynamic_per_tag_api_keys_refresh_period"`
-	SignalfxEndpointAPI                       string    `yaml:"signalfx_endpoint_api"`
-	SignalfxEndpointBase                      string    `yaml:"signalfx_endpoint_base"`
-	SignalfxFlushMaxPerBody                   int       `yaml:"signalfx_flush_max_per_body"`
-	SignalfxHostnameTag                       string    `yaml:"signalfx_hostname_tag"`
-	SignalfxMetricNamePrefixDrops             []string  `yaml:"signalfx_metric_name_prefix_drops"`
-	SignalfxMetricTagPrefixDrops              []string  `yaml:"signalfx_metric_tag_prefix_drops"`
+	DatadogFlushMaxPerBody       int      `yaml:"datadog_flush_max_per_body"`
+	DatadogMetricNamePrefixDrops []string `yaml:"datadog_metric_name_prefix_drops"`
+	DatadogMetricNameRenames     []struct {
+		Pattern     string `yaml:"pattern"`
+		Replacement string `yaml:"replacement"`
+	} `yaml:"datadog_metric_name_renames"`
+	DatadogMetricNameRenamesDryRun bool   `yaml:"datadog_metric_name_renames_dry_run"`
+	DatadogSpanBufferSize          int    `yaml:"datadog_span_buffer_size"`
+	DatadogTraceAPIAddress         string `yaml:"datadog_trace_api_address"`
+	DeadLetterDirectory            string `yaml:"dead_letter_directory"`
+	Debug                          bool   `yaml:"debug"`
+	DebugFlushedMetrics            bool   `yaml:"debug_flushed_metrics"`
+	DebugIngestedSpans             bool   `yaml:"debug_ingested_spans"`
+	DistributionSinkOverrides      []struct {
+		Sink string `yaml:"sink"`
+		Mode string `yaml:"mode"`
+	} `yaml:"distribution_sink_overrides"`
+	DistributionsEnabled       bool     `yaml:"distributions_enabled"`
+	DogstatsdContainerIDTag    bool     `yaml:"dogstatsd_container_id_tag"`
+	DogstatsdMaxBackfillAge    string   `yaml:"dogstatsd_max_backfill_age"`
+	EnableProfiling            bool     `yaml:"enable_profiling"`
+	EnableSoftnetStats         bool     `yaml:"enable_softnet_stats"`
+	EnableUDPSocketStats       bool     `yaml:"enable_udp_socket_stats"`
+	FalconerAddress            string   `yaml:"falconer_address"`
+	FastFlushInterval          string   `yaml:"fast_flush_interval"`
+	FastFlushMetrics           []string `yaml:"fast_flush_metrics"`
+	FlushFile                  string   `yaml:"flush_file"`
+	FlushJitter                string   `yaml:"flush_jitter"`
+	FlushJitterDeterministic   bool     `yaml:"flush_jitter_deterministic"`
+	FlushMaxPerBody            int      `yaml:"flush_max_per_body"`
+	FlushWatchdogMissedFlushes int      `yaml:"flush_watchdog_missed_flushes"`
+	ForwardAddress             string   `yaml:"forward_address"`
+	ForwardCompression         string   `yaml:"forward_compression"`
+	ForwardGrpcAddress         string   `yaml:"forward_grpc_address"`
+	ForwardMetricsAllow        []string `yaml:"forward_metrics_allow"`
+	ForwardMetricsDeny         []string `yaml:"forward_metrics_deny"`
+	ForwardUseGrpc             bool     `yaml:"forward_use_grpc"`
+	GaugeTTLIntervals          int      `yaml:"gauge_ttl_intervals"`
+	GlobalTags                 []string `yaml:"global_tags"`
+	GrpcAddress                string   `yaml:"grpc_address"`
+	GrpcListenAddresses        []string `yaml:"grpc_listen_addresses"`
+	HistogramAggregatesOnly    []string `yaml:"histogram_aggregates_only"`
+	HistogramReservoirs        []struct {
+		Metric string `yaml:"metric"`
+		Size   int    `yaml:"size"`
+	} `yaml:"histogram_reservoirs"`
+	Hostname                     string   `yaml:"hostname"`
+	HTTPAddress                  string   `yaml:"http_address"`
+	HTTPIngestAuthToken          string   `yaml:"http_ingest_auth_token"`
+	HTTPIngestBurst              int      `yaml:"http_ingest_burst"`
+	HTTPIngestEnabled            bool     `yaml:"http_ingest_enabled"`
+	HTTPIngestMaxBodyBytes       int      `yaml:"http_ingest_max_body_bytes"`
+	HTTPIngestRequestsPerSecond  int      `yaml:"http_ingest_requests_per_second"`
+	HTTPQuit                     bool     `yaml:"http_quit"`
+	IndicatorSpanTimerName       string   `yaml:"indicator_span_timer_name"`
+	IngestQueueSize              int      `yaml:"ingest_queue_size"`
+	Interval                     string   `yaml:"interval"`
+	JaegerBatchSize              int      `yaml:"jaeger_batch_size"`
+	JaegerCollectorAddress       string   `yaml:"jaeger_collector_address"`
+	JaegerSpanBufferSize         int      `yaml:"jaeger_span_buffer_size"`
+	JSONListenAddresses          []string `yaml:"json_listen_addresses"`
+	KafkaBroker                  string   `yaml:"kafka_broker"`
+	KafkaCheckTopic              string   `yaml:"kafka_check_topic"`
+	KafkaEventTopic              string   `yaml:"kafka_event_topic"`
+	KafkaMetricBufferBytes       int      `yaml:"kafka_metric_buffer_bytes"`
+	KafkaMetricBufferFrequency   string   `yaml:"kafka_metric_buffer_frequency"`
+	KafkaMetricBufferMessages    int      `yaml:"kafka_metric_buffer_messages"`
+	KafkaMetricConfirmDelivery   bool     `yaml:"kafka_metric_confirm_delivery"`
+	KafkaMetricDeliveryRetries   int      `yaml:"kafka_metric_delivery_retries"`
+	KafkaMetricIdempotent        bool     `yaml:"kafka_metric_idempotent"`
+	KafkaMetricPartitionFields   []string `yaml:"kafka_metric_partition_fields"`
+	KafkaMetricRequireAcks       string   `yaml:"kafka_metric_require_acks"`
+	KafkaMetricTopic             string   `yaml:"kafka_metric_topic"`
+	KafkaPartitioner             string   `yaml:"kafka_partitioner"`
+	KafkaRetryMax                int      `yaml:"kafka_retry_max"`
+	KafkaSaslMechanism           string   `yaml:"kafka_sasl_mechanism"`
+	KafkaSaslPassword            string   `yaml:"kafka_sasl_password"`
+	KafkaSaslUser                string   `yaml:"kafka_sasl_user"`
+	KafkaSpanBufferBytes         int      `yaml:"kafka_span_buffer_bytes"`
+	KafkaSpanBufferFrequency     string   `yaml:"kafka_span_buffer_frequency"`
+	KafkaSpanBufferMesages       int      `yaml:"kafka_span_buffer_mesages"`
+	KafkaSpanPartitioner         string   `yaml:"kafka_span_partitioner"`
+	KafkaSpanRequireAcks         string   `yaml:"kafka_span_require_acks"`
+	KafkaSpanSampleRatePercent   float64  `yaml:"kafka_span_sample_rate_percent"`
+	KafkaSpanSampleTag           string   `yaml:"kafka_span_sample_tag"`
+	KafkaSpanSerializationFormat string   `yaml:"kafka_span_serialization_format"`
+	KafkaSpanTopic               string   `yaml:"kafka_span_topic"`
+	KafkaTLSAuthorityCertificate string   `yaml:"kafka_tls_authority_certificate"`
+	KafkaTLSCertificate          string   `yaml:"kafka_tls_certificate"`
+	KafkaTLSEnabled              bool     `yaml:"kafka_tls_enabled"`
+	KafkaTLSKey                  string   `yaml:"kafka_tls_key"`
+	LightstepAccessToken         string   `yaml:"lightstep_access_token"`
+	LightstepCollectorHost       string   `yaml:"lightstep_collector_host"`
+	LightstepMaximumSpans        int      `yaml:"lightstep_maximum_spans"`
+	LightstepNumClients          int      `yaml:"lightstep_num_clients"`
+	LightstepReconnectPeriod     string   `yaml:"lightstep_reconnect_period"`
+	LogFormat                    string   `yaml:"log_format"`
+	MetricCardinalityLimit       int      `yaml:"metric_cardinality_limit"`
+	MetricFlushIntervals         []struct {
+		Metric   string `yaml:"metric"`
+		Interval string `yaml:"interval"`
+	} `yaml:"metric_flush_intervals"`
+	MetricMaxLength                 int    `yaml:"metric_max_length"`
+	MetricNameAllowedCharacters     string `yaml:"metric_name_allowed_characters"`
+	MetricNameInvalidAction         string `yaml:"metric_name_invalid_action"`
+	MetricNameMaxLength             int    `yaml:"metric_name_max_length"`
+	MetricSinkFlushTimeout          string `yaml:"metric_sink_flush_timeout"`
+	MetricSinkFlushTimeoutOverrides []struct {
+		Sink    string `yaml:"sink"`
+		Timeout string `yaml:"timeout"`
+	} `yaml:"metric_sink_flush_timeout_overrides"`
+	MetricSinkTagsAllow  []string `yaml:"metric_sink_tags_allow"`
+	MetricSinkTagsDeny   []string `yaml:"metric_sink_tags_deny"`
+	MetricSinksDisabled  []string `yaml:"metric_sinks_disabled"`
+	MetricSinksShadow    []string `yaml:"metric_sinks_shadow"`
+	MetricTypeCollisions string   `yaml:"metric_type_collisions"`
+	MetricUnitTag        bool     `yaml:"metric_unit_tag"`
+	MetricValueBounds    []struct {
+		Metric string   `yaml:"metric"`
+		Min    *float64 `yaml:"min"`
+		Max    *float64 `yaml:"max"`
+		Action string   `yaml:"action"`
+	} `yaml:"metric_value_bounds"`
+	MutexProfileFraction          int      `yaml:"mutex_profile_fraction"`
+	NewrelicAccountID             int      `yaml:"newrelic_account_id"`
+	NewrelicCommonTags            []string `yaml:"newrelic_common_tags"`
+	NewrelicEventType             string   `yaml:"newrelic_event_type"`
+	NewrelicInsertKey             string   `yaml:"newrelic_insert_key"`
+	NewrelicRegion                string   `yaml:"newrelic_region"`
+	NewrelicServiceCheckEventType string   `yaml:"newrelic_service_check_event_type"`
+	NewrelicTraceObserverURL      string   `yaml:"newrelic_trace_observer_url"`
+	NonNegativeMetrics            []struct {
+		Metric string `yaml:"metric"`
+		Action string `yaml:"action"`
+	} `yaml:"non_negative_metrics"`
+	NumReaders                  Readers  `yaml:"num_readers"`
+	NumReadersAdaptiveInterval  string   `yaml:"num_readers_adaptive_interval"`
+	NumReadersAdaptiveMax       int      `yaml:"num_readers_adaptive_max"`
+	NumReadersAdaptiveMin       int      `yaml:"num_readers_adaptive_min"`
+	NumSpanWorkers              int      `yaml:"num_span_workers"`
+	NumWorkers                  int      `yaml:"num_workers"`
+	ObjectiveSpanTimerName      string   `yaml:"objective_span_timer_name"`
+	OmitEmptyHostname           bool     `yaml:"omit_empty_hostname"`
+	OtlpAddress                 string   `yaml:"otlp_address"`
+	OtlpHeaders                 []string `yaml:"otlp_headers"`
+	OtlpInsecure                bool     `yaml:"otlp_insecure"`
+	OtlpTLSAuthorityCertificate string   `yaml:"otlp_tls_authority_certificate"`
+	ParseErrorLogsPerSecond     int      `yaml:"parse_error_logs_per_second"`
+	PercentileOverrides         []struct {
+		Metric      string    `yaml:"metric"`
+		Percentiles []float64 `yaml:"percentiles"`
+	} `yaml:"percentile_overrides"`
+	Percentiles                    []float64 `yaml:"percentiles"`
+	PrometheusNetworkType          string    `yaml:"prometheus_network_type"`
+	PrometheusRemoteWriteAddress   string    `yaml:"prometheus_remote_write_address"`
+	PrometheusRemoteWriteBatchSize int       `yaml:"prometheus_remote_write_batch_size"`
+	PrometheusRepeaterAddress      string    `yaml:"prometheus_repeater_address"`
+	ReadBufferSizeAutosize         bool      `yaml:"read_buffer_size_autosize"`
+	ReadBufferSizeBytes            int       `yaml:"read_buffer_size_bytes"`
+	SelfTestMetricEnabled          bool      `yaml:"self_test_metric_enabled"`
+	SelfTestMetricName             string    `yaml:"self_test_metric_name"`
+	SentryDsn                      string    `yaml:"sentry_dsn"`
+	SetMaxCardinality              int       `yaml:"set_max_cardinality"`
+	SetMaxCardinalityOverrides     []struct {
+		MaxCardinality int    `yaml:"max_cardinality"`
+		Metric         string `yaml:"metric"`
+	} `yaml:"set_max_cardinality_overrides"`
+	SignalfxAPIKey                            string   `yaml:"signalfx_api_key"`
+	SignalfxDimensionAllowlist                []string `yaml:"signalfx_dimension_allowlist"`
+	SignalfxDynamicPerTagAPIKeysEnable        bool     `yaml:"signalfx_dynamic_per_tag_api_keys_enable"`
+	SignalfxDynamicPerTagAPIKeysRefreshPeriod string   `yaml:"signalfx_dynamic_per_tag_api_keys_refresh_period"`
+	SignalfxEndpointAPI                       string   `yaml:"signalfx_endpoint_api"`
+	SignalfxEndpointBase                      string   `yaml:"signalfx_endpoint_base"`
+	SignalfxFlushMaxPerBody                   int      `yaml:"signalfx_flush_max_per_body"`
+	SignalfxHostnameTag                       string   `yaml:"signalfx_hostname_tag"`
+	SignalfxMetricNamePrefixDrops             []string `yaml:"signalfx_metric_name_prefix_drops"`
+	SignalfxMetricTagPrefixDrops              []string `yaml:"signalfx_metric_tag_prefix_drops"`
 	SignalfxPerTagAPIKeys                     []struct {
 		APIKey string `yaml:"api_key"`
 		Name   string `yaml:"name"`
 	} `yaml:"signalfx_per_tag_api_keys"`
-	SignalfxVaryKeyBy                 string   `yaml:"signalfx_vary_key_by"`
-	SpanChannelCapacity               int      `yaml:"span_channel_capacity"`
+	SignalfxVaryKeyBy              string   `yaml:"signalfx_vary_key_by"`
+	SpanBatchBufferSize            int      `yaml:"span_batch_buffer_size"`
+	SpanBatchMaxAge                string   `yaml:"span_batch_max_age"`
+	SpanBatchMaxSpans              int      `yaml:"span_batch_max_spans"`
+	SpanChannelCapacity            int      `yaml:"span_channel_capacity"`
+	SpanCountCounterName           string   `yaml:"span_count_counter_name"`
+	SpanDurationTimerName          string   `yaml:"span_duration_timer_name"`
+	SpanErrorCounterName           string   `yaml:"span_error_counter_name"`
+	SpanMetricsMaxOperations       int      `yaml:"span_metrics_max_operations"`
+	SpanMetricsMaxTagValues        int      `yaml:"span_metrics_max_tag_values"`
+	SpanMetricsTags                []string `yaml:"span_metrics_tags"`
+	SpanSamplingDefaultRatePercent float64  `yaml:"span_sampling_default_rate_percent"`
+	SpanSamplingRules              []struct {
+		Operation   string  `yaml:"operation"`
+		RatePercent float64 `yaml:"rate_percent"`
+	} `yaml:"span_sampling_rules"`
 	SplunkHecAddress                  string   `yaml:"splunk_hec_address"`
 	SplunkHecBatchSize                int      `yaml:"splunk_hec_batch_size"`
 	SplunkHecConnectionLifetimeJitter string   `yaml:"splunk_hec_connection_lifetime_jitter"`
@@ -103,22 +231,50 @@ type Config struct {
 	SplunkHecToken                    string   `yaml:"splunk_hec_token"`
 	SplunkSpanSampleRate              int      `yaml:"splunk_span_sample_rate"`
 	SsfBufferSize                     int      `yaml:"ssf_buffer_size"`
+	SsfInvalidSpanLogsPerSecond       int      `yaml:"ssf_invalid_span_logs_per_second"`
 	SsfListenAddresses                []string `yaml:"ssf_listen_addresses"`
+	SsfMaxFrameLengthBytes            uint32   `yaml:"ssf_max_frame_length_bytes"`
+	SsfTailSamplingDecisionWait       string   `yaml:"ssf_tail_sampling_decision_wait"`
+	SsfTailSamplingLatencyThreshold   string   `yaml:"ssf_tail_sampling_latency_threshold"`
+	SsfTailSamplingMaxSpans           int      `yaml:"ssf_tail_sampling_max_spans"`
+	SsfTailSamplingRatePercent        float64  `yaml:"ssf_tail_sampling_rate_percent"`
+	SsfTraceSampleRatePercent         float64  `yaml:"ssf_trace_sample_rate_percent"`
+	SsfValidateSpans                  bool     `yaml:"ssf_validate_spans"`
 	StatsAddress                      string   `yaml:"stats_address"`
 	StatsdListenAddresses             []string `yaml:"statsd_listen_addresses"`
+	SynchronizeHoldPartialWindow      bool     `yaml:"synchronize_hold_partial_window"`
 	SynchronizeWithInterval           bool     `yaml:"synchronize_with_interval"`
 	Tags                              []string `yaml:"tags"`
 	TagsExclude                       []string `yaml:"tags_exclude"`
+	TCPDrainTimeout                   string   `yaml:"tcp_drain_timeout"`
+	TCPMaxConnections                 int      `yaml:"tcp_max_connections"`
+	TCPMaxConnectionsAction           string   `yaml:"tcp_max_connections_action"`
+	TCPProxyProtocol                  bool     `yaml:"tcp_proxy_protocol"`
+	TCPReadTimeout                    string   `yaml:"tcp_read_timeout"`
 	TLSAuthorityCertificate           string   `yaml:"tls_authority_certificate"`
 	TLSCertificate                    string   `yaml:"tls_certificate"`
+	TLSCertificateFile                string   `yaml:"tls_certificate_file"`
+	TLSCipherSuites                   []string `yaml:"tls_cipher_suites"`
+	TLSClientTagKey                   string   `yaml:"tls_client_tag_key"`
+	TLSClientTagSource                string   `yaml:"tls_client_tag_source"`
 	TLSKey                            string   `yaml:"tls_key"`
+	TLSKeyFile                        string   `yaml:"tls_key_file"`
+	TLSMinVersion                     string   `yaml:"tls_min_version"`
+	TLSOCSPResponseFile               string   `yaml:"tls_ocsp_response_file"`
+	TLSOCSPStapling                   bool     `yaml:"tls_ocsp_stapling"`
+	TLSReloadInterval                 string   `yaml:"tls_reload_interval"`
 	TraceLightstepAccessToken         string   `yaml:"trace_lightstep_access_token"`
 	TraceLightstepCollectorHost       string   `yaml:"trace_lightstep_collector_host"`
 	TraceLightstepMaximumSpans        int      `yaml:"trace_lightstep_maximum_spans"`
 	TraceLightstepNumClients          int      `yaml:"trace_lightstep_num_clients"`
 	TraceLightstepReconnectPeriod     string   `yaml:"trace_lightstep_reconnect_period"`
 	TraceMaxLengthBytes               int      `yaml:"trace_max_length_bytes"`
+	UDPCaptureFile                    string   `yaml:"udp_capture_file"`
+	UDPCaptureMaxBytes                int64    `yaml:"udp_capture_max_bytes"`
+	UDPRateLimitBurst                 int      `yaml:"udp_rate_limit_burst"`
+	UDPRateLimitPacketsPerSecond      int      `yaml:"udp_rate_limit_packets_per_second"`
 	VeneurMetricsAdditionalTags       []string `yaml:"veneur_metrics_additional_tags"`
+	VeneurMetricsDisabled             []string `yaml:"veneur_metrics_disabled"`
 	VeneurMetricsScopes               struct {
 		Counter   string `yaml:"counter"`
 		Gauge     string `yaml:"gauge"`
@@ -126,7 +282,12 @@ type Config struct {
 		Set       string `yaml:"set"`
 		Status    string `yaml:"status"`
 	} `yaml:"veneur_metrics_scopes"`
+	WavefrontAddress     string   `yaml:"wavefront_address"`
+	WorkerSharding       string   `yaml:"worker_sharding"`
 	XrayAddress          string   `yaml:"xray_address"`
 	XrayAnnotationTags   []string `yaml:"xray_annotation_tags"`
 	XraySamplePercentage float64  `yaml:"xray_sample_percentage"`
+	ZipkinAddress        string   `yaml:"zipkin_address"`
+	ZipkinBatchSize      int      `yaml:"zipkin_batch_size"`
+	ZipkinSpanBufferSize int      `yaml:"zipkin_span_buffer_size"`
 }
@@ -1,12 +1,18 @@
 package veneur
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
+	"github.com/sirupsen/logrus"
 
 	"gopkg.in/yaml.v2"
 )
@@ -81,10 +87,67 @@ func readProxyConfig(r io.Reader) (ProxyConfig, error) {
 	if err != nil {
 		return c, err
 	}
+	err = processKeyEnv("veneur_proxy", &c)
+	if err != nil {
+		return c, err
+	}
 
 	return c, unmarshalErr
 }
 
+// Readers is the number of readers to start on each listening address.
+// It can be configured as a number, or as "auto".
+type Readers int
+
+// AutoReaders is the value of NumReaders when it is configured as
+// "auto", which makes veneur use one reader per GOMAXPROCS.
+const AutoReaders Readers = -1
+
+// maxAutoReaders caps the number of readers that "auto" starts on hosts
+// with a lot of cores, where more sockets stop helping.
+const maxAutoReaders = 32
+
+// UnmarshalYAML accepts a number, or "auto" for AutoReaders.
+func (r *Readers) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var n int
+	if err := unmarshal(&n); err == nil {
+		*r = Readers(n)
+		return nil
+	}
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return r.Decode(s)
+}
+
+// Decode parses Readers from an environment variable.
+func (r *Readers) Decode(value string) error {
+	if value == "auto" {
+		*r = AutoReaders
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("the number of readers must be a number or \"auto\", not %q", value)
+	}
+	*r = Readers(n)
+	return nil
+}
+
+// Count returns the number of readers to start: r itself, or for
+// AutoReaders, GOMAXPROCS capped at maxAutoReaders.
+func (r Readers) Count() int {
+	if r != AutoReaders {
+		return int(r)
+	}
+	n := runtime.GOMAXPROCS(0)
+	if n > maxAutoReaders {
+		n = maxAutoReaders
+	}
+	return n
+}
+
 // UnknownConfigKeys represents a failure to strictly parse a
 // configuration YAML file has failed, indicating that the file
 // contains unknown keys.
@@ -145,11 +208,129 @@ func readConfig(r io.Reader) (Config, error) {
 	if err != nil {
 		return c, err
 	}
+	err = processKeyEnv("veneur", &c)
+	if err != nil {
+		return c, err
+	}
 
 	// pass back an error about any unknown fields:
 	return c, unmarshalErr
 }
 
+// processKeyEnv overrides the options of spec, a pointer to a config
+// struct, with the environment variables named after their YAML keys,
+// upper-cased and prefixed, like VENEUR_DATADOG_API_HOSTNAME for
+// datadog_api_hostname. They take precedence over the variables that
+// envconfig reads, which are named after the fields. Strings are taken
+// as they are, lists of scalars are separated by commas, and other
+// values are parsed as YAML.
+func processKeyEnv(prefix string, spec interface{}) error {
+	v := reflect.ValueOf(spec).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := yamlKey(t.Field(i))
+		if key == "" {
+			continue
+		}
+		name := strings.ToUpper(prefix + "_" + key)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setFromEnv(v.Field(i), value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// yamlKey returns the YAML key of a config struct's field, or "" if it
+// has none.
+func yamlKey(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+func setFromEnv(field reflect.Value, value string) error {
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+		return nil
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() != reflect.Struct:
+		if value == "" {
+			field.Set(reflect.Zero(field.Type()))
+			return nil
+		}
+		parts := strings.Split(value, ",")
+		list := reflect.MakeSlice(field.Type(), len(parts), len(parts))
+		for i, part := range parts {
+			if err := setFromEnv(list.Index(i), strings.TrimSpace(part)); err != nil {
+				return err
+			}
+		}
+		field.Set(list)
+		return nil
+	}
+	parsed := reflect.New(field.Type())
+	if err := yaml.UnmarshalStrict([]byte(value), parsed.Interface()); err != nil {
+		return err
+	}
+	field.Set(parsed.Elem())
+	return nil
+}
+
+// redactedConfigKeys are the options that hold credentials, whose values
+// are never logged.
+var redactedConfigKeys = map[string]bool{
+	"aws_secret_access_key":        true,
+	"datadog_api_key":              true,
+	"http_ingest_auth_token":       true,
+	"kafka_sasl_password":          true,
+	"kafka_tls_key":                true,
+	"lightstep_access_token":       true,
+	"newrelic_insert_key":          true,
+	"otlp_headers":                 true,
+	"sentry_dsn":                   true,
+	"signalfx_api_key":             true,
+	"signalfx_per_tag_api_keys":    true,
+	"splunk_hec_token":             true,
+	"tls_key":                      true,
+	"trace_lightstep_access_token": true,
+}
+
+// LogFields returns the options that are set, by their YAML key, to log
+// the effective configuration. Credentials are redacted.
+func (c Config) LogFields() logrus.Fields {
+	return configLogFields(c)
+}
+
+// LogFields returns the options that are set, by their YAML key, to log
+// the effective configuration. Credentials are redacted.
+func (c ProxyConfig) LogFields() logrus.Fields {
+	return configLogFields(c)
+}
+
+func configLogFields(spec interface{}) logrus.Fields {
+	fields := logrus.Fields{}
+	v := reflect.ValueOf(spec)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := yamlKey(t.Field(i))
+		if key == "" || v.Field(i).IsZero() {
+			continue
+		}
+		if redactedConfigKeys[key] {
+			fields[key] = "REDACTED"
+			continue
+		}
+		fields[key] = v.Field(i).Interface()
+	}
+	return fields
+}
+
 func (c *Config) applyDefaults() {
 	if len(c.Aggregates) == 0 {
 		c.Aggregates = defaultConfig.Aggregates
@@ -176,3 +176,76 @@ trace_lightstep_num_clients: 2
 	assert.Equal(t, 1, c.LightstepMaximumSpans)
 	assert.Equal(t, 2, c.LightstepNumClients)
 }
+
+func TestReadConfigNumReaders(t *testing.T) {
+	c, err := readConfig(strings.NewReader("num_readers: 4\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, Readers(4), c.NumReaders)
+	assert.Equal(t, 4, c.NumReaders.Count())
+
+	c, err = readConfig(strings.NewReader("num_readers: auto\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, AutoReaders, c.NumReaders)
+	assert.True(t, c.NumReaders.Count() >= 1 && c.NumReaders.Count() <= maxAutoReaders)
+
+	_, err = readConfig(strings.NewReader("num_readers: lots\n"))
+	assert.Error(t, err)
+
+	defer os.Unsetenv("VENEUR_NUMREADERS")
+	os.Setenv("VENEUR_NUMREADERS", "auto")
+	c, err = readConfig(strings.NewReader("num_readers: 2\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, AutoReaders, c.NumReaders)
+}
+
+func TestReadConfigKeyEnv(t *testing.T) {
+	env := map[string]string{
+		"VENEUR_INTERVAL":                   "30s",
+		"VENEUR_DATADOG_API_HOSTNAME":       "https://app.datadoghq.com",
+		"VENEUR_NUM_READERS":                "auto",
+		"VENEUR_TAGS_EXCLUDE":               "host, user:*",
+		"VENEUR_PERCENTILES":                "0.5,0.99",
+		"VENEUR_ENABLE_SOFTNET_STATS":       "true",
+		"VENEUR_PERCENTILE_OVERRIDES":       "[{metric: api.*, percentiles: [0.9]}]",
+		"VENEUR_SSF_MAX_FRAME_LENGTH_BYTES": "1024",
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+	// the environment variables named after the keys win over those
+	// named after the fields, and over the file
+	os.Setenv("VENEUR_DATADOGAPIHOSTNAME", "https://example.com")
+	defer os.Unsetenv("VENEUR_DATADOGAPIHOSTNAME")
+
+	c, err := readConfig(strings.NewReader("interval: 10s\nenable_softnet_stats: false\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "30s", c.Interval)
+	assert.Equal(t, "https://app.datadoghq.com", c.DatadogAPIHostname)
+	assert.Equal(t, AutoReaders, c.NumReaders)
+	assert.Equal(t, []string{"host", "user:*"}, c.TagsExclude)
+	assert.Equal(t, []float64{0.5, 0.99}, c.Percentiles)
+	assert.True(t, c.EnableSoftnetStats)
+	if assert.Len(t, c.PercentileOverrides, 1) {
+		assert.Equal(t, "api.*", c.PercentileOverrides[0].Metric)
+		assert.Equal(t, []float64{0.9}, c.PercentileOverrides[0].Percentiles)
+	}
+	assert.Equal(t, uint32(1024), c.SsfMaxFrameLengthBytes)
+
+	os.Setenv("VENEUR_ENABLE_SOFTNET_STATS", "maybe")
+	_, err = readConfig(strings.NewReader(""))
+	assert.Error(t, err)
+}
+
+func TestConfigLogFields(t *testing.T) {
+	c := Config{
+		Interval:      "10s",
+		DatadogAPIKey: "hunter2",
+		Percentiles:   []float64{0.5},
+	}
+	fields := c.LogFields()
+	assert.Equal(t, "10s", fields["interval"])
+	assert.Equal(t, "REDACTED", fields["datadog_api_key"])
+	assert.Equal(t, []float64{0.5}, fields["percentiles"])
+	assert.NotContains(t, fields, "debug", "options that aren't set aren't logged")
+}
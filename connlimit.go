@@ -0,0 +1,51 @@
+package veneur
+
+// What a TCP listener does with new connections when it already has as
+// many open as tcp_max_connections allows.
+const (
+	// tcpLimitReject accepts the connection, and closes it right away.
+	tcpLimitReject = "reject"
+	// tcpLimitWait stops accepting connections until one closes, so that
+	// the new ones wait in the kernel's backlog.
+	tcpLimitWait = "wait"
+)
+
+// connLimiter caps the connections that a TCP listener has open at once.
+// It's shared by the listeners of an address, if SO_REUSEPORT gives it
+// several.
+type connLimiter struct {
+	slots chan struct{}
+	wait  bool
+}
+
+func newConnLimiter(max int, action string) *connLimiter {
+	return &connLimiter{
+		slots: make(chan struct{}, max),
+		wait:  action == tcpLimitWait,
+	}
+}
+
+// acquire takes a slot for a new connection, and returns false if there's
+// none free. If the limiter waits for slots, it blocks until one is free
+// or done is closed instead.
+func (l *connLimiter) acquire(done <-chan struct{}) bool {
+	if l.wait {
+		select {
+		case l.slots <- struct{}{}:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// release frees the slot of a connection that closed.
+func (l *connLimiter) release() {
+	<-l.slots
+}
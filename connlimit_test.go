@@ -0,0 +1,80 @@
+package veneur
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLimiter(t *testing.T) {
+	done := make(chan struct{})
+
+	reject := newConnLimiter(1, tcpLimitReject)
+	assert.True(t, reject.acquire(done))
+	assert.False(t, reject.acquire(done), "there should be no free slot")
+	reject.release()
+	assert.True(t, reject.acquire(done))
+
+	wait := newConnLimiter(1, tcpLimitWait)
+	assert.True(t, wait.acquire(done))
+	acquired := make(chan bool)
+	go func() {
+		acquired <- wait.acquire(done)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquire should wait for a free slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	wait.release()
+	assert.True(t, <-acquired)
+
+	go func() {
+		acquired <- wait.acquire(done)
+	}()
+	close(done)
+	assert.False(t, <-acquired, "acquire should give up on shutdown")
+}
+
+func TestTCPMaxConnectionsReject(t *testing.T) {
+	config := localConfig()
+	config.NumWorkers = 1
+	config.Interval = "60s"
+	config.StatsdListenAddresses = []string{"tcp://127.0.0.1:0"}
+	config.TCPMaxConnections = 1
+	f := newFixture(t, config, nil, nil)
+	defer f.Close()
+	addr := f.server.StatsdListenAddrs[0].String()
+
+	first := connectToAddress(t, "tcp", addr, 20*time.Millisecond)
+	defer first.Close()
+	_, err := first.Write([]byte("foo.bar:1|c\n"))
+	require.NoError(t, err)
+
+	// wait for the first connection to be accepted, so that it holds
+	// the only slot
+	time.Sleep(50 * time.Millisecond)
+
+	second, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = ioutil.ReadAll(second)
+	assert.NoError(t, err, "the second connection should be closed")
+
+	// the first connection's slot frees up once it closes
+	first.Close()
+	time.Sleep(50 * time.Millisecond)
+	third, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer third.Close()
+	third.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = third.Read(make([]byte, 1))
+	if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Errorf("the third connection should be kept open, but reading from it returned %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package veneur
+
+import (
+	"time"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+)
+
+// The ways a metric sink can receive counters.
+const (
+	counterModeCount = "count"
+	counterModeRate  = "rate"
+)
+
+// counterMode returns how the sink receives counters. Unless its mode is
+// overridden, a sink receives them as rates if it converts them itself,
+// and as counts otherwise.
+func (s *Server) counterMode(sink sinks.MetricSink) string {
+	if mode, ok := s.counterModes[sink.Name()]; ok {
+		return mode
+	}
+	if _, ok := sinks.UnwrapMetricSink(sink).(sinks.CounterRateMetricSink); ok {
+		return counterModeRate
+	}
+	return counterModeCount
+}
+
+// convertsCounters returns true if the counters that the sink receives
+// need to be converted into rates before it flushes them.
+func (s *Server) convertsCounters(sink sinks.MetricSink) bool {
+	_, converts := sinks.UnwrapMetricSink(sink).(sinks.CounterRateMetricSink)
+	return !converts && s.counterMode(sink) == counterModeRate
+}
+
+// countersAsRates returns a copy of the metrics in which the counters,
+// which are counts over the interval, are per-second rates instead.
+func countersAsRates(metrics []samplers.InterMetric, interval time.Duration) []samplers.InterMetric {
+	rates := make([]samplers.InterMetric, len(metrics))
+	copy(rates, metrics)
+	for i := range rates {
+		if rates[i].Type == samplers.CounterMetric {
+			rates[i].Value /= interval.Seconds()
+		}
+	}
+	return rates
+}
@@ -0,0 +1,172 @@
+package veneur
+
+import (
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks/datadog"
+)
+
+// rateMetricSink is a channel sink that converts counters into rates
+// itself.
+type rateMetricSink struct {
+	*channelMetricSink
+	countersAsCounts bool
+}
+
+func (r *rateMetricSink) Name() string {
+	return "rates"
+}
+
+func (r *rateMetricSink) SendCountersAsCounts() {
+	r.countersAsCounts = true
+}
+
+func TestCounterMode(t *testing.T) {
+	s := &Server{}
+	channel := &channelMetricSink{}
+	rates := &rateMetricSink{channelMetricSink: channel}
+	assert.Equal(t, counterModeCount, s.counterMode(channel))
+	assert.Equal(t, counterModeRate, s.counterMode(rates))
+	assert.False(t, s.convertsCounters(channel))
+	assert.False(t, s.convertsCounters(rates))
+
+	s.counterModes = map[string]string{"channel": counterModeRate, "rates": counterModeCount}
+	assert.Equal(t, counterModeRate, s.counterMode(channel))
+	assert.Equal(t, counterModeCount, s.counterMode(rates))
+	assert.True(t, s.convertsCounters(channel))
+	assert.False(t, s.convertsCounters(rates), "the sink should send counts itself")
+}
+
+func TestFlushCountersAsRates(t *testing.T) {
+	const count = 60.0
+	tests := []struct {
+		name     string
+		interval string
+		modes    map[string]string
+		value    float64
+	}{
+		{"default_10s", "10s", nil, count},
+		{"rate_10s", "10s", map[string]string{"channel": counterModeRate}, count / 10},
+		{"rate_20s", "20s", map[string]string{"channel": counterModeRate}, count / 20},
+		{"count_20s", "20s", map[string]string{"channel": counterModeCount}, count},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rcv := make(chan []samplers.InterMetric, 10)
+			sink, err := NewChannelMetricSink(rcv)
+			require.NoError(t, err)
+
+			config := globalConfig()
+			config.Interval = test.interval
+			global := setupVeneurServer(t, config, nil, sink, nil, nil)
+			defer global.Shutdown()
+			global.counterModes = test.modes
+
+			global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+				MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: counterTypeName},
+				Value:      count,
+				SampleRate: 1.0,
+				Scope:      samplers.MixedScope,
+			})
+			global.Flush(context.Background())
+
+			select {
+			case metrics := <-rcv:
+				require.Len(t, metrics, 1)
+				assert.Equal(t, test.value, metrics[0].Value)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the flush")
+			}
+		})
+	}
+}
+
+func TestTagFilteredDatadogCounters(t *testing.T) {
+	const count = 60.0
+	tests := []struct {
+		mode       string
+		metricType string
+		value      float64
+	}{
+		{"", "rate", count / 10},
+		{counterModeRate, "rate", count / 10},
+		{counterModeCount, "count", count},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run("mode_"+test.mode, func(t *testing.T) {
+			series := make(chan []datadog.DDMetric, 10)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body := io.Reader(r.Body)
+				if r.Header.Get("Content-Encoding") == "deflate" {
+					zr, err := zlib.NewReader(r.Body)
+					require.NoError(t, err)
+					body = zr
+				}
+				var req struct {
+					Series []datadog.DDMetric `json:"series"`
+				}
+				require.NoError(t, json.NewDecoder(body).Decode(&req))
+				series <- req.Series
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer srv.Close()
+
+			config := globalConfig()
+			config.Interval = "10s"
+			config.DatadogAPIKey = "apikey"
+			config.DatadogAPIHostname = srv.URL
+			// wraps the Datadog sink in a TagFilterSink
+			config.MetricSinkTagsAllow = []string{"x"}
+			if test.mode != "" {
+				config.CounterSinkOverrides = append(config.CounterSinkOverrides, struct {
+					Sink string `yaml:"sink"`
+					Mode string `yaml:"mode"`
+				}{"datadog", test.mode})
+			}
+			global := setupVeneurServer(t, config, nil, nil, nil, nil)
+			defer global.Shutdown()
+
+			global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+				MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: counterTypeName, JoinedTags: "x:1,y:2"},
+				Tags:       []string{"x:1", "y:2"},
+				Value:      count,
+				SampleRate: 1.0,
+				Scope:      samplers.MixedScope,
+			})
+			global.Flush(context.Background())
+
+			select {
+			case flushed := <-series:
+				require.Len(t, flushed, 1)
+				assert.Equal(t, test.metricType, flushed[0].MetricType)
+				assert.Equal(t, test.value, flushed[0].Value[0][1])
+				assert.NotContains(t, flushed[0].Tags, "y:2", "the tags should be filtered")
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the flush")
+			}
+		})
+	}
+}
+
+func TestCounterSinkOverridesInvalid(t *testing.T) {
+	config := localConfig()
+	config.CounterSinkOverrides = append(config.CounterSinkOverrides, struct {
+		Sink string `yaml:"sink"`
+		Mode string `yaml:"mode"`
+	}{"signalfx", "gauge"})
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+}
@@ -0,0 +1,86 @@
+package veneur
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/sinks/deadletter"
+)
+
+// deadLetterMetrics writes the metrics that a sink failed to flush with
+// err to the dead-letter directory: the ones it reported in a
+// MetricFlushError, or else all those it accepts.
+func (s *Server) deadLetterMetrics(sink sinks.MetricSink, metrics []samplers.InterMetric, err error) {
+	var failed []samplers.InterMetric
+	var flushErr *sinks.MetricFlushError
+	if errors.As(err, &flushErr) {
+		failed = flushErr.Failed
+	} else {
+		for _, m := range metrics {
+			if sinks.IsAcceptableMetric(m, sink) {
+				failed = append(failed, m)
+			}
+		}
+	}
+	if len(failed) == 0 {
+		return
+	}
+
+	tags := []string{"sink:" + sink.Name()}
+	if err := s.deadLetter.Write(deadletter.Batch{Sink: sink.Name(), Metrics: failed}); err != nil {
+		log.WithError(err).WithFields(logrus.Fields{
+			"sink":    sink.Name(),
+			"metrics": len(failed),
+		}).Error("Could not write metrics to the dead-letter directory")
+		s.Statsd.Count("flush.dead_letter_errors_total", 1, tags, 1.0)
+		return
+	}
+	s.Statsd.Count("flush.dead_lettered_metrics_total", int64(len(failed)), tags, 1.0)
+}
+
+// ReplayDeadLetters flushes the batches in the dead-letter directory to
+// the sinks that failed to deliver them. The metrics that fail again are
+// written back to the directory.
+func (s *Server) ReplayDeadLetters(ctx context.Context) (deadletter.ReplayResult, error) {
+	if s.deadLetter == nil {
+		return deadletter.ReplayResult{}, errors.New("no dead_letter_directory is configured")
+	}
+	result, err := s.deadLetter.Replay(func(b deadletter.Batch) error {
+		for _, sink := range s.metricSinks {
+			if sink.Name() == b.Sink {
+				return s.flushMetricSink(ctx, sink, b.Metrics)
+			}
+		}
+		// Keep the metrics around, in case the sink comes back
+		// with the next configuration change.
+		if err := s.deadLetter.Write(b); err != nil {
+			return err
+		}
+		return fmt.Errorf("no metric sink is named %q", b.Sink)
+	})
+	log.WithFields(logrus.Fields{
+		"batches": result.Batches,
+		"metrics": result.Metrics,
+		"failed":  result.Failed,
+	}).Info("Replayed dead-lettered metrics")
+	return result, err
+}
+
+// handleReplayDeadLetters replays the dead-lettered metrics, and responds
+// with the number of batches and metrics that were replayed.
+func (s *Server) handleReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	result, err := s.ReplayDeadLetters(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,108 @@
+package veneur
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks/deadletter"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+)
+
+// flakyMetricSink fails to flush while down is set, and records the
+// metrics it flushes otherwise.
+type flakyMetricSink struct {
+	mtx     sync.Mutex
+	down    bool
+	flushed []samplers.InterMetric
+}
+
+func (f *flakyMetricSink) Name() string {
+	return "flaky"
+}
+
+func (f *flakyMetricSink) Start(*trace.Client) error {
+	return nil
+}
+
+func (f *flakyMetricSink) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.down {
+		return errors.New("the flaky sink is down")
+	}
+	f.flushed = append(f.flushed, metrics...)
+	return nil
+}
+
+func (f *flakyMetricSink) FlushOtherSamples(ctx context.Context, events []ssf.SSFSample) {}
+
+func (f *flakyMetricSink) setDown(down bool) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.down = down
+}
+
+func (f *flakyMetricSink) names() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	var names []string
+	for _, m := range f.flushed {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+func TestDeadLetterReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sink := &flakyMetricSink{down: true}
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	config.DeadLetterDirectory = dir
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+		MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: counterTypeName},
+		Value:      1.0,
+		SampleRate: 1.0,
+		Scope:      samplers.MixedScope,
+	})
+	global.Flush(context.Background())
+	assert.Empty(t, sink.names(), "the sink shouldn't have flushed anything while it was down")
+
+	// Replaying while the sink is still down keeps the metrics.
+	result, err := global.ReplayDeadLetters(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, deadletter.ReplayResult{Batches: 1, Metrics: 1, Failed: 1}, result)
+
+	sink.setDown(false)
+	srv := httptest.NewServer(global.Handler())
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/deadletter/replay", "", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, deadletter.ReplayResult{Batches: 1, Metrics: 1}, result)
+	assert.Equal(t, []string{"a.b.c"}, sink.names())
+
+	result, err = global.ReplayDeadLetters(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, deadletter.ReplayResult{}, result, "replayed metrics shouldn't be replayed again")
+}
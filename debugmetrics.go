@@ -0,0 +1,147 @@
+package veneur
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"goji.io/pat"
+)
+
+// debugSeries is the current aggregate of a series, before it's flushed,
+// as /debug/metrics/:name reports it. Counters and gauges have a Value,
+// sets have an Estimate, and histograms, timers and distributions have
+// a Histogram.
+type debugSeries struct {
+	Type string   `json:"type"`
+	Tags []string `json:"tags"`
+	// Scope is "global" or "local" for the series that are always
+	// forwarded or never forwarded, and empty for the others.
+	Scope string `json:"scope,omitempty"`
+	// Interval is how often the series is flushed, if it's in a flush
+	// group rather than flushed every interval.
+	Interval string `json:"interval,omitempty"`
+
+	Value     *float64        `json:"value,omitempty"`
+	Estimate  *uint64         `json:"estimate,omitempty"`
+	Histogram *debugHistogram `json:"histogram,omitempty"`
+}
+
+// debugHistogram holds the aggregates of the samples that a histogram
+// received on this veneur, and the count of its t-digest, which also
+// includes the histograms that were merged into it.
+type debugHistogram struct {
+	Count       float64  `json:"count"`
+	Sum         float64  `json:"sum"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	DigestCount float64  `json:"digest_count,omitempty"`
+}
+
+func (s *Server) handleDebugMetrics(w http.ResponseWriter, r *http.Request) {
+	name := pat.Param(r, "name")
+	var series []debugSeries
+	for _, worker := range s.Workers {
+		series = append(series, worker.currentSeries(name, s.flushIntervals)...)
+	}
+	if len(series) == 0 {
+		http.Error(w, fmt.Sprintf("no series of %q were received in this interval", name), http.StatusNotFound)
+		return
+	}
+	sort.Slice(series, func(i, j int) bool {
+		if series[i].Type != series[j].Type {
+			return series[i].Type < series[j].Type
+		}
+		return strings.Join(series[i].Tags, ",") < strings.Join(series[j].Tags, ",")
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(series)
+}
+
+// currentSeries returns the current aggregates of the series with the
+// given name, which haven't been flushed yet. flushIntervals are the
+// intervals of the server's flush groups.
+func (w *Worker) currentSeries(name string, flushIntervals []time.Duration) []debugSeries {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	series := w.wm.debugSeries(name, nil)
+	for group := range w.groupWMs {
+		groupSeries := w.groupWMs[group].debugSeries(name, nil)
+		for i := range groupSeries {
+			groupSeries[i].Interval = flushIntervals[group].String()
+		}
+		series = append(series, groupSeries...)
+	}
+	return series
+}
+
+// debugSeries appends the aggregates of the series named name to series.
+func (wm *WorkerMetrics) debugSeries(name string, series []debugSeries) []debugSeries {
+	counters := func(m map[samplers.MetricKey]*samplers.Counter, scope string) {
+		for key, c := range m {
+			if key.Name == name {
+				value := c.Flush(0)[0].Value
+				series = append(series, debugSeries{Type: counterTypeName, Tags: c.Tags, Scope: scope, Value: &value})
+			}
+		}
+	}
+	gauges := func(m map[samplers.MetricKey]*samplers.Gauge, scope string) {
+		for key, g := range m {
+			if key.Name == name {
+				value := g.Flush()[0].Value
+				series = append(series, debugSeries{Type: gaugeTypeName, Tags: g.Tags, Scope: scope, Value: &value})
+			}
+		}
+	}
+	sets := func(m map[samplers.MetricKey]*samplers.Set, scope string) {
+		for key, s := range m {
+			if key.Name == name {
+				estimate := s.Hll.Estimate()
+				series = append(series, debugSeries{Type: setTypeName, Tags: s.Tags, Scope: scope, Estimate: &estimate})
+			}
+		}
+	}
+	histograms := func(m map[samplers.MetricKey]*samplers.Histo, typ, scope string) {
+		for key, h := range m {
+			if key.Name == name {
+				series = append(series, debugSeries{Type: typ, Tags: h.Tags, Scope: scope, Histogram: newDebugHistogram(h)})
+			}
+		}
+	}
+
+	counters(wm.counters, "")
+	counters(wm.globalCounters, "global")
+	gauges(wm.gauges, "")
+	gauges(wm.globalGauges, "global")
+	sets(wm.sets, "")
+	sets(wm.localSets, "local")
+	histograms(wm.histograms, histogramTypeName, "")
+	histograms(wm.globalHistograms, histogramTypeName, "global")
+	histograms(wm.localHistograms, histogramTypeName, "local")
+	histograms(wm.timers, timerTypeName, "")
+	histograms(wm.globalTimers, timerTypeName, "global")
+	histograms(wm.localTimers, timerTypeName, "local")
+	for key, d := range wm.distributions {
+		if key.Name == name {
+			series = append(series, debugSeries{Type: distributionTypeName, Tags: d.Tags, Scope: "local", Histogram: newDebugHistogram(d.Histo)})
+		}
+	}
+	return series
+}
+
+func newDebugHistogram(h *samplers.Histo) *debugHistogram {
+	dh := &debugHistogram{Count: h.LocalWeight, Sum: h.LocalSum}
+	// the minimum and maximum are infinite until a sample arrives
+	if h.LocalWeight > 0 {
+		min, max := h.LocalMin, h.LocalMax
+		dh.Min, dh.Max = &min, &max
+	}
+	if h.Value != nil {
+		dh.DigestCount = h.Value.Count()
+	}
+	return dh
+}
@@ -0,0 +1,74 @@
+package veneur
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func TestDebugMetrics(t *testing.T) {
+	config := localConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	s := setupVeneurServer(t, config, nil, nil, nil, nil)
+	defer s.Shutdown()
+
+	process := func(name, typ string, tags []string, value float64) {
+		m := &samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: name, Type: typ},
+			Tags:       tags,
+			Value:      value,
+			Digest:     12,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		}
+		if len(tags) > 0 {
+			m.JoinedTags = tags[0]
+		}
+		s.Workers[0].ProcessMetric(m)
+	}
+	process("a.b.c", counterTypeName, []string{"env:prod"}, 2)
+	process("a.b.c", counterTypeName, []string{"env:prod"}, 3)
+	process("a.b.c", counterTypeName, []string{"env:qa"}, 1)
+	process("d.e.f", histogramTypeName, nil, 5)
+	process("d.e.f", histogramTypeName, nil, 1)
+
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	get := func(name string) ([]debugSeries, int) {
+		resp, err := http.Get(srv.URL + "/debug/metrics/" + name)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, resp.StatusCode
+		}
+		var series []debugSeries
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&series))
+		return series, resp.StatusCode
+	}
+
+	series, _ := get("a.b.c")
+	require.Len(t, series, 2)
+	assert.Equal(t, []string{"env:prod"}, series[0].Tags)
+	assert.Equal(t, 5.0, *series[0].Value)
+	assert.Equal(t, []string{"env:qa"}, series[1].Tags)
+	assert.Equal(t, 1.0, *series[1].Value)
+
+	series, _ = get("d.e.f")
+	require.Len(t, series, 1)
+	assert.Equal(t, histogramTypeName, series[0].Type)
+	assert.Equal(t, 2.0, series[0].Histogram.Count)
+	assert.Equal(t, 6.0, series[0].Histogram.Sum)
+	assert.Equal(t, 1.0, *series[0].Histogram.Min)
+	assert.Equal(t, 5.0, *series[0].Histogram.Max)
+
+	_, status := get("g.h.i")
+	assert.Equal(t, http.StatusNotFound, status)
+}
@@ -0,0 +1,80 @@
+package veneur
+
+import (
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+)
+
+// The ways a metric sink can receive distributions.
+const (
+	distributionModeDistribution = "distribution"
+	distributionModeHistogram    = "histogram"
+	distributionModeDrop         = "drop"
+)
+
+// checkDistribution turns a distribution into a histogram, unless
+// distributions_enabled is set. Unlike distributions, histograms are
+// forwarded, and aggregated into global percentiles.
+func (s *Server) checkDistribution(metric *samplers.UDPMetric) {
+	if metric.Type == distributionTypeName && !s.distributionsEnabled {
+		metric.SetType(histogramTypeName)
+	}
+}
+
+// distributionRoutes returns the metric sinks that receive distributions as
+// they are, and those that receive them as histograms. Unless its mode is
+// overridden, a sink receives them as they are if it supports them.
+func (s *Server) distributionRoutes() (distributions, histograms samplers.RouteInformation) {
+	distributions = samplers.RouteInformation{}
+	histograms = samplers.RouteInformation{}
+	for _, sink := range s.metricSinks {
+		_, supported := sinks.UnwrapMetricSink(sink).(sinks.DistributionMetricSink)
+		mode, ok := s.distributionModes[sink.Name()]
+		if !ok && supported {
+			mode = distributionModeDistribution
+		}
+		switch {
+		case mode == distributionModeDistribution && supported:
+			distributions[sink.Name()] = struct{}{}
+		case mode == distributionModeDrop:
+		default:
+			histograms[sink.Name()] = struct{}{}
+		}
+	}
+	return distributions, histograms
+}
+
+// appendRouted appends the metrics that go to any of the sinks in route,
+// restricting them to those sinks.
+func appendRouted(dst []samplers.InterMetric, metrics []samplers.InterMetric, route samplers.RouteInformation) []samplers.InterMetric {
+	for _, m := range metrics {
+		if m.Sinks == nil {
+			m.Sinks = route
+		} else {
+			// the client picked the sinks with veneursinkonly tags
+			routed := samplers.RouteInformation{}
+			for name := range m.Sinks {
+				if route.RouteTo(name) {
+					routed[name] = struct{}{}
+				}
+			}
+			if len(routed) == 0 {
+				continue
+			}
+			m.Sinks = routed
+		}
+		dst = append(dst, m)
+	}
+	return dst
+}
+
+// withoutDistributions returns the metrics that aren't DistributionMetrics.
+func withoutDistributions(metrics []samplers.InterMetric) []samplers.InterMetric {
+	filtered := make([]samplers.InterMetric, 0, len(metrics))
+	for _, m := range metrics {
+		if m.Type != samplers.DistributionMetric {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
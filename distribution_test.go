@@ -0,0 +1,156 @@
+package veneur
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/sinks/datadog"
+)
+
+// distributionMetricSink is a channel sink that accepts distributions.
+type distributionMetricSink struct {
+	*channelMetricSink
+}
+
+func (d distributionMetricSink) Name() string {
+	return "distributions"
+}
+
+func (d distributionMetricSink) AcceptsDistributions() {}
+
+// accepted returns the metrics of the flush that the sink accepts.
+func accepted(sink sinks.MetricSink, metrics []samplers.InterMetric) []samplers.InterMetric {
+	var res []samplers.InterMetric
+	for _, m := range metrics {
+		if sinks.IsAcceptableMetric(m, sink) {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+func TestFlushDistributions(t *testing.T) {
+	tests := []struct {
+		name  string
+		modes map[string]string
+		// the types of the metrics that each sink should receive
+		histogramSink    []samplers.MetricType
+		distributionSink []samplers.MetricType
+	}{
+		{"default", nil,
+			[]samplers.MetricType{samplers.CounterMetric},
+			[]samplers.MetricType{samplers.DistributionMetric}},
+		{"overridden", map[string]string{"channel": "drop", "distributions": "histogram"},
+			nil,
+			[]samplers.MetricType{samplers.CounterMetric}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rcv := make(chan []samplers.InterMetric, 10)
+			histogramSink, err := NewChannelMetricSink(rcv)
+			require.NoError(t, err)
+			distributionSink := distributionMetricSink{&channelMetricSink{metricsChannel: make(chan []samplers.InterMetric, 10)}}
+
+			config := globalConfig()
+			// Flush only when the test does
+			config.Interval = "1m"
+			config.Percentiles = nil
+			config.Aggregates = []string{"count"}
+			global := setupVeneurServer(t, config, nil, histogramSink, nil, nil)
+			defer global.Shutdown()
+			global.metricSinks = append(global.metricSinks, distributionSink)
+			global.distributionModes = test.modes
+
+			for _, sample := range []struct {
+				value float64
+				rate  float32
+			}{{1, 1}, {3, 0.5}} {
+				global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+					MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: distributionTypeName},
+					Value:      sample.value,
+					SampleRate: sample.rate,
+				})
+			}
+			global.Flush(context.Background())
+
+			metrics := <-rcv
+			types := func(metrics []samplers.InterMetric) []samplers.MetricType {
+				var res []samplers.MetricType
+				for _, m := range metrics {
+					res = append(res, m.Type)
+				}
+				return res
+			}
+			assert.Equal(t, test.histogramSink, types(accepted(histogramSink, metrics)))
+			received := accepted(distributionSink, metrics)
+			assert.Equal(t, test.distributionSink, types(received))
+			if received[0].Type == samplers.DistributionMetric {
+				assert.Equal(t, []float64{1, 3, 3}, received[0].Values, "sampled values should be repeated")
+			} else {
+				assert.Equal(t, "a.b.c.count", received[0].Name)
+				assert.Equal(t, 3.0, received[0].Value, "the count should be weighted by the sample rate")
+			}
+		})
+	}
+}
+
+func TestDistributionRoutesTagFiltered(t *testing.T) {
+	distributionSink := distributionMetricSink{&channelMetricSink{}}
+	ddSink, err := datadog.NewDatadogMetricSink(10, 1000, "host", nil, "http://api", "apikey", nil, logrus.New(), nil, nil)
+	require.NoError(t, err)
+	histogramSink := &channelMetricSink{}
+	s := &Server{metricSinks: []sinks.MetricSink{
+		sinks.FilterMetricTags(distributionSink, []string{"x"}, nil),
+		sinks.FilterMetricTags(ddSink, nil, []string{"y"}),
+		sinks.FilterMetricTags(histogramSink, []string{"x"}, nil),
+	}}
+
+	for _, modes := range []map[string]string{
+		nil,
+		{"distributions": distributionModeDistribution, "datadog": distributionModeDistribution},
+	} {
+		s.distributionModes = modes
+		distributions, histograms := s.distributionRoutes()
+		assert.Equal(t, samplers.RouteInformation{"distributions": {}, "datadog": {}}, distributions,
+			"tag-filtered sinks that support distributions should receive them, with modes %v", modes)
+		assert.Equal(t, samplers.RouteInformation{"channel": {}}, histograms)
+	}
+}
+
+func TestDistributionSinkOverridesInvalid(t *testing.T) {
+	config := localConfig()
+	config.DistributionSinkOverrides = append(config.DistributionSinkOverrides, struct {
+		Sink string `yaml:"sink"`
+		Mode string `yaml:"mode"`
+	}{"signalfx", "percentiles"})
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+}
+
+func TestDistributionsEnabled(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		config := localConfig()
+		config.DistributionsEnabled = enabled
+		server, err := NewFromConfig(logrus.New(), config)
+		require.NoError(t, err)
+
+		metric, err := samplers.ParseMetric([]byte("a.b.c:1|d"))
+		require.NoError(t, err)
+		server.checkDistribution(metric)
+
+		want := samplers.MetricKey{Name: "a.b.c", Type: histogramTypeName}
+		if enabled {
+			want.Type = distributionTypeName
+		}
+		assert.Equal(t, want, metric.MetricKey, "distributions_enabled: %v", enabled)
+		histogram, err := samplers.ParseMetric([]byte("a.b.c:1|h"))
+		require.NoError(t, err)
+		assert.Equal(t, !enabled, histogram.Digest == metric.Digest, "the digest should match the type")
+	}
+}
@@ -0,0 +1,58 @@
+package veneur
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// flushJitter delays emitting each flush's metrics by a random duration
+// of up to max, so that a fleet of Veneurs that flush at the same time
+// don't all hit their sinks at once. Only the emit is delayed: metrics
+// are still collected from the workers on the flush tick, so their
+// aggregation windows stay aligned with the interval.
+type flushJitter struct {
+	max time.Duration
+
+	mtx  sync.Mutex
+	rand *rand.Rand
+}
+
+// newFlushJitter returns a flushJitter whose delays are drawn from a
+// random source seeded by the hostname if deterministic is set, so that
+// a host picks the same sequence of delays every time it starts, or by
+// the current time otherwise.
+func newFlushJitter(max time.Duration, hostname string, deterministic bool) *flushJitter {
+	seed := time.Now().UnixNano()
+	if deterministic {
+		h := fnv.New64a()
+		h.Write([]byte(hostname))
+		seed = int64(h.Sum64())
+	}
+	return &flushJitter{
+		max:  max,
+		rand: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// next returns the delay before the next flush is emitted.
+func (j *flushJitter) next() time.Duration {
+	j.mtx.Lock()
+	defer j.mtx.Unlock()
+	return time.Duration(j.rand.Int63n(int64(j.max)))
+}
+
+// wait waits for the next delay, or until ctx is done, and returns the
+// delay it picked.
+func (j *flushJitter) wait(ctx context.Context) time.Duration {
+	delay := j.next()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	return delay
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"path"
 	"reflect"
 	"runtime"
 	"strings"
@@ -42,6 +43,22 @@ func (s *Server) Flush(ctx context.Context) {
 	s.Statsd.Gauge("mem.heap_alloc_bytes", float64(mem.HeapAlloc), nil, 1.0)
 	s.Statsd.Gauge("flush.flush_timestamp_ns", float64(flushTime), nil, 1.0)
 
+	s.applyReload()
+
+	s.reportRateLimited()
+	s.reportListenerStats()
+	s.reportIngestQueues()
+	s.reportTraceSampling()
+	s.reportSpanValidation()
+	if s.enableUDPSocketStats {
+		s.reportUDPSocketStats()
+	}
+	if s.udpCapture != nil {
+		if err := s.udpCapture.Flush(); err != nil {
+			log.WithError(err).Error("Error flushing the UDP capture file")
+		}
+	}
+
 	if s.CountUniqueTimeseries {
 		s.Statsd.Count("flush.unique_timeseries_total", s.tallyTimeseries(), []string{fmt.Sprintf("global_veneur:%t", !s.IsLocal())}, 1.0)
 	}
@@ -55,34 +72,54 @@ func (s *Server) Flush(ctx context.Context) {
 
 	go s.flushTraces(span.Attach(ctx))
 
-	var finalMetrics []samplers.InterMetric
+	s.flushMetrics(ctx, span, regularFlush)
 
-	// This ensures that mixedscope histograms and timers behave correctly.
-	// That is, they should emit aggregates when forwarding, but no percentiles.
-	// Similarly, they should emit percentiles when global, but no aggregates.
-	//
-	// This serves two purposes:
-	//   * Percentiles are only accurate when aggregated globally.
-	//   * Avoid double counting and breaking existing queries (if count is also
-	//     emitted globally, queries that sum over counts double!)
-	var percentiles []float64
-	aggregates := s.HistogramAggregates
-	if !s.IsLocal() {
-		percentiles = s.HistogramPercentiles
-		aggregates = samplers.HistogramAggregates{}
-	}
+	s.ingestSelfTest(time.Now())
+}
 
-	tempMetrics, ms := s.tallyMetrics(percentiles)
+// reportIngestQueues emits how many metrics are waiting in the workers'
+// queues, and how many were dropped since the last report because the
+// queues were full.
+func (s *Server) reportIngestQueues() {
+	var elements, capacity int
+	var dropped int64
+	for _, w := range s.Workers {
+		elements += len(w.PacketChan)
+		capacity += cap(w.PacketChan)
+		dropped += atomic.SwapInt64(&w.dropped, 0)
+	}
+	s.Statsd.Gauge("worker.packet_chan.total_elements", float64(elements), nil, 1.0)
+	s.Statsd.Gauge("worker.packet_chan.total_capacity", float64(capacity), nil, 1.0)
+	if dropped > 0 {
+		s.Statsd.Count("worker.packet_chan.dropped_total", dropped, nil, 1.0)
+	}
+}
 
-	finalMetrics = s.generateInterMetrics(span.Attach(ctx), percentiles, aggregates, tempMetrics, ms)
+// flushMetrics collects the metrics of a flush group, or the others if
+// group is regularFlush, from the workers, and forwards them and passes
+// them to sinks.
+func (s *Server) flushMetrics(ctx context.Context, span *trace.Span, group int) {
+	tempMetrics, ms, finalMetrics := s.collectMetrics(span.Attach(ctx), group)
 
 	s.reportMetricsFlushCounts(ms)
 
+	// The metrics were collected from the workers on the flush tick, so
+	// delaying their emit doesn't move the aggregation window.
+	if s.flushJitter != nil && group == regularFlush {
+		delay := s.flushJitter.wait(ctx)
+		s.Statsd.Timing("flush.jitter_duration_ns", delay, nil, 1.0)
+	}
+
+	rc := s.currentConfig()
 	wg := sync.WaitGroup{}
 	if s.IsLocal() {
+		if rc.forwardFilter != nil {
+			s.Statsd.Count("forward.series_total", int64(ms.totalForwarded), []string{"forwarded:true"}, 1.0)
+			s.Statsd.Count("forward.series_total", int64(ms.totalKeptLocal), []string{"forwarded:false"}, 1.0)
+		}
 		wg.Add(1)
 		// Forward over gRPC or HTTP depending on the configuration
-		if s.forwardUseGRPC {
+		if s.grpcForwardStream != nil || s.forwardUseGRPC {
 			go func() {
 				s.forwardGRPC(span.Attach(ctx), tempMetrics)
 				wg.Done()
@@ -95,7 +132,9 @@ func (s *Server) Flush(ctx context.Context) {
 		}
 	} else {
 		s.reportGlobalMetricsFlushCounts(ms)
-		s.reportGlobalReceivedProtocolMetrics()
+		if group == regularFlush {
+			s.reportGlobalReceivedProtocolMetrics()
+		}
 	}
 
 	// If there's nothing to flush, don't bother calling the plugins and stuff.
@@ -103,15 +142,41 @@ func (s *Server) Flush(ctx context.Context) {
 		return
 	}
 
+	interval := s.interval
+	if group != regularFlush {
+		interval = s.flushIntervals[group]
+	}
+	// the sinks that convert counters to rates themselves divide them
+	// by the interval of the flush group
+	sinkCtx := sinks.WithFlushInterval(ctx, interval)
+	var rates []samplers.InterMetric
 	for _, sink := range s.metricSinks {
-		wg.Add(1)
-		go func(ms sinks.MetricSink) {
-			err := ms.Flush(span.Attach(ctx), finalMetrics)
-			if err != nil {
-				log.WithError(err).WithField("sink", ms.Name()).Warn("Error flushing sink")
+		if rc.disabledMetricSinks[sink.Name()] {
+			continue
+		}
+		sinkMetrics := finalMetrics
+		if s.convertsCounters(sink) {
+			if rates == nil {
+				rates = countersAsRates(finalMetrics, interval)
 			}
+			sinkMetrics = rates
+		}
+		if s.shadowMetricSinks[sink.Name()] {
+			// the flush doesn't wait for shadow sinks, and cancels its
+			// context once it's done, so they get their own
+			go s.flushShadowMetricSink(span.Attach(sinks.WithFlushInterval(context.Background(), interval)), sink, sinkMetrics, interval)
+			continue
+		}
+		wg.Add(1)
+		go func(ms sinks.MetricSink, metrics []samplers.InterMetric) {
+			s.flushMetricSink(span.Attach(sinkCtx), ms, metrics)
 			wg.Done()
-		}(sink)
+		}(sink, sinkMetrics)
+	}
+	if s.OnFlush != nil {
+		// like the shadow sinks, the hook gets its own context, since
+		// the flush doesn't wait for it
+		go s.OnFlush(span.Attach(sinks.WithFlushInterval(context.Background(), interval)), finalMetrics)
 	}
 	wg.Wait()
 
@@ -120,18 +185,185 @@ func (s *Server) Flush(ctx context.Context) {
 		defer metrics.Report(s.TraceClient, samples)
 
 		tags := map[string]string{"part": "post"}
-		for _, p := range s.getPlugins() {
+		plugins := s.getPlugins()
+		pluginMetrics := finalMetrics
+		if len(plugins) > 0 && ms.totalDistributions > 0 {
+			// plugins don't know about distributions, but they get the
+			// histograms that the other sinks get
+			pluginMetrics = withoutDistributions(finalMetrics)
+		}
+		for _, p := range plugins {
 			start := time.Now()
-			err := p.Flush(span.Attach(ctx), finalMetrics)
+			err := p.Flush(span.Attach(ctx), pluginMetrics)
 			samples.Add(ssf.Timing(fmt.Sprintf("flush.plugins.%s.total_duration_ns", p.Name()), time.Since(start), time.Nanosecond, tags))
 			if err != nil {
 				samples.Add(ssf.Count(fmt.Sprintf("flush.plugins.%s.error_total", p.Name()), 1, nil))
 			}
-			samples.Add(ssf.Gauge(fmt.Sprintf("flush.plugins.%s.post_metrics_total", p.Name()), float32(len(finalMetrics)), nil))
+			samples.Add(ssf.Gauge(fmt.Sprintf("flush.plugins.%s.post_metrics_total", p.Name()), float32(len(pluginMetrics)), nil))
 		}
 	}()
 }
 
+// collectMetrics flushes the metrics of a flush group, or the others if
+// group is regularFlush, from the workers, and returns them, along with
+// the metrics that they flush to sinks.
+func (s *Server) collectMetrics(ctx context.Context, group int) ([]WorkerMetrics, metricsSummary, []samplers.InterMetric) {
+	// This ensures that mixedscope histograms and timers behave correctly.
+	// That is, they should emit aggregates when forwarding, but no percentiles.
+	// Similarly, they should emit percentiles when global, but no aggregates.
+	//
+	// This serves two purposes:
+	//   * Percentiles are only accurate when aggregated globally.
+	//   * Avoid double counting and breaking existing queries (if count is also
+	//     emitted globally, queries that sum over counts double!)
+	var percentiles []float64
+	aggregates := s.HistogramAggregates
+	if !s.IsLocal() {
+		percentiles = s.HistogramPercentiles
+		aggregates = samplers.HistogramAggregates{}
+	}
+
+	tempMetrics, ms := s.tallyMetrics(percentiles, group)
+	if len(s.cardinality) > 0 && group == regularFlush {
+		s.reportCardinality()
+	}
+	if len(s.typeCollisions) > 0 && group == regularFlush {
+		s.reportTypeCollisions()
+	}
+
+	finalMetrics := s.generateInterMetrics(ctx, percentiles, aggregates, tempMetrics, ms)
+	if globalTags := s.currentConfig().globalTags; len(globalTags) > 0 {
+		applyGlobalTags(finalMetrics, globalTags)
+	}
+	return tempMetrics, ms, finalMetrics
+}
+
+// flushMetricSink flushes metrics to a sink, waiting for up to the sink's
+// flush timeout, if it has one. If the sink fails, or doesn't finish in
+// time, its metrics are dropped, or written to the dead-letter
+// directory if there is one. It reports how long the sink took, and how
+// many of the metrics were meant for it.
+func (s *Server) flushMetricSink(ctx context.Context, sink sinks.MetricSink, metrics []samplers.InterMetric) error {
+	timeout := s.metricSinkFlushTimeout
+	if override, ok := s.metricSinkFlushTimeouts[sink.Name()]; ok {
+		timeout = override
+	}
+	batchSize := acceptedMetrics(sink, metrics)
+	flushStart := time.Now()
+	timedOut, err := flushSinkWithTimeout(ctx, sink, metrics, timeout)
+	tags := []string{"sink:" + sink.Name()}
+	s.Statsd.Timing("flush.sink_duration_ns", time.Since(flushStart), tags, 1.0)
+	s.Statsd.Histogram("flush.sink_batch_size", float64(batchSize), tags, 1.0)
+	if timedOut {
+		tags := []string{"sink:" + sink.Name(), "cause:timeout"}
+		s.Statsd.Count("flush.sink_timeouts_total", 1, tags, 1.0)
+		s.Statsd.Count("flush.sink_metrics_dropped_total", int64(batchSize), tags, 1.0)
+	}
+	if err == nil {
+		return nil
+	}
+	log.WithError(err).WithField("sink", sink.Name()).Warn("Error flushing sink")
+	if s.deadLetter != nil {
+		s.deadLetterMetrics(sink, metrics, err)
+	}
+	return err
+}
+
+// flushShadowMetricSink flushes metrics to a shadow sink, which receives
+// the same metrics as the others but isn't trusted yet. Its failures are
+// logged and counted in flush.shadow_sink_errors_total, but never
+// dead-lettered or counted with the other sinks' failures. It waits for
+// up to the sink's flush timeout, or the flush interval if it has none,
+// so that a hung shadow sink can't pile up flushes.
+func (s *Server) flushShadowMetricSink(ctx context.Context, sink sinks.MetricSink, metrics []samplers.InterMetric, interval time.Duration) {
+	timeout := interval
+	if s.metricSinkFlushTimeout > 0 {
+		timeout = s.metricSinkFlushTimeout
+	}
+	if override, ok := s.metricSinkFlushTimeouts[sink.Name()]; ok {
+		timeout = override
+	}
+	flushStart := time.Now()
+	timedOut, err := flushSinkWithTimeout(ctx, sink, metrics, timeout)
+	tags := []string{"sink:" + sink.Name(), "shadow:true"}
+	s.Statsd.Timing("flush.sink_duration_ns", time.Since(flushStart), tags, 1.0)
+	s.Statsd.Histogram("flush.sink_batch_size", float64(acceptedMetrics(sink, metrics)), tags, 1.0)
+	if err == nil {
+		return
+	}
+	cause := "error"
+	if timedOut {
+		cause = "timeout"
+	}
+	s.Statsd.Count("flush.shadow_sink_errors_total", 1, []string{"sink:" + sink.Name(), "cause:" + cause}, 1.0)
+	log.WithError(err).WithField("sink", sink.Name()).Info("Error flushing shadow sink")
+}
+
+// flushSinkWithTimeout flushes metrics to a sink, waiting for up to
+// timeout, if it's positive. It returns whether it gave up on the sink
+// because it didn't finish in time, and the sink's error.
+func flushSinkWithTimeout(ctx context.Context, sink sinks.MetricSink, metrics []samplers.InterMetric, timeout time.Duration) (bool, error) {
+	if timeout <= 0 {
+		return false, sink.Flush(ctx, metrics)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Run the flush in its own goroutine, so that a sink that
+	// doesn't respect ctx doesn't hold up the rest of the flush.
+	done := make(chan error, 1)
+	go func() {
+		done <- sink.Flush(ctx, metrics)
+	}()
+	select {
+	case err := <-done:
+		return false, err
+	case <-ctx.Done():
+		return true, fmt.Errorf("abandoned the flush: %v", ctx.Err())
+	}
+}
+
+// acceptedMetrics returns the number of metrics that are meant for the
+// sink.
+func acceptedMetrics(sink sinks.MetricSink, metrics []samplers.InterMetric) int {
+	n := 0
+	for _, m := range metrics {
+		if sinks.IsAcceptableMetric(m, sink) {
+			n++
+		}
+	}
+	return n
+}
+
+// applyGlobalTags adds the global tags to each metric, except for those
+// whose key the metric already has a tag with: the tags that clients set
+// take precedence.
+func applyGlobalTags(metrics []samplers.InterMetric, globalTags []string) {
+	for i := range metrics {
+		tags := make([]string, len(metrics[i].Tags), len(metrics[i].Tags)+len(globalTags))
+		copy(tags, metrics[i].Tags)
+	globalTags:
+		for _, globalTag := range globalTags {
+			key := tagKey(globalTag)
+			for _, tag := range metrics[i].Tags {
+				if tagKey(tag) == key {
+					continue globalTags
+				}
+			}
+			tags = append(tags, globalTag)
+		}
+		metrics[i].Tags = tags
+	}
+}
+
+// tagKey returns the key of a "key:value" tag.
+func tagKey(tag string) string {
+	if i := strings.IndexByte(tag, ':'); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
 func (s *Server) tallyTimeseries() int64 {
 	allTimeseries := hyperloglog.New()
 	for _, w := range s.Workers {
@@ -160,6 +392,15 @@ type metricsSummary struct {
 	totalLocalTimers       int
 	totalLocalStatusChecks int
 
+	totalDistributions int
+
+	totalBackfilled int
+
+	// the series that a local veneur forwards, and those that it keeps
+	// local because of forward_metrics_allow and forward_metrics_deny
+	totalForwarded int
+	totalKeptLocal int
+
 	totalLength int
 }
 
@@ -168,17 +409,35 @@ const perProtocolTotalMetricName string = "listen.received_per_protocol_total"
 // tallyMetrics gives a slight overestimate of the number
 // of metrics we'll be reporting, so that we can pre-allocate
 // a slice of the correct length instead of constantly appending
-// for performance
-func (s *Server) tallyMetrics(percentiles []float64) ([]WorkerMetrics, metricsSummary) {
+// for performance. It flushes the workers' metrics of the flush group,
+// or the others if group is regularFlush.
+//
+// The workers are flushed one after the other, and each only stops
+// processing metrics for as long as it takes to swap its maps for empty
+// ones (see Worker.Flush), so ingestion never pauses as a whole. Since
+// each series is aggregated by a single worker, every sample of it is
+// counted in exactly one flush interval.
+func (s *Server) tallyMetrics(percentiles []float64, group int) ([]WorkerMetrics, metricsSummary) {
 	// allocating this long array to count up the sizes is cheaper than appending
 	// the []WorkerMetrics together one at a time
 	tempMetrics := make([]WorkerMetrics, 0, len(s.Workers))
 
 	ms := metricsSummary{}
+	forwardFilter := s.currentConfig().forwardFilter
 
 	for i, w := range s.Workers {
 		log.WithField("worker", i).Debug("Flushing")
-		wm := w.Flush()
+		var wm WorkerMetrics
+		if group == regularFlush {
+			wm = w.Flush()
+		} else {
+			wm = w.FlushGroup(group)
+		}
+		if forwardFilter != nil && s.IsLocal() {
+			forwarded, local := forwardFilter.keepLocal(&wm)
+			ms.totalForwarded += forwarded
+			ms.totalKeptLocal += local
+		}
 		tempMetrics = append(tempMetrics, wm)
 
 		ms.totalCounters += len(wm.counters)
@@ -197,6 +456,10 @@ func (s *Server) tallyMetrics(percentiles []float64) ([]WorkerMetrics, metricsSu
 		ms.totalLocalTimers += len(wm.localTimers)
 
 		ms.totalLocalStatusChecks += len(wm.localStatusChecks)
+
+		ms.totalDistributions += len(wm.distributions)
+
+		ms.totalBackfilled += len(wm.backfilledCounters) + len(wm.backfilledGauges)
 	}
 
 	ms.totalLength = ms.totalCounters + ms.totalGauges +
@@ -207,7 +470,11 @@ func (s *Server) tallyMetrics(percentiles []float64) ([]WorkerMetrics, metricsSu
 		// use the original percentile list here.
 		// remember that both the global veneur and the local instances have
 		// 'local-only' histograms.
-		ms.totalLocalSets + (ms.totalLocalTimers+ms.totalLocalHistograms)*(s.HistogramAggregates.Count+len(s.HistogramPercentiles))
+		ms.totalLocalSets + (ms.totalLocalTimers+ms.totalLocalHistograms)*(s.HistogramAggregates.Count+len(s.HistogramPercentiles)) +
+		// distributions can be flushed both as they are and as
+		// local-only histograms
+		ms.totalDistributions*(1+s.HistogramAggregates.Count+len(s.HistogramPercentiles)) +
+		ms.totalBackfilled
 
 	// Global instances also flush sets and global counters, so be sure and add
 	// them to the total size
@@ -222,6 +489,25 @@ func (s *Server) tallyMetrics(percentiles []float64) ([]WorkerMetrics, metricsSu
 	return tempMetrics, ms
 }
 
+// percentileOverride sets the percentiles that are flushed for the
+// histograms and timers whose names match a glob pattern.
+type percentileOverride struct {
+	pattern     string
+	percentiles []float64
+}
+
+// percentilesFor returns the percentiles to flush for the histogram or
+// timer with the given name: those of the first override that matches
+// it, or HistogramPercentiles if none does.
+func (s *Server) percentilesFor(name string) []float64 {
+	for _, override := range s.percentileOverrides {
+		if ok, _ := path.Match(override.pattern, name); ok {
+			return override.percentiles
+		}
+	}
+	return s.HistogramPercentiles
+}
+
 // generateInterMetrics calls the Flush method on each
 // counter/gauge/histogram/timer/set in order to
 // generate an InterMetric corresponding to that value
@@ -230,6 +516,20 @@ func (s *Server) generateInterMetrics(ctx context.Context, percentiles []float64
 	span, _ := trace.StartSpanFromContext(ctx, "")
 	defer span.ClientFinish(s.TraceClient)
 
+	// Overrides don't apply to histograms and timers that a local
+	// veneur forwards, since it doesn't flush their percentiles:
+	forwardedPercentiles := func(name string) []float64 {
+		if s.IsLocal() {
+			return percentiles
+		}
+		return s.percentilesFor(name)
+	}
+
+	var distributionSinks, distributionHistogramSinks samplers.RouteInformation
+	if ms.totalDistributions > 0 {
+		distributionSinks, distributionHistogramSinks = s.distributionRoutes()
+	}
+
 	finalMetrics := make([]samplers.InterMetric, 0, ms.totalLength)
 	for _, wm := range tempMetrics {
 		for _, c := range wm.counters {
@@ -243,10 +543,10 @@ func (s *Server) generateInterMetrics(ctx context.Context, percentiles []float64
 		//
 		// if we're a global veneur, aggregates will be nil.
 		for _, h := range wm.histograms {
-			finalMetrics = append(finalMetrics, h.Flush(s.interval, percentiles, s.HistogramAggregates, false)...)
+			finalMetrics = append(finalMetrics, h.Flush(s.interval, forwardedPercentiles(h.Name), s.HistogramAggregates, false)...)
 		}
 		for _, t := range wm.timers {
-			finalMetrics = append(finalMetrics, t.Flush(s.interval, percentiles, s.HistogramAggregates, false)...)
+			finalMetrics = append(finalMetrics, s.timerMetrics(t.Flush(s.interval, forwardedPercentiles(t.Name), s.HistogramAggregates, false))...)
 		}
 
 		// local-only samplers should be flushed in their entirety, since they
@@ -254,19 +554,46 @@ func (s *Server) generateInterMetrics(ctx context.Context, percentiles []float64
 		// we still want percentiles for these, even if we're a local veneur, so
 		// we use the original percentile list when flushing them
 		for _, h := range wm.localHistograms {
-			finalMetrics = append(finalMetrics, h.Flush(s.interval, s.HistogramPercentiles, s.HistogramAggregates, false)...)
+			finalMetrics = append(finalMetrics, h.Flush(s.interval, s.percentilesFor(h.Name), s.HistogramAggregates, false)...)
 		}
 		for _, s := range wm.localSets {
 			finalMetrics = append(finalMetrics, s.Flush()...)
 		}
 		for _, t := range wm.localTimers {
-			finalMetrics = append(finalMetrics, t.Flush(s.interval, s.HistogramPercentiles, s.HistogramAggregates, false)...)
+			finalMetrics = append(finalMetrics, s.timerMetrics(t.Flush(s.interval, s.percentilesFor(t.Name), s.HistogramAggregates, false))...)
 		}
 
 		for _, status := range wm.localStatusChecks {
 			finalMetrics = append(finalMetrics, status.Flush()...)
 		}
 
+		// distributions are flushed wherever they were received, as they
+		// are to the sinks that support them, and as local-only
+		// histograms to the others
+		for _, d := range wm.distributions {
+			if len(distributionSinks) > 0 {
+				finalMetrics = appendRouted(finalMetrics, d.FlushDistribution(), distributionSinks)
+			}
+			if len(distributionHistogramSinks) > 0 {
+				finalMetrics = appendRouted(finalMetrics, d.Flush(s.interval, s.percentilesFor(d.Name), s.HistogramAggregates, false), distributionHistogramSinks)
+			}
+		}
+
+		// backfilled counters and gauges are flushed wherever they were
+		// received, with the timestamp of their flush interval
+		for key, c := range wm.backfilledCounters {
+			for _, m := range c.Flush(s.interval) {
+				m.Timestamp = key.timestamp
+				finalMetrics = append(finalMetrics, m)
+			}
+		}
+		for key, g := range wm.backfilledGauges {
+			for _, m := range g.Flush() {
+				m.Timestamp = key.timestamp
+				finalMetrics = append(finalMetrics, m)
+			}
+		}
+
 		// TODO (aditya) refactor this out so we don't
 		// have to call IsLocal again
 		if !s.IsLocal() {
@@ -289,10 +616,10 @@ func (s *Server) generateInterMetrics(ctx context.Context, percentiles []float64
 			}
 
 			for _, h := range wm.globalHistograms {
-				finalMetrics = append(finalMetrics, h.Flush(s.interval, s.HistogramPercentiles, s.HistogramAggregates, true)...)
+				finalMetrics = append(finalMetrics, h.Flush(s.interval, s.percentilesFor(h.Name), s.HistogramAggregates, true)...)
 			}
 			for _, h := range wm.globalTimers {
-				finalMetrics = append(finalMetrics, h.Flush(s.interval, s.HistogramPercentiles, s.HistogramAggregates, true)...)
+				finalMetrics = append(finalMetrics, s.timerMetrics(h.Flush(s.interval, s.percentilesFor(h.Name), s.HistogramAggregates, true))...)
 			}
 		}
 	}
@@ -300,6 +627,24 @@ func (s *Server) generateInterMetrics(ctx context.Context, percentiles []float64
 	return finalMetrics
 }
 
+// timerUnitTag is the tag of the metrics computed from timers, when
+// metric_unit_tag is set: statsd timers are in milliseconds.
+const timerUnitTag = "metric_unit:millisecond"
+
+// timerMetrics marks the metrics flushed from a timer as such, and tags
+// them with their unit if the server is configured to. The count of a
+// timer is a count, not milliseconds, so it isn't tagged.
+func (s *Server) timerMetrics(metrics []samplers.InterMetric) []samplers.InterMetric {
+	for i := range metrics {
+		metrics[i].Timer = true
+		if s.metricUnitTag && metrics[i].Type == samplers.GaugeMetric {
+			// each metric has its own copy of the tags
+			metrics[i].Tags = append(metrics[i].Tags, timerUnitTag)
+		}
+	}
+	return metrics
+}
+
 const flushTotalMetric = "worker.metrics_flushed_total"
 
 // reportMetricsFlushCounts reports the counts of
@@ -316,6 +661,7 @@ func (s *Server) reportMetricsFlushCounts(ms metricsSummary) {
 	s.Statsd.Count(flushTotalMetric, int64(ms.totalLocalSets), []string{"metric_type:local_set"}, 1.0)
 	s.Statsd.Count(flushTotalMetric, int64(ms.totalLocalTimers), []string{"metric_type:local_timer"}, 1.0)
 	s.Statsd.Count(flushTotalMetric, int64(ms.totalLocalStatusChecks), []string{"metric_type:status"}, 1.0)
+	s.Statsd.Count(flushTotalMetric, int64(ms.totalDistributions), []string{"metric_type:distribution"}, 1.0)
 }
 
 // reportGlobalMetricsFlushCounts reports the counts of
@@ -345,6 +691,7 @@ func (s *Server) reportGlobalReceivedProtocolMetrics() {
 	dogstatsdUdpTotal := atomic.SwapInt64(&protocolMetrics.dogstatsdUdpReceivedTotal, 0)
 	dogstatsdUnixTotal := atomic.SwapInt64(&protocolMetrics.dogstatsdUnixReceivedTotal, 0)
 	dogstatsdGrpcTotal := atomic.SwapInt64(&protocolMetrics.dogstatsdGrpcReceivedTotal, 0)
+	dogstatsdHttpTotal := atomic.SwapInt64(&protocolMetrics.dogstatsdHttpReceivedTotal, 0)
 
 	ssfUdpTotal := atomic.SwapInt64(&protocolMetrics.ssfUdpReceivedTotal, 0)
 	ssfUnixTotal := atomic.SwapInt64(&protocolMetrics.ssfUnixReceivedTotal, 0)
@@ -354,6 +701,7 @@ func (s *Server) reportGlobalReceivedProtocolMetrics() {
 	s.Statsd.Count(perProtocolTotalMetricName, dogstatsdUdpTotal, []string{"veneurglobalonly:true", "protocol:" + DOGSTATSD_UDP.String()}, 1.0)
 	s.Statsd.Count(perProtocolTotalMetricName, dogstatsdUnixTotal, []string{"veneurglobalonly:true", "protocol:" + DOGSTATSD_UNIX.String()}, 1.0)
 	s.Statsd.Count(perProtocolTotalMetricName, dogstatsdGrpcTotal, []string{"veneurglobalonly:true", "protocol:" + DOGSTATSD_GRPC.String()}, 1.0)
+	s.Statsd.Count(perProtocolTotalMetricName, dogstatsdHttpTotal, []string{"veneurglobalonly:true", "protocol:" + DOGSTATSD_HTTP.String()}, 1.0)
 
 	s.Statsd.Count(perProtocolTotalMetricName, ssfUdpTotal, []string{"veneurglobalonly:true", "protocol:" + SSF_UDP.String()}, 1.0)
 	s.Statsd.Count(perProtocolTotalMetricName, ssfUnixTotal, []string{"veneurglobalonly:true", "protocol:" + SSF_UNIX.String()}, 1.0)
@@ -448,7 +796,7 @@ func (s *Server) flushForward(ctx context.Context, wms []WorkerMetrics) {
 	// the error has already been logged (if there was one), so we only care
 	// about the success case
 	endpoint := fmt.Sprintf("%s/import", s.ForwardAddr)
-	if vhttp.PostHelper(span.Attach(ctx), s.HTTPClient, s.TraceClient, http.MethodPost, endpoint, jsonMetrics, "forward", true, nil, log) == nil {
+	if vhttp.PostHelperWithEncoding(span.Attach(ctx), s.HTTPClient, s.TraceClient, http.MethodPost, endpoint, jsonMetrics, "forward", s.forwardEncoding, nil, log) == nil {
 		log.WithFields(logrus.Fields{
 			"metrics":     len(jsonMetrics),
 			"endpoint":    endpoint,
@@ -522,17 +870,25 @@ func (s *Server) forwardGRPC(ctx context.Context, wms []WorkerMetrics) {
 		return
 	}
 
+	destination := s.ForwardAddr
+	if s.grpcForwardStream != nil {
+		destination = s.ForwardGRPCAddr
+	}
 	entry := log.WithFields(logrus.Fields{
 		"metrics":     len(metrics),
-		"destination": s.ForwardAddr,
+		"destination": destination,
 		"protocol":    "grpc",
 		"grpcstate":   s.grpcForwardConn.GetState().String(),
 	})
 
-	c := forwardrpc.NewForwardClient(s.grpcForwardConn)
-
 	grpcStart := time.Now()
-	_, err := c.SendMetrics(ctx, &forwardrpc.MetricList{Metrics: metrics})
+	var err error
+	if s.grpcForwardStream != nil {
+		err = s.grpcForwardStream.send(ctx, metrics)
+	} else {
+		c := forwardrpc.NewForwardClient(s.grpcForwardConn)
+		_, err = c.SendMetrics(ctx, &forwardrpc.MetricList{Metrics: metrics})
+	}
 	if err != nil {
 		if ctx.Err() != nil {
 			// We exceeded the deadline of the flush context.
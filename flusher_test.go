@@ -2,6 +2,9 @@ package veneur
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,6 +12,7 @@ import (
 	"github.com/stripe/veneur/v14/ssf"
 	"github.com/stripe/veneur/v14/trace"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/v14/internal/forwardtest"
@@ -179,6 +183,144 @@ func TestGlobalAcceptsHistogramsOverUDP(t *testing.T) {
 	}
 }
 
+func TestPercentileOverrides(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	cfg := globalConfig()
+	cfg.Percentiles = []float64{0.5}
+	cfg.Aggregates = []string{}
+	global := setupVeneurServer(t, cfg, nil, sink, nil, nil)
+	defer global.Shutdown()
+	global.percentileOverrides = []percentileOverride{
+		{pattern: "api.*.latency", percentiles: []float64{0.99, 0.999}},
+		{pattern: "api.*", percentiles: []float64{0.75}},
+	}
+
+	for _, name := range []string{"api.foo.latency", "api.foo.bytes", "db.latency"} {
+		global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+			MetricKey: samplers.MetricKey{
+				Name: name,
+				Type: histogramTypeName,
+			},
+			Value:      20.0,
+			Digest:     1,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+	}
+	global.Flush(context.Background())
+
+	select {
+	case results := <-rcv:
+		names := make([]string, 0, len(results))
+		for _, m := range results {
+			names = append(names, m.Name)
+		}
+		assert.ElementsMatch(t, []string{
+			"api.foo.latency.99percentile",
+			"api.foo.latency.999percentile",
+			"api.foo.bytes.75percentile",
+			"db.latency.50percentile",
+		}, names)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for global veneur flush")
+	}
+}
+
+func TestFlushTimerUnits(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	cfg := globalConfig()
+	cfg.Percentiles = []float64{0.5}
+	cfg.Aggregates = []string{"max", "count"}
+	cfg.MetricUnitTag = true
+	global := setupVeneurServer(t, cfg, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	for _, typ := range []string{timerTypeName, histogramTypeName} {
+		global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+			MetricKey: samplers.MetricKey{
+				Name: typ,
+				Type: typ,
+			},
+			Value:      20.0,
+			Digest:     1,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+	}
+	global.Flush(context.Background())
+
+	select {
+	case results := <-rcv:
+		require.Len(t, results, 6)
+		for _, m := range results {
+			timer := strings.HasPrefix(m.Name, timerTypeName+".")
+			assert.Equal(t, timer, m.Timer, "%s should only be marked as a timer if it's from one", m.Name)
+			if timer && m.Type == samplers.GaugeMetric {
+				assert.Equal(t, []string{timerUnitTag}, m.Tags, m.Name)
+			} else {
+				assert.Empty(t, m.Tags, m.Name)
+			}
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for global veneur flush")
+	}
+}
+
+func TestFlushBackfilled(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	global := setupVeneurServer(t, globalConfig(), nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	for _, m := range []samplers.UDPMetric{
+		{MetricKey: samplers.MetricKey{Name: "a.b.c", Type: counterTypeName}, Value: 1.0, Timestamp: 1000},
+		{MetricKey: samplers.MetricKey{Name: "a.b.c", Type: counterTypeName}, Value: 2.0, Timestamp: 1000},
+		{MetricKey: samplers.MetricKey{Name: "a.b.c", Type: counterTypeName}, Value: 4.0, Timestamp: 2000},
+		{MetricKey: samplers.MetricKey{Name: "a.b.c", Type: counterTypeName}, Value: 8.0},
+		{MetricKey: samplers.MetricKey{Name: "a.b.g", Type: gaugeTypeName}, Value: 3.0, Timestamp: 1000},
+	} {
+		m := m
+		m.SampleRate = 1.0
+		m.Scope = samplers.MixedScope
+		global.Workers[0].ProcessMetric(&m)
+	}
+	global.Flush(context.Background())
+
+	select {
+	case results := <-rcv:
+		type point struct {
+			name      string
+			value     float64
+			timestamp int64
+		}
+		var points []point
+		for _, m := range results {
+			ts := m.Timestamp
+			if ts > 2000 {
+				// flushed in the current interval
+				ts = 0
+			}
+			points = append(points, point{m.Name, m.Value, ts})
+		}
+		assert.ElementsMatch(t, []point{
+			{"a.b.c", 3, 1000},
+			{"a.b.c", 4, 2000},
+			{"a.b.c", 8, 0},
+			{"a.b.g", 3, 1000},
+		}, points)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for global veneur flush")
+	}
+}
+
 func TestFlushResetsWorkerUniqueMTS(t *testing.T) {
 	config := localConfig()
 	config.CountUniqueTimeseries = true
@@ -246,3 +388,299 @@ func TestTallyTimeseries(t *testing.T) {
 	summary := f.server.tallyTimeseries()
 	assert.Equal(t, int64(2), summary)
 }
+
+func TestFlushJitter(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	config.Hostname = "veneur.example.com"
+	config.FlushJitter = "1s"
+	config.FlushJitterDeterministic = true
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+	delay := newFlushJitter(time.Second, "veneur.example.com", true).next()
+	require.True(t, delay > 200*time.Millisecond, "the test needs a longer delay than %v", delay)
+
+	process := func(value float64) {
+		global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: counterTypeName},
+			Value:      value,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+	}
+	process(1.0)
+	start := time.Now()
+	go global.Flush(context.Background())
+	// The metrics were collected before the emit was delayed, so this
+	// belongs to the next flush.
+	time.Sleep(100 * time.Millisecond)
+	process(2.0)
+
+	select {
+	case results := <-rcv:
+		assert.True(t, time.Since(start) >= delay, "the flush should be delayed by %v", delay)
+		require.Len(t, results, 1)
+		assert.Equal(t, 1.0, results[0].Value)
+		assert.True(t, time.Unix(results[0].Timestamp, 0).Before(start.Add(time.Second)),
+			"metrics should be timestamped when they're collected, not when they're emitted")
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for global veneur flush")
+	}
+}
+
+func TestFlushJitterDeterministic(t *testing.T) {
+	a := newFlushJitter(time.Second, "a.example.com", true)
+	b := newFlushJitter(time.Second, "a.example.com", true)
+	c := newFlushJitter(time.Second, "c.example.com", true)
+	var differs bool
+	for i := 0; i < 10; i++ {
+		delay := a.next()
+		assert.True(t, delay >= 0 && delay < time.Second, "delay %v should be under the maximum", delay)
+		assert.Equal(t, delay, b.next(), "hosts with the same name should pick the same delays")
+		if delay != c.next() {
+			differs = true
+		}
+	}
+	assert.True(t, differs, "hosts with different names should pick different delays")
+}
+
+func TestFlushJitterLongerThanInterval(t *testing.T) {
+	config := globalConfig()
+	config.Interval = "10s"
+	config.FlushJitter = "10s"
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err, "the jitter should have to be shorter than the interval")
+}
+
+func TestFlushFast(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	config.FastFlushInterval = "30s"
+	config.FastFlushMetrics = []string{"fast.*"}
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	for _, name := range []string{"fast.a", "slow.a"} {
+		global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: name, Type: counterTypeName},
+			Value:      1.0,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+	}
+
+	flushed := func(flush func(context.Context)) []string {
+		flush(context.Background())
+		select {
+		case results := <-rcv:
+			var names []string
+			for _, m := range results {
+				names = append(names, m.Name)
+			}
+			return names
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for global veneur flush")
+			return nil
+		}
+	}
+	assert.Equal(t, []string{"fast.a"}, flushed(func(ctx context.Context) {
+		global.flushGroup(ctx, 0)
+	}))
+	assert.Equal(t, []string{"slow.a"}, flushed(global.Flush))
+}
+
+func TestFastFlushIntervalLongerThanInterval(t *testing.T) {
+	config := globalConfig()
+	config.Interval = "10s"
+	config.FastFlushInterval = "10s"
+	config.FastFlushMetrics = []string{"fast.*"}
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err, "the fast flush interval should have to be shorter than the interval")
+}
+
+// hungMetricSink never finishes flushing, whatever its context.
+type hungMetricSink struct {
+	name    string
+	release chan struct{}
+}
+
+func (h *hungMetricSink) Name() string { return h.name }
+
+func (h *hungMetricSink) Start(*trace.Client) error { return nil }
+
+func (h *hungMetricSink) Flush(context.Context, []samplers.InterMetric) error {
+	<-h.release
+	return nil
+}
+
+func (h *hungMetricSink) FlushOtherSamples(context.Context, []ssf.SSFSample) {}
+
+func TestFlushMetricSinkTimeout(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	config.MetricSinkFlushTimeout = "50ms"
+	config.MetricSinkFlushTimeoutOverrides = append(config.MetricSinkFlushTimeoutOverrides, struct {
+		Sink    string `yaml:"sink"`
+		Timeout string `yaml:"timeout"`
+	}{Sink: "slow", Timeout: "2s"})
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+	hung := &hungMetricSink{name: "hung", release: make(chan struct{})}
+	defer close(hung.release)
+	global.metricSinks = append(global.metricSinks, hung)
+
+	global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+		MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: counterTypeName},
+		Value:      1.0,
+		SampleRate: 1.0,
+		Scope:      samplers.MixedScope,
+	})
+	start := time.Now()
+	global.Flush(context.Background())
+	assert.True(t, time.Since(start) < time.Second, "the flush shouldn't wait for the hung sink")
+	select {
+	case results := <-rcv:
+		assert.Len(t, results, 1, "the other sinks should still flush")
+	default:
+		t.Fatal("the other sinks should have flushed")
+	}
+
+	slow := &hungMetricSink{name: "slow", release: make(chan struct{})}
+	time.AfterFunc(100*time.Millisecond, func() { close(slow.release) })
+	assert.NoError(t, global.flushMetricSink(context.Background(), slow, nil),
+		"the sink's override should give it longer to flush")
+}
+
+func TestFlushShadowMetricSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	config.DeadLetterDirectory = dir
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+	flaky := &flakyMetricSink{}
+	hung := &hungMetricSink{name: "hung", release: make(chan struct{})}
+	defer close(hung.release)
+	global.metricSinks = append(global.metricSinks, flaky, hung)
+	global.shadowMetricSinks = map[string]bool{"flaky": true, "hung": true}
+
+	global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+		MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: counterTypeName},
+		Value:      1.0,
+		SampleRate: 1.0,
+		Scope:      samplers.MixedScope,
+	})
+	start := time.Now()
+	global.Flush(context.Background())
+	assert.True(t, time.Since(start) < time.Second, "the flush shouldn't wait for the shadow sinks")
+	select {
+	case results := <-rcv:
+		assert.Len(t, results, 1, "the other sinks should still flush")
+	default:
+		t.Fatal("the other sinks should have flushed")
+	}
+	assert.Eventually(t, func() bool {
+		return len(flaky.names()) == 1
+	}, 5*time.Second, 10*time.Millisecond, "the shadow sink should get the same metrics")
+
+	flaky.setDown(true)
+	global.flushShadowMetricSink(context.Background(), flaky, []samplers.InterMetric{{Name: "d.e.f"}}, time.Minute)
+	result, err := global.ReplayDeadLetters(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, result.Batches, "the shadow sink's failures shouldn't be dead-lettered")
+}
+
+func TestApplyGlobalTags(t *testing.T) {
+	globalTags := []string{"region:us-west-2", "cluster:northwest", "canary"}
+	tests := []struct {
+		name string
+		tags []string
+		want []string
+	}{
+		{"untagged", nil, []string{"region:us-west-2", "cluster:northwest", "canary"}},
+		{"other tags", []string{"foo:bar"}, []string{"foo:bar", "region:us-west-2", "cluster:northwest", "canary"}},
+		{"client sets the key", []string{"region:eu-central-1"}, []string{"region:eu-central-1", "cluster:northwest", "canary"}},
+		{"client sets the key with no value", []string{"cluster"}, []string{"cluster", "region:us-west-2", "canary"}},
+		{"client sets a value for a valueless tag", []string{"canary:false"}, []string{"canary:false", "region:us-west-2", "cluster:northwest"}},
+		{"prefix of a key", []string{"regional:yes"}, []string{"regional:yes", "region:us-west-2", "cluster:northwest", "canary"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metrics := []samplers.InterMetric{{Name: "a.b.c", Tags: test.tags}}
+			applyGlobalTags(metrics, globalTags)
+			assert.Equal(t, test.want, metrics[0].Tags)
+		})
+	}
+}
+
+func TestApplyGlobalTagsDoesNotAlias(t *testing.T) {
+	shared := make([]string, 1, 10)
+	shared[0] = "foo:bar"
+	metrics := []samplers.InterMetric{{Name: "a", Tags: shared}, {Name: "b", Tags: shared[:1]}}
+	applyGlobalTags(metrics, []string{"region:us-west-2"})
+	metrics[0].Tags[1] = "region:changed"
+	assert.Equal(t, []string{"foo:bar", "region:us-west-2"}, metrics[1].Tags)
+}
+
+func TestFlushGlobalTags(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	config.GlobalTags = []string{"region:us-west-2", ""}
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	for _, tags := range [][]string{{"foo:bar"}, {"region:eu-central-1"}} {
+		global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: counterTypeName, JoinedTags: strings.Join(tags, ",")},
+			Value:      1.0,
+			SampleRate: 1.0,
+			Tags:       tags,
+			Scope:      samplers.MixedScope,
+		})
+	}
+	global.Flush(context.Background())
+
+	results := <-rcv
+	var tags [][]string
+	for _, m := range results {
+		tags = append(tags, m.Tags)
+	}
+	assert.ElementsMatch(t, [][]string{{"foo:bar", "region:us-west-2"}, {"region:eu-central-1"}}, tags)
+}
+
+func TestGlobalTagsInvalid(t *testing.T) {
+	for _, globalTags := range [][]string{{":foo"}, {"region:a", "region:b"}} {
+		config := localConfig()
+		config.GlobalTags = globalTags
+		_, err := NewFromConfig(logrus.New(), config)
+		assert.Error(t, err, "global tags %v should be invalid", globalTags)
+	}
+}
@@ -0,0 +1,89 @@
+package veneur
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/stripe/veneur/v14/trace"
+)
+
+// regularFlush stands for the metrics that aren't in a flush group, and
+// are flushed every interval.
+const regularFlush = -1
+
+// flushGroupPattern assigns the metrics whose names match pattern to a
+// flush group, by its index in Server.flushIntervals, or to none if the
+// group is regularFlush.
+type flushGroupPattern struct {
+	pattern string
+	group   int
+}
+
+// parseFlushGroups returns the intervals of the flush groups that
+// fast_flush_metrics and metric_flush_intervals ask for, one for each
+// distinct interval other than the server's, and the patterns that
+// assign metrics to them, in the order they're matched.
+func parseFlushGroups(conf Config, interval time.Duration) ([]time.Duration, []flushGroupPattern, error) {
+	var intervals []time.Duration
+	var patterns []flushGroupPattern
+	add := func(pattern string, groupInterval time.Duration) {
+		group := regularFlush
+		if groupInterval != interval {
+			for i, other := range intervals {
+				if other == groupInterval {
+					group = i
+				}
+			}
+			if group == regularFlush {
+				group = len(intervals)
+				intervals = append(intervals, groupInterval)
+			}
+		}
+		patterns = append(patterns, flushGroupPattern{pattern: pattern, group: group})
+	}
+
+	for i, pattern := range conf.FastFlushMetrics {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, nil, fmt.Errorf("fast_flush_metrics[%d]: invalid metric pattern %q: %v", i, pattern, err)
+		}
+	}
+	if len(conf.FastFlushMetrics) > 0 {
+		fastFlushInterval, err := time.ParseDuration(conf.FastFlushInterval)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid fast_flush_interval: %v", err)
+		}
+		if fastFlushInterval <= 0 || fastFlushInterval >= interval {
+			return nil, nil, fmt.Errorf("fast_flush_interval (%v) must be positive and shorter than the interval (%v)", fastFlushInterval, interval)
+		}
+		for _, pattern := range conf.FastFlushMetrics {
+			add(pattern, fastFlushInterval)
+		}
+	}
+
+	for i, override := range conf.MetricFlushIntervals {
+		if _, err := path.Match(override.Metric, ""); err != nil {
+			return nil, nil, fmt.Errorf("metric_flush_intervals[%d]: invalid metric pattern %q: %v", i, override.Metric, err)
+		}
+		groupInterval, err := time.ParseDuration(override.Interval)
+		if err != nil {
+			return nil, nil, fmt.Errorf("metric_flush_intervals[%d]: invalid interval %q: %v", i, override.Interval, err)
+		}
+		if groupInterval <= 0 {
+			return nil, nil, fmt.Errorf("metric_flush_intervals[%d]: the interval (%v) must be positive", i, groupInterval)
+		}
+		add(override.Metric, groupInterval)
+	}
+	return intervals, patterns, nil
+}
+
+// flushGroup collects the metrics of a flush group, and passes them to
+// sinks.
+func (s *Server) flushGroup(ctx context.Context, group int) {
+	span := tracer.StartSpan("flush_group").(*trace.Span)
+	span.SetTag("interval", s.flushIntervals[group].String())
+	defer span.ClientFinish(s.TraceClient)
+
+	s.flushMetrics(ctx, span, group)
+}
@@ -0,0 +1,183 @@
+package veneur
+
+import (
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks/datadog"
+)
+
+func addFlushInterval(config *Config, metric, interval string) {
+	config.MetricFlushIntervals = append(config.MetricFlushIntervals, struct {
+		Metric   string `yaml:"metric"`
+		Interval string `yaml:"interval"`
+	}{Metric: metric, Interval: interval})
+}
+
+func TestParseFlushGroups(t *testing.T) {
+	config := localConfig()
+	config.FastFlushInterval = "1s"
+	config.FastFlushMetrics = []string{"fast.*"}
+	addFlushInterval(&config, "slow.important.*", "10s")
+	addFlushInterval(&config, "slow.*", "1m")
+	addFlushInterval(&config, "fresh.*", "1s")
+	addFlushInterval(&config, "hourly.*", "1h")
+
+	intervals, patterns, err := parseFlushGroups(config, 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{time.Second, time.Minute, time.Hour}, intervals)
+	assert.Equal(t, []flushGroupPattern{
+		{pattern: "fast.*", group: 0},
+		{pattern: "slow.important.*", group: regularFlush},
+		{pattern: "slow.*", group: 1},
+		{pattern: "fresh.*", group: 0},
+		{pattern: "hourly.*", group: 2},
+	}, patterns)
+}
+
+func TestParseFlushGroupsInvalid(t *testing.T) {
+	for name, interval := range map[string]string{
+		"invalid pattern":  "",
+		"invalid interval": "soon",
+		"zero interval":    "0s",
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := localConfig()
+			if interval == "" {
+				addFlushInterval(&config, "a.[", "1m")
+			} else {
+				addFlushInterval(&config, "a.*", interval)
+			}
+			_, err := NewFromConfig(logrus.New(), config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFlushGroupLongerInterval(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "10s"
+	addFlushInterval(&config, "slow.*", "24h")
+	config.CounterSinkOverrides = append(config.CounterSinkOverrides, struct {
+		Sink string `yaml:"sink"`
+		Mode string `yaml:"mode"`
+	}{Sink: "channel", Mode: "rate"})
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	process := func(name string, value float64) {
+		global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: name, Type: counterTypeName},
+			Value:      value,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+	}
+	flushed := func(flush func(context.Context)) map[string]float64 {
+		flush(context.Background())
+		select {
+		case results := <-rcv:
+			values := map[string]float64{}
+			for _, m := range results {
+				values[m.Name] = m.Value
+			}
+			return values
+		case <-time.After(time.Second * 5):
+			t.Fatal("timed out waiting for global veneur flush")
+			return nil
+		}
+	}
+
+	process("fast.a", 10)
+	process("slow.a", 3600)
+	assert.Equal(t, map[string]float64{"fast.a": 1}, flushed(global.Flush))
+
+	// the slow counter keeps counting over the regular flushes, for its
+	// whole interval
+	process("fast.a", 20)
+	process("slow.a", 3600*23)
+	assert.Equal(t, map[string]float64{"fast.a": 2}, flushed(global.Flush))
+	assert.Equal(t, map[string]float64{"slow.a": 1}, flushed(func(ctx context.Context) {
+		global.flushGroup(ctx, 0)
+	}), "the rate should be over the group's interval")
+}
+
+func TestFlushGroupDatadogRates(t *testing.T) {
+	series := make(chan []datadog.DDMetric, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/series" {
+			return
+		}
+		zr, err := zlib.NewReader(r.Body)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(zr)
+		require.NoError(t, err)
+		var req struct {
+			Series []datadog.DDMetric `json:"series"`
+		}
+		require.NoError(t, json.Unmarshal(body, &req))
+		series <- req.Series
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	config := globalConfig()
+	config.Interval = "10s"
+	addFlushInterval(&config, "slow.*", "1m")
+	sink, err := datadog.NewDatadogMetricSink(10, 2500, "example.com", nil, srv.URL, "secret", &http.Client{}, logrus.New(), nil, nil)
+	require.NoError(t, err)
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	process := func(name string, value float64) {
+		global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: name, Type: counterTypeName},
+			Value:      value,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+	}
+	received := func() map[string]datadog.DDMetric {
+		select {
+		case ms := <-series:
+			byName := map[string]datadog.DDMetric{}
+			for _, m := range ms {
+				byName[m.Name] = m
+			}
+			return byName
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the Datadog flush")
+			return nil
+		}
+	}
+
+	process("fast.a", 10)
+	process("slow.a", 60)
+	global.Flush(context.Background())
+	regular := received()
+	require.Contains(t, regular, "fast.a")
+	assert.Equal(t, 1.0, regular["fast.a"].Value[0][1])
+	assert.Equal(t, int32(10), regular["fast.a"].Interval)
+
+	global.flushGroup(context.Background(), 0)
+	slow := received()
+	require.Contains(t, slow, "slow.a")
+	assert.Equal(t, 1.0, slow["slow.a"].Value[0][1], "the rate should be over the group's interval")
+	assert.Equal(t, int32(60), slow["slow.a"].Interval)
+}
@@ -8,8 +8,13 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/forwardrpc"
+	"github.com/stripe/veneur/v14/importsrv"
 	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/samplers/metricpb"
 	"github.com/stripe/veneur/v14/sinks"
+	"google.golang.org/grpc"
 )
 
 const (
@@ -190,6 +195,20 @@ func TestE2EForwardingGRPCMetrics(t *testing.T) {
 	for _, metric := range input {
 		ff.IngestMetric(metric)
 	}
+	done := expectForwardedGRPCTestMetrics(t, ch)
+	ff.local.Flush(context.TODO())
+	ff.global.Flush(context.TODO())
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Timed out waiting for a metric after 3 seconds")
+	}
+}
+
+// expectForwardedGRPCTestMetrics reads flushed metrics from ch until all
+// the metrics that forwardGRPCTestMetrics should result in have been
+// flushed, and then closes the returned channel.
+func expectForwardedGRPCTestMetrics(t *testing.T, ch <-chan []samplers.InterMetric) <-chan struct{} {
 	done := make(chan struct{})
 	go func() {
 		defer close(done)
@@ -246,11 +265,134 @@ func TestE2EForwardingGRPCMetrics(t *testing.T) {
 		}
 
 	}()
-	ff.local.Flush(context.TODO())
-	ff.global.Flush(context.TODO())
+	return done
+}
+
+// TestE2EForwardingGRPCStreamMetrics forwards the same metrics as
+// TestE2EForwardingGRPCMetrics, directly to the global Veneur over the
+// ForwardStream service.
+func TestE2EForwardingGRPCStreamMetrics(t *testing.T) {
+	ch := make(chan []samplers.InterMetric)
+	sink, _ := NewChannelMetricSink(ch)
+
+	globalCfg := globalConfig()
+	globalCfg.GrpcAddress = unusedLocalTCPAddress(t)
+	global := setupVeneurServer(t, globalCfg, nil, sink, nil, nil)
+	go func() {
+		global.Serve()
+	}()
+	defer global.Shutdown()
+	waitForHTTPStart(t, global, 3*time.Second)
+
+	localCfg := localConfig()
+	localCfg.ForwardAddress = ""
+	localCfg.ForwardGrpcAddress = globalCfg.GrpcAddress
+	local := setupVeneurServer(t, localCfg, nil, nil, nil, nil)
+	defer local.Shutdown()
+	require.True(t, local.IsLocal(), "a veneur with a gRPC forward address should be local")
+	require.NotNil(t, local.grpcForwardStream)
+
+	for _, metric := range forwardGRPCTestMetrics() {
+		local.Workers[0].ProcessMetric(metric)
+	}
+	done := expectForwardedGRPCTestMetrics(t, ch)
+	local.Flush(context.TODO())
+	global.Flush(context.TODO())
 	select {
 	case <-done:
 	case <-time.After(3 * time.Second):
 		t.Fatal("Timed out waiting for a metric after 3 seconds")
 	}
 }
+
+func TestForwardStreamReconnects(t *testing.T) {
+	defer func(backoff time.Duration) { forwardStreamMinBackoff = backoff }(forwardStreamMinBackoff)
+	forwardStreamMinBackoff = 100 * time.Millisecond
+
+	addr := unusedLocalTCPAddress(t)
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	fs := newForwardStream(conn)
+	defer fs.Close()
+
+	metrics := []*metricpb.Metric{{Name: "test.counter", Type: metricpb.Type_Counter}}
+	require.Error(t, fs.send(context.Background(), metrics), "nothing is listening yet")
+	err = fs.send(context.Background(), metrics)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reconnecting", "sends during the backoff shouldn't try to connect")
+
+	ingester := &channelMetricIngester{metrics: make(chan []*metricpb.Metric, 10)}
+	srv := importsrv.New([]importsrv.MetricIngester{ingester})
+	ln, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	go srv.Server.Serve(ln)
+	defer srv.Stop()
+
+	assert.Eventually(t, func() bool {
+		return fs.send(context.Background(), metrics) == nil
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Equal(t, metrics[0].Name, (<-ingester.metrics)[0].Name)
+
+	// Sends on the open stream again:
+	require.NoError(t, fs.send(context.Background(), metrics))
+	assert.Equal(t, metrics[0].Name, (<-ingester.metrics)[0].Name)
+}
+
+// ackOnceStreamServer acknowledges the first batch of each stream, and
+// closes the stream without acknowledging the second one.
+type ackOnceStreamServer struct {
+	batches chan []*metricpb.Metric
+}
+
+func (s *ackOnceStreamServer) SendMetricBatches(stream forwardrpc.ForwardStream_SendMetricBatchesServer) error {
+	for i := 0; ; i++ {
+		batch, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		s.batches <- batch.Metrics
+		if i > 0 {
+			return nil
+		}
+		if err := stream.Send(&forwardrpc.MetricListAck{Accepted: uint32(len(batch.Metrics))}); err != nil {
+			return err
+		}
+	}
+}
+
+func TestForwardStreamDoesNotResendSentBatches(t *testing.T) {
+	defer func(backoff time.Duration) { forwardStreamMinBackoff = backoff }(forwardStreamMinBackoff)
+	forwardStreamMinBackoff = time.Millisecond
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &ackOnceStreamServer{batches: make(chan []*metricpb.Metric, 10)}
+	srv := grpc.NewServer()
+	forwardrpc.RegisterForwardStreamServer(srv, server)
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	fs := newForwardStream(conn)
+	defer fs.Close()
+
+	metrics := []*metricpb.Metric{{Name: "test.counter", Type: metricpb.Type_Counter}}
+	require.NoError(t, fs.send(context.Background(), metrics))
+	assert.Len(t, server.batches, 1)
+
+	// The batch is received on the open stream, which then closes
+	// without acknowledging it.
+	assert.Error(t, fs.send(context.Background(), metrics))
+	assert.Len(t, server.batches, 2, "a batch that was received mustn't be sent again")
+}
+
+type channelMetricIngester struct {
+	metrics chan []*metricpb.Metric
+}
+
+func (mi *channelMetricIngester) IngestMetrics(ms []*metricpb.Metric) {
+	mi.metrics <- ms
+}
@@ -0,0 +1,163 @@
+package veneur
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/stripe/veneur/v14/forwardrpc"
+	"github.com/stripe/veneur/v14/samplers/metricpb"
+)
+
+// forwardStreamBatchSize is the most metrics that are sent in one message
+// on the forwarding stream, which keeps messages well below gRPC's
+// default limit of 4MB.
+const forwardStreamBatchSize = 500
+
+// The delay before re-opening the forwarding stream after it broke starts
+// at forwardStreamMinBackoff, and doubles with each consecutive failure
+// up to forwardStreamMaxBackoff.
+var (
+	forwardStreamMinBackoff = 250 * time.Millisecond
+	forwardStreamMaxBackoff = 30 * time.Second
+)
+
+// forwardStream forwards metrics to a global Veneur over a single
+// long-lived ForwardStream stream. If the stream breaks, it's re-opened
+// on the next flush, once the backoff delay has passed; flushes during
+// that delay fail without trying to connect.
+type forwardStream struct {
+	conn *grpc.ClientConn
+
+	mtx         sync.Mutex
+	stream      forwardrpc.ForwardStream_SendMetricBatchesClient
+	closeStream func()
+	backoff     time.Duration
+	retryAt     time.Time
+}
+
+func newForwardStream(conn *grpc.ClientConn) *forwardStream {
+	return &forwardStream{conn: conn}
+}
+
+// send forwards metrics in batches, and waits for each batch to be
+// acknowledged, or for ctx to be done.
+func (fs *forwardStream) send(ctx context.Context, metrics []*metricpb.Metric) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	reused := fs.stream != nil
+	if !reused {
+		if wait := time.Until(fs.retryAt); wait > 0 {
+			return fmt.Errorf("the forwarding stream is reconnecting in %v", wait.Round(time.Millisecond))
+		}
+		if err := fs.open(); err != nil {
+			fs.fail()
+			return err
+		}
+	}
+	for i := 0; i < len(metrics); i += forwardStreamBatchSize {
+		end := i + forwardStreamBatchSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+		sent, err := fs.sendBatch(ctx, metrics[i:end])
+		if err != nil && !sent && reused && ctx.Err() == nil {
+			// The global Veneur may have closed the stream since
+			// the last flush, e.g. because it restarted, so retry
+			// once on a new stream. Batches that were sent aren't
+			// retried, even if they weren't acknowledged, since
+			// the global Veneur may have merged them already.
+			fs.close()
+			if err = fs.open(); err == nil {
+				_, err = fs.sendBatch(ctx, metrics[i:end])
+			}
+		}
+		if err != nil {
+			fs.fail()
+			return err
+		}
+		reused = false
+	}
+	fs.backoff = 0
+	return nil
+}
+
+// open opens the stream.
+func (fs *forwardStream) open() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := forwardrpc.NewForwardStreamClient(fs.conn).SendMetricBatches(ctx)
+	if err != nil {
+		cancel()
+		return err
+	}
+	fs.stream = stream
+	fs.closeStream = cancel
+	return nil
+}
+
+// sendBatch sends a batch on the stream and waits for its
+// acknowledgement. It reports whether the batch was sent, even if it
+// wasn't acknowledged.
+func (fs *forwardStream) sendBatch(ctx context.Context, batch []*metricpb.Metric) (bool, error) {
+	stream := fs.stream
+	sendErr := make(chan error, 1)
+	acked := make(chan error, 1)
+	go func() {
+		if err := stream.Send(&forwardrpc.MetricList{Metrics: batch}); err != nil {
+			sendErr <- err
+			return
+		}
+		sendErr <- nil
+		_, err := stream.Recv()
+		acked <- err
+	}()
+
+	select {
+	case err := <-sendErr:
+		if err != nil {
+			return false, err
+		}
+	case <-ctx.Done():
+		// Closing the stream makes the goroutine above return.
+		return false, ctx.Err()
+	}
+	select {
+	case err := <-acked:
+		return true, err
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+}
+
+// fail closes the stream, if it's open, and delays the next attempt to
+// open it.
+func (fs *forwardStream) fail() {
+	fs.close()
+	if fs.backoff == 0 {
+		fs.backoff = forwardStreamMinBackoff
+	} else if fs.backoff *= 2; fs.backoff > forwardStreamMaxBackoff {
+		fs.backoff = forwardStreamMaxBackoff
+	}
+	fs.retryAt = time.Now().Add(fs.backoff)
+}
+
+// close closes the stream, if it's open.
+func (fs *forwardStream) close() {
+	if fs.stream == nil {
+		return
+	}
+	fs.closeStream()
+	fs.stream = nil
+	fs.closeStream = nil
+}
+
+// Close closes the stream.
+func (fs *forwardStream) Close() {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	fs.close()
+}
@@ -0,0 +1,124 @@
+package veneur
+
+import (
+	"path"
+	"sync"
+)
+
+// maxForwardFilterNames bounds the decisions that a forwardFilter
+// caches: once it has this many, it forgets them all and starts over.
+const maxForwardFilterNames = 100000
+
+// forwardFilter decides which metrics a local veneur forwards to the
+// global one, by name. The metrics that it doesn't forward are flushed
+// in their entirety by the local veneur instead.
+type forwardFilter struct {
+	allow []string
+	deny  []string
+
+	mtx sync.Mutex
+	// the decision for each name that was seen, up to
+	// maxForwardFilterNames of them
+	names map[string]bool
+}
+
+// newForwardFilter returns a filter that forwards the metrics whose
+// names match one of the allow patterns, or any metric if there are
+// none, unless they match one of the deny patterns. It returns nil if
+// every metric is forwarded.
+func newForwardFilter(allow, deny []string) *forwardFilter {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+	return &forwardFilter{allow: allow, deny: deny, names: map[string]bool{}}
+}
+
+// forwards returns true if the metric with the given name is forwarded.
+func (f *forwardFilter) forwards(name string) bool {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if forwarded, ok := f.names[name]; ok {
+		return forwarded
+	}
+	// patterns are validated when the server is created
+	forwarded := len(f.allow) == 0
+	for _, pattern := range f.allow {
+		if ok, _ := path.Match(pattern, name); ok {
+			forwarded = true
+			break
+		}
+	}
+	for _, pattern := range f.deny {
+		if !forwarded {
+			break
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			forwarded = false
+		}
+	}
+	if len(f.names) >= maxForwardFilterNames {
+		f.names = map[string]bool{}
+	}
+	f.names[name] = forwarded
+	return forwarded
+}
+
+// keepLocal moves the metrics of wm that aren't forwarded to the maps of
+// the metrics that are flushed locally, and returns how many series are
+// left to forward, and how many were moved. A metric stays where it is
+// if a local-only metric already has its key.
+func (f *forwardFilter) keepLocal(wm *WorkerMetrics) (forwarded, local int) {
+	for key, h := range wm.histograms {
+		if _, ok := wm.localHistograms[key]; !ok && !f.forwards(key.Name) {
+			wm.localHistograms[key] = h
+			delete(wm.histograms, key)
+			local++
+		}
+	}
+	for key, h := range wm.globalHistograms {
+		if _, ok := wm.localHistograms[key]; !ok && !f.forwards(key.Name) {
+			wm.localHistograms[key] = h
+			delete(wm.globalHistograms, key)
+			local++
+		}
+	}
+	for key, t := range wm.timers {
+		if _, ok := wm.localTimers[key]; !ok && !f.forwards(key.Name) {
+			wm.localTimers[key] = t
+			delete(wm.timers, key)
+			local++
+		}
+	}
+	for key, t := range wm.globalTimers {
+		if _, ok := wm.localTimers[key]; !ok && !f.forwards(key.Name) {
+			wm.localTimers[key] = t
+			delete(wm.globalTimers, key)
+			local++
+		}
+	}
+	for key, s := range wm.sets {
+		if _, ok := wm.localSets[key]; !ok && !f.forwards(key.Name) {
+			wm.localSets[key] = s
+			delete(wm.sets, key)
+			local++
+		}
+	}
+	for key, c := range wm.globalCounters {
+		if _, ok := wm.counters[key]; !ok && !f.forwards(key.Name) {
+			wm.counters[key] = c
+			delete(wm.globalCounters, key)
+			local++
+		}
+	}
+	for key, g := range wm.globalGauges {
+		if _, ok := wm.gauges[key]; !ok && !f.forwards(key.Name) {
+			wm.gauges[key] = g
+			delete(wm.globalGauges, key)
+			local++
+		}
+	}
+	forwarded = len(wm.histograms) + len(wm.globalHistograms) +
+		len(wm.timers) + len(wm.globalTimers) + len(wm.sets) +
+		len(wm.globalCounters) + len(wm.globalGauges)
+	return forwarded, local
+}
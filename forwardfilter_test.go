@@ -0,0 +1,63 @@
+package veneur
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func TestForwardFilterForwards(t *testing.T) {
+	assert.Nil(t, newForwardFilter(nil, nil))
+
+	f := newForwardFilter([]string{"api.*"}, []string{"api.debug.*"})
+	assert.True(t, f.forwards("api.latency"))
+	assert.False(t, f.forwards("api.debug.latency"))
+	assert.False(t, f.forwards("db.latency"), "only allowed metrics are forwarded")
+
+	f = newForwardFilter(nil, []string{"local.*"})
+	assert.True(t, f.forwards("api.latency"))
+	assert.False(t, f.forwards("local.latency"))
+	assert.False(t, f.forwards("local.latency"), "the decision should be cached")
+}
+
+func TestForwardFilterBounded(t *testing.T) {
+	f := newForwardFilter(nil, []string{"local.*"})
+	for i := 0; i < maxForwardFilterNames+10; i++ {
+		assert.True(t, f.forwards(fmt.Sprintf("api.%d", i)))
+	}
+	assert.True(t, len(f.names) <= maxForwardFilterNames, "the filter cached %d names", len(f.names))
+	assert.False(t, f.forwards("local.latency"))
+}
+
+func TestForwardFilterKeepLocal(t *testing.T) {
+	wm := NewWorkerMetrics()
+	for _, name := range []string{"fwd.h", "local.h"} {
+		key := samplers.MetricKey{Name: name, Type: histogramTypeName}
+		wm.histograms[key] = samplers.NewHist(name, nil)
+	}
+	setKey := samplers.MetricKey{Name: "local.s", Type: setTypeName}
+	wm.sets[setKey] = samplers.NewSet("local.s", nil)
+	counterKey := samplers.MetricKey{Name: "local.c", Type: counterTypeName}
+	wm.globalCounters[counterKey] = samplers.NewCounter("local.c", nil)
+
+	forwarded, local := newForwardFilter(nil, []string{"local.*"}).keepLocal(&wm)
+	assert.Equal(t, 1, forwarded)
+	assert.Equal(t, 3, local)
+	assert.Contains(t, wm.histograms, samplers.MetricKey{Name: "fwd.h", Type: histogramTypeName})
+	assert.Contains(t, wm.localHistograms, samplers.MetricKey{Name: "local.h", Type: histogramTypeName})
+	assert.Contains(t, wm.localSets, setKey)
+	assert.Contains(t, wm.counters, counterKey)
+	assert.Empty(t, wm.sets)
+	assert.Empty(t, wm.globalCounters)
+}
+
+func TestForwardMetricsDenyInvalid(t *testing.T) {
+	config := localConfig()
+	config.ForwardMetricsDeny = []string{"["}
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+}
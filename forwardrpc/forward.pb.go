@@ -70,14 +70,61 @@ func (m *MetricList) GetMetrics() []*metricpb.Metric {
 	return nil
 }
 
+// MetricListAck acknowledges a MetricList sent over the SendMetricBatches
+// stream, with the number of metrics in it that were accepted.
+type MetricListAck struct {
+	Accepted uint32 `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+}
+
+func (m *MetricListAck) Reset()         { *m = MetricListAck{} }
+func (m *MetricListAck) String() string { return proto.CompactTextString(m) }
+func (*MetricListAck) ProtoMessage()    {}
+func (*MetricListAck) Descriptor() ([]byte, []int) {
+	return fileDescriptor_0f9bdf2b06f7b9ea, []int{1}
+}
+func (m *MetricListAck) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *MetricListAck) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_MetricListAck.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *MetricListAck) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MetricListAck.Merge(m, src)
+}
+func (m *MetricListAck) XXX_Size() int {
+	return m.Size()
+}
+func (m *MetricListAck) XXX_DiscardUnknown() {
+	xxx_messageInfo_MetricListAck.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MetricListAck proto.InternalMessageInfo
+
+func (m *MetricListAck) GetAccepted() uint32 {
+	if m != nil {
+		return m.Accepted
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*MetricList)(nil), "forwardrpc.MetricList")
+	proto.RegisterType((*MetricListAck)(nil), "forwardrpc.MetricListAck")
 }
 
 func init() { proto.RegisterFile("forwardrpc/forward.proto", fileDescriptor_0f9bdf2b06f7b9ea) }
 
 var fileDescriptor_0f9bdf2b06f7b9ea = []byte{
-	// 202 bytes of a gzipped FileDescriptorProto
+	// 268 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0x92, 0x48, 0xcb, 0x2f, 0x2a,
 	0x4f, 0x2c, 0x4a, 0x29, 0x2a, 0x48, 0xd6, 0x87, 0x32, 0xf5, 0x0a, 0x8a, 0xf2, 0x4b, 0xf2, 0x85,
 	0xb8, 0x10, 0x32, 0x52, 0x72, 0xc5, 0x89, 0xb9, 0x05, 0x39, 0xa9, 0x45, 0xc5, 0xfa, 0xb9, 0xa9,
@@ -85,12 +132,16 @@ var fileDescriptor_0f9bdf2b06f7b9ea = []byte{
 	0x4e, 0xaa, 0x3e, 0x98, 0x97, 0x54, 0x9a, 0xa6, 0x9f, 0x9a, 0x5b, 0x50, 0x52, 0x09, 0x91, 0x54,
 	0xb2, 0xe0, 0xe2, 0xf2, 0x05, 0x2b, 0xf6, 0xc9, 0x2c, 0x2e, 0x11, 0xd2, 0xe2, 0x62, 0x87, 0x68,
 	0x2d, 0x96, 0x60, 0x54, 0x60, 0xd6, 0xe0, 0x36, 0x12, 0xd0, 0x83, 0x99, 0xa9, 0x07, 0x51, 0x16,
-	0x04, 0x53, 0x60, 0xe4, 0xc5, 0xc5, 0xee, 0x06, 0x71, 0x84, 0x90, 0x3d, 0x17, 0x77, 0x70, 0x6a,
-	0x5e, 0x0a, 0x44, 0x45, 0xb1, 0x90, 0x98, 0x1e, 0xc2, 0x75, 0x7a, 0x08, 0xd3, 0xa5, 0xc4, 0xf4,
-	0x20, 0x2e, 0xd1, 0x83, 0xb9, 0x44, 0xcf, 0x15, 0xe4, 0x12, 0x25, 0x06, 0x27, 0x89, 0x13, 0x8f,
-	0xe4, 0x18, 0x2f, 0x3c, 0x92, 0x63, 0x7c, 0xf0, 0x48, 0x8e, 0x71, 0xc2, 0x63, 0x39, 0x86, 0x0b,
-	0x8f, 0xe5, 0x18, 0x6e, 0x3c, 0x96, 0x63, 0x48, 0x62, 0x03, 0xab, 0x35, 0x06, 0x04, 0x00, 0x00,
-	0xff, 0xff, 0xa5, 0x18, 0xbe, 0xc7, 0x0b, 0x01, 0x00, 0x00,
+	0x04, 0x53, 0xa0, 0xa4, 0xcd, 0xc5, 0x8b, 0xd0, 0xe9, 0x98, 0x9c, 0x2d, 0x24, 0xc5, 0xc5, 0x91,
+	0x98, 0x9c, 0x9c, 0x5a, 0x50, 0x92, 0x9a, 0x22, 0xc1, 0xa8, 0xc0, 0xa8, 0xc1, 0x1b, 0x04, 0xe7,
+	0x1b, 0x79, 0x71, 0xb1, 0xbb, 0x41, 0x5c, 0x2c, 0x64, 0xcf, 0xc5, 0x1d, 0x9c, 0x9a, 0x97, 0x02,
+	0xd1, 0x5b, 0x2c, 0x24, 0xa6, 0x87, 0xf0, 0x8a, 0x1e, 0xc2, 0x40, 0x29, 0x31, 0x3d, 0x88, 0xb3,
+	0xf5, 0x60, 0xce, 0xd6, 0x73, 0x05, 0x39, 0x5b, 0x89, 0xc1, 0x28, 0x96, 0x8b, 0x17, 0x6a, 0x56,
+	0x70, 0x49, 0x51, 0x6a, 0x62, 0xae, 0x90, 0x0f, 0x97, 0x20, 0xc2, 0x44, 0xa7, 0xc4, 0x92, 0xe4,
+	0x8c, 0x54, 0xdc, 0xe6, 0x4a, 0x62, 0x17, 0x77, 0x4c, 0xce, 0x56, 0x62, 0xd0, 0x60, 0x34, 0x60,
+	0x74, 0x92, 0x38, 0xf1, 0x48, 0x8e, 0xf1, 0xc2, 0x23, 0x39, 0xc6, 0x07, 0x8f, 0xe4, 0x18, 0x27,
+	0x3c, 0x96, 0x63, 0xb8, 0xf0, 0x58, 0x8e, 0xe1, 0xc6, 0x63, 0x39, 0x86, 0x24, 0x36, 0xb0, 0x53,
+	0x8c, 0x01, 0x03, 0x00, 0xf3, 0xa9, 0x83, 0xc1, 0x97, 0x01, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -167,6 +218,106 @@ var _Forward_serviceDesc = grpc.ServiceDesc{
 	Metadata: "forwardrpc/forward.proto",
 }
 
+// ForwardStreamClient is the client API for ForwardStream service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type ForwardStreamClient interface {
+	// SendMetricBatches acknowledges each batch once its metrics have been
+	// handed to the workers.
+	SendMetricBatches(ctx context.Context, opts ...grpc.CallOption) (ForwardStream_SendMetricBatchesClient, error)
+}
+
+type forwardStreamClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewForwardStreamClient(cc *grpc.ClientConn) ForwardStreamClient {
+	return &forwardStreamClient{cc}
+}
+
+func (c *forwardStreamClient) SendMetricBatches(ctx context.Context, opts ...grpc.CallOption) (ForwardStream_SendMetricBatchesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_ForwardStream_serviceDesc.Streams[0], "/forwardrpc.ForwardStream/SendMetricBatches", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &forwardStreamSendMetricBatchesClient{stream}
+	return x, nil
+}
+
+type ForwardStream_SendMetricBatchesClient interface {
+	Send(*MetricList) error
+	Recv() (*MetricListAck, error)
+	grpc.ClientStream
+}
+
+type forwardStreamSendMetricBatchesClient struct {
+	grpc.ClientStream
+}
+
+func (x *forwardStreamSendMetricBatchesClient) Send(m *MetricList) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *forwardStreamSendMetricBatchesClient) Recv() (*MetricListAck, error) {
+	m := new(MetricListAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ForwardStreamServer is the server API for ForwardStream service.
+type ForwardStreamServer interface {
+	// SendMetricBatches acknowledges each batch once its metrics have been
+	// handed to the workers.
+	SendMetricBatches(ForwardStream_SendMetricBatchesServer) error
+}
+
+func RegisterForwardStreamServer(s *grpc.Server, srv ForwardStreamServer) {
+	s.RegisterService(&_ForwardStream_serviceDesc, srv)
+}
+
+func _ForwardStream_SendMetricBatches_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ForwardStreamServer).SendMetricBatches(&forwardStreamSendMetricBatchesServer{stream})
+}
+
+type ForwardStream_SendMetricBatchesServer interface {
+	Send(*MetricListAck) error
+	Recv() (*MetricList, error)
+	grpc.ServerStream
+}
+
+type forwardStreamSendMetricBatchesServer struct {
+	grpc.ServerStream
+}
+
+func (x *forwardStreamSendMetricBatchesServer) Send(m *MetricListAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *forwardStreamSendMetricBatchesServer) Recv() (*MetricList, error) {
+	m := new(MetricList)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _ForwardStream_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "forwardrpc.ForwardStream",
+	HandlerType: (*ForwardStreamServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SendMetricBatches",
+			Handler:       _ForwardStream_SendMetricBatches_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "forwardrpc/forward.proto",
+}
+
 func (m *MetricList) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -197,6 +348,29 @@ func (m *MetricList) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
+func (m *MetricListAck) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *MetricListAck) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Accepted != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintForward(dAtA, i, uint64(m.Accepted))
+	}
+	return i, nil
+}
+
 func encodeVarintForward(dAtA []byte, offset int, v uint64) int {
 	for v >= 1<<7 {
 		dAtA[offset] = uint8(v&0x7f | 0x80)
@@ -221,6 +395,18 @@ func (m *MetricList) Size() (n int) {
 	return n
 }
 
+func (m *MetricListAck) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Accepted != 0 {
+		n += 1 + sovForward(uint64(m.Accepted))
+	}
+	return n
+}
+
 func sovForward(x uint64) (n int) {
 	for {
 		n++
@@ -321,6 +507,78 @@ func (m *MetricList) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *MetricListAck) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowForward
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MetricListAck: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MetricListAck: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Accepted", wireType)
+			}
+			m.Accepted = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowForward
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Accepted |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipForward(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthForward
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthForward
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipForward(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -1,7 +1,6 @@
 package veneur
 
 import (
-	"compress/zlib"
 	"context"
 	"encoding/json"
 	"errors"
@@ -12,6 +11,7 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+	vhttp "github.com/stripe/veneur/v14/http"
 	"github.com/stripe/veneur/v14/samplers"
 	"github.com/stripe/veneur/v14/ssf"
 	"github.com/stripe/veneur/v14/trace"
@@ -133,27 +133,26 @@ func unmarshalMetricsFromHTTP(ctx context.Context, client *trace.Client, w http.
 
 	innerLogger := log.WithField("client", r.RemoteAddr)
 
-	switch encLogger := innerLogger.WithField("encoding", encoding); encoding {
-	case "":
-		body = r.Body
-		encoding = "identity"
-	case "deflate":
-		body, err = zlib.NewReader(r.Body)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			span.Error(err)
-			encLogger.WithError(err).Error("Could not read compressed request body")
-			span.Add(ssf.Count("import.request_error_total", 1, map[string]string{"cause": "deflate"}))
-			return span, nil, err
-		}
-		defer body.Close()
-	default:
+	encLogger := innerLogger.WithField("encoding", encoding)
+	if encoding == "" {
+		encoding = vhttp.EncodingIdentity
+	}
+	if err = vhttp.ValidateEncoding(encoding); err != nil {
 		http.Error(w, encoding, http.StatusUnsupportedMediaType)
 		span.Error(errors.New("Could not determine content-encoding of request"))
 		encLogger.Error("Could not determine content-encoding of request")
 		span.Add(ssf.Count("import.request_error_total", 1, map[string]string{"cause": "unknown_content_encoding"}))
 		return span, nil, err
 	}
+	body, err = vhttp.NewDecoder(encoding, r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		span.Error(err)
+		encLogger.WithError(err).Error("Could not read compressed request body")
+		span.Add(ssf.Count("import.request_error_total", 1, map[string]string{"cause": encoding}))
+		return span, nil, err
+	}
+	defer body.Close()
 	span.Add(ssf.Count("import.bytes", float32(r.ContentLength), nil))
 
 	if err = json.NewDecoder(body).Decode(&jsonMetrics); err != nil {
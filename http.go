@@ -1,6 +1,7 @@
 package veneur
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/pprof"
 	"sort"
@@ -26,6 +27,22 @@ func (s *Server) Handler() http.Handler {
 		w.Write([]byte("ok\n"))
 	})
 
+	// ingestion is ready once every statsd and SSF listener has bound
+	// its socket, which makes this endpoint suitable as a readiness
+	// probe. The body reports the state of each listener:
+	mux.HandleFunc(pat.Get("/healthcheck/ingestion"), func(w http.ResponseWriter, r *http.Request) {
+		if !s.isIngestionReady() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		for _, l := range s.ingestionListeners {
+			state := "ok"
+			if !l.isBound() {
+				state = "not bound"
+			}
+			fmt.Fprintf(w, "%s %s: %s\n", l.protocol, l.address, state)
+		}
+	})
+
 	mux.HandleFunc(pat.Get("/builddate"), func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(BUILD_DATE))
 	})
@@ -49,6 +66,17 @@ func (s *Server) Handler() http.Handler {
 
 	mux.Handle(pat.Post("/import"), handleImport(s))
 
+	if s.httpIngest {
+		mux.HandleFunc(pat.Post(httpIngestEndpoint), s.handleIngest)
+	}
+	if len(s.cardinality) > 0 {
+		mux.HandleFunc(pat.Get("/debug/cardinality"), s.handleDebugCardinality)
+	}
+	mux.HandleFunc(pat.Get("/debug/metrics/:name"), s.handleDebugMetrics)
+	if s.deadLetter != nil {
+		mux.HandleFunc(pat.Post("/deadletter/replay"), s.handleReplayDeadLetters)
+	}
+
 	mux.Handle(pat.Get("/debug/pprof/cmdline"), http.HandlerFunc(pprof.Cmdline))
 	mux.Handle(pat.Get("/debug/pprof/profile"), http.HandlerFunc(pprof.Profile))
 	mux.Handle(pat.Get("/debug/pprof/symbol"), http.HandlerFunc(pprof.Symbol))
@@ -0,0 +1,74 @@
+package http
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The content encodings that request bodies can be compressed with, as
+// named in the Content-Encoding header.
+const (
+	EncodingIdentity = "identity"
+	EncodingDeflate  = "deflate"
+	EncodingGzip     = "gzip"
+	EncodingZstd     = "zstd"
+)
+
+// ValidateEncoding returns an error if encoding isn't one of the
+// supported content encodings.
+func ValidateEncoding(encoding string) error {
+	switch encoding {
+	case EncodingIdentity, EncodingDeflate, EncodingGzip, EncodingZstd:
+		return nil
+	}
+	return fmt.Errorf("unknown content encoding %q, must be one of %q, %q, %q or %q",
+		encoding, EncodingIdentity, EncodingDeflate, EncodingGzip, EncodingZstd)
+}
+
+// NewEncoder returns a writer that compresses what is written to it with
+// the encoding, into w. It must be closed to flush the compressed bytes.
+func NewEncoder(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case EncodingIdentity:
+		return nopWriteCloser{w}, nil
+	case EncodingDeflate:
+		return zlib.NewWriter(w), nil
+	case EncodingGzip:
+		return gzip.NewWriter(w), nil
+	case EncodingZstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, ValidateEncoding(encoding)
+}
+
+// NewDecoder returns a reader that decompresses r with the encoding. An
+// empty encoding is the same as the identity encoding, as that's what a
+// missing Content-Encoding header means.
+func NewDecoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "", EncodingIdentity:
+		return ioutil.NopCloser(r), nil
+	case EncodingDeflate:
+		return zlib.NewReader(r)
+	case EncodingGzip:
+		return gzip.NewReader(r)
+	case EncodingZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, ValidateEncoding(encoding)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
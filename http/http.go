@@ -2,10 +2,10 @@ package http
 
 import (
 	"bytes"
-	"compress/zlib"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptrace"
@@ -141,6 +141,18 @@ func (tripper *TraceRoundTripper) RoundTrip(req *http.Request) (*http.Response,
 	return tripper.inner.RoundTrip(req)
 }
 
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
 func mergeTags(tags map[string]string, k, v string) map[string]string {
 	ret := make(map[string]string, len(tags)+1)
 	for k, v := range tags {
@@ -157,6 +169,25 @@ func mergeTags(tags map[string]string, k, v string) map[string]string {
 // you can disable compression with compress=false for endpoints that don't
 // support it
 func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client, method string, endpoint string, bodyObject interface{}, action string, compress bool, extraTags map[string]string, log *logrus.Logger) error {
+	encoding := EncodingIdentity
+	if compress {
+		encoding = EncodingDeflate
+	}
+	return PostHelperWithEncoding(ctx, httpClient, tc, method, endpoint, bodyObject, action, encoding, extraTags, log)
+}
+
+// PostHelperWithEncoding is like PostHelper, but compresses the body with
+// the given content encoding. For compressed bodies, it reports the ratio
+// of the JSON's size to the compressed size as action.compression_ratio.
+func PostHelperWithEncoding(ctx context.Context, httpClient *http.Client, tc *trace.Client, method string, endpoint string, bodyObject interface{}, action string, encoding string, extraTags map[string]string, log *logrus.Logger) error {
+	_, err := PostHelperSize(ctx, httpClient, tc, method, endpoint, bodyObject, action, encoding, extraTags, log)
+	return err
+}
+
+// PostHelperSize is like PostHelperWithEncoding, but also returns the size
+// of the body it sent, after compression. The size is returned along with
+// any error that happened once the body was serialized.
+func PostHelperSize(ctx context.Context, httpClient *http.Client, tc *trace.Client, method string, endpoint string, bodyObject interface{}, action string, encoding string, extraTags map[string]string, log *logrus.Logger) (int, error) {
 	span, _ := trace.StartSpanFromContext(ctx, "")
 	span.SetTag("action", action)
 	for k, v := range extraTags {
@@ -168,31 +199,27 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 	innerLogger := log.WithField("action", action)
 
 	marshalStart := time.Now()
-	var (
-		bodyBuffer bytes.Buffer
-		encoder    *json.Encoder
-		compressor *zlib.Writer
-	)
-	if compress {
-		compressor = zlib.NewWriter(&bodyBuffer)
-		encoder = json.NewEncoder(compressor)
-	} else {
-		encoder = json.NewEncoder(&bodyBuffer)
+	var bodyBuffer bytes.Buffer
+	compressor, err := NewEncoder(encoding, &bodyBuffer)
+	if err != nil {
+		span.Error(err)
+		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", "compress")))
+		innerLogger.WithError(err).Error("Could not set up compression")
+		return 0, err
 	}
-	if err := encoder.Encode(bodyObject); err != nil {
+	uncompressed := &countingWriter{w: compressor}
+	if err := json.NewEncoder(uncompressed).Encode(bodyObject); err != nil {
 		span.Error(err)
 		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", "json")))
 		innerLogger.WithError(err).Error("Could not render JSON")
-		return err
+		return 0, err
 	}
-	if compress {
-		// don't forget to flush leftover compressed bytes to the buffer
-		if err := compressor.Close(); err != nil {
-			span.Error(err)
-			span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", "compress")))
-			innerLogger.WithError(err).Error("Could not finalize compression")
-			return err
-		}
+	// don't forget to flush leftover compressed bytes to the buffer
+	if err := compressor.Close(); err != nil {
+		span.Error(err)
+		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", "compress")))
+		innerLogger.WithError(err).Error("Could not finalize compression")
+		return 0, err
 	}
 	span.Add(ssf.Timing(action+".duration_ns", time.Since(marshalStart), time.Nanosecond, mergeTags(extraTags, "part", "json")))
 
@@ -200,19 +227,23 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 	// http client consumes it
 	bodyLength := bodyBuffer.Len()
 	span.Add(ssf.Count(action+".content_length_bytes", float32(bodyLength), nil))
+	if encoding != EncodingIdentity && bodyLength > 0 {
+		span.Add(ssf.Histogram(action+".compression_ratio", float32(uncompressed.n)/float32(bodyLength),
+			mergeTags(extraTags, "encoding", encoding)))
+	}
 
 	req, err := http.NewRequest(method, endpoint, &bodyBuffer)
 	if err != nil {
 		span.Error(err)
 		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", "construct")))
 		innerLogger.WithError(err).Error("Could not construct request")
-		return err
+		return bodyLength, err
 	}
 
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/json")
-	if compress {
-		req.Header.Set("Content-Encoding", "deflate")
+	if encoding != EncodingIdentity {
+		req.Header.Set("Content-Encoding", encoding)
 	}
 
 	err = tracer.InjectRequest(span.Trace, req)
@@ -242,7 +273,7 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 			"host": req.URL.Host,
 			"path": req.URL.Path,
 		}).Warn("Could not execute request")
-		return err
+		return bodyLength, err
 	}
 	defer resp.Body.Close()
 
@@ -268,11 +299,11 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 		span.Error(err)
 		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", strconv.Itoa(resp.StatusCode))))
 		resultLogger.WithError(err).Warn("Could not POST")
-		return err
+		return bodyLength, err
 	}
 
 	// make sure the error metric isn't sparse
 	span.Add(ssf.Count(action+".error_total", 0, nil))
 	resultLogger.Debug("POSTed successfully")
-	return nil
+	return bodyLength, nil
 }
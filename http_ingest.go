@@ -0,0 +1,108 @@
+package veneur
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+const httpIngestEndpoint = "/ingest"
+
+// defaultHTTPIngestMaxBodyBytes is the largest request body the HTTP
+// ingestion endpoint accepts unless http_ingest_max_body_bytes is set.
+const defaultHTTPIngestMaxBodyBytes = 4 * 1024 * 1024
+
+// ingestResult is the response of the HTTP ingestion endpoint.
+type ingestResult struct {
+	Accepted int `json:"accepted"`
+	Rejected int `json:"rejected"`
+}
+
+// handleIngest accepts a batch of metrics in the body of a POST request,
+// and feeds them to the workers just like metrics that arrive on a
+// socket. With a Content-Type of application/json, the body is either a
+// JSON array of metrics, or one JSON metric per line (see
+// samplers.ParseMetricJSON); otherwise, it's one statsd packet per line.
+// It responds with the number of lines that were accepted, and of those
+// that were rejected because they couldn't be parsed.
+func (s *Server) handleIngest(w http.ResponseWriter, r *http.Request) {
+	if s.httpIngestAuthToken != "" {
+		token := []byte("Bearer " + s.httpIngestAuthToken)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), token) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "invalid or missing bearer token", http.StatusUnauthorized)
+			return
+		}
+	}
+	if s.httpIngestRateLimiter != nil {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if !s.httpIngestRateLimiter.allow(net.ParseIP(host), time.Now()) {
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, s.httpIngestMaxBodyBytes+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > s.httpIngestMaxBodyBytes {
+		http.Error(w, fmt.Sprintf("the body must be at most %d bytes", s.httpIngestMaxBodyBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// the client's address, for the logs of lines that can't be parsed
+	var source net.Addr
+	if addr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr); err == nil {
+		source = addr
+	}
+	var result ingestResult
+	count := func(err error) {
+		if err != nil {
+			result.Rejected++
+		} else {
+			result.Accepted++
+		}
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/json" && bytes.HasPrefix(bytes.TrimSpace(body), []byte{'['}):
+		var lines []json.RawMessage
+		if err := json.Unmarshal(body, &lines); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, line := range lines {
+			count(s.handleJSONLine(line, source))
+		}
+	default:
+		lines := samplers.NewSplitBytes(body, '\n')
+		for lines.Next() {
+			line := bytes.TrimSuffix(lines.Chunk(), []byte{'\r'})
+			if len(line) == 0 {
+				continue
+			}
+			if mediaType == "application/json" {
+				count(s.handleJSONLine(line, source))
+			} else {
+				count(s.handleMetricPacket(line, DOGSTATSD_HTTP, nil, source))
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,106 @@
+package veneur
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func postIngest(t *testing.T, url, contentType, token, body string) (int, ingestResult) {
+	req, err := http.NewRequest(http.MethodPost, url+httpIngestEndpoint, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", contentType)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var result ingestResult
+	if resp.StatusCode == http.StatusOK {
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	}
+	return resp.StatusCode, result
+}
+
+func TestHTTPIngest(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	config.HTTPIngestEnabled = true
+	config.HTTPIngestAuthToken = "s3cret"
+	config.HTTPIngestMaxBodyBytes = 1024
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+	srv := httptest.NewServer(global.Handler())
+	defer srv.Close()
+
+	status, _ := postIngest(t, srv.URL, "text/plain", "", "a.b.c:1|c")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = postIngest(t, srv.URL, "text/plain", "wrong", "a.b.c:1|c")
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, result := postIngest(t, srv.URL, "text/plain", "s3cret",
+		"a.b.c:1|c|#foo:bar\r\na.b.c:2|c|@0.5|#foo:bar\n\nnot a metric\n")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, ingestResult{Accepted: 2, Rejected: 1}, result)
+
+	status, result = postIngest(t, srv.URL, "application/json; charset=utf-8", "s3cret",
+		`[{"name": "a.b.c", "type": "counter", "value": 3, "tags": ["foo:bar"]}, {"name": "d.e.f"}]`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, ingestResult{Accepted: 1, Rejected: 1}, result)
+
+	status, result = postIngest(t, srv.URL, "application/json", "s3cret",
+		`{"name": "g.h.i", "type": "gauge", "value": 4}`+"\n"+`{"name": "g.h.i", "type": "gauge", "value": 5}`)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, ingestResult{Accepted: 2}, result)
+
+	status, _ = postIngest(t, srv.URL, "application/json", "s3cret", `[{"name": `)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = postIngest(t, srv.URL, "text/plain", "s3cret", strings.Repeat("a.b.c:1|c\n", 200))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, status)
+
+	for _, w := range global.Workers {
+		w.Sync()
+	}
+	global.Flush(context.Background())
+	values := map[string]float64{}
+	for _, m := range <-rcv {
+		values[m.Name] = m.Value
+		if m.Name == "a.b.c" {
+			assert.Equal(t, []string{"foo:bar"}, m.Tags)
+		}
+	}
+	assert.Equal(t, map[string]float64{"a.b.c": 8, "g.h.i": 5}, values)
+}
+
+func TestHTTPIngestRateLimit(t *testing.T) {
+	config := globalConfig()
+	config.HTTPIngestEnabled = true
+	config.HTTPIngestRequestsPerSecond = 1
+	config.HTTPIngestBurst = 2
+	global := setupVeneurServer(t, config, nil, nil, nil, nil)
+	defer global.Shutdown()
+	srv := httptest.NewServer(global.Handler())
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		status, _ := postIngest(t, srv.URL, "text/plain", "", "a.b.c:1|c")
+		assert.Equal(t, http.StatusOK, status, "request %d should fit in the burst", i)
+	}
+	status, _ := postIngest(t, srv.URL, "text/plain", "", "a.b.c:1|c")
+	assert.Equal(t, http.StatusTooManyRequests, status)
+}
@@ -2,7 +2,6 @@ package veneur
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -21,7 +20,10 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	vhttp "github.com/stripe/veneur/v14/http"
 	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/ssf"
 )
 
 func TestSortableJSONMetrics(t *testing.T) {
@@ -136,22 +138,55 @@ func TestServerImportUncompressed(t *testing.T) {
 	testServerImport(t, filepath.Join("testdata", "import.uncompressed"), "")
 }
 
-func TestServerImportGzip(t *testing.T) {
-	// Test that the global veneur instance
-	// returns a 400 for gzipped-input
-
+func testServerImportEncoded(t *testing.T, contentEncoding string) {
 	f, err := os.Open(filepath.Join("testdata", "import.uncompressed"))
 	assert.NoError(t, err, "Error reading response fixture")
 	defer f.Close()
 
 	var data bytes.Buffer
-	gz := gzip.NewWriter(&data)
-	_, err = io.Copy(gz, f)
+	enc, err := vhttp.NewEncoder(contentEncoding, &data)
+	require.NoError(t, err)
+	_, err = io.Copy(enc, f)
 	assert.NoError(t, err)
-	gz.Close()
+	require.NoError(t, enc.Close())
 
 	r := httptest.NewRequest(http.MethodPost, "/import", &data)
-	r.Header.Set("Content-Encoding", "gzip")
+	r.Header.Set("Content-Encoding", contentEncoding)
+
+	w := httptest.NewRecorder()
+
+	config := localConfig()
+	s := setupVeneurServer(t, config, nil, nil, nil, nil)
+	defer s.Shutdown()
+
+	handler := handleImport(s)
+	handler.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusAccepted, w.Code, "Test server returned wrong HTTP response code")
+}
+
+func TestServerImportGzip(t *testing.T) {
+	// Test that the global veneur instance can handle
+	// requests that provide gzipped metrics
+	testServerImportEncoded(t, "gzip")
+}
+
+func TestServerImportZstd(t *testing.T) {
+	// Test that the global veneur instance can handle
+	// requests that provide zstd-compressed metrics
+	testServerImportEncoded(t, "zstd")
+}
+
+func TestServerImportUnknownEncoding(t *testing.T) {
+	// Test that the global veneur instance
+	// returns a 415 for encodings it doesn't support
+
+	f, err := os.Open(filepath.Join("testdata", "import.uncompressed"))
+	assert.NoError(t, err, "Error reading response fixture")
+	defer f.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/import", f)
+	r.Header.Set("Content-Encoding", "br")
 
 	w := httptest.NewRecorder()
 
@@ -165,6 +200,54 @@ func TestServerImportGzip(t *testing.T) {
 	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code, "Test server returned wrong HTTP response code")
 }
 
+// TestForwardEncodings tests that metrics forwarded with each content
+// encoding are imported, and that the compression ratio is reported.
+func TestForwardEncodings(t *testing.T) {
+	config := localConfig()
+	s := setupVeneurServer(t, config, nil, nil, nil, nil)
+	defer s.Shutdown()
+	global := httptest.NewServer(handleImport(s))
+	defer global.Close()
+
+	var metrics []samplers.JSONMetric
+	for i := 0; i < 100; i++ {
+		metrics = append(metrics, samplers.JSONMetric{
+			MetricKey: samplers.MetricKey{Name: fmt.Sprintf("a.b.c.%d", i), Type: "counter", JoinedTags: "foo:bar"},
+			Tags:      []string{"foo:bar"},
+			Value:     []byte{0, 0, 0, 0, 0, 0, 0, 1},
+		})
+	}
+
+	for _, encoding := range []string{"identity", "deflate", "gzip", "zstd"} {
+		t.Run(encoding, func(t *testing.T) {
+			ch := make(chan *ssf.SSFSpan, 20)
+			cl, err := trace.NewChannelClient(ch)
+			require.NoError(t, err)
+			defer cl.Close()
+
+			err = vhttp.PostHelperWithEncoding(context.Background(), http.DefaultClient, cl, http.MethodPost,
+				global.URL+"/import", metrics, "forward", encoding, nil, logrus.New())
+			require.NoError(t, err)
+
+			var ratio *ssf.SSFSample
+			for len(ch) > 0 {
+				for _, sample := range (<-ch).Metrics {
+					if sample.Name == "forward.compression_ratio" {
+						ratio = sample
+					}
+				}
+			}
+			if encoding == "identity" {
+				assert.Nil(t, ratio, "uncompressed bodies shouldn't report a compression ratio")
+				return
+			}
+			require.NotNil(t, ratio, "the compression ratio should be reported")
+			assert.Equal(t, encoding, ratio.Tags["encoding"])
+			assert.True(t, ratio.Value > 1, "the metrics should compress, but the ratio was %v", ratio.Value)
+		})
+	}
+}
+
 func TestServerImportCompressedInvalid(t *testing.T) {
 	// Test that the global veneur instance
 	// properly responds to invalid zlib-deflated data
@@ -271,6 +354,36 @@ func TestGeneralHealthCheck(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code, "Healthcheck did not succeed")
 }
 
+func TestIngestionHealthCheck(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "ingestion_healthcheck")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+	sock := filepath.Join(tdir, "statsd.sock")
+
+	config := localConfig()
+	config.StatsdListenAddresses = []string{"udp://127.0.0.1:0", "unixgram://" + sock}
+	config.SsfListenAddresses = []string{"udp://127.0.0.1:0"}
+	server, err := NewFromConfig(logrus.New(), config)
+	require.NoError(t, err)
+	defer server.Shutdown()
+	handler := server.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthcheck/ingestion", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code, "Ingestion healthcheck succeeded before listeners were bound")
+	assert.Equal(t, "statsd udp://127.0.0.1:0: not bound\n"+
+		"statsd unixgram://"+sock+": not bound\n"+
+		"ssf udp://127.0.0.1:0: not bound\n", w.Body.String())
+
+	server.Start()
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthcheck/ingestion", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "Ingestion healthcheck did not succeed")
+	assert.Equal(t, "statsd udp://127.0.0.1:0: ok\n"+
+		"statsd unixgram://"+sock+": ok\n"+
+		"ssf udp://127.0.0.1:0: ok\n", w.Body.String())
+}
+
 func TestOkTraceHealthCheck(t *testing.T) {
 	r := httptest.NewRequest(http.MethodGet, "/healthcheck/tracing", nil)
 
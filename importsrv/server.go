@@ -1,13 +1,15 @@
 // Package importsrv receives metrics over gRPC and sends them to workers.
 //
-// The Server wraps a grpc.Server, and implements the forwardrpc.Forward
-// service.  It receives batches of metrics, then hashes them to a specific
-// "MetricIngester" and forwards them on.
+// The Server wraps a grpc.Server, and implements the forwardrpc.Forward and
+// forwardrpc.ForwardStream services.  It receives batches of metrics, then
+// hashes them to a specific "MetricIngester" and forwards them on.
 package importsrv
 
 import (
 	"fmt"
+	"io"
 	"net"
+	"sync"
 	"time"
 
 	"context"
@@ -15,6 +17,8 @@ import (
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/segmentio/fasthash/fnv1a"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/stripe/veneur/v14/forwardrpc"
 	"github.com/stripe/veneur/v14/samplers/metricpb"
@@ -39,6 +43,9 @@ type Server struct {
 	*grpc.Server
 	metricOuts []MetricIngester
 	opts       *options
+
+	mtx  sync.Mutex
+	addr net.Addr
 }
 
 type options struct {
@@ -67,6 +74,9 @@ func New(metricOuts []MetricIngester, opts ...Option) *Server {
 	}
 
 	forwardrpc.RegisterForwardServer(res.Server, res)
+	forwardrpc.RegisterForwardStreamServer(res.Server, res)
+	// Proxies health-check the server before forwarding to it.
+	grpc_health_v1.RegisterHealthServer(res.Server, health.NewServer())
 
 	return res
 }
@@ -80,10 +90,22 @@ func (s *Server) Serve(addr string) error {
 		return fmt.Errorf("failed to bind the import server to '%s': %v",
 			addr, err)
 	}
+	s.mtx.Lock()
+	s.addr = ln.Addr()
+	s.mtx.Unlock()
 
 	return s.Server.Serve(ln)
 }
 
+// Addr returns the address that Serve is listening on, which has the
+// actual port if the address passed to Serve asked for port 0. It's nil
+// until Serve has bound the listener.
+func (s *Server) Addr() net.Addr {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.addr
+}
+
 // Static maps of tags used in the SendMetrics handler
 var (
 	grpcTags          = map[string]string{"protocol": "grpc"}
@@ -104,6 +126,39 @@ func (s *Server) SendMetrics(ctx context.Context, mlist *forwardrpc.MetricList)
 	span.SetTag("protocol", "grpc")
 	defer span.ClientFinish(s.opts.traceClient)
 
+	s.ingest(span, mlist)
+	return &empty.Empty{}, nil
+}
+
+// SendMetricBatches fulfils the ForwardStream service: it ingests each
+// batch of metrics like SendMetrics does, and acknowledges it once all of
+// them have been handed to the ingesters.  The next batch is only read
+// after that, so if the ingesters fall behind, gRPC's flow control makes
+// the local Veneur wait.
+func (s *Server) SendMetricBatches(stream forwardrpc.ForwardStream_SendMetricBatchesServer) error {
+	for {
+		mlist, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		span, _ := trace.StartSpanFromContext(stream.Context(), "veneur.opentracing.importsrv.handle_send_metric_batches")
+		span.SetTag("protocol", "grpc")
+		s.ingest(span, mlist)
+		span.ClientFinish(s.opts.traceClient)
+
+		if err := stream.Send(&forwardrpc.MetricListAck{Accepted: uint32(len(mlist.Metrics))}); err != nil {
+			return err
+		}
+	}
+}
+
+// ingest hashes each metric in mlist to a metric ingester, and reports
+// how long that took on span.
+func (s *Server) ingest(span *trace.Span, mlist *forwardrpc.MetricList) {
 	dests := make([][]*metricpb.Metric, len(s.metricOuts))
 
 	// group metrics by their destination
@@ -128,8 +183,6 @@ func (s *Server) SendMetrics(ctx context.Context, mlist *forwardrpc.MetricList)
 		ssf.Timing(responseDurationMetric, time.Since(sendStart), time.Nanosecond, responseSendTags),
 		ssf.Count("import.metrics_total", float32(len(mlist.Metrics)), grpcTags),
 	)
-
-	return &empty.Empty{}, nil
 }
 
 // hashMetric returns a 32-bit hash from the input metric based on its name,
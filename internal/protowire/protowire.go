@@ -0,0 +1,46 @@
+// Package protowire appends protobuf-encoded fields to byte slices, for
+// sinks that send small, fixed messages without generated code.
+package protowire
+
+import "encoding/binary"
+
+// The wire types of the fields that the Append functions encode.
+const (
+	VarintType  = 0
+	Fixed64Type = 1
+	BytesType   = 2
+)
+
+// AppendTag appends the key of a field with the number and wire type.
+func AppendTag(b []byte, field int, wireType int) []byte {
+	return AppendVarint(b, uint64(field<<3|wireType))
+}
+
+// AppendVarint appends v as a varint, without a key.
+func AppendVarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(b, tmp[:n]...)
+}
+
+// AppendFixed64 appends v as 8 little-endian bytes, without a key.
+func AppendFixed64(b []byte, v uint64) []byte {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], v)
+	return append(b, tmp[:]...)
+}
+
+// AppendBytes appends a length-delimited field, such as an embedded
+// message.
+func AppendBytes(b []byte, field int, v []byte) []byte {
+	b = AppendTag(b, field, BytesType)
+	b = AppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// AppendString appends a string field.
+func AppendString(b []byte, field int, v string) []byte {
+	b = AppendTag(b, field, BytesType)
+	b = AppendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
@@ -0,0 +1,52 @@
+package protowire
+
+import (
+	"math"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppend(t *testing.T) {
+	var b []byte
+	b = AppendVarint(AppendTag(b, 1, VarintType), 300)
+	b = AppendFixed64(AppendTag(b, 2, Fixed64Type), math.Float64bits(1.5))
+	b = AppendString(b, 3, "veneur")
+	b = AppendBytes(b, 4, []byte{1, 2})
+
+	buf := proto.NewBuffer(b)
+	for _, field := range []struct {
+		key   uint64
+		check func()
+	}{
+		{1<<3 | VarintType, func() {
+			v, err := buf.DecodeVarint()
+			require.NoError(t, err)
+			assert.Equal(t, uint64(300), v)
+		}},
+		{2<<3 | Fixed64Type, func() {
+			v, err := buf.DecodeFixed64()
+			require.NoError(t, err)
+			assert.Equal(t, 1.5, math.Float64frombits(v))
+		}},
+		{3<<3 | BytesType, func() {
+			v, err := buf.DecodeStringBytes()
+			require.NoError(t, err)
+			assert.Equal(t, "veneur", v)
+		}},
+		{4<<3 | BytesType, func() {
+			v, err := buf.DecodeRawBytes(false)
+			require.NoError(t, err)
+			assert.Equal(t, []byte{1, 2}, v)
+		}},
+	} {
+		key, err := buf.DecodeVarint()
+		require.NoError(t, err)
+		assert.Equal(t, field.key, key)
+		field.check()
+	}
+	_, err := buf.DecodeVarint()
+	assert.Error(t, err, "there should be nothing left")
+}
@@ -0,0 +1,156 @@
+package veneur
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// StartJSONLines spawns goroutines that listen for newline-delimited
+// JSON metrics (see samplers.ParseMetricJSON) on the address a, and
+// returns the concrete listening address. As this is a setup routine,
+// if any error occurs, it panics.
+func StartJSONLines(s *Server, a net.Addr, packetPool *sync.Pool) net.Addr {
+	addr, err := StartJSONLinesE(s, a, packetPool)
+	if err != nil {
+		panic(err.Error())
+	}
+	return addr
+}
+
+// StartJSONLinesE is like StartJSONLines, but returns an error instead
+// of panicking if the listening socket can not be set up.
+func StartJSONLinesE(s *Server, a net.Addr, packetPool *sync.Pool) (net.Addr, error) {
+	var err error
+	switch addr := a.(type) {
+	case *net.UDPAddr:
+		a, err = startProcessingOnUDP(s, "json", addr, packetPool, s.readJSONLinesSocket)
+	case *net.TCPAddr:
+		a, err = startJSONLinesTCP(s, addr)
+	case *net.UnixAddr:
+		if addr.Network() != "unix" {
+			return nil, fmt.Errorf("Can't listen for JSON metrics on %v: only udp://, tcp:// and unix:// are supported", a)
+		}
+		_, a, err = startUnixStream(s, addr, s.ReadJSONLinesStreamSocket)
+	default:
+		err = fmt.Errorf("Can't listen for JSON metrics on %v: only udp://, tcp:// and unix:// are supported", a)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.WithFields(logrus.Fields{
+		"address": a.String(),
+		"network": a.Network(),
+	}).Info("Listening for JSON metrics")
+	return a, nil
+}
+
+// startJSONLinesTCP accepts connections that send newline-delimited JSON
+// metrics on a TCP address, until the server shuts down.
+func startJSONLinesTCP(s *Server, addr *net.TCPAddr) (net.Addr, error) {
+	listener, err := NewTCPListener(addr, false)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't listen on TCP socket %v: %v", addr, err)
+	}
+	go func() {
+		<-s.shutdown
+		if err := listener.Close(); err != nil {
+			log.WithError(err).Warn("Ignoring error closing TCP listener")
+		}
+	}()
+	go func() {
+		defer func() {
+			ConsumePanic(s.TraceClient, s.Hostname, recover())
+		}()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-s.shutdown:
+					// occurs when cleanly shutting down the server e.g. in tests; ignore errors
+					log.WithError(err).Info("Ignoring Accept error while shutting down")
+					return
+				default:
+					log.WithError(err).Fatal("TCP accept failed")
+				}
+			}
+			go s.ReadJSONLinesStreamSocket(conn)
+		}
+	}()
+	return listener.Addr(), nil
+}
+
+// readJSONLinesSocket reads datagrams of newline-delimited JSON metrics
+// off a packet socket.
+func (s *Server) readJSONLinesSocket(serverConn net.PacketConn, packetPool *sync.Pool, reader int) {
+	for {
+		buf := packetPool.Get().([]byte)
+		n, src, err := serverConn.ReadFrom(buf)
+		if err != nil {
+			log.WithError(err).Error("Error reading from UDP JSON metrics socket")
+			packetPool.Put(buf)
+			continue
+		}
+		lines := samplers.NewSplitBytes(buf[:n], '\n')
+		for lines.Next() {
+			s.handleJSONLine(lines.Chunk(), src)
+		}
+		packetPool.Put(buf)
+	}
+}
+
+// ReadJSONLinesStreamSocket reads newline-delimited JSON metrics off a
+// streaming connection, such as a TCP or UNIX domain socket. Lines that
+// fail to parse are counted and skipped; the connection is closed once
+// the client hangs up or has been idle for longer than the TCP read
+// timeout.
+func (s *Server) ReadJSONLinesStreamSocket(conn net.Conn) {
+	defer func() {
+		ConsumePanic(s.TraceClient, s.Hostname, recover())
+	}()
+	defer conn.Close()
+
+	timeout := s.idleTimeout()
+	buf := bufio.NewScanner(conn)
+	for {
+		if timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+		if !buf.Scan() {
+			break
+		}
+		s.handleJSONLine(buf.Bytes(), conn.RemoteAddr())
+	}
+	if buf.Err() != nil {
+		log.WithFields(logrus.Fields{
+			logrus.ErrorKey: buf.Err(),
+			"peer":          conn.RemoteAddr(),
+		}).Info("Error reading from JSON metrics client")
+	}
+}
+
+// handleJSONLine parses a JSON metric from source (nil if unknown) and
+// sends it to the appropriate worker. Empty lines are ignored; it only
+// returns an error if the line can't be parsed.
+func (s *Server) handleJSONLine(line []byte, source net.Addr) error {
+	if len(line) == 0 {
+		return nil
+	}
+	metric, err := samplers.ParseMetricJSON(line)
+	if err != nil {
+		metrics.ReportOne(s.TraceClient, s.rejectLine(line, source, "json", err))
+		return err
+	}
+	if !s.checkMetricName(metric, "json") {
+		return nil
+	}
+	s.checkDistribution(metric)
+	s.workerFor(metric).IngestUDP(*metric)
+	return nil
+}
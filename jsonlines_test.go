@@ -0,0 +1,63 @@
+package veneur
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func TestJSONLinesMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix-stream-json")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for network, address := range map[string]string{
+		"udp":  "udp://127.0.0.1:0",
+		"tcp":  "tcp://127.0.0.1:0",
+		"unix": fmt.Sprintf("unix://%s/json.sock", dir),
+	} {
+		network, address := network, address
+		t.Run(network, func(t *testing.T) {
+			config := localConfig()
+			config.NumWorkers = 1
+			config.Interval = "60s"
+			config.JSONListenAddresses = []string{address}
+			ch := make(chan []samplers.InterMetric, 20)
+			sink, _ := NewChannelMetricSink(ch)
+			f := newFixture(t, config, sink, nil)
+			defer f.Close()
+
+			addr := f.server.JSONListenAddrs[0]
+			conn := connectToAddress(t, network, addr.String(), 20*time.Millisecond)
+			defer conn.Close()
+			_, err := conn.Write([]byte(`{"name": "foo.bar", "type": "counter", "value": 1, "tags": ["baz:gorch"]}
+{"name": "broken", "type":
+{"name": "foo.baz", "type": "gauge", "value": 2}
+`))
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(context.TODO(), 500*time.Millisecond)
+			defer cancel()
+			keepFlushing(ctx, f.server)
+			seen := map[string]float64{}
+			for len(seen) < 2 {
+				select {
+				case metrics := <-ch:
+					for _, m := range metrics {
+						seen[m.Name] = m.Value
+					}
+				case <-ctx.Done():
+					t.Fatalf("timed out waiting for metrics; saw %v", seen)
+				}
+			}
+			require.Equal(t, map[string]float64{"foo.bar": 1, "foo.baz": 2}, seen,
+				"should process the lines around one that fails to parse")
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package veneur
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// listenerStats counts the traffic that a single statsd or SSF
+// listener handles. Its counters are updated atomically in the read
+// loops, and reported on every flush.
+type listenerStats struct {
+	protocol string
+	address  string
+
+	// connections is the number of currently open connections, on
+	// stream listeners.
+	connections int64
+	// bytes is the number of bytes read since the last report.
+	bytes int64
+	// packets is the number of packets read by each reader of a UDP
+	// listener since the last report.
+	packets []int64
+}
+
+// listenerStatsRegistry holds the stats of all listeners the server
+// started.
+type listenerStatsRegistry struct {
+	mtx   sync.Mutex
+	stats []*listenerStats
+}
+
+// listenerStatsFor returns the stats of the protocol listener on
+// address, creating them if necessary. readers is the number of
+// readers whose packets are counted separately.
+func (s *Server) listenerStatsFor(protocol string, address string, readers int) *listenerStats {
+	r := &s.listenerStats
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, ls := range r.stats {
+		if ls.protocol == protocol && ls.address == address {
+			return ls
+		}
+	}
+	ls := &listenerStats{
+		protocol: protocol,
+		address:  address,
+		packets:  make([]int64, readers),
+	}
+	r.stats = append(r.stats, ls)
+	return ls
+}
+
+// read records that reader read a packet of n bytes.
+func (ls *listenerStats) read(reader int, n int) {
+	atomic.AddInt64(&ls.bytes, int64(n))
+	atomic.AddInt64(&ls.packets[reader], 1)
+}
+
+// track counts conn as an open connection until it is closed, and
+// returns a connection that counts the bytes read from it.
+func (ls *listenerStats) track(conn net.Conn) net.Conn {
+	atomic.AddInt64(&ls.connections, 1)
+	return &countingConn{Conn: conn, stats: ls}
+}
+
+// countingConn is a net.Conn that counts the bytes read from it, and
+// its closing, in a listener's stats.
+type countingConn struct {
+	net.Conn
+	stats  *listenerStats
+	closed int32
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.stats.bytes, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		atomic.AddInt64(&c.stats.connections, -1)
+	}
+	return c.Conn.Close()
+}
+
+// countingListener is a net.Listener whose connections are tracked in
+// a listener's stats.
+type countingListener struct {
+	net.Listener
+	stats *listenerStats
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.stats.track(conn), nil
+}
+
+// reportListenerStats emits the traffic each listener handled since the
+// last report, tagged with its protocol and address, and resets the
+// counts.
+func (s *Server) reportListenerStats() {
+	r := &s.listenerStats
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, ls := range r.stats {
+		tags := []string{"protocol:" + ls.protocol, "listener:" + ls.address}
+		s.Statsd.Count("listener.bytes_read_total", atomic.SwapInt64(&ls.bytes, 0), tags, 1.0)
+		if len(ls.packets) == 0 {
+			s.Statsd.Gauge("listener.connections", float64(atomic.LoadInt64(&ls.connections)), tags, 1.0)
+			continue
+		}
+		for i := range ls.packets {
+			packets := atomic.SwapInt64(&ls.packets[i], 0)
+			readerTags := []string{tags[0], tags[1], fmt.Sprintf("reader:%d", i)}
+			s.Statsd.Gauge("listener.packets_per_second", float64(packets)/s.interval.Seconds(), readerTags, 1.0)
+		}
+	}
+}
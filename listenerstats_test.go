@@ -0,0 +1,95 @@
+package veneur
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountingConn(t *testing.T) {
+	ls := &listenerStats{}
+	client, server := net.Pipe()
+	conn := ls.track(server)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&ls.connections))
+
+	go client.Write([]byte("foo.bar:1|c\n"))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 12, n)
+	assert.Equal(t, int64(12), atomic.LoadInt64(&ls.bytes))
+
+	conn.Close()
+	conn.Close()
+	assert.Equal(t, int64(0), atomic.LoadInt64(&ls.connections), "closing twice should only count once")
+}
+
+func TestListenerStats(t *testing.T) {
+	stats, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer stats.Close()
+
+	config := localConfig()
+	config.NumWorkers = 1
+	config.Interval = "60s"
+	config.StatsAddress = stats.LocalAddr().String()
+	config.StatsdListenAddresses = []string{"udp://127.0.0.1:0", "tcp://127.0.0.1:0"}
+	f := newFixture(t, config, nil, nil)
+	defer f.Close()
+
+	udpAddr := f.server.StatsdListenAddrs[0].String()
+	tcpAddr := f.server.StatsdListenAddrs[1].String()
+	udp := connectToAddress(t, "udp", udpAddr, 20*time.Millisecond)
+	defer udp.Close()
+	_, err = udp.Write([]byte("foo.bar:1|c"))
+	require.NoError(t, err)
+	tcp := connectToAddress(t, "tcp", tcpAddr, 20*time.Millisecond)
+	defer tcp.Close()
+	_, err = tcp.Write([]byte("foo.bar:1|c\n"))
+	require.NoError(t, err)
+
+	udpStats := f.server.listenerStatsFor(DOGSTATSD_UDP.String(), udpAddr, 1)
+	tcpStats := f.server.listenerStatsFor(DOGSTATSD_TCP.String(), tcpAddr, 0)
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&udpStats.bytes) < 11 || atomic.LoadInt64(&tcpStats.bytes) < 12 {
+		require.True(t, time.Now().Before(deadline), "timed out waiting for the metrics to be read")
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int64(1), atomic.LoadInt64(&udpStats.packets[0]))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&tcpStats.connections))
+
+	f.server.Flush(context.Background())
+	expected := map[string]bool{
+		"veneur.listener.bytes_read_total:11|c|#protocol:dogstatsd-udp,listener:" + udpAddr:                           false,
+		"veneur.listener.bytes_read_total:12|c|#protocol:dogstatsd-tcp,listener:" + tcpAddr:                           false,
+		"veneur.listener.connections:1|g|#protocol:dogstatsd-tcp,listener:" + tcpAddr:                                 false,
+		"veneur.listener.packets_per_second:0.016666666666666666|g|#protocol:dogstatsd-udp,listener:" + udpAddr + ",": false,
+	}
+	buf := make([]byte, 4096)
+	stats.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for seen := 0; seen < len(expected); {
+		n, _, err := stats.ReadFrom(buf)
+		require.NoError(t, err, "should have received the listener stats")
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			for prefix, found := range expected {
+				if !found && strings.HasPrefix(line, prefix) {
+					expected[prefix] = true
+					seen++
+				}
+			}
+		}
+	}
+	assert.Equal(t, int64(0), atomic.LoadInt64(&udpStats.bytes), "counts should be reset after reporting")
+
+	tcp.Close()
+	for atomic.LoadInt64(&tcpStats.connections) != 0 {
+		require.True(t, time.Now().Before(deadline.Add(2*time.Second)), "timed out waiting for the connection to close")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
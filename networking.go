@@ -4,10 +4,12 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stripe/veneur/v14/protocol/dogstatsd"
@@ -24,106 +26,261 @@ import (
 // address. As this is a setup routine, if any error occurs, it
 // panics.
 func StartStatsd(s *Server, a net.Addr, packetPool *sync.Pool) net.Addr {
+	addr, err := StartStatsdE(s, a, packetPool)
+	if err != nil {
+		panic(err.Error())
+	}
+	return addr
+}
+
+// StartStatsdE is like StartStatsd, but returns an error instead of
+// panicking if the listening socket can not be set up.
+func StartStatsdE(s *Server, a net.Addr, packetPool *sync.Pool) (net.Addr, error) {
+	configured := a
+	var err error
 	switch addr := a.(type) {
 	case *net.UDPAddr:
-		return startStatsdUDP(s, addr, packetPool)
+		a, err = startStatsdUDP(s, addr, packetPool)
 	case *net.TCPAddr:
-		return startStatsdTCP(s, addr, packetPool)
+		a, err = startStatsdTCP(s, addr, packetPool)
 	case *net.UnixAddr:
-		_, b := startStatsdUnix(s, addr, packetPool)
-		return b
+		if addr.Network() == "unix" {
+			_, a, err = startStatsdUnixStream(s, addr)
+		} else {
+			_, a, err = startStatsdUnix(s, addr, packetPool)
+		}
+	default:
+		err = fmt.Errorf("Can't listen on %v: only TCP, UDP, unix:// and unixgram:// are supported", a)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.listenerBound("statsd", configured)
+	return a, nil
+}
+
+// listenNetwork returns the network, e.g. "udp4", that net.ListenUDP or
+// net.ListenTCP should listen on for the IP of an address that
+// protocol.ResolveAddr returned. A nil IP means both IPv4 and IPv6.
+func listenNetwork(proto string, ip net.IP) string {
+	switch {
+	case ip == nil:
+		return proto
+	case ip.To4() != nil:
+		return proto + "4"
 	default:
-		panic(fmt.Sprintf("Can't listen on %v: only TCP, UDP and unixgram:// are supported", a))
+		return proto + "6"
 	}
 }
 
+// maxUDPDatagramSize is the largest payload that a UDP datagram over
+// IPv4 can carry: 65535 bytes, less the IP and UDP headers.
+const maxUDPDatagramSize = 65507
+
 // udpProcessor is a function that reads packets from a socket, using
-// the pool provided.
-type udpProcessor func(net.PacketConn, *sync.Pool)
+// the pool provided. reader is the index of the socket among the
+// num_readers sockets listening on the same address.
+type udpProcessor func(sock net.PacketConn, pool *sync.Pool, reader int)
 
 // startProcessingOnUDP starts network num_readers listeners on the
-// given address in one goroutine each, using the passed pool. When
-// the listener is established, it starts the udpProcessor with the
-// listener.
-func startProcessingOnUDP(s *Server, protocol string, addr *net.UDPAddr, pool *sync.Pool, proc udpProcessor) net.Addr {
-	reusePort := s.numReaders != 1
-	// If we're reusing the port, make sure we're listening on the
-	// exact same address always; this is mostly relevant for
-	// tests, where port is typically 0 and the initial ListenUDP
-	// call results in a contrete port.
-	if reusePort {
-		sock, err := NewSocket(addr, s.RcvbufBytes, reusePort)
+// given address in one goroutine each, using the passed pool. Once
+// all listeners are established, it starts the udpProcessor with each
+// listener. If the kernel doesn't honor SO_REUSEPORT, the readers
+// share a single socket through a udpFanout instead. If the number of
+// readers is adaptive, and the kernel honors SO_REUSEPORT, it starts
+// the minimum number of readers, and adaptiveReaders scales them.
+func startProcessingOnUDP(s *Server, protocol string, addr *net.UDPAddr, pool *sync.Pool, proc udpProcessor) (net.Addr, error) {
+	if s.numReaders < 1 {
+		return nil, fmt.Errorf("couldn't listen on UDP socket %v: need at least one reader, got %d", addr, s.numReaders)
+	}
+	mode := "single"
+	numSockets := s.numReaders
+	reusePort := false
+	if s.adaptiveReadersMax > 0 && ReusePortSupported() {
+		mode = "adaptive"
+		numSockets = s.adaptiveReadersMin
+		reusePort = true
+	} else if s.numReaders > 1 {
+		mode = "reuseport"
+		reusePort = true
+		if !ReusePortSupported() {
+			mode = "fanout"
+			numSockets = 1
+			reusePort = false
+		}
+	}
+	if s.adaptiveReadersMax > 0 && mode != "adaptive" {
+		log.WithField("address", addr).
+			Warn("SO_REUSEPORT is not supported; the number of UDP readers can't be adaptive")
+	}
+	socks := make([]net.PacketConn, 0, numSockets)
+	var bound *net.UDPAddr
+	for i := 0; i < numSockets; i++ {
+		// each goroutine gets its own socket
+		// if the sockets support SO_REUSEPORT, then this will cause the
+		// kernel to distribute datagrams across them, for better read
+		// performance
+		sock, rcvbuf, err := NewSizedSocket(addr, s.RcvbufBytes, s.rcvbufAutosize, reusePort)
 		if err != nil {
-			panic(fmt.Sprintf("couldn't listen on UDP socket %v: %v", addr, err))
+			// if any socket can't be created, this probably
+			// indicates a systemic issue, so give up on all of
+			// them
+			for _, sock := range socks {
+				sock.Close()
+			}
+			return nil, fmt.Errorf("couldn't listen on UDP socket %v: %v", addr, err)
+		}
+		// If we're reusing the port, make sure we're listening on the
+		// exact same address always; this is mostly relevant for
+		// tests, where port is typically 0 and the first socket
+		// results in a contrete port. The IP has to stay as
+		// configured, as the local address of a dual-stack socket
+		// is ::, which would only listen on IPv6.
+		bound = sock.LocalAddr().(*net.UDPAddr)
+		addr = &net.UDPAddr{IP: addr.IP, Port: bound.Port, Zone: addr.Zone}
+		socks = append(socks, sock)
+		s.recordRcvbuf(rcvbuf)
+		if rcvbuf < s.RcvbufBytes {
+			log.WithFields(logrus.Fields{
+				"address":   addr,
+				"requested": s.RcvbufBytes,
+				"effective": rcvbuf,
+			}).Warn("Kernel granted a smaller receive buffer than requested; consider raising net.core.rmem_max")
 		}
-		defer sock.Close()
-		addr = sock.LocalAddr().(*net.UDPAddr)
 	}
-	addrChan := make(chan net.Addr, 1)
-	once := sync.Once{}
-	for i := 0; i < s.numReaders; i++ {
-		go func() {
+	// the configured IP, on the port that was bound
+	listenAddr := addr
+	addr = bound
+	if mode == "fanout" {
+		log.WithField("address", addr).
+			Warn("SO_REUSEPORT is not supported; falling back to a single UDP socket shared by all readers")
+		fanout := newUDPFanout(socks[0], pool, s.numReaders)
+		socks = socks[:0]
+		for i := 0; i < s.numReaders; i++ {
+			socks = append(socks, fanout)
+		}
+	}
+	log.WithFields(logrus.Fields{
+		"address":   addr,
+		"protocol":  protocol,
+		"listeners": len(socks),
+		"mode":      mode,
+	}).Info("Listening on UDP address")
+
+	if mode == "adaptive" {
+		newAdaptiveReaders(s, protocol, listenAddr, pool, proc, socks).start(s.adaptiveReadersInterval)
+		return addr, nil
+	}
+
+	for i, sock := range socks {
+		go func(sock net.PacketConn, reader int) {
 			defer func() {
 				ConsumePanic(s.TraceClient, s.Hostname, recover())
 			}()
-			// each goroutine gets its own socket
-			// if the sockets support SO_REUSEPORT, then this will cause the
-			// kernel to distribute datagrams across them, for better read
-			// performance
-			sock, err := NewSocket(addr, s.RcvbufBytes, reusePort)
-			if err != nil {
-				// if any goroutine fails to create the socket, we can't really
-				// recover, so we just blow up
-				// this probably indicates a systemic issue, eg lack of
-				// SO_REUSEPORT support
-				panic(fmt.Sprintf("couldn't listen on UDP socket %v: %v", addr, err))
-			}
-			// Pass the address that we are listening on
-			// back to whoever spawned this goroutine so
-			// it can return that address.
-			once.Do(func() {
-				addrChan <- sock.LocalAddr()
-				log.WithFields(logrus.Fields{
-					"address":   sock.LocalAddr(),
-					"protocol":  protocol,
-					"listeners": s.numReaders,
-				}).Info("Listening on UDP address")
-				close(addrChan)
-			})
-
-			proc(sock, pool)
-		}()
+			proc(sock, pool, reader)
+		}(sock, i)
 	}
-	return <-addrChan
+	return addr, nil
 }
 
-func startStatsdUDP(s *Server, addr *net.UDPAddr, packetPool *sync.Pool) net.Addr {
-	return startProcessingOnUDP(s, "statsd", addr, packetPool, s.ReadMetricSocket)
+func startStatsdUDP(s *Server, addr *net.UDPAddr, packetPool *sync.Pool) (net.Addr, error) {
+	return startProcessingOnUDP(s, "statsd", addr, packetPool, s.readMetricSocket)
 }
 
-func startStatsdTCP(s *Server, addr *net.TCPAddr, packetPool *sync.Pool) net.Addr {
-	var listener net.Listener
-	var err error
+// startStatsdTCP starts listening for statsd metrics over TCP. If
+// the server is configured with more than one reader, and the kernel
+// honors SO_REUSEPORT, it creates num_readers listeners bound to the
+// same address with it, each accepting connections in its own
+// goroutine.
+func startStatsdTCP(s *Server, addr *net.TCPAddr, packetPool *sync.Pool) (net.Addr, error) {
+	numListeners := 1
+	reusePort := s.numReaders > 1
+	if reusePort && !ReusePortSupported() {
+		log.WithField("address", addr).
+			Warn("SO_REUSEPORT is not supported; falling back to a single TCP listener")
+		reusePort = false
+	}
+	if reusePort {
+		numListeners = s.numReaders
+	}
 
-	listener, err = net.ListenTCP("tcp", addr)
-	if err != nil {
-		panic(fmt.Sprintf("couldn't listen on TCP socket %v: %v", addr, err))
+	listeners := make([]net.Listener, 0, numListeners)
+	var bound *net.TCPAddr
+	for i := 0; i < numListeners; i++ {
+		listener, err := NewTCPListener(addr, reusePort)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, fmt.Errorf("couldn't listen on TCP socket %v: %v", addr, err)
+		}
+		// If we're reusing the port, make sure the remaining
+		// listeners bind the exact same address; this is
+		// mostly relevant for tests, where port is typically 0.
+		bound = listener.Addr().(*net.TCPAddr)
+		addr = &net.TCPAddr{IP: addr.IP, Port: bound.Port, Zone: addr.Zone}
+		listeners = append(listeners, listener)
+	}
+	addr = bound
+
+	// count connections and bytes on the raw sockets, before the PROXY
+	// protocol header and TLS are stripped:
+	stats := s.listenerStatsFor(DOGSTATSD_TCP.String(), addr.String(), 0)
+	for i, listener := range listeners {
+		listeners[i] = &countingListener{Listener: listener, stats: stats}
+	}
+
+	var conns *tcpConnTracker
+	if s.tcpDrainTimeout > 0 {
+		conns = newTCPConnTracker()
+	}
+
+	var limit *connLimiter
+	if s.tcpMaxConnections > 0 {
+		limit = newConnLimiter(s.tcpMaxConnections, s.tcpMaxConnectionsAction)
 	}
 
 	go func() {
 		<-s.shutdown
 		// TODO: the socket is in use until there are no goroutines blocked in Accept
 		// we should wait until the accepting goroutine exits
-		err := listener.Close()
-		if err != nil {
-			log.WithError(err).Warn("Ignoring error closing TCP listener")
+		for _, listener := range listeners {
+			err := listener.Close()
+			if err != nil {
+				log.WithError(err).Warn("Ignoring error closing TCP listener")
+			}
+		}
+		if conns != nil {
+			drained, forced := conns.drain(s.tcpDrainTimeout)
+			s.Statsd.Count("tcp.shutdown.drained_total", int64(drained), nil, 1.0)
+			s.Statsd.Count("tcp.shutdown.forced_closes_total", int64(forced), nil, 1.0)
+			entry := log.WithFields(logrus.Fields{
+				"address":       addr,
+				"drained":       drained,
+				"forced_closes": forced,
+			})
+			if forced > 0 {
+				entry.Warn("Forcibly closed TCP connections that did not drain in time")
+			} else {
+				entry.Info("Drained TCP connections")
+			}
 		}
 	}()
 
+	if s.tcpProxyProtocol {
+		// the PROXY header precedes the TLS handshake, so strip
+		// it before wrapping the listeners with TLS:
+		for i, listener := range listeners {
+			listeners[i] = &proxyProtocolListener{Listener: listener, server: s}
+		}
+	}
+
 	mode := "unencrypted"
 	if s.tlsConfig != nil {
-		// wrap the listener with TLS
-		listener = tls.NewListener(listener, s.tlsConfig)
+		// wrap the listeners with TLS
+		for i, listener := range listeners {
+			listeners[i] = tls.NewListener(listener, s.tlsConfig)
+		}
 		if s.tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert {
 			mode = "authenticated"
 		} else {
@@ -132,23 +289,26 @@ func startStatsdTCP(s *Server, addr *net.TCPAddr, packetPool *sync.Pool) net.Add
 	}
 
 	log.WithFields(logrus.Fields{
-		"address": addr, "mode": mode,
+		"address": addr, "mode": mode, "listeners": numListeners,
+		"proxy_protocol": s.tcpProxyProtocol,
 	}).Info("Listening for statsd metrics on TCP socket")
 
-	go func() {
-		defer func() {
-			ConsumePanic(s.TraceClient, s.Hostname, recover())
-		}()
-		s.ReadTCPSocket(listener)
-	}()
-	return listener.Addr()
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			defer func() {
+				ConsumePanic(s.TraceClient, s.Hostname, recover())
+			}()
+			s.readTCPSocket(listener, conns, limit)
+		}(listener)
+	}
+	return addr, nil
 }
 
 // startStatsdUnix starts listening for datagram statsd metric packets
 // on a UNIX domain socket address. It does so until the
 // server's shutdown socket is closed. startStatsdUnix returns a channel
 // that is closed once the listening connection has terminated.
-func startStatsdUnix(s *Server, addr *net.UnixAddr, packetPool *sync.Pool) (<-chan struct{}, net.Addr) {
+func startStatsdUnix(s *Server, addr *net.UnixAddr, packetPool *sync.Pool) (<-chan struct{}, net.Addr, error) {
 	done := make(chan struct{})
 
 	isAbstractSocket := isAbstractSocket(addr)
@@ -156,17 +316,23 @@ func startStatsdUnix(s *Server, addr *net.UnixAddr, packetPool *sync.Pool) (<-ch
 	// ensure we are the only ones locking this socket if it's a file:
 	var lock *flock.Flock
 	if !isAbstractSocket {
-		lock = acquireLockForSocket(addr)
+		var err error
+		lock, err = acquireLockForSocket(addr)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
-	fmt.Println(addr.String())
 	conn, err := net.ListenUnixgram(addr.Network(), addr)
 	if err != nil {
-		panic(fmt.Sprintf("Couldn't listen on UNIX socket %v: %v", addr, err))
+		releaseLockForSocket(lock)
+		return nil, nil, fmt.Errorf("Couldn't listen on UNIX socket %v: %v", addr, err)
 	}
 
 	if rcvbufsize := s.RcvbufBytes; rcvbufsize != 0 {
 		if err := conn.SetReadBuffer(rcvbufsize); err != nil {
-			panic(fmt.Sprintf("Couldn't set buffer size for UNIX socket %v: %v", addr, err))
+			conn.Close()
+			releaseLockForSocket(lock)
+			return nil, nil, fmt.Errorf("Couldn't set buffer size for UNIX socket %v: %v", addr, err)
 		}
 	}
 
@@ -174,15 +340,15 @@ func startStatsdUnix(s *Server, addr *net.UnixAddr, packetPool *sync.Pool) (<-ch
 	if !isAbstractSocket {
 		err = os.Chmod(addr.String(), 0666)
 		if err != nil {
-			panic(fmt.Sprintf("Couldn't set permissions on %v: %v", addr, err))
+			conn.Close()
+			releaseLockForSocket(lock)
+			return nil, nil, fmt.Errorf("Couldn't set permissions on %v: %v", addr, err)
 		}
 	}
 
 	go func() {
 		defer func() {
-			if !isAbstractSocket {
-				lock.Unlock()
-			}
+			releaseLockForSocket(lock)
 			close(done)
 		}()
 		for {
@@ -195,71 +361,134 @@ func startStatsdUnix(s *Server, addr *net.UnixAddr, packetPool *sync.Pool) (<-ch
 		}
 	}()
 	for i := 0; i < s.numReaders; i++ {
-		go s.ReadStatsdDatagramSocket(conn, packetPool)
+		go s.readStatsdDatagramSocket(conn, packetPool, i)
 	}
-	return done, addr
+	return done, addr, nil
 }
 
 // StartSSF starts listening for SSF on an address a, and returns the
-// concrete address that the server is listening on.
+// concrete address that the server is listening on. As this is a
+// setup routine, if any error occurs, it panics.
 func StartSSF(s *Server, a net.Addr, tracePool *sync.Pool) net.Addr {
+	addr, err := StartSSFE(s, a, tracePool)
+	if err != nil {
+		panic(err.Error())
+	}
+	return addr
+}
+
+// StartSSFE is like StartSSF, but returns an error instead of
+// panicking if the listening socket can not be set up.
+func StartSSFE(s *Server, a net.Addr, tracePool *sync.Pool) (net.Addr, error) {
+	configured := a
+	var err error
 	switch addr := a.(type) {
 	case *net.UDPAddr:
-		a = startSSFUDP(s, addr, tracePool)
+		a, err = startSSFUDP(s, addr, tracePool)
 	case *net.UnixAddr:
-		_, a = startSSFUnix(s, addr)
+		_, a, err = startSSFUnix(s, addr)
 	default:
-		panic(fmt.Sprintf("Can't listen for SSF on %v: only udp:// & unix:// are supported", a))
+		err = fmt.Errorf("Can't listen for SSF on %v: only udp:// & unix:// are supported", a)
+	}
+	if err != nil {
+		return nil, err
 	}
+	s.listenerBound("ssf", configured)
 	log.WithFields(logrus.Fields{
 		"address": a.String(),
 		"network": a.Network(),
 	}).Info("Listening for SSF traces")
-	return a
+	return a, nil
 }
 
-func startSSFUDP(s *Server, addr *net.UDPAddr, tracePool *sync.Pool) net.Addr {
-	return startProcessingOnUDP(s, "ssf", addr, tracePool, s.ReadSSFPacketSocket)
+func startSSFUDP(s *Server, addr *net.UDPAddr, tracePool *sync.Pool) (net.Addr, error) {
+	return startProcessingOnUDP(s, "ssf", addr, tracePool, s.readSSFPacketSocket)
 }
 
 // startSSFUnix starts listening for connections that send framed SSF
 // spans on a UNIX domain socket address. It does so until the
 // server's shutdown socket is closed. startSSFUnix returns a channel
 // that is closed once the listener has terminated.
-func startSSFUnix(s *Server, addr *net.UnixAddr) (<-chan struct{}, net.Addr) {
-	done := make(chan struct{})
+func startSSFUnix(s *Server, addr *net.UnixAddr) (<-chan struct{}, net.Addr, error) {
 	if addr.Network() != "unix" {
-		panic(fmt.Sprintf("Can't listen for SSF on %v: only udp:// and unix:// addresses are supported", addr))
+		return nil, nil, fmt.Errorf("Can't listen for SSF on %v: only udp:// and unix:// addresses are supported", addr)
+	}
+	stats := s.listenerStatsFor(SSF_UNIX.String(), addr.String(), 0)
+	return startUnixStream(s, addr, func(conn net.Conn) {
+		s.ReadSSFStreamSocket(stats.track(conn))
+	})
+}
+
+// startStatsdUnixStream starts listening for connections that send
+// newline-separated statsd metrics on a UNIX domain socket address. It
+// does so until the server's shutdown socket is closed.
+// startStatsdUnixStream returns a channel that is closed once the
+// listener has terminated.
+func startStatsdUnixStream(s *Server, addr *net.UnixAddr) (<-chan struct{}, net.Addr, error) {
+	stats := s.listenerStatsFor(DOGSTATSD_UNIX.String(), addr.String(), 0)
+	done, a, err := startUnixStream(s, addr, func(conn net.Conn) {
+		s.ReadStatsdStreamSocket(stats.track(conn))
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	log.WithField("address", a).Info("Listening for statsd metrics on UNIX stream socket")
+	return done, a, nil
+}
 
+// listenUnixStream listens on a UNIX domain stream socket address,
+// locking the socket file, if addr isn't an abstract socket, and making
+// it connectable by everyone who can access its path. The lock must be
+// released with releaseLockForSocket once the listener is closed.
+func listenUnixStream(addr *net.UnixAddr) (*net.UnixListener, *flock.Flock, error) {
 	isAbstractSocket := isAbstractSocket(addr)
 
 	// ensure we are the only ones locking this socket if it's a file:
 	var lock *flock.Flock
 	if !isAbstractSocket {
-		lock = acquireLockForSocket(addr)
+		var err error
+		lock, err = acquireLockForSocket(addr)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	listener, err := net.ListenUnix(addr.Network(), addr)
 	if err != nil {
-		panic(fmt.Sprintf("Couldn't listen on UNIX socket %v: %v", addr, err))
+		releaseLockForSocket(lock)
+		return nil, nil, fmt.Errorf("Couldn't listen on UNIX socket %v: %v", addr, err)
 	}
 
 	// Make the socket connectable by everyone with access to the socket pathname:
 	if !isAbstractSocket {
 		err = os.Chmod(addr.String(), 0666)
 		if err != nil {
-			panic(fmt.Sprintf("Couldn't set permissions on %v: %v", addr, err))
+			listener.Close()
+			releaseLockForSocket(lock)
+			return nil, nil, fmt.Errorf("Couldn't set permissions on %v: %v", addr, err)
 		}
 	}
+	return listener, lock, nil
+}
+
+// startUnixStream listens on a UNIX domain stream socket address
+// (locking the socket file, if addr isn't an abstract socket), and
+// starts a goroutine running handle for each connection it accepts.
+// It returns a channel that is closed once the listener has
+// terminated.
+func startUnixStream(s *Server, addr *net.UnixAddr, handle func(net.Conn)) (<-chan struct{}, net.Addr, error) {
+	done := make(chan struct{})
+
+	listener, lock, err := listenUnixStream(addr)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	go func() {
 		conns := make(chan net.Conn)
 		go func() {
 			defer func() {
-				if !isAbstractSocket {
-					lock.Unlock()
-				}
+				releaseLockForSocket(lock)
 				close(done)
 			}()
 			for {
@@ -280,7 +509,7 @@ func startSSFUnix(s *Server, addr *net.UnixAddr) (<-chan struct{}, net.Addr) {
 		for {
 			select {
 			case conn := <-conns:
-				go s.ReadSSFStreamSocket(conn)
+				go handle(conn)
 			case <-s.shutdown:
 				listener.Close()
 				return
@@ -288,10 +517,13 @@ func startSSFUnix(s *Server, addr *net.UnixAddr) (<-chan struct{}, net.Addr) {
 		}
 	}()
 
-	return done, listener.Addr()
+	return done, listener.Addr(), nil
 }
 
-// StartGRPC starts listening for spans over HTTP
+// StartGRPC starts listening for spans and statsd metrics over gRPC on
+// the address a, and returns the concrete listening address. As this is
+// a setup routine, it panics if a isn't a TCP address, and exits if it
+// can't listen on it.
 func StartGRPC(s *Server, a net.Addr) net.Addr {
 	switch addr := a.(type) {
 	case *net.TCPAddr:
@@ -313,7 +545,7 @@ type grpcStatsServer struct {
 //This is the function that fulfils the ssf server proto
 func (grpcsrv *grpcStatsServer) SendPacket(ctx context.Context, packet *dogstatsd.DogstatsdPacket) (*dogstatsd.Empty, error) {
 	//We use processMetricPacket instead of handleMetricPacket because process can split the byte array into multiple packets if needed
-	grpcsrv.server.processMetricPacket(len(packet.GetPacketBytes()), packet.GetPacketBytes(), nil, DOGSTATSD_GRPC)
+	grpcsrv.server.processMetricPacket(len(packet.GetPacketBytes()), packet.GetPacketBytes(), nil, DOGSTATSD_GRPC, "", nil)
 	return &dogstatsd.Empty{}, nil
 }
 
@@ -323,8 +555,37 @@ func (grpcsrv *grpcStatsServer) SendSpan(ctx context.Context, span *ssf.SSFSpan)
 	return &ssf.Empty{}, nil
 }
 
+// SendSpans fulfils the SSFStream service: it handles the spans in each
+// batch like those read off a framed SSF stream, and acknowledges the
+// batch once all of them have been handed to the span worker. The next
+// batch is only read after that, so if the span worker falls behind,
+// gRPC's flow control makes the client wait instead of buffering spans
+// without bound.
+func (grpcsrv *grpcStatsServer) SendSpans(stream ssf.SSFStream_SendSpansServer) error {
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var accepted uint32
+		for _, span := range batch.Spans {
+			if span == nil {
+				continue
+			}
+			grpcsrv.server.handleSSF(span, "framed", SSF_GRPC)
+			accepted++
+		}
+		if err := stream.Send(&ssf.SSFSpanBatchAck{Accepted: accepted}); err != nil {
+			return err
+		}
+	}
+}
+
 func startGRPCTCP(s *Server, addr *net.TCPAddr) (*grpc.Server, net.Addr) {
-	listener, err := net.Listen("tcp", addr.String())
+	listener, err := net.ListenTCP(listenNetwork("tcp", addr.IP), addr)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
@@ -348,6 +609,7 @@ func startGRPCTCP(s *Server, addr *net.TCPAddr) (*grpc.Server, net.Addr) {
 
 	grpc_health_v1.RegisterHealthServer(grpcServer, healthServer)
 	ssf.RegisterSSFGRPCServer(grpcServer, statsServer)
+	ssf.RegisterSSFStreamServer(grpcServer, statsServer)
 	dogstatsd.RegisterDogstatsdGRPCServer(grpcServer, statsServer)
 
 	log.WithFields(logrus.Fields{
@@ -357,23 +619,98 @@ func startGRPCTCP(s *Server, addr *net.TCPAddr) (*grpc.Server, net.Addr) {
 	return grpcServer, listener.Addr()
 }
 
-// Acquires exclusive use lock for a given socket file and returns the lock
-// Panic's if unable to acquire lock
-func acquireLockForSocket(addr *net.UnixAddr) *flock.Flock {
+// Acquires exclusive use lock for a given socket file and returns the
+// lock. Returns an error if unable to acquire the lock.
+func acquireLockForSocket(addr *net.UnixAddr) (*flock.Flock, error) {
 	lockname := fmt.Sprintf("%s.lock", addr.String())
 	lock := flock.NewFlock(lockname)
 	locked, err := lock.TryLock()
 	if err != nil {
-		panic(fmt.Sprintf("Could not acquire the lock %q to listen on %v: %v", lockname, addr, err))
+		return nil, fmt.Errorf("Could not acquire the lock %q to listen on %v: %v", lockname, addr, err)
 	}
 	if !locked {
-		panic(fmt.Sprintf("Lock file %q for %v is in use by another process already", lockname, addr))
+		return nil, fmt.Errorf("Lock file %q for %v is in use by another process already", lockname, addr)
 	}
 	// We have the exclusive use of the socket, clear away any old sockets and listen:
 	_ = os.Remove(addr.String())
-	return lock
+	return lock, nil
+}
+
+// releaseLockForSocket releases a lock acquired with
+// acquireLockForSocket. It is a no-op for a nil lock, which is what
+// abstract sockets use.
+func releaseLockForSocket(lock *flock.Flock) {
+	if lock != nil {
+		lock.Unlock()
+	}
 }
 
 func isAbstractSocket(addr *net.UnixAddr) bool {
 	return strings.HasPrefix(addr.String(), "@")
 }
+
+// tcpConnTracker keeps track of the TCP connections accepted on a
+// statsd listener, so that they can be drained when the server shuts
+// down.
+type tcpConnTracker struct {
+	wg    sync.WaitGroup
+	mtx   sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func newTCPConnTracker() *tcpConnTracker {
+	return &tcpConnTracker{conns: map[net.Conn]struct{}{}}
+}
+
+// add registers an accepted connection. It must be paired with a call
+// to done once the connection's handler has returned.
+func (t *tcpConnTracker) add(conn net.Conn) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.wg.Add(1)
+	t.conns[conn] = struct{}{}
+}
+
+func (t *tcpConnTracker) done(conn net.Conn) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	delete(t.conns, conn)
+	t.wg.Done()
+}
+
+// drain stops all tracked connections from waiting for further
+// input, and waits up to timeout for their handlers to finish
+// processing what they have already read. Any connection still open
+// after the timeout is closed forcibly. drain returns the number of
+// connections that finished in time and the number that had to be
+// closed.
+func (t *tcpConnTracker) drain(timeout time.Duration) (drained int, forced int) {
+	t.mtx.Lock()
+	total := len(t.conns)
+	for conn := range t.conns {
+		// Unblock any pending reads; the handler still
+		// processes the lines it has already buffered.
+		conn.SetReadDeadline(time.Now())
+	}
+	t.mtx.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return total, 0
+	case <-time.After(timeout):
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	forced = len(t.conns)
+	for conn := range t.conns {
+		conn.Close()
+	}
+	return total - forced, forced
+}
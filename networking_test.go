@@ -2,8 +2,12 @@ package veneur
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io"
 	"net"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -11,12 +15,15 @@ import (
 	"io/ioutil"
 	"os"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/v14/protocol"
 	"github.com/stripe/veneur/v14/protocol/dogstatsd"
+	"github.com/stripe/veneur/v14/samplers"
 	"github.com/stripe/veneur/v14/ssf"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
@@ -33,11 +40,11 @@ func TestMultipleListeners(t *testing.T) {
 	addr, ok := addrNet.(*net.UnixAddr)
 	require.True(t, ok)
 
-	done, _ := startSSFUnix(srv, addr)
-	assert.Panics(t, func() {
-		srv2 := &Server{}
-		startSSFUnix(srv2, addr)
-	})
+	done, _, err := startSSFUnix(srv, addr)
+	require.NoError(t, err)
+	srv2 := &Server{}
+	_, _, err = startSSFUnix(srv2, addr)
+	assert.Error(t, err)
 	close(srv.shutdown)
 
 	// Wait for the server to actually shut down:
@@ -45,8 +52,49 @@ func TestMultipleListeners(t *testing.T) {
 
 	srv3 := &Server{}
 	srv3.shutdown = make(chan struct{})
-	startSSFUnix(srv3, addr)
+	done, _, err = startSSFUnix(srv3, addr)
+	require.NoError(t, err)
+	close(srv3.shutdown)
+	<-done
+}
+
+func TestAbstractSSFListener(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract unix sockets are only supported on linux")
+	}
+	srv := &Server{}
+	srv.shutdown = make(chan struct{})
+
+	addrNet, err := protocol.ResolveAddr("unix:@veneur-test-ssf.sock")
+	require.NoError(t, err)
+	addr, ok := addrNet.(*net.UnixAddr)
+	require.True(t, ok)
+
+	done, _, err := startSSFUnix(srv, addr)
+	require.NoError(t, err)
+	_, err = os.Stat(addr.String() + ".lock")
+	assert.True(t, os.IsNotExist(err), "abstract sockets should not create a lock file")
+
+	// The kernel refuses a second bind to the same abstract name:
+	srv2 := &Server{}
+	_, _, err = startSSFUnix(srv2, addr)
+	assert.Error(t, err)
+
+	c, err := net.DialUnix("unix", nil, addr)
+	require.NoError(t, err)
+	c.Close()
+
+	close(srv.shutdown)
+	<-done
+
+	// Once the listener is closed, the name is free again; there
+	// is no stale socket file to clean up:
+	srv3 := &Server{}
+	srv3.shutdown = make(chan struct{})
+	done, _, err = startSSFUnix(srv3, addr)
+	require.NoError(t, err)
 	close(srv3.shutdown)
+	<-done
 }
 
 func TestConnectUNIX(t *testing.T) {
@@ -61,7 +109,8 @@ func TestConnectUNIX(t *testing.T) {
 	require.NoError(t, err)
 	addr, ok := addrNet.(*net.UnixAddr)
 	require.True(t, ok)
-	startSSFUnix(srv, addr)
+	_, _, err = startSSFUnix(srv, addr)
+	require.NoError(t, err)
 
 	conns := make(chan struct{})
 	for i := 0; i < 5; i++ {
@@ -117,7 +166,8 @@ func TestConnectUNIXStatsd(t *testing.T) {
 			return make([]byte, 4097)
 		},
 	}
-	startStatsdUnix(srv, addr, statsdPool)
+	_, _, err = startStatsdUnix(srv, addr, statsdPool)
+	require.NoError(t, err)
 
 	conns := make(chan struct{})
 	for i := 0; i < 5; i++ {
@@ -155,6 +205,38 @@ func TestConnectUNIXStatsd(t *testing.T) {
 	close(srv.shutdown)
 }
 
+func TestReadSSFStreamSocketBrokenFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{"hangup in length prefix", []byte{0x00, 0x00}},
+		{"hangup mid-frame", []byte{0x00, 0x00, 0x00, 0x00, 0x0a, 0x01, 0x02}},
+		{"frame too long", []byte{0x00, 0x7f, 0xff, 0xff, 0xff}},
+		{"bad version", []byte{0x17, 0x00, 0x00, 0x00, 0x01}},
+	}
+	for _, elt := range tests {
+		test := elt
+		t.Run(test.name, func(t *testing.T) {
+			srv := &Server{ssfMaxFrameLength: 1024}
+			client, server := net.Pipe()
+			done := make(chan struct{})
+			go func() {
+				srv.ReadSSFStreamSocket(server)
+				close(done)
+			}()
+
+			client.Write(test.input)
+			client.Close()
+			select {
+			case <-done:
+			case <-time.After(3 * time.Second):
+				t.Fatal("ReadSSFStreamSocket did not return")
+			}
+		})
+	}
+}
+
 func TestHealthCheckGRPC(t *testing.T) {
 	srv := &Server{}
 
@@ -260,3 +342,228 @@ func TestConnectDogstatsdGRPC(t *testing.T) {
 	}
 	grpcServer.Stop()
 }
+
+func TestTCPConnTrackerDrain(t *testing.T) {
+	tracker := newTCPConnTracker()
+
+	// A well-behaved connection handler returns once its read
+	// deadline passes:
+	polite, politePeer := net.Pipe()
+	defer politePeer.Close()
+	tracker.add(polite)
+	go func() {
+		defer tracker.done(polite)
+		_, err := polite.Read(make([]byte, 1))
+		assert.Error(t, err)
+	}()
+
+	// A stuck handler only returns once its connection is closed:
+	stuck, stuckPeer := net.Pipe()
+	defer stuckPeer.Close()
+	closed := make(chan struct{})
+	tracker.add(stuck)
+	go func() {
+		defer tracker.done(stuck)
+		for {
+			_, err := stuck.Read(make([]byte, 1))
+			if err == io.ErrClosedPipe {
+				close(closed)
+				return
+			}
+			stuck.SetReadDeadline(time.Time{})
+		}
+	}()
+
+	drained, forced := tracker.drain(50 * time.Millisecond)
+	assert.Equal(t, 1, drained)
+	assert.Equal(t, 1, forced)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("stuck connection was not closed")
+	}
+}
+
+func TestStartStatsdEAddressInUse(t *testing.T) {
+	srv := &Server{numReaders: 1, shutdown: make(chan struct{})}
+	defer close(srv.shutdown)
+
+	addr, err := StartStatsdE(srv, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	require.NoError(t, err)
+
+	_, err = StartStatsdE(srv, addr, nil)
+	assert.Error(t, err, "listening twice on the same address should fail")
+	assert.Panics(t, func() {
+		StartStatsd(srv, addr, nil)
+	})
+
+	_, err = StartSSFE(srv, &net.IPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	assert.Error(t, err, "SSF can't be read from IP sockets")
+}
+
+func TestSendSpansGRPC(t *testing.T) {
+	pems, err := readTestKeysCerts()
+	require.NoError(t, err)
+
+	config := localConfig()
+	config.TLSKey = pems["serverkey.pem"]
+	config.TLSCertificate = pems["servercert.pem"]
+	config.TLSAuthorityCertificate = pems["cacert.pem"]
+	config.SpanChannelCapacity = 2
+	srv, err := NewFromConfig(logrus.New(), config)
+	require.NoError(t, err)
+	// the test CA has expired, so verify certificates as of a time
+	// when it was still valid:
+	validAt := func() time.Time { return time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC) }
+	srv.tlsConfig.Time = validAt
+	grpcServer, addr := startGRPCTCP(srv, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	defer grpcServer.Stop()
+
+	trustServerCA := x509.NewCertPool()
+	require.True(t, trustServerCA.AppendCertsFromPEM([]byte(pems["cacert.pem"])))
+	clientCert, err := tls.X509KeyPair([]byte(pems["clientcert_correct.pem"]), []byte(pems["clientkey.pem"]))
+	require.NoError(t, err)
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		conn, err := grpc.Dial(addr.String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			RootCAs: trustServerCA,
+			Time:    validAt,
+		})))
+		require.NoError(t, err)
+		defer conn.Close()
+		stream, err := ssf.NewSSFStreamClient(conn).SendSpans(context.Background())
+		if err == nil {
+			stream.Send(&ssf.SSFSpanBatch{Spans: []*ssf.SSFSpan{{Id: 1}}})
+			_, err = stream.Recv()
+		}
+		assert.Error(t, err, "clients without a certificate should be rejected")
+	})
+
+	t.Run("authenticated", func(t *testing.T) {
+		conn, err := grpc.Dial(addr.String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			RootCAs:      trustServerCA,
+			Certificates: []tls.Certificate{clientCert},
+			Time:         validAt,
+		})))
+		require.NoError(t, err)
+		defer conn.Close()
+		stream, err := ssf.NewSSFStreamClient(conn).SendSpans(context.Background())
+		require.NoError(t, err)
+
+		require.NoError(t, stream.Send(&ssf.SSFSpanBatch{Spans: []*ssf.SSFSpan{
+			{Id: 1, TraceId: 1, Service: "a"},
+			{Id: 2, TraceId: 1, Service: "a"},
+		}}))
+		ack, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(2), ack.Accepted)
+
+		// the span channel is full now, so the next batch is only
+		// acknowledged once there is room for its spans:
+		acks := make(chan *ssf.SSFSpanBatchAck)
+		go func() {
+			ack, _ := stream.Recv()
+			acks <- ack
+		}()
+		require.NoError(t, stream.Send(&ssf.SSFSpanBatch{Spans: []*ssf.SSFSpan{{Id: 3, TraceId: 3, Service: "b"}}}))
+		select {
+		case <-acks:
+			t.Fatal("batch should not be acknowledged while the span channel is full")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		got := []int64{(<-srv.SpanChan).Id, (<-srv.SpanChan).Id}
+		select {
+		case ack := <-acks:
+			require.NotNil(t, ack)
+			assert.Equal(t, uint32(1), ack.Accepted)
+		case <-time.After(3 * time.Second):
+			t.Fatal("timed out waiting for the batch to be acknowledged")
+		}
+		got = append(got, (<-srv.SpanChan).Id)
+		assert.Equal(t, []int64{1, 2, 3}, got)
+		require.NoError(t, stream.CloseSend())
+	})
+}
+
+func TestStatsdIPv6(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "[::1]:0")
+	if err != nil {
+		t.Skip("the system doesn't support IPv6")
+	}
+	conn.Close()
+
+	tests := []struct {
+		addr string
+		// the hosts that metrics are sent to
+		send []string
+	}{
+		{"udp6://[::1]:0", []string{"[::1]"}},
+		{"tcp6://[::1]:0", []string{"[::1]"}},
+		{"udp://:0", []string{"127.0.0.1", "[::1]"}},
+		{"tcp://:0", []string{"127.0.0.1", "[::1]"}},
+		{"udp://[::]:0", []string{"127.0.0.1", "[::1]"}},
+		{"udp4://:0", []string{"127.0.0.1"}},
+		{"udp6://:0", []string{"[::1]"}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.addr, func(t *testing.T) {
+			config := localConfig()
+			config.NumWorkers = 1
+			config.NumReaders = 2
+			config.Interval = "60s"
+			config.StatsdListenAddresses = []string{test.addr}
+			ch := make(chan []samplers.InterMetric, 20)
+			sink, _ := NewChannelMetricSink(ch)
+			f := newFixture(t, config, sink, nil)
+			defer f.Close()
+
+			addr := f.server.StatsdListenAddrs[0]
+			_, port, err := net.SplitHostPort(addr.String())
+			require.NoError(t, err)
+			for _, host := range test.send {
+				conn := connectToAddress(t, addr.Network(), host+":"+port, 20*time.Millisecond)
+				_, err := conn.Write([]byte("foo.bar:1|c\n"))
+				require.NoError(t, err)
+				conn.Close()
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			keepFlushing(ctx, f.server)
+			var received float64
+			for received < float64(len(test.send)) {
+				select {
+				case metrics := <-ch:
+					for _, m := range metrics {
+						received += m.Value
+					}
+				case <-ctx.Done():
+					t.Fatalf("received %v of %d metrics", received, len(test.send))
+				}
+			}
+		})
+	}
+}
+
+func TestListenV6Only(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "[::1]:0")
+	if err != nil {
+		t.Skip("the system doesn't support IPv6")
+	}
+	conn.Close()
+
+	addr, err := protocol.ResolveAddr("tcp6://:0")
+	require.NoError(t, err)
+	listener, err := NewTCPListener(addr.(*net.TCPAddr), false)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	_, err = net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	assert.Error(t, err, "a tcp6 listener shouldn't accept IPv4 connections")
+	client, err := net.Dial("tcp", fmt.Sprintf("[::1]:%d", port))
+	require.NoError(t, err)
+	client.Close()
+}
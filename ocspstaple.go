@@ -0,0 +1,197 @@
+package veneur
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspCheckInterval is how often the OCSP staple is checked, and
+// refreshed if it's due.
+const ocspCheckInterval = time.Minute
+
+// ocspRefreshInterval is how often the OCSP staple is refreshed if the
+// response doesn't say when the next update is.
+const ocspRefreshInterval = time.Hour
+
+// ocspStapler staples an OCSP response to the TLS certificate, so that
+// clients can check that it isn't revoked without asking the responder
+// themselves. The response is read from responseFile if it's set, or
+// fetched from the responder URL in the certificate otherwise, and it's
+// refreshed halfway through its validity, or when the certificate
+// changes. An expired response is no longer stapled.
+type ocspStapler struct {
+	responseFile string
+	client       *http.Client
+	// getCertificate returns the certificate to staple the response to.
+	getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+
+	mtx      sync.RWMutex
+	cert     *tls.Certificate
+	stapled  *tls.Certificate
+	response *ocsp.Response
+}
+
+func newOCSPStapler(responseFile string, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *ocspStapler {
+	return &ocspStapler{
+		responseFile:   responseFile,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		getCertificate: getCertificate,
+	}
+}
+
+// certificate returns the certificate with the OCSP response stapled to
+// it, for tls.Config's GetCertificate. Until there's a response for the
+// current certificate, it's returned without one.
+func (o *ocspStapler) certificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := o.getCertificate(hello)
+	if err != nil {
+		return nil, err
+	}
+	o.mtx.RLock()
+	defer o.mtx.RUnlock()
+	if o.cert != cert || o.stapled == nil {
+		return cert, nil
+	}
+	if next := o.response.NextUpdate; !next.IsZero() && !time.Now().Before(next) {
+		// clients would reject the expired response
+		return cert, nil
+	}
+	return o.stapled, nil
+}
+
+// refresh gets a new OCSP response if the current one is due to be
+// refreshed at now, or is for a previous certificate. If a new one can't
+// be had, the current one stays in use until it expires.
+func (o *ocspStapler) refresh(now time.Time) error {
+	cert, err := o.getCertificate(nil)
+	if err != nil {
+		return err
+	}
+	o.mtx.RLock()
+	due := o.cert != cert || o.response == nil || !now.Before(refreshTime(o.response))
+	o.mtx.RUnlock()
+	if !due {
+		return nil
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return err
+		}
+	}
+	var issuer *x509.Certificate
+	if len(cert.Certificate) > 1 {
+		if issuer, err = x509.ParseCertificate(cert.Certificate[1]); err != nil {
+			return err
+		}
+	}
+
+	var der []byte
+	if o.responseFile != "" {
+		der, err = ioutil.ReadFile(o.responseFile)
+	} else {
+		der, err = o.fetch(leaf, issuer)
+	}
+	if err != nil {
+		return err
+	}
+	response, err := ocsp.ParseResponseForCert(der, leaf, issuer)
+	if err != nil {
+		return err
+	}
+	if response.Status != ocsp.Good {
+		return fmt.Errorf("the OCSP response says the certificate's status is %d, not good", response.Status)
+	}
+	if !response.NextUpdate.IsZero() && !now.Before(response.NextUpdate) {
+		return fmt.Errorf("the OCSP response expired at %v", response.NextUpdate)
+	}
+
+	stapled := *cert
+	stapled.OCSPStaple = der
+	o.mtx.Lock()
+	o.cert = cert
+	o.stapled = &stapled
+	o.response = response
+	o.mtx.Unlock()
+	return nil
+}
+
+// fetch asks the certificate's OCSP responder for the certificate's
+// status.
+func (o *ocspStapler) fetch(leaf, issuer *x509.Certificate) ([]byte, error) {
+	if len(leaf.OCSPServer) == 0 {
+		return nil, errors.New("the certificate has no OCSP responder URL")
+	}
+	if issuer == nil {
+		return nil, errors.New("the certificate's chain must include its issuer to request its OCSP status")
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := o.client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the OCSP responder returned %s", resp.Status)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+}
+
+// nextUpdate returns when the stapled response expires, or false if
+// there's no response yet. The time is zero if the response doesn't say.
+func (o *ocspStapler) nextUpdate() (time.Time, bool) {
+	o.mtx.RLock()
+	defer o.mtx.RUnlock()
+	if o.response == nil {
+		return time.Time{}, false
+	}
+	return o.response.NextUpdate, true
+}
+
+// refreshTime returns when the response should be replaced: halfway
+// between its update and the next one.
+func refreshTime(response *ocsp.Response) time.Time {
+	if response.NextUpdate.IsZero() {
+		return response.ThisUpdate.Add(ocspRefreshInterval)
+	}
+	return response.ThisUpdate.Add(response.NextUpdate.Sub(response.ThisUpdate) / 2)
+}
+
+// watchOCSP refreshes the OCSP staple when it's due until the server
+// shuts down, and reports how long the staple is still valid for.
+func (s *Server) watchOCSP() {
+	ticker := time.NewTicker(ocspCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case now := <-ticker.C:
+			s.refreshOCSP(now)
+		}
+	}
+}
+
+func (s *Server) refreshOCSP(now time.Time) {
+	if err := s.ocspStapler.refresh(now); err != nil {
+		log.WithError(err).Warn("Could not refresh the OCSP staple")
+		s.Statsd.Count("tls.ocsp_refresh_errors_total", 1, nil, 1.0)
+	}
+	if next, ok := s.ocspStapler.nextUpdate(); ok && !next.IsZero() {
+		s.Statsd.Gauge("tls.ocsp_staple_remaining_seconds", next.Sub(now).Seconds(), nil, 1.0)
+	}
+}
@@ -0,0 +1,195 @@
+package veneur
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspFixture is a CA, a certificate it issued that names responderURL
+// as its OCSP responder, and a way to sign OCSP responses for it.
+type ocspFixture struct {
+	ca     *x509.Certificate
+	caKey  crypto.Signer
+	leaf   *x509.Certificate
+	tlsCrt *tls.Certificate
+}
+
+func newOCSPFixture(t *testing.T, responderURL string) *ocspFixture {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "veneur"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{responderURL},
+	}, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(leafDER)
+	require.NoError(t, err)
+
+	return &ocspFixture{
+		ca:    ca,
+		caKey: caKey,
+		leaf:  leaf,
+		tlsCrt: &tls.Certificate{
+			Certificate: [][]byte{leafDER, caDER},
+			PrivateKey:  key,
+		},
+	}
+}
+
+func (f *ocspFixture) response(t *testing.T, status int, thisUpdate, nextUpdate time.Time) []byte {
+	der, err := ocsp.CreateResponse(f.ca, f.ca, ocsp.Response{
+		Status:       status,
+		SerialNumber: f.leaf.SerialNumber,
+		ThisUpdate:   thisUpdate,
+		NextUpdate:   nextUpdate,
+	}, f.caKey)
+	require.NoError(t, err)
+	return der
+}
+
+func (f *ocspFixture) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return f.tlsCrt, nil
+}
+
+func TestOCSPStaplerFetches(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	var f *ocspFixture
+	requests := 0
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		req, err := ocsp.ParseRequest(body)
+		require.NoError(t, err)
+		assert.Equal(t, f.leaf.SerialNumber, req.SerialNumber)
+		w.Write(f.response(t, ocsp.Good, now, now.Add(4*time.Hour)))
+	}))
+	defer responder.Close()
+	f = newOCSPFixture(t, responder.URL)
+
+	o := newOCSPStapler("", f.getCertificate)
+	cert, err := o.certificate(nil)
+	require.NoError(t, err)
+	assert.Empty(t, cert.OCSPStaple, "there's no response to staple yet")
+
+	require.NoError(t, o.refresh(now))
+	cert, err = o.certificate(nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, cert.OCSPStaple)
+	response, err := ocsp.ParseResponseForCert(cert.OCSPStaple, f.leaf, f.ca)
+	require.NoError(t, err)
+	assert.Equal(t, ocsp.Good, response.Status)
+	assert.Empty(t, f.tlsCrt.OCSPStaple, "the certificate itself shouldn't be modified")
+
+	require.NoError(t, o.refresh(now.Add(time.Hour)))
+	assert.Equal(t, 1, requests, "the response shouldn't be refreshed before halfway through its validity")
+	require.NoError(t, o.refresh(now.Add(2*time.Hour)))
+	assert.Equal(t, 2, requests)
+}
+
+func TestOCSPStaplerFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "veneur-ocsp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	responseFile := filepath.Join(dir, "response.der")
+
+	now := time.Now().Truncate(time.Minute)
+	f := newOCSPFixture(t, "http://ocsp.example.com")
+	o := newOCSPStapler(responseFile, f.getCertificate)
+
+	require.NoError(t, ioutil.WriteFile(responseFile, f.response(t, ocsp.Revoked, now, now.Add(time.Hour)), 0600))
+	assert.Error(t, o.refresh(now), "a revoked status shouldn't be stapled")
+
+	require.NoError(t, ioutil.WriteFile(responseFile, f.response(t, ocsp.Good, now, now.Add(time.Hour)), 0600))
+	require.NoError(t, o.refresh(now))
+	cert, err := o.certificate(nil)
+	require.NoError(t, err)
+	assert.NotEmpty(t, cert.OCSPStaple)
+	next, ok := o.nextUpdate()
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(time.Hour).UTC(), next.UTC())
+
+	require.NoError(t, os.Remove(responseFile))
+	assert.Error(t, o.refresh(now.Add(30*time.Minute)), "the response file is gone")
+
+	// the certificate is reloaded
+	f.tlsCrt = &tls.Certificate{Certificate: f.tlsCrt.Certificate, PrivateKey: f.tlsCrt.PrivateKey}
+	cert, err = o.certificate(nil)
+	require.NoError(t, err)
+	assert.Empty(t, cert.OCSPStaple, "the response for the previous certificate shouldn't be stapled")
+}
+
+func TestOCSPStapledWithoutServerName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "veneur-ocsp")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	responseFile := filepath.Join(dir, "response.der")
+
+	now := time.Now().Truncate(time.Minute)
+	f := newOCSPFixture(t, "http://ocsp.example.com")
+	require.NoError(t, ioutil.WriteFile(responseFile, f.response(t, ocsp.Good, now, now.Add(time.Hour)), 0600))
+
+	var certPEM []byte
+	for _, der := range f.tlsCrt.Certificate {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(f.tlsCrt.PrivateKey.(*ecdsa.PrivateKey))
+	require.NoError(t, err)
+
+	config := localConfig()
+	config.TLSCertificate = string(certPEM)
+	config.TLSKey = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	config.TLSOCSPResponseFile = responseFile
+	s, err := NewFromConfig(logrus.New(), config)
+	require.NoError(t, err)
+	require.NoError(t, s.ocspStapler.refresh(now))
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+	go tls.Server(serverConn, s.tlsConfig).Handshake()
+
+	// no ServerName, so no SNI
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, client.Handshake())
+	assert.NotEmpty(t, client.ConnectionState().OCSPResponse, "the response should be stapled")
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/segmentio/fasthash/fnv1a"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/v14/protocol"
@@ -198,6 +199,8 @@ func TestParseSSFIndicatorSpan(t *testing.T) {
 		assert.Equal(t, "timer_name", m.Name)
 		assert.Equal(t, "histogram", m.Type)
 		assert.InEpsilon(t, float32(duration/time.Nanosecond), m.Value, 0.001)
+		assert.Equal(t, int64(5), m.TraceID, "the timer should be traced, for exemplars")
+		assert.Equal(t, int64(1), m.SpanID)
 		if assert.Equal(t, 2, len(m.Tags)) {
 			var tags sort.StringSlice = m.Tags
 			sort.Sort(tags)
@@ -541,7 +544,7 @@ func TestParserDistribution(t *testing.T) {
 	assert.NotNil(t, m, "Got nil metric!")
 	assert.Equal(t, "a.b.c", m.Name, "Name")
 	assert.Equal(t, float64(0.1716441474854946), m.Value, "Value")
-	assert.Equal(t, "histogram", m.Type, "Type")
+	assert.Equal(t, "distribution", m.Type, "Type")
 }
 
 func TestParserTimerFloat(t *testing.T) {
@@ -584,6 +587,26 @@ func TestParserWithTags(t *testing.T) {
 	assert.Contains(t, valueError.Error(), "Invalid number", "Invalid number error missing")
 }
 
+func TestParserAddTags(t *testing.T) {
+	m, err := samplers.ParseMetric([]byte("a.b.c:1|c|#foo:bar,baz:gorch"))
+	require.NoError(t, err)
+	m.AddTags("veneur_client:example")
+
+	expected, err := samplers.ParseMetric([]byte("a.b.c:1|c|#foo:bar,baz:gorch,veneur_client:example"))
+	require.NoError(t, err)
+	assert.Equal(t, expected.MetricKey, m.MetricKey, "should have the same key as if the tag had been sent")
+	assert.Equal(t, expected.Digest, m.Digest, "should have the same digest as if the tag had been sent")
+	assert.Equal(t, expected.Tags, m.Tags, "tags should be sorted")
+
+	untagged, err := samplers.ParseMetric([]byte("a.b.c:1|c"))
+	require.NoError(t, err)
+	untagged.AddTags("veneur_client:example")
+	expected, err = samplers.ParseMetric([]byte("a.b.c:1|c|#veneur_client:example"))
+	require.NoError(t, err)
+	assert.Equal(t, expected.MetricKey, untagged.MetricKey)
+	assert.Equal(t, expected.Digest, untagged.Digest)
+}
+
 func TestParserWithSampleRate(t *testing.T) {
 	m, _ := samplers.ParseMetric([]byte("a.b.c:1|c|@0.1"))
 	assert.NotNil(t, m, "Got nil metric!")
@@ -614,6 +637,178 @@ func TestParserWithSampleRateAndTags(t *testing.T) {
 	assert.Contains(t, valueError.Error(), "Invalid number", "Invalid number error missing")
 }
 
+func TestParserContainerID(t *testing.T) {
+	for _, packet := range []string{
+		"a.b.c:1|c|@0.1|#foo:bar|c:abc123",
+		"a.b.c:1|c|c:abc123|@0.1|#foo:bar",
+		"a.b.c:1|c|#foo:bar|c:abc123|@0.1",
+	} {
+		m, err := samplers.ParseMetric([]byte(packet))
+		require.NoError(t, err, packet)
+		assert.Equal(t, "abc123", m.ContainerID, packet)
+		assert.Equal(t, "counter", m.Type, packet)
+		assert.Equal(t, float32(0.1), m.SampleRate, packet)
+		assert.Equal(t, []string{"foo:bar"}, m.Tags, "the container ID should not be a tag: %s", packet)
+
+		without, err := samplers.ParseMetric([]byte("a.b.c:1|c|@0.1|#foo:bar"))
+		require.NoError(t, err)
+		assert.Equal(t, without.MetricKey, m.MetricKey, packet)
+		assert.Equal(t, without.Digest, m.Digest, packet)
+	}
+
+	m, err := samplers.ParseMetric([]byte("a.b.c:1|c|#foo:bar"))
+	require.NoError(t, err)
+	assert.Empty(t, m.ContainerID)
+
+	_, err = samplers.ParseMetric([]byte("a.b.c:1|c|c:abc|c:def"))
+	assert.Error(t, err, "should not accept multiple container IDs")
+}
+
+func TestParserTimestamp(t *testing.T) {
+	m, err := samplers.ParseMetric([]byte("a.b.c:1|c|T1656581400|#foo:bar"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1656581400), m.Timestamp)
+	assert.Equal(t, []string{"foo:bar"}, m.Tags)
+
+	m, err = samplers.ParseMetric([]byte("a.b.c:1|g"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), m.Timestamp)
+
+	for _, packet := range []string{"a.b.c:1|c|T", "a.b.c:1|c|Tnope", "a.b.c:1|c|T-5", "a.b.c:1|c|T1|T2"} {
+		_, err := samplers.ParseMetric([]byte(packet))
+		assert.Error(t, err, packet)
+	}
+}
+
+func TestParserJSON(t *testing.T) {
+	m, err := samplers.ParseMetricJSON([]byte(`{"name": "a.b.c", "type": "counter", "value": 1, "tags": ["foo:bar", "baz:gorch"], "sample_rate": 0.1}`))
+	require.NoError(t, err)
+	statsd, err := samplers.ParseMetric([]byte("a.b.c:1|c|@0.1|#foo:bar,baz:gorch"))
+	require.NoError(t, err)
+	assert.Equal(t, statsd, m, "should parse the same as the equivalent statsd packet")
+
+	m, err = samplers.ParseMetricJSON([]byte(`{"name": "a.b.c", "type": "distribution", "value": 2.5, "tags": ["veneurlocalonly"]}`))
+	require.NoError(t, err)
+	assert.Equal(t, "distribution", m.Type)
+	assert.Equal(t, 2.5, m.Value)
+	assert.Equal(t, float32(1), m.SampleRate)
+	assert.Equal(t, samplers.LocalOnly, m.Scope)
+	assert.Empty(t, m.Tags)
+
+	m, err = samplers.ParseMetricJSON([]byte(`{"name": "a.b.c", "type": "set", "value": "user1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "user1", m.Value)
+
+	for line, reason := range map[string]string{
+		`{"name": "a.b.c", "type": "counter", "value": 1`:                     "truncated",
+		`{"type": "counter", "value": 1}`:                                     "no name",
+		`{"name": "a.b.c", "type": "c", "value": 1}`:                          "unknown type",
+		`{"name": "a.b.c", "type": "counter"}`:                                "no value",
+		`{"name": "a.b.c", "type": "counter", "value": "1"}`:                  "string value",
+		`{"name": "a.b.c", "type": "set", "value": 1}`:                        "numeric set value",
+		`{"name": "a.b.c", "type": "counter", "value": 1, "sample_rate": 2}`:  "sample rate",
+		`{"name": "a.b.c", "type": "counter", "value": 1, "tags": "foo:bar"}`: "tags not a list",
+	} {
+		_, err := samplers.ParseMetricJSON([]byte(line))
+		assert.Error(t, err, reason)
+	}
+}
+
+func TestContainerIDTag(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		w := NewWorker(1, false, false, nil, logrus.New(), nil)
+		s := &Server{Workers: []*Worker{w}, containerIDTag: enabled}
+		require.NoError(t, s.HandleMetricPacket([]byte("a.b.c:1|c|#foo:bar|c:abc123"), DOGSTATSD_UDP))
+
+		m := <-w.PacketChan
+		if enabled {
+			assert.Equal(t, []string{"container_id:abc123", "foo:bar"}, m.Tags)
+		} else {
+			assert.Equal(t, []string{"foo:bar"}, m.Tags)
+		}
+	}
+}
+
+func TestMetricNameValidation(t *testing.T) {
+	names, err := samplers.NewNameValidator(`[a-zA-Z0-9_.]`, 16)
+	require.NoError(t, err)
+
+	for _, sanitize := range []bool{false, true} {
+		w := NewWorker(1, false, false, nil, logrus.New(), nil)
+		s := &Server{Workers: []*Worker{w}, metricNames: names, sanitizeMetricNames: sanitize}
+		require.NoError(t, s.HandleMetricPacket([]byte("a.b.c:1|c|#foo:bar"), DOGSTATSD_UDP))
+		require.NoError(t, s.HandleMetricPacket([]byte("a b.ü:1|c|#foo:bar"), DOGSTATSD_UDP))
+		s.handleJSONLine([]byte(`{"name": "a.really.long.name.indeed", "type": "counter", "value": 1}`), nil)
+
+		m := <-w.PacketChan
+		assert.Equal(t, "a.b.c", m.Name, "valid names should be left alone")
+		if !sanitize {
+			assert.Empty(t, w.PacketChan, "metrics with invalid names should be dropped")
+			continue
+		}
+		require.Len(t, w.PacketChan, 2)
+		m = <-w.PacketChan
+		assert.Equal(t, "a_b._", m.Name)
+		expected, err := samplers.ParseMetric([]byte("a_b._:1|c|#foo:bar"))
+		require.NoError(t, err)
+		assert.Equal(t, expected.Digest, m.Digest, "the digest should be of the sanitized name")
+		m = <-w.PacketChan
+		assert.Equal(t, "a.really.long.na", m.Name, "long names should be truncated")
+	}
+}
+
+// Histograms sampled at a rate should flush the same aggregates as they
+// would have if every value had been sent: the count and sum are scaled
+// by the inverse of the rate, and the distribution stays the same.
+func TestParserHistogramSampleRate(t *testing.T) {
+	aggregates := samplers.HistogramAggregates{
+		Value: samplers.AggregateMin | samplers.AggregateMax | samplers.AggregateCount |
+			samplers.AggregateSum | samplers.AggregateAverage | samplers.AggregateMedian,
+		Count: 6,
+	}
+	percentiles := []float64{0.25, 0.75, 0.99}
+
+	for rate, repeats := range map[string]int{"0.1": 10, "0.25": 4, "0.5": 2} {
+		rate, repeats := rate, repeats
+		t.Run(rate, func(t *testing.T) {
+			sampled := samplers.NewHist("a.b.c", nil)
+			unsampled := samplers.NewHist("a.b.c", nil)
+			for v := 1; v <= 100; v++ {
+				m, err := samplers.ParseMetric([]byte(fmt.Sprintf("a.b.c:%d|h|@%s", v, rate)))
+				require.NoError(t, err)
+				sampled.Sample(m.Value.(float64), m.SampleRate)
+
+				for i := 0; i < repeats; i++ {
+					m, err := samplers.ParseMetric([]byte(fmt.Sprintf("a.b.c:%d|h", v)))
+					require.NoError(t, err)
+					unsampled.Sample(m.Value.(float64), m.SampleRate)
+				}
+			}
+
+			// local histograms flush their own aggregates, and global
+			// ones those of their t-digest
+			for _, global := range []bool{false, true} {
+				want := map[string]float64{}
+				for _, m := range unsampled.Flush(10*time.Second, percentiles, aggregates, global) {
+					want[m.Name] = m.Value
+				}
+				got := map[string]float64{}
+				for _, m := range sampled.Flush(10*time.Second, percentiles, aggregates, global) {
+					got[m.Name] = m.Value
+				}
+				require.Len(t, got, len(want))
+
+				assert.InDelta(t, float64(100*repeats), got["a.b.c.count"], 1e-6, "count (global: %v)", global)
+				assert.InDelta(t, want["a.b.c.count"], got["a.b.c.count"], 1e-6, "count (global: %v)", global)
+				assert.InDelta(t, want["a.b.c.sum"], got["a.b.c.sum"], 1e-3, "sum (global: %v)", global)
+				for _, name := range []string{"a.b.c.min", "a.b.c.max", "a.b.c.avg", "a.b.c.median", "a.b.c.25percentile", "a.b.c.75percentile", "a.b.c.99percentile"} {
+					assert.InEpsilon(t, want[name], got[name], 0.02, "%s (global: %v)", name, global)
+				}
+			}
+		})
+	}
+}
+
 func TestInvalidPackets(t *testing.T) {
 	table := map[string]string{
 		"foo":                                "1 colon",
@@ -846,3 +1041,27 @@ func BenchmarkParseMetric(b *testing.B) {
 	// Attempt to avoid compiler optimizations? Is this relevant?
 	benchResult = total
 }
+
+func TestParseErrorCategory(t *testing.T) {
+	tests := []struct {
+		packet   string
+		category string
+	}{
+		{"a.b.c", samplers.ParseErrorFormat},
+		{"a.b.c:1|x", samplers.ParseErrorType},
+		{"a.b.c:foo|c", samplers.ParseErrorValue},
+		{"a.b.c:1|c|@2", samplers.ParseErrorSampleRate},
+		{"a.b.c:1|c|T-5", samplers.ParseErrorTimestamp},
+		{"a.b.c:1|c|#foo|#bar", samplers.ParseErrorTags},
+	}
+	for _, test := range tests {
+		_, err := samplers.ParseMetric([]byte(test.packet))
+		require.Error(t, err, test.packet)
+		assert.Equal(t, test.category, samplers.ParseErrorCategory(err), test.packet)
+	}
+
+	_, err := samplers.ParseMetricJSON([]byte(`{"name": "a.b.c", "type": "counter", "value": "x"}`))
+	assert.Equal(t, samplers.ParseErrorValue, samplers.ParseErrorCategory(err))
+	_, err = samplers.ParseEvent([]byte("_e{5,4}:title"))
+	assert.Equal(t, samplers.ParseErrorFormat, samplers.ParseErrorCategory(err))
+}
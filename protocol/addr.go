@@ -11,8 +11,14 @@ import (
 // string. If any error (in URL decoding, destructuring or resolving)
 // occurs, ResolveAddr returns the respective error.
 //
+// The IP of a TCP or UDP address tells which IP versions to listen on:
+// the unspecified address of a tcp4/udp4 network is 0.0.0.0 and that of
+// a tcp6/udp6 network is ::, while a tcp/udp address without an IP, or
+// with the IP ::, has a nil IP, which means listening on both IPv4 and
+// IPv6 where the platform allows it.
+//
 // Valid address examples are:
-//   udp6://127.0.0.1:8000
+//   udp6://[::1]:8000
 //   unix:///tmp/foo.sock
 //   tcp://127.0.0.1:9002
 func ResolveAddr(str string) (net.Addr, error) {
@@ -39,13 +45,36 @@ func ResolveAddr(str string) (net.Addr, error) {
 		if err != nil {
 			return nil, err
 		}
+		addr.IP = familyIP(u.Scheme, addr.IP)
 		return addr, nil
 	case "udp6", "udp4", "udp":
 		addr, err := net.ResolveUDPAddr(u.Scheme, u.Host)
 		if err != nil {
 			return nil, err
 		}
+		addr.IP = familyIP(u.Scheme, addr.IP)
 		return addr, nil
 	}
 	return nil, fmt.Errorf("unknown address family %q on address %q", u.Scheme, u.String())
 }
+
+// familyIP returns the IP that an address of the network should have, so
+// that a listener can tell which network it's for, as described in
+// ResolveAddr.
+func familyIP(network string, ip net.IP) net.IP {
+	switch network[len(network)-1] {
+	case '4':
+		if ip == nil {
+			return net.IPv4zero.To4()
+		}
+	case '6':
+		if ip == nil {
+			return net.IPv6unspecified
+		}
+	default:
+		if ip.Equal(net.IPv6unspecified) {
+			return nil
+		}
+	}
+	return ip
+}
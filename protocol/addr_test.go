@@ -15,6 +15,11 @@ func TestListenAddr(t *testing.T) {
 		{"udp://127.0.0.1:8200", "udp", "127.0.0.1:8200"},
 		{"tcp://:8200", "tcp", ":8200"},
 		{"tcp6://[::1]:8200", "tcp", "[::1]:8200"},
+		{"udp4://:8200", "udp", "0.0.0.0:8200"},
+		{"udp6://:8200", "udp", "[::]:8200"},
+		{"udp://[::]:8200", "udp", ":8200"},
+		{"tcp4://:8200", "tcp", "0.0.0.0:8200"},
+		{"tcp6://:8200", "tcp", "[::]:8200"},
 		{"unix:///tmp/foo.sock", "unix", "/tmp/foo.sock"},
 		{"unix:@abstract.sock", "unix", "@abstract.sock"},
 		{"unixgram:///tmp/foo.sock", "unixgram", "/tmp/foo.sock"},
@@ -1,6 +1,9 @@
 package protocol
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+)
 
 type errFramingIO struct {
 	err error
@@ -40,3 +43,15 @@ func IsFramingError(err error) bool {
 	}
 	return false
 }
+
+// IsHangup returns true if an error indicates that the client closed
+// the stream in the middle of a frame. Like any other framing error,
+// the stream must be closed, but this is usually not a cause for
+// concern.
+func IsHangup(err error) bool {
+	ioErr, ok := err.(*errFramingIO)
+	if !ok {
+		return false
+	}
+	return ioErr.err == io.EOF || ioErr.err == io.ErrUnexpectedEOF
+}
@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"fmt"
+	"io"
 	"testing"
 
 	"os"
@@ -16,3 +17,12 @@ func TestFramingError(t *testing.T) {
 
 	assert.False(t, IsFramingError(os.ErrClosed))
 }
+
+func TestHangup(t *testing.T) {
+	assert.True(t, IsHangup(&errFramingIO{io.EOF}))
+	assert.True(t, IsHangup(&errFramingIO{io.ErrUnexpectedEOF}))
+
+	assert.False(t, IsHangup(io.EOF), "EOF at a frame boundary is not a framing error")
+	assert.False(t, IsHangup(&errFramingIO{fmt.Errorf("oh hai")}))
+	assert.False(t, IsHangup(&errFrameLength{0}))
+}
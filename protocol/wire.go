@@ -13,15 +13,24 @@
 //   [32 bits - length of framed message in octets]
 //   [<length> - SSF message]
 //
-// The version and type of message can currently only be set to the
-// value 0, which means that what follows is a protobuf-encoded
-// ssf.SSFSpan.
+// The version and type of message can currently be set to the
+// following values:
+//
+//   0: what follows is a protobuf-encoded ssf.SSFSpan.
+//   1: what follows is a protobuf-encoded ssf.SSFSpan, compressed
+//      with zstd.
+//
+// Each frame has its own version, so a stream can mix compressed and
+// uncompressed frames, and clients that only write version 0 frames
+// can talk to any veneur.
 //
 // The length of the framed message is a number of octets (8-bit
 // bytes) in network byte order (big-endian), specifying the number of
 // octets taken up by the SSF message that follows directly on the
 // stream. To avoid DoS'ing Veneur instances, no lengths greater than
-// MaxSSFPacketLength (currently 16MB) can be read or encoded.
+// MaxSSFPacketLength (currently 16MB) can be read or encoded. This
+// also applies to the length of compressed messages once they're
+// decompressed.
 //
 // Since this protocol does not contain any re-syncing hints, any
 // framing error on the stream is automatically fatal. The stream must
@@ -29,6 +38,7 @@
 package protocol
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"sync"
@@ -36,6 +46,7 @@ import (
 	"encoding/binary"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stripe/veneur/v14/ssf"
 )
 
@@ -48,23 +59,50 @@ const MaxSSFPacketLength uint32 = 16 * 1024 * 1024
 // length.
 const SSFFrameLength uint32 = 1 + 4
 
-// The only version we support right now: A frame with a length
-// followed by an ssf.SSFSpan.
+// A frame with a length followed by an ssf.SSFSpan.
 const version0 uint8 = 0
 
+// A frame with a length followed by an ssf.SSFSpan compressed with zstd.
+const version1 uint8 = 1
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// zstdCodecs returns the zstd encoder and decoder that every frame
+// is compressed and decompressed with. Both are safe for concurrent
+// use with EncodeAll and DecodeAll.
+func zstdCodecs() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		// neither can fail with these options
+		zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(MaxSSFPacketLength)))
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// frameChunkSize is the amount of memory that readFrame allocates up
+// front for a frame. Longer frames' buffers grow as their data actually
+// arrives, so that a bogus length prefix can't make us allocate
+// MaxSSFPacketLength bytes at once.
+const frameChunkSize = 64 * 1024
+
 func readFrame(in io.Reader, length int) ([]byte, error) {
-	bts := make([]byte, length)
-	read := 0
-	for {
-		n, err := in.Read(bts[read:])
-		if err != nil {
-			return []byte{}, err
-		}
-		read += n
-		if read == length {
-			return bts, nil
+	initial := length
+	if initial > frameChunkSize {
+		initial = frameChunkSize
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, initial))
+	if _, err := io.CopyN(buf, in, int64(length)); err != nil {
+		if err == io.EOF {
+			// the frame was cut short:
+			err = io.ErrUnexpectedEOF
 		}
+		return []byte{}, err
 	}
+	return buf.Bytes(), nil
 }
 
 // InvalidTrace is an error type indicating that an SSF span was
@@ -104,8 +142,19 @@ func ValidateTrace(span *ssf.SSFSpan) error {
 // IsFramingError to decide if the error means the stream is
 // unrecoverably broken. The error is EOF only if no bytes were read
 // at the start of a message (e.g. if a connection was closed after
-// the last message).
+// the last message); IsHangup reports whether the connection was
+// closed in the middle of a message.
 func ReadSSF(in io.Reader) (*ssf.SSFSpan, error) {
+	return ReadSSFLimit(in, MaxSSFPacketLength)
+}
+
+// ReadSSFLimit works like ReadSSF, but rejects frames longer than
+// maxLength with a framing error. maxLength can not exceed
+// MaxSSFPacketLength.
+func ReadSSFLimit(in io.Reader, maxLength uint32) (*ssf.SSFSpan, error) {
+	if maxLength > MaxSSFPacketLength {
+		maxLength = MaxSSFPacketLength
+	}
 	var version uint8
 	var length uint32
 	if err := binary.Read(in, binary.BigEndian, &version); err != nil {
@@ -116,19 +165,31 @@ func ReadSSF(in io.Reader) (*ssf.SSFSpan, error) {
 		}
 		return nil, &errFramingIO{err}
 	}
-	if version != version0 {
+	if version != version0 && version != version1 {
 		return nil, &errFrameVersion{version}
 	}
 	if err := binary.Read(in, binary.BigEndian, &length); err != nil {
 		return nil, &errFramingIO{err}
 	}
-	if length > MaxSSFPacketLength {
+	if length > maxLength {
 		return nil, &errFrameLength{length}
 	}
 	bts, err := readFrame(in, int(length))
 	if err != nil {
 		return nil, &errFramingIO{err}
 	}
+	if version == version1 {
+		// The whole frame was read, so the stream can still be read
+		// if it can't be decompressed.
+		_, decoder := zstdCodecs()
+		bts, err = decoder.DecodeAll(bts, nil)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress SSF frame: %v", err)
+		}
+		if uint32(len(bts)) > maxLength {
+			return nil, fmt.Errorf("decompressed SSF frame length %d is too large", len(bts))
+		}
+	}
 	return ParseSSF(bts)
 }
 
@@ -184,6 +245,17 @@ var pbufPool = sync.Pool{
 // If the error matches IsFramingError, the stream must be considered
 // poisoned and should not be re-used.
 func WriteSSF(out io.Writer, ssf *ssf.SSFSpan) (int, error) {
+	return writeSSF(out, ssf, false)
+}
+
+// WriteSSFCompressed works like WriteSSF, but compresses the SSF span
+// with zstd, and writes it with a preceding v1 frame. The stream can only
+// be read by veneurs that support v1 frames.
+func WriteSSFCompressed(out io.Writer, ssf *ssf.SSFSpan) (int, error) {
+	return writeSSF(out, ssf, true)
+}
+
+func writeSSF(out io.Writer, ssf *ssf.SSFSpan, compress bool) (int, error) {
 	pbuf := pbufPool.Get().(*proto.Buffer)
 	err := pbuf.Marshal(ssf)
 	if err != nil {
@@ -198,13 +270,18 @@ func WriteSSF(out io.Writer, ssf *ssf.SSFSpan) (int, error) {
 		pbufPool.Put(pbuf)
 	}()
 
-	if err = binary.Write(out, binary.BigEndian, version0); err != nil {
+	version, msg := version0, pbuf.Bytes()
+	if compress {
+		encoder, _ := zstdCodecs()
+		version, msg = version1, encoder.EncodeAll(msg, nil)
+	}
+	if err = binary.Write(out, binary.BigEndian, version); err != nil {
 		return 0, &errFramingIO{err}
 	}
-	if err = binary.Write(out, binary.BigEndian, uint32(len(pbuf.Bytes()))); err != nil {
+	if err = binary.Write(out, binary.BigEndian, uint32(len(msg))); err != nil {
 		return 0, &errFramingIO{err}
 	}
-	n, err := out.Write(pbuf.Bytes())
+	n, err := out.Write(msg)
 	if err != nil {
 		return n, &errFramingIO{err}
 	}
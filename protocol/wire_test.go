@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -92,7 +93,7 @@ func TestReadSSFStreamBad(t *testing.T) {
 
 	// Bad: illegal frame:
 	{
-		buf := bytes.NewBuffer([]byte{0x01, 0x00})
+		buf := bytes.NewBuffer([]byte{0x02, 0x00})
 		read, err := ReadSSF(buf)
 		if assert.Error(t, err) {
 			assert.True(t, IsFramingError(err))
@@ -132,6 +133,115 @@ func TestReadSSFStreamBad(t *testing.T) {
 	}
 }
 
+func TestReadSSFPartialFrame(t *testing.T) {
+	msg := &ssf.SSFSpan{
+		Version:        1,
+		TraceId:        1,
+		Id:             2,
+		ParentId:       3,
+		StartTimestamp: 9000,
+		EndTimestamp:   9001,
+	}
+	full := bytes.NewBuffer([]byte{})
+	_, err := WriteSSF(full, msg)
+	require.NoError(t, err)
+
+	// Cut the frame short in the length prefix, and in the message:
+	for _, cut := range []int{1, 3, int(SSFFrameLength), full.Len() - 1} {
+		buf := bytes.NewBuffer(full.Bytes()[:cut])
+		read, err := ReadSSF(buf)
+		if assert.Error(t, err, "cut at %d", cut) {
+			assert.True(t, IsFramingError(err), "cut at %d", cut)
+			assert.True(t, IsHangup(err), "cut at %d", cut)
+		}
+		assert.Nil(t, read)
+	}
+}
+
+func TestReadSSFLimit(t *testing.T) {
+	msg := &ssf.SSFSpan{
+		Version:        1,
+		TraceId:        1,
+		Id:             2,
+		ParentId:       3,
+		StartTimestamp: 9000,
+		EndTimestamp:   9001,
+		Name:           "a span with a reasonably long name",
+	}
+	buf := bytes.NewBuffer([]byte{})
+	_, err := WriteSSF(buf, msg)
+	require.NoError(t, err)
+	length := uint32(buf.Len()) - SSFFrameLength
+
+	read, err := ReadSSFLimit(bytes.NewBuffer(buf.Bytes()), length)
+	require.NoError(t, err)
+	assert.Equal(t, msg.Name, read.Name)
+
+	read, err = ReadSSFLimit(bytes.NewBuffer(buf.Bytes()), length-1)
+	if assert.Error(t, err) {
+		assert.True(t, IsFramingError(err))
+		assert.False(t, IsHangup(err))
+	}
+	assert.Nil(t, read)
+}
+
+func TestReadSSFCompressed(t *testing.T) {
+	msg := &ssf.SSFSpan{
+		Version:        1,
+		TraceId:        1,
+		Id:             2,
+		StartTimestamp: 9000,
+		EndTimestamp:   9001,
+		Name:           strings.Repeat("a span with a compressible name ", 16),
+	}
+	// Compressed and uncompressed frames can be mixed on a stream:
+	buf := bytes.NewBuffer([]byte{})
+	_, err := WriteSSFCompressed(buf, msg)
+	require.NoError(t, err)
+	compressedLength := buf.Len()
+	_, err = WriteSSF(buf, msg)
+	require.NoError(t, err)
+	assert.True(t, compressedLength < buf.Len()-compressedLength, "the frame should be compressed")
+
+	for i := 0; i < 2; i++ {
+		read, err := ReadSSF(buf)
+		require.NoError(t, err)
+		assert.Equal(t, msg.Name, read.Name)
+	}
+	_, err = ReadSSF(buf)
+	assert.Equal(t, io.EOF, err)
+
+	// The limit applies to the decompressed message:
+	_, err = WriteSSFCompressed(buf, msg)
+	require.NoError(t, err)
+	read, err := ReadSSFLimit(buf, uint32(len(msg.Name)))
+	if assert.Error(t, err) {
+		assert.False(t, IsFramingError(err), "the whole frame was read")
+	}
+	assert.Nil(t, read)
+
+	// Bad: corrupted compressed message:
+	_, err = WriteSSFCompressed(buf, msg)
+	require.NoError(t, err)
+	buf.Bytes()[SSFFrameLength] = 0xff
+	read, err = ReadSSF(buf)
+	if assert.Error(t, err) {
+		assert.False(t, IsFramingError(err))
+	}
+	assert.Nil(t, read)
+	assert.Zero(t, buf.Len(), "the corrupted frame should be consumed")
+}
+
+func TestReadSSFBogusLength(t *testing.T) {
+	// A frame that claims to be MaxSSFPacketLength long, but isn't:
+	buf := bytes.NewBuffer([]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x02, 0x03})
+	read, err := ReadSSF(buf)
+	if assert.Error(t, err) {
+		assert.True(t, IsHangup(err))
+	}
+	assert.Nil(t, read)
+}
+
 func BenchmarkValidTrace(b *testing.B) {
 	const Len = 1000
 	input := make([]*ssf.SSFSpan, Len)
@@ -42,21 +42,26 @@ type Proxy struct {
 	ForwardDestinations        *consistent.Consistent
 	TraceDestinations          *consistent.Consistent
 	ForwardGRPCDestinations    *consistent.Consistent
+	TraceGRPCDestinations      *consistent.Consistent
 	Discoverer                 Discoverer
 	ConsulForwardService       string
 	ConsulTraceService         string
 	ConsulForwardGRPCService   string
+	ConsulTraceGRPCService     string
 	ConsulInterval             time.Duration
 	MetricsInterval            time.Duration
 	ForwardDestinationsMtx     sync.Mutex
 	TraceDestinationsMtx       sync.Mutex
 	ForwardGRPCDestinationsMtx sync.Mutex
+	TraceGRPCDestinationsMtx   sync.Mutex
 	HTTPAddr                   string
 	HTTPClient                 *http.Client
 	AcceptingForwards          bool
 	AcceptingTraces            bool
 	AcceptingGRPCForwards      bool
+	AcceptingGRPCTraces        bool
 	ForwardTimeout             time.Duration
+	ForwardEncoding            string
 
 	usingConsul     bool
 	usingKubernetes bool
@@ -68,6 +73,10 @@ type Proxy struct {
 	grpcServer        *proxysrv.Server
 	grpcListenAddress string
 
+	// backendHealthCheckers check the destinations of the rings they
+	// are keyed by, if backend health checks are enabled.
+	backendHealthCheckers map[*consistent.Consistent]*backendHealthChecker
+
 	// HTTP
 	// An atomic boolean for whether or not the HTTP server is listening
 	numListeningHTTP *int32
@@ -128,6 +137,7 @@ func NewProxyFromConfig(logger *logrus.Logger, conf ProxyConfig) (p Proxy, err e
 	p.ConsulForwardService = conf.ConsulForwardServiceName
 	p.ConsulTraceService = conf.ConsulTraceServiceName
 	p.ConsulForwardGRPCService = conf.ConsulForwardGrpcServiceName
+	p.ConsulTraceGRPCService = conf.ConsulTraceGrpcServiceName
 
 	if p.ConsulForwardService != "" || conf.ForwardAddress != "" {
 		p.AcceptingForwards = true
@@ -138,13 +148,17 @@ func NewProxyFromConfig(logger *logrus.Logger, conf ProxyConfig) (p Proxy, err e
 	if p.ConsulForwardGRPCService != "" || conf.GrpcForwardAddress != "" {
 		p.AcceptingGRPCForwards = true
 	}
+	if p.ConsulTraceGRPCService != "" || conf.GrpcTraceAddress != "" {
+		p.AcceptingGRPCTraces = true
+	}
 
 	// We need a convenient way to know if we're even using Consul later
-	if p.ConsulForwardService != "" || p.ConsulTraceService != "" || p.ConsulForwardGRPCService != "" {
+	if p.ConsulForwardService != "" || p.ConsulTraceService != "" || p.ConsulForwardGRPCService != "" || p.ConsulTraceGRPCService != "" {
 		log.WithFields(logrus.Fields{
 			"consulForwardService":     p.ConsulForwardService,
 			"consulTraceService":       p.ConsulTraceService,
 			"consulGRPCForwardService": p.ConsulForwardGRPCService,
+			"consulGRPCTraceService":   p.ConsulTraceGRPCService,
 		}).Info("Using consul for service discovery")
 		p.usingConsul = true
 	}
@@ -160,9 +174,15 @@ func NewProxyFromConfig(logger *logrus.Logger, conf ProxyConfig) (p Proxy, err e
 		}
 	}
 
-	p.ForwardDestinations = consistent.New()
-	p.TraceDestinations = consistent.New()
-	p.ForwardGRPCDestinations = consistent.New()
+	if conf.ConsistentHashReplicas < 0 {
+		err = fmt.Errorf("consistent_hash_replicas must not be negative, got %d", conf.ConsistentHashReplicas)
+		logger.WithError(err).Error("Invalid consistent hash configuration")
+		return
+	}
+	p.ForwardDestinations = newRing(conf.ConsistentHashReplicas)
+	p.TraceDestinations = newRing(conf.ConsistentHashReplicas)
+	p.ForwardGRPCDestinations = newRing(conf.ConsistentHashReplicas)
+	p.TraceGRPCDestinations = newRing(conf.ConsistentHashReplicas)
 
 	if conf.ForwardTimeout != "" {
 		p.ForwardTimeout, err = time.ParseDuration(conf.ForwardTimeout)
@@ -174,6 +194,17 @@ func NewProxyFromConfig(logger *logrus.Logger, conf ProxyConfig) (p Proxy, err e
 		}
 	}
 
+	p.ForwardEncoding = vhttp.EncodingDeflate
+	if conf.ForwardCompression != "" {
+		if err = vhttp.ValidateEncoding(conf.ForwardCompression); err != nil {
+			logger.WithError(err).
+				WithField("value", conf.ForwardCompression).
+				Error("Could not parse forward compression")
+			return
+		}
+		p.ForwardEncoding = conf.ForwardCompression
+	}
+
 	// We got a static forward address, stick it in the destination!
 	if p.ConsulForwardService == "" && conf.ForwardAddress != "" {
 		p.ForwardDestinations.Add(conf.ForwardAddress)
@@ -184,13 +215,17 @@ func NewProxyFromConfig(logger *logrus.Logger, conf ProxyConfig) (p Proxy, err e
 	if p.ConsulForwardGRPCService == "" && conf.GrpcForwardAddress != "" {
 		p.ForwardGRPCDestinations.Add(conf.GrpcForwardAddress)
 	}
+	if p.ConsulTraceGRPCService == "" && conf.GrpcTraceAddress != "" {
+		p.TraceGRPCDestinations.Add(conf.GrpcTraceAddress)
+	}
 
-	if !p.AcceptingForwards && !p.AcceptingTraces && !p.AcceptingGRPCForwards {
+	if !p.AcceptingForwards && !p.AcceptingTraces && !p.AcceptingGRPCForwards && !p.AcceptingGRPCTraces {
 		err = errors.New("refusing to start with no Consul service names or static addresses in config")
 		logger.WithError(err).WithFields(logrus.Fields{
 			"consul_forward_service_name":      p.ConsulForwardService,
 			"consul_trace_service_name":        p.ConsulTraceService,
 			"consul_forward_grpc_service_name": p.ConsulForwardGRPCService,
+			"consul_trace_grpc_service_name":   p.ConsulTraceGRPCService,
 			"forward_address":                  conf.ForwardAddress,
 			"trace_address":                    conf.TraceAddress,
 		}).Error("Oops")
@@ -251,16 +286,31 @@ func NewProxyFromConfig(logger *logrus.Logger, conf ProxyConfig) (p Proxy, err e
 		}
 	}
 
+	if conf.BackendHealthCheckInterval != "" {
+		err = p.newBackendHealthCheckers(conf)
+		if err != nil {
+			logger.WithError(err).Error("Invalid backend health check configuration")
+			return
+		}
+	}
+
 	if conf.GrpcAddress != "" {
 		p.grpcListenAddress = conf.GrpcAddress
 		p.grpcServer, err = proxysrv.New(p.ForwardGRPCDestinations,
 			proxysrv.WithForwardTimeout(p.ForwardTimeout),
 			proxysrv.WithLog(logrus.NewEntry(log)),
 			proxysrv.WithTraceClient(p.TraceClient),
+			proxysrv.WithSpanQueueSize(conf.SpanForwardQueueSize),
 		)
 		if err != nil {
 			logger.WithError(err).Fatal("Failed to initialize the gRPC server")
 		}
+		if p.AcceptingGRPCTraces {
+			err = p.grpcServer.SetTraceDestinations(p.TraceGRPCDestinations)
+			if err != nil {
+				logger.WithError(err).Fatal("Failed to set the trace destinations of the gRPC server")
+			}
+		}
 	}
 
 	// TODO Size of replicas in config?
@@ -317,6 +367,14 @@ func (p *Proxy) Start() {
 		}
 	}
 
+	if p.AcceptingGRPCTraces && p.ConsulTraceGRPCService != "" {
+		p.RefreshDestinations(p.ConsulTraceGRPCService, p.TraceGRPCDestinations, &p.TraceGRPCDestinationsMtx)
+		if len(p.TraceGRPCDestinations.Members()) == 0 {
+			log.WithField("serviceName", p.ConsulTraceGRPCService).Fatal("Refusing to start with zero destinations for tracing over gRPC.")
+		}
+		p.setGRPCTraceDestinations()
+	}
+
 	if p.AcceptingGRPCForwards && p.ConsulForwardGRPCService != "" {
 		p.RefreshDestinations(p.ConsulForwardGRPCService, p.ForwardGRPCDestinations, &p.ForwardGRPCDestinationsMtx)
 		if len(p.ForwardGRPCDestinations.Members()) == 0 {
@@ -325,6 +383,11 @@ func (p *Proxy) Start() {
 		p.grpcServer.SetDestinations(p.ForwardGRPCDestinations)
 	}
 
+	if p.backendHealthCheckers != nil {
+		log.Info("Starting backend health checks")
+		p.startBackendHealthChecks()
+	}
+
 	if p.usingConsul || p.usingKubernetes {
 		log.Info("Creating service discovery goroutine")
 		go func() {
@@ -338,6 +401,7 @@ func (p *Proxy) Start() {
 					"consulForwardService":     p.ConsulForwardService,
 					"consulTraceService":       p.ConsulTraceService,
 					"consulForwardGRPCService": p.ConsulForwardGRPCService,
+					"consulTraceGRPCService":   p.ConsulTraceGRPCService,
 				}).Debug("About to refresh destinations")
 				if p.AcceptingForwards && p.ConsulForwardService != "" {
 					p.RefreshDestinations(p.ConsulForwardService, p.ForwardDestinations, &p.ForwardDestinationsMtx)
@@ -349,6 +413,10 @@ func (p *Proxy) Start() {
 					p.RefreshDestinations(p.ConsulForwardGRPCService, p.ForwardGRPCDestinations, &p.ForwardGRPCDestinationsMtx)
 					p.grpcServer.SetDestinations(p.ForwardGRPCDestinations)
 				}
+				if p.AcceptingGRPCTraces && p.ConsulTraceGRPCService != "" {
+					p.RefreshDestinations(p.ConsulTraceGRPCService, p.TraceGRPCDestinations, &p.TraceGRPCDestinationsMtx)
+					p.setGRPCTraceDestinations()
+				}
 			}
 		}()
 	}
@@ -485,6 +553,17 @@ func (p *Proxy) gRPCStop() {
 	}
 }
 
+// setGRPCTraceDestinations updates the destinations that the gRPC server
+// forwards SSF spans to, if it's running.
+func (p *Proxy) setGRPCTraceDestinations() {
+	if p.grpcServer == nil {
+		return
+	}
+	if err := p.grpcServer.SetTraceDestinations(p.TraceGRPCDestinations); err != nil {
+		log.WithError(err).Error("Failed to update the trace destinations of the gRPC server")
+	}
+}
+
 // RefreshDestinations updates the server's list of valid destinations
 // for flushing. This should be called periodically to ensure we have
 // the latest data.
@@ -515,9 +594,62 @@ func (p *Proxy) RefreshDestinations(serviceName string, ring *consistent.Consist
 	}
 
 	mtx.Lock()
-	ring.Set(destinations)
+	before := ringOwners(ring)
+	if hc, ok := p.backendHealthCheckers[ring]; ok {
+		// Keep ejected destinations out of the ring until their
+		// health checks pass again.
+		ring.Set(hc.setDestinations(destinations))
+	} else {
+		ring.Set(destinations)
+	}
+	after := ringOwners(ring)
 	mtx.Unlock()
 	samples.Add(ssf.Gauge("discoverer.destination_number", float32(len(destinations)), srvTags))
+	if before != nil {
+		if moved := keysMoved(before, after); moved > 0 {
+			samples.Add(ssf.Gauge("discoverer.keys_moved_ratio", float32(moved), srvTags))
+		}
+	}
+}
+
+// ringProbeKeys is the number of keys that are looked up to estimate the
+// fraction of keys that move when a ring changes.
+const ringProbeKeys = 1000
+
+// newRing returns an empty consistent hash ring, on which each member
+// has the given number of virtual nodes, or the consistent package's
+// default if replicas is zero.
+func newRing(replicas int) *consistent.Consistent {
+	ring := consistent.New()
+	if replicas > 0 {
+		ring.NumberOfReplicas = replicas
+	}
+	return ring
+}
+
+// ringOwners returns the members of the ring that a fixed sample of
+// keys hash to, or nil if the ring is empty.
+func ringOwners(ring *consistent.Consistent) []string {
+	if len(ring.Members()) == 0 {
+		return nil
+	}
+	owners := make([]string, ringProbeKeys)
+	for i := range owners {
+		owners[i], _ = ring.Get(strconv.Itoa(i))
+	}
+	return owners
+}
+
+// keysMoved returns the fraction of the sampled keys whose owner
+// changed between before and after.
+func keysMoved(before, after []string) float64 {
+	moved := 0
+	for i := range before {
+		if before[i] != after[i] {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(before))
 }
 
 // Handler returns the Handler responsible for routing request processing.
@@ -645,7 +777,7 @@ func (p *Proxy) doPost(ctx context.Context, wg *sync.WaitGroup, destination stri
 	}
 
 	endpoint := fmt.Sprintf("%s/import", destination)
-	err := vhttp.PostHelper(ctx, p.HTTPClient, p.TraceClient, http.MethodPost, endpoint, batch, "forward", true, nil, log)
+	err := vhttp.PostHelperWithEncoding(ctx, p.HTTPClient, p.TraceClient, http.MethodPost, endpoint, batch, "forward", p.ForwardEncoding, nil, log)
 	if err == nil {
 		log.WithField("metrics", batchSize).Debug("Completed forward to Veneur")
 	} else {
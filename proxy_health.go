@@ -0,0 +1,385 @@
+package veneur
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"stathat.com/c/consistent"
+)
+
+// Defaults for the proxy's backend health checks, used if the
+// corresponding option is unset.
+const (
+	defaultBackendHealthCheckTimeout            = time.Second
+	defaultBackendHealthCheckUnhealthyThreshold = 3
+	defaultBackendHealthCheckHealthyThreshold   = 3
+	defaultBackendHealthCheckCooldown           = 30 * time.Second
+)
+
+// backendHealth is the health check state of one destination.
+type backendHealth struct {
+	ejected bool
+	// successes and failures count the consecutive checks that
+	// disagree with the current state.
+	successes int
+	failures  int
+	// changed is when the destination was last ejected or
+	// re-admitted.
+	changed time.Time
+}
+
+// backendHealthChecker periodically checks the health of the
+// destinations of one of the proxy's hash rings. Destinations that fail
+// unhealthyThreshold consecutive checks are ejected from the ring, so
+// their keys are redistributed over the remaining destinations, and are
+// re-admitted after healthyThreshold consecutive successful checks. A
+// destination stays in its state for at least cooldown, so that one that
+// is flapping doesn't reshuffle the ring on every check.
+type backendHealthChecker struct {
+	ringName           string
+	check              func(ctx context.Context, destination string) error
+	interval           time.Duration
+	timeout            time.Duration
+	unhealthyThreshold int
+	healthyThreshold   int
+	cooldown           time.Duration
+	traceClient        *trace.Client
+
+	// now returns the current time; it's overridden in tests.
+	now func() time.Time
+	// onChange, if set, is called after the checker changed the
+	// members of the ring.
+	onChange func()
+
+	ring    *consistent.Consistent
+	ringMtx *sync.Mutex
+
+	// mtx protects the fields below. When both are held, ringMtx is
+	// acquired first.
+	mtx          sync.Mutex
+	destinations []string
+	backends     map[string]*backendHealth
+}
+
+// setDestinations replaces the destinations that are checked by the
+// ones found by service discovery, and returns the ones that should be
+// members of the ring. It must be called with ringMtx held.
+func (hc *backendHealthChecker) setDestinations(destinations []string) []string {
+	hc.mtx.Lock()
+	defer hc.mtx.Unlock()
+
+	backends := make(map[string]*backendHealth, len(destinations))
+	for _, dest := range destinations {
+		if b, ok := hc.backends[dest]; ok {
+			backends[dest] = b
+		} else {
+			backends[dest] = &backendHealth{}
+		}
+	}
+	hc.destinations = destinations
+	hc.backends = backends
+	return hc.members()
+}
+
+// members returns the destinations that aren't ejected. If all of them
+// are, it returns all destinations: forwarding to an unhealthy veneur
+// may still succeed, and forwarding to none certainly won't. It must
+// be called with mtx held.
+func (hc *backendHealthChecker) members() []string {
+	members := make([]string, 0, len(hc.destinations))
+	for _, dest := range hc.destinations {
+		if !hc.backends[dest].ejected {
+			members = append(members, dest)
+		}
+	}
+	if len(members) == 0 {
+		return hc.destinations
+	}
+	return members
+}
+
+// run checks the destinations every interval until shutdown is closed.
+func (hc *backendHealthChecker) run(shutdown <-chan struct{}) {
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+			hc.checkAll()
+		}
+	}
+}
+
+// checkAll checks all destinations concurrently, updates their state
+// with the results and, if any of them was ejected or re-admitted,
+// updates the ring.
+func (hc *backendHealthChecker) checkAll() {
+	hc.mtx.Lock()
+	destinations := hc.destinations
+	hc.mtx.Unlock()
+
+	results := make([]error, len(destinations))
+	wg := sync.WaitGroup{}
+	for i, dest := range destinations {
+		wg.Add(1)
+		go func(i int, dest string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), hc.timeout)
+			defer cancel()
+			results[i] = hc.check(ctx, dest)
+		}(i, dest)
+	}
+	wg.Wait()
+
+	samples := &ssf.Samples{}
+	defer metrics.Report(hc.traceClient, samples)
+	ringTags := map[string]string{"ring": hc.ringName}
+
+	hc.ringMtx.Lock()
+	hc.mtx.Lock()
+	now := hc.now()
+	changed := false
+	for i, dest := range destinations {
+		// Service discovery may have removed the destination
+		// while it was being checked.
+		b, ok := hc.backends[dest]
+		if !ok {
+			continue
+		}
+		if !hc.record(b, results[i], now) {
+			continue
+		}
+		changed = true
+		tags := map[string]string{"ring": hc.ringName, "destination": dest}
+		entry := log.WithFields(logrus.Fields{
+			"ring":        hc.ringName,
+			"destination": dest,
+		})
+		if b.ejected {
+			entry.WithError(results[i]).Warn("Ejecting unhealthy destination from the ring")
+			samples.Add(ssf.Count("proxy.backend_ejections_total", 1, tags))
+		} else {
+			entry.Info("Re-admitting healthy destination to the ring")
+			samples.Add(ssf.Count("proxy.backend_readmissions_total", 1, tags))
+		}
+	}
+	ejected := 0
+	for _, dest := range hc.destinations {
+		if hc.backends[dest].ejected {
+			ejected++
+		}
+	}
+	if changed {
+		hc.ring.Set(hc.members())
+	}
+	members := len(hc.ring.Members())
+	hc.mtx.Unlock()
+	hc.ringMtx.Unlock()
+
+	if changed && hc.onChange != nil {
+		hc.onChange()
+	}
+	samples.Add(
+		ssf.Gauge("proxy.backends_in_ring", float32(members), ringTags),
+		ssf.Gauge("proxy.backends_ejected", float32(ejected), ringTags),
+	)
+}
+
+// record updates b with the result of a check at now, and reports
+// whether the destination was ejected or re-admitted.
+func (hc *backendHealthChecker) record(b *backendHealth, err error, now time.Time) bool {
+	if err != nil {
+		b.successes = 0
+		if b.ejected {
+			return false
+		}
+		b.failures++
+		if b.failures < hc.unhealthyThreshold || now.Sub(b.changed) < hc.cooldown {
+			return false
+		}
+	} else {
+		b.failures = 0
+		if !b.ejected {
+			return false
+		}
+		b.successes++
+		if b.successes < hc.healthyThreshold || now.Sub(b.changed) < hc.cooldown {
+			return false
+		}
+	}
+	b.ejected = !b.ejected
+	b.successes = 0
+	b.failures = 0
+	b.changed = now
+	return true
+}
+
+// checkHTTPHealth checks the health of a veneur that's forwarded to over
+// HTTP by requesting its /healthcheck endpoint.
+func checkHTTPHealth(client *http.Client) func(ctx context.Context, destination string) error {
+	return func(ctx context.Context, destination string) error {
+		if !strings.HasPrefix(destination, "http") {
+			u := url.URL{Scheme: "http", Host: destination}
+			destination = u.String()
+		}
+		req, err := http.NewRequest(http.MethodGet, destination+"/healthcheck", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(ioutil.Discard, resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("healthcheck responded with status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// checkGRPCHealth checks the health of a veneur that's forwarded to over
+// gRPC with the standard gRPC health checking protocol. A server that
+// doesn't implement it, such as a veneur from before it did, counts as
+// healthy if it answers at all.
+func checkGRPCHealth(ctx context.Context, destination string) error {
+	conn, err := grpc.DialContext(ctx, destination, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) == codes.Unimplemented {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if resp.Status != grpc_health_v1.HealthCheckResponse_SERVING {
+		return fmt.Errorf("health check returned status %s", resp.Status)
+	}
+	return nil
+}
+
+// newBackendHealthCheckers sets up health checks of the destinations of
+// each of the proxy's rings, as configured in conf. They don't run until
+// the proxy is started.
+func (p *Proxy) newBackendHealthCheckers(conf ProxyConfig) error {
+	interval, err := time.ParseDuration(conf.BackendHealthCheckInterval)
+	if err != nil {
+		return fmt.Errorf("backend_health_check_interval: %v", err)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("backend_health_check_interval must be positive, got %s", interval)
+	}
+	timeout := defaultBackendHealthCheckTimeout
+	if conf.BackendHealthCheckTimeout != "" {
+		timeout, err = time.ParseDuration(conf.BackendHealthCheckTimeout)
+		if err != nil {
+			return fmt.Errorf("backend_health_check_timeout: %v", err)
+		}
+	}
+	cooldown := defaultBackendHealthCheckCooldown
+	if conf.BackendHealthCheckCooldown != "" {
+		cooldown, err = time.ParseDuration(conf.BackendHealthCheckCooldown)
+		if err != nil {
+			return fmt.Errorf("backend_health_check_cooldown: %v", err)
+		}
+	}
+	unhealthyThreshold := conf.BackendHealthCheckUnhealthyThreshold
+	if unhealthyThreshold <= 0 {
+		unhealthyThreshold = defaultBackendHealthCheckUnhealthyThreshold
+	}
+	healthyThreshold := conf.BackendHealthCheckHealthyThreshold
+	if healthyThreshold <= 0 {
+		healthyThreshold = defaultBackendHealthCheckHealthyThreshold
+	}
+
+	p.backendHealthCheckers = map[*consistent.Consistent]*backendHealthChecker{}
+	add := func(name string, ring *consistent.Consistent, check func(context.Context, string) error) {
+		p.backendHealthCheckers[ring] = &backendHealthChecker{
+			ringName:           name,
+			check:              check,
+			interval:           interval,
+			timeout:            timeout,
+			unhealthyThreshold: unhealthyThreshold,
+			healthyThreshold:   healthyThreshold,
+			cooldown:           cooldown,
+			traceClient:        p.TraceClient,
+			now:                time.Now,
+			ring:               ring,
+		}
+	}
+	if p.AcceptingForwards {
+		add("forward", p.ForwardDestinations, checkHTTPHealth(p.HTTPClient))
+	}
+	if p.AcceptingTraces {
+		add("trace", p.TraceDestinations, checkHTTPHealth(p.HTTPClient))
+	}
+	if p.AcceptingGRPCForwards {
+		add("grpc_forward", p.ForwardGRPCDestinations, checkGRPCHealth)
+	}
+	if p.AcceptingGRPCTraces {
+		add("grpc_trace", p.TraceGRPCDestinations, checkGRPCHealth)
+	}
+	return nil
+}
+
+// startBackendHealthChecks starts checking the destinations of each ring
+// that has a health checker. Rings with a static destination are
+// checked too, but since the last healthy destination is never
+// ejected, that only reports their health.
+func (p *Proxy) startBackendHealthChecks() {
+	mutexes := map[*consistent.Consistent]*sync.Mutex{
+		p.ForwardDestinations:     &p.ForwardDestinationsMtx,
+		p.TraceDestinations:       &p.TraceDestinationsMtx,
+		p.ForwardGRPCDestinations: &p.ForwardGRPCDestinationsMtx,
+		p.TraceGRPCDestinations:   &p.TraceGRPCDestinationsMtx,
+	}
+	for ring, hc := range p.backendHealthCheckers {
+		hc.ringMtx = mutexes[ring]
+		if ring == p.ForwardGRPCDestinations && p.grpcServer != nil {
+			hc.onChange = func() {
+				p.grpcServer.SetDestinations(p.ForwardGRPCDestinations)
+			}
+		}
+		if ring == p.TraceGRPCDestinations {
+			hc.onChange = p.setGRPCTraceDestinations
+		}
+
+		hc.ringMtx.Lock()
+		hc.mtx.Lock()
+		discovered := hc.destinations != nil
+		hc.mtx.Unlock()
+		if !discovered {
+			ring.Set(hc.setDestinations(ring.Members()))
+		}
+		hc.ringMtx.Unlock()
+
+		go func(hc *backendHealthChecker) {
+			defer func() {
+				ConsumePanic(p.TraceClient, p.Hostname, recover())
+			}()
+			hc.run(p.shutdown)
+		}(hc)
+	}
+}
@@ -5,17 +5,22 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/importsrv"
 	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
 	"github.com/zenazn/goji/graceful"
 )
 
@@ -122,6 +127,195 @@ func TestAcceptingBooleans(t *testing.T) {
 	assert.False(t, server.AcceptingTraces, "Server does not forward traces")
 }
 
+func TestConsistentHashReplicas(t *testing.T) {
+	proxyConfig := generateProxyConfig()
+	server, err := NewProxyFromConfig(logrus.New(), proxyConfig)
+	require.NoError(t, err)
+	assert.Equal(t, 20, server.ForwardDestinations.NumberOfReplicas, "should default to the consistent package's default")
+
+	proxyConfig.ConsistentHashReplicas = 200
+	server, err = NewProxyFromConfig(logrus.New(), proxyConfig)
+	require.NoError(t, err)
+	assert.Equal(t, 200, server.ForwardDestinations.NumberOfReplicas)
+	assert.Equal(t, 200, server.TraceDestinations.NumberOfReplicas)
+	assert.Equal(t, 200, server.ForwardGRPCDestinations.NumberOfReplicas)
+
+	proxyConfig.ConsistentHashReplicas = -1
+	_, err = NewProxyFromConfig(logrus.New(), proxyConfig)
+	assert.Error(t, err)
+}
+
+func TestTraceDestinationRings(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/healthcheck", r.URL.Path)
+	}))
+	defer healthy.Close()
+
+	proxyConfig := generateProxyConfig()
+	proxyConfig.ConsulTraceServiceName = ""
+	proxyConfig.TraceAddress = healthy.URL
+	proxyConfig.GrpcTraceAddress = "veneur-grpc.example.com:8128"
+	proxyConfig.BackendHealthCheckInterval = "1s"
+	server, err := NewProxyFromConfig(logrus.New(), proxyConfig)
+	require.NoError(t, err)
+
+	assert.True(t, server.AcceptingTraces)
+	assert.True(t, server.AcceptingGRPCTraces)
+	assert.Equal(t, []string{healthy.URL}, server.TraceDestinations.Members(), "spans are posted to the HTTP trace destinations")
+	assert.Equal(t, []string{"veneur-grpc.example.com:8128"}, server.TraceGRPCDestinations.Members(), "spans are streamed to the gRPC trace destinations")
+
+	traceChecker := server.backendHealthCheckers[server.TraceDestinations]
+	require.NotNil(t, traceChecker)
+	assert.Equal(t, "trace", traceChecker.ringName)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, traceChecker.check(ctx, healthy.URL), "the trace destinations should be checked over HTTP")
+
+	grpcChecker := server.backendHealthCheckers[server.TraceGRPCDestinations]
+	require.NotNil(t, grpcChecker)
+	assert.Equal(t, "grpc_trace", grpcChecker.ringName)
+}
+
+type staticDiscoverer []string
+
+func (d *staticDiscoverer) GetDestinationsForService(string) ([]string, error) {
+	return *d, nil
+}
+
+func TestRefreshDestinationsKeysMoved(t *testing.T) {
+	ch := make(chan *ssf.SSFSpan, 10)
+	cl, err := trace.NewChannelClient(ch)
+	require.NoError(t, err)
+	defer cl.Close()
+
+	destinations := &staticDiscoverer{"10.0.0.1:8000", "10.0.0.2:8000", "10.0.0.3:8000", "10.0.0.4:8000"}
+	p := Proxy{Discoverer: destinations, TraceClient: cl}
+	ring := newRing(500)
+	var mtx sync.Mutex
+	movedRatio := func() (float32, bool) {
+		p.RefreshDestinations("forwardServiceName", ring, &mtx)
+		for _, sample := range (<-ch).Metrics {
+			if sample.Name == "discoverer.keys_moved_ratio" {
+				return sample.Value, true
+			}
+		}
+		return 0, false
+	}
+
+	_, reported := movedRatio()
+	assert.False(t, reported, "filling an empty ring doesn't move keys")
+	_, reported = movedRatio()
+	assert.False(t, reported, "an unchanged ring doesn't move keys")
+
+	*destinations = append(*destinations, "10.0.0.5:8000")
+	moved, reported := movedRatio()
+	require.True(t, reported)
+	// adding a fifth destination should move about a fifth of the keys:
+	assert.InDelta(t, 0.2, moved, 0.05)
+}
+
+func TestBackendHealthEjection(t *testing.T) {
+	ch := make(chan *ssf.SSFSpan, 100)
+	cl, err := trace.NewChannelClient(ch)
+	require.NoError(t, err)
+	defer cl.Close()
+
+	var healthy, otherHealthy int32 = 1, 1
+	healthServer := func(healthy *int32) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/healthcheck", r.URL.Path)
+			if atomic.LoadInt32(healthy) == 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+	}
+	stable := healthServer(&otherHealthy)
+	defer stable.Close()
+	flappy := healthServer(&healthy)
+	defer flappy.Close()
+
+	p := Proxy{
+		Discoverer:          &staticDiscoverer{stable.URL, flappy.URL},
+		TraceClient:         cl,
+		HTTPClient:          http.DefaultClient,
+		ForwardDestinations: newRing(0),
+		AcceptingForwards:   true,
+	}
+	require.NoError(t, p.newBackendHealthCheckers(ProxyConfig{
+		BackendHealthCheckInterval:           "10s",
+		BackendHealthCheckUnhealthyThreshold: 2,
+		BackendHealthCheckHealthyThreshold:   2,
+		BackendHealthCheckCooldown:           "1m",
+	}))
+	hc := p.backendHealthCheckers[p.ForwardDestinations]
+	require.NotNil(t, hc)
+	hc.ringMtx = &p.ForwardDestinationsMtx
+	now := time.Now()
+	hc.now = func() time.Time { return now }
+
+	refresh := func() {
+		p.RefreshDestinations("forwardServiceName", p.ForwardDestinations, &p.ForwardDestinationsMtx)
+		<-ch
+	}
+	refresh()
+	assert.ElementsMatch(t, []string{stable.URL, flappy.URL}, p.ForwardDestinations.Members())
+
+	reported := map[string]float32{}
+	check := func() {
+		hc.checkAll()
+		for _, sample := range (<-ch).Metrics {
+			reported[sample.Name] += sample.Value
+		}
+	}
+
+	atomic.StoreInt32(&healthy, 0)
+	check()
+	assert.Len(t, p.ForwardDestinations.Members(), 2, "one failed check isn't enough to eject")
+	check()
+	assert.Equal(t, []string{stable.URL}, p.ForwardDestinations.Members())
+	assert.Equal(t, float32(1), reported["proxy.backend_ejections_total"])
+	refresh()
+	assert.Equal(t, []string{stable.URL}, p.ForwardDestinations.Members(),
+		"discovery shouldn't re-admit an ejected destination")
+
+	atomic.StoreInt32(&healthy, 1)
+	check()
+	check()
+	check()
+	assert.Equal(t, []string{stable.URL}, p.ForwardDestinations.Members(),
+		"destinations should stay ejected during the cooldown")
+
+	now = now.Add(time.Minute)
+	check()
+	assert.ElementsMatch(t, []string{stable.URL, flappy.URL}, p.ForwardDestinations.Members())
+	assert.Equal(t, float32(1), reported["proxy.backend_readmissions_total"])
+
+	now = now.Add(time.Minute)
+	atomic.StoreInt32(&healthy, 0)
+	atomic.StoreInt32(&otherHealthy, 0)
+	check()
+	check()
+	assert.ElementsMatch(t, []string{stable.URL, flappy.URL}, p.ForwardDestinations.Members(),
+		"the ring should keep all destinations if they are all unhealthy")
+}
+
+func TestCheckGRPCHealth(t *testing.T) {
+	srv := importsrv.New(nil)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Server.Serve(ln)
+	defer srv.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, checkGRPCHealth(ctx, ln.Addr().String()))
+
+	srv.Stop()
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, checkGRPCHealth(ctx, ln.Addr().String()))
+}
+
 func TestConsistentForward(t *testing.T) {
 
 	// We need to set up a proxy, have a local veneur send to it, then verify
@@ -0,0 +1,244 @@
+package veneur
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// proxyHeaderTimeout is how long a client has to send the PROXY
+// protocol header after connecting.
+const proxyHeaderTimeout = 5 * time.Second
+
+// maxProxyV1HeaderLength is the longest a v1 (text) PROXY header can
+// be, including the trailing CRLF.
+const maxProxyV1HeaderLength = 107
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errMalformedProxyHeader = errors.New("malformed PROXY protocol header")
+
+// proxyProtocolListener wraps a listener such that every connection it
+// accepts must start with a PROXY protocol (v1 or v2) header, as sent
+// by load balancers like haproxy or AWS NLBs. The header is stripped
+// from the connection, and the connection's RemoteAddr reports the
+// original client's address.
+type proxyProtocolListener struct {
+	net.Listener
+	server *Server
+}
+
+// Accept waits for the next connection and wraps it. The PROXY header
+// is not read until the connection is first used, so that a slow or
+// malicious client can't hold up the accept loop.
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+		server: l.server,
+	}, nil
+}
+
+// proxyProtocolConn is a connection whose PROXY protocol header is
+// parsed upon the first call to Read or RemoteAddr.
+type proxyProtocolConn struct {
+	net.Conn
+	reader *bufio.Reader
+	server *Server
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+
+	deadlineMtx  sync.Mutex
+	readDeadline time.Time
+}
+
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		c.deadlineMtx.Lock()
+		deadline := c.readDeadline
+		c.deadlineMtx.Unlock()
+
+		headerDeadline := time.Now().Add(proxyHeaderTimeout)
+		if deadline.IsZero() || deadline.After(headerDeadline) {
+			c.Conn.SetReadDeadline(headerDeadline)
+		}
+		c.remoteAddr, c.err = readProxyHeader(c.reader)
+		// restore the deadline that the connection's user asked for:
+		c.Conn.SetReadDeadline(deadline)
+		if c.err != nil {
+			metrics.ReportOne(c.server.TraceClient, ssf.Count("tcp.proxy_protocol_errors_total", 1, nil))
+			log.WithFields(logrus.Fields{
+				logrus.ErrorKey: c.err,
+				"peer":          c.Conn.RemoteAddr(),
+			}).Info("Rejecting connection with invalid PROXY protocol header")
+		}
+	})
+}
+
+// SetReadDeadline sets the read deadline on the underlying connection,
+// remembering it so that it can be restored after reading the header.
+func (c *proxyProtocolConn) SetReadDeadline(t time.Time) error {
+	c.deadlineMtx.Lock()
+	c.readDeadline = t
+	c.deadlineMtx.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetDeadline sets the read and write deadlines on the underlying
+// connection.
+func (c *proxyProtocolConn) SetDeadline(t time.Time) error {
+	c.deadlineMtx.Lock()
+	c.readDeadline = t
+	c.deadlineMtx.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// Read reads data from the connection, past the PROXY header. If the
+// header could not be parsed, it returns the parse error.
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address given in the PROXY header. If
+// the header did not contain an address (e.g. for health checks by the
+// load balancer itself) or could not be parsed, it returns the address
+// of the connection's peer.
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader consumes a v1 or v2 PROXY protocol header from r and
+// returns the source address it contains. The returned address is nil
+// if the header is valid but does not carry a TCP source address.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err == nil && bytes.Equal(sig, proxyV2Signature) {
+		return readProxyV2Header(r)
+	}
+	if len(sig) >= 6 && string(sig[:6]) == "PROXY " {
+		return readProxyV1Header(r)
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return nil, errMalformedProxyHeader
+}
+
+// readProxyV1Header parses a text header of the form
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 8126\r\n".
+func readProxyV1Header(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxProxyV1HeaderLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errMalformedProxyHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 {
+		return nil, errMalformedProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, errMalformedProxyHeader
+	}
+	if len(fields) != 6 {
+		return nil, errMalformedProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, errMalformedProxyHeader
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errMalformedProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyV2Header parses a binary header; see section 2.2 of
+// https://www.haproxy.org/download/2.3/doc/proxy-protocol.txt
+func readProxyV2Header(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	verCmd, family := header[12], header[13]
+	length := binary.BigEndian.Uint16(header[14:16])
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", verCmd>>4)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch verCmd & 0xf {
+	case 0x0:
+		// LOCAL: the connection was made by the proxy itself
+		return nil, nil
+	case 0x1:
+		// PROXY
+	default:
+		return nil, errMalformedProxyHeader
+	}
+
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, errMalformedProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, errMalformedProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	default:
+		// UNSPEC, UDP or UNIX sources don't carry an address
+		// we can use.
+		return nil, nil
+	}
+}
@@ -0,0 +1,97 @@
+package veneur
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func proxyV2Header(cmd byte, family byte, payload []byte) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x20|cmd, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(payload)))
+	return append(header, payload...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	v4Payload := []byte{
+		192, 0, 2, 1, // source
+		198, 51, 100, 1, // destination
+		0xdc, 0x04, // source port 56324
+		0x1f, 0xbe, // destination port 8126
+	}
+	v6Payload := make([]byte, 36)
+	copy(v6Payload[0:16], net.ParseIP("2001:db8::1"))
+	copy(v6Payload[16:32], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(v6Payload[32:34], 56324)
+	binary.BigEndian.PutUint16(v6Payload[34:36], 8126)
+
+	tests := []struct {
+		name   string
+		header []byte
+		addr   string
+		err    bool
+	}{
+		{"v1 TCP4", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 8126\r\n"), "192.0.2.1:56324", false},
+		{"v1 TCP6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 8126\r\n"), "[2001:db8::1]:56324", false},
+		{"v1 UNKNOWN", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 family mismatch", []byte("PROXY TCP6 192.0.2.1 198.51.100.1 56324 8126\r\n"), "", true},
+		{"v1 bad port", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 99999 8126\r\n"), "", true},
+		{"v1 missing fields", []byte("PROXY TCP4 192.0.2.1\r\n"), "", true},
+		{"v1 no CRLF", []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 8126\n"), "", true},
+		{"v1 too long", []byte("PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n"), "", true},
+		{"v2 TCP4", proxyV2Header(0x1, 0x11, v4Payload), "192.0.2.1:56324", false},
+		{"v2 TCP6", proxyV2Header(0x1, 0x21, v6Payload), "[2001:db8::1]:56324", false},
+		{"v2 LOCAL", proxyV2Header(0x0, 0x00, nil), "", false},
+		{"v2 unix", proxyV2Header(0x1, 0x31, make([]byte, 216)), "", false},
+		{"v2 short address", proxyV2Header(0x1, 0x11, v4Payload[:8]), "", true},
+		{"v2 bad command", proxyV2Header(0x2, 0x11, v4Payload), "", true},
+		{"no header", []byte("foo.bar:1|c\n"), "", true},
+	}
+	for _, elt := range tests {
+		test := elt
+		t.Run(test.name, func(t *testing.T) {
+			r := bufio.NewReader(bytes.NewReader(append(test.header, "foo.bar:1|c\n"...)))
+			addr, err := readProxyHeader(r)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if test.addr == "" {
+				assert.Nil(t, addr)
+			} else {
+				require.NotNil(t, addr)
+				assert.Equal(t, test.addr, addr.String())
+			}
+			rest, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, "foo.bar:1|c\n", string(rest), "should have consumed exactly the header")
+		})
+	}
+}
+
+func TestProxyProtocolConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := &proxyProtocolConn{
+		Conn:   server,
+		reader: bufio.NewReader(server),
+		server: &Server{},
+	}
+	defer conn.Close()
+
+	go client.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 8126\r\nfoo.bar:1|c\n"))
+
+	assert.Equal(t, "192.0.2.1:56324", conn.RemoteAddr().String())
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "foo.bar:1|c\n", line)
+}
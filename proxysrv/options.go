@@ -22,6 +22,17 @@ func WithLog(e *logrus.Entry) Option {
 	}
 }
 
+// WithSpanQueueSize sets the number of spans that are buffered for each
+// destination.  Once a destination's buffer is full, senders wait for up
+// to the forward timeout before their spans are dropped.
+func WithSpanQueueSize(n int) Option {
+	return func(opts *options) {
+		if n > 0 {
+			opts.spanQueueSize = n
+		}
+	}
+}
+
 // WithStatsInterval sets the time interval at which diagnostic metrics about
 // the server will be emitted.
 func WithStatsInterval(d time.Duration) Option {
@@ -1,9 +1,10 @@
-// Package proxysrv proxies metrics over gRPC to global veneurs using
-// consistent hashing
+// Package proxysrv proxies metrics and spans over gRPC to global veneurs
+// using consistent hashing
 //
 // The Server provided accepts a hash ring of destinations, and then listens
 // for metrics over gRPC.  It hashes each metric to a specific destination,
-// and forwards each metric to its appropriate destination Veneur.
+// and forwards each metric to its appropriate destination Veneur.  Spans
+// are likewise hashed by their trace ID onto a second ring of destinations.
 package proxysrv
 
 import (
@@ -51,6 +52,14 @@ type Server struct {
 	// A simple counter to track the number of goroutines spawned to handle
 	// proxying metrics
 	activeProxyHandlers *int64
+
+	// The destinations of spans, and a forwarder for each of them
+	traceDestinations *consistent.Consistent
+	spanForwarders    map[string]*spanForwarder
+	spanMtx           sync.RWMutex
+	// The number of spans that had no destination since the last report;
+	// accessed atomically
+	spansUnroutable int64
 }
 
 // Option modifies an internal options type.
@@ -61,6 +70,7 @@ type options struct {
 	forwardTimeout time.Duration
 	traceClient    *trace.Client
 	statsInterval  time.Duration
+	spanQueueSize  int
 }
 
 // New creates a new Server with the provided destinations. The server returned
@@ -71,9 +81,11 @@ func New(destinations *consistent.Consistent, opts ...Option) (*Server, error) {
 		opts: &options{
 			forwardTimeout: defaultForwardTimeout,
 			statsInterval:  defaultReportStatsInterval,
+			spanQueueSize:  defaultSpanQueueSize,
 		},
 		conns:               newClientConnMap(grpc.WithInsecure()),
 		activeProxyHandlers: new(int64),
+		spanForwarders:      map[string]*spanForwarder{},
 	}
 
 	for _, opt := range opts {
@@ -91,6 +103,8 @@ func New(destinations *consistent.Consistent, opts ...Option) (*Server, error) {
 	}
 
 	forwardrpc.RegisterForwardServer(res.Server, res)
+	ssf.RegisterSSFGRPCServer(res.Server, res)
+	ssf.RegisterSSFStreamServer(res.Server, res)
 
 	return res, nil
 }
@@ -136,6 +150,13 @@ func (s *Server) Serve(addr string) error {
 func (s *Server) Stop() {
 	s.Server.Stop()
 	s.conns.Clear()
+
+	s.spanMtx.Lock()
+	for dest, f := range s.spanForwarders {
+		f.stop()
+		delete(s.spanForwarders, dest)
+	}
+	s.spanMtx.Unlock()
 }
 
 // SetDestinations updates the ring of hosts that are forwarded to by
@@ -308,6 +329,7 @@ func (s *Server) forward(ctx context.Context, dest string, ms []*metricpb.Metric
 func (s *Server) reportStats() {
 	_ = metrics.ReportOne(s.opts.traceClient,
 		ssf.Gauge("proxy.active_goroutines", float32(atomic.LoadInt64(s.activeProxyHandlers)), globalProtocolTags))
+	s.reportSpanStats()
 }
 
 func strInSlice(s string, slice []string) bool {
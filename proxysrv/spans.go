@@ -0,0 +1,307 @@
+package proxysrv
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"stathat.com/c/consistent"
+
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+const (
+	// By default, buffer up to this many spans for each destination
+	defaultSpanQueueSize = 4096
+
+	// The most spans that are sent to a destination in one batch
+	maxSpanBatchSize = 256
+)
+
+// SetTraceDestinations updates the ring of hosts that spans are forwarded
+// to.  Spans are hashed to a destination by their trace ID, so that all
+// the spans of a trace reach the same global Veneur.
+//
+// A forwarder is started for each new destination, and the forwarders of
+// destinations that aren't in the ring anymore are stopped once they've
+// sent the spans they have buffered.
+func (s *Server) SetTraceDestinations(dests *consistent.Consistent) error {
+	s.spanMtx.Lock()
+	defer s.spanMtx.Unlock()
+
+	members := dests.Members()
+	for dest, f := range s.spanForwarders {
+		if !strInSlice(dest, members) {
+			f.stop()
+			delete(s.spanForwarders, dest)
+		}
+	}
+
+	for _, dest := range members {
+		if _, ok := s.spanForwarders[dest]; ok {
+			continue
+		}
+		f, err := s.newSpanForwarder(dest)
+		if err != nil {
+			return fmt.Errorf("failed to setup a span forwarder for the "+
+				"destination '%s': %v", dest, err)
+		}
+		s.spanForwarders[dest] = f
+		go f.run()
+	}
+
+	s.traceDestinations = dests
+	return nil
+}
+
+// SendSpan fulfils the SSFGRPC service: it forwards a single span to the
+// destination of its trace.
+func (s *Server) SendSpan(ctx context.Context, span *ssf.SSFSpan) (*ssf.Empty, error) {
+	s.forwardSpan(ctx, span)
+	return &ssf.Empty{}, nil
+}
+
+// SendSpans fulfils the SSFStream service: it forwards the spans of each
+// batch to the destinations of their traces, and acknowledges the batch
+// with the number of spans that were queued for forwarding.  If a
+// destination's queue is full, the batch isn't acknowledged until it has
+// room again (or the forward timeout passes, and the span is dropped), so
+// the client slows down to the rate that the destination can handle.
+func (s *Server) SendSpans(stream ssf.SSFStream_SendSpansServer) error {
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var accepted uint32
+		for _, span := range batch.Spans {
+			if span != nil && s.forwardSpan(stream.Context(), span) {
+				accepted++
+			}
+		}
+		if err := stream.Send(&ssf.SSFSpanBatchAck{Accepted: accepted}); err != nil {
+			return err
+		}
+	}
+}
+
+// forwardSpan queues a span on the forwarder of its trace's destination,
+// and reports whether it was queued.
+func (s *Server) forwardSpan(ctx context.Context, span *ssf.SSFSpan) bool {
+	s.spanMtx.RLock()
+	var f *spanForwarder
+	if s.traceDestinations != nil {
+		if dest, err := s.traceDestinations.Get(strconv.FormatInt(span.TraceId, 10)); err == nil {
+			f = s.spanForwarders[dest]
+		}
+	}
+	s.spanMtx.RUnlock()
+
+	if f == nil {
+		atomic.AddInt64(&s.spansUnroutable, 1)
+		return false
+	}
+	return f.enqueue(ctx, span, s.opts.forwardTimeout)
+}
+
+// reportSpanStats reports the number of spans forwarded to and dropped
+// for each destination.
+func (s *Server) reportSpanStats() {
+	s.spanMtx.RLock()
+	defer s.spanMtx.RUnlock()
+	for _, f := range s.spanForwarders {
+		f.reportStats()
+	}
+	if n := atomic.SwapInt64(&s.spansUnroutable, 0); n > 0 {
+		_ = metrics.ReportOne(s.opts.traceClient, ssf.Count("proxy.spans_dropped_total", float32(n),
+			map[string]string{"cause": "no-destination", "protocol": "grpc"}))
+	}
+}
+
+// spanForwarder streams the spans of traces that hash to one destination
+// over the SSFStream service, in batches.  Spans are buffered in a
+// bounded queue while a batch is in flight.
+type spanForwarder struct {
+	dest  string
+	conn  *grpc.ClientConn
+	queue chan *ssf.SSFSpan
+	done  chan struct{}
+	log   *logrus.Entry
+
+	// ctx is canceled once the forwarder has stopped; the streams it
+	// opens are derived from it.
+	ctx    context.Context
+	cancel func()
+
+	server *Server
+
+	// forwarded and dropped are counted since the last report; they
+	// are accessed atomically.
+	forwarded int64
+	dropped   int64
+}
+
+func (s *Server) newSpanForwarder(dest string) (*spanForwarder, error) {
+	conn, err := grpc.Dial(dest, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &spanForwarder{
+		dest:   dest,
+		conn:   conn,
+		queue:  make(chan *ssf.SSFSpan, s.opts.spanQueueSize),
+		done:   make(chan struct{}),
+		log:    s.opts.log.WithField("destination", dest),
+		ctx:    ctx,
+		cancel: cancel,
+		server: s,
+	}, nil
+}
+
+// enqueue adds a span to the queue.  If the queue is full, it waits for
+// up to timeout (or indefinitely, if it is zero) for room, and then drops
+// the span.
+func (f *spanForwarder) enqueue(ctx context.Context, span *ssf.SSFSpan, timeout time.Duration) bool {
+	select {
+	case f.queue <- span:
+		return true
+	default:
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case f.queue <- span:
+		return true
+	case <-f.done:
+	case <-ctx.Done():
+	case <-expired:
+	}
+	atomic.AddInt64(&f.dropped, 1)
+	return false
+}
+
+// stop makes the forwarder send the spans it has queued and exit.
+func (f *spanForwarder) stop() {
+	close(f.done)
+}
+
+// run sends batches of queued spans until the forwarder is stopped.
+func (f *spanForwarder) run() {
+	defer f.conn.Close()
+	defer f.cancel()
+
+	var stream ssf.SSFStream_SendSpansClient
+	closeStream := func() {}
+	defer func() { closeStream() }()
+	for {
+		batch, open := f.nextBatch()
+		if len(batch) > 0 {
+			var err error
+			if stream == nil {
+				stream, closeStream, err = f.openStream()
+			}
+			if err == nil {
+				err = f.send(stream, batch)
+			}
+			if err != nil {
+				f.log.WithError(err).WithField("spans", len(batch)).
+					Warn("Failed to forward spans")
+				atomic.AddInt64(&f.dropped, int64(len(batch)))
+				// Start a new stream with the next batch.
+				if stream != nil {
+					closeStream()
+					stream = nil
+				}
+			}
+		}
+		if !open {
+			if stream != nil {
+				_ = stream.CloseSend()
+			}
+			f.reportStats()
+			return
+		}
+	}
+}
+
+// openStream opens a SendSpans stream to the destination, along with the
+// function that closes it.
+func (f *spanForwarder) openStream() (ssf.SSFStream_SendSpansClient, func(), error) {
+	ctx, cancel := context.WithCancel(f.ctx)
+	stream, err := ssf.NewSSFStreamClient(f.conn).SendSpans(ctx)
+	if err != nil {
+		cancel()
+		return nil, func() {}, err
+	}
+	return stream, cancel, nil
+}
+
+// nextBatch waits for queued spans and returns up to maxSpanBatchSize of
+// them.  Once the forwarder is stopped, it returns the remaining spans
+// and false.
+func (f *spanForwarder) nextBatch() ([]*ssf.SSFSpan, bool) {
+	var batch []*ssf.SSFSpan
+	select {
+	case span := <-f.queue:
+		batch = append(batch, span)
+	case <-f.done:
+		for {
+			select {
+			case span := <-f.queue:
+				batch = append(batch, span)
+			default:
+				return batch, false
+			}
+		}
+	}
+	for len(batch) < maxSpanBatchSize {
+		select {
+		case span := <-f.queue:
+			batch = append(batch, span)
+		default:
+			return batch, true
+		}
+	}
+	return batch, true
+}
+
+// send sends a batch on the stream and waits for its acknowledgement.
+func (f *spanForwarder) send(stream ssf.SSFStream_SendSpansClient, batch []*ssf.SSFSpan) error {
+	if err := stream.Send(&ssf.SSFSpanBatch{Spans: batch}); err != nil {
+		return err
+	}
+	ack, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&f.forwarded, int64(ack.Accepted))
+	if rejected := len(batch) - int(ack.Accepted); rejected > 0 {
+		atomic.AddInt64(&f.dropped, int64(rejected))
+	}
+	return nil
+}
+
+// reportStats reports the spans forwarded and dropped since the last
+// report.
+func (f *spanForwarder) reportStats() {
+	tags := map[string]string{"destination": f.dest, "protocol": "grpc"}
+	_ = metrics.ReportBatch(f.server.opts.traceClient, []*ssf.SSFSample{
+		ssf.Count("proxy.spans_forwarded_total", float32(atomic.SwapInt64(&f.forwarded, 0)), tags),
+		ssf.Count("proxy.spans_dropped_total", float32(atomic.SwapInt64(&f.dropped, 0)), tags),
+	})
+}
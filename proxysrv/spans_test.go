@@ -0,0 +1,177 @@
+package proxysrv
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"stathat.com/c/consistent"
+
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+)
+
+// spanRecorder is a global veneur's SSFStream service, which records the
+// spans it receives.
+type spanRecorder struct {
+	mtx   sync.Mutex
+	spans []*ssf.SSFSpan
+	// if set, each batch is only acknowledged once unblock is
+	// readable
+	unblock chan struct{}
+
+	server *grpc.Server
+	addr   string
+}
+
+func newSpanRecorder(t *testing.T) *spanRecorder {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	r := &spanRecorder{server: grpc.NewServer(), addr: ln.Addr().String()}
+	ssf.RegisterSSFStreamServer(r.server, r)
+	go r.server.Serve(ln)
+	return r
+}
+
+func (r *spanRecorder) SendSpans(stream ssf.SSFStream_SendSpansServer) error {
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if r.unblock != nil {
+			select {
+			case <-r.unblock:
+			case <-stream.Context().Done():
+				return stream.Context().Err()
+			}
+		}
+		r.mtx.Lock()
+		r.spans = append(r.spans, batch.Spans...)
+		r.mtx.Unlock()
+		if err := stream.Send(&ssf.SSFSpanBatchAck{Accepted: uint32(len(batch.Spans))}); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *spanRecorder) received() []*ssf.SSFSpan {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]*ssf.SSFSpan(nil), r.spans...)
+}
+
+func TestForwardSpansByTraceID(t *testing.T) {
+	recorders := []*spanRecorder{newSpanRecorder(t), newSpanRecorder(t), newSpanRecorder(t)}
+	ring := consistent.New()
+	for _, r := range recorders {
+		defer r.server.Stop()
+		ring.Add(r.addr)
+	}
+
+	server := newServer(t, consistent.New())
+	require.NoError(t, server.SetTraceDestinations(ring))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Server.Serve(ln)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	stream, err := ssf.NewSSFStreamClient(conn).SendSpans(context.Background())
+	require.NoError(t, err)
+
+	const traces, spansPerTrace = 50, 4
+	for id := int64(1); id <= traces; id++ {
+		batch := &ssf.SSFSpanBatch{}
+		for i := int64(0); i < spansPerTrace; i++ {
+			batch.Spans = append(batch.Spans, &ssf.SSFSpan{TraceId: id, Id: id*10 + i})
+		}
+		require.NoError(t, stream.Send(batch))
+		ack, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, uint32(spansPerTrace), ack.Accepted)
+	}
+	require.NoError(t, stream.CloseSend())
+
+	assert.Eventually(t, func() bool {
+		total := 0
+		for _, r := range recorders {
+			total += len(r.received())
+		}
+		return total == traces*spansPerTrace
+	}, 5*time.Second, 10*time.Millisecond, "all spans should be forwarded")
+
+	destinations := map[int64]string{}
+	for _, r := range recorders {
+		assert.NotEmpty(t, r.received(), "traces should be spread over all destinations")
+		for _, span := range r.received() {
+			if dest, ok := destinations[span.TraceId]; ok {
+				assert.Equal(t, dest, r.addr, "all spans of trace %d should go to the same destination", span.TraceId)
+			}
+			destinations[span.TraceId] = r.addr
+		}
+	}
+}
+
+func TestForwardSpansBackpressure(t *testing.T) {
+	recorder := newSpanRecorder(t)
+	recorder.unblock = make(chan struct{})
+	defer recorder.server.Stop()
+	ring := consistent.New()
+	ring.Add(recorder.addr)
+
+	ch := make(chan *ssf.SSFSpan, 10)
+	cl, err := trace.NewChannelClient(ch)
+	require.NoError(t, err)
+	defer cl.Close()
+
+	server := newServer(t, consistent.New(),
+		WithSpanQueueSize(1),
+		WithForwardTimeout(10*time.Millisecond),
+		WithTraceClient(cl))
+	require.NoError(t, server.SetTraceDestinations(ring))
+
+	// The first span is sent, and waits for its ack; the second one
+	// waits in the queue; the third finds the queue full and is dropped
+	// once the timeout passes.
+	assert.True(t, server.forwardSpan(context.Background(), &ssf.SSFSpan{TraceId: 1, Id: 1}))
+	assert.Eventually(t, func() bool {
+		return len(server.spanForwarders[recorder.addr].queue) == 0
+	}, 5*time.Second, time.Millisecond)
+	assert.True(t, server.forwardSpan(context.Background(), &ssf.SSFSpan{TraceId: 1, Id: 2}))
+	start := time.Now()
+	assert.False(t, server.forwardSpan(context.Background(), &ssf.SSFSpan{TraceId: 1, Id: 3}))
+	assert.True(t, time.Since(start) >= 10*time.Millisecond, "senders should wait for room in the queue")
+
+	server.reportSpanStats()
+	reported := map[string]float32{}
+	for _, sample := range (<-ch).Metrics {
+		assert.Equal(t, recorder.addr, sample.Tags["destination"])
+		reported[sample.Name] = sample.Value
+	}
+	assert.Equal(t, float32(1), reported["proxy.spans_dropped_total"])
+
+	close(recorder.unblock)
+	assert.Eventually(t, func() bool {
+		return len(recorder.received()) == 2
+	}, 5*time.Second, 10*time.Millisecond, "the queued span should be sent once the destination catches up")
+}
+
+func TestForwardSpansNoDestinations(t *testing.T) {
+	server := newServer(t, consistent.New())
+	assert.False(t, server.forwardSpan(context.Background(), &ssf.SSFSpan{TraceId: 1, Id: 1}),
+		"spans shouldn't be queued without a trace destination")
+	require.NoError(t, server.SetTraceDestinations(consistent.New()))
+	assert.False(t, server.forwardSpan(context.Background(), &ssf.SSFSpan{TraceId: 1, Id: 1}))
+}
@@ -0,0 +1,139 @@
+package veneur
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/fasthash/fnv1a"
+	"github.com/sirupsen/logrus"
+)
+
+// rateLimitBuckets is the number of buckets that rate-limited source
+// addresses are hashed into when reporting dropped packets, so that a
+// flood from many (possibly spoofed) addresses can't blow up the
+// cardinality of veneur's own metrics.
+const rateLimitBuckets = 16
+
+// rateLimitSweepInterval is how often sourceRateLimiter forgets about
+// sources that haven't sent anything for long enough to have a full
+// bucket of tokens again.
+const rateLimitSweepInterval = time.Minute
+
+// tokenBucket tracks the packets (or requests) a single source may send.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limited bool
+}
+
+// sourceRateLimiter is a token-bucket rate limiter keyed on the source
+// IP address of UDP packets or HTTP requests. It is safe for concurrent
+// use.
+type sourceRateLimiter struct {
+	// what describes what's being limited, for the logs.
+	what  string
+	rate  float64
+	burst float64
+
+	mtx       sync.Mutex
+	sources   map[string]*tokenBucket
+	lastSweep time.Time
+
+	// dropped counts the packets dropped since the last report,
+	// by source bucket; accessed atomically.
+	dropped [rateLimitBuckets]int64
+}
+
+// newSourceRateLimiter returns a limiter that allows each source rate
+// packets per second on average, and bursts of up to burst packets.
+// what describes the packets, e.g. "UDP packets".
+func newSourceRateLimiter(what string, rate float64, burst int) *sourceRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &sourceRateLimiter{
+		what:      what,
+		rate:      rate,
+		burst:     float64(burst),
+		sources:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+// allow reports whether a packet from ip may be processed at time now,
+// consuming a token if so.
+func (l *sourceRateLimiter) allow(ip net.IP, now time.Time) bool {
+	// IPv4 addresses can come in either of their forms:
+	ip = ip.To16()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if now.Sub(l.lastSweep) > rateLimitSweepInterval {
+		l.sweep(now)
+	}
+
+	bucket, ok := l.sources[string(ip)]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.sources[string(ip)] = bucket
+	}
+	bucket.tokens += now.Sub(bucket.updated).Seconds() * l.rate
+	if bucket.tokens > l.burst {
+		bucket.tokens = l.burst
+	}
+	bucket.updated = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		bucket.limited = false
+		return true
+	}
+
+	atomic.AddInt64(&l.dropped[fnv1a.HashString32(string(ip))%rateLimitBuckets], 1)
+	if !bucket.limited {
+		// only log when a source starts getting limited, not for
+		// every packet we drop:
+		bucket.limited = true
+		log.WithFields(logrus.Fields{
+			"source":     ip.String(),
+			"rate_limit": l.rate,
+			"burst":      l.burst,
+		}).Warn("Rate limiting " + l.what + " from source")
+	}
+	return false
+}
+
+// sweep forgets about sources whose buckets have refilled completely.
+// It must be called with l.mtx held.
+func (l *sourceRateLimiter) sweep(now time.Time) {
+	for key, bucket := range l.sources {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate >= l.burst {
+			delete(l.sources, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// reportRateLimited emits the number of packets and HTTP ingestion
+// requests dropped since the last report, and resets the counts.
+func (s *Server) reportRateLimited() {
+	s.reportDropped(s.udpRateLimiter, "udp.rate_limited_total")
+	s.reportDropped(s.httpIngestRateLimiter, "http_ingest.rate_limited_total")
+}
+
+func (s *Server) reportDropped(l *sourceRateLimiter, name string) {
+	if l == nil {
+		return
+	}
+	for i := range l.dropped {
+		dropped := atomic.SwapInt64(&l.dropped[i], 0)
+		if dropped == 0 {
+			continue
+		}
+		s.Statsd.Count(name, dropped, []string{fmt.Sprintf("source_bucket:%d", i)}, 1.0)
+	}
+}
@@ -0,0 +1,53 @@
+package veneur
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceRateLimiter(t *testing.T) {
+	l := newSourceRateLimiter("UDP packets", 10, 5)
+	now := time.Now()
+	noisy := net.ParseIP("192.0.2.1")
+	quiet := net.ParseIP("192.0.2.2")
+
+	for i := 0; i < 5; i++ {
+		assert.True(t, l.allow(noisy, now), "packet %d should fit in the burst", i)
+	}
+	assert.False(t, l.allow(noisy, now), "burst should be exhausted")
+	assert.True(t, l.allow(quiet, now), "other sources should not be affected")
+
+	// 10 packets/s means one more token every 100ms:
+	now = now.Add(100 * time.Millisecond)
+	assert.True(t, l.allow(noisy, now))
+	assert.False(t, l.allow(noisy, now))
+
+	// the 4-byte and 16-byte forms of an address share a bucket:
+	assert.False(t, l.allow(noisy.To4(), now))
+
+	var dropped int64
+	for i := range l.dropped {
+		dropped += atomic.LoadInt64(&l.dropped[i])
+	}
+	assert.Equal(t, int64(3), dropped)
+}
+
+func TestSourceRateLimiterSweep(t *testing.T) {
+	l := newSourceRateLimiter("UDP packets", 10, 5)
+	now := time.Now()
+	l.allow(net.ParseIP("192.0.2.1"), now)
+	for i := 0; i < 10; i++ {
+		l.allow(net.ParseIP("192.0.2.2"), now)
+	}
+	assert.Len(t, l.sources, 2)
+
+	// after the sweep interval, both sources have had time to
+	// refill their buckets and are forgotten:
+	now = now.Add(rateLimitSweepInterval + time.Second)
+	l.allow(net.ParseIP("192.0.2.3"), now)
+	assert.Len(t, l.sources, 1)
+}
@@ -0,0 +1,95 @@
+package veneur
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/ssf"
+)
+
+// maxRejectedLineLength is how much of a line that failed to parse gets
+// logged.
+const maxRejectedLineLength = 256
+
+// defaultRejectedLinesPerSecond is how many lines that failed to parse
+// get logged each second unless parse_error_logs_per_second is set.
+const defaultRejectedLinesPerSecond = 10
+
+// rejectLogger logs the lines that fail to parse, but at most perSecond
+// of them each second, so that a broken client flooding veneur with bad
+// lines doesn't flood its logs too. It's safe for concurrent use.
+type rejectLogger struct {
+	perSecond int
+
+	mtx        sync.Mutex
+	second     time.Time
+	logged     int
+	suppressed int
+}
+
+func newRejectLogger(perSecond int) *rejectLogger {
+	return &rejectLogger{perSecond: perSecond}
+}
+
+// log logs the line that failed to parse with err, unless too many lines
+// have been logged in the current second. source is nil if it's unknown.
+func (l *rejectLogger) log(line []byte, source net.Addr, packetType string, category string, err error, now time.Time) {
+	if !l.allow(now, "Suppressed the logs of lines that couldn't be parsed; see veneur.packet.error_total for how many there were") {
+		return
+	}
+
+	fields := logrus.Fields{
+		logrus.ErrorKey: err,
+		"packet_type":   packetType,
+		"category":      category,
+	}
+	if len(line) > maxRejectedLineLength {
+		fields["line"] = string(line[:maxRejectedLineLength])
+		fields["line_length"] = len(line)
+	} else {
+		fields["line"] = string(line)
+	}
+	if source != nil {
+		fields["source"] = source.String()
+	}
+	log.WithFields(fields).Warn("Could not parse packet")
+}
+
+// allow returns true if another log can be written in the second of
+// now. At the start of each second, it logs how many logs were
+// suppressed in the last one with the message suppressedMsg.
+func (l *rejectLogger) allow(now time.Time, suppressedMsg string) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if second := now.Truncate(time.Second); !second.Equal(l.second) {
+		if l.suppressed > 0 {
+			log.WithField("suppressed", l.suppressed).Warn(suppressedMsg)
+		}
+		l.second = second
+		l.logged = 0
+		l.suppressed = 0
+	}
+	if l.logged >= l.perSecond {
+		l.suppressed++
+		return false
+	}
+	l.logged++
+	return true
+}
+
+// rejectLine logs a line of the packetType that failed to parse with err,
+// and returns the sample that counts it in veneur.packet.error_total.
+func (s *Server) rejectLine(line []byte, source net.Addr, packetType string, err error) *ssf.SSFSample {
+	category := samplers.ParseErrorCategory(err)
+	if s.rejectLog != nil {
+		s.rejectLog.log(line, source, packetType, category, err, time.Now())
+	}
+	return ssf.Count("packet.error_total", 1, map[string]string{
+		"packet_type": packetType,
+		"reason":      "parse",
+		"category":    category,
+	})
+}
@@ -0,0 +1,53 @@
+package veneur
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRejectLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &out
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	defer SetLogger(log)
+	SetLogger(logger)
+
+	l := newRejectLogger(2)
+	source := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}
+	now := time.Unix(100, 0)
+	long := strings.Repeat("x", maxRejectedLineLength+10)
+	l.log([]byte(long), source, "metric", "format", errors.New("bad"), now)
+	l.log([]byte("a.b.c:1|x"), nil, "metric", "type", errors.New("bad type"), now)
+	l.log([]byte("dropped"), nil, "metric", "type", errors.New("bad type"), now)
+	l.log([]byte("dropped"), nil, "metric", "type", errors.New("bad type"), now)
+	l.log([]byte("a.b.c:x|c"), nil, "metric", "value", errors.New("bad value"), now.Add(time.Second))
+
+	var entries []map[string]interface{}
+	lines := bufio.NewScanner(&out)
+	for lines.Scan() {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(lines.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.Len(t, entries, 4)
+	assert.Equal(t, long[:maxRejectedLineLength], entries[0]["line"], "long lines should be truncated")
+	assert.Equal(t, float64(len(long)), entries[0]["line_length"])
+	assert.Equal(t, "192.0.2.1:1234", entries[0]["source"])
+	assert.Equal(t, "bad", entries[0]["error"])
+	assert.Equal(t, "format", entries[0]["category"])
+	assert.Equal(t, "a.b.c:1|x", entries[1]["line"])
+	assert.NotContains(t, entries[1], "source")
+	assert.Equal(t, float64(2), entries[2]["suppressed"], "the next second should report the suppressed lines")
+	assert.Equal(t, "a.b.c:x|c", entries[3]["line"])
+}
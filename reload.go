@@ -0,0 +1,260 @@
+package veneur
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"reflect"
+	"sort"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stripe/veneur/v14/sinks"
+)
+
+// reloadableConfigKeys are the options that Reload applies to a running
+// server. Changing any other option requires a restart.
+var reloadableConfigKeys = map[string]bool{
+	"forward_metrics_allow":              true,
+	"forward_metrics_deny":               true,
+	"global_tags":                        true,
+	"metric_sinks_disabled":              true,
+	"span_sampling_default_rate_percent": true,
+	"span_sampling_rules":                true,
+	"ssf_trace_sample_rate_percent":      true,
+}
+
+// reloadableConfig holds the options that Reload can change, parsed.
+type reloadableConfig struct {
+	// globalTags are added to every metric that's flushed to the sinks,
+	// unless it already has a tag with the same key.
+	globalTags []string
+	// forwardFilter decides which metrics a local veneur forwards; nil
+	// if it forwards all of them.
+	forwardFilter *forwardFilter
+	// disabledMetricSinks are the names of the metric sinks that the
+	// flushes skip.
+	disabledMetricSinks map[string]bool
+
+	traceSampleRatePercent         float64
+	spanSamplingRules              []sinks.SpanSamplingRule
+	spanSamplingDefaultRatePercent float64
+}
+
+// parseReloadableConfig validates the reloadable options of conf.
+func parseReloadableConfig(conf Config) (reloadableConfig, error) {
+	var rc reloadableConfig
+	var err error
+	rc.globalTags, err = parseGlobalTags(conf.GlobalTags)
+	if err != nil {
+		return rc, err
+	}
+	for i, pattern := range conf.ForwardMetricsAllow {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return rc, fmt.Errorf("forward_metrics_allow[%d]: invalid metric pattern %q: %v", i, pattern, err)
+		}
+	}
+	for i, pattern := range conf.ForwardMetricsDeny {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return rc, fmt.Errorf("forward_metrics_deny[%d]: invalid metric pattern %q: %v", i, pattern, err)
+		}
+	}
+	rc.forwardFilter = newForwardFilter(conf.ForwardMetricsAllow, conf.ForwardMetricsDeny)
+	if len(conf.MetricSinksDisabled) > 0 {
+		rc.disabledMetricSinks = map[string]bool{}
+		for _, name := range conf.MetricSinksDisabled {
+			rc.disabledMetricSinks[name] = true
+		}
+	}
+
+	if conf.SsfTraceSampleRatePercent < 0 || conf.SsfTraceSampleRatePercent > 100 {
+		return rc, fmt.Errorf("ssf_trace_sample_rate_percent must be between 0 and 100, got %v", conf.SsfTraceSampleRatePercent)
+	}
+	rc.traceSampleRatePercent = conf.SsfTraceSampleRatePercent
+
+	if conf.SpanSamplingDefaultRatePercent < 0 || conf.SpanSamplingDefaultRatePercent > 100 {
+		return rc, fmt.Errorf("span_sampling_default_rate_percent must be between 0 and 100, got %v", conf.SpanSamplingDefaultRatePercent)
+	}
+	rc.spanSamplingDefaultRatePercent = conf.SpanSamplingDefaultRatePercent
+	if rc.spanSamplingDefaultRatePercent == 0 {
+		rc.spanSamplingDefaultRatePercent = 100
+	}
+	for i, rule := range conf.SpanSamplingRules {
+		if _, err := path.Match(rule.Operation, ""); err != nil {
+			return rc, fmt.Errorf("span_sampling_rules[%d]: invalid operation pattern %q: %v", i, rule.Operation, err)
+		}
+		if rule.RatePercent < 0 || rule.RatePercent > 100 {
+			return rc, fmt.Errorf("span_sampling_rules[%d]: rate_percent must be between 0 and 100, got %v", i, rule.RatePercent)
+		}
+		rc.spanSamplingRules = append(rc.spanSamplingRules, sinks.SpanSamplingRule{
+			Operation:   rule.Operation,
+			RatePercent: rule.RatePercent,
+		})
+	}
+	return rc, nil
+}
+
+// parseGlobalTags returns the global tags that are set, checking that
+// they all have a distinct key.
+func parseGlobalTags(tags []string) ([]string, error) {
+	var globalTags []string
+	for i, tag := range tags {
+		if tag == "" {
+			continue
+		}
+		if tagKey(tag) == "" {
+			return nil, fmt.Errorf("global_tags[%d]: %q has no key", i, tag)
+		}
+		for _, other := range globalTags {
+			if tagKey(other) == tagKey(tag) {
+				return nil, fmt.Errorf("global_tags[%d]: %q has the same key as %q", i, tag, other)
+			}
+		}
+		globalTags = append(globalTags, tag)
+	}
+	return globalTags, nil
+}
+
+// spanSamplingEnabled returns whether conf samples spans by their
+// operation name.
+func spanSamplingEnabled(conf Config) bool {
+	return len(conf.SpanSamplingRules) > 0 || conf.SpanSamplingDefaultRatePercent != 0
+}
+
+// traceSamplingEnabled returns whether conf samples SSF traces at
+// ingestion.
+func traceSamplingEnabled(conf Config) bool {
+	return conf.SsfTraceSampleRatePercent > 0 && conf.SsfTraceSampleRatePercent < 100
+}
+
+// checkMetricSinkNames checks that every name in the option key is the
+// name of a metric sink.
+func (s *Server) checkMetricSinkNames(key string, names []string) error {
+	known := map[string]bool{}
+	for _, sink := range s.metricSinks {
+		known[sink.Name()] = true
+	}
+	for _, name := range names {
+		if !known[name] {
+			return fmt.Errorf("%s: there is no metric sink named %q", key, name)
+		}
+	}
+	return nil
+}
+
+// currentConfig returns the reloadable options that are in effect.
+func (s *Server) currentConfig() reloadableConfig {
+	s.reloadMtx.RLock()
+	defer s.reloadMtx.RUnlock()
+	return s.reloadable
+}
+
+// ReloadConfig reads the config file at path, and reloads the server's
+// configuration from it with Reload.
+func (s *Server) ReloadConfig(path string) error {
+	conf, err := ReadConfig(path)
+	if _, ok := err.(*UnknownConfigKeys); err != nil && !ok {
+		s.Statsd.Count("config.reloads_total", 1, []string{"status:failure"}, 1.0)
+		log.WithError(err).WithField("path", path).Error("Couldn't read the config to reload")
+		return err
+	}
+	return s.Reload(conf)
+}
+
+// ReloadOnSIGHUP makes the server reload its configuration from the
+// config file at path with ReloadConfig whenever the process gets a
+// SIGHUP. It must be called before Serve or HTTPServe, which otherwise
+// shut the HTTP server down gracefully on SIGHUP.
+func (s *Server) ReloadOnSIGHUP(path string) {
+	s.reloadsOnSIGHUP = true
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			s.ReloadConfig(path)
+		}
+	}()
+}
+
+// Reload applies the options of conf that can change while the server
+// runs (see reloadableConfigKeys) at the start of the next flush,
+// without touching the listeners. Changes to the other options are
+// logged and ignored until the server restarts. If any reloadable
+// option is invalid, nothing changes.
+func (s *Server) Reload(conf Config) error {
+	rc, err := parseReloadableConfig(conf)
+	if err == nil {
+		err = s.checkMetricSinkNames("metric_sinks_disabled", conf.MetricSinksDisabled)
+	}
+	// sampling can't be turned on or off, since that changes which
+	// sinks spans go through, or whether they're sampled at ingestion
+	if err == nil && spanSamplingEnabled(conf) != spanSamplingEnabled(s.config) {
+		err = errors.New("span sampling can only be turned on or off by a restart")
+	}
+	if err == nil && traceSamplingEnabled(conf) != traceSamplingEnabled(s.config) {
+		err = errors.New("ssf_trace_sample_rate_percent: trace sampling can only be turned on or off by a restart")
+	}
+	if err != nil {
+		s.Statsd.Count("config.reloads_total", 1, []string{"status:failure"}, 1.0)
+		log.WithError(err).Error("Couldn't reload the config")
+		return err
+	}
+
+	if restartOnly := changedConfigKeys(s.config, conf); len(restartOnly) > 0 {
+		log.WithField("options", restartOnly).Warn("Some options changed that only take effect after a restart")
+	}
+
+	s.reloadMtx.Lock()
+	s.pendingReload = &rc
+	s.reloadMtx.Unlock()
+	return nil
+}
+
+// applyReload applies the configuration that Reload was last given, if
+// it hasn't been applied yet.
+func (s *Server) applyReload() {
+	s.reloadMtx.Lock()
+	rc := s.pendingReload
+	s.pendingReload = nil
+	if rc != nil {
+		s.reloadable = *rc
+	}
+	s.reloadMtx.Unlock()
+	if rc == nil {
+		return
+	}
+
+	if s.traceSampler != nil {
+		s.traceSampler.setRate(rc.traceSampleRatePercent)
+	}
+	for _, sampler := range s.spanSamplers {
+		sampler.SetRules(rc.spanSamplingRules, rc.spanSamplingDefaultRatePercent)
+	}
+	s.Statsd.Count("config.reloads_total", 1, []string{"status:success"}, 1.0)
+	log.WithFields(logrus.Fields{
+		"global_tags":           rc.globalTags,
+		"metric_sinks_disabled": len(rc.disabledMetricSinks),
+	}).Info("Reloaded the config")
+}
+
+// changedConfigKeys returns the keys of the options that aren't
+// reloadable and differ between old and new, sorted.
+func changedConfigKeys(old, new Config) []string {
+	var changed []string
+	o, n := reflect.ValueOf(old), reflect.ValueOf(new)
+	t := o.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := yamlKey(t.Field(i))
+		if key == "" || reloadableConfigKeys[key] {
+			continue
+		}
+		if !reflect.DeepEqual(o.Field(i).Interface(), n.Field(i).Interface()) {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
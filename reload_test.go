@@ -0,0 +1,153 @@
+package veneur
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func TestReloadGlobalTagsAndDisabledSinks(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	config.GlobalTags = []string{"region:us-west-2"}
+	s := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer s.Shutdown()
+
+	process := func() {
+		s.Workers[0].ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: counterTypeName},
+			Value:      1.0,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+	}
+
+	reloaded := config
+	reloaded.GlobalTags = []string{"region:eu-central-1"}
+	require.NoError(t, s.Reload(reloaded))
+	// the reload only takes effect at the start of the next flush
+	assert.Equal(t, []string{"region:us-west-2"}, s.currentConfig().globalTags)
+
+	process()
+	s.Flush(context.Background())
+	results := <-rcv
+	require.Len(t, results, 1)
+	assert.Equal(t, []string{"region:eu-central-1"}, results[0].Tags)
+
+	reloaded.MetricSinksDisabled = []string{"channel"}
+	require.NoError(t, s.Reload(reloaded))
+	process()
+	s.Flush(context.Background())
+	select {
+	case <-rcv:
+		t.Fatal("the disabled sink was flushed to")
+	default:
+	}
+}
+
+func TestReloadInvalid(t *testing.T) {
+	config := localConfig()
+	config.Interval = "1m"
+	config.GlobalTags = []string{"region:us-west-2"}
+	s := setupVeneurServer(t, config, nil, nil, nil, nil)
+	defer s.Shutdown()
+
+	tests := map[string]func(*Config){
+		"duplicate global tag": func(c *Config) {
+			c.GlobalTags = []string{"region:a", "region:b"}
+		},
+		"invalid forward pattern": func(c *Config) {
+			c.ForwardMetricsDeny = []string{"a.["}
+		},
+		"unknown disabled sink": func(c *Config) {
+			c.MetricSinksDisabled = []string{"nonexistent"}
+		},
+		"span sampling turned on": func(c *Config) {
+			c.SpanSamplingDefaultRatePercent = 50
+		},
+		"trace sampling turned on": func(c *Config) {
+			c.SsfTraceSampleRatePercent = 50
+		},
+	}
+	for name, change := range tests {
+		t.Run(name, func(t *testing.T) {
+			reloaded := config
+			reloaded.GlobalTags = []string{"region:eu-central-1"}
+			change(&reloaded)
+			assert.Error(t, s.Reload(reloaded))
+			s.applyReload()
+			assert.Equal(t, []string{"region:us-west-2"}, s.currentConfig().globalTags)
+		})
+	}
+}
+
+func TestReloadConfigFile(t *testing.T) {
+	config := localConfig()
+	config.Interval = "1m"
+	s := setupVeneurServer(t, config, nil, nil, nil, nil)
+	defer s.Shutdown()
+
+	f, err := ioutil.TempFile("", "veneur-reload")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(strings.Join([]string{
+		"global_tags:",
+		"  - region:eu-central-1",
+		"forward_metrics_deny:",
+		"  - a.*",
+	}, "\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	require.NoError(t, s.ReloadConfig(f.Name()))
+	s.applyReload()
+	rc := s.currentConfig()
+	assert.Equal(t, []string{"region:eu-central-1"}, rc.globalTags)
+	require.NotNil(t, rc.forwardFilter)
+	assert.False(t, rc.forwardFilter.forwards("a.b.c"))
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	config := localConfig()
+	config.Interval = "1m"
+	s := setupVeneurServer(t, config, nil, nil, nil, nil)
+	defer s.Shutdown()
+
+	f, err := ioutil.TempFile("", "veneur-reload")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("global_tags:\n  - region:eu-central-1\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s.ReloadOnSIGHUP(f.Name())
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	assert.Eventually(t, func() bool {
+		s.applyReload()
+		tags := s.currentConfig().globalTags
+		return len(tags) == 1 && tags[0] == "region:eu-central-1"
+	}, 5*time.Second, 10*time.Millisecond, "SIGHUP should reload the config")
+}
+
+func TestChangedConfigKeys(t *testing.T) {
+	old := localConfig()
+	new := old
+	new.GlobalTags = []string{"region:eu-central-1"}
+	new.Interval = "1m"
+	new.StatsdListenAddresses = []string{"udp://127.0.0.1:8127"}
+	assert.Equal(t, []string{"interval", "statsd_listen_addresses"}, changedConfigKeys(old, new))
+}
@@ -11,11 +11,12 @@ func _() {
 	_ = x[CounterMetric-0]
 	_ = x[GaugeMetric-1]
 	_ = x[StatusMetric-2]
+	_ = x[DistributionMetric-3]
 }
 
-const _MetricType_name = "CounterMetricGaugeMetricStatusMetric"
+const _MetricType_name = "CounterMetricGaugeMetricStatusMetricDistributionMetric"
 
-var _MetricType_index = [...]uint8{0, 13, 24, 36}
+var _MetricType_index = [...]uint8{0, 13, 24, 36, 54}
 
 func (i MetricType) String() string {
 	if i < 0 || i >= MetricType(len(_MetricType_index)-1) {
@@ -0,0 +1,107 @@
+package samplers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// NameValidator checks metric names against a set of allowed characters
+// and a maximum length, and can sanitize the names that fail.
+type NameValidator struct {
+	// allowed matches a single allowed character, or is nil if every
+	// character is allowed.
+	allowed *regexp.Regexp
+	// ascii caches whether allowed matches each ASCII character, so
+	// that the common case doesn't need to run the regexp.
+	ascii     [utf8.RuneSelf]bool
+	maxLength int
+}
+
+// NewNameValidator returns a NameValidator for the names whose characters
+// all match allowedCharacters, a regular expression that matches a single
+// character (e.g. "[a-zA-Z0-9_.-]"), and that are at most maxLength bytes
+// long. An empty allowedCharacters allows every character, and a
+// maxLength of zero allows names of any length.
+func NewNameValidator(allowedCharacters string, maxLength int) (*NameValidator, error) {
+	v := &NameValidator{maxLength: maxLength}
+	if maxLength < 0 {
+		return nil, fmt.Errorf("the maximum length %d is negative", maxLength)
+	}
+	if allowedCharacters == "" {
+		for i := range v.ascii {
+			v.ascii[i] = true
+		}
+		return v, nil
+	}
+
+	allowed, err := regexp.Compile(`^(?:` + allowedCharacters + `)$`)
+	if err != nil {
+		return nil, err
+	}
+	v.allowed = allowed
+	for i := range v.ascii {
+		v.ascii[i] = allowed.MatchString(string(rune(i)))
+	}
+	return v, nil
+}
+
+// Valid returns whether the name only has allowed characters, and isn't
+// too long.
+func (v *NameValidator) Valid(name string) bool {
+	if v.maxLength > 0 && len(name) > v.maxLength {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] >= utf8.RuneSelf {
+			// only names with multi-byte characters take the slow
+			// path
+			return v.validRunes(name[i:])
+		}
+		if !v.ascii[name[i]] {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *NameValidator) validRunes(name string) bool {
+	for _, r := range name {
+		if !v.allowedRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *NameValidator) allowedRune(r rune) bool {
+	if r < utf8.RuneSelf {
+		return v.ascii[r]
+	}
+	return v.allowed == nil || v.allowed.MatchString(string(r))
+}
+
+// Sanitize replaces each disallowed character in the name with
+// replacement, and then truncates it to the maximum length, without
+// splitting a multi-byte character.
+func (v *NameValidator) Sanitize(name string, replacement string) string {
+	var b strings.Builder
+	b.Grow(len(name))
+	for _, r := range name {
+		if v.allowedRune(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteString(replacement)
+		}
+	}
+	sanitized := b.String()
+	if v.maxLength > 0 && len(sanitized) > v.maxLength {
+		end := v.maxLength
+		for end > 0 && !utf8.RuneStart(sanitized[end]) {
+			end--
+		}
+		sanitized = sanitized[:end]
+	}
+	return sanitized
+}
@@ -0,0 +1,52 @@
+package samplers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNameValidator(t *testing.T) {
+	v, err := NewNameValidator(`[a-zA-Z0-9_.\-]|\p{Greek}`, 10)
+	require.NoError(t, err)
+	tests := []struct {
+		name      string
+		valid     bool
+		sanitized string
+	}{
+		{"a.b-c_9", true, "a.b-c_9"},
+		{"a b", false, "a_b"},
+		{"a:b|c", false, "a_b_c"},
+		{"αβγ.δ", true, "αβγ.δ"},
+		{"a.ü", false, "a._"},
+		{"bad\xffutf8", false, "bad_utf8"},
+		{"a.very.long.name", false, "a.very.lon"},
+		// the truncation doesn't split the 2-byte λ
+		{"abcdefghiλ", false, "abcdefghi"},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.valid, v.Valid(test.name), "Valid(%q)", test.name)
+		assert.Equal(t, test.sanitized, v.Sanitize(test.name, "_"), "Sanitize(%q)", test.name)
+	}
+}
+
+func TestNameValidatorDefaults(t *testing.T) {
+	v, err := NewNameValidator("", 0)
+	require.NoError(t, err)
+	assert.True(t, v.Valid("any thing! ü"), "every name should be valid")
+
+	_, err = NewNameValidator("[a-z", 0)
+	assert.Error(t, err)
+	_, err = NewNameValidator("", -1)
+	assert.Error(t, err)
+}
+
+func BenchmarkNameValidatorValid(b *testing.B) {
+	v, err := NewNameValidator(`[a-zA-Z0-9_.\-]`, 200)
+	require.NoError(b, err)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		v.Valid("veneur.flush.sink_duration_ns")
+	}
+}
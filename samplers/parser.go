@@ -2,6 +2,7 @@ package samplers
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
@@ -17,7 +18,45 @@ import (
 	"github.com/stripe/veneur/v14/ssf"
 )
 
-var invalidMetricTypeError = errors.New("Invalid type for metric")
+// The categories of the errors that ParseMetric, ParseMetricJSON and
+// ParseMetricSSF return; see ParseErrorCategory.
+const (
+	ParseErrorFormat     = "format"
+	ParseErrorType       = "type"
+	ParseErrorValue      = "value"
+	ParseErrorSampleRate = "sample_rate"
+	ParseErrorTimestamp  = "timestamp"
+	ParseErrorTags       = "tags"
+)
+
+// parseError is an error parsing a metric, along with the category of the
+// problem.
+type parseError struct {
+	category string
+	msg      string
+}
+
+func (e *parseError) Error() string {
+	return e.msg
+}
+
+func parseErrorf(category string, format string, args ...interface{}) error {
+	return &parseError{category: category, msg: fmt.Sprintf(format, args...)}
+}
+
+// ParseErrorCategory returns the category of an error that a Parse
+// function returned, e.g. ParseErrorSampleRate, so that the errors of each
+// kind can be counted together. Errors that don't have a more specific
+// category, such as those parsing events, are ParseErrorFormat errors.
+func ParseErrorCategory(err error) string {
+	var pe *parseError
+	if errors.As(err, &pe) {
+		return pe.category
+	}
+	return ParseErrorFormat
+}
+
+var invalidMetricTypeError = &parseError{category: ParseErrorType, msg: "Invalid type for metric"}
 
 // UDPMetric is a representation of the sample provided by a client. The tag list
 // should be deterministically ordered.
@@ -31,6 +70,15 @@ type UDPMetric struct {
 	Timestamp  int64
 	Message    string
 	HostName   string
+	// ContainerID is the ID of the container that sent the metric, set
+	// by DogStatsD clients with the "c:" extension. It is not part of the
+	// metric's key.
+	ContainerID string
+	// TraceID and SpanID identify the span that the metric was
+	// extracted from, if any. Histograms and timers keep one of their
+	// traced samples as an exemplar.
+	TraceID int64
+	SpanID  int64
 }
 
 // MetricScope describes where the metric will be emitted.
@@ -111,6 +159,8 @@ func ConvertMetrics(m *ssf.SSFSpan) ([]UDPMetric, error) {
 			invalid = append(invalid, metricPacket)
 			continue
 		}
+		metric.TraceID = m.TraceId
+		metric.SpanID = m.Id
 		metrics = append(metrics, metric)
 	}
 	if len(invalid) != 0 {
@@ -151,6 +201,8 @@ func ConvertIndicatorMetrics(span *ssf.SSFSpan, indicatorTimerName, objectiveTim
 		if err != nil {
 			return metrics, err
 		}
+		timer.TraceID = span.TraceId
+		timer.SpanID = span.Id
 		metrics = append(metrics, timer)
 	}
 
@@ -174,6 +226,8 @@ func ConvertIndicatorMetrics(span *ssf.SSFSpan, indicatorTimerName, objectiveTim
 		if err != nil {
 			return metrics, err
 		}
+		timer.TraceID = span.TraceId
+		timer.SpanID = span.Id
 		metrics = append(metrics, timer)
 	}
 
@@ -235,7 +289,9 @@ func (err *invalidMetrics) Samples() []*ssf.SSFSample {
 	return err.samples
 }
 
-// ParseMetricSSF converts an incoming SSF packet to a Metric.
+// ParseMetricSSF converts an incoming SSF packet to a Metric. The
+// metric is identical to the one that ParseMetric returns for the
+// equivalent DogStatsD packet, so that both aggregate together.
 func ParseMetricSSF(metric *ssf.SSFSample) (UDPMetric, error) {
 	ret := UDPMetric{
 		SampleRate: 1.0,
@@ -272,7 +328,14 @@ func ParseMetricSSF(metric *ssf.SSFSample) (UDPMetric, error) {
 	case ssf.SSFSample_GLOBAL:
 		ret.Scope = GlobalOnly
 	}
-	ret.SampleRate = metric.SampleRate
+	// An unset sample rate means the sample wasn't sampled, like a
+	// statsd packet without one.
+	if metric.SampleRate != 0 {
+		if metric.SampleRate < 0 || metric.SampleRate > 1 {
+			return UDPMetric{}, parseErrorf(ParseErrorSampleRate, "Sample rate %f must be >0 and <=1", metric.SampleRate)
+		}
+		ret.SampleRate = metric.SampleRate
+	}
 	tempTags := make([]string, 0, len(metric.Tags))
 	for key, value := range metric.Tags {
 		if key == "veneurlocalonly" {
@@ -283,6 +346,12 @@ func ParseMetricSSF(metric *ssf.SSFSample) (UDPMetric, error) {
 			ret.Scope = GlobalOnly
 			continue
 		}
+		// tags without a value are sent by statsd clients as just
+		// the key, so render them the same way.
+		if value == "" {
+			tempTags = append(tempTags, key)
+			continue
+		}
 		tempTags = append(tempTags, key+":"+value)
 	}
 	sort.Strings(tempTags)
@@ -304,22 +373,22 @@ func ParseMetric(packet []byte) (*UDPMetric, error) {
 
 	startingColon := bytes.IndexByte(pipeSplitter.Chunk(), ':')
 	if startingColon == -1 {
-		return nil, errors.New("Invalid metric packet, need at least 1 colon")
+		return nil, parseErrorf(ParseErrorFormat, "Invalid metric packet, need at least 1 colon")
 	}
 	nameChunk := pipeSplitter.Chunk()[:startingColon]
 	valueChunk := pipeSplitter.Chunk()[startingColon+1:]
 	if len(nameChunk) == 0 {
-		return nil, errors.New("Invalid metric packet, name cannot be empty")
+		return nil, parseErrorf(ParseErrorFormat, "Invalid metric packet, name cannot be empty")
 	}
 
 	if !pipeSplitter.Next() {
-		return nil, errors.New("Invalid metric packet, need at least 1 pipe for type")
+		return nil, parseErrorf(ParseErrorType, "Invalid metric packet, need at least 1 pipe for type")
 	}
 	typeChunk := pipeSplitter.Chunk()
 	if len(typeChunk) == 0 {
 		// avoid panicking on malformed packets missing a type
 		// (eg "foo:1||")
-		return nil, errors.New("Invalid metric packet, metric type not specified")
+		return nil, parseErrorf(ParseErrorType, "Invalid metric packet, metric type not specified")
 	}
 
 	h := fnv1a.Init32
@@ -333,7 +402,9 @@ func ParseMetric(packet []byte) (*UDPMetric, error) {
 		ret.Type = "counter"
 	case 'g':
 		ret.Type = "gauge"
-	case 'd', 'h': // consider DogStatsD's "distribution" to be a histogram
+	case 'd':
+		ret.Type = "distribution"
+	case 'h':
 		ret.Type = "histogram"
 	case 'm': // We can ignore the s in "ms"
 		ret.Type = "timer"
@@ -351,69 +422,76 @@ func ParseMetric(packet []byte) (*UDPMetric, error) {
 	} else {
 		v, err := strconv.ParseFloat(string(valueChunk), 64)
 		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
-			return nil, fmt.Errorf("Invalid number for metric value: %s", valueChunk)
+			return nil, parseErrorf(ParseErrorValue, "Invalid number for metric value: %s", valueChunk)
 		}
 		ret.Value = v
 	}
 
 	// each of these sections can only appear once in the packet
 	foundSampleRate := false
+	foundContainerID := false
+	foundTimestamp := false
 	for pipeSplitter.Next() {
 		if len(pipeSplitter.Chunk()) == 0 {
 			// avoid panicking on malformed packets that have too many pipes
 			// (eg "foo:1|g|" or "foo:1|c||@0.1")
-			return nil, errors.New("Invalid metric packet, empty string after/between pipes")
+			return nil, parseErrorf(ParseErrorFormat, "Invalid metric packet, empty string after/between pipes")
+		}
+		if bytes.HasPrefix(pipeSplitter.Chunk(), []byte{'c', ':'}) {
+			// DogStatsD's container ID extension
+			if foundContainerID {
+				return nil, parseErrorf(ParseErrorFormat, "Invalid metric packet, multiple container IDs specified")
+			}
+			ret.ContainerID = string(pipeSplitter.Chunk()[2:])
+			foundContainerID = true
+			continue
 		}
 		switch pipeSplitter.Chunk()[0] {
 		case '@':
 			if foundSampleRate {
-				return nil, errors.New("Invalid metric packet, multiple sample rates specified")
+				return nil, parseErrorf(ParseErrorSampleRate, "Invalid metric packet, multiple sample rates specified")
 			}
 			// sample rate!
 			sr := string(pipeSplitter.Chunk()[1:])
 			sampleRate, err := strconv.ParseFloat(sr, 32)
 			if err != nil {
-				return nil, fmt.Errorf("Invalid float for sample rate: %s", sr)
+				return nil, parseErrorf(ParseErrorSampleRate, "Invalid float for sample rate: %s", sr)
 			}
 			if sampleRate <= 0 || sampleRate > 1 {
-				return nil, fmt.Errorf("Sample rate %f must be >0 and <=1", sampleRate)
+				return nil, parseErrorf(ParseErrorSampleRate, "Sample rate %f must be >0 and <=1", sampleRate)
 			}
 			ret.SampleRate = float32(sampleRate)
 			foundSampleRate = true
 
+		case 'T':
+			// DogStatsD's timestamp extension, for backfilled points
+			if foundTimestamp {
+				return nil, parseErrorf(ParseErrorTimestamp, "Invalid metric packet, multiple timestamps specified")
+			}
+			ts := string(pipeSplitter.Chunk()[1:])
+			unixTimestamp, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil || unixTimestamp <= 0 {
+				return nil, parseErrorf(ParseErrorTimestamp, "Invalid metric packet, invalid unix timestamp: %s", ts)
+			}
+			ret.Timestamp = unixTimestamp
+			foundTimestamp = true
+
 		case '#':
 			// tags!
 			if ret.Tags != nil {
-				return nil, errors.New("Invalid metric packet, multiple tag sections specified")
+				return nil, parseErrorf(ParseErrorTags, "Invalid metric packet, multiple tag sections specified")
 			}
 			// should we be filtering known key tags from here?
 			// in order to prevent extremely high cardinality in the global stats?
 			// see worker.go line 273
-			tags := strings.Split(string(pipeSplitter.Chunk()[1:]), ",")
-			sort.Strings(tags)
-			for i, tag := range tags {
-				// we use this tag as an escape hatch for metrics that always
-				// want to be host-local
-				if strings.HasPrefix(tag, "veneurlocalonly") {
-					// delete the tag from the list
-					tags = append(tags[:i], tags[i+1:]...)
-					ret.Scope = LocalOnly
-					break
-				} else if strings.HasPrefix(tag, "veneurglobalonly") {
-					// delete the tag from the list
-					tags = append(tags[:i], tags[i+1:]...)
-					ret.Scope = GlobalOnly
-					break
-				}
-			}
-			ret.Tags = tags
+			ret.Tags, ret.Scope = scopeTags(strings.Split(string(pipeSplitter.Chunk()[1:]), ","))
 			// we specifically need the sorted version here so that hashing over
 			// tags behaves deterministically
-			ret.JoinedTags = strings.Join(tags, ",")
+			ret.JoinedTags = strings.Join(ret.Tags, ",")
 			h = fnv1a.AddString32(h, ret.JoinedTags)
 
 		default:
-			return nil, fmt.Errorf("Invalid metric packet, contains unknown section %q", pipeSplitter.Chunk())
+			return nil, parseErrorf(ParseErrorFormat, "Invalid metric packet, contains unknown section %q", pipeSplitter.Chunk())
 		}
 	}
 
@@ -422,6 +500,147 @@ func ParseMetric(packet []byte) (*UDPMetric, error) {
 	return ret, nil
 }
 
+// scopeTags sorts a metric's tags, and removes the first of veneur's
+// scope escape hatch tags from them, returning the scope that it sets.
+func scopeTags(tags []string) ([]string, MetricScope) {
+	sort.Strings(tags)
+	for i, tag := range tags {
+		// we use this tag as an escape hatch for metrics that always
+		// want to be host-local
+		if strings.HasPrefix(tag, "veneurlocalonly") {
+			// delete the tag from the list
+			return append(tags[:i], tags[i+1:]...), LocalOnly
+		} else if strings.HasPrefix(tag, "veneurglobalonly") {
+			// delete the tag from the list
+			return append(tags[:i], tags[i+1:]...), GlobalOnly
+		}
+	}
+	return tags, MixedScope
+}
+
+// jsonLineMetric is the schema of the metrics that ParseMetricJSON
+// parses.
+type jsonLineMetric struct {
+	Name       string          `json:"name"`
+	Type       string          `json:"type"`
+	Value      json.RawMessage `json:"value"`
+	Tags       []string        `json:"tags"`
+	SampleRate *float64        `json:"sample_rate"`
+}
+
+// ParseMetricJSON converts a JSON object, such as
+//
+//	{"name": "a.b.c", "type": "counter", "value": 1, "tags": ["foo:bar"], "sample_rate": 0.5}
+//
+// into a Metric, with the same meaning as the equivalent statsd packet
+// ("a.b.c:1|c|@0.5|#foo:bar"). The type is one of "counter", "gauge",
+// "histogram", "distribution", "timer" or "set". A set's value is a
+// string; every other value is a number. The tags and sample rate are
+// optional.
+func ParseMetricJSON(line []byte) (*UDPMetric, error) {
+	var jm jsonLineMetric
+	if err := json.Unmarshal(line, &jm); err != nil {
+		return nil, parseErrorf(ParseErrorFormat, "Invalid JSON metric: %v", err)
+	}
+	if jm.Name == "" {
+		return nil, parseErrorf(ParseErrorFormat, "Invalid JSON metric, name cannot be empty")
+	}
+	ret := &UDPMetric{
+		SampleRate: 1.0,
+	}
+	ret.Name = jm.Name
+	switch jm.Type {
+	case "counter", "gauge", "histogram", "distribution", "timer", "set":
+		ret.Type = jm.Type
+	default:
+		return nil, invalidMetricTypeError
+	}
+
+	if len(jm.Value) == 0 {
+		return nil, parseErrorf(ParseErrorValue, "Invalid JSON metric, value not specified")
+	}
+	if ret.Type == "set" {
+		var v string
+		if err := json.Unmarshal(jm.Value, &v); err != nil {
+			return nil, parseErrorf(ParseErrorValue, "Invalid string for set value: %s", jm.Value)
+		}
+		ret.Value = v
+	} else {
+		var v float64
+		if err := json.Unmarshal(jm.Value, &v); err != nil {
+			return nil, parseErrorf(ParseErrorValue, "Invalid number for metric value: %s", jm.Value)
+		}
+		ret.Value = v
+	}
+
+	if jm.SampleRate != nil {
+		if *jm.SampleRate <= 0 || *jm.SampleRate > 1 {
+			return nil, parseErrorf(ParseErrorSampleRate, "Sample rate %f must be >0 and <=1", *jm.SampleRate)
+		}
+		ret.SampleRate = float32(*jm.SampleRate)
+	}
+
+	if jm.Tags != nil {
+		ret.Tags, ret.Scope = scopeTags(jm.Tags)
+		ret.JoinedTags = strings.Join(ret.Tags, ",")
+	}
+
+	h := fnv1a.Init32
+	h = fnv1a.AddString32(h, ret.Name)
+	h = fnv1a.AddString32(h, ret.Type)
+	h = fnv1a.AddString32(h, ret.JoinedTags)
+	ret.Digest = h
+	return ret, nil
+}
+
+// AddTags adds tags to a metric, keeping its tag list sorted and
+// updating its key and digest to match, as if the tags had been part
+// of the packet it was parsed from.
+func (u *UDPMetric) AddTags(tags ...string) {
+	if len(tags) == 0 {
+		return
+	}
+	joined := make([]string, 0, len(u.Tags)+len(tags))
+	joined = append(joined, u.Tags...)
+	joined = append(joined, tags...)
+	sort.Strings(joined)
+	u.Tags = joined
+	u.JoinedTags = strings.Join(joined, ",")
+	u.updateDigest()
+}
+
+// SetTags replaces the tags of a metric, sorting them and updating its
+// key and digest to match its name, type and tags, as if it had been
+// parsed from a packet.
+func (u *UDPMetric) SetTags(tags ...string) {
+	sorted := make([]string, len(tags))
+	copy(sorted, tags)
+	sort.Strings(sorted)
+	u.Tags = sorted
+	u.JoinedTags = strings.Join(sorted, ",")
+	u.updateDigest()
+}
+
+// Rename changes the name of the metric, and updates its digest.
+func (u *UDPMetric) Rename(name string) {
+	u.Name = name
+	u.updateDigest()
+}
+
+// SetType changes the type of the metric, and updates its digest.
+func (u *UDPMetric) SetType(typ string) {
+	u.Type = typ
+	u.updateDigest()
+}
+
+func (u *UDPMetric) updateDigest() {
+	h := fnv1a.Init32
+	h = fnv1a.AddString32(h, u.Name)
+	h = fnv1a.AddString32(h, u.Type)
+	h = fnv1a.AddString32(h, u.JoinedTags)
+	u.Digest = h
+}
+
 // ParseEvent parses a DogStatsD event packet and returns an SSF sample or an
 // error on failure. To facilitate the many Datadog-specific values that are
 // present in a DogStatsD event but not in an SSF sample, a series of special
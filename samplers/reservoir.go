@@ -0,0 +1,96 @@
+package samplers
+
+import (
+	"container/heap"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Reservoir keeps a random sample of at most a fixed number of weighted
+// observations, and estimates quantiles from it, as a bounded alternative
+// to a t-digest. Each observation is kept with a probability proportional
+// to its weight (Efraimidis and Spirakis' A-Res algorithm), so the sample
+// estimates the weighted distribution of the observations.
+//
+// Its memory doesn't grow with the number of observations, but its
+// quantiles are only as good as the sample: the rank of each one is off by
+// about sqrt(q*(1-q)/size), and the quantiles beyond the last 1/size of
+// the observations are those of the most extreme sampled observation. A
+// t-digest is more accurate, especially at the extremes.
+type Reservoir struct {
+	size  int
+	items reservoirItems
+	// sorted caches the sampled values in order, for Quantile.
+	sorted []float64
+}
+
+type reservoirItem struct {
+	value float64
+	// key is the item's random key, scaled by its weight; the items with
+	// the largest keys are kept.
+	key float64
+}
+
+// reservoirItems is a min-heap of items by key.
+type reservoirItems []reservoirItem
+
+func (r reservoirItems) Len() int            { return len(r) }
+func (r reservoirItems) Less(i, j int) bool  { return r[i].key < r[j].key }
+func (r reservoirItems) Swap(i, j int)       { r[i], r[j] = r[j], r[i] }
+func (r *reservoirItems) Push(x interface{}) { *r = append(*r, x.(reservoirItem)) }
+func (r *reservoirItems) Pop() interface{} {
+	old := *r
+	item := old[len(old)-1]
+	*r = old[:len(old)-1]
+	return item
+}
+
+// NewReservoir returns a Reservoir that keeps up to size observations.
+func NewReservoir(size int) *Reservoir {
+	return &Reservoir{size: size}
+}
+
+// Add offers an observation with the given weight to the sample.
+func (r *Reservoir) Add(value, weight float64) {
+	// the key of A-Res is u^(1/weight) for u uniform in (0, 1]; its log
+	// orders the items the same way without underflowing
+	key := math.Log(1-rand.Float64()) / weight
+	r.sorted = nil
+	if len(r.items) < r.size {
+		heap.Push(&r.items, reservoirItem{value: value, key: key})
+		return
+	}
+	if len(r.items) > 0 && key > r.items[0].key {
+		r.items[0] = reservoirItem{value: value, key: key}
+		heap.Fix(&r.items, 0)
+	}
+}
+
+// Quantile returns the estimated value at the quantile q (between 0 and
+// 1), interpolating between the sampled values around it. It returns
+// NaN if there are no observations.
+func (r *Reservoir) Quantile(q float64) float64 {
+	if len(r.items) == 0 {
+		return math.NaN()
+	}
+	if r.sorted == nil {
+		r.sorted = make([]float64, len(r.items))
+		for i, item := range r.items {
+			r.sorted[i] = item.value
+		}
+		sort.Float64s(r.sorted)
+	}
+	pos := q * float64(len(r.sorted)-1)
+	i := int(pos)
+	if i >= len(r.sorted)-1 {
+		return r.sorted[len(r.sorted)-1]
+	}
+	frac := pos - float64(i)
+	return r.sorted[i] + frac*(r.sorted[i+1]-r.sorted[i])
+}
+
+// Len returns the number of observations in the sample.
+func (r *Reservoir) Len() int {
+	return len(r.items)
+}
@@ -0,0 +1,91 @@
+package samplers
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/tdigest"
+)
+
+func TestReservoirBounded(t *testing.T) {
+	r := NewReservoir(100)
+	assert.True(t, math.IsNaN(r.Quantile(0.5)), "there are no observations yet")
+	for i := 0; i < 10000; i++ {
+		r.Add(float64(i), 1)
+	}
+	assert.Equal(t, 100, r.Len())
+	assert.True(t, r.Quantile(0) <= r.Quantile(0.5))
+	assert.True(t, r.Quantile(0.5) <= r.Quantile(1))
+	assert.True(t, r.Quantile(1) < 10000)
+}
+
+func TestReservoirFewObservations(t *testing.T) {
+	r := NewReservoir(100)
+	for _, v := range []float64{4, 1, 3, 2} {
+		r.Add(v, 1)
+	}
+	// all the observations fit, so the quantiles are exact
+	assert.Equal(t, 1.0, r.Quantile(0))
+	assert.Equal(t, 2.5, r.Quantile(0.5))
+	assert.Equal(t, 4.0, r.Quantile(1))
+}
+
+func TestReservoirWeights(t *testing.T) {
+	r := NewReservoir(1000)
+	// the 1s are sampled at 1:9, so they're 90% of the observations
+	for i := 0; i < 10000; i++ {
+		r.Add(1, 9)
+		r.Add(2, 1)
+	}
+	assert.Equal(t, 1.0, r.Quantile(0.8))
+	assert.Equal(t, 2.0, r.Quantile(0.95))
+}
+
+// TestReservoirMatchesDigest compares the percentiles of a reservoir and
+// of a t-digest of the same observations with the exact ones, as a
+// fraction of the range of the observations.
+func TestReservoirMatchesDigest(t *testing.T) {
+	rand.Seed(1)
+	const n = 100000
+	r := NewReservoir(10000)
+	td := tdigest.NewMerging(100, false)
+	for i := 0; i < n; i++ {
+		// a long-tailed distribution, like latencies
+		v := rand.ExpFloat64() * 100
+		r.Add(v, 1)
+		td.Add(v, 1)
+	}
+	exact := func(q float64) float64 {
+		// the quantile function of the exponential distribution
+		return -math.Log(1-q) * 100
+	}
+	for _, q := range []float64{0.5, 0.75, 0.9, 0.99} {
+		actual := exact(q)
+		reservoirError := math.Abs(r.Quantile(q)-actual) / actual
+		digestError := math.Abs(td.Quantile(q)-actual) / actual
+		t.Logf("p%v: reservoir is off by %.2f%%, t-digest by %.2f%%", q*100, reservoirError*100, digestError*100)
+		require.True(t, reservoirError < 0.1, "p%v of the reservoir is off by %.2f%%", q*100, reservoirError*100)
+		assert.True(t, digestError < 0.02, "p%v of the t-digest is off by %.2f%%", q*100, digestError*100)
+	}
+}
+
+func TestReservoirHistFlush(t *testing.T) {
+	h := NewReservoirHist("a.b.c", []string{"a:b"}, 10)
+	for i := 1; i <= 5; i++ {
+		h.Sample(float64(i), 1)
+	}
+	metrics := h.Flush(0, []float64{0.5}, HistogramAggregates{Value: AggregateMedian | AggregateCount, Count: 2}, false)
+	values := map[string]float64{}
+	for _, m := range metrics {
+		values[m.Name] = m.Value
+	}
+	assert.Equal(t, map[string]float64{
+		"a.b.c.count":        5,
+		"a.b.c.median":       3,
+		"a.b.c.50percentile": 3,
+	}, values)
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math"
+	"math/rand"
+	"strconv"
 	"strings"
 	"time"
 
@@ -24,6 +26,9 @@ const (
 	GaugeMetric
 	// StatusMetric is a status (synonymous with a service check)
 	StatusMetric
+	// DistributionMetric holds the samples of a distribution, in Values,
+	// for the sinks that aggregate them themselves
+	DistributionMetric
 )
 
 // RouteInformation is a key-only map indicating sink names that are
@@ -53,11 +58,36 @@ type InterMetric struct {
 	Type      MetricType
 	Message   string
 	HostName  string
+	// Values are the samples of a DistributionMetric, which has no
+	// Value.
+	Values []float64
 
 	// Sinks, if non-nil, indicates which metric sinks a metric
 	// should be inserted into. If nil, that means the metric is
 	// meant to go to every sink.
 	Sinks RouteInformation
+
+	// Exemplar, if non-nil, is an observation of the histogram that
+	// the metric was computed from, for sinks that support exemplars.
+	// It's shared between all the metrics of the histogram, and must
+	// not be modified.
+	Exemplar *Exemplar
+
+	// Timer is true for the metrics computed from a timer (the statsd `ms`
+	// type), rather than from a histogram, for the sinks that handle the
+	// two differently. Both are aggregated the same way.
+	Timer bool
+}
+
+// Exemplar is a raw observation of a histogram, along with the trace in
+// which it was observed.
+type Exemplar struct {
+	Value float64
+	// Timestamp is when the observation was sampled, in nanoseconds
+	// since the epoch.
+	Timestamp int64
+	TraceID   int64
+	SpanID    int64
 }
 
 type Aggregate int
@@ -363,17 +393,48 @@ func NewStatusCheck(Name string, Tags []string) *StatusCheck {
 	return &StatusCheck{InterMetric{Name: Name, Tags: Tags}}
 }
 
+// setCapCheckInterval is how many samples a capped Set takes between
+// estimates of its cardinality, which are too expensive to make on every
+// sample.
+const setCapCheckInterval = 64
+
 // Set is a list of unique values seen.
 type Set struct {
 	Name string
 	Tags []string
 	Hll  *hyperloglog.Sketch
+
+	// MaxCardinality is the estimated number of unique values after which
+	// the set stops taking samples. If it is 0, the set is unlimited.
+	MaxCardinality uint64
+
+	samples uint64
+	capped  bool
 }
 
 // Sample checks if the supplied value has is already in the filter. If not, it increments
 // the counter!
 func (s *Set) Sample(sample string) {
+	if s.capped {
+		return
+	}
 	s.Hll.Insert([]byte(sample))
+
+	if s.MaxCardinality == 0 {
+		return
+	}
+	// the estimate can't be much more than the number of samples, so
+	// there's no need to check it until there are enough of them
+	s.samples++
+	if s.samples >= s.MaxCardinality && (s.samples-s.MaxCardinality)%setCapCheckInterval == 0 {
+		s.capped = s.Hll.Estimate() >= s.MaxCardinality
+	}
+}
+
+// Capped returns true if the set has reached its MaxCardinality and
+// stopped taking samples.
+func (s *Set) Capped() bool {
+	return s.capped
 }
 
 // NewSet generates a new Set and returns it
@@ -390,8 +451,11 @@ func NewSet(Name string, Tags []string) *Set {
 
 // Flush generates an InterMetric for the state of this Set.
 func (s *Set) Flush() []InterMetric {
-	tags := make([]string, len(s.Tags))
+	tags := make([]string, len(s.Tags), len(s.Tags)+1)
 	copy(tags, s.Tags)
+	if s.capped {
+		tags = append(tags, "capped:true")
+	}
 	return []InterMetric{{
 		Name:      s.Name,
 		Timestamp: time.Now().Unix(),
@@ -478,12 +542,31 @@ type Histo struct {
 	LocalMax           float64
 	LocalSum           float64
 	LocalReciprocalSum float64
+
+	// Reservoir, if set, replaces the t-digest (Value is nil) to compute
+	// the percentiles of the samples that came through this veneur
+	// instance with bounded memory.
+	Reservoir *Reservoir
+
+	// Exemplar is one of the traced observations that came through this
+	// veneur instance, if there were any.
+	Exemplar *Exemplar
+	// exemplarCandidates counts the traced observations, so that each
+	// has the same chance of ending up as the exemplar.
+	exemplarCandidates int
 }
 
-// Sample adds the supplied value to the histogram.
+// Sample adds the supplied value to the histogram. Each sample is weighted
+// by the inverse of its sample rate, so the count and sum estimate what the
+// client observed, while the percentiles are those of the sampled values.
 func (h *Histo) Sample(sample float64, sampleRate float32) {
 	weight := float64(1 / sampleRate)
-	h.Value.Add(sample, weight)
+	if h.Value != nil {
+		h.Value.Add(sample, weight)
+	}
+	if h.Reservoir != nil {
+		h.Reservoir.Add(sample, weight)
+	}
 
 	h.LocalWeight += weight
 	h.LocalMin = math.Min(h.LocalMin, sample)
@@ -493,6 +576,24 @@ func (h *Histo) Sample(sample float64, sampleRate float32) {
 	h.LocalReciprocalSum += (1 / sample) * weight
 }
 
+// SampleExemplar offers a traced observation, which should also be passed
+// to Sample, as the histogram's exemplar. Only one observation is kept,
+// chosen at random from the ones that are offered.
+func (h *Histo) SampleExemplar(sample float64, traceID, spanID int64) {
+	h.exemplarCandidates++
+	if h.Exemplar == nil {
+		h.Exemplar = &Exemplar{}
+	} else if rand.Intn(h.exemplarCandidates) != 0 {
+		return
+	}
+	*h.Exemplar = Exemplar{
+		Value:     sample,
+		Timestamp: time.Now().UnixNano(),
+		TraceID:   traceID,
+		SpanID:    spanID,
+	}
+}
+
 // NewHist generates a new Histo and returns it.
 func NewHist(Name string, Tags []string) *Histo {
 	return &Histo{
@@ -506,6 +607,31 @@ func NewHist(Name string, Tags []string) *Histo {
 	}
 }
 
+// NewAggregatesOnlyHist generates a new Histo that only tracks the local
+// aggregates of its samples, and not a t-digest. It can't be forwarded or
+// merged, and flushes no percentiles or median.
+func NewAggregatesOnlyHist(Name string, Tags []string) *Histo {
+	return &Histo{
+		Name:     Name,
+		Tags:     Tags,
+		LocalMin: math.Inf(+1),
+		LocalMax: math.Inf(-1),
+	}
+}
+
+// NewReservoirHist generates a new Histo that computes its percentiles
+// from a Reservoir of up to size samples instead of a t-digest. Like the
+// aggregates-only ones, it can't be merged.
+func NewReservoirHist(Name string, Tags []string, size int) *Histo {
+	return &Histo{
+		Name:      Name,
+		Tags:      Tags,
+		Reservoir: NewReservoir(size),
+		LocalMin:  math.Inf(+1),
+		LocalMax:  math.Inf(-1),
+	}
+}
+
 // Flush generates InterMetrics for the current state of the Histo. percentiles
 // indicates what percentiles should be exported from the histogram.
 func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates HistogramAggregates, global bool) []InterMetric {
@@ -619,7 +745,16 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 		})
 	}
 
-	if (aggregates.Value & AggregateMedian) == AggregateMedian {
+	// percentiles and the median are computed from the digest, or from the
+	// reservoir that replaces it; aggregates-only histograms have neither
+	var quantile func(float64) float64
+	if h.Value != nil {
+		quantile = h.Value.Quantile
+	} else if h.Reservoir != nil && h.Reservoir.Len() > 0 {
+		quantile = h.Reservoir.Quantile
+	}
+
+	if (aggregates.Value&AggregateMedian) == AggregateMedian && quantile != nil {
 		tags := make([]string, len(h.Tags))
 		copy(tags, h.Tags)
 		metrics = append(
@@ -627,7 +762,7 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 			InterMetric{
 				Name:      fmt.Sprintf("%s.median", h.Name),
 				Timestamp: now,
-				Value:     float64(h.Value.Quantile(0.5)),
+				Value:     quantile(0.5),
 				Tags:      tags,
 				Type:      GaugeMetric,
 				Sinks:     sinks,
@@ -654,16 +789,18 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 		})
 	}
 
+	if quantile == nil {
+		percentiles = nil
+	}
 	for _, p := range percentiles {
 		tags := make([]string, len(h.Tags))
 		copy(tags, h.Tags)
 		metrics = append(
 			metrics,
-			// TODO Fix to allow for p999, etc
 			InterMetric{
-				Name:      fmt.Sprintf("%s.%dpercentile", h.Name, int(p*100)),
+				Name:      fmt.Sprintf("%s.%spercentile", h.Name, percentileSuffix(p)),
 				Timestamp: now,
-				Value:     float64(h.Value.Quantile(p)),
+				Value:     quantile(p),
 				Tags:      tags,
 				Type:      GaugeMetric,
 				Sinks:     sinks,
@@ -671,11 +808,28 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 		)
 	}
 
+	if h.Exemplar != nil {
+		for i := range metrics {
+			metrics[i].Exemplar = h.Exemplar
+		}
+	}
 	return metrics
 }
 
+// percentileSuffix returns the name of the percentile p in a metric
+// name: "99" for 0.99 and, since metric names use dots as separators,
+// "999" for 0.999.
+func percentileSuffix(p float64) string {
+	// round away floating point noise, as in 0.29*100 = 28.999999999999996
+	pct := strconv.FormatFloat(math.Round(p*100*1e6)/1e6, 'f', -1, 64)
+	return strings.Replace(pct, ".", "", 1)
+}
+
 // Export converts a Histogram into a JSONMetric
 func (h *Histo) Export() (JSONMetric, error) {
+	if h.Value == nil {
+		return JSONMetric{}, fmt.Errorf("histogram %s only has aggregates and can't be exported", h.Name)
+	}
 	val, err := h.Value.GobEncode()
 	if err != nil {
 		return JSONMetric{}, err
@@ -711,6 +865,9 @@ func (h *Histo) GetName() string {
 // at the time this function was called.  This should be used to export
 // a Histo for forwarding.
 func (h *Histo) Metric() (*metricpb.Metric, error) {
+	if h.Value == nil {
+		return nil, fmt.Errorf("histogram %s only has aggregates and can't be exported", h.Name)
+	}
 	return &metricpb.Metric{
 		Name: h.Name,
 		Tags: h.Tags,
@@ -728,3 +885,69 @@ func (h *Histo) Merge(v *metricpb.HistogramValue) {
 		h.Value.Merge(tdigest.NewMergingFromData(v.TDigest))
 	}
 }
+
+// MergeHistograms merges t-digests that were serialized as protobuf
+// tdigest.MergingDigestData messages, as veneurs forward them to each other
+// over gRPC, in the same way that a global veneur merges forwarded
+// histograms. It returns the merged digest, and the metrics that a global
+// veneur would flush for it with the given percentiles and aggregates.
+//
+// The digests may have been created with any compression; the merged
+// digest uses the same compression as veneur's own histograms.
+func MergeHistograms(name string, tags []string, digests [][]byte, percentiles []float64, aggregates HistogramAggregates) (*tdigest.MergingDigest, []InterMetric, error) {
+	h := NewHist(name, tags)
+	for i, b := range digests {
+		data := &tdigest.MergingDigestData{}
+		if err := data.Unmarshal(b); err != nil {
+			return nil, nil, fmt.Errorf("failed to decode t-digest %d: %v", i, err)
+		}
+		h.Merge(&metricpb.HistogramValue{TDigest: data})
+	}
+	return h.Value, h.Flush(0, percentiles, aggregates, true), nil
+}
+
+// distributionCompression is the compression of a Distribution's t-digest.
+// It's higher than a histogram's, since its centroids stand in for the
+// samples that sinks like Datadog aggregate across hosts themselves.
+const distributionCompression = 1000
+
+// Distribution is a histogram whose samples are also passed, as the
+// centroids of its t-digest, to the sinks that aggregate them themselves,
+// like Datadog's distributions, which are aggregated across all the hosts
+// that report them. The other sinks can receive it as a histogram. Like a
+// histogram's, its memory is bounded however many samples it receives.
+type Distribution struct {
+	*Histo
+}
+
+// NewDistribution creates a new Distribution.
+func NewDistribution(Name string, Tags []string) *Distribution {
+	h := NewHist(Name, Tags)
+	h.Value = tdigest.NewMerging(distributionCompression, false)
+	return &Distribution{Histo: h}
+}
+
+// FlushDistribution generates the DistributionMetric that holds the
+// distribution's samples: the mean of each centroid of its t-digest,
+// repeated as many times as the samples it stands for, rounded.
+func (d *Distribution) FlushDistribution() []InterMetric {
+	centroids := d.Value.Data().MainCentroids
+	values := make([]float64, 0, len(centroids))
+	for _, c := range centroids {
+		n := int(math.Round(c.Weight))
+		if n < 1 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			values = append(values, c.Mean)
+		}
+	}
+	return []InterMetric{{
+		Name:      d.Name,
+		Timestamp: time.Now().Unix(),
+		Values:    values,
+		Tags:      d.Tags,
+		Type:      DistributionMetric,
+		Sinks:     routeInfo(d.Tags),
+	}}
+}
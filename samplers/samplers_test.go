@@ -10,6 +10,7 @@ import (
 	"github.com/stripe/veneur/v14/tdigest"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/v14/ssf"
 )
 
@@ -212,6 +213,30 @@ func TestSet(t *testing.T) {
 	assert.Equal(t, float64(4), m1.Value, "Value")
 }
 
+func TestSetMaxCardinality(t *testing.T) {
+	s := NewSet("a.b.c", []string{"a:b"})
+	s.MaxCardinality = 100
+	for i := 0; i < 1000; i++ {
+		s.Sample(strconv.Itoa(i))
+	}
+	assert.True(t, s.Capped(), "set should be capped")
+	estimate := s.Hll.Estimate()
+	assert.True(t, estimate >= 100 && estimate < 100+setCapCheckInterval+10,
+		"set should stop taking samples shortly after the cap, but has %d", estimate)
+
+	metrics := s.Flush()
+	assert.Len(t, metrics, 1, "Flush")
+	assert.Equal(t, []string{"a:b", "capped:true"}, metrics[0].Tags)
+	assert.Equal(t, []string{"a:b"}, s.Tags, "should not modify the set's tags")
+
+	unlimited := NewSet("a.b.c", []string{"a:b"})
+	for i := 0; i < 1000; i++ {
+		unlimited.Sample(strconv.Itoa(i))
+	}
+	assert.False(t, unlimited.Capped())
+	assert.Equal(t, []string{"a:b"}, unlimited.Flush()[0].Tags)
+}
+
 func TestSetMerge(t *testing.T) {
 	rand.Seed(time.Now().Unix())
 
@@ -308,6 +333,81 @@ func TestLocalHistoFlushedBehavior(t *testing.T) {
 	assert.Equal(t, float64(1), m[0].Value, "histogram returned global value for mixed scope flush.")
 }
 
+func TestHistoExemplar(t *testing.T) {
+	aggregates := HistogramAggregates{
+		Value: AggregateMax | AggregateCount,
+		Count: 2,
+	}
+	h := NewHist("test", []string{"foo:bar"})
+	h.Sample(5, 1.0)
+	for _, m := range h.Flush(10*time.Second, []float64{0.5}, aggregates, false) {
+		assert.Nil(t, m.Exemplar, "untraced samples shouldn't be exemplars")
+	}
+
+	chosen := map[int64]int{}
+	for i := 0; i < 1000; i++ {
+		h := NewHist("test", []string{"foo:bar"})
+		for id := int64(1); id <= 3; id++ {
+			h.Sample(float64(id), 1.0)
+			h.SampleExemplar(float64(id), id, id*10)
+		}
+		metrics := h.Flush(10*time.Second, []float64{0.5}, aggregates, false)
+		require.Len(t, metrics, 3)
+		exemplar := metrics[0].Exemplar
+		require.NotNil(t, exemplar)
+		for _, m := range metrics {
+			assert.Equal(t, exemplar, m.Exemplar, "all of the histogram's metrics should have its exemplar")
+		}
+		assert.Equal(t, float64(exemplar.TraceID), exemplar.Value)
+		assert.Equal(t, exemplar.TraceID*10, exemplar.SpanID)
+		chosen[exemplar.TraceID]++
+	}
+	for id := int64(1); id <= 3; id++ {
+		assert.True(t, chosen[id] > 200, "sample %d was the exemplar only %d times out of 1000", id, chosen[id])
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	d := NewDistribution("test", []string{"foo:bar", "veneursinkonly:datadog"})
+	d.Sample(5, 1.0)
+	d.Sample(2, 0.25)
+
+	metrics := d.FlushDistribution()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "test", metrics[0].Name)
+	assert.Equal(t, DistributionMetric, metrics[0].Type)
+	assert.Equal(t, []float64{2, 2, 2, 2, 5}, metrics[0].Values, "sampled values should be repeated")
+	assert.Equal(t, RouteInformation{"datadog": struct{}{}}, metrics[0].Sinks)
+
+	aggregates := HistogramAggregates{Value: AggregateCount, Count: 1}
+	histogram := d.Flush(10*time.Second, nil, aggregates, false)
+	require.Len(t, histogram, 1)
+	assert.Equal(t, "test.count", histogram[0].Name)
+	assert.Equal(t, 5.0, histogram[0].Value, "the histogram should count the same samples")
+}
+
+func TestDistributionBounded(t *testing.T) {
+	d := NewDistribution("test", nil)
+	for i := 0; i < 100000; i++ {
+		d.Sample(float64(i), 1.0)
+	}
+	assert.True(t, len(d.Value.Data().MainCentroids) <= 2*distributionCompression,
+		"the distribution kept %d centroids", len(d.Value.Data().MainCentroids))
+
+	metrics := d.FlushDistribution()
+	require.Len(t, metrics, 1)
+	assert.Len(t, metrics[0].Values, 100000, "each sample should still be counted")
+}
+
+func TestPercentileSuffix(t *testing.T) {
+	assert.Equal(t, "50", percentileSuffix(0.5))
+	assert.Equal(t, "99", percentileSuffix(0.99))
+	assert.Equal(t, "29", percentileSuffix(0.29))
+	assert.Equal(t, "999", percentileSuffix(0.999))
+	assert.Equal(t, "9999", percentileSuffix(0.9999))
+	assert.Equal(t, "05", percentileSuffix(0.005))
+}
+
 func TestHisto(t *testing.T) {
 	h := NewHist("a.b.c", []string{"a:b"})
 
@@ -435,6 +535,37 @@ func TestHistoAvgOnly(t *testing.T) {
 	assert.Equal(t, float64(15), m5.Value, "Value")
 }
 
+func TestAggregatesOnlyHisto(t *testing.T) {
+	h := NewAggregatesOnlyHist("a.b.c", []string{"a:b"})
+	assert.Nil(t, h.Value, "should not have a digest")
+
+	h.Sample(5, 1.0)
+	h.Sample(10, 0.5)
+	h.Sample(15, 1.0)
+
+	aggregates := HistogramAggregates{
+		Value: AggregateMin | AggregateMax | AggregateCount | AggregateSum | AggregateMedian,
+		Count: 5,
+	}
+	metrics := h.Flush(10*time.Second, []float64{0.5, 0.99}, aggregates, false)
+
+	values := map[string]float64{}
+	for _, m := range metrics {
+		values[m.Name] = m.Value
+	}
+	assert.Equal(t, map[string]float64{
+		"a.b.c.min":   5,
+		"a.b.c.max":   15,
+		"a.b.c.count": 4,
+		"a.b.c.sum":   40,
+	}, values, "should flush no median or percentiles")
+
+	_, err := h.Metric()
+	assert.Error(t, err)
+	_, err = h.Export()
+	assert.Error(t, err)
+}
+
 func TestHistoHMeanOnly(t *testing.T) {
 	h := NewHist("a.b.c", []string{"a:b"})
 
@@ -524,6 +655,52 @@ func TestHistoMerge(t *testing.T) {
 	assert.InDelta(t, 1.0, h2.LocalMax, 0.02, "merged histogram should have max of 1 after adding a value")
 }
 
+func TestMergeHistograms(t *testing.T) {
+	// digests with different compressions, each holding every third
+	// value between 1 and 3000
+	var digests [][]byte
+	for i, compression := range []float64{20, 100, 1000} {
+		td := tdigest.NewMerging(compression, false)
+		for v := i + 1; v <= 3000; v += 3 {
+			td.Add(float64(v), 1)
+		}
+		b, err := td.Data().Marshal()
+		assert.NoError(t, err)
+		digests = append(digests, b)
+	}
+
+	aggregates := HistogramAggregates{
+		Value: AggregateMin | AggregateMax | AggregateCount | AggregateSum,
+		Count: 4,
+	}
+	merged, metrics, err := MergeHistograms("a.b.c", []string{"a:b"}, digests, []float64{0.5, 0.99}, aggregates)
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(3000), merged.Count())
+	// digests are merged centroid by centroid, so the extremes are only
+	// as accurate as the outermost centroids
+	assert.InDelta(t, 1, merged.Min(), 5)
+	assert.InDelta(t, 3000, merged.Max(), 5)
+	assert.InEpsilon(t, 1500, merged.Quantile(0.5), 0.02)
+	assert.InEpsilon(t, 2970, merged.Quantile(0.99), 0.02)
+
+	values := map[string]float64{}
+	for _, m := range metrics {
+		assert.Equal(t, []string{"a:b"}, m.Tags)
+		values[m.Name] = m.Value
+	}
+	assert.Len(t, values, 6)
+	assert.Equal(t, merged.Min(), values["a.b.c.min"])
+	assert.Equal(t, merged.Max(), values["a.b.c.max"])
+	assert.Equal(t, float64(3000), values["a.b.c.count"])
+	assert.InEpsilon(t, float64(3000*3001/2), values["a.b.c.sum"], 1e-9)
+	assert.InEpsilon(t, 1500, values["a.b.c.50percentile"], 0.02)
+	assert.InEpsilon(t, 2970, values["a.b.c.99percentile"], 0.02)
+
+	_, _, err = MergeHistograms("a.b.c", nil, [][]byte{{0xff}}, nil, aggregates)
+	assert.Error(t, err, "should not merge garbage")
+}
+
 // Test the Metric and Merge function on Set
 func TestHistoMergeMetric(t *testing.T) {
 	rand.Seed(time.Now().Unix())
@@ -738,6 +915,84 @@ func TestParseMetricSSF(t *testing.T) {
 	}
 }
 
+func TestParseMetricSSFMatchesDogStatsD(t *testing.T) {
+	tests := []struct {
+		packet string
+		sample ssf.SSFSample
+	}{
+		{
+			packet: "a.b.c:3|c|@0.5|#foo:bar,novalue",
+			sample: ssf.SSFSample{
+				Metric:     ssf.SSFSample_COUNTER,
+				Name:       "a.b.c",
+				Value:      3,
+				SampleRate: 0.5,
+				Tags:       map[string]string{"foo": "bar", "novalue": ""},
+			},
+		},
+		{
+			packet: "a.b.c:3|c",
+			sample: ssf.SSFSample{
+				Metric: ssf.SSFSample_COUNTER,
+				Name:   "a.b.c",
+				Value:  3,
+			},
+		},
+		{
+			packet: "a.b.c:12.5|h|#foo:bar,veneurglobalonly",
+			sample: ssf.SSFSample{
+				Metric: ssf.SSFSample_HISTOGRAM,
+				Name:   "a.b.c",
+				Value:  12.5,
+				Tags:   map[string]string{"foo": "bar", "veneurglobalonly": ""},
+			},
+		},
+		{
+			packet: "a.b.c:2|g|#foo:bar,veneurlocalonly",
+			sample: ssf.SSFSample{
+				Metric: ssf.SSFSample_GAUGE,
+				Name:   "a.b.c",
+				Value:  2,
+				Tags:   map[string]string{"foo": "bar"},
+				Scope:  ssf.SSFSample_LOCAL,
+			},
+		},
+		{
+			packet: "a.b.c:someone|s|#foo:bar",
+			sample: ssf.SSFSample{
+				Metric:  ssf.SSFSample_SET,
+				Name:    "a.b.c",
+				Message: "someone",
+				Tags:    map[string]string{"foo": "bar"},
+			},
+		},
+	}
+	for _, elt := range tests {
+		test := elt
+		t.Run(test.packet, func(t *testing.T) {
+			t.Parallel()
+			statsd, err := ParseMetric([]byte(test.packet))
+			require.NoError(t, err)
+			fromSSF, err := ParseMetricSSF(&test.sample)
+			require.NoError(t, err)
+			if len(fromSSF.Tags) == 0 {
+				// statsd packets without tags have nil tags
+				fromSSF.Tags = nil
+			}
+			assert.Equal(t, *statsd, fromSSF)
+		})
+	}
+}
+
+func TestParseMetricSSFInvalidSampleRate(t *testing.T) {
+	for _, rate := range []float32{-0.5, 1.5} {
+		sample := ssf.Count("a.b.c", 1, nil)
+		sample.SampleRate = rate
+		_, err := ParseMetricSSF(sample)
+		assert.Error(t, err, "sample rate %f should be invalid", rate)
+	}
+}
+
 func BenchmarkParseMetricSSF(b *testing.B) {
 
 	const LEN = 10000
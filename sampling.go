@@ -0,0 +1,88 @@
+package veneur
+
+import (
+	"sync/atomic"
+
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+)
+
+// traceSampler decides which traces veneur keeps at ingestion. The
+// decision only depends on the trace ID, so all spans of a trace are
+// either kept or dropped together, by every veneur instance.
+type traceSampler struct {
+	// threshold is the sinks.SamplingThreshold of the rate; accessed
+	// atomically, since a reload can change it.
+	threshold uint64
+
+	// the decisions since the last report; accessed atomically.
+	keptTraces    int64
+	droppedTraces int64
+	keptSpans     int64
+	droppedSpans  int64
+}
+
+// newTraceSampler returns a sampler that keeps ratePercent percent of
+// traces.
+func newTraceSampler(ratePercent float64) *traceSampler {
+	ts := &traceSampler{}
+	ts.setRate(ratePercent)
+	return ts
+}
+
+// setRate makes the sampler keep ratePercent percent of traces.
+func (ts *traceSampler) setRate(ratePercent float64) {
+	atomic.StoreUint64(&ts.threshold, sinks.SamplingThreshold(ratePercent))
+}
+
+// keep returns true if the span belongs to a trace that is sampled.
+// Spans that aren't part of a trace (e.g. carriers of SSF samples) and
+// indicator spans, from which veneur derives SLI metrics, are always
+// kept.
+func (ts *traceSampler) keep(span *ssf.SSFSpan) bool {
+	if span.TraceId == 0 || span.Indicator {
+		return true
+	}
+	keep := sinks.TraceSampled(span.TraceId, atomic.LoadUint64(&ts.threshold))
+	root := span.Id == span.TraceId
+	switch {
+	case keep && root:
+		atomic.AddInt64(&ts.keptTraces, 1)
+	case !keep && root:
+		atomic.AddInt64(&ts.droppedTraces, 1)
+	}
+	if keep {
+		atomic.AddInt64(&ts.keptSpans, 1)
+	} else {
+		atomic.AddInt64(&ts.droppedSpans, 1)
+	}
+	return keep
+}
+
+// sampleSpan returns the span that veneur should process in place of
+// span: span itself if its trace is sampled, and otherwise nil, or a
+// span that only carries span's SSF samples, so that their metrics are
+// still extracted.
+func (s *Server) sampleSpan(span *ssf.SSFSpan) *ssf.SSFSpan {
+	if s.traceSampler == nil || s.traceSampler.keep(span) {
+		return span
+	}
+	if len(span.Metrics) == 0 {
+		return nil
+	}
+	return &ssf.SSFSpan{Metrics: span.Metrics}
+}
+
+// reportTraceSampling emits the number of traces (counted by their root
+// spans) and spans that were kept and dropped since the last report,
+// and resets the counts.
+func (s *Server) reportTraceSampling() {
+	ts := s.traceSampler
+	if ts == nil {
+		return
+	}
+	s.Statsd.Count("ssf.sampling.traces_total", atomic.SwapInt64(&ts.keptTraces, 0), []string{"decision:kept"}, 1.0)
+	s.Statsd.Count("ssf.sampling.traces_total", atomic.SwapInt64(&ts.droppedTraces, 0), []string{"decision:dropped"}, 1.0)
+	s.Statsd.Count("ssf.sampling.spans_total", atomic.SwapInt64(&ts.keptSpans, 0), []string{"decision:kept"}, 1.0)
+	s.Statsd.Count("ssf.sampling.spans_total", atomic.SwapInt64(&ts.droppedSpans, 0), []string{"decision:dropped"}, 1.0)
+}
@@ -0,0 +1,47 @@
+package veneur
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/ssf"
+)
+
+func TestTraceSampler(t *testing.T) {
+	ts := newTraceSampler(25)
+	const traces = 10000
+	for id := int64(1); id <= traces; id++ {
+		root := ts.keep(&ssf.SSFSpan{Id: id, TraceId: id})
+		child := ts.keep(&ssf.SSFSpan{Id: id + traces, TraceId: id, ParentId: id})
+		require.Equal(t, root, child, "spans of trace %d should be kept or dropped together", id)
+	}
+
+	kept := atomic.LoadInt64(&ts.keptTraces)
+	assert.Equal(t, int64(traces), kept+atomic.LoadInt64(&ts.droppedTraces))
+	assert.InDelta(t, 0.25, float64(kept)/traces, 0.02)
+	assert.Equal(t, 2*kept, atomic.LoadInt64(&ts.keptSpans))
+
+	assert.True(t, newTraceSampler(100).keep(&ssf.SSFSpan{Id: 1, TraceId: 1}))
+	assert.False(t, newTraceSampler(0).keep(&ssf.SSFSpan{Id: 1, TraceId: 1}))
+	assert.True(t, newTraceSampler(0).keep(&ssf.SSFSpan{Id: 1, TraceId: 1, Indicator: true}),
+		"indicator spans should always be kept")
+	assert.True(t, newTraceSampler(0).keep(&ssf.SSFSpan{Metrics: []*ssf.SSFSample{ssf.Count("a.b.c", 1, nil)}}),
+		"spans that aren't part of a trace should always be kept")
+}
+
+func TestSampleSpan(t *testing.T) {
+	s := &Server{traceSampler: newTraceSampler(0)}
+	span := &ssf.SSFSpan{Id: 1, TraceId: 1, Name: "dropped", Service: "a"}
+	assert.Nil(t, s.sampleSpan(span))
+
+	span.Metrics = []*ssf.SSFSample{ssf.Count("a.b.c", 1, nil)}
+	carrier := s.sampleSpan(span)
+	require.NotNil(t, carrier)
+	assert.Equal(t, span.Metrics, carrier.Metrics, "the samples of a dropped span should be kept")
+	assert.Zero(t, carrier.TraceId, "the dropped span should not be passed on to trace sinks")
+
+	s.traceSampler = nil
+	assert.Equal(t, span, s.sampleSpan(span))
+}
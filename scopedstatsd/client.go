@@ -42,6 +42,10 @@ type ScopedClient struct {
 
 	addTags []string
 	scopes  MetricScopes
+
+	// disabled are the full names (including the inner client's
+	// namespace) of the metrics that aren't sent.
+	disabled map[string]struct{}
 }
 
 var _ Client = &ScopedClient{}
@@ -61,6 +65,9 @@ func (s *ScopedClient) Gauge(name string, value float64, tags []string, rate flo
 	if s == nil {
 		return nil
 	}
+	if s.isDisabled(name) {
+		return nil
+	}
 	tags = append(tags, s.addTags...)
 	tags = addScopeTag(tags, s.scopes.Gauge)
 	return s.client.Gauge(name, value, tags, rate)
@@ -70,6 +77,9 @@ func (s *ScopedClient) Count(name string, value int64, tags []string, rate float
 	if s == nil {
 		return nil
 	}
+	if s.isDisabled(name) {
+		return nil
+	}
 	tags = append(tags, s.addTags...)
 	tags = addScopeTag(tags, s.scopes.Count)
 	return s.client.Count(name, value, tags, rate)
@@ -87,6 +97,9 @@ func (s *ScopedClient) TimeInMilliseconds(name string, value float64, tags []str
 	if s == nil {
 		return nil
 	}
+	if s.isDisabled(name) {
+		return nil
+	}
 	tags = append(tags, s.addTags...)
 	tags = addScopeTag(tags, s.scopes.Histogram)
 	return s.client.TimeInMilliseconds(name, value, tags, rate)
@@ -96,6 +109,9 @@ func (s *ScopedClient) Timing(name string, value time.Duration, tags []string, r
 	if s == nil {
 		return nil
 	}
+	if s.isDisabled(name) {
+		return nil
+	}
 	tags = append(tags, s.addTags...)
 	tags = addScopeTag(tags, s.scopes.Histogram)
 	return s.client.Timing(name, value, tags, rate)
@@ -105,11 +121,38 @@ func (s *ScopedClient) Histogram(name string, value float64, tags []string, rate
 	if s == nil {
 		return nil
 	}
+	if s.isDisabled(name) {
+		return nil
+	}
 	tags = append(tags, s.addTags...)
 	tags = addScopeTag(tags, s.scopes.Histogram)
 	return s.client.Histogram(name, value, tags, rate)
 }
 
+// Disable stops the client from sending the metrics with the given
+// names, which include the inner client's namespace. It must be called
+// before the client is used.
+func (s *ScopedClient) Disable(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	s.disabled = make(map[string]struct{}, len(names))
+	for _, name := range names {
+		s.disabled[name] = struct{}{}
+	}
+}
+
+func (s *ScopedClient) isDisabled(name string) bool {
+	if s.disabled == nil {
+		return false
+	}
+	if s.client != nil {
+		name = s.client.Namespace + name
+	}
+	_, ok := s.disabled[name]
+	return ok
+}
+
 func NewClient(inner *statsd.Client, addTags []string, scopes MetricScopes) *ScopedClient {
 	return &ScopedClient{
 		client:  inner,
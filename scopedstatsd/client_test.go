@@ -2,9 +2,13 @@ package scopedstatsd
 
 import (
 	"errors"
+	"net"
 	"testing"
+	"time"
 
+	"github.com/DataDog/datadog-go/statsd"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEnsure(t *testing.T) {
@@ -55,3 +59,25 @@ func TestDoesSomething(t *testing.T) {
 		})
 	}
 }
+
+func TestDisable(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	inner, err := statsd.New(conn.LocalAddr().String(), statsd.WithoutTelemetry())
+	require.NoError(t, err)
+	inner.Namespace = "veneur."
+	cl := NewClient(inner, nil, MetricScopes{})
+	cl.Disable([]string{"veneur.noisy.gauge"})
+
+	require.NoError(t, cl.Gauge("noisy.gauge", 1, nil, 1.0))
+	require.NoError(t, cl.Gauge("quiet.gauge", 1, nil, 1.0))
+	require.NoError(t, inner.Flush())
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "veneur.quiet.gauge:1|g", string(buf[:n]))
+}
@@ -0,0 +1,35 @@
+package veneur
+
+import (
+	"time"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+// defaultSelfTestMetric is the prefix of the self-test metrics, unless
+// self_test_metric_name sets it.
+const defaultSelfTestMetric = "veneur.self_test"
+
+// ingestSelfTest ingests the self-test metrics, if they're enabled, for
+// the next flush to pass them to sinks along with the others: a counter
+// of 1, named after the prefix with ".count", and a gauge of the time
+// they were ingested, in seconds since the UNIX epoch, with ".timestamp".
+// Both are local-only, so each veneur flushes its own.
+func (s *Server) ingestSelfTest(now time.Time) {
+	if s.selfTestMetric == "" {
+		return
+	}
+	for _, metric := range []samplers.UDPMetric{{
+		MetricKey: samplers.MetricKey{Name: s.selfTestMetric + ".count", Type: counterTypeName},
+		Value:     1.0,
+		Scope:     samplers.LocalOnly,
+	}, {
+		MetricKey: samplers.MetricKey{Name: s.selfTestMetric + ".timestamp", Type: gaugeTypeName},
+		Value:     float64(now.Unix()),
+		Scope:     samplers.LocalOnly,
+	}} {
+		if err := s.Ingest(metric); err != nil {
+			log.WithError(err).WithField("metric", metric.Name).Error("Could not ingest the self-test metric")
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package veneur
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func TestSelfTestMetrics(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := localConfig()
+	config.SelfTestMetricEnabled = true
+	config.SelfTestMetricName = "test.heartbeat"
+	start := time.Now().Unix()
+	server := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer server.Shutdown()
+
+	// the metrics are ingested asynchronously, so each flush may miss
+	// them, and pass them to the next one
+	for i := 0; i < 2; i++ {
+		values := map[string]float64{}
+		require.Eventually(t, func() bool {
+			server.Flush(context.Background())
+			select {
+			case flushed := <-rcv:
+				for _, m := range flushed {
+					values[m.Name] = m.Value
+				}
+			case <-time.After(time.Second):
+			}
+			return len(values) == 2
+		}, 5*time.Second, time.Millisecond)
+
+		assert.Equal(t, 1.0, values["test.heartbeat.count"], "flush %d", i)
+		assert.True(t, values["test.heartbeat.timestamp"] >= float64(start), "flush %d", i)
+		assert.True(t, values["test.heartbeat.timestamp"] <= float64(time.Now().Unix()), "flush %d", i)
+	}
+}
+
+func TestSelfTestMetricsDefaultName(t *testing.T) {
+	config := localConfig()
+	config.SelfTestMetricEnabled = true
+	server, err := NewFromConfig(logrus.New(), config)
+	require.NoError(t, err)
+	assert.Equal(t, defaultSelfTestMetric, server.selfTestMetric)
+
+	config.SelfTestMetricEnabled = false
+	config.SelfTestMetricName = "test.heartbeat"
+	server, err = NewFromConfig(logrus.New(), config)
+	require.NoError(t, err)
+	assert.Empty(t, server.selfTestMetric, "the self-test metrics should be disabled")
+}
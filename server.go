@@ -10,9 +10,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
+	"path"
 	"reflect"
+	"regexp"
 	"runtime"
 	rtdebug "runtime/debug"
 	"strings"
@@ -32,9 +35,11 @@ import (
 	"github.com/zenazn/goji/bind"
 	"github.com/zenazn/goji/graceful"
 	"google.golang.org/grpc"
+	grpccredentials "google.golang.org/grpc/credentials"
 
 	"github.com/pkg/profile"
 
+	"github.com/stripe/veneur/v14/capture"
 	vhttp "github.com/stripe/veneur/v14/http"
 	"github.com/stripe/veneur/v14/importsrv"
 	"github.com/stripe/veneur/v14/plugins"
@@ -44,17 +49,23 @@ import (
 	"github.com/stripe/veneur/v14/samplers"
 	"github.com/stripe/veneur/v14/scopedstatsd"
 	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/sinks/csvfile"
 	"github.com/stripe/veneur/v14/sinks/datadog"
+	"github.com/stripe/veneur/v14/sinks/deadletter"
 	"github.com/stripe/veneur/v14/sinks/debug"
 	"github.com/stripe/veneur/v14/sinks/falconer"
+	"github.com/stripe/veneur/v14/sinks/jaeger"
 	"github.com/stripe/veneur/v14/sinks/kafka"
 	"github.com/stripe/veneur/v14/sinks/lightstep"
 	"github.com/stripe/veneur/v14/sinks/newrelic"
+	"github.com/stripe/veneur/v14/sinks/otlp"
 	"github.com/stripe/veneur/v14/sinks/prometheus"
 	"github.com/stripe/veneur/v14/sinks/signalfx"
 	"github.com/stripe/veneur/v14/sinks/splunk"
 	"github.com/stripe/veneur/v14/sinks/ssfmetrics"
+	"github.com/stripe/veneur/v14/sinks/wavefront"
 	"github.com/stripe/veneur/v14/sinks/xray"
+	"github.com/stripe/veneur/v14/sinks/zipkin"
 	"github.com/stripe/veneur/v14/ssf"
 	"github.com/stripe/veneur/v14/trace"
 	"github.com/stripe/veneur/v14/trace/metrics"
@@ -96,33 +107,181 @@ type Server struct {
 	Tags      []string
 	TagsAsMap map[string]string
 
+	// OnStart, OnFlush and OnShutdown are hooks for programs that embed
+	// veneur, which are called if they're set. OnStart is called first
+	// thing in Start, before the sinks are started and the listeners are
+	// opened, so it can add sinks with AddMetricSink and AddSpanSink.
+	// OnFlush is called in its own goroutine with the metrics of each
+	// flush that has any, including those of flush groups, while the
+	// metric sinks flush them: it must not modify them. The flushes
+	// don't wait for it, so calls can overlap if it's slow. OnShutdown
+	// is called last thing in Shutdown.
+	OnStart    func(s *Server)
+	OnFlush    func(ctx context.Context, metrics []samplers.InterMetric)
+	OnShutdown func(s *Server)
+
+	// reloadable holds the options that Reload can change while the
+	// server runs. The flushes apply the pending ones, and the fast
+	// flushes read them concurrently, so both are guarded by reloadMtx.
+	reloadMtx     sync.RWMutex
+	reloadable    reloadableConfig
+	pendingReload *reloadableConfig
+	// config is the configuration the server was started with, to tell
+	// which options changed when it's reloaded.
+	config Config
+	// reloadsOnSIGHUP is set by ReloadOnSIGHUP, so that HTTPServe leaves
+	// SIGHUP to it rather than shutting down on it.
+	reloadsOnSIGHUP bool
+
 	HTTPClient *http.Client
 
 	HTTPAddr         string
 	numListeningHTTP *int32 // An atomic boolean for whether or not the HTTP server is running
-
-	ForwardAddr    string
-	forwardUseGRPC bool
+	// httpListenAddr is the address HTTPServe bound, guarded by
+	// httpListenAddrMtx.
+	httpListenAddrMtx sync.Mutex
+	httpListenAddr    net.Addr
+
+	ForwardAddr     string
+	ForwardGRPCAddr string
+	forwardUseGRPC  bool
+	// the content encoding of metrics forwarded over HTTP
+	forwardEncoding string
+	// metricSinkFlushTimeout is how long to wait for each metric sink to
+	// flush, unless metricSinkFlushTimeouts overrides it for the sink.
+	metricSinkFlushTimeout  time.Duration
+	metricSinkFlushTimeouts map[string]time.Duration
+
+	// distributionsEnabled is set if DogStatsD distributions are kept as
+	// distributions; otherwise they're histograms.
+	distributionsEnabled bool
+	// distributionModes overrides how metric sinks receive
+	// distributions: "distribution", "histogram" or "drop".
+	distributionModes map[string]string
+
+	// counterModes overrides whether metric sinks receive counters as
+	// counts or as rates, by sink name.
+	counterModes map[string]string
+
+	// deadLetter stores the metrics that metric sinks failed to flush,
+	// if a dead-letter directory is configured.
+	deadLetter *deadletter.Sink
+
+	// shadowMetricSinks are the names of the metric sinks that are being
+	// tried out: they're flushed to apart from the others, and their
+	// failures don't count as failed flushes.
+	shadowMetricSinks map[string]bool
 
 	StatsdListenAddrs []net.Addr
 	SSFListenAddrs    []net.Addr
 	GRPCListenAddrs   []net.Addr
+	JSONListenAddrs   []net.Addr
 	RcvbufBytes       int
+	rcvbufAutosize    bool
+	// udpRateLimiter limits the rate of UDP statsd packets per
+	// source IP; nil if unlimited.
+	udpRateLimiter *sourceRateLimiter
+	// udpCapture records the UDP statsd packets as they're read, if
+	// udp_capture_file is set; udpCaptureStopped logs when it stops.
+	udpCapture        *capture.Writer
+	udpCaptureStopped sync.Once
+	// selfTestMetric is the prefix of the self-test metrics that are
+	// ingested every interval, if self_test_metric_enabled is set.
+	selfTestMetric string
+	// rejectLog logs the lines that fail to parse, up to a rate.
+	rejectLog *rejectLogger
+	// httpIngest enables the HTTP ingestion endpoint, which requires
+	// httpIngestAuthToken as a bearer token if it's set, and is
+	// limited per client IP by httpIngestRateLimiter if that's set.
+	httpIngest             bool
+	httpIngestAuthToken    string
+	httpIngestMaxBodyBytes int64
+	httpIngestRateLimiter  *sourceRateLimiter
+	// effectiveRcvbufBytes is the smallest receive buffer granted
+	// to any UDP socket; accessed atomically.
+	effectiveRcvbufBytes int64
+	// ingestionListeners are the statsd and SSF listeners that the
+	// server is configured with.
+	ingestionListeners []*ingestionListener
+	// listenerStats counts the traffic of each listener.
+	listenerStats listenerStatsRegistry
 
 	interval            time.Duration
 	synchronizeInterval bool
-	numReaders          int
-	metricMaxLength     int
-	traceMaxLengthBytes int
-
-	tlsConfig      *tls.Config
-	tcpReadTimeout time.Duration
+	flushJitter         *flushJitter
+	// holdPartialWindow delays the first synchronized flush to the
+	// second boundary, rather than flushing the partial window before
+	// the first one.
+	holdPartialWindow bool
+	// flushIntervals are the intervals of the flush groups, which flush
+	// the metrics that fast_flush_metrics and metric_flush_intervals
+	// assign to them apart from the others.
+	flushIntervals []time.Duration
+	numReaders     int
+	// the bounds of the number of readers of each UDP address, and how
+	// often it's adjusted; if adaptiveReadersMax is 0, it's numReaders.
+	adaptiveReadersMin      int
+	adaptiveReadersMax      int
+	adaptiveReadersInterval time.Duration
+	metricMaxLength         int
+	traceMaxLengthBytes     int
+	ssfMaxFrameLength       uint32
+
+	// metricNames, if set, checks the names of the metrics that clients
+	// send, and sanitizeMetricNames decides whether the metrics with
+	// invalid names are sanitized or dropped.
+	metricNames         *samplers.NameValidator
+	sanitizeMetricNames bool
+
+	// cardinality, if set, caps the number of series of each metric
+	// name in a flush interval. There's one limiter shared by all the
+	// workers, or one for each worker when metrics are sharded by name.
+	cardinality []*cardinalityLimiter
+
+	// typeCollisions, if set, detects the metrics that arrive as more
+	// than one type, shared or sharded like cardinality.
+	typeCollisions []*typeCollisions
+
+	// shardByName makes workerFor shard metrics by name rather than by
+	// series.
+	shardByName bool
+
+	tlsConfig        *tls.Config
+	tcpReadTimeout   time.Duration
+	tcpDrainTimeout  time.Duration
+	tcpProxyProtocol bool
+
+	// tcpMaxConnections, if positive, caps the connections each TCP
+	// statsd address has open at once, and tcpMaxConnectionsAction is
+	// what happens to the new connections past the cap.
+	tcpMaxConnections       int
+	tcpMaxConnectionsAction string
+
+	tlsClientTagKey    string
+	tlsClientTagSource string
+
+	// certReloader, if set, serves the TLS certificate from the files in
+	// tls_certificate_file and tls_key_file, and checks them for changes
+	// every tlsReloadInterval.
+	certReloader      *certReloader
+	tlsReloadInterval time.Duration
+
+	// ocspStapler, if set, staples OCSP responses to the TLS certificate.
+	ocspStapler *ocspStapler
+
+	// containerIDTag controls whether metrics are tagged with the
+	// container ID that DogStatsD clients send.
+	containerIDTag bool
+	// maxBackfillAge is how old the DogStatsD timestamps of counters and
+	// gauges can be; if it's 0, timestamps are ignored.
+	maxBackfillAge time.Duration
 
 	// closed when the server is shutting down gracefully
 	shutdown chan struct{}
 	httpQuit bool
 
 	HistogramPercentiles []float64
+	percentileOverrides  []percentileOverride
 
 	plugins   []plugins.Plugin
 	pluginMtx sync.Mutex
@@ -131,11 +290,30 @@ type Server struct {
 
 	HistogramAggregates samplers.HistogramAggregates
 
+	// metricUnitTag tags the metrics computed from timers with their
+	// unit.
+	metricUnitTag bool
+
 	spanSinks   []sinks.SpanSink
 	metricSinks []sinks.MetricSink
 
+	// spanBatchers are the span sinks that send spans on in batches of
+	// their own, which are drained on shutdown.
+	spanBatchers []*sinks.BatchingSpanSink
+
 	TraceClient *trace.Client
 
+	// traceSampler samples SSF traces at ingestion; nil if all traces
+	// are kept.
+	traceSampler *traceSampler
+	// spanSamplers are the span sinks that sample spans by their
+	// operation name.
+	spanSamplers []*sinks.SpanSamplingSink
+
+	// spanValidator rejects invalid SSF spans at ingestion; nil if they
+	// aren't validated.
+	spanValidator *spanValidator
+
 	ssfInternalMetrics          sync.Map
 	listeningPerProtocolMetrics *GlobalListeningPerProtocolMetrics
 
@@ -144,10 +322,16 @@ type Server struct {
 	grpcServer        *importsrv.Server
 
 	// gRPC forward clients
-	grpcForwardConn *grpc.ClientConn
+	grpcForwardConn   *grpc.ClientConn
+	grpcForwardStream *forwardStream
 
 	stuckIntervals int
 	lastFlushUnix  int64
+
+	enableSoftnetStats bool
+	// enableUDPSocketStats reports the drops of the UDP listeners'
+	// sockets on each flush.
+	enableUDPSocketStats bool
 }
 
 type GlobalListeningPerProtocolMetrics struct {
@@ -155,6 +339,7 @@ type GlobalListeningPerProtocolMetrics struct {
 	dogstatsdUdpReceivedTotal  int64
 	dogstatsdUnixReceivedTotal int64
 	dogstatsdGrpcReceivedTotal int64
+	dogstatsdHttpReceivedTotal int64
 
 	ssfUnixReceivedTotal int64
 	ssfUdpReceivedTotal  int64
@@ -171,6 +356,8 @@ const (
 	SSF_UNIX
 	SSF_UDP
 	SSF_GRPC
+	DOGSTATSD_STDIN
+	DOGSTATSD_HTTP
 )
 
 func (p ProtocolType) String() string {
@@ -182,6 +369,8 @@ func (p ProtocolType) String() string {
 		"ssf-unix",
 		"ssf-udp",
 		"ssf-grpc",
+		"dogstatsd-stdin",
+		"dogstatsd-http",
 	}[p]
 }
 
@@ -275,6 +464,19 @@ func normalizeSpans(conf Config) trace.ClientParam {
 	}
 }
 
+// dropDisabledSamples drops the samples that veneur reports about its
+// own operation whose names are in veneur_metrics_disabled.
+func dropDisabledSamples(conf Config) trace.ClientParam {
+	disabled := make(map[string]struct{}, len(conf.VeneurMetricsDisabled))
+	for _, name := range conf.VeneurMetricsDisabled {
+		disabled[name] = struct{}{}
+	}
+	return trace.DropSamples(func(sample *ssf.SSFSample) bool {
+		_, ok := disabled[sample.Name]
+		return ok
+	})
+}
+
 func scopesFromConfig(conf Config) (scopedstatsd.MetricScopes, error) {
 	var err error
 	var ms scopedstatsd.MetricScopes
@@ -293,6 +495,45 @@ func scopesFromConfig(conf Config) (scopedstatsd.MetricScopes, error) {
 	return ms, nil
 }
 
+// kafkaAuthFromConfig returns how the Kafka sinks authenticate to the
+// brokers, checking that SASL has credentials and that the TLS
+// certificates parse.
+func kafkaAuthFromConfig(conf Config) (kafka.Auth, error) {
+	auth := kafka.Auth{
+		SASLMechanism: conf.KafkaSaslMechanism,
+		SASLUser:      conf.KafkaSaslUser,
+		SASLPassword:  conf.KafkaSaslPassword,
+	}
+	if err := auth.Validate(); err != nil {
+		return auth, fmt.Errorf("kafka_sasl_mechanism: %v", err)
+	}
+
+	if !conf.KafkaTLSEnabled {
+		if conf.KafkaTLSAuthorityCertificate != "" || conf.KafkaTLSCertificate != "" || conf.KafkaTLSKey != "" {
+			return auth, errors.New("kafka_tls_enabled must be set to use the kafka_tls_* certificates")
+		}
+		return auth, nil
+	}
+	auth.TLS = &tls.Config{}
+	if conf.KafkaTLSAuthorityCertificate != "" {
+		auth.TLS.RootCAs = x509.NewCertPool()
+		if !auth.TLS.RootCAs.AppendCertsFromPEM([]byte(conf.KafkaTLSAuthorityCertificate)) {
+			return auth, errors.New("kafka_tls_authority_certificate: could not parse PEM certificate")
+		}
+	}
+	if (conf.KafkaTLSCertificate == "") != (conf.KafkaTLSKey == "") {
+		return auth, errors.New("kafka_tls_certificate and kafka_tls_key must be set together")
+	}
+	if conf.KafkaTLSCertificate != "" {
+		cert, err := tls.X509KeyPair([]byte(conf.KafkaTLSCertificate), []byte(conf.KafkaTLSKey))
+		if err != nil {
+			return auth, fmt.Errorf("kafka_tls_certificate: %v", err)
+		}
+		auth.TLS.Certificates = []tls.Certificate{cert}
+	}
+	return auth, nil
+}
+
 // NewFromConfig creates a new veneur server from a configuration
 // specification and sets up the passed logger according to the
 // configuration.
@@ -305,22 +546,135 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	mappedTags := samplers.ParseTagSliceToMap(ret.Tags)
 
 	ret.synchronizeInterval = conf.SynchronizeWithInterval
+	ret.holdPartialWindow = conf.SynchronizeHoldPartialWindow
 
 	ret.TagsAsMap = mappedTags
+	ret.config = conf
 	ret.HistogramPercentiles = conf.Percentiles
+	for i, override := range conf.PercentileOverrides {
+		if _, err := path.Match(override.Metric, ""); err != nil {
+			return ret, fmt.Errorf("percentile_overrides[%d]: invalid metric pattern %q: %v", i, override.Metric, err)
+		}
+		ret.percentileOverrides = append(ret.percentileOverrides, percentileOverride{
+			pattern:     override.Metric,
+			percentiles: override.Percentiles,
+		})
+	}
+	for i, pattern := range conf.HistogramAggregatesOnly {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return ret, fmt.Errorf("histogram_aggregates_only[%d]: invalid metric pattern %q: %v", i, pattern, err)
+		}
+	}
+	if conf.SetMaxCardinality < 0 {
+		return ret, fmt.Errorf("set_max_cardinality must not be negative, got %d", conf.SetMaxCardinality)
+	}
+	var setCardinalityOverrides []setCardinalityOverride
+	for i, override := range conf.SetMaxCardinalityOverrides {
+		if _, err := path.Match(override.Metric, ""); err != nil {
+			return ret, fmt.Errorf("set_max_cardinality_overrides[%d]: invalid metric pattern %q: %v", i, override.Metric, err)
+		}
+		if override.MaxCardinality < 0 {
+			return ret, fmt.Errorf("set_max_cardinality_overrides[%d]: max_cardinality must not be negative, got %d", i, override.MaxCardinality)
+		}
+		setCardinalityOverrides = append(setCardinalityOverrides, setCardinalityOverride{
+			pattern: override.Metric,
+			max:     uint64(override.MaxCardinality),
+		})
+	}
+	var reservoirs []histogramReservoir
+	for i, reservoir := range conf.HistogramReservoirs {
+		if _, err := path.Match(reservoir.Metric, ""); err != nil {
+			return ret, fmt.Errorf("histogram_reservoirs[%d]: invalid metric pattern %q: %v", i, reservoir.Metric, err)
+		}
+		if reservoir.Size <= 0 {
+			return ret, fmt.Errorf("histogram_reservoirs[%d]: size must be positive, got %d", i, reservoir.Size)
+		}
+		reservoirs = append(reservoirs, histogramReservoir{
+			pattern: reservoir.Metric,
+			size:    reservoir.Size,
+		})
+	}
+	var valueBounds []valueBound
+	for i, bound := range conf.MetricValueBounds {
+		if _, err := path.Match(bound.Metric, ""); err != nil {
+			return ret, fmt.Errorf("metric_value_bounds[%d]: invalid metric pattern %q: %v", i, bound.Metric, err)
+		}
+		if bound.Min == nil && bound.Max == nil {
+			return ret, fmt.Errorf("metric_value_bounds[%d]: min or max must be set", i)
+		}
+		vb := valueBound{pattern: bound.Metric, min: math.Inf(-1), max: math.Inf(1)}
+		if bound.Min != nil {
+			vb.min = *bound.Min
+		}
+		if bound.Max != nil {
+			vb.max = *bound.Max
+		}
+		if math.IsNaN(vb.min) || math.IsNaN(vb.max) || vb.min > vb.max {
+			return ret, fmt.Errorf("metric_value_bounds[%d]: min (%v) must not be greater than max (%v)", i, vb.min, vb.max)
+		}
+		switch bound.Action {
+		case "", "drop":
+			vb.drop = true
+		case "clamp":
+		default:
+			return ret, fmt.Errorf("metric_value_bounds[%d]: action %q is neither \"drop\" nor \"clamp\"", i, bound.Action)
+		}
+		valueBounds = append(valueBounds, vb)
+	}
+	var nonNegative []nonNegativeMetric
+	for i, rule := range conf.NonNegativeMetrics {
+		if _, err := path.Match(rule.Metric, ""); err != nil {
+			return ret, fmt.Errorf("non_negative_metrics[%d]: invalid metric pattern %q: %v", i, rule.Metric, err)
+		}
+		nn := nonNegativeMetric{pattern: rule.Metric}
+		switch rule.Action {
+		case "", "drop":
+			nn.drop = true
+		case "log":
+		default:
+			return ret, fmt.Errorf("non_negative_metrics[%d]: action %q is neither \"drop\" nor \"log\"", i, rule.Action)
+		}
+		nonNegative = append(nonNegative, nn)
+	}
 	ret.HistogramAggregates.Value = 0
 	for _, agg := range conf.Aggregates {
 		ret.HistogramAggregates.Value += samplers.AggregatesLookup[agg]
 	}
 	ret.HistogramAggregates.Count = len(conf.Aggregates)
+	ret.metricUnitTag = conf.MetricUnitTag
 
 	var err error
 	ret.interval, err = conf.ParseInterval()
 	if err != nil {
 		return ret, err
 	}
+	ret.reloadable, err = parseReloadableConfig(conf)
+	if err != nil {
+		return ret, err
+	}
+
+	if conf.FlushJitter != "" {
+		maxJitter, err := time.ParseDuration(conf.FlushJitter)
+		if err != nil {
+			return ret, fmt.Errorf("invalid flush_jitter: %v", err)
+		}
+		if maxJitter >= ret.interval {
+			return ret, fmt.Errorf("flush_jitter (%v) must be shorter than the interval (%v)", maxJitter, ret.interval)
+		}
+		if maxJitter > 0 {
+			ret.flushJitter = newFlushJitter(maxJitter, conf.Hostname, conf.FlushJitterDeterministic)
+		}
+	}
+
+	var flushGroupPatterns []flushGroupPattern
+	ret.flushIntervals, flushGroupPatterns, err = parseFlushGroups(conf, ret.interval)
+	if err != nil {
+		return ret, err
+	}
 
 	ret.stuckIntervals = conf.FlushWatchdogMissedFlushes
+	ret.enableSoftnetStats = conf.EnableSoftnetStats
+	ret.enableUDPSocketStats = conf.EnableUDPSocketStats
 
 	transport := &http.Transport{
 		IdleConnTimeout: ret.interval * 2, // If we're idle more than one interval something is up
@@ -342,12 +696,15 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	if err != nil {
 		return ret, err
 	}
-	ret.Statsd = scopedstatsd.NewClient(stats, conf.VeneurMetricsAdditionalTags, scopes)
+	scopedStats := scopedstatsd.NewClient(stats, conf.VeneurMetricsAdditionalTags, scopes)
+	scopedStats.Disable(conf.VeneurMetricsDisabled)
+	ret.Statsd = scopedStats
 
 	ret.SpanChan = make(chan *ssf.SSFSpan, conf.SpanChannelCapacity)
 	ret.TraceClient, err = trace.NewChannelClient(ret.SpanChan,
 		trace.ReportStatistics(stats, 1*time.Second, []string{"ssf_format:internal"}),
 		normalizeSpans(conf),
+		dropDisabledSamples(conf),
 	)
 	if err != nil {
 		return ret, err
@@ -367,6 +724,20 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	if conf.Debug {
 		logger.SetLevel(logrus.DebugLevel)
 	}
+	switch conf.LogFormat {
+	case "", "text":
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return ret, fmt.Errorf("log_format must be text or json, got %q", conf.LogFormat)
+	}
+	if conf.ParseErrorLogsPerSecond < 0 {
+		return ret, fmt.Errorf("parse_error_logs_per_second must not be negative, got %d", conf.ParseErrorLogsPerSecond)
+	}
+	ret.rejectLog = newRejectLogger(defaultRejectedLinesPerSecond)
+	if conf.ParseErrorLogsPerSecond > 0 {
+		ret.rejectLog = newRejectLogger(conf.ParseErrorLogsPerSecond)
+	}
 
 	mpf := 0
 	if conf.MutexProfileFraction > 0 {
@@ -414,20 +785,160 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	logger.WithField("number", numWorkers).Info("Preparing workers")
 	// Allocate the slice, we'll fill it with workers later.
 	ret.Workers = make([]*Worker, numWorkers)
-	ret.numReaders = conf.NumReaders
+	ret.numReaders = conf.NumReaders.Count()
+	if conf.NumReaders == AutoReaders {
+		logger.WithField("number", ret.numReaders).Info("Detected the number of readers from GOMAXPROCS")
+	}
+	if conf.NumReadersAdaptiveMax > 0 {
+		ret.adaptiveReadersMin = conf.NumReadersAdaptiveMin
+		if ret.adaptiveReadersMin == 0 {
+			ret.adaptiveReadersMin = 1
+		}
+		ret.adaptiveReadersMax = conf.NumReadersAdaptiveMax
+		if ret.adaptiveReadersMin < 0 || ret.adaptiveReadersMin > ret.adaptiveReadersMax {
+			return ret, fmt.Errorf("num_readers_adaptive_min (%d) must be between 1 and num_readers_adaptive_max (%d)", ret.adaptiveReadersMin, ret.adaptiveReadersMax)
+		}
+		ret.adaptiveReadersInterval = 10 * time.Second
+		if conf.NumReadersAdaptiveInterval != "" {
+			ret.adaptiveReadersInterval, err = time.ParseDuration(conf.NumReadersAdaptiveInterval)
+			if err != nil {
+				return ret, fmt.Errorf("invalid num_readers_adaptive_interval: %v", err)
+			}
+			if ret.adaptiveReadersInterval <= 0 {
+				return ret, fmt.Errorf("num_readers_adaptive_interval must be positive, got %v", ret.adaptiveReadersInterval)
+			}
+		}
+	}
 
 	// This must come before worker initialization. We need to
 	// initialize workers with state from *Server.IsWorker.
 	ret.ForwardAddr = conf.ForwardAddress
+	ret.ForwardGRPCAddr = conf.ForwardGrpcAddress
+	ret.forwardEncoding = vhttp.EncodingDeflate
+	if conf.ForwardCompression != "" {
+		if err := vhttp.ValidateEncoding(conf.ForwardCompression); err != nil {
+			return ret, fmt.Errorf("invalid forward_compression: %v", err)
+		}
+		ret.forwardEncoding = conf.ForwardCompression
+	}
+
+	if conf.MetricSinkFlushTimeout != "" {
+		ret.metricSinkFlushTimeout, err = time.ParseDuration(conf.MetricSinkFlushTimeout)
+		if err != nil {
+			return ret, fmt.Errorf("invalid metric_sink_flush_timeout: %v", err)
+		}
+	}
+	ret.distributionsEnabled = conf.DistributionsEnabled
+	for i, override := range conf.DistributionSinkOverrides {
+		switch override.Mode {
+		case distributionModeDistribution, distributionModeHistogram, distributionModeDrop:
+		default:
+			return ret, fmt.Errorf("distribution_sink_overrides[%d]: %q is not \"distribution\", \"histogram\" or \"drop\"", i, override.Mode)
+		}
+		if ret.distributionModes == nil {
+			ret.distributionModes = map[string]string{}
+		}
+		ret.distributionModes[override.Sink] = override.Mode
+	}
+
+	for i, override := range conf.CounterSinkOverrides {
+		switch override.Mode {
+		case counterModeCount, counterModeRate:
+		default:
+			return ret, fmt.Errorf("counter_sink_overrides[%d]: %q is neither \"count\" nor \"rate\"", i, override.Mode)
+		}
+		if ret.counterModes == nil {
+			ret.counterModes = map[string]string{}
+		}
+		ret.counterModes[override.Sink] = override.Mode
+	}
+
+	for i, override := range conf.MetricSinkFlushTimeoutOverrides {
+		timeout, err := time.ParseDuration(override.Timeout)
+		if err != nil {
+			return ret, fmt.Errorf("metric_sink_flush_timeout_overrides[%d]: invalid timeout: %v", i, err)
+		}
+		if ret.metricSinkFlushTimeouts == nil {
+			ret.metricSinkFlushTimeouts = map[string]time.Duration{}
+		}
+		ret.metricSinkFlushTimeouts[override.Sink] = timeout
+	}
+
+	if conf.DeadLetterDirectory != "" {
+		ret.deadLetter, err = deadletter.New(conf.DeadLetterDirectory, logger)
+		if err != nil {
+			return ret, fmt.Errorf("could not set up the dead-letter directory: %v", err)
+		}
+	}
 
 	// Control whether Veneur should emit metric
 	// "veneur.flush.unique_timeseries_total", which may come at a
 	// slight performance hit to workers.
 	ret.CountUniqueTimeseries = conf.CountUniqueTimeseries
 
+	if conf.GaugeTTLIntervals < 0 {
+		return ret, fmt.Errorf("gauge_ttl_intervals must not be negative, got %d", conf.GaugeTTLIntervals)
+	}
+
+	if conf.IngestQueueSize < 0 {
+		return ret, fmt.Errorf("ingest_queue_size must not be negative, got %d", conf.IngestQueueSize)
+	}
+
+	if conf.MetricCardinalityLimit < 0 {
+		return ret, fmt.Errorf("metric_cardinality_limit must not be negative, got %d", conf.MetricCardinalityLimit)
+	}
+	switch conf.WorkerSharding {
+	case "", shardBySeries:
+	case shardByName:
+		ret.shardByName = true
+	default:
+		return ret, fmt.Errorf("worker_sharding must be %q or %q, got %q", shardBySeries, shardByName, conf.WorkerSharding)
+	}
+
+	if conf.MetricCardinalityLimit > 0 {
+		limiters := 1
+		if ret.shardByName {
+			limiters = len(ret.Workers)
+		}
+		for i := 0; i < limiters; i++ {
+			ret.cardinality = append(ret.cardinality, newCardinalityLimiter(conf.MetricCardinalityLimit))
+		}
+	}
+
+	switch conf.MetricTypeCollisions {
+	case "":
+	case typeCollisionsKeep, typeCollisionsDrop:
+		detectors := 1
+		if ret.shardByName {
+			detectors = len(ret.Workers)
+		}
+		for i := 0; i < detectors; i++ {
+			ret.typeCollisions = append(ret.typeCollisions, newTypeCollisions(conf.MetricTypeCollisions == typeCollisionsDrop))
+		}
+	default:
+		return ret, fmt.Errorf("metric_type_collisions must be %q or %q, got %q", typeCollisionsKeep, typeCollisionsDrop, conf.MetricTypeCollisions)
+	}
+
 	// Use the pre-allocated Workers slice to know how many to start.
 	for i := range ret.Workers {
 		ret.Workers[i] = NewWorker(i+1, ret.IsLocal(), ret.CountUniqueTimeseries, ret.TraceClient, log, ret.Statsd)
+		ret.Workers[i].aggregatesOnly = conf.HistogramAggregatesOnly
+		ret.Workers[i].reservoirs = reservoirs
+		ret.Workers[i].setFlushGroups(flushGroupPatterns, len(ret.flushIntervals))
+		ret.Workers[i].setMaxCardinality = uint64(conf.SetMaxCardinality)
+		ret.Workers[i].setCardinalityOverrides = setCardinalityOverrides
+		ret.Workers[i].valueBounds = valueBounds
+		ret.Workers[i].nonNegative = nonNegative
+		if ret.cardinality != nil {
+			ret.Workers[i].cardinality = ret.cardinality[i%len(ret.cardinality)]
+		}
+		if ret.typeCollisions != nil {
+			ret.Workers[i].typeCollisions = ret.typeCollisions[i%len(ret.typeCollisions)]
+		}
+		ret.Workers[i].gaugeTTL = conf.GaugeTTLIntervals
+		if conf.IngestQueueSize > 0 {
+			ret.Workers[i].setQueueSize(conf.IngestQueueSize)
+		}
 		// do not close over loop index
 		go func(w *Worker) {
 			defer func() {
@@ -445,7 +956,26 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	for i, w := range ret.Workers {
 		processors[i] = w
 	}
-	metricSink, err := ssfmetrics.NewMetricExtractionSink(processors, conf.IndicatorSpanTimerName, conf.ObjectiveSpanTimerName, ret.TraceClient, log)
+	if ret.shardByName {
+		// the sink shards by series, so it gets a single processor
+		// that shards by name instead
+		processors = []ssfmetrics.Processor{workerRouter{ret}}
+	}
+	if conf.SpanMetricsMaxOperations < 0 {
+		return ret, fmt.Errorf("span_metrics_max_operations must not be negative, got %d", conf.SpanMetricsMaxOperations)
+	}
+	if conf.SpanMetricsMaxTagValues < 0 {
+		return ret, fmt.Errorf("span_metrics_max_tag_values must not be negative, got %d", conf.SpanMetricsMaxTagValues)
+	}
+	spanMetrics := ssfmetrics.SpanMetrics{
+		DurationTimerName: conf.SpanDurationTimerName,
+		CountCounterName:  conf.SpanCountCounterName,
+		ErrorCounterName:  conf.SpanErrorCounterName,
+		Tags:              conf.SpanMetricsTags,
+		MaxOperations:     conf.SpanMetricsMaxOperations,
+		MaxTagValues:      conf.SpanMetricsMaxTagValues,
+	}
+	metricSink, err := ssfmetrics.NewMetricExtractionSink(processors, conf.IndicatorSpanTimerName, conf.ObjectiveSpanTimerName, spanMetrics, ret.TraceClient, log)
 	if err != nil {
 		return ret, err
 	}
@@ -466,6 +996,12 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 		}
 		ret.SSFListenAddrs = append(ret.SSFListenAddrs, addr)
 	}
+	for _, addr := range ret.StatsdListenAddrs {
+		ret.ingestionListeners = append(ret.ingestionListeners, newIngestionListener("statsd", addr))
+	}
+	for _, addr := range ret.SSFListenAddrs {
+		ret.ingestionListeners = append(ret.ingestionListeners, newIngestionListener("ssf", addr))
+	}
 
 	for _, addrStr := range conf.GrpcListenAddresses {
 		addr, err := protocol.ResolveAddr(addrStr)
@@ -475,26 +1011,197 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 		ret.GRPCListenAddrs = append(ret.GRPCListenAddrs, addr)
 	}
 
+	for _, addrStr := range conf.JSONListenAddresses {
+		addr, err := protocol.ResolveAddr(addrStr)
+		if err != nil {
+			return ret, err
+		}
+		ret.JSONListenAddrs = append(ret.JSONListenAddrs, addr)
+	}
+
 	ret.metricMaxLength = conf.MetricMaxLength
+	if ret.metricMaxLength < 0 {
+		return ret, fmt.Errorf("metric_max_length must not be negative, got %d", conf.MetricMaxLength)
+	}
+	if ret.metricMaxLength == 0 {
+		ret.metricMaxLength = defaultConfig.MetricMaxLength
+	}
+	for _, addr := range ret.StatsdListenAddrs {
+		if _, ok := addr.(*net.UDPAddr); ok && ret.metricMaxLength > maxUDPDatagramSize {
+			log.WithFields(logrus.Fields{
+				"metric_max_length": ret.metricMaxLength,
+				"max_datagram_size": maxUDPDatagramSize,
+				"address":           addr,
+			}).Warn("metric_max_length is larger than any UDP datagram can be; UDP packets can't use the entire buffer")
+			break
+		}
+	}
+	if conf.MetricNameMaxLength < 0 {
+		return ret, fmt.Errorf("metric_name_max_length must not be negative, got %d", conf.MetricNameMaxLength)
+	}
+	if conf.MetricNameAllowedCharacters != "" || conf.MetricNameMaxLength != 0 {
+		ret.metricNames, err = samplers.NewNameValidator(conf.MetricNameAllowedCharacters, conf.MetricNameMaxLength)
+		if err != nil {
+			return ret, fmt.Errorf("metric_name_allowed_characters: %v", err)
+		}
+		switch conf.MetricNameInvalidAction {
+		case "", "drop":
+		case "sanitize":
+			ret.sanitizeMetricNames = true
+		default:
+			return ret, fmt.Errorf("metric_name_invalid_action: %q is neither \"drop\" nor \"sanitize\"", conf.MetricNameInvalidAction)
+		}
+	}
 	ret.traceMaxLengthBytes = conf.TraceMaxLengthBytes
+	if traceSamplingEnabled(conf) {
+		ret.traceSampler = newTraceSampler(conf.SsfTraceSampleRatePercent)
+	}
+	if conf.SsfInvalidSpanLogsPerSecond < 0 {
+		return ret, fmt.Errorf("ssf_invalid_span_logs_per_second must not be negative, got %d", conf.SsfInvalidSpanLogsPerSecond)
+	}
+	if conf.SsfValidateSpans {
+		ret.spanValidator = &spanValidator{}
+		if conf.SsfInvalidSpanLogsPerSecond > 0 {
+			ret.spanValidator.rejectLog = newRejectLogger(conf.SsfInvalidSpanLogsPerSecond)
+		}
+	}
+	ret.ssfMaxFrameLength = conf.SsfMaxFrameLengthBytes
+	if ret.ssfMaxFrameLength == 0 || ret.ssfMaxFrameLength > protocol.MaxSSFPacketLength {
+		ret.ssfMaxFrameLength = protocol.MaxSSFPacketLength
+	}
 	ret.RcvbufBytes = conf.ReadBufferSizeBytes
-	ret.HTTPAddr = conf.HTTPAddress
-	ret.numListeningHTTP = new(int32)
-
-	if conf.TLSKey != "" {
-		if conf.TLSCertificate == "" {
-			err = errors.New("tls_key is set; must set tls_certificate")
-			logger.WithError(err).Error("Improper TLS configuration")
+	ret.rcvbufAutosize = conf.ReadBufferSizeAutosize
+	if conf.UDPRateLimitPacketsPerSecond > 0 {
+		burst := conf.UDPRateLimitBurst
+		if burst == 0 {
+			burst = conf.UDPRateLimitPacketsPerSecond
+		}
+		ret.udpRateLimiter = newSourceRateLimiter("UDP packets", float64(conf.UDPRateLimitPacketsPerSecond), burst)
+	}
+	if conf.SelfTestMetricEnabled {
+		ret.selfTestMetric = conf.SelfTestMetricName
+		if ret.selfTestMetric == "" {
+			ret.selfTestMetric = defaultSelfTestMetric
+		}
+	}
+	if conf.UDPCaptureFile != "" {
+		if conf.UDPCaptureMaxBytes < 0 {
+			return ret, fmt.Errorf("udp_capture_max_bytes must not be negative, got %d", conf.UDPCaptureMaxBytes)
+		}
+		ret.udpCapture, err = capture.Create(conf.UDPCaptureFile, conf.UDPCaptureMaxBytes)
+		if err != nil {
+			return ret, fmt.Errorf("could not create the UDP capture file: %v", err)
+		}
+		logger.WithField("file", conf.UDPCaptureFile).Warn("Capturing the UDP statsd packets")
+	}
+	if conf.HTTPIngestEnabled {
+		ret.httpIngest = true
+		ret.httpIngestAuthToken = conf.HTTPIngestAuthToken
+		if conf.HTTPIngestMaxBodyBytes < 0 {
+			return ret, fmt.Errorf("http_ingest_max_body_bytes must not be negative, got %d", conf.HTTPIngestMaxBodyBytes)
+		}
+		ret.httpIngestMaxBodyBytes = int64(conf.HTTPIngestMaxBodyBytes)
+		if ret.httpIngestMaxBodyBytes == 0 {
+			ret.httpIngestMaxBodyBytes = defaultHTTPIngestMaxBodyBytes
+		}
+		if conf.HTTPIngestRequestsPerSecond > 0 {
+			burst := conf.HTTPIngestBurst
+			if burst == 0 {
+				burst = conf.HTTPIngestRequestsPerSecond
+			}
+			ret.httpIngestRateLimiter = newSourceRateLimiter("HTTP ingestion requests", float64(conf.HTTPIngestRequestsPerSecond), burst)
+		}
+		if ret.httpIngestAuthToken == "" {
+			logger.Warn("The HTTP ingestion endpoint is enabled without authentication")
+		}
+	}
+	if conf.TCPReadTimeout != "" {
+		ret.tcpReadTimeout, err = time.ParseDuration(conf.TCPReadTimeout)
+		if err != nil {
 			return ret, err
 		}
-
-		// load the TLS key and certificate
-		var cert tls.Certificate
-		cert, err = tls.X509KeyPair([]byte(conf.TLSCertificate), []byte(conf.TLSKey))
+		switch {
+		case ret.tcpReadTimeout < 0:
+			return ret, fmt.Errorf("tcp_read_timeout must not be negative, got %v", ret.tcpReadTimeout)
+		case ret.tcpReadTimeout == 0:
+			// connections can stay idle forever
+			ret.tcpReadTimeout = -1
+		}
+	}
+	if conf.TCPMaxConnections < 0 {
+		return ret, fmt.Errorf("tcp_max_connections must not be negative, got %d", conf.TCPMaxConnections)
+	}
+	switch conf.TCPMaxConnectionsAction {
+	case "", tcpLimitReject, tcpLimitWait:
+	default:
+		return ret, fmt.Errorf("tcp_max_connections_action must be %q or %q, got %q", tcpLimitReject, tcpLimitWait, conf.TCPMaxConnectionsAction)
+	}
+	ret.tcpMaxConnections = conf.TCPMaxConnections
+	ret.tcpMaxConnectionsAction = conf.TCPMaxConnectionsAction
+	if conf.TCPDrainTimeout != "" {
+		ret.tcpDrainTimeout, err = time.ParseDuration(conf.TCPDrainTimeout)
 		if err != nil {
-			logger.WithError(err).Error("Improper TLS configuration")
 			return ret, err
 		}
+	}
+	ret.tcpProxyProtocol = conf.TCPProxyProtocol
+	ret.containerIDTag = conf.DogstatsdContainerIDTag
+	if conf.DogstatsdMaxBackfillAge != "" {
+		ret.maxBackfillAge, err = time.ParseDuration(conf.DogstatsdMaxBackfillAge)
+		if err != nil {
+			return ret, err
+		}
+	}
+	ret.HTTPAddr = conf.HTTPAddress
+	ret.numListeningHTTP = new(int32)
+
+	if (conf.TLSKeyFile == "") != (conf.TLSCertificateFile == "") {
+		err = errors.New("tls_key_file and tls_certificate_file must be set together")
+		logger.WithError(err).Error("Improper TLS configuration")
+		return ret, err
+	}
+	if conf.TLSKeyFile != "" && conf.TLSKey != "" {
+		err = errors.New("tls_key_file is set; must not set tls_key")
+		logger.WithError(err).Error("Improper TLS configuration")
+		return ret, err
+	}
+
+	if conf.TLSKey != "" || conf.TLSKeyFile != "" {
+		var certs []tls.Certificate
+		if conf.TLSKeyFile != "" {
+			// load the TLS key and certificate from their files,
+			// and again each time they change
+			ret.certReloader, err = newCertReloader(conf.TLSCertificateFile, conf.TLSKeyFile)
+			if err != nil {
+				logger.WithError(err).Error("Improper TLS configuration")
+				return ret, err
+			}
+			ret.tlsReloadInterval = defaultTLSReloadInterval
+			if conf.TLSReloadInterval != "" {
+				ret.tlsReloadInterval, err = time.ParseDuration(conf.TLSReloadInterval)
+				if err != nil {
+					return ret, err
+				}
+				if ret.tlsReloadInterval <= 0 {
+					return ret, fmt.Errorf("tls_reload_interval must be positive, got %v", ret.tlsReloadInterval)
+				}
+			}
+		} else {
+			if conf.TLSCertificate == "" {
+				err = errors.New("tls_key is set; must set tls_certificate")
+				logger.WithError(err).Error("Improper TLS configuration")
+				return ret, err
+			}
+
+			// load the TLS key and certificate
+			var cert tls.Certificate
+			cert, err = tls.X509KeyPair([]byte(conf.TLSCertificate), []byte(conf.TLSKey))
+			if err != nil {
+				logger.WithError(err).Error("Improper TLS configuration")
+				return ret, err
+			}
+			certs = []tls.Certificate{cert}
+		}
 
 		clientAuthMode := tls.NoClientCert
 		var clientCAs *x509.CertPool
@@ -510,11 +1217,58 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 			}
 		}
 
+		var minVersion uint16
+		minVersion, err = parseTLSMinVersion(conf.TLSMinVersion)
+		if err != nil {
+			logger.WithError(err).Error("Improper TLS configuration")
+			return ret, err
+		}
+		var cipherSuites []uint16
+		cipherSuites, err = parseCipherSuites(conf.TLSCipherSuites)
+		if err != nil {
+			logger.WithError(err).Error("Improper TLS configuration")
+			return ret, err
+		}
+
 		ret.tlsConfig = &tls.Config{
-			Certificates: []tls.Certificate{cert},
+			Certificates: certs,
 			ClientAuth:   clientAuthMode,
 			ClientCAs:    clientCAs,
+			MinVersion:   minVersion,
+			CipherSuites: cipherSuites,
+		}
+		if ret.certReloader != nil {
+			ret.tlsConfig.GetCertificate = ret.certReloader.getCertificate
 		}
+
+		if conf.TLSOCSPStapling || conf.TLSOCSPResponseFile != "" {
+			getCertificate := ret.tlsConfig.GetCertificate
+			if getCertificate == nil {
+				cert := &certs[0]
+				getCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+					return cert, nil
+				}
+			}
+			ret.ocspStapler = newOCSPStapler(conf.TLSOCSPResponseFile, getCertificate)
+			// crypto/tls only calls GetCertificate for handshakes
+			// without SNI if there are no Certificates
+			ret.tlsConfig.Certificates = nil
+			ret.tlsConfig.GetCertificate = ret.ocspStapler.certificate
+		}
+	} else if conf.TLSOCSPStapling || conf.TLSOCSPResponseFile != "" {
+		err = errors.New("tls_ocsp_stapling and tls_ocsp_response_file need a TLS certificate")
+		logger.WithError(err).Error("Improper TLS configuration")
+		return ret, err
+	}
+
+	switch conf.TLSClientTagSource {
+	case "", "cn", "dns_san", "email_san", "uri_san":
+		ret.tlsClientTagKey = conf.TLSClientTagKey
+		ret.tlsClientTagSource = conf.TLSClientTagSource
+	default:
+		err = fmt.Errorf("tls_client_tag_source: unknown certificate field %q", conf.TLSClientTagSource)
+		logger.WithError(err).Error("Improper TLS configuration")
+		return ret, err
 	}
 
 	if conf.SignalfxAPIKey != "" {
@@ -541,6 +1295,7 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 		if err != nil {
 			return ret, err
 		}
+		sfxSink.SetDimensionAllowlist(conf.SignalfxDimensionAllowlist)
 		ret.metricSinks = append(ret.metricSinks, sfxSink)
 	}
 
@@ -574,6 +1329,23 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 		if err != nil {
 			return ret, err
 		}
+
+		if len(conf.DatadogMetricNameRenames) > 0 {
+			renames := make([]datadog.MetricNameRename, 0, len(conf.DatadogMetricNameRenames))
+			for i, rename := range conf.DatadogMetricNameRenames {
+				pattern, err := regexp.Compile(rename.Pattern)
+				if err != nil {
+					err = fmt.Errorf("datadog_metric_name_renames[%d]: %v", i, err)
+					logger.WithError(err).Error("Invalid Datadog metric rename rule")
+					return ret, err
+				}
+				renames = append(renames, datadog.MetricNameRename{
+					Pattern:     pattern,
+					Replacement: rename.Replacement,
+				})
+			}
+			ddSink.SetMetricNameRenames(renames, conf.DatadogMetricNameRenamesDryRun)
+		}
 		ret.metricSinks = append(ret.metricSinks, ddSink)
 	}
 
@@ -706,6 +1478,32 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 			logger.Info("Configured Falconer trace sink")
 		}
 
+		if conf.JaegerCollectorAddress != "" {
+			jaegerSink, err := jaeger.NewSpanSink(
+				conf.JaegerCollectorAddress, conf.JaegerBatchSize,
+				conf.JaegerSpanBufferSize, ret.TagsAsMap, ret.HTTPClient, log,
+			)
+			if err != nil {
+				return ret, fmt.Errorf("jaeger_collector_address: %v", err)
+			}
+
+			ret.spanSinks = append(ret.spanSinks, jaegerSink)
+			logger.WithField("address", conf.JaegerCollectorAddress).Info("Configured Jaeger span sink")
+		}
+
+		if conf.ZipkinAddress != "" {
+			zipkinSink, err := zipkin.NewSpanSink(
+				conf.ZipkinAddress, conf.ZipkinBatchSize,
+				conf.ZipkinSpanBufferSize, ret.TagsAsMap, ret.HTTPClient, log,
+			)
+			if err != nil {
+				return ret, fmt.Errorf("zipkin_address: %v", err)
+			}
+
+			ret.spanSinks = append(ret.spanSinks, zipkinSink)
+			logger.WithField("address", conf.ZipkinAddress).Info("Configured Zipkin span sink")
+		}
+
 		// Set up as many span workers as we need:
 		ret.SpanWorkerGoroutines = 1
 		if conf.NumSpanWorkers > 0 {
@@ -714,17 +1512,37 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	}
 
 	if conf.KafkaBroker != "" {
+		kafkaAuth, err := kafkaAuthFromConfig(conf)
+		if err != nil {
+			logger.WithError(err).Error("Improper Kafka configuration")
+			return ret, err
+		}
+
 		if conf.KafkaMetricTopic != "" || conf.KafkaCheckTopic != "" || conf.KafkaEventTopic != "" {
 			kSink, err := kafka.NewKafkaMetricSink(
 				log, ret.TraceClient, conf.KafkaBroker, conf.KafkaCheckTopic, conf.KafkaEventTopic,
 				conf.KafkaMetricTopic, conf.KafkaMetricRequireAcks,
-				conf.KafkaPartitioner, conf.KafkaRetryMax,
+				conf.KafkaPartitioner, conf.KafkaMetricPartitionFields, conf.KafkaRetryMax,
 				conf.KafkaMetricBufferBytes, conf.KafkaMetricBufferMessages,
 				conf.KafkaMetricBufferFrequency,
 			)
 			if err != nil {
 				return ret, err
 			}
+			if err := kSink.SetAuth(kafkaAuth); err != nil {
+				return ret, err
+			}
+			if conf.KafkaMetricConfirmDelivery {
+				if conf.KafkaMetricDeliveryRetries < 0 {
+					return ret, fmt.Errorf("kafka_metric_delivery_retries must not be negative, got %d", conf.KafkaMetricDeliveryRetries)
+				}
+				kSink.ConfirmDelivery(conf.KafkaMetricDeliveryRetries)
+			}
+			if conf.KafkaMetricIdempotent {
+				if err := kSink.Idempotent(); err != nil {
+					return ret, fmt.Errorf("kafka_metric_idempotent: %v", err)
+				}
+			}
 
 			ret.metricSinks = append(ret.metricSinks, kSink)
 
@@ -735,7 +1553,7 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 
 		if conf.KafkaSpanTopic != "" {
 			sink, err := kafka.NewKafkaSpanSink(log, ret.TraceClient, conf.KafkaBroker, conf.KafkaSpanTopic,
-				conf.KafkaPartitioner, conf.KafkaMetricRequireAcks, conf.KafkaRetryMax,
+				conf.KafkaSpanPartitioner, conf.KafkaSpanRequireAcks, conf.KafkaRetryMax,
 				conf.KafkaSpanBufferBytes, conf.KafkaSpanBufferMesages,
 				conf.KafkaSpanBufferFrequency, conf.KafkaSpanSerializationFormat,
 				conf.KafkaSpanSampleTag, conf.KafkaSpanSampleRatePercent,
@@ -743,6 +1561,9 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 			if err != nil {
 				return ret, err
 			}
+			if err := sink.SetAuth(kafkaAuth); err != nil {
+				return ret, err
+			}
 
 			ret.spanSinks = append(ret.spanSinks, sink)
 			logger.Info("Configured Kafka span sink")
@@ -765,6 +1586,92 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 		logger.Info("Configured Prometheus metric sink.")
 	}
 
+	if conf.PrometheusRemoteWriteAddress != "" {
+		remoteWriteSink, err := prometheus.NewRemoteWriteSink(
+			conf.PrometheusRemoteWriteAddress,
+			conf.PrometheusRemoteWriteBatchSize,
+			ret.HTTPClient,
+			log,
+		)
+		if err != nil {
+			return ret, err
+		}
+
+		ret.metricSinks = append(ret.metricSinks, remoteWriteSink)
+		logger.Info("Configured Prometheus remote write metric sink.")
+	}
+
+	if conf.OtlpAddress != "" {
+		headers := map[string]string{}
+		for _, header := range conf.OtlpHeaders {
+			kv := strings.SplitN(header, ":", 2)
+			if len(kv) != 2 {
+				return ret, fmt.Errorf("otlp_headers: %q is not of the form \"name:value\"", header)
+			}
+			headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+
+		creds := grpc.WithInsecure()
+		if !conf.OtlpInsecure {
+			tlsConfig := &tls.Config{}
+			if conf.OtlpTLSAuthorityCertificate != "" {
+				tlsConfig.RootCAs = x509.NewCertPool()
+				if !tlsConfig.RootCAs.AppendCertsFromPEM([]byte(conf.OtlpTLSAuthorityCertificate)) {
+					return ret, errors.New("otlp_tls_authority_certificate: could not parse PEM certificate")
+				}
+			}
+			creds = grpc.WithTransportCredentials(grpccredentials.NewTLS(tlsConfig))
+		}
+
+		otlpSink, err := otlp.NewMetricSink(conf.OtlpAddress, ret.interval, conf.Hostname, headers, log, creds)
+		if err != nil {
+			return ret, err
+		}
+
+		ret.metricSinks = append(ret.metricSinks, otlpSink)
+		logger.WithFields(logrus.Fields{
+			"address":  conf.OtlpAddress,
+			"insecure": conf.OtlpInsecure,
+		}).Info("Configured OTLP metric sink.")
+	}
+
+	if conf.CsvFileDirectory != "" {
+		csvConfig := csvfile.Config{
+			Directory: conf.CsvFileDirectory,
+			MaxSize:   conf.CsvFileMaxSizeBytes,
+			Buffer:    conf.CsvFileBufferSize,
+		}
+		switch conf.CsvFileFormat {
+		case "", "csv":
+			csvConfig.Delimiter = ','
+		case "tsv":
+			csvConfig.Delimiter = '\t'
+		default:
+			return ret, fmt.Errorf("csv_file_format: %q is neither \"csv\" nor \"tsv\"", conf.CsvFileFormat)
+		}
+		if conf.CsvFileMaxAge != "" {
+			csvConfig.MaxAge, err = time.ParseDuration(conf.CsvFileMaxAge)
+			if err != nil {
+				return ret, fmt.Errorf("csv_file_max_age: %v", err)
+			}
+		}
+		csvSink, err := csvfile.NewMetricSink(csvConfig, log)
+		if err != nil {
+			return ret, err
+		}
+		ret.metricSinks = append(ret.metricSinks, csvSink)
+		logger.WithField("directory", conf.CsvFileDirectory).Info("Configured CSV file metric sink.")
+	}
+
+	if conf.WavefrontAddress != "" {
+		wavefrontSink, err := wavefront.NewMetricSink(conf.WavefrontAddress, conf.Hostname, log)
+		if err != nil {
+			return ret, fmt.Errorf("invalid wavefront_address: %v", err)
+		}
+		ret.metricSinks = append(ret.metricSinks, wavefrontSink)
+		logger.WithField("address", conf.WavefrontAddress).Info("Configured Wavefront metric sink.")
+	}
+
 	{
 		mtx := sync.Mutex{}
 		if conf.DebugFlushedMetrics {
@@ -779,6 +1686,77 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 
 	// After all sinks are initialized, set the list of tags to exclude
 	setSinkExcludedTags(conf.TagsExclude, ret.metricSinks, ret.spanSinks)
+	ret.metricSinks = filterSinkTags(conf.MetricSinkTagsAllow, conf.MetricSinkTagsDeny, ret.metricSinks)
+	// the names in metric_sinks_disabled and metric_sinks_shadow are
+	// checked by Start, once programs that embed veneur added their sinks
+	if len(conf.MetricSinksShadow) > 0 {
+		ret.shadowMetricSinks = map[string]bool{}
+		for _, name := range conf.MetricSinksShadow {
+			ret.shadowMetricSinks[name] = true
+		}
+	}
+	if conf.SpanBatchMaxSpans != 0 || conf.SpanBatchMaxAge != "" {
+		batching := sinks.SpanBatching{
+			MaxSpans:   conf.SpanBatchMaxSpans,
+			MaxAge:     ret.interval,
+			BufferSize: conf.SpanBatchBufferSize,
+		}
+		if batching.MaxSpans < 0 {
+			return ret, fmt.Errorf("span_batch_max_spans must not be negative, got %d", batching.MaxSpans)
+		}
+		if batching.MaxSpans == 0 {
+			batching.MaxSpans = defaultSpanBatchMaxSpans
+		}
+		if conf.SpanBatchMaxAge != "" {
+			batching.MaxAge, err = time.ParseDuration(conf.SpanBatchMaxAge)
+			if err != nil {
+				return ret, fmt.Errorf("span_batch_max_age: %v", err)
+			}
+			if batching.MaxAge <= 0 {
+				return ret, fmt.Errorf("span_batch_max_age must be positive, got %v", batching.MaxAge)
+			}
+		}
+		if batching.BufferSize == 0 {
+			batching.BufferSize = defaultSpanBatchBufferSize
+		}
+		if batching.BufferSize < batching.MaxSpans {
+			return ret, fmt.Errorf("span_batch_buffer_size (%d) must be at least span_batch_max_spans (%d)", batching.BufferSize, batching.MaxSpans)
+		}
+		ret.spanSinks, ret.spanBatchers = batchSpanSinks(batching, ret.spanSinks)
+	}
+	if spanSamplingEnabled(conf) {
+		ret.spanSinks, ret.spanSamplers = sampleSpanSinks(ret.reloadable.spanSamplingRules, ret.reloadable.spanSamplingDefaultRatePercent, ret.spanSinks)
+	}
+	if conf.SsfTailSamplingDecisionWait != "" {
+		tailSampling := sinks.TailSampling{
+			RatePercent: conf.SsfTailSamplingRatePercent,
+			MaxSpans:    conf.SsfTailSamplingMaxSpans,
+		}
+		var err error
+		tailSampling.DecisionWait, err = time.ParseDuration(conf.SsfTailSamplingDecisionWait)
+		if err != nil {
+			return ret, fmt.Errorf("ssf_tail_sampling_decision_wait: %v", err)
+		}
+		if tailSampling.DecisionWait <= 0 {
+			return ret, fmt.Errorf("ssf_tail_sampling_decision_wait must be positive, got %v", tailSampling.DecisionWait)
+		}
+		if conf.SsfTailSamplingLatencyThreshold != "" {
+			tailSampling.LatencyThreshold, err = time.ParseDuration(conf.SsfTailSamplingLatencyThreshold)
+			if err != nil {
+				return ret, fmt.Errorf("ssf_tail_sampling_latency_threshold: %v", err)
+			}
+		}
+		if tailSampling.RatePercent < 0 || tailSampling.RatePercent > 100 {
+			return ret, fmt.Errorf("ssf_tail_sampling_rate_percent must be between 0 and 100, got %v", tailSampling.RatePercent)
+		}
+		if tailSampling.MaxSpans < 0 {
+			return ret, fmt.Errorf("ssf_tail_sampling_max_spans must not be negative, got %d", tailSampling.MaxSpans)
+		}
+		if tailSampling.MaxSpans == 0 {
+			tailSampling.MaxSpans = defaultTailSamplingMaxSpans
+		}
+		ret.spanSinks = tailSampleSpanSinks(tailSampling, ret.spanSinks)
+	}
 
 	var svc s3iface.S3API
 	awsID := conf.AwsAccessKeyID
@@ -869,6 +1847,7 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 			dogstatsdUdpReceivedTotal:  0,
 			dogstatsdUnixReceivedTotal: 0,
 			dogstatsdGrpcReceivedTotal: 0,
+			dogstatsdHttpReceivedTotal: 0,
 			ssfUdpReceivedTotal:        0,
 			ssfUnixReceivedTotal:       0,
 			ssfGrpcReceivedTotal:       0,
@@ -886,6 +1865,16 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 func (s *Server) Start() {
 	log.WithField("version", VERSION).Info("Starting server")
 
+	if s.OnStart != nil {
+		s.OnStart(s)
+	}
+	if err := s.checkMetricSinkNames("metric_sinks_disabled", s.config.MetricSinksDisabled); err != nil {
+		logrus.WithError(err).Fatal("Invalid metric sink configuration")
+	}
+	if err := s.checkMetricSinkNames("metric_sinks_shadow", s.config.MetricSinksShadow); err != nil {
+		logrus.WithError(err).Fatal("Invalid metric sink configuration")
+	}
+
 	// Set up the processors for spans:
 
 	// Use the pre-allocated Workers slice to know how many to start.
@@ -910,7 +1899,9 @@ func (s *Server) Start() {
 	}
 
 	statsdPool := &sync.Pool{
-		// We +1 this so we an "detect" when someone sends us too long of a metric!
+		// We +1 this so we an "detect" when someone sends us too long of a
+		// metric! Datagrams that fill the entire buffer were most likely
+		// truncated by the kernel, and are counted and dropped.
 		New: func() interface{} {
 			return make([]byte, s.metricMaxLength+1)
 		},
@@ -930,6 +1921,9 @@ func (s *Server) Start() {
 	}
 
 	for _, sink := range s.metricSinks {
+		if cr, ok := sinks.UnwrapMetricSink(sink).(sinks.CounterRateMetricSink); ok && s.counterMode(sink) == counterModeCount {
+			cr.SendCountersAsCounts()
+		}
 		logrus.WithField("sink", sink.Name()).Info("Starting metric sink")
 		if err := sink.Start(s.TraceClient); err != nil {
 			logrus.WithError(err).WithField("sink", sink).Fatal("Error starting metric sink")
@@ -943,6 +1937,14 @@ func (s *Server) Start() {
 	}
 	s.StatsdListenAddrs = concreteAddrs
 
+	if len(s.JSONListenAddrs) > 0 {
+		concreteAddrs := make([]net.Addr, 0, len(s.JSONListenAddrs))
+		for _, addr := range s.JSONListenAddrs {
+			concreteAddrs = append(concreteAddrs, StartJSONLines(s, addr, statsdPool))
+		}
+		s.JSONListenAddrs = concreteAddrs
+	}
+
 	// Read Traces Forever!
 	if len(s.SSFListenAddrs) > 0 {
 		concreteAddrs := make([]net.Addr, 0, len(s.StatsdListenAddrs))
@@ -978,7 +1980,16 @@ func (s *Server) Start() {
 	}
 
 	// Initialize a gRPC connection for forwarding
-	if s.forwardUseGRPC {
+	if s.ForwardGRPCAddr != "" {
+		var err error
+		s.grpcForwardConn, err = grpc.Dial(s.ForwardGRPCAddr, grpc.WithInsecure())
+		if err != nil {
+			log.WithError(err).WithFields(logrus.Fields{
+				"forwardGRPCAddr": s.ForwardGRPCAddr,
+			}).Fatal("Failed to initialize a gRPC connection for forwarding")
+		}
+		s.grpcForwardStream = newForwardStream(s.grpcForwardConn)
+	} else if s.forwardUseGRPC {
 		var err error
 		s.grpcForwardConn, err = grpc.Dial(s.ForwardAddr, grpc.WithInsecure())
 		if err != nil {
@@ -988,44 +1999,81 @@ func (s *Server) Start() {
 		}
 	}
 
+	go s.SoftnetStatCollector()
+
+	if s.certReloader != nil {
+		go s.certReloader.watch(s.tlsReloadInterval, s.shutdown)
+	}
+	if s.ocspStapler != nil {
+		s.refreshOCSP(time.Now())
+		go s.watchOCSP()
+	}
+
+	// The self-test metrics are ingested again after each flush
+	s.ingestSelfTest(time.Now())
+
 	// Flush every Interval forever!
+	go s.flushLoop(s.interval, s.Flush)
+
+	// and each flush group on its own interval
+	for i, interval := range s.flushIntervals {
+		group := i
+		go s.flushLoop(interval, func(ctx context.Context) {
+			s.flushGroup(ctx, group)
+		})
+	}
+}
+
+// flushLoop calls flush every interval, until the server shuts down.
+// Each flush is scheduled on the next tick after it's done, so a slow
+// flush skips ticks rather than pile them up, and the ticks never drift.
+func (s *Server) flushLoop(interval time.Duration, flush func(context.Context)) {
+	defer func() {
+		ConsumePanic(s.TraceClient, s.Hostname, recover())
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	go func() {
-		defer func() {
-			ConsumePanic(s.TraceClient, s.Hostname, recover())
-		}()
+		// If the server is shutting down, cancel any in-flight flush:
+		<-s.shutdown
+		cancel()
+	}()
 
-		ctx, cancel := context.WithCancel(context.Background())
-		go func() {
-			// If the server is shutting down, cancel any in-flight flush:
-			<-s.shutdown
+	start := time.Now()
+	delay := flushDelay(interval, s.synchronizeInterval, start, start)
+	if s.synchronizeInterval && s.holdPartialWindow {
+		// the first flush takes the partial window along with the first
+		// whole one, so it covers up to two intervals, and the
+		// per-second rates of its counters spike once
+		delay += interval
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-s.shutdown:
+			// stop flushing on graceful shutdown
+			return
+		case triggered := <-timer.C:
+			ctx, cancel := context.WithDeadline(ctx, triggered.Add(interval))
+			flush(ctx)
 			cancel()
-		}()
-
-		if s.synchronizeInterval {
-			// We want to align our ticker to a multiple of its duration for
-			// convenience of bucketing.
-			<-time.After(CalculateTickDelay(s.interval, time.Now()))
+			timer.Reset(flushDelay(interval, s.synchronizeInterval, start, time.Now()))
 		}
+	}
+}
 
-		// We aligned the ticker to our interval above. It's worth noting that just
-		// because we aligned once we're not guaranteed to be perfect on each
-		// subsequent tick. This code is small, however, and should service the
-		// incoming tick signal fast enough that the amount we are "off" is
-		// negligible.
-		ticker := time.NewTicker(s.interval)
-		for {
-			select {
-			case <-s.shutdown:
-				// stop flushing on graceful shutdown
-				ticker.Stop()
-				return
-			case triggered := <-ticker.C:
-				ctx, cancel := context.WithDeadline(ctx, triggered.Add(s.interval))
-				s.Flush(ctx)
-				cancel()
-			}
-		}
-	}()
+// flushDelay returns how long it is from now to the next flush. If
+// synchronized is set, flushes happen on the multiples of interval since
+// the epoch, so that the flush windows of every veneur line up, and stay
+// lined up across restarts. Otherwise, they happen every interval from
+// start.
+func flushDelay(interval time.Duration, synchronized bool, start, now time.Time) time.Duration {
+	if synchronized {
+		return CalculateTickDelay(interval, now)
+	}
+	return interval - now.Sub(start)%interval
 }
 
 // FlushWatchdog periodically checks that at most
@@ -1033,6 +2081,9 @@ func (s *Server) Start() {
 // than that number was skipped, it panics (assuming that flushing is
 // stuck) with a full level of detail on that panic's backtraces.
 //
+// It only starts counting from the first flush, which
+// synchronize_hold_partial_window can delay by up to two intervals.
+//
 // It never terminates, so is ideally run from a goroutine in a
 // program's main function.
 func (s *Server) FlushWatchdog() {
@@ -1044,7 +2095,6 @@ func (s *Server) FlushWatchdog() {
 		// No watchdog needed:
 		return
 	}
-	atomic.StoreInt64(&s.lastFlushUnix, time.Now().UnixNano())
 
 	ticker := time.NewTicker(s.interval)
 	for {
@@ -1053,7 +2103,12 @@ func (s *Server) FlushWatchdog() {
 			ticker.Stop()
 			return
 		case <-ticker.C:
-			last := time.Unix(0, atomic.LoadInt64(&s.lastFlushUnix))
+			lastFlush := atomic.LoadInt64(&s.lastFlushUnix)
+			if lastFlush == 0 {
+				// no flush has started yet
+				continue
+			}
+			last := time.Unix(0, lastFlush)
 			since := time.Since(last)
 
 			// If no flush was kicked off in the last N
@@ -1091,6 +2146,10 @@ func incrementListeningProtocol(s *Server, protocol ProtocolType) {
 			atomic.AddInt64(&metricsStruct.ssfUnixReceivedTotal, 1)
 		case SSF_GRPC:
 			atomic.AddInt64(&metricsStruct.ssfGrpcReceivedTotal, 1)
+		case DOGSTATSD_HTTP:
+			atomic.AddInt64(&metricsStruct.dogstatsdHttpReceivedTotal, 1)
+		case DOGSTATSD_STDIN:
+			// not a listener, see ReadMetrics
 		default: //If it is an unrecognized protocol then don't increment anything
 			logrus.WithField("protocol", protocol).
 				Warning("Attempted to increment metrics for unrecognized protocol")
@@ -1101,6 +2160,12 @@ func incrementListeningProtocol(s *Server, protocol ProtocolType) {
 // HandleMetricPacket processes each packet that is sent to the server, and sends to an
 // appropriate worker (EventWorker or Worker).
 func (s *Server) HandleMetricPacket(packet []byte, protocolType ProtocolType) error {
+	return s.handleMetricPacket(packet, protocolType, nil, nil)
+}
+
+// handleMetricPacket works like HandleMetricPacket, and additionally
+// adds extraTags to every metric in the packet.
+func (s *Server) handleMetricPacket(packet []byte, protocolType ProtocolType, extraTags []string, source net.Addr) error {
 	// This is a very performance-sensitive function
 	// and packets may be dropped if it gets slowed down.
 	// Keep that in mind when modifying!
@@ -1120,40 +2185,156 @@ func (s *Server) HandleMetricPacket(packet []byte, protocolType ProtocolType) er
 	if bytes.HasPrefix(packet, []byte{'_', 'e', '{'}) {
 		event, err := samplers.ParseEvent(packet)
 		if err != nil {
-			log.WithFields(logrus.Fields{
-				logrus.ErrorKey: err,
-				"packet":        string(packet),
-			}).Warn("Could not parse packet")
-			samples.Add(ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "event", "reason": "parse"}))
+			samples.Add(s.rejectLine(packet, source, "event", err))
 			return err
 		}
 		s.EventWorker.sampleChan <- *event
 	} else if bytes.HasPrefix(packet, []byte{'_', 's', 'c'}) {
 		svcheck, err := samplers.ParseServiceCheck(packet)
 		if err != nil {
-			log.WithFields(logrus.Fields{
-				logrus.ErrorKey: err,
-				"packet":        string(packet),
-			}).Warn("Could not parse packet")
-			samples.Add(ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "service_check", "reason": "parse"}))
+			samples.Add(s.rejectLine(packet, source, "service_check", err))
 			return err
 		}
-		s.Workers[svcheck.Digest%uint32(len(s.Workers))].PacketChan <- *svcheck
+		s.workerFor(svcheck).IngestUDP(*svcheck)
 	} else {
 		metric, err := samplers.ParseMetric(packet)
 		if err != nil {
-			log.WithFields(logrus.Fields{
-				logrus.ErrorKey: err,
-				"packet":        string(packet),
-			}).Warn("Could not parse packet")
-			samples.Add(ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "metric", "reason": "parse"}))
+			samples.Add(s.rejectLine(packet, source, "metric", err))
 			return err
 		}
-		s.Workers[metric.Digest%uint32(len(s.Workers))].PacketChan <- *metric
+		if !s.checkMetricName(metric, "metric") {
+			return nil
+		}
+		s.checkDistribution(metric)
+		metric.AddTags(extraTags...)
+		if s.containerIDTag && metric.ContainerID != "" {
+			metric.AddTags("container_id:" + metric.ContainerID)
+		}
+		if metric.Timestamp != 0 && !s.backfill(metric, time.Now()) {
+			samples.Add(ssf.Count("packet.backfill_dropped_total", 1, map[string]string{"type": metric.Type}))
+			return nil
+		}
+		s.workerFor(metric).IngestUDP(*metric)
+	}
+	return nil
+}
+
+// AddMetricSink adds a metric sink to the ones that the configuration set
+// up, for programs that embed veneur. It must be called before Start, or
+// from OnStart; Start starts the sink with the others. The sink is
+// flushed like the others, so its name can be used in options like
+// metric_sinks_disabled and counter_sink_overrides.
+func (s *Server) AddMetricSink(sink sinks.MetricSink) {
+	s.metricSinks = append(s.metricSinks, sink)
+}
+
+// AddSpanSink adds a span sink to the ones that the configuration set up,
+// for programs that embed veneur. Like AddMetricSink, it must be called
+// before Start, or from OnStart.
+func (s *Server) AddSpanSink(sink sinks.SpanSink) {
+	s.spanSinks = append(s.spanSinks, sink)
+}
+
+// Ingest aggregates a metric that was built in-process rather than parsed
+// from a packet, for programs that embed veneur. The metric only needs its
+// Name, Type, Value, Tags and Scope set, and its SampleRate, which is 1
+// if it's zero: Ingest sets its key and digest from them. Magic tags like
+// veneurglobalonly have no effect; the Scope is used as given. The metric's
+// name is checked and its timestamp handled like a packet's would be.
+//
+// The Value must be a float64, except for sets, whose value is a string,
+// and status checks, whose value is an ssf.SSFSample_Status. Ingest
+// returns an error if the metric can't be aggregated.
+//
+// Ingest is safe to call from multiple goroutines once the server is
+// created, and until it's shut down. Like the network listeners, it
+// blocks while the worker that aggregates the metric is busy, unless
+// ingest_queue_size is set, in which case the metric is dropped.
+func (s *Server) Ingest(metric samplers.UDPMetric) error {
+	if metric.Name == "" {
+		return errors.New("the metric has no name")
+	}
+	var ok bool
+	switch metric.Type {
+	case counterTypeName, gaugeTypeName, histogramTypeName, timerTypeName, distributionTypeName:
+		_, ok = metric.Value.(float64)
+	case setTypeName:
+		_, ok = metric.Value.(string)
+	case statusTypeName:
+		_, ok = metric.Value.(ssf.SSFSample_Status)
+	default:
+		return fmt.Errorf("unknown metric type %q", metric.Type)
+	}
+	if !ok {
+		return fmt.Errorf("the value of a %s can't be a %T", metric.Type, metric.Value)
+	}
+	if metric.SampleRate == 0 {
+		metric.SampleRate = 1
+	} else if metric.SampleRate < 0 || metric.SampleRate > 1 {
+		return fmt.Errorf("the sample rate must be between 0 and 1, got %v", metric.SampleRate)
+	}
+	metric.SetTags(metric.Tags...)
+
+	if !s.checkMetricName(&metric, "ingest") {
+		return fmt.Errorf("invalid metric name %q", metric.Name)
+	}
+	s.checkDistribution(&metric)
+	if metric.Timestamp != 0 && !s.backfill(&metric, time.Now()) {
+		metrics.ReportOne(s.TraceClient, ssf.Count("packet.backfill_dropped_total", 1, map[string]string{"type": metric.Type}))
+		return nil
 	}
+	s.workerFor(&metric).IngestUDP(metric)
 	return nil
 }
 
+// checkMetricName checks the name of a metric that a client sent, and
+// returns false if the metric should be dropped. Depending on
+// metric_name_invalid_action, metrics with invalid names are either
+// dropped or renamed, with the disallowed characters replaced by
+// underscores.
+func (s *Server) checkMetricName(metric *samplers.UDPMetric, packetType string) bool {
+	if s.metricNames == nil || s.metricNames.Valid(metric.Name) {
+		return true
+	}
+	if s.sanitizeMetricNames {
+		metric.Rename(s.metricNames.Sanitize(metric.Name, "_"))
+		metrics.ReportOne(s.TraceClient, ssf.Count("packet.metric_names_sanitized_total", 1, map[string]string{"packet_type": packetType}))
+		return true
+	}
+	log.WithField("name", metric.Name).Debug("Dropping a metric with an invalid name")
+	metrics.ReportOne(s.TraceClient, ssf.Count("packet.error_total", 1, map[string]string{"packet_type": packetType, "reason": "invalid_name"}))
+	return false
+}
+
+// backfill decides which flush interval a metric with a DogStatsD
+// timestamp is aggregated in, and returns false if it is too old to be
+// kept. Counters and gauges are aggregated into the interval that their
+// timestamp falls into, which only lines up with veneur's own flushes if
+// synchronize_with_interval is set; points from the current interval (or
+// the future) are aggregated as usual. Other metric types, and all metrics
+// if backfilling is disabled, ignore their timestamp.
+func (s *Server) backfill(metric *samplers.UDPMetric, now time.Time) bool {
+	if s.maxBackfillAge == 0 || (metric.Type != "counter" && metric.Type != "gauge") {
+		metric.Timestamp = 0
+		return true
+	}
+	if now.Sub(time.Unix(metric.Timestamp, 0)) > s.maxBackfillAge {
+		return false
+	}
+
+	interval := int64(s.interval / time.Second)
+	if interval < 1 {
+		interval = 1
+	}
+	start := metric.Timestamp - metric.Timestamp%interval
+	if start >= now.Unix()-now.Unix()%interval {
+		metric.Timestamp = 0
+	} else {
+		metric.Timestamp = start
+	}
+	return true
+}
+
 // HandleTracePacket accepts an incoming packet as bytes and sends it to the
 // appropriate worker.
 func (s *Server) HandleTracePacket(packet []byte, protocolType ProtocolType) {
@@ -1233,26 +2414,87 @@ func (s *Server) handleSSF(span *ssf.SSFSpan, ssfFormat string, protocolType Pro
 		incrementListeningProtocol(s, protocolType)
 	}
 
+	if span = s.validateSpan(span); span == nil {
+		return
+	}
+	if span = s.sampleSpan(span); span == nil {
+		return
+	}
 	s.SpanChan <- span
 }
 
 // ReadMetricSocket listens for available packets to handle.
 func (s *Server) ReadMetricSocket(serverConn net.PacketConn, packetPool *sync.Pool) {
+	s.readMetricSocket(serverConn, packetPool, 0)
+}
+
+// readMetricSocket reads metrics off serverConn like ReadMetricSocket,
+// and counts the reads that failed or were likely truncated, tagged
+// with the index of the reader and the address it listens on.
+func (s *Server) readMetricSocket(serverConn net.PacketConn, packetPool *sync.Pool, reader int) {
+	listener := serverConn.LocalAddr().String()
+	tags := []string{fmt.Sprintf("reader:%d", reader), "listener:" + listener}
+	stats := s.listenerStatsFor(DOGSTATSD_UDP.String(), listener, s.udpReaders())
 	for {
 		buf := packetPool.Get().([]byte)
-		n, _, err := serverConn.ReadFrom(buf)
+		n, src, err := serverConn.ReadFrom(buf)
+		if err == errReaderRetired {
+			packetPool.Put(buf)
+			return
+		}
 		if err != nil {
+			s.Statsd.Count("udp.read_errors_total", 1, tags, 1.0)
 			log.WithError(err).Error("Error reading from UDP metrics socket")
+			packetPool.Put(buf)
 			continue
 		}
-		s.processMetricPacket(n, buf, packetPool, DOGSTATSD_UDP)
+		stats.read(reader, n)
+		if s.udpCapture != nil {
+			s.captureUDPPacket(buf[:n])
+		}
+		if s.udpRateLimiter != nil {
+			if udpSrc, ok := src.(*net.UDPAddr); ok && !s.udpRateLimiter.allow(udpSrc.IP, time.Now()) {
+				packetPool.Put(buf)
+				continue
+			}
+		}
+		if n == len(buf) {
+			// The datagram filled the entire buffer, so the
+			// kernel most likely discarded the rest of it.
+			s.Statsd.Count("udp.truncated_reads_total", 1, tags, 1.0)
+		}
+		s.processMetricPacket(n, buf, packetPool, DOGSTATSD_UDP, listener, src)
+	}
+}
+
+// captureUDPPacket records a UDP statsd packet to the capture file, and
+// logs when the capture stops, because the file is full or because of an
+// error.
+func (s *Server) captureUDPPacket(packet []byte) {
+	err := s.udpCapture.Write(time.Now(), packet)
+	if err == nil || err == capture.ErrClosed {
+		return
 	}
+	s.udpCaptureStopped.Do(func() {
+		if err == capture.ErrFull {
+			log.Warn("Stopped capturing the UDP statsd packets: the capture file is full")
+			return
+		}
+		log.WithError(err).Error("Stopped capturing the UDP statsd packets")
+	})
 }
 
-// Splits the read metric packet into multiple metrics and handles them
-func (s *Server) processMetricPacket(numBytes int, buf []byte, packetPool *sync.Pool, protocolType ProtocolType) {
+// Splits the read metric packet into multiple metrics and handles them.
+// If listener is non-empty, packets that get dropped are counted with
+// it as a tag, so that listeners on several addresses can be told
+// apart.
+func (s *Server) processMetricPacket(numBytes int, buf []byte, packetPool *sync.Pool, protocolType ProtocolType, listener string, source net.Addr) {
 	if numBytes > s.metricMaxLength {
-		metrics.ReportOne(s.TraceClient, ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "unknown", "reason": "toolong"}))
+		tags := map[string]string{"packet_type": "unknown", "reason": "toolong"}
+		if listener != "" {
+			tags["listener"] = listener
+		}
+		metrics.ReportOne(s.TraceClient, ssf.Count("packet.error_total", 1, tags))
 		return
 	}
 
@@ -1263,7 +2505,7 @@ func (s *Server) processMetricPacket(numBytes int, buf []byte, packetPool *sync.
 	// trailing newlines
 	splitPacket := samplers.NewSplitBytes(buf[:numBytes], '\n')
 	for splitPacket.Next() {
-		s.HandleMetricPacket(splitPacket.Chunk(), protocolType)
+		s.handleMetricPacket(splitPacket.Chunk(), protocolType, nil, source)
 	}
 
 	//Only return to the pool if there is a pool
@@ -1278,6 +2520,16 @@ func (s *Server) processMetricPacket(numBytes int, buf []byte, packetPool *sync.
 
 // ReadStatsdDatagramSocket reads statsd metrics packets from connection off a unix datagram socket.
 func (s *Server) ReadStatsdDatagramSocket(serverConn *net.UnixConn, packetPool *sync.Pool) {
+	s.readStatsdDatagramSocket(serverConn, packetPool, 0)
+}
+
+// readStatsdDatagramSocket reads metrics off serverConn like
+// ReadStatsdDatagramSocket, counting the packets it reads as those of
+// the given reader, and the reads that were likely truncated.
+func (s *Server) readStatsdDatagramSocket(serverConn *net.UnixConn, packetPool *sync.Pool, reader int) {
+	listener := serverConn.LocalAddr().String()
+	tags := []string{fmt.Sprintf("reader:%d", reader), "listener:" + listener}
+	stats := s.listenerStatsFor(DOGSTATSD_UNIX.String(), listener, s.numReaders)
 	for {
 		buf := packetPool.Get().([]byte)
 		n, _, err := serverConn.ReadFromUnix(buf)
@@ -1292,12 +2544,33 @@ func (s *Server) ReadStatsdDatagramSocket(serverConn *net.UnixConn, packetPool *
 			}
 		}
 
-		s.processMetricPacket(n, buf, packetPool, DOGSTATSD_UNIX)
+		stats.read(reader, n)
+		if n == len(buf) {
+			// As with UDP, the datagram most likely didn't fit.
+			s.Statsd.Count("unix.truncated_reads_total", 1, tags, 1.0)
+		}
+		s.processMetricPacket(n, buf, packetPool, DOGSTATSD_UNIX, listener, nil)
+	}
+}
+
+// udpReaders returns the greatest number of readers that each UDP
+// address can have.
+func (s *Server) udpReaders() int {
+	if s.adaptiveReadersMax > s.numReaders {
+		return s.adaptiveReadersMax
 	}
+	return s.numReaders
 }
 
 // ReadSSFPacketSocket reads SSF packets off a packet connection.
 func (s *Server) ReadSSFPacketSocket(serverConn net.PacketConn, packetPool *sync.Pool) {
+	s.readSSFPacketSocket(serverConn, packetPool, 0)
+}
+
+// readSSFPacketSocket reads SSF packets off serverConn like
+// ReadSSFPacketSocket, counting the packets it reads as those of the
+// given reader.
+func (s *Server) readSSFPacketSocket(serverConn net.PacketConn, packetPool *sync.Pool, reader int) {
 	// TODO This is duplicated from ReadMetricSocket and feels like it could be it's
 	// own function?
 	p := packetPool.Get().([]byte)
@@ -1307,9 +2580,14 @@ func (s *Server) ReadSSFPacketSocket(serverConn net.PacketConn, packetPool *sync
 	}
 	packetPool.Put(p)
 
+	stats := s.listenerStatsFor(SSF_UDP.String(), serverConn.LocalAddr().String(), s.udpReaders())
 	for {
 		buf := packetPool.Get().([]byte)
 		n, _, err := serverConn.ReadFrom(buf)
+		if err == errReaderRetired {
+			packetPool.Put(buf)
+			return
+		}
 		if err != nil {
 			// In tests, the probably-best way to
 			// terminate this reader is to issue a shutdown and close the listening
@@ -1324,6 +2602,7 @@ func (s *Server) ReadSSFPacketSocket(serverConn net.PacketConn, packetPool *sync
 			}
 		}
 
+		stats.read(reader, n)
 		s.HandleTracePacket(buf[:n], SSF_UDP)
 		packetPool.Put(buf)
 	}
@@ -1343,13 +2622,22 @@ func (s *Server) ReadSSFStreamSocket(serverConn net.Conn) {
 	tags[0] = "ssf_format:framed"
 
 	for {
-		msg, err := protocol.ReadSSF(serverConn)
+		msg, err := protocol.ReadSSFLimit(serverConn, s.ssfMaxFrameLength)
 		if err != nil {
 			if err == io.EOF {
 				// Client hangup, close this
 				s.Statsd.Count("frames.disconnects", 1, nil, 1.0)
 				return
 			}
+			if protocol.IsHangup(err) {
+				// Client hung up in the middle of a frame;
+				// there's nothing left to read.
+				log.WithError(err).
+					WithField("remote", serverConn.RemoteAddr()).
+					Debug("SSF connection closed mid-frame")
+				s.Statsd.Count("frames.disconnects", 1, nil, 1.0)
+				return
+			}
 			if protocol.IsFramingError(err) {
 				log.WithError(err).
 					WithField("remote", serverConn.RemoteAddr()).
@@ -1391,14 +2679,14 @@ func (s *Server) handleTCPGoroutine(conn net.Conn) {
 	metrics.ReportOne(s.TraceClient, ssf.Count("tcp.connects", 1, nil))
 
 	// time out idle connections to prevent leaking memory/goroutines
-	timeout := defaultTCPReadTimeout
-	if s.tcpReadTimeout != 0 {
-		timeout = s.tcpReadTimeout
-	}
+	timeout := s.idleTimeout()
 
+	var extraTags []string
 	if tlsConn, ok := conn.(*tls.Conn); ok {
 		// complete the handshake to verify the certificate
-		conn.SetReadDeadline(time.Now().Add(timeout))
+		if timeout > 0 {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		}
 		err := tlsConn.Handshake()
 		if err != nil {
 			// usually io.EOF or "read: connection reset by peer"; not really errors
@@ -1417,6 +2705,9 @@ func (s *Server) handleTCPGoroutine(conn net.Conn) {
 		if len(state.PeerCertificates) > 0 {
 			clientCert = state.PeerCertificates[0].Subject.ToRDNSequence()
 		}
+		if tag := s.tlsClientTag(state); tag != "" {
+			extraTags = []string{tag}
+		}
 		log.WithFields(logrus.Fields{
 			"peer":        conn.RemoteAddr(),
 			"client_cert": clientCert,
@@ -1427,21 +2718,90 @@ func (s *Server) handleTCPGoroutine(conn net.Conn) {
 		}).Debug("Starting TCP connection")
 	}
 
+	s.readStatsdLines(conn, timeout, DOGSTATSD_TCP, extraTags)
+}
+
+// tlsClientTag returns the tag identifying the verified client
+// certificate of a TLS connection, or "" if client tagging is
+// disabled or the client did not present a verified certificate.
+func (s *Server) tlsClientTag(state tls.ConnectionState) string {
+	if s.tlsClientTagKey == "" || len(state.VerifiedChains) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	var value string
+	switch s.tlsClientTagSource {
+	case "", "cn":
+		value = cert.Subject.CommonName
+	case "dns_san":
+		if len(cert.DNSNames) > 0 {
+			value = cert.DNSNames[0]
+		}
+	case "email_san":
+		if len(cert.EmailAddresses) > 0 {
+			value = cert.EmailAddresses[0]
+		}
+	case "uri_san":
+		if len(cert.URIs) > 0 {
+			value = cert.URIs[0].String()
+		}
+	}
+	if value == "" {
+		return ""
+	}
+	return s.tlsClientTagKey + ":" + value
+}
+
+// ReadStatsdStreamSocket reads newline-separated statsd metrics off a
+// streaming connection, such as a UNIX domain socket, treating each
+// line as a separate packet. It closes the connection once the client
+// hangs up, sends an invalid packet, or has been idle for longer than
+// the TCP read timeout.
+func (s *Server) ReadStatsdStreamSocket(conn net.Conn) {
+	defer func() {
+		ConsumePanic(s.TraceClient, s.Hostname, recover())
+	}()
+	defer conn.Close()
+
+	s.readStatsdLines(conn, s.idleTimeout(), DOGSTATSD_UNIX, nil)
+}
+
+// idleTimeout returns how long stream connections can go without sending
+// anything before they're closed, or 0 if they can stay idle forever.
+func (s *Server) idleTimeout() time.Duration {
+	switch {
+	case s.tcpReadTimeout < 0:
+		return 0
+	case s.tcpReadTimeout == 0:
+		return defaultTCPReadTimeout
+	}
+	return s.tcpReadTimeout
+}
+
+// readStatsdLines reads statsd packets, one per line, off conn until
+// it encounters an error or a packet that fails to parse. Each read
+// must complete within timeout, unless it's 0. extraTags are added to
+// every metric read from conn.
+func (s *Server) readStatsdLines(conn net.Conn, timeout time.Duration, protocol ProtocolType, extraTags []string) {
 	// Scanner is nearly the same performance as a custom implementation
 	buf := bufio.NewScanner(conn)
 
 	scanWithDeadline := func() bool {
-		conn.SetReadDeadline(time.Now().Add(timeout))
+		if timeout > 0 && (protocol != DOGSTATSD_TCP || !s.isDrainingTCP()) {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+		}
 		return buf.Scan()
 	}
 	for scanWithDeadline() {
 		// treat each line as a separate packet
-		err := s.HandleMetricPacket(buf.Bytes(), DOGSTATSD_TCP)
+		err := s.handleMetricPacket(buf.Bytes(), protocol, extraTags, conn.RemoteAddr())
 		if err != nil {
 			// don't consume bad data from a client indefinitely
 			// HandleMetricPacket logs the err and packet, and increments error counters
-			log.WithField("peer", conn.RemoteAddr()).Warn(
-				"Error parsing packet; closing TCP connection")
+			log.WithFields(logrus.Fields{
+				"peer":     conn.RemoteAddr(),
+				"protocol": protocol.String(),
+			}).Warn("Error parsing packet; closing connection")
 			return
 		}
 	}
@@ -1450,13 +2810,53 @@ func (s *Server) handleTCPGoroutine(conn net.Conn) {
 		log.WithFields(logrus.Fields{
 			logrus.ErrorKey: buf.Err(),
 			"peer":          conn.RemoteAddr(),
-		}).Info("Error reading from TCP client")
+			"protocol":      protocol.String(),
+		}).Info("Error reading from statsd client")
+	}
+}
+
+// ReadMetrics reads newline-separated statsd packets from r until it's
+// exhausted, and then returns the metrics that a flush would pass to the
+// metric sinks, without flushing them. Packets that fail to parse are
+// logged and skipped. It doesn't need the server to be started, so that
+// metrics can be aggregated from a file or a pipe, for scripts and tests.
+func (s *Server) ReadMetrics(ctx context.Context, r io.Reader) ([]samplers.InterMetric, error) {
+	buf := bufio.NewScanner(r)
+	for buf.Scan() {
+		if len(buf.Bytes()) == 0 {
+			continue
+		}
+		// HandleMetricPacket logs the err and packet
+		s.HandleMetricPacket(buf.Bytes(), DOGSTATSD_STDIN)
+	}
+	if err := buf.Err(); err != nil {
+		return nil, err
+	}
+	for _, w := range s.Workers {
+		w.Sync()
+	}
+	_, _, metrics := s.collectMetrics(ctx, regularFlush)
+	for group := range s.flushIntervals {
+		_, _, groupMetrics := s.collectMetrics(ctx, group)
+		metrics = append(metrics, groupMetrics...)
 	}
+	return metrics, nil
 }
 
 // ReadTCPSocket listens on Server.TCPAddr for new connections, starting a goroutine for each.
 func (s *Server) ReadTCPSocket(listener net.Listener) {
+	s.readTCPSocket(listener, nil, nil)
+}
+
+// readTCPSocket accepts connections on listener like ReadTCPSocket,
+// registering each of them with conns (if it is non-nil) so they can
+// be drained on shutdown, and taking a slot in limit (if it is non-nil)
+// for each of them.
+func (s *Server) readTCPSocket(listener net.Listener, conns *tcpConnTracker, limit *connLimiter) {
 	for {
+		if limit != nil && limit.wait && !limit.acquire(s.shutdown) {
+			return
+		}
 		conn, err := listener.Accept()
 		if err != nil {
 			select {
@@ -1468,8 +2868,24 @@ func (s *Server) ReadTCPSocket(listener net.Listener) {
 				log.WithError(err).Fatal("TCP accept failed")
 			}
 		}
+		if limit != nil && !limit.wait && !limit.acquire(s.shutdown) {
+			metrics.ReportOne(s.TraceClient, ssf.Count("tcp.connections_rejected_total", 1, nil))
+			conn.Close()
+			continue
+		}
 
-		go s.handleTCPGoroutine(conn)
+		if conns != nil {
+			conns.add(conn)
+		}
+		go func() {
+			if limit != nil {
+				defer limit.release()
+			}
+			if conns != nil {
+				defer conns.done(conn)
+			}
+			s.handleTCPGoroutine(conn)
+		}()
 	}
 }
 
@@ -1518,7 +2934,23 @@ func (s *Server) HTTPServe() {
 			profileStopOnce.Do(prf.Stop)
 		}()
 	}
-	httpSocket := bind.Socket(s.HTTPAddr)
+	var httpSocket net.Listener
+	if strings.HasPrefix(s.HTTPAddr, "unix://") {
+		// lock the socket file and clear away a stale socket, like
+		// the UNIX domain socket listeners of metrics and spans do
+		addr, err := protocol.ResolveAddr(s.HTTPAddr)
+		if err != nil {
+			log.WithError(err).Fatal("Couldn't parse the HTTP address")
+		}
+		listener, lock, err := listenUnixStream(addr.(*net.UnixAddr))
+		if err != nil {
+			log.WithError(err).Fatal("Couldn't listen for HTTP")
+		}
+		defer releaseLockForSocket(lock)
+		httpSocket = listener
+	} else {
+		httpSocket = bind.Socket(s.HTTPAddr)
+	}
 	graceful.Timeout(10 * time.Second)
 	graceful.PreHook(func() {
 
@@ -1529,12 +2961,20 @@ func (s *Server) HTTPServe() {
 		log.Info("Terminating HTTP listener")
 	})
 
-	// Ensure that the server responds to SIGUSR2 even
-	// when *not* running under einhorn.
-	graceful.AddSignal(syscall.SIGUSR2, syscall.SIGHUP)
+	// Ensure that the server responds to SIGUSR2 and SIGHUP even
+	// when *not* running under einhorn, unless SIGHUP reloads the
+	// config instead, see ReloadOnSIGHUP.
+	if s.reloadsOnSIGHUP {
+		graceful.AddSignal(syscall.SIGUSR2)
+	} else {
+		graceful.AddSignal(syscall.SIGUSR2, syscall.SIGHUP)
+	}
 	graceful.HandleSignals()
 	gracefulSocket := graceful.WrapListener(httpSocket)
-	log.WithField("address", s.HTTPAddr).Info("HTTP server listening")
+	s.httpListenAddrMtx.Lock()
+	s.httpListenAddr = httpSocket.Addr()
+	s.httpListenAddrMtx.Unlock()
+	log.WithField("address", httpSocket.Addr()).Info("HTTP server listening")
 
 	// Signal that the HTTP server is starting
 	atomic.AddInt32(s.numListeningHTTP, 1)
@@ -1549,10 +2989,30 @@ func (s *Server) HTTPServe() {
 	graceful.Shutdown()
 }
 
+// HTTPListenAddr returns the address that the HTTP server is listening
+// on, which has the actual port if http_address asks for port 0. It's nil
+// until HTTPServe has bound the socket.
+func (s *Server) HTTPListenAddr() net.Addr {
+	s.httpListenAddrMtx.Lock()
+	defer s.httpListenAddrMtx.Unlock()
+	return s.httpListenAddr
+}
+
+// GRPCImportAddr returns the address that the gRPC import server is
+// listening on, which has the actual port if grpc_address asks for port
+// 0. It's nil if grpc_address isn't set, or until Serve has bound the
+// listener.
+func (s *Server) GRPCImportAddr() net.Addr {
+	if s.grpcServer == nil {
+		return nil
+	}
+	return s.grpcServer.Addr()
+}
+
 // gRPCServe starts the gRPC server and blocks until an error is encountered,
 // or the server is shutdown.
 //
-// TODO this doesn't handle SIGUSR2 and SIGHUP on it's own, unlike HTTPServe
+// TODO this doesn't handle SIGUSR2 on it's own, unlike HTTPServe
 // As long as both are running this is actually fine, as Serve will stop
 // the gRPC server when the HTTP one exits.  When running just gRPC however,
 // the signal handling won't work.
@@ -1598,16 +3058,123 @@ func (s *Server) Shutdown() {
 	s.gRPCStop()
 
 	// Close the gRPC connection for forwarding
+	if s.grpcForwardStream != nil {
+		s.grpcForwardStream.Close()
+	}
 	if s.grpcForwardConn != nil {
 		s.grpcForwardConn.Close()
 	}
+
+	if s.deadLetter != nil {
+		s.deadLetter.Close()
+	}
+
+	if s.udpCapture != nil {
+		if err := s.udpCapture.Close(); err != nil {
+			log.WithError(err).Error("Error closing the UDP capture file")
+		}
+		if dropped := s.udpCapture.Dropped(); dropped > 0 {
+			log.WithField("dropped", dropped).Warn("The UDP capture file couldn't keep up, and is missing packets")
+		}
+	}
+
+	for _, batcher := range s.spanBatchers {
+		batcher.Stop()
+	}
+
+	if s.OnShutdown != nil {
+		s.OnShutdown(s)
+	}
+}
+
+// EffectiveRcvbufBytes returns the smallest receive buffer size that
+// the kernel granted to any of the server's UDP sockets, or 0 if no
+// UDP socket has been opened yet. If it is smaller than RcvbufBytes,
+// the configured read_buffer_size_bytes was not honored.
+func (s *Server) EffectiveRcvbufBytes() int {
+	return int(atomic.LoadInt64(&s.effectiveRcvbufBytes))
+}
+
+// recordRcvbuf records the receive buffer size granted to a UDP
+// socket, keeping track of the smallest one.
+func (s *Server) recordRcvbuf(size int) {
+	for {
+		current := atomic.LoadInt64(&s.effectiveRcvbufBytes)
+		if current != 0 && current <= int64(size) {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&s.effectiveRcvbufBytes, current, int64(size)) {
+			return
+		}
+	}
+}
+
+// isDrainingTCP returns true if the server is shutting down and
+// TCP connections should finish processing their buffered input
+// instead of waiting for more.
+func (s *Server) isDrainingTCP() bool {
+	if s.tcpDrainTimeout == 0 {
+		return false
+	}
+	select {
+	case <-s.shutdown:
+		return true
+	default:
+		return false
+	}
 }
 
 // IsLocal indicates whether veneur is running as a local instance
 // (forwarding non-local data to a global veneur instance) or is running as a global
 // instance (sending all data directly to the final destination).
 func (s *Server) IsLocal() bool {
-	return s.ForwardAddr != ""
+	return s.ForwardAddr != "" || s.ForwardGRPCAddr != ""
+}
+
+// ingestionListener is a statsd or SSF address that the server is
+// configured to listen on.
+type ingestionListener struct {
+	protocol string
+	address  string
+	bound    int32 // accessed atomically
+}
+
+func newIngestionListener(protocol string, addr net.Addr) *ingestionListener {
+	return &ingestionListener{protocol: protocol, address: listenerAddress(addr)}
+}
+
+// isBound returns true once the listener has bound its socket.
+func (l *ingestionListener) isBound() bool {
+	return atomic.LoadInt32(&l.bound) != 0
+}
+
+// listenerAddress formats addr the way it appears in the
+// configuration, e.g. udp://127.0.0.1:8126.
+func listenerAddress(addr net.Addr) string {
+	return addr.Network() + "://" + addr.String()
+}
+
+// listenerBound records that the configured protocol listener on addr
+// has bound its socket. Addresses that the server isn't configured
+// with are ignored.
+func (s *Server) listenerBound(protocol string, addr net.Addr) {
+	address := listenerAddress(addr)
+	for _, l := range s.ingestionListeners {
+		if l.protocol == protocol && l.address == address && atomic.CompareAndSwapInt32(&l.bound, 0, 1) {
+			return
+		}
+	}
+}
+
+// isIngestionReady returns true once every configured statsd and SSF
+// listener has bound its socket.
+func (s *Server) isIngestionReady() bool {
+	for _, l := range s.ingestionListeners {
+		if !l.isBound() {
+			return false
+		}
+	}
+	return true
 }
 
 // isListeningHTTP returns if the Server is currently listening over HTTP
@@ -1667,6 +3234,109 @@ func setSinkExcludedTags(excludeRules []string, metricSinks []sinks.MetricSink,
 	}
 }
 
+// filterSinkTags wraps each metric sink that an allow or deny rule
+// applies to, such that it only receives the tags that pass its rules.
+func filterSinkTags(allowRules, denyRules []string, metricSinks []sinks.MetricSink) []sinks.MetricSink {
+	if len(allowRules) == 0 && len(denyRules) == 0 {
+		return metricSinks
+	}
+	filtered := make([]sinks.MetricSink, 0, len(metricSinks))
+	for _, sink := range metricSinks {
+		allow := generateExcludedTags(allowRules, sink.Name())
+		deny := generateExcludedTags(denyRules, sink.Name())
+		if len(allow) == 0 && len(deny) == 0 {
+			filtered = append(filtered, sink)
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"sink":  sink.Name(),
+			"allow": allow,
+			"deny":  deny,
+		}).Info("Filtering tags on metric sink")
+		filtered = append(filtered, sinks.FilterMetricTags(sink, allow, deny))
+	}
+	return filtered
+}
+
+// sampleSpanSinks wraps each span sink, except the one that extracts
+// metrics from spans, such that it only receives the spans that the
+// sampling rules keep.
+func sampleSpanSinks(rules []sinks.SpanSamplingRule, defaultRatePercent float64, spanSinks []sinks.SpanSink) ([]sinks.SpanSink, []*sinks.SpanSamplingSink) {
+	sampled := make([]sinks.SpanSink, 0, len(spanSinks))
+	var samplers []*sinks.SpanSamplingSink
+	for _, sink := range spanSinks {
+		if sink.Name() == "metric_extraction" {
+			sampled = append(sampled, sink)
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"sink":         sink.Name(),
+			"rules":        len(rules),
+			"default_rate": defaultRatePercent,
+		}).Info("Sampling spans on span sink")
+		sampler := sinks.SampleSpans(sink, rules, defaultRatePercent)
+		sampled = append(sampled, sampler)
+		samplers = append(samplers, sampler)
+	}
+	return sampled, samplers
+}
+
+// The defaults of span batching, for the settings that aren't set.
+const (
+	defaultSpanBatchMaxSpans   = 1000
+	defaultSpanBatchBufferSize = 1 << 14
+)
+
+// batchSpanSinks wraps each span sink, except the one that extracts
+// metrics from spans, such that it's sent spans in batches, and returns
+// the wrapped sinks along with the batching ones.
+func batchSpanSinks(config sinks.SpanBatching, spanSinks []sinks.SpanSink) ([]sinks.SpanSink, []*sinks.BatchingSpanSink) {
+	batched := make([]sinks.SpanSink, 0, len(spanSinks))
+	var batchers []*sinks.BatchingSpanSink
+	for _, sink := range spanSinks {
+		if sink.Name() == "metric_extraction" {
+			batched = append(batched, sink)
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"sink":        sink.Name(),
+			"max_spans":   config.MaxSpans,
+			"max_age":     config.MaxAge,
+			"buffer_size": config.BufferSize,
+		}).Info("Batching spans to span sink")
+		batcher := sinks.BatchSpans(sink, config)
+		batched = append(batched, batcher)
+		batchers = append(batchers, batcher)
+	}
+	return batched, batchers
+}
+
+// defaultTailSamplingMaxSpans is how many spans tail sampling buffers at
+// most for each span sink unless ssf_tail_sampling_max_spans is set.
+const defaultTailSamplingMaxSpans = 100000
+
+// tailSampleSpanSinks wraps each span sink, except the one that extracts
+// metrics from spans, such that it only receives the traces that tail
+// sampling keeps. Each wrapper has its own buffer, of up to
+// config.MaxSpans spans.
+func tailSampleSpanSinks(config sinks.TailSampling, spanSinks []sinks.SpanSink) []sinks.SpanSink {
+	sampled := make([]sinks.SpanSink, 0, len(spanSinks))
+	for _, sink := range spanSinks {
+		if sink.Name() == "metric_extraction" {
+			sampled = append(sampled, sink)
+			continue
+		}
+		log.WithFields(logrus.Fields{
+			"sink":              sink.Name(),
+			"decision_wait":     config.DecisionWait,
+			"latency_threshold": config.LatencyThreshold,
+			"rate":              config.RatePercent,
+		}).Info("Tail sampling traces on span sink")
+		sampled = append(sampled, sinks.TailSampleSpans(sink, config))
+	}
+	return sampled
+}
+
 func generateExcludedTags(excludeRules []string, sinkName string) []string {
 	excludedTags := make([]string, 0, len(excludeRules))
 	for _, rule := range excludeRules {
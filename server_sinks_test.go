@@ -15,6 +15,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stripe/veneur/v14/sinks/datadog"
+	"github.com/stripe/veneur/v14/sinks/kafka"
 	"github.com/stripe/veneur/v14/sinks/lightstep"
 	"github.com/stripe/veneur/v14/sinks/prometheus"
 )
@@ -180,3 +181,26 @@ func TestNewPrometheusMetricSinkConfig(t *testing.T) {
 	sink := server.metricSinks[0].(*prometheus.StatsdRepeater)
 	assert.Equal(t, "prometheus", sink.Name())
 }
+
+func TestNewKafkaSinkAuthConfig(t *testing.T) {
+	config := localConfig()
+	config.KafkaBroker = "localhost:9092"
+	config.KafkaSpanTopic = "veneur_spans"
+	config.KafkaSaslMechanism = "PLAIN"
+	config.KafkaSaslUser = "veneur"
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err, "SASL needs a password")
+
+	config.KafkaSaslPassword = "hunter2"
+	config.KafkaTLSEnabled = true
+	config.KafkaTLSCertificate = "not a certificate"
+	_, err = NewFromConfig(logrus.New(), config)
+	assert.Error(t, err, "the client certificate needs a key")
+
+	config.KafkaTLSCertificate = ""
+	server, err := NewFromConfig(logrus.New(), config)
+	if assert.NoError(t, err) {
+		sink := server.spanSinks[len(server.spanSinks)-1].(*kafka.KafkaSpanSink)
+		assert.Equal(t, "kafka", sink.Name())
+	}
+}
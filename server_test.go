@@ -6,9 +6,11 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"sync"
 
@@ -16,8 +18,11 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -25,6 +30,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/capture"
 	"github.com/stripe/veneur/v14/protocol"
 	"github.com/stripe/veneur/v14/samplers"
 	"github.com/stripe/veneur/v14/sinks"
@@ -294,6 +300,91 @@ func TestGlobalServerFlush(t *testing.T) {
 	assert.Equal(t, len(expectedMetrics), len(interMetrics), "incorrect number of elements in the flushed series on the remote server")
 }
 
+func TestLifecycleHooks(t *testing.T) {
+	config := globalConfig()
+	// the sink that OnStart adds can be named in the configuration
+	config.MetricSinksShadow = []string{"channel"}
+	server, err := NewFromConfig(logrus.New(), config)
+	require.NoError(t, err)
+	trace.NeutralizeClient(server.TraceClient)
+	server.TraceClient = nil
+
+	metricsChan := make(chan []samplers.InterMetric, 10)
+	cms, _ := NewChannelMetricSink(metricsChan)
+	var events []string
+	hooked := make(chan []samplers.InterMetric, 1)
+	server.OnStart = func(s *Server) {
+		events = append(events, "start")
+		s.AddMetricSink(cms)
+		bhs, _ := blackhole.NewBlackholeSpanSink()
+		s.AddSpanSink(bhs)
+	}
+	server.OnFlush = func(ctx context.Context, metrics []samplers.InterMetric) {
+		hooked <- metrics
+	}
+	server.OnShutdown = func(s *Server) {
+		events = append(events, "shutdown")
+	}
+
+	server.Start()
+	server.Workers[0].ProcessMetric(&samplers.UDPMetric{
+		MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: counterTypeName},
+		Value:      1.0,
+		Digest:     12345,
+		SampleRate: 1.0,
+		Scope:      samplers.MixedScope,
+	})
+	server.Flush(context.TODO())
+
+	var flushed []samplers.InterMetric
+	select {
+	case flushed = <-metricsChan:
+		require.Len(t, flushed, 1)
+		assert.Equal(t, "a.b.c", flushed[0].Name, "the sink added by OnStart should be flushed to")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the flush")
+	}
+	select {
+	case metrics := <-hooked:
+		assert.Equal(t, flushed, metrics, "OnFlush should get the flushed metrics")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for OnFlush")
+	}
+	server.Shutdown()
+	assert.Equal(t, []string{"start", "shutdown"}, events)
+}
+
+func TestReadMetrics(t *testing.T) {
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	config.Percentiles = nil
+	config.Aggregates = []string{"count"}
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+	server := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer server.Shutdown()
+
+	// over the capacity of the workers' channels, so that reading has to
+	// wait for the workers
+	var lines strings.Builder
+	for i := 0; i < 500; i++ {
+		lines.WriteString("a.b.c:1|c|#foo:bar\n")
+		lines.WriteString("d.e.f:2|h\n\n")
+	}
+	lines.WriteString("not a metric\n")
+	metrics, err := server.ReadMetrics(context.Background(), strings.NewReader(lines.String()))
+	require.NoError(t, err)
+
+	values := map[string]float64{}
+	for _, m := range metrics {
+		values[m.Name] = m.Value
+	}
+	assert.Equal(t, map[string]float64{"a.b.c": 500, "d.e.f.count": 500}, values)
+	assert.Empty(t, rcv, "the metrics shouldn't be flushed to sinks")
+}
+
 // TestLocalServerMixedMetrics ensures that stuff tagged as local only or local parts of mixed
 // scope metrics are sent directly to sinks while global metrics are forwarded.
 func TestLocalServerMixedMetrics(t *testing.T) {
@@ -565,6 +656,207 @@ func TestUDPMetrics(t *testing.T) {
 	assert.Equal(t, "foo.bar", metrics[0].Name, "worker processed the metric")
 }
 
+func TestUDPTruncatedReads(t *testing.T) {
+	stats, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer stats.Close()
+
+	config := localConfig()
+	config.NumWorkers = 1
+	config.Interval = "60s"
+	config.MetricMaxLength = 32
+	config.StatsAddress = stats.LocalAddr().String()
+	config.StatsdListenAddresses = []string{"udp://127.0.0.1:0"}
+	f := newFixture(t, config, nil, nil)
+	defer f.Close()
+
+	addr := f.server.StatsdListenAddrs[0]
+	conn := connectToAddress(t, "udp", addr.String(), 20*time.Millisecond)
+	defer conn.Close()
+	_, err = conn.Write([]byte(fmt.Sprintf("foo.bar:1|c|#baz:%s", strings.Repeat("x", 64))))
+	require.NoError(t, err)
+
+	buf := make([]byte, 4096)
+	stats.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		n, _, err := stats.ReadFrom(buf)
+		require.NoError(t, err, "should have received the truncated read counter")
+		if strings.Contains(string(buf[:n]), "veneur.udp.truncated_reads_total:1|c|#reader:0,listener:"+addr.String()) {
+			break
+		}
+	}
+}
+
+func TestUDPCapture(t *testing.T) {
+	tdir, err := ioutil.TempDir("", "udp_capture")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+	path := filepath.Join(tdir, "capture")
+
+	config := localConfig()
+	config.NumWorkers = 1
+	config.Interval = "60s"
+	config.StatsdListenAddresses = []string{"udp://127.0.0.1:0"}
+	config.UDPCaptureFile = path
+	f := newFixture(t, config, nil, nil)
+	defer f.Close()
+
+	addr := f.server.StatsdListenAddrs[0]
+	conn := connectToAddress(t, "udp", addr.String(), 20*time.Millisecond)
+	defer conn.Close()
+	sent := []string{"foo.bar:1|c|#baz:gorch", "foo.bar:2|c\nfoo.baz:3|g"}
+	for _, packet := range sent {
+		_, err = conn.Write([]byte(packet))
+		require.NoError(t, err)
+	}
+
+	var captured []string
+	require.Eventually(t, func() bool {
+		require.NoError(t, f.server.udpCapture.Flush())
+		file, err := os.Open(path)
+		require.NoError(t, err)
+		defer file.Close()
+		r, err := capture.NewReader(file, 0)
+		require.NoError(t, err)
+		captured = nil
+		for {
+			p, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			captured = append(captured, string(p.Data))
+		}
+		return len(captured) == len(sent)
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, sent, captured)
+}
+
+func TestUnixTruncatedReads(t *testing.T) {
+	stats, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer stats.Close()
+	tdir, err := ioutil.TempDir("", "unix_truncated_reads")
+	require.NoError(t, err)
+	defer os.RemoveAll(tdir)
+	path := filepath.Join(tdir, "statsd.sock")
+
+	config := localConfig()
+	config.NumWorkers = 1
+	config.Interval = "60s"
+	config.MetricMaxLength = 32
+	config.StatsAddress = stats.LocalAddr().String()
+	config.StatsdListenAddresses = []string{"unixgram://" + path}
+	f := newFixture(t, config, nil, nil)
+	defer f.Close()
+
+	conn := connectToAddress(t, "unixgram", path, 500*time.Millisecond)
+	defer conn.Close()
+	_, err = conn.Write([]byte(fmt.Sprintf("foo.bar:1|c|#baz:%s", strings.Repeat("x", 64))))
+	require.NoError(t, err)
+
+	buf := make([]byte, 4096)
+	stats.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		n, _, err := stats.ReadFrom(buf)
+		require.NoError(t, err, "should have received the truncated read counter")
+		if strings.Contains(string(buf[:n]), "veneur.unix.truncated_reads_total:1|c|#reader:0,listener:"+path) {
+			break
+		}
+	}
+	assert.Equal(t, int64(0), f.server.Workers[0].processed, "worker should not process a truncated metric")
+}
+
+func TestMetricMaxLength(t *testing.T) {
+	config := localConfig()
+	config.MetricMaxLength = -1
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+
+	config.MetricMaxLength = 0
+	server, err := NewFromConfig(logrus.New(), config)
+	require.NoError(t, err)
+	assert.Equal(t, defaultConfig.MetricMaxLength, server.metricMaxLength)
+}
+
+func TestSpanSamplingRules(t *testing.T) {
+	config := localConfig()
+	config.DebugIngestedSpans = true
+	config.SpanSamplingRules = append(config.SpanSamplingRules, struct {
+		Operation   string  `yaml:"operation"`
+		RatePercent float64 `yaml:"rate_percent"`
+	}{Operation: "checkout.*", RatePercent: 100})
+	server, err := NewFromConfig(logrus.New(), config)
+	require.NoError(t, err)
+
+	sampled := 0
+	for _, sink := range server.spanSinks {
+		if _, ok := sink.(*sinks.SpanSamplingSink); ok {
+			sampled++
+			continue
+		}
+		assert.Equal(t, "metric_extraction", sink.Name(), "only the metric extraction sink should see every span")
+	}
+	assert.NotZero(t, sampled)
+	assert.Equal(t, len(server.spanSinks)-1, sampled)
+
+	config.SpanSamplingRules[0].Operation = "checkout.["
+	_, err = NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+
+	config.SpanSamplingRules[0].Operation = "checkout.*"
+	config.SpanSamplingRules[0].RatePercent = 101
+	_, err = NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+}
+
+func TestTailSampling(t *testing.T) {
+	config := localConfig()
+	config.DebugIngestedSpans = true
+	config.SsfTailSamplingDecisionWait = "10s"
+	config.SsfTailSamplingLatencyThreshold = "1s"
+	server, err := NewFromConfig(logrus.New(), config)
+	require.NoError(t, err)
+
+	sampled := 0
+	for _, sink := range server.spanSinks {
+		if _, ok := sink.(*sinks.TailSamplingSink); ok {
+			sampled++
+			continue
+		}
+		assert.Equal(t, "metric_extraction", sink.Name(), "only the metric extraction sink should see every span")
+	}
+	assert.Equal(t, len(server.spanSinks)-1, sampled)
+
+	config.SsfTailSamplingDecisionWait = "soon"
+	_, err = NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+
+	config.SsfTailSamplingDecisionWait = "10s"
+	config.SsfTailSamplingRatePercent = -1
+	_, err = NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+}
+
+func TestSpanBatching(t *testing.T) {
+	config := localConfig()
+	config.DebugIngestedSpans = true
+	config.SpanBatchMaxSpans = 10
+	server, err := NewFromConfig(logrus.New(), config)
+	require.NoError(t, err)
+	assert.Len(t, server.spanBatchers, len(server.spanSinks)-1, "all but the metric extraction sink should be batched")
+	server.Shutdown()
+
+	config.SpanBatchMaxAge = "-1s"
+	_, err = NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+
+	config.SpanBatchMaxAge = ""
+	config.SpanBatchBufferSize = 5
+	_, err = NewFromConfig(logrus.New(), config)
+	assert.Error(t, err, "the buffer must hold a batch")
+}
+
 func TestUnixSocketMetrics(t *testing.T) {
 	ctx := context.TODO()
 	tdir, err := ioutil.TempDir("", "unixmetrics_statsd")
@@ -687,6 +979,83 @@ func TestMultipleUDPSockets(t *testing.T) {
 	}
 }
 
+func TestTCPMetricsReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT is only supported on linux")
+	}
+	config := localConfig()
+	config.NumWorkers = 1
+	config.NumReaders = 4
+	config.Interval = "60s"
+	config.StatsdListenAddresses = []string{"tcp://127.0.0.1:0"}
+	ch := make(chan []samplers.InterMetric, 20)
+	sink, _ := NewChannelMetricSink(ch)
+	f := newFixture(t, config, sink, nil)
+	defer f.Close()
+
+	addr := f.server.StatsdListenAddrs[0]
+	for i := 0; i < 8; i++ {
+		conn := connectToAddress(t, "tcp", addr.String(), 20*time.Millisecond)
+		_, err := conn.Write([]byte(fmt.Sprintf("foo.bar%d:1|c|#baz:gorch\n", i)))
+		require.NoError(t, err)
+		conn.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 500*time.Millisecond)
+	defer cancel()
+	keepFlushing(ctx, f.server)
+	seen := map[string]bool{}
+	for len(seen) < 8 {
+		select {
+		case metrics := <-ch:
+			for _, m := range metrics {
+				seen[m.Name] = true
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for metrics; saw %v", seen)
+		}
+	}
+}
+
+func TestUNIXStreamMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix-stream-statsd")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := localConfig()
+	config.NumWorkers = 1
+	config.Interval = "60s"
+	config.StatsdListenAddresses = []string{fmt.Sprintf("unix://%s/statsd.sock", dir)}
+	ch := make(chan []samplers.InterMetric, 20)
+	sink, _ := NewChannelMetricSink(ch)
+	f := newFixture(t, config, sink, nil)
+	defer f.Close()
+
+	addr := f.server.StatsdListenAddrs[0]
+	assert.Equal(t, "unix", addr.Network())
+	conn := connectToAddress(t, "unix", addr.String(), 20*time.Millisecond)
+	_, err = conn.Write([]byte("foo.bar:1|c|#baz:gorch\nfoo.baz:2|c\n"))
+	require.NoError(t, err)
+	conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 500*time.Millisecond)
+	defer cancel()
+	keepFlushing(ctx, f.server)
+	seen := map[string]bool{}
+	for len(seen) < 2 {
+		select {
+		case metrics := <-ch:
+			for _, m := range metrics {
+				seen[m.Name] = true
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for metrics; saw %v", seen)
+		}
+	}
+	assert.True(t, seen["foo.bar"])
+	assert.True(t, seen["foo.baz"])
+}
+
 func TestUDPMetricsSSF(t *testing.T) {
 	config := localConfig()
 	config.NumWorkers = 1
@@ -930,6 +1299,49 @@ func TestTCPMetrics(t *testing.T) {
 	}
 }
 
+func TestTLSClientTag(t *testing.T) {
+	uri, err := url.Parse("spiffe://example.com/client")
+	require.NoError(t, err)
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "client.example.com"},
+		DNSNames:       []string{"client-dns.example.com"},
+		EmailAddresses: []string{"client@example.com"},
+		URIs:           []*url.URL{uri},
+	}
+	verified := tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+	unverified := tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+	}
+
+	tests := []struct {
+		key, source string
+		state       tls.ConnectionState
+		expected    string
+	}{
+		{"", "cn", verified, ""},
+		{"veneur_client", "", verified, "veneur_client:client.example.com"},
+		{"veneur_client", "cn", verified, "veneur_client:client.example.com"},
+		{"veneur_client", "dns_san", verified, "veneur_client:client-dns.example.com"},
+		{"veneur_client", "email_san", verified, "veneur_client:client@example.com"},
+		{"veneur_client", "uri_san", verified, "veneur_client:spiffe://example.com/client"},
+		{"veneur_client", "cn", unverified, ""},
+		{"veneur_client", "cn", tls.ConnectionState{}, ""},
+	}
+	for _, test := range tests {
+		s := &Server{tlsClientTagKey: test.key, tlsClientTagSource: test.source}
+		assert.Equal(t, test.expected, s.tlsClientTag(test.state), "key %q, source %q", test.key, test.source)
+	}
+
+	config := localConfig()
+	config.TLSClientTagKey = "veneur_client"
+	config.TLSClientTagSource = "serial_number"
+	_, err = NewFromConfig(logrus.New(), config)
+	assert.Error(t, err, "unknown tag sources should be rejected")
+}
+
 // TestHandleTCPGoroutineTimeout verifies that an idle TCP connection doesn't block forever.
 func TestHandleTCPGoroutineTimeout(t *testing.T) {
 	const readTimeout = 30 * time.Millisecond
@@ -1001,6 +1413,19 @@ func TestCalculateTickerDelay(t *testing.T) {
 	assert.Equal(t, 3.629, delay.Seconds(), "Delay is incorrect")
 }
 
+func TestFlushDelay(t *testing.T) {
+	interval := 10 * time.Second
+	start := time.Date(2014, 11, 12, 11, 45, 26, 0, time.UTC)
+
+	assert.Equal(t, interval, flushDelay(interval, false, start, start))
+	assert.Equal(t, 4*time.Second, flushDelay(interval, false, start, start.Add(16*time.Second)),
+		"a slow flush should skip to the next tick after start")
+
+	assert.Equal(t, 4*time.Second, flushDelay(interval, true, start, start))
+	assert.Equal(t, 8*time.Second, flushDelay(interval, true, start, start.Add(6*time.Second)),
+		"synchronized flushes should stay on the multiples of the interval")
+}
+
 // BenchmarkSendSSFUNIX sends b.N metrics to veneur and waits until
 // all of them have been read (not processed).
 func BenchmarkSendSSFUNIX(b *testing.B) {
@@ -1282,6 +1707,69 @@ func TestInternalSSFMetricsEndToEnd(t *testing.T) {
 	assert.Equal(t, 2, n, "Should have gotten the right number of metrics")
 }
 
+// TestSSFMetricsMatchStatsd sends the same metrics through DogStatsD
+// and as SSF samples attached to a span, and checks that they are
+// flushed with the same values.
+func TestSSFMetricsMatchStatsd(t *testing.T) {
+	config := localConfig()
+	metricsChan := make(chan []samplers.InterMetric, 10)
+	cms, _ := NewChannelMetricSink(metricsChan)
+	f := newFixture(t, config, cms, nil)
+	defer f.Close()
+
+	for _, packet := range []string{
+		"parity.counter:3|c|@0.5|#via:statsd,novalue",
+		"parity.latency:25|h|#via:statsd,novalue",
+	} {
+		require.NoError(t, f.server.HandleMetricPacket([]byte(packet), DOGSTATSD_UDP))
+	}
+
+	client, err := trace.NewChannelClient(f.server.SpanChan)
+	require.NoError(t, err)
+	defer client.Close()
+	tags := map[string]string{"via": "ssf", "novalue": ""}
+	done := make(chan error)
+	for {
+		err = metrics.ReportAsync(client, []*ssf.SSFSample{
+			ssf.Count("parity.counter", 3, tags, ssf.SampleRate(0.5)),
+			ssf.Histogram("parity.latency", 25, tags),
+		}, done)
+		if err != trace.ErrWouldBlock {
+			break
+		}
+	}
+	require.NoError(t, err)
+	require.NoError(t, <-done)
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 1*time.Second)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		close(metricsChan)
+	}()
+	keepFlushing(ctx, f.server)
+
+	// sum up each metric's values over all flushes, by the way it
+	// was sent:
+	values := map[string]map[string]float64{"via:statsd": {}, "via:ssf": {}}
+	for flushed := range metricsChan {
+		for _, m := range flushed {
+			if !strings.HasPrefix(m.Name, "parity.") {
+				continue
+			}
+			assert.Contains(t, m.Tags, "novalue")
+			for _, tag := range m.Tags {
+				if byName, ok := values[tag]; ok {
+					byName[m.Name] += m.Value
+				}
+			}
+		}
+	}
+	assert.Equal(t, 6.0, values["via:statsd"]["parity.counter"])
+	assert.Equal(t, 25.0, values["via:statsd"]["parity.latency.max"])
+	assert.Equal(t, values["via:statsd"], values["via:ssf"])
+}
+
 func TestGenerateExcludeTags(t *testing.T) {
 	type testCase struct {
 		name         string
@@ -1327,6 +1815,31 @@ func TestGenerateExcludeTags(t *testing.T) {
 	}
 }
 
+func TestFilterSinkTags(t *testing.T) {
+	ch := make(chan []samplers.InterMetric, 1)
+	channelSink, err := NewChannelMetricSink(ch)
+	require.NoError(t, err)
+	bhs, err := blackhole.NewBlackholeMetricSink()
+	require.NoError(t, err)
+
+	metricSinks := []sinks.MetricSink{channelSink, bhs}
+	assert.Equal(t, metricSinks, filterSinkTags(nil, nil, metricSinks), "no rules should leave sinks alone")
+
+	filtered := filterSinkTags(nil, []string{"request_id|channel"}, metricSinks)
+	require.Len(t, filtered, 2)
+	assert.Equal(t, "channel", filtered[0].Name())
+	assert.IsType(t, &sinks.TagFilterSink{}, filtered[0])
+	assert.Equal(t, bhs, filtered[1], "sinks without rules should not be wrapped")
+
+	require.NoError(t, filtered[0].Flush(context.Background(), []samplers.InterMetric{{
+		Name: "a.b.c",
+		Tags: []string{"request_id:1234", "service:web"},
+	}}))
+	flushed := <-ch
+	require.Len(t, flushed, 1)
+	assert.Equal(t, []string{"service:web"}, flushed[0].Tags)
+}
+
 func generateSSFPackets(tb testing.TB, length int) [][]byte {
 	input := make([][]byte, length)
 	for i, _ := range input {
@@ -1383,6 +1896,42 @@ func TestServeStopGRPC(t *testing.T) {
 	}
 }
 
+// Test that servers listening on port 0 report the ports they got, so
+// that tests can run in parallel without picking ports up front.
+func TestServeEphemeralPorts(t *testing.T) {
+	config := globalConfig()
+	config.HTTPAddress = "127.0.0.1:0"
+	config.GrpcAddress = "127.0.0.1:0"
+	config.StatsdListenAddresses = []string{"udp://127.0.0.1:0", "tcp://127.0.0.1:0"}
+	s := setupVeneurServer(t, config, nil, nil, nil, nil)
+	defer s.Shutdown()
+	for _, addr := range s.StatsdListenAddrs {
+		_, port, err := net.SplitHostPort(addr.String())
+		require.NoError(t, err)
+		assert.NotEqual(t, "0", port, "%s should have its actual port", addr.Network())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Serve()
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		return s.HTTPListenAddr() != nil && s.GRPCImportAddr() != nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/healthcheck", s.HTTPListenAddr()))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	conn, err := net.Dial("tcp", s.GRPCImportAddr().String())
+	require.NoError(t, err)
+	conn.Close()
+
+	s.gRPCStop()
+	<-done
+}
+
 type testHTTPStarter interface {
 	isListeningHTTP() bool
 }
@@ -1528,6 +2077,27 @@ func TestWatchdog(t *testing.T) {
 	close(sink.ch)
 }
 
+func TestWatchdogHeldFirstFlush(t *testing.T) {
+	config := localConfig()
+	config.Interval = "10ms"
+	config.SynchronizeWithInterval = true
+	config.SynchronizeHoldPartialWindow = true
+	config.FlushWatchdogMissedFlushes = 1
+	server, err := NewFromConfig(logrus.New(), config)
+	require.NoError(t, err)
+
+	// the server isn't started, so it doesn't flush, as if its first
+	// flush were held
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NotPanics(t, server.FlushWatchdog, "the watchdog shouldn't trigger before the first flush")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(server.shutdown)
+	<-done
+}
+
 func BenchmarkHandleTracePacket(b *testing.B) {
 	const LEN = 1000
 	input := generateSSFPackets(b, LEN)
@@ -1565,3 +2135,141 @@ func BenchmarkHandleSSF(b *testing.B) {
 		f.server.handleSSF(spans[i%LEN], "packet", SSF_UNIX)
 	}
 }
+
+func TestBackfill(t *testing.T) {
+	now := time.Unix(10015, 0)
+	s := &Server{interval: 10 * time.Second, maxBackfillAge: time.Hour}
+	tests := []struct {
+		name      string
+		typ       string
+		timestamp int64
+		keep      bool
+		want      int64
+	}{
+		{"backfilled counter", "counter", 9995, true, 9990},
+		{"backfilled gauge", "gauge", 9990, true, 9990},
+		{"current interval", "counter", 10011, true, 0},
+		{"future", "gauge", 20000, true, 0},
+		{"too old", "counter", 10015 - 3601, false, 0},
+		{"histograms ignore timestamps", "histogram", 9995, true, 0},
+	}
+	for _, elt := range tests {
+		test := elt
+		t.Run(test.name, func(t *testing.T) {
+			m := &samplers.UDPMetric{
+				MetricKey: samplers.MetricKey{Name: "a.b.c", Type: test.typ},
+				Timestamp: test.timestamp,
+			}
+			assert.Equal(t, test.keep, s.backfill(m, now))
+			if test.keep {
+				assert.Equal(t, test.want, m.Timestamp)
+			}
+		})
+	}
+
+	disabled := &Server{interval: 10 * time.Second}
+	m := &samplers.UDPMetric{
+		MetricKey: samplers.MetricKey{Name: "a.b.c", Type: "counter"},
+		Timestamp: 1,
+	}
+	assert.True(t, disabled.backfill(m, now))
+	assert.Equal(t, int64(0), m.Timestamp, "timestamps are ignored unless backfilling is enabled")
+}
+
+func TestIngestQueueSizeInvalid(t *testing.T) {
+	config := localConfig()
+	config.IngestQueueSize = -1
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+}
+
+func TestTCPReadTimeoutConfig(t *testing.T) {
+	tests := []struct {
+		timeout  string
+		expected time.Duration
+	}{
+		{"", defaultTCPReadTimeout},
+		{"30s", 30 * time.Second},
+		{"0s", 0},
+	}
+	for _, test := range tests {
+		config := localConfig()
+		config.TCPReadTimeout = test.timeout
+		s, err := NewFromConfig(logrus.New(), config)
+		require.NoError(t, err, "timeout %q", test.timeout)
+		assert.Equal(t, test.expected, s.idleTimeout(), "timeout %q", test.timeout)
+	}
+
+	config := localConfig()
+	config.TCPReadTimeout = "-1s"
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+}
+
+func TestServeHTTPUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "veneur-http")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "http.sock")
+
+	config := globalConfig()
+	config.HTTPAddress = "unix://" + path
+	s := setupVeneurServer(t, config, nil, nil, nil, nil)
+	defer s.Shutdown()
+	go s.HTTPServe()
+	require.Eventually(t, func() bool {
+		return s.HTTPListenAddr() != nil
+	}, 3*time.Second, 10*time.Millisecond)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0666), info.Mode().Perm())
+
+	client := http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://veneur/healthcheck")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestIngest(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	require.NoError(t, global.Ingest(samplers.UDPMetric{
+		MetricKey: samplers.MetricKey{Name: "a.b.c", Type: counterTypeName},
+		Value:     2.0,
+		Tags:      []string{"z:1", "a:2"},
+	}))
+	// the same series, from a packet
+	require.NoError(t, global.HandleMetricPacket([]byte("a.b.c:3|c|#a:2,z:1"), DOGSTATSD_UDP))
+
+	for _, invalid := range []samplers.UDPMetric{
+		{MetricKey: samplers.MetricKey{Type: counterTypeName}, Value: 1.0},
+		{MetricKey: samplers.MetricKey{Name: "a.b.c", Type: "meter"}, Value: 1.0},
+		{MetricKey: samplers.MetricKey{Name: "a.b.c", Type: setTypeName}, Value: 1.0},
+		{MetricKey: samplers.MetricKey{Name: "a.b.c", Type: counterTypeName}, Value: 1.0, SampleRate: 2},
+	} {
+		assert.Error(t, global.Ingest(invalid), "%#v", invalid)
+	}
+
+	for _, w := range global.Workers {
+		w.Sync()
+	}
+	global.Flush(context.Background())
+	results := <-rcv
+	require.Len(t, results, 1)
+	assert.Equal(t, []string{"a:2", "z:1"}, results[0].Tags)
+	assert.Equal(t, 5.0, results[0].Value)
+}
@@ -0,0 +1,35 @@
+package veneur
+
+import (
+	"github.com/segmentio/fasthash/fnv1a"
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+// The ways metrics can be sharded across the workers.
+const (
+	shardBySeries = "series"
+	shardByName   = "name"
+)
+
+// workerFor returns the worker that aggregates the metric. By default,
+// the series of a metric name are spread across the workers by their
+// digest; when sharding by name, all of them go to the same worker, so
+// that each worker can keep the state it has about names, like the
+// number of series of each, to itself.
+func (s *Server) workerFor(m *samplers.UDPMetric) *Worker {
+	h := m.Digest
+	if s.shardByName {
+		h = fnv1a.HashString32(m.Name)
+	}
+	return s.Workers[h%uint32(len(s.Workers))]
+}
+
+// workerRouter hands each metric it ingests to the worker that
+// aggregates it, for the metrics extracted from spans.
+type workerRouter struct {
+	s *Server
+}
+
+func (r workerRouter) IngestUDP(m samplers.UDPMetric) {
+	r.s.workerFor(&m).IngestUDP(m)
+}
@@ -0,0 +1,75 @@
+package veneur
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func TestWorkerForShardsByName(t *testing.T) {
+	config := localConfig()
+	config.NumWorkers = 8
+	config.WorkerSharding = shardByName
+	config.MetricCardinalityLimit = 2
+	s := setupVeneurServer(t, config, nil, nil, nil, nil)
+	defer s.Shutdown()
+	require.Len(t, s.cardinality, 8, "each worker should have its own limiter")
+
+	for _, name := range []string{"a.b.c", "d.e.f", "g.h.i"} {
+		var workers []*Worker
+		for i := 0; i < 10; i++ {
+			metric, err := samplers.ParseMetric([]byte(fmt.Sprintf("%s:1|c|#user:%d", name, i)))
+			require.NoError(t, err)
+			w := s.workerFor(metric)
+			assert.Equal(t, s.cardinality[w.id-1], w.cardinality)
+			workers = append(workers, w)
+		}
+		for _, w := range workers {
+			assert.Equal(t, workers[0], w, "all the series of %s should go to the same worker", name)
+		}
+	}
+}
+
+func TestCardinalityShardedByName(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := globalConfig()
+	// Flush only when the test does
+	config.Interval = "1m"
+	config.NumWorkers = 4
+	config.WorkerSharding = shardByName
+	config.MetricCardinalityLimit = 2
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	for _, name := range []string{"a.b.c", "d.e.f"} {
+		for i := 0; i < 3; i++ {
+			metric, err := samplers.ParseMetric([]byte(fmt.Sprintf("%s:1|c|#user:%d", name, i)))
+			require.NoError(t, err)
+			metric.Scope = samplers.MixedScope
+			global.workerFor(metric).ProcessMetric(metric)
+		}
+	}
+	global.Flush(context.Background())
+	assert.Len(t, <-rcv, 4, "the series past the limit of each name should be dropped")
+
+	assert.Equal(t, []cardinalityEntry{
+		{Metric: "a.b.c", Series: 2, Dropped: 1, TagKey: "user"},
+		{Metric: "d.e.f", Series: 2, Dropped: 1, TagKey: "user"},
+	}, global.topCardinality(10), "the limiters' entries should be merged")
+}
+
+func TestWorkerShardingInvalid(t *testing.T) {
+	config := localConfig()
+	config.WorkerSharding = "tags"
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+}
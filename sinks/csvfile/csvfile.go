@@ -0,0 +1,227 @@
+// Package csvfile provides a metric sink that writes each flush's metrics
+// to CSV (or TSV) files on local disk, for offline analysis.
+package csvfile
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// header is the first row of each file.
+var header = []string{"metric", "type", "value", "tags", "timestamp"}
+
+// MetricSink writes metrics to files in a directory, one row per metric.
+// A new file is started once the current one grows past MaxSize bytes,
+// or is older than MaxAge. Old files are left in place.
+//
+// Flush only queues the metrics; they're written to disk in the
+// background, so that a slow disk doesn't hold up the flush. If the
+// queue is full, the metrics are dropped.
+type MetricSink struct {
+	dir       string
+	delimiter rune
+	maxSize   int64
+	maxAge    time.Duration
+	log       *logrus.Logger
+
+	batches     chan []samplers.InterMetric
+	traceClient *trace.Client
+
+	// These are only used by the goroutine that writes the files.
+	current  *os.File
+	csv      *csv.Writer
+	size     int64
+	opened   time.Time
+	lastName int64
+}
+
+var _ sinks.MetricSink = &MetricSink{}
+
+// Config configures a MetricSink. MaxSize and MaxAge can be zero, so
+// that files aren't rotated by size or by age. Buffer is the number of
+// flushes that can be queued for writing.
+type Config struct {
+	Directory string
+	Delimiter rune
+	MaxSize   int64
+	MaxAge    time.Duration
+	Buffer    int
+}
+
+// NewMetricSink creates a sink that writes to files in the configured
+// directory, which is created if it doesn't exist.
+func NewMetricSink(config Config, log *logrus.Logger) (*MetricSink, error) {
+	if err := os.MkdirAll(config.Directory, 0755); err != nil {
+		return nil, err
+	}
+	if config.Delimiter == 0 {
+		config.Delimiter = ','
+	}
+	if config.Buffer <= 0 {
+		config.Buffer = 1
+	}
+	return &MetricSink{
+		dir:       config.Directory,
+		delimiter: config.Delimiter,
+		maxSize:   config.MaxSize,
+		maxAge:    config.MaxAge,
+		log:       log,
+		batches:   make(chan []samplers.InterMetric, config.Buffer),
+	}, nil
+}
+
+// Name returns the name of this sink.
+func (s *MetricSink) Name() string {
+	return "csvfile"
+}
+
+// Start starts writing the queued metrics to disk.
+func (s *MetricSink) Start(cl *trace.Client) error {
+	s.traceClient = cl
+	go s.write()
+	return nil
+}
+
+// Flush queues the metrics to be written, or drops them if the queue is
+// full.
+func (s *MetricSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	accepted := make([]samplers.InterMetric, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		if sinks.IsAcceptableMetric(metric, s) {
+			accepted = append(accepted, metric)
+		}
+	}
+	if len(accepted) == 0 {
+		return nil
+	}
+
+	select {
+	case s.batches <- accepted:
+	default:
+		metrics.ReportOne(s.traceClient, ssf.Count("sinks.csvfile.dropped_metrics_total", float32(len(accepted)), map[string]string{"sink": s.Name()}))
+		s.log.WithField("metrics", len(accepted)).Warn("Dropping metrics, the CSV file sink can't keep up")
+	}
+	return nil
+}
+
+// FlushOtherSamples is a no-op: only metrics are written to the files.
+func (s *MetricSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+}
+
+func (s *MetricSink) write() {
+	for batch := range s.batches {
+		start := time.Now()
+		err := s.writeBatch(batch, start)
+		tags := map[string]string{"sink": s.Name()}
+		if err != nil {
+			metrics.ReportOne(s.traceClient, ssf.Count("sinks.csvfile.write_errors_total", 1, tags))
+			s.log.WithError(err).WithField("directory", s.dir).Warn("Could not write metrics to a CSV file")
+			continue
+		}
+		metrics.ReportBatch(s.traceClient, []*ssf.SSFSample{
+			ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(start), time.Nanosecond, tags),
+			ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(len(batch)), tags),
+		})
+	}
+}
+
+// writeBatch appends the metrics to the current file, starting a new one
+// first if it's due to be rotated.
+func (s *MetricSink) writeBatch(batch []samplers.InterMetric, now time.Time) error {
+	if s.current != nil && s.rotate(now) {
+		err := s.current.Close()
+		s.current = nil
+		if err != nil {
+			return err
+		}
+	}
+	if s.current == nil {
+		if err := s.open(now); err != nil {
+			return err
+		}
+	}
+
+	for _, metric := range batch {
+		err := s.csv.Write([]string{
+			metric.Name,
+			metricType(metric.Type),
+			strconv.FormatFloat(metric.Value, 'f', -1, 64),
+			strings.Join(metric.Tags, ","),
+			strconv.FormatInt(metric.Timestamp, 10),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	s.csv.Flush()
+	return s.csv.Error()
+}
+
+// rotate returns whether the current file is due to be rotated.
+func (s *MetricSink) rotate(now time.Time) bool {
+	return (s.maxSize > 0 && s.size >= s.maxSize) ||
+		(s.maxAge > 0 && now.Sub(s.opened) >= s.maxAge)
+}
+
+// open starts a new file, named so that it sorts after the ones before
+// it, and writes the header to it.
+func (s *MetricSink) open(now time.Time) error {
+	name := now.UnixNano()
+	if name <= s.lastName {
+		name = s.lastName + 1
+	}
+	ext := ".csv"
+	if s.delimiter == '\t' {
+		ext = ".tsv"
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, fmt.Sprintf("metrics-%020d%s", name, ext)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	s.current = f
+	s.lastName = name
+	s.opened = now
+	s.size = 0
+	s.csv = csv.NewWriter(&countingWriter{w: f, n: &s.size})
+	s.csv.Comma = s.delimiter
+	return s.csv.Write(header)
+}
+
+func metricType(t samplers.MetricType) string {
+	switch t {
+	case samplers.CounterMetric:
+		return "counter"
+	case samplers.GaugeMetric:
+		return "gauge"
+	case samplers.StatusMetric:
+		return "status"
+	}
+	return t.String()
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
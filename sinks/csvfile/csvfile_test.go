@@ -0,0 +1,144 @@
+package csvfile
+
+import (
+	"context"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func testMetrics() []samplers.InterMetric {
+	return []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: 1600000000,
+		Value:     1.5,
+		Tags:      []string{"foo:bar", "baz:quz"},
+		Type:      samplers.CounterMetric,
+	}, {
+		Name:      "d.e.f",
+		Timestamp: 1600000000,
+		Value:     2,
+		Type:      samplers.GaugeMetric,
+	}}
+}
+
+func readFiles(t *testing.T, dir string, delimiter rune) [][][]string {
+	files, err := filepath.Glob(filepath.Join(dir, "metrics-*"))
+	require.NoError(t, err)
+	var contents [][][]string
+	for _, file := range files {
+		f, err := os.Open(file)
+		require.NoError(t, err)
+		r := csv.NewReader(f)
+		r.Comma = delimiter
+		records, err := r.ReadAll()
+		f.Close()
+		require.NoError(t, err)
+		contents = append(contents, records)
+	}
+	return contents
+}
+
+func TestWriteBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csvfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewMetricSink(Config{Directory: dir, Delimiter: '\t'}, logrus.New())
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, s.writeBatch(testMetrics(), now))
+	require.NoError(t, s.writeBatch(testMetrics()[1:], now.Add(time.Hour)))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.tsv"))
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, [][][]string{{
+		header,
+		{"a.b.c", "counter", "1.5", "foo:bar,baz:quz", "1600000000"},
+		{"d.e.f", "gauge", "2", "", "1600000000"},
+		{"d.e.f", "gauge", "2", "", "1600000000"},
+	}}, readFiles(t, dir, '\t'))
+}
+
+func TestRotate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		later  time.Duration
+	}{
+		{"size", Config{MaxSize: 10}, time.Second},
+		{"age", Config{MaxAge: time.Minute}, time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "csvfile")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			test.config.Directory = dir
+			s, err := NewMetricSink(test.config, logrus.New())
+			require.NoError(t, err)
+			now := time.Now()
+			require.NoError(t, s.writeBatch(testMetrics()[:1], now))
+			require.NoError(t, s.writeBatch(testMetrics()[1:], now.Add(test.later)))
+
+			contents := readFiles(t, dir, ',')
+			require.Len(t, contents, 2, "the second batch should start a new file")
+			assert.Equal(t, []string{"a.b.c", "counter", "1.5", "foo:bar,baz:quz", "1600000000"}, contents[0][1])
+			assert.Equal(t, [][]string{header, {"d.e.f", "gauge", "2", "", "1600000000"}}, contents[1])
+		})
+	}
+}
+
+func TestFlushDropsWhenFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csvfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Not started, so that nothing drains the queue.
+	s, err := NewMetricSink(Config{Directory: dir, Buffer: 1}, logrus.New())
+	require.NoError(t, err)
+	start := time.Now()
+	require.NoError(t, s.Flush(context.Background(), testMetrics()))
+	require.NoError(t, s.Flush(context.Background(), testMetrics()[1:]))
+	assert.True(t, time.Since(start) < time.Second, "a full queue shouldn't block the flush")
+
+	require.Len(t, s.batches, 1)
+	assert.Equal(t, testMetrics(), <-s.batches, "the first batch should be queued")
+}
+
+func TestFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csvfile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewMetricSink(Config{Directory: dir}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, s.Start(nil))
+	require.NoError(t, s.Flush(context.Background(), testMetrics()))
+
+	require.Eventually(t, func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.csv"))
+		if len(files) != 1 {
+			return false
+		}
+		data, _ := ioutil.ReadFile(files[0])
+		return strings.Count(string(data), "\n") == 3
+	}, 5*time.Second, 10*time.Millisecond, "the metrics should be written in the background")
+	assert.Equal(t, [][][]string{{
+		header,
+		{"a.b.c", "counter", "1.5", "foo:bar,baz:quz", "1600000000"},
+		{"d.e.f", "gauge", "2", "", "1600000000"},
+	}}, readFiles(t, dir, ','))
+}
@@ -5,8 +5,10 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -44,6 +46,24 @@ type DatadogMetricSink struct {
 	metricNamePrefixDrops           []string
 	excludedTags                    []string
 	excludeTagsPrefixByPrefixMetric map[string][]string
+	metricNameRenames               []MetricNameRename
+	metricNameRenamesDryRun         bool
+	loggedRenamesMtx                sync.Mutex
+	loggedRenames                   map[string]bool
+	countersAsCounts                bool
+}
+
+// maxLoggedRenames bounds the metric names whose dry-run renames are
+// remembered as logged: once there are this many, they are all forgotten
+// and may be logged again.
+const maxLoggedRenames = 100000
+
+// MetricNameRename is a rule that renames the metrics whose names match
+// Pattern, replacing the match with Replacement. Replacement may refer
+// to the pattern's capture groups, as in regexp.Regexp.ReplaceAllString.
+type MetricNameRename struct {
+	Pattern     *regexp.Regexp
+	Replacement string
 }
 
 // DDEvent represents the structure of datadog's undocumented /intake endpoint
@@ -71,6 +91,16 @@ type DDMetric struct {
 	Interval   int32         `json:"interval,omitempty"`
 }
 
+// DDDistribution is the JSON that Datadog wants when posting to the
+// distribution API: each point is a timestamp and a list of samples.
+type DDDistribution struct {
+	Name       string            `json:"metric"`
+	Points     [1][2]interface{} `json:"points"`
+	Tags       []string          `json:"tags,omitempty"`
+	MetricType string            `json:"type"`
+	Hostname   string            `json:"host,omitempty"`
+}
+
 // DDServiceCheck is a representation of the service check.
 type DDServiceCheck struct {
 	Name      string   `json:"check"`
@@ -102,6 +132,20 @@ func (dd *DatadogMetricSink) Name() string {
 	return "datadog"
 }
 
+// AcceptsDistributions marks the sink as one that submits distributions
+// to Datadog's distribution API.
+func (dd *DatadogMetricSink) AcceptsDistributions() {}
+
+var _ sinks.DistributionMetricSink = &DatadogMetricSink{}
+
+// SendCountersAsCounts makes the sink submit counters as Datadog counts
+// over the flush interval, rather than as rates.
+func (dd *DatadogMetricSink) SendCountersAsCounts() {
+	dd.countersAsCounts = true
+}
+
+var _ sinks.CounterRateMetricSink = &DatadogMetricSink{}
+
 // Start sets the sink up.
 func (dd *DatadogMetricSink) Start(cl *trace.Client) error {
 	dd.traceClient = cl
@@ -113,7 +157,26 @@ func (dd *DatadogMetricSink) Flush(ctx context.Context, interMetrics []samplers.
 	span, _ := trace.StartSpanFromContext(ctx, "")
 	defer span.ClientFinish(dd.traceClient)
 
-	ddmetrics, checks := dd.finalizeMetrics(interMetrics)
+	interval := dd.interval
+	if flushInterval, ok := sinks.FlushInterval(ctx); ok {
+		interval = flushInterval.Seconds()
+	}
+	ddmetrics, checks, metricSources, checkSources := dd.finalizeMetricsWithSources(interMetrics, interval)
+	distributions, distributionSources := dd.finalizeDistributions(interMetrics)
+
+	// failed collects the metrics that couldn't be delivered, so that
+	// they can be retried later.
+	var (
+		failedMtx sync.Mutex
+		failed    []samplers.InterMetric
+		flushErr  error
+	)
+	fail := func(sources []samplers.InterMetric, err error) {
+		failedMtx.Lock()
+		defer failedMtx.Unlock()
+		failed = append(failed, sources...)
+		flushErr = err
+	}
 
 	if len(checks) != 0 {
 		// this endpoint is not documented to take an array... but it does
@@ -126,6 +189,21 @@ func (dd *DatadogMetricSink) Flush(ctx context.Context, interMetrics []samplers.
 			dd.log.WithFields(logrus.Fields{
 				"checks":        len(checks),
 				logrus.ErrorKey: err}).Warn("Error flushing checks to Datadog")
+			fail(checkSources, err)
+		}
+	}
+
+	if len(distributions) != 0 {
+		err := vhttp.PostHelperWithEncoding(span.Attach(ctx), dd.HTTPClient, dd.traceClient, http.MethodPost, fmt.Sprintf("%s/api/v1/distribution_points?api_key=%s", dd.DDHostname, dd.APIKey), map[string][]DDDistribution{
+			"series": distributions,
+		}, "flush_distributions", vhttp.EncodingDeflate, map[string]string{"sink": "datadog"}, dd.log)
+		if err == nil {
+			dd.log.WithField("distributions", len(distributions)).Info("Completed flushing distributions to Datadog")
+		} else {
+			dd.log.WithFields(logrus.Fields{
+				"distributions": len(distributions),
+				logrus.ErrorKey: err}).Warn("Error flushing distributions to Datadog")
+			fail(distributionSources, err)
 		}
 	}
 
@@ -137,23 +215,37 @@ func (dd *DatadogMetricSink) Flush(ctx context.Context, interMetrics []samplers.
 	dd.log.WithField("workers", workers).Debug("Worker count chosen")
 	dd.log.WithField("chunkSize", chunkSize).Debug("Chunk size chosen")
 	var wg sync.WaitGroup
+	var flushedBytes int64
 	flushStart := time.Now()
 	for i := 0; i < workers; i++ {
 		chunk := ddmetrics[i*chunkSize:]
+		sources := metricSources[i*chunkSize:]
 		if i < workers-1 {
 			// trim to chunk size unless this is the last one
 			chunk = chunk[:chunkSize]
+			sources = sources[:chunkSize]
 		}
 		wg.Add(1)
-		go dd.flushPart(span.Attach(ctx), chunk, &wg)
+		go func() {
+			defer wg.Done()
+			n, err := dd.flushPart(span.Attach(ctx), chunk)
+			atomic.AddInt64(&flushedBytes, int64(n))
+			if err != nil {
+				fail(sources, err)
+			}
+		}()
 	}
 	wg.Wait()
 	tags := map[string]string{"sink": dd.Name()}
 	span.Add(
 		ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(flushStart), time.Nanosecond, tags),
-		ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(len(ddmetrics)), tags),
+		ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(len(ddmetrics)+len(distributions)), tags),
+		ssf.Histogram(sinks.MetricKeyMetricFlushBytes, float32(flushedBytes), tags),
 	)
 	dd.log.WithField("metrics", len(ddmetrics)).Info("Completed flush to Datadog")
+	if len(failed) > 0 {
+		return &sinks.MetricFlushError{Failed: failed, Err: flushErr}
+	}
 	return nil
 }
 
@@ -253,13 +345,65 @@ func (dd *DatadogMetricSink) SetExcludedTags(excludes []string) {
 	dd.excludedTags = excludes
 }
 
+// SetMetricNameRenames sets the rules used to rename metrics before
+// they are sent to Datadog. Rules are tried in order, and only the first
+// rule that matches a metric's name is applied. If dryRun is true,
+// metrics are not renamed, but the name each metric would get is logged
+// (once per metric name, up to maxLoggedRenames names).
+func (dd *DatadogMetricSink) SetMetricNameRenames(renames []MetricNameRename, dryRun bool) {
+	dd.metricNameRenames = renames
+	dd.metricNameRenamesDryRun = dryRun
+	dd.loggedRenames = map[string]bool{}
+}
+
+// renameMetric returns the name that a metric should be sent to Datadog
+// with.
+func (dd *DatadogMetricSink) renameMetric(name string) string {
+	for _, rename := range dd.metricNameRenames {
+		if !rename.Pattern.MatchString(name) {
+			continue
+		}
+		renamed := rename.Pattern.ReplaceAllString(name, rename.Replacement)
+		if !dd.metricNameRenamesDryRun {
+			return renamed
+		}
+
+		dd.loggedRenamesMtx.Lock()
+		logged := dd.loggedRenames[name]
+		if !logged && len(dd.loggedRenames) >= maxLoggedRenames {
+			dd.loggedRenames = map[string]bool{}
+		}
+		dd.loggedRenames[name] = true
+		dd.loggedRenamesMtx.Unlock()
+		if !logged {
+			dd.log.WithFields(logrus.Fields{
+				"metric":  name,
+				"renamed": renamed,
+				"pattern": rename.Pattern.String(),
+			}).Info("Would rename metric (dry run)")
+		}
+		return name
+	}
+	return name
+}
+
 func (dd *DatadogMetricSink) finalizeMetrics(metrics []samplers.InterMetric) ([]DDMetric, []DDServiceCheck) {
+	ddMetrics, checks, _, _ := dd.finalizeMetricsWithSources(metrics, dd.interval)
+	return ddMetrics, checks
+}
+
+// finalizeMetricsWithSources is like finalizeMetrics, but also returns the
+// metric that each of the Datadog metrics and service checks was made
+// from, in the same order.
+func (dd *DatadogMetricSink) finalizeMetricsWithSources(metrics []samplers.InterMetric, interval float64) ([]DDMetric, []DDServiceCheck, []samplers.InterMetric, []samplers.InterMetric) {
 	ddMetrics := make([]DDMetric, 0, len(metrics))
 	checks := []DDServiceCheck{}
+	var metricSources, checkSources []samplers.InterMetric
 
 METRICLOOP:
 	for _, m := range metrics {
-		if !sinks.IsAcceptableMetric(m, dd) {
+		if !sinks.IsAcceptableMetric(m, dd) || m.Type == samplers.DistributionMetric {
+			// distributions are finalized by finalizeDistributions
 			continue
 		}
 
@@ -269,75 +413,17 @@ METRICLOOP:
 			}
 		}
 
-		// Defensively copy tags since we're gonna mutate it
-		tags := make([]string, 0, len(dd.tags))
+		tags, hostname, devicename := dd.finalizeTags(m)
 
-		// Prepare exclude tags by specific prefix metric
-		var excludeTagsPrefixByPrefixMetric []string
-		if len(dd.excludeTagsPrefixByPrefixMetric) > 0 {
-			for prefixMetric, tags := range dd.excludeTagsPrefixByPrefixMetric {
-				if strings.HasPrefix(m.Name, prefixMetric) {
-					excludeTagsPrefixByPrefixMetric = tags
-					break
-				}
-			}
-		}
-
-		for i := range dd.tags {
-			exclude := false
-			for j := range dd.excludedTags {
-				if strings.HasPrefix(dd.tags[i], dd.excludedTags[j]) {
-					exclude = true
-					break
-				}
-			}
-			if !exclude {
-				tags = append(tags, dd.tags[i])
-			}
-
-		}
-		var hostname, devicename string
-		// Let's look for "magic tags" that override metric fields host and device.
-		for _, tag := range m.Tags {
-			// This overrides hostname
-			if strings.HasPrefix(tag, "host:") {
-				// Override the hostname with the tag, trimming off the prefix.
-				hostname = tag[5:]
-			} else if strings.HasPrefix(tag, "device:") {
-				// Same as above, but device this time
-				devicename = tag[7:]
-			} else {
-				exclude := false
-				for i := range dd.excludedTags {
-					// access excluded tags by index to avoid a string copy
-					if strings.HasPrefix(tag, dd.excludedTags[i]) {
-						exclude = true
-						break
-					}
-
-				}
-
-				for i := range excludeTagsPrefixByPrefixMetric {
-					if strings.HasPrefix(tag, excludeTagsPrefixByPrefixMetric[i]) {
-						exclude = true
-						break
-					}
-				}
-				if !exclude {
-					tags = append(tags, tag)
-				}
-			}
-		}
-
-		if hostname == "" {
-			// No magic tag, set the hostname
-			hostname = dd.hostname
+		name := m.Name
+		if len(dd.metricNameRenames) > 0 {
+			name = dd.renameMetric(name)
 		}
 
 		if m.Type == samplers.StatusMetric {
 			// This is a service check!
 			ret := DDServiceCheck{
-				Name:      m.Name,
+				Name:      name,
 				Message:   m.Message,
 				Timestamp: m.Timestamp,
 				Tags:      tags,
@@ -346,6 +432,7 @@ METRICLOOP:
 			}
 			// Do our last bit of tag housekeeping
 			checks = append(checks, ret)
+			checkSources = append(checkSources, m)
 			continue
 		}
 		metricType := ""
@@ -353,9 +440,14 @@ METRICLOOP:
 
 		switch m.Type {
 		case samplers.CounterMetric:
-			// We convert counters into rates for Datadog
-			metricType = "rate"
-			value = m.Value / dd.interval
+			// We convert counters into rates for Datadog, unless
+			// they're meant to be sent as counts
+			if dd.countersAsCounts {
+				metricType = "count"
+			} else {
+				metricType = "rate"
+				value = m.Value / interval
+			}
 		case samplers.GaugeMetric:
 			metricType = "gauge"
 		default:
@@ -364,7 +456,7 @@ METRICLOOP:
 		}
 
 		ddMetric := DDMetric{
-			Name: m.Name,
+			Name: name,
 			Value: [1][2]float64{
 				[2]float64{
 					float64(m.Timestamp), value,
@@ -372,21 +464,126 @@ METRICLOOP:
 			},
 			Tags:       tags,
 			MetricType: metricType,
-			Interval:   int32(dd.interval),
+			Interval:   int32(interval),
 			Hostname:   hostname,
 			DeviceName: devicename,
 		}
 		ddMetrics = append(ddMetrics, ddMetric)
+		metricSources = append(metricSources, m)
 	}
 
-	return ddMetrics, checks
+	return ddMetrics, checks, metricSources, checkSources
+}
+
+// finalizeDistributions converts the DistributionMetrics into Datadog
+// distributions, and also returns the metric that each was made from.
+func (dd *DatadogMetricSink) finalizeDistributions(metrics []samplers.InterMetric) ([]DDDistribution, []samplers.InterMetric) {
+	var distributions []DDDistribution
+	var sources []samplers.InterMetric
+
+METRICLOOP:
+	for _, m := range metrics {
+		if m.Type != samplers.DistributionMetric || !sinks.IsAcceptableMetric(m, dd) {
+			continue
+		}
+		for _, dropMetricPrefix := range dd.metricNamePrefixDrops {
+			if strings.HasPrefix(m.Name, dropMetricPrefix) {
+				continue METRICLOOP
+			}
+		}
+
+		tags, hostname, _ := dd.finalizeTags(m)
+		name := m.Name
+		if len(dd.metricNameRenames) > 0 {
+			name = dd.renameMetric(name)
+		}
+		distributions = append(distributions, DDDistribution{
+			Name:       name,
+			Points:     [1][2]interface{}{{float64(m.Timestamp), m.Values}},
+			Tags:       tags,
+			MetricType: "distribution",
+			Hostname:   hostname,
+		})
+		sources = append(sources, m)
+	}
+	return distributions, sources
+}
+
+// finalizeTags returns the tags that a metric should be sent to Datadog
+// with, and the host and device that its magic tags set.
+func (dd *DatadogMetricSink) finalizeTags(m samplers.InterMetric) (tags []string, hostname, devicename string) {
+	// Defensively copy tags since we're gonna mutate it
+	tags = make([]string, 0, len(dd.tags))
+
+	// Prepare exclude tags by specific prefix metric
+	var excludeTagsPrefixByPrefixMetric []string
+	if len(dd.excludeTagsPrefixByPrefixMetric) > 0 {
+		for prefixMetric, tags := range dd.excludeTagsPrefixByPrefixMetric {
+			if strings.HasPrefix(m.Name, prefixMetric) {
+				excludeTagsPrefixByPrefixMetric = tags
+				break
+			}
+		}
+	}
+
+	for i := range dd.tags {
+		exclude := false
+		for j := range dd.excludedTags {
+			if strings.HasPrefix(dd.tags[i], dd.excludedTags[j]) {
+				exclude = true
+				break
+			}
+		}
+		if !exclude {
+			tags = append(tags, dd.tags[i])
+		}
+
+	}
+	// Let's look for "magic tags" that override metric fields host and device.
+	for _, tag := range m.Tags {
+		// This overrides hostname
+		if strings.HasPrefix(tag, "host:") {
+			// Override the hostname with the tag, trimming off the prefix.
+			hostname = tag[5:]
+		} else if strings.HasPrefix(tag, "device:") {
+			// Same as above, but device this time
+			devicename = tag[7:]
+		} else {
+			exclude := false
+			for i := range dd.excludedTags {
+				// access excluded tags by index to avoid a string copy
+				if strings.HasPrefix(tag, dd.excludedTags[i]) {
+					exclude = true
+					break
+				}
+
+			}
+
+			for i := range excludeTagsPrefixByPrefixMetric {
+				if strings.HasPrefix(tag, excludeTagsPrefixByPrefixMetric[i]) {
+					exclude = true
+					break
+				}
+			}
+			if !exclude {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	if hostname == "" {
+		// No magic tag, set the hostname
+		hostname = dd.hostname
+	}
+	return tags, hostname, devicename
 }
 
-func (dd *DatadogMetricSink) flushPart(ctx context.Context, metricSlice []DDMetric, wg *sync.WaitGroup) {
-	defer wg.Done()
-	vhttp.PostHelper(ctx, dd.HTTPClient, dd.traceClient, http.MethodPost, fmt.Sprintf("%s/api/v1/series?api_key=%s", dd.DDHostname, dd.APIKey), map[string][]DDMetric{
+// flushPart posts a chunk of metrics, and returns the size of the body
+// that it sent.
+func (dd *DatadogMetricSink) flushPart(ctx context.Context, metricSlice []DDMetric) (int, error) {
+	return vhttp.PostHelperSize(ctx, dd.HTTPClient, dd.traceClient, http.MethodPost, fmt.Sprintf("%s/api/v1/series?api_key=%s", dd.DDHostname, dd.APIKey), map[string][]DDMetric{
 		"series": metricSlice,
-	}, "flush", true, map[string]string{"sink": "datadog"}, dd.log)
+	}, "flush", vhttp.EncodingDeflate, map[string]string{"sink": "datadog"}, dd.log)
 }
 
 // DatadogTraceSpan represents a trace span as JSON for the
@@ -4,10 +4,14 @@ import (
 	"compress/zlib"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,7 +20,9 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/v14/protocol/dogstatsd"
 	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
 	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
 )
 
 // DDMetricsRequest represents the body of the POST request
@@ -50,6 +56,11 @@ func TestDatadogRate(t *testing.T) {
 	assert.Empty(t, serviceChecks, "No service check metrics are reported")
 	assert.Equal(t, "rate", ddMetrics[0].MetricType, "Metric type should be rate")
 	assert.Equal(t, float64(1.0), ddMetrics[0].Value[0][1], "Metric rate wasnt computed correctly")
+
+	ddSink.SendCountersAsCounts()
+	ddMetrics, _ = ddSink.finalizeMetrics(metrics)
+	assert.Equal(t, "count", ddMetrics[0].MetricType)
+	assert.Equal(t, float64(10), ddMetrics[0].Value[0][1], "Counts shouldn't be divided by the interval")
 }
 
 func TestServerTags(t *testing.T) {
@@ -497,3 +508,210 @@ func TestDataDogDropTagsByMetricPrefix(t *testing.T) {
 	}
 
 }
+
+func TestDataDogRenameMetrics(t *testing.T) {
+	ddSink := DatadogMetricSink{log: logrus.New()}
+	ddSink.SetMetricNameRenames([]MetricNameRename{
+		{regexp.MustCompile(`^legacy\.(.*)$`), "$1"},
+		{regexp.MustCompile(`\.count$`), ".total"},
+		{regexp.MustCompile(`^legacy\.`), "never."},
+	}, false)
+
+	interMetrics := []samplers.InterMetric{
+		{Name: "legacy.foo.count", Type: samplers.CounterMetric},
+		{Name: "bar.count", Type: samplers.GaugeMetric},
+		{Name: "baz", Type: samplers.GaugeMetric},
+		{Name: "legacy.check", Type: samplers.StatusMetric},
+	}
+
+	ddMetrics, serviceChecks := ddSink.finalizeMetrics(interMetrics)
+	require.Len(t, ddMetrics, 3)
+	assert.Equal(t, "foo.count", ddMetrics[0].Name, "only the first matching rule should apply")
+	assert.Equal(t, "bar.total", ddMetrics[1].Name)
+	assert.Equal(t, "baz", ddMetrics[2].Name)
+	require.Len(t, serviceChecks, 1)
+	assert.Equal(t, "check", serviceChecks[0].Name)
+
+	// a dry run leaves the names alone:
+	ddSink.SetMetricNameRenames(ddSink.metricNameRenames, true)
+	ddMetrics, _ = ddSink.finalizeMetrics(interMetrics)
+	require.Len(t, ddMetrics, 3)
+	assert.Equal(t, "legacy.foo.count", ddMetrics[0].Name)
+	assert.Equal(t, "bar.count", ddMetrics[1].Name)
+	assert.True(t, ddSink.loggedRenames["legacy.foo.count"])
+	assert.False(t, ddSink.loggedRenames["baz"])
+}
+
+func TestDataDogRenameMetricsDryRunBounded(t *testing.T) {
+	ddSink := DatadogMetricSink{log: logrus.New()}
+	ddSink.log.SetOutput(ioutil.Discard)
+	ddSink.SetMetricNameRenames([]MetricNameRename{
+		{regexp.MustCompile(`^legacy\.(.*)$`), "$1"},
+	}, true)
+
+	for i := 0; i < maxLoggedRenames+10; i++ {
+		assert.Equal(t, fmt.Sprintf("legacy.%d", i), ddSink.renameMetric(fmt.Sprintf("legacy.%d", i)))
+	}
+	assert.True(t, len(ddSink.loggedRenames) <= maxLoggedRenames, "remembered %d renames", len(ddSink.loggedRenames))
+	assert.True(t, ddSink.loggedRenames[fmt.Sprintf("legacy.%d", maxLoggedRenames+9)])
+}
+
+func TestDatadogFlushReportsFailedMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := zlib.NewReader(r.Body)
+		require.NoError(t, err)
+		body, _ := ioutil.ReadAll(zr)
+		if strings.Contains(string(body), "fail.me") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	ddSink := DatadogMetricSink{
+		DDHostname:      srv.URL,
+		HTTPClient:      &http.Client{},
+		flushMaxPerBody: 1,
+		log:             logrus.New(),
+		interval:        10,
+	}
+	failing := samplers.InterMetric{
+		Name:      "fail.me",
+		Timestamp: time.Now().Unix(),
+		Value:     1,
+		Type:      samplers.GaugeMetric,
+	}
+	err := ddSink.Flush(context.Background(), []samplers.InterMetric{failing, {
+		Name:      "ok.me",
+		Timestamp: time.Now().Unix(),
+		Value:     1,
+		Type:      samplers.GaugeMetric,
+	}})
+	require.Error(t, err)
+	flushErr, ok := err.(*sinks.MetricFlushError)
+	require.True(t, ok, "the error should report the failed metrics, but was %v", err)
+	assert.Equal(t, []samplers.InterMetric{failing}, flushErr.Failed)
+}
+
+func TestDatadogFlushReportsBytes(t *testing.T) {
+	var received int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		atomic.AddInt64(&received, int64(len(body)))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	ch := make(chan *ssf.SSFSpan, 20)
+	cl, err := trace.NewChannelClient(ch)
+	require.NoError(t, err)
+	defer cl.Close()
+	ddSink := DatadogMetricSink{
+		DDHostname:      srv.URL,
+		HTTPClient:      &http.Client{},
+		flushMaxPerBody: 1,
+		log:             logrus.New(),
+		interval:        10,
+		traceClient:     cl,
+	}
+	var metrics []samplers.InterMetric
+	for _, name := range []string{"a.b.c", "d.e.f"} {
+		metrics = append(metrics, samplers.InterMetric{
+			Name:      name,
+			Timestamp: time.Now().Unix(),
+			Value:     1,
+			Type:      samplers.GaugeMetric,
+		})
+	}
+	require.NoError(t, ddSink.Flush(context.Background(), metrics))
+
+	var flushed *ssf.SSFSample
+	for len(ch) > 0 {
+		for _, sample := range (<-ch).Metrics {
+			if sample.Name == sinks.MetricKeyMetricFlushBytes {
+				flushed = sample
+			}
+		}
+	}
+	require.NotNil(t, flushed, "the bytes flushed should be reported")
+	assert.Equal(t, "datadog", flushed.Tags["sink"])
+	assert.Equal(t, float32(atomic.LoadInt64(&received)), flushed.Value,
+		"the bytes of both bodies should be reported")
+}
+
+func TestDatadogFlushDistributions(t *testing.T) {
+	var mtx sync.Mutex
+	bodies := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := zlib.NewReader(r.Body)
+		require.NoError(t, err)
+		body, _ := ioutil.ReadAll(zr)
+		mtx.Lock()
+		bodies[r.URL.Path] = body
+		mtx.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	ddSink := DatadogMetricSink{
+		DDHostname:      srv.URL,
+		HTTPClient:      &http.Client{},
+		flushMaxPerBody: 10,
+		hostname:        "example.com",
+		log:             logrus.New(),
+		interval:        10,
+	}
+	require.NoError(t, ddSink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: 1600000000,
+		Values:    []float64{1, 2.5},
+		Tags:      []string{"foo:bar"},
+		Type:      samplers.DistributionMetric,
+	}, {
+		Name:      "d.e.f",
+		Timestamp: 1600000000,
+		Value:     1,
+		Type:      samplers.GaugeMetric,
+	}}))
+
+	assert.JSONEq(t, `{"series": [{
+		"metric": "a.b.c",
+		"points": [[1600000000, [1, 2.5]]],
+		"tags": ["foo:bar"],
+		"type": "distribution",
+		"host": "example.com"
+	}]}`, string(bodies["/api/v1/distribution_points"]))
+	assert.NotContains(t, string(bodies["/api/v1/series"]), "a.b.c", "distributions shouldn't be sent as series")
+	assert.Contains(t, string(bodies["/api/v1/series"]), "d.e.f")
+}
+
+func TestDatadogFlushIntervalRates(t *testing.T) {
+	ddSink := DatadogMetricSink{
+		hostname: "example.com",
+		log:      logrus.New(),
+		interval: 10,
+	}
+	counter := []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: 1600000000,
+		Value:     60,
+		Type:      samplers.CounterMetric,
+	}}
+
+	ddMetrics, _, _, _ := ddSink.finalizeMetricsWithSources(counter, 10)
+	require.Len(t, ddMetrics, 1)
+	assert.Equal(t, 6.0, ddMetrics[0].Value[0][1])
+	assert.Equal(t, int32(10), ddMetrics[0].Interval)
+
+	ddMetrics, _, _, _ = ddSink.finalizeMetricsWithSources(counter, time.Minute.Seconds())
+	require.Len(t, ddMetrics, 1)
+	assert.Equal(t, 1.0, ddMetrics[0].Value[0][1], "counters should be divided by the flush group's interval")
+	assert.Equal(t, int32(60), ddMetrics[0].Interval)
+
+	interval, ok := sinks.FlushInterval(sinks.WithFlushInterval(context.Background(), time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, interval)
+	_, ok = sinks.FlushInterval(context.Background())
+	assert.False(t, ok)
+}
@@ -0,0 +1,71 @@
+package deadletter
+
+import (
+	"sort"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks/deadletter/deadletterpb"
+)
+
+// Batch is a batch of metrics that a metric sink failed to deliver. It's
+// stored as a deadletterpb.Batch.
+type Batch struct {
+	// Sink is the name of the sink that failed to deliver the metrics.
+	Sink    string
+	Metrics []samplers.InterMetric
+}
+
+// Marshal encodes the batch in protobuf wire format.
+func (b *Batch) Marshal() ([]byte, error) {
+	pb := &deadletterpb.Batch{
+		Sink:    b.Sink,
+		Metrics: make([]*deadletterpb.Metric, 0, len(b.Metrics)),
+	}
+	for _, m := range b.Metrics {
+		metric := &deadletterpb.Metric{
+			Name:      m.Name,
+			Timestamp: m.Timestamp,
+			Value:     m.Value,
+			Tags:      m.Tags,
+			Type:      int32(m.Type),
+			Message:   m.Message,
+			HostName:  m.HostName,
+			Values:    m.Values,
+		}
+		for sink := range m.Sinks {
+			metric.Sinks = append(metric.Sinks, sink)
+		}
+		sort.Strings(metric.Sinks)
+		pb.Metrics = append(pb.Metrics, metric)
+	}
+	return pb.Marshal()
+}
+
+// Unmarshal decodes a batch in protobuf wire format.
+func (b *Batch) Unmarshal(data []byte) error {
+	pb := &deadletterpb.Batch{}
+	if err := pb.Unmarshal(data); err != nil {
+		return err
+	}
+	*b = Batch{Sink: pb.Sink}
+	for _, metric := range pb.Metrics {
+		m := samplers.InterMetric{
+			Name:      metric.Name,
+			Timestamp: metric.Timestamp,
+			Value:     metric.Value,
+			Tags:      metric.Tags,
+			Type:      samplers.MetricType(metric.Type),
+			Message:   metric.Message,
+			HostName:  metric.HostName,
+			Values:    metric.Values,
+		}
+		if len(metric.Sinks) > 0 {
+			m.Sinks = samplers.RouteInformation{}
+			for _, sink := range metric.Sinks {
+				m.Sinks[sink] = struct{}{}
+			}
+		}
+		b.Metrics = append(b.Metrics, m)
+	}
+	return nil
+}
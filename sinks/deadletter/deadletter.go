@@ -0,0 +1,204 @@
+// Package deadletter stores batches of metrics that a metric sink failed
+// to deliver on local disk, so that they can be replayed once the sink
+// recovers.
+package deadletter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	filePrefix = "deadletter-"
+	fileSuffix = ".pb"
+
+	// maxBatchSize is the size of the largest record that's read back,
+	// which guards against allocating absurd amounts of memory for a
+	// corrupted length prefix.
+	maxBatchSize = 256 << 20
+)
+
+// Sink writes batches to files in a directory, each as a varint length
+// prefix followed by the batch in protobuf wire format. Batches are
+// appended to the same file until the batches on disk are replayed.
+type Sink struct {
+	dir string
+	log *logrus.Entry
+
+	mtx     sync.Mutex
+	current *os.File
+
+	// replayMtx makes sure that only one replay runs at a time
+	replayMtx sync.Mutex
+}
+
+// ReplayResult counts the batches that were replayed.
+type ReplayResult struct {
+	Batches int `json:"batches"`
+	Metrics int `json:"metrics"`
+	// Failed is the number of batches that couldn't be delivered
+	// again.
+	Failed int `json:"failed"`
+}
+
+// New returns a Sink that stores batches in dir, which is created if it
+// doesn't exist.
+func New(dir string, log *logrus.Logger) (*Sink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Sink{
+		dir: dir,
+		log: log.WithField("dead_letter_directory", dir),
+	}, nil
+}
+
+// Write appends a batch to the current file, and syncs it to disk.
+func (s *Sink) Write(b Batch) error {
+	data, err := b.Marshal()
+	if err != nil {
+		return err
+	}
+	record := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(data))
+	record = append(record[:binary.PutUvarint(record, uint64(len(data)))], data...)
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.current == nil {
+		s.current, err = os.OpenFile(s.fileName(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := s.current.Write(record); err != nil {
+		return err
+	}
+	return s.current.Sync()
+}
+
+// fileName returns the name of a new file, which sorts after the
+// files written before it.
+func (s *Sink) fileName() string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", filePrefix, time.Now().UnixNano(), fileSuffix))
+}
+
+// Replay calls replay with each batch on disk, oldest first, and
+// removes each file once all its batches have been replayed. replay is
+// responsible for writing the batches (or the parts of them) that fail
+// again back to the Sink; they're written to a new file, so they're only
+// retried by the next replay.
+//
+// A file with a truncated or corrupted record, e.g. because Veneur
+// crashed while writing it, is replayed up to that record, and then
+// removed.
+func (s *Sink) Replay(replay func(Batch) error) (ReplayResult, error) {
+	s.replayMtx.Lock()
+	defer s.replayMtx.Unlock()
+
+	// New batches go to a new file from now on.
+	s.mtx.Lock()
+	if s.current != nil {
+		err := s.current.Close()
+		s.current = nil
+		if err != nil {
+			s.mtx.Unlock()
+			return ReplayResult{}, err
+		}
+	}
+	files, err := s.files()
+	s.mtx.Unlock()
+	if err != nil {
+		return ReplayResult{}, err
+	}
+
+	var result ReplayResult
+	for _, file := range files {
+		err := readFile(file, func(b Batch) {
+			result.Batches++
+			result.Metrics += len(b.Metrics)
+			if err := replay(b); err != nil {
+				result.Failed++
+			}
+		})
+		if err != nil {
+			s.log.WithError(err).WithField("file", file).
+				Warn("Dropping the rest of a corrupted dead-letter file")
+		}
+		if err := os.Remove(file); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// files returns the dead-letter files in the directory, oldest first.
+func (s *Sink) files() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Mode().IsRegular() && strings.HasPrefix(name, filePrefix) && strings.HasSuffix(name, fileSuffix) {
+			files = append(files, filepath.Join(s.dir, name))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readFile calls fn with each batch in a file.
+func readFile(file string, fn func(Batch)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		size, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if size > maxBatchSize {
+			return fmt.Errorf("record of %d bytes is too large", size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		var b Batch
+		if err := b.Unmarshal(data); err != nil {
+			return err
+		}
+		fn(b)
+	}
+}
+
+// Close closes the current file.
+func (s *Sink) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current = nil
+	return err
+}
@@ -0,0 +1,119 @@
+package deadletter
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func testBatch(sink string, names ...string) Batch {
+	b := Batch{Sink: sink}
+	for i, name := range names {
+		b.Metrics = append(b.Metrics, samplers.InterMetric{
+			Name:      name,
+			Timestamp: 1600000000 + int64(i),
+			Value:     float64(i) + 0.5,
+			Tags:      []string{"a:b", "c:d"},
+			Type:      samplers.GaugeMetric,
+			Sinks:     samplers.RouteInformation{sink: struct{}{}},
+		})
+	}
+	return b
+}
+
+func TestBatchRoundTrip(t *testing.T) {
+	b := testBatch("datadog", "a.b.c", "d.e.f")
+	b.Metrics = append(b.Metrics, samplers.InterMetric{
+		Name:     "check",
+		Type:     samplers.StatusMetric,
+		Value:    2,
+		Message:  "uh oh",
+		HostName: "example.com",
+	}, samplers.InterMetric{
+		Name:   "distribution",
+		Type:   samplers.DistributionMetric,
+		Values: []float64{1, 2.5},
+	})
+	data, err := b.Marshal()
+	require.NoError(t, err)
+
+	var decoded Batch
+	require.NoError(t, decoded.Unmarshal(data))
+	assert.Equal(t, b, decoded)
+}
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir, logrus.New())
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Write(testBatch("datadog", "a", "b")))
+	require.NoError(t, s.Write(testBatch("signalfx", "c")))
+
+	var replayed []Batch
+	result, err := s.Replay(func(b Batch) error {
+		replayed = append(replayed, b)
+		if b.Sink == "signalfx" {
+			// the metrics that fail again are written back
+			require.NoError(t, s.Write(b))
+			return errors.New("still down")
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ReplayResult{Batches: 2, Metrics: 3, Failed: 1}, result)
+	assert.Equal(t, []Batch{testBatch("datadog", "a", "b"), testBatch("signalfx", "c")}, replayed)
+
+	replayed = nil
+	result, err = s.Replay(func(b Batch) error {
+		replayed = append(replayed, b)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, ReplayResult{Batches: 1, Metrics: 1}, result)
+	assert.Equal(t, []Batch{testBatch("signalfx", "c")}, replayed)
+
+	files, err := s.files()
+	require.NoError(t, err)
+	assert.Empty(t, files, "replayed files should be removed")
+}
+
+func TestReplayTruncated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deadletter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, s.Write(testBatch("datadog", "a")))
+	require.NoError(t, s.Write(testBatch("datadog", "b")))
+	require.NoError(t, s.Close())
+
+	// Cut the last record short, as if Veneur crashed while writing it.
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	info, err := os.Stat(files[0])
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(files[0], info.Size()-3))
+
+	var replayed []Batch
+	result, err := s.Replay(func(b Batch) error {
+		replayed = append(replayed, b)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Batches)
+	assert.Equal(t, []Batch{testBatch("datadog", "a")}, replayed)
+}
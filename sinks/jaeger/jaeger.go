@@ -0,0 +1,195 @@
+// Package jaeger provides a span sink that sends spans to a Jaeger
+// collector over Thrift/HTTP.
+package jaeger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stripe/veneur/v14/protocol"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// DefaultBatchSize is the number of spans sent in a single request to
+// the collector if no batch size is configured.
+const DefaultBatchSize = 500
+
+// DefaultBufferSize is the number of spans held between flushes if no
+// buffer size is configured. Spans that arrive while the buffer is
+// full are dropped.
+const DefaultBufferSize = 1 << 14
+
+// collectorRetries is how many times a request that failed with a
+// network or server error is retried before its spans are dropped.
+const collectorRetries = 3
+
+// collectorBackoff is the delay before the first retry; it doubles
+// with each subsequent one.
+var collectorBackoff = 250 * time.Millisecond
+
+// SpanSink sends spans to a Jaeger collector's HTTP endpoint (usually
+// http://jaeger-collector:14268/api/traces).
+//
+// Spans are buffered until the next flush, and then sent in batches
+// grouped by service, which becomes the Jaeger process's service name.
+// SSF trace and span IDs are used as-is (as the low 64 bits of the
+// Jaeger trace ID), span tags become string tags, and spans that are
+// errors get the boolean "error" tag. Veneur's common tags are sent as
+// tags of the process.
+type SpanSink struct {
+	addr        string
+	batchSize   int
+	bufferSize  int
+	processTags []tag
+	httpClient  *http.Client
+	log         *logrus.Logger
+	traceClient *trace.Client
+
+	mtx    sync.Mutex
+	buffer []*ssf.SSFSpan
+
+	spansDropped int64
+}
+
+var _ sinks.SpanSink = &SpanSink{}
+
+// NewSpanSink returns a sink that sends spans to the Jaeger collector
+// at addr, in requests of at most batchSize spans, holding at most
+// bufferSize spans between flushes.
+func NewSpanSink(addr string, batchSize int, bufferSize int, commonTags map[string]string, httpClient *http.Client, log *logrus.Logger) (*SpanSink, error) {
+	if _, err := url.ParseRequestURI(addr); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	return &SpanSink{
+		addr:        addr,
+		batchSize:   batchSize,
+		bufferSize:  bufferSize,
+		processTags: sortedTags(commonTags),
+		httpClient:  httpClient,
+		log:         log,
+	}, nil
+}
+
+// Name returns the name of this sink.
+func (s *SpanSink) Name() string {
+	return "jaeger"
+}
+
+// Start begins the sink.
+func (s *SpanSink) Start(cl *trace.Client) error {
+	s.traceClient = cl
+	return nil
+}
+
+// Ingest buffers a span until the next flush.
+func (s *SpanSink) Ingest(span *ssf.SSFSpan) error {
+	if err := protocol.ValidateTrace(span); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.buffer) >= s.bufferSize {
+		atomic.AddInt64(&s.spansDropped, 1)
+		return nil
+	}
+	s.buffer = append(s.buffer, span)
+	return nil
+}
+
+// Flush sends the buffered spans to the collector.
+func (s *SpanSink) Flush() {
+	s.mtx.Lock()
+	spans := s.buffer
+	s.buffer = make([]*ssf.SSFSpan, 0, len(spans))
+	s.mtx.Unlock()
+
+	flushStart := time.Now()
+	byService := map[string][]*ssf.SSFSpan{}
+	for _, span := range spans {
+		byService[span.Service] = append(byService[span.Service], span)
+	}
+
+	flushed := 0
+	for service, spans := range byService {
+		for i := 0; i < len(spans); i += s.batchSize {
+			end := i + s.batchSize
+			if end > len(spans) {
+				end = len(spans)
+			}
+
+			err := s.send(context.TODO(), encodeBatch(service, s.processTags, spans[i:end]))
+			if err != nil {
+				atomic.AddInt64(&s.spansDropped, int64(end-i))
+				s.log.WithFields(logrus.Fields{
+					logrus.ErrorKey: err,
+					"service":       service,
+					"spans":         end - i,
+				}).Warn("Failed to send spans to Jaeger")
+				continue
+			}
+			flushed += end - i
+		}
+	}
+
+	tags := map[string]string{"sink": s.Name()}
+	metrics.ReportBatch(s.traceClient, []*ssf.SSFSample{
+		ssf.Timing(sinks.MetricKeySpanFlushDuration, time.Since(flushStart), time.Nanosecond, tags),
+		ssf.Count(sinks.MetricKeyTotalSpansFlushed, float32(flushed), tags),
+		ssf.Count(sinks.MetricKeyTotalSpansDropped, float32(atomic.SwapInt64(&s.spansDropped, 0)), tags),
+	})
+}
+
+// send posts a serialized batch, retrying with exponential backoff if
+// the request fails with a network error or a 5xx status.
+func (s *SpanSink) send(ctx context.Context, batch []byte) error {
+	return sinks.Retry(ctx, collectorRetries, collectorBackoff, func() (bool, error) {
+		return s.post(ctx, batch)
+	})
+}
+
+// post makes a single request to the collector, and reports whether a
+// failed request may succeed if it is retried.
+func (s *SpanSink) post(ctx context.Context, batch []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.addr, bytes.NewReader(batch))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-thrift")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("jaeger collector responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	return resp.StatusCode/100 == 5, err
+}
@@ -0,0 +1,231 @@
+package jaeger
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+)
+
+// thriftStructFields decodes a Thrift binary struct into its fields by
+// ID, and returns the remaining bytes. Nested structs decode to maps,
+// and lists to slices.
+func thriftStructFields(t *testing.T, b []byte) (map[int16]interface{}, []byte) {
+	fields := map[int16]interface{}{}
+	for {
+		require.NotEmpty(t, b, "truncated struct")
+		typ := b[0]
+		if typ == thriftStop {
+			return fields, b[1:]
+		}
+		id := int16(binary.BigEndian.Uint16(b[1:3]))
+		fields[id], b = thriftValue(t, typ, b[3:])
+	}
+}
+
+func thriftValue(t *testing.T, typ byte, b []byte) (interface{}, []byte) {
+	switch typ {
+	case thriftBool:
+		return b[0] == 1, b[1:]
+	case thriftI32:
+		return int32(binary.BigEndian.Uint32(b)), b[4:]
+	case thriftI64:
+		return int64(binary.BigEndian.Uint64(b)), b[8:]
+	case thriftString:
+		n := binary.BigEndian.Uint32(b)
+		return string(b[4 : 4+n]), b[4+n:]
+	case thriftStruct:
+		return thriftStructFields(t, b)
+	case thriftList:
+		elemType, n := b[0], int(binary.BigEndian.Uint32(b[1:5]))
+		b = b[5:]
+		list := make([]interface{}, n)
+		for i := range list {
+			list[i], b = thriftValue(t, elemType, b)
+		}
+		return list, b
+	}
+	t.Fatalf("unexpected thrift type %d", typ)
+	return nil, nil
+}
+
+// decodeTags turns a list of Jaeger tags into a map of their values.
+func decodeTags(tags interface{}) map[string]interface{} {
+	ret := map[string]interface{}{}
+	list, _ := tags.([]interface{})
+	for _, elt := range list {
+		tag := elt.(map[int16]interface{})
+		switch tag[2].(int32) {
+		case tagTypeString:
+			ret[tag[1].(string)] = tag[3]
+		case tagTypeBool:
+			ret[tag[1].(string)] = tag[5]
+		}
+	}
+	return ret
+}
+
+type testBatch struct {
+	service     string
+	processTags map[string]interface{}
+	spans       []map[int16]interface{}
+}
+
+func decodeBatch(t *testing.T, b []byte) testBatch {
+	batch, rest := thriftStructFields(t, b)
+	require.Empty(t, rest)
+	process := batch[1].(map[int16]interface{})
+	ret := testBatch{
+		service:     process[1].(string),
+		processTags: decodeTags(process[2]),
+	}
+	for _, span := range batch[2].([]interface{}) {
+		ret.spans = append(ret.spans, span.(map[int16]interface{}))
+	}
+	return ret
+}
+
+func testSpan(traceID, id, parentID int64, service string) *ssf.SSFSpan {
+	start := time.Unix(1600000000, 0)
+	return &ssf.SSFSpan{
+		TraceId:        traceID,
+		Id:             id,
+		ParentId:       parentID,
+		Service:        service,
+		Name:           "request",
+		StartTimestamp: start.UnixNano(),
+		EndTimestamp:   start.Add(1500 * time.Microsecond).UnixNano(),
+		Tags:           map[string]string{"foo": "bar"},
+	}
+}
+
+func TestSpanSinkFlush(t *testing.T) {
+	var batches []testBatch
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-thrift", r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		batches = append(batches, decodeBatch(t, body))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	sink, err := NewSpanSink(srv.URL, 2, 0, map[string]string{"env": "test"}, srv.Client(), logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	failed := testSpan(1, 2, 1, "frontend")
+	failed.Error = true
+	failed.Tags["error"] = "timeout"
+	indicator := testSpan(1, 1, 0, "frontend")
+	indicator.Indicator = true
+	for _, span := range []*ssf.SSFSpan{indicator, failed, testSpan(1, 3, 2, "frontend"), testSpan(1, 4, 2, "backend")} {
+		require.NoError(t, sink.Ingest(span))
+	}
+	assert.Error(t, sink.Ingest(&ssf.SSFSpan{}), "invalid spans should be rejected")
+	sink.Flush()
+
+	require.Len(t, batches, 3, "frontend's spans should be split into two batches")
+	sort.SliceStable(batches, func(i, j int) bool { return batches[i].service < batches[j].service })
+	assert.Equal(t, "backend", batches[0].service)
+	assert.Equal(t, map[string]interface{}{"env": "test"}, batches[0].processTags)
+	assert.Len(t, batches[0].spans, 1)
+	frontend := append(batches[1].spans, batches[2].spans...)
+	require.Len(t, frontend, 3)
+	sort.Slice(frontend, func(i, j int) bool { return frontend[i][3].(int64) < frontend[j][3].(int64) })
+
+	root := frontend[0]
+	assert.Equal(t, int64(1), root[1], "traceIdLow")
+	assert.Equal(t, int64(0), root[2], "traceIdHigh")
+	assert.Equal(t, int64(1), root[3], "spanId")
+	assert.Equal(t, int64(0), root[4], "parentSpanId")
+	assert.Equal(t, "request", root[5], "operationName")
+	assert.Equal(t, int32(spanFlagSampled), root[7], "flags")
+	assert.Equal(t, time.Unix(1600000000, 0).UnixNano()/1e3, root[8], "startTime")
+	assert.Equal(t, int64(1500), root[9], "duration")
+	assert.Equal(t, map[string]interface{}{"foo": "bar", "indicator": true}, decodeTags(root[10]))
+
+	child := frontend[1]
+	assert.Equal(t, int64(1), child[4], "parentSpanId")
+	assert.Equal(t, map[string]interface{}{"foo": "bar", "error": true}, decodeTags(child[10]))
+}
+
+func TestSpanSinkRetries(t *testing.T) {
+	defer func(backoff time.Duration) { collectorBackoff = backoff }(collectorBackoff)
+	collectorBackoff = time.Millisecond
+
+	// server errors are retried, but client errors aren't
+	statuses := []int{http.StatusServiceUnavailable, http.StatusBadRequest, http.StatusAccepted}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[requests])
+		requests++
+	}))
+	defer srv.Close()
+
+	ch := make(chan *ssf.SSFSpan, 1)
+	cl, err := trace.NewChannelClient(ch)
+	require.NoError(t, err)
+	defer cl.Close()
+	sink, err := NewSpanSink(srv.URL, 0, 0, nil, srv.Client(), logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(cl))
+
+	require.NoError(t, sink.Ingest(testSpan(1, 1, 0, "frontend")))
+	require.NoError(t, sink.Ingest(testSpan(1, 2, 1, "frontend")))
+	sink.Flush()
+	assert.Equal(t, 2, requests)
+
+	found := false
+	for _, sample := range (<-ch).Metrics {
+		if sample.Name == sinks.MetricKeyTotalSpansDropped {
+			found = true
+			assert.Equal(t, float32(2), sample.Value)
+		}
+	}
+	assert.True(t, found, "should report the dropped spans")
+}
+
+func TestSpanSinkBufferFull(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Len(t, decodeBatch(t, body).spans, 1)
+		requests++
+	}))
+	defer srv.Close()
+
+	ch := make(chan *ssf.SSFSpan, 1)
+	cl, err := trace.NewChannelClient(ch)
+	require.NoError(t, err)
+	defer cl.Close()
+	sink, err := NewSpanSink(srv.URL, 0, 1, nil, srv.Client(), logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(cl))
+
+	require.NoError(t, sink.Ingest(testSpan(1, 1, 0, "frontend")))
+	require.NoError(t, sink.Ingest(testSpan(1, 2, 1, "frontend")))
+	sink.Flush()
+	assert.Equal(t, 1, requests)
+
+	found := false
+	for _, sample := range (<-ch).Metrics {
+		if sample.Name == sinks.MetricKeyTotalSpansDropped {
+			found = true
+			assert.Equal(t, float32(1), sample.Value)
+		}
+	}
+	assert.True(t, found, "should report the dropped span")
+}
@@ -0,0 +1,177 @@
+package jaeger
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/stripe/veneur/v14/ssf"
+)
+
+// Thrift binary protocol type IDs, as defined in
+// https://github.com/apache/thrift/blob/master/doc/specs/thrift-binary-protocol.md
+const (
+	thriftStop   = 0
+	thriftBool   = 2
+	thriftI32    = 8
+	thriftI64    = 10
+	thriftString = 11
+	thriftStruct = 12
+	thriftList   = 15
+)
+
+// Jaeger tag value types (the TagType enum).
+const (
+	tagTypeString = 0
+	tagTypeBool   = 2
+)
+
+// spanFlagSampled marks a span as sampled, so that the collector
+// stores it.
+const spanFlagSampled = 1
+
+// tag is a Jaeger tag with either a string or a boolean value.
+type tag struct {
+	key   string
+	str   string
+	bool  bool
+	isStr bool
+}
+
+func stringTag(key, value string) tag {
+	return tag{key: key, str: value, isStr: true}
+}
+
+func boolTag(key string, value bool) tag {
+	return tag{key: key, bool: value}
+}
+
+// sortedTags converts tags to Jaeger string tags, sorted by key.
+func sortedTags(tags map[string]string) []tag {
+	ret := make([]tag, 0, len(tags))
+	for k, v := range tags {
+		ret = append(ret, stringTag(k, v))
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].key < ret[j].key })
+	return ret
+}
+
+// spanTags returns the Jaeger tags of an SSF span. SSF's error flag
+// becomes the boolean "error" tag that the Jaeger UI highlights, and
+// takes precedence over an "error" tag on the span; indicator spans are
+// tagged as such.
+func spanTags(span *ssf.SSFSpan) []tag {
+	tags := make([]tag, 0, len(span.Tags)+2)
+	for _, t := range sortedTags(span.Tags) {
+		if t.key == "error" && span.Error {
+			continue
+		}
+		tags = append(tags, t)
+	}
+	if span.Error {
+		tags = append(tags, boolTag("error", true))
+	}
+	if span.Indicator {
+		tags = append(tags, boolTag("indicator", true))
+	}
+	return tags
+}
+
+// encodeBatch serializes spans, which must all belong to the same
+// service, as a Jaeger Batch in the Thrift binary protocol, as defined
+// in https://github.com/jaegertracing/jaeger-idl/blob/master/thrift/jaeger.thrift
+func encodeBatch(service string, processTags []tag, spans []*ssf.SSFSpan) []byte {
+	// Batch: 1: required Process process; 2: required list<Span> spans
+	b := appendFieldHeader(nil, thriftStruct, 1)
+	// Process: 1: required string serviceName; 2: optional list<Tag> tags
+	b = appendString(appendFieldHeader(b, thriftString, 1), service)
+	if len(processTags) > 0 {
+		b = appendTags(appendFieldHeader(b, thriftList, 2), processTags)
+	}
+	b = append(b, thriftStop)
+
+	b = appendListHeader(appendFieldHeader(b, thriftList, 2), thriftStruct, len(spans))
+	for _, span := range spans {
+		b = appendSpan(b, span)
+	}
+	return append(b, thriftStop)
+}
+
+// appendSpan appends a Span struct. Jaeger's timestamps and durations
+// are in microseconds, while SSF's are in nanoseconds.
+func appendSpan(b []byte, span *ssf.SSFSpan) []byte {
+	parentID := span.ParentId
+	if parentID < 0 {
+		parentID = 0
+	}
+	name := span.Name
+	if name == "" {
+		name = "unknown"
+	}
+
+	// 1: traceIdLow; 2: traceIdHigh; 3: spanId; 4: parentSpanId
+	b = appendI64(appendFieldHeader(b, thriftI64, 1), span.TraceId)
+	b = appendI64(appendFieldHeader(b, thriftI64, 2), 0)
+	b = appendI64(appendFieldHeader(b, thriftI64, 3), span.Id)
+	b = appendI64(appendFieldHeader(b, thriftI64, 4), parentID)
+	// 5: operationName; 7: flags; 8: startTime; 9: duration
+	b = appendString(appendFieldHeader(b, thriftString, 5), name)
+	b = appendI32(appendFieldHeader(b, thriftI32, 7), spanFlagSampled)
+	b = appendI64(appendFieldHeader(b, thriftI64, 8), span.StartTimestamp/1e3)
+	b = appendI64(appendFieldHeader(b, thriftI64, 9), (span.EndTimestamp-span.StartTimestamp)/1e3)
+	// 10: optional list<Tag> tags
+	if tags := spanTags(span); len(tags) > 0 {
+		b = appendTags(appendFieldHeader(b, thriftList, 10), tags)
+	}
+	return append(b, thriftStop)
+}
+
+// appendTags appends a list of Tag structs: 1: required string key;
+// 2: required TagType vType; 3: optional string vStr;
+// 5: optional bool vBool.
+func appendTags(b []byte, tags []tag) []byte {
+	b = appendListHeader(b, thriftStruct, len(tags))
+	for _, t := range tags {
+		b = appendString(appendFieldHeader(b, thriftString, 1), t.key)
+		if t.isStr {
+			b = appendI32(appendFieldHeader(b, thriftI32, 2), tagTypeString)
+			b = appendString(appendFieldHeader(b, thriftString, 3), t.str)
+		} else {
+			b = appendI32(appendFieldHeader(b, thriftI32, 2), tagTypeBool)
+			b = appendBool(appendFieldHeader(b, thriftBool, 5), t.bool)
+		}
+		b = append(b, thriftStop)
+	}
+	return b
+}
+
+func appendFieldHeader(b []byte, typ byte, id int16) []byte {
+	return append(b, typ, byte(uint16(id)>>8), byte(id))
+}
+
+func appendListHeader(b []byte, elemType byte, size int) []byte {
+	return appendI32(append(b, elemType), int32(size))
+}
+
+func appendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+func appendI32(b []byte, v int32) []byte {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], uint32(v))
+	return append(b, tmp[:]...)
+}
+
+func appendI64(b []byte, v int64) []byte {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], uint64(v))
+	return append(b, tmp[:]...)
+}
+
+func appendString(b []byte, v string) []byte {
+	b = appendI32(b, int32(len(v)))
+	return append(b, v...)
+}
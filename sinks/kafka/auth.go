@@ -0,0 +1,85 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+)
+
+// SASLPlain is the name of the SASL/PLAIN mechanism, which sends the user
+// and password to the broker as they are, so it should be combined with
+// TLS.
+const SASLPlain = "PLAIN"
+
+// Auth is how the Kafka sinks authenticate to the brokers. The zero
+// value connects over plaintext without authenticating.
+type Auth struct {
+	// SASLMechanism is the SASL mechanism to authenticate with, or empty
+	// to not use SASL.
+	SASLMechanism string
+	SASLUser      string
+	SASLPassword  string
+
+	// TLS, if set, is the configuration of the TLS connections to the
+	// brokers, including the client certificate if they require one.
+	TLS *tls.Config
+}
+
+// Validate checks that the SASL mechanism is supported and that it has
+// credentials.
+func (a Auth) Validate() error {
+	switch a.SASLMechanism {
+	case "":
+		if a.SASLUser != "" || a.SASLPassword != "" {
+			return errors.New("SASL credentials are set, but no SASL mechanism is")
+		}
+		return nil
+	case SASLPlain, SASLSCRAMSHA256, SASLSCRAMSHA512:
+	default:
+		return fmt.Errorf("Unknown SASL mechanism %q, must be one of %s, %s or %s", a.SASLMechanism, SASLPlain, SASLSCRAMSHA256, SASLSCRAMSHA512)
+	}
+	if a.SASLUser == "" || a.SASLPassword == "" {
+		return fmt.Errorf("SASL mechanism %s requires a user and a password", a.SASLMechanism)
+	}
+	return nil
+}
+
+// apply sets up config to authenticate with a.
+func (a Auth) apply(config *sarama.Config) error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+	if a.SASLMechanism != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLMechanism(a.SASLMechanism)
+		config.Net.SASL.User = a.SASLUser
+		config.Net.SASL.Password = a.SASLPassword
+	}
+	if a.SASLMechanism == SASLSCRAMSHA256 || a.SASLMechanism == SASLSCRAMSHA512 {
+		config.Net.SASL.SCRAMClientGeneratorFunc = newSCRAMClientGenerator(a.SASLMechanism)
+		// the brokers only take SCRAM exchanges in SaslAuthenticate
+		// requests since Kafka 1.0
+		if !config.Version.IsAtLeast(sarama.V1_0_0_0) {
+			config.Version = sarama.V1_0_0_0
+		}
+	}
+	if a.TLS != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = a.TLS
+	}
+	return nil
+}
+
+// SetAuth makes the sink authenticate to the brokers with auth. It must
+// be called before Start.
+func (k *KafkaMetricSink) SetAuth(auth Auth) error {
+	return auth.apply(k.config)
+}
+
+// SetAuth makes the sink authenticate to the brokers with auth. It must
+// be called before Start.
+func (k *KafkaSpanSink) SetAuth(auth Auth) error {
+	return auth.apply(k.config)
+}
@@ -10,6 +10,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -36,14 +37,25 @@ var _ sinks.MetricSink = &KafkaMetricSink{}
 var _ sinks.SpanSink = &KafkaSpanSink{}
 
 type KafkaMetricSink struct {
-	logger      *logrus.Entry
-	producer    sarama.AsyncProducer
-	checkTopic  string
-	eventTopic  string
-	metricTopic string
-	brokers     string
-	config      *sarama.Config
-	traceClient *trace.Client
+	logger          *logrus.Entry
+	producer        sarama.AsyncProducer
+	checkTopic      string
+	eventTopic      string
+	metricTopic     string
+	partitionFields []string
+	brokers         string
+	config          *sarama.Config
+	traceClient     *trace.Client
+
+	// confirmDelivery makes Flush wait until the brokers acknowledge
+	// each message, re-producing the failed ones up to deliveryRetries
+	// times.
+	confirmDelivery bool
+	deliveryRetries int
+	// confirmMtx serializes the flushes that wait for their messages.
+	confirmMtx sync.Mutex
+
+	produceErrors int64
 }
 
 type KafkaSpanSink struct {
@@ -56,11 +68,16 @@ type KafkaSpanSink struct {
 	sampleThreshold uint32
 	config          *sarama.Config
 	spansFlushed    int64
+	produceErrors   int64
 	traceClient     *trace.Client
 }
 
-// NewKafkaMetricSink creates a new Kafka Plugin.
-func NewKafkaMetricSink(logger *logrus.Logger, cl *trace.Client, brokers string, checkTopic string, eventTopic string, metricTopic string, ackRequirement string, partitioner string, retries int, bufferBytes int, bufferMessages int, bufferDuration string) (*KafkaMetricSink, error) {
+// NewKafkaMetricSink creates a new Kafka Plugin. If partitionFields is
+// non-empty, each metric's message is keyed on those fields of the
+// metric (any of "name", "type" and "tags"), so that the hash
+// partitioner, which is then the default, always sends the same metric
+// to the same partition.
+func NewKafkaMetricSink(logger *logrus.Logger, cl *trace.Client, brokers string, checkTopic string, eventTopic string, metricTopic string, ackRequirement string, partitioner string, partitionFields []string, retries int, bufferBytes int, bufferMessages int, bufferDuration string) (*KafkaMetricSink, error) {
 	if logger == nil {
 		logger = &logrus.Logger{Out: ioutil.Discard}
 	}
@@ -69,6 +86,21 @@ func NewKafkaMetricSink(logger *logrus.Logger, cl *trace.Client, brokers string,
 		return nil, errors.New("Unable to start Kafka sink with no valid topic names")
 	}
 
+	for _, field := range partitionFields {
+		switch field {
+		case "name", "type", "tags":
+		default:
+			return nil, fmt.Errorf("Unknown metric field %q to partition on, must be one of name, type or tags", field)
+		}
+	}
+	if len(partitionFields) > 0 {
+		if partitioner == "" {
+			partitioner = "hash"
+		} else if partitioner != "hash" {
+			return nil, fmt.Errorf("Partitioning metrics on %v requires the hash partitioner, but %s was requested", partitionFields, partitioner)
+		}
+	}
+
 	ll := logger.WithField("metric_sink", "kafka")
 
 	var finalBufferDuration time.Duration
@@ -83,39 +115,64 @@ func NewKafkaMetricSink(logger *logrus.Logger, cl *trace.Client, brokers string,
 	config, _ := newProducerConfig(ll, ackRequirement, partitioner, retries, bufferBytes, bufferMessages, finalBufferDuration)
 
 	ll.WithFields(logrus.Fields{
-		"brokers":         brokers,
-		"check_topic":     checkTopic,
-		"event_topic":     eventTopic,
-		"metric_topic":    metricTopic,
-		"partitioner":     partitioner,
-		"ack_requirement": ackRequirement,
-		"max_retries":     retries,
-		"buffer_bytes":    bufferBytes,
-		"buffer_messages": bufferMessages,
-		"buffer_duration": bufferDuration,
+		"brokers":          brokers,
+		"check_topic":      checkTopic,
+		"event_topic":      eventTopic,
+		"metric_topic":     metricTopic,
+		"partitioner":      partitioner,
+		"partition_fields": partitionFields,
+		"ack_requirement":  ackRequirement,
+		"max_retries":      retries,
+		"buffer_bytes":     bufferBytes,
+		"buffer_messages":  bufferMessages,
+		"buffer_duration":  bufferDuration,
 	}).Info("Created Kafka metric sink")
 
 	return &KafkaMetricSink{
-		logger:      ll,
-		checkTopic:  checkTopic,
-		eventTopic:  eventTopic,
-		metricTopic: metricTopic,
-		brokers:     brokers,
-		config:      config,
-		traceClient: cl,
+		logger:          ll,
+		checkTopic:      checkTopic,
+		eventTopic:      eventTopic,
+		metricTopic:     metricTopic,
+		partitionFields: partitionFields,
+		brokers:         brokers,
+		config:          config,
+		traceClient:     cl,
 	}, nil
 }
 
+// partitionKey returns the key of the message for metric, built from
+// the sink's partition fields, or nil if the sink has none.
+func (k *KafkaMetricSink) partitionKey(metric samplers.InterMetric) sarama.Encoder {
+	if len(k.partitionFields) == 0 {
+		return nil
+	}
+	var key strings.Builder
+	for i, field := range k.partitionFields {
+		if i > 0 {
+			key.WriteByte('|')
+		}
+		switch field {
+		case "name":
+			key.WriteString(metric.Name)
+		case "type":
+			key.WriteString(metric.Type.String())
+		case "tags":
+			key.WriteString(strings.Join(metric.Tags, ","))
+		}
+	}
+	return sarama.StringEncoder(key.String())
+}
+
 func newProducerConfig(logger *logrus.Entry, ackRequirement string, partitioner string, retries int, bufferBytes int, bufferMessages int, bufferFrequency time.Duration) (*sarama.Config, error) {
 
 	config := sarama.NewConfig()
 	// TODO Stringer?
 	switch ackRequirement {
-	case "all":
+	case "all", "-1":
 		config.Producer.RequiredAcks = sarama.WaitForAll
-	case "none":
+	case "none", "0":
 		config.Producer.RequiredAcks = sarama.NoResponse
-	case "local":
+	case "local", "1":
 		config.Producer.RequiredAcks = sarama.WaitForLocal
 	default:
 		logrus.WithField("ack_requirement", ackRequirement).Warn("Unknown ack requirement, defaulting to all")
@@ -125,8 +182,10 @@ func newProducerConfig(logger *logrus.Entry, ackRequirement string, partitioner
 	switch partitioner {
 	case "random":
 		config.Producer.Partitioner = sarama.NewRandomPartitioner
-	default:
+	case "hash":
 		config.Producer.Partitioner = sarama.NewHashPartitioner
+	default:
+		config.Producer.Partitioner = sarama.NewRoundRobinPartitioner
 	}
 
 	if bufferBytes != 0 {
@@ -145,8 +204,10 @@ func newProducerConfig(logger *logrus.Entry, ackRequirement string, partitioner
 	// If either of these is set to true, you must
 	// read from the corresponding channels in a separate
 	// goroutine. Otherwise, the entire sink will back up.
+	// The sinks count the errors with countErrors, unless the
+	// metric sink confirms the delivery of its messages itself.
 	config.Producer.Return.Successes = false
-	config.Producer.Return.Errors = false
+	config.Producer.Return.Errors = true
 
 	return config, nil
 }
@@ -170,6 +231,19 @@ func newConfiguredProducer(logger *logrus.Entry, brokerString string, config *sa
 	return producer, nil
 }
 
+// countErrors adds the messages that producer failed to deliver to
+// count, until the producer is closed.
+func countErrors(logger *logrus.Entry, producer sarama.AsyncProducer, count *int64) {
+	// newConfiguredProducer returns no producer if it couldn't connect.
+	if producer == nil {
+		return
+	}
+	for perr := range producer.Errors() {
+		atomic.AddInt64(count, 1)
+		logger.WithError(perr.Err).WithField("topic", perr.Msg.Topic).Debug("Failed to produce a message")
+	}
+}
+
 // Name returns the name of this sink.
 func (k *KafkaMetricSink) Name() string {
 	return "kafka"
@@ -182,6 +256,41 @@ func (k *KafkaMetricSink) Start(cl *trace.Client) error {
 		return err
 	}
 	k.producer = producer
+	if !k.confirmDelivery {
+		go countErrors(k.logger, producer, &k.produceErrors)
+	}
+	return nil
+}
+
+// ConfirmDelivery makes Flush wait until the brokers acknowledge every
+// message it produced (as required by the sink's ack requirement), and
+// return an error if any of them couldn't be delivered. Each message
+// that fails is produced again, up to retries times, for as long as the
+// flush's context allows. It must be called before Start.
+func (k *KafkaMetricSink) ConfirmDelivery(retries int) {
+	k.confirmDelivery = true
+	k.deliveryRetries = retries
+	k.config.Producer.Return.Successes = true
+}
+
+// Idempotent makes the producer idempotent, so that the brokers write
+// each message once even if the producer retries it. It requires the
+// "all" ack requirement, at least one retry and Kafka 0.11 or later,
+// and the producer only has one request in flight to each broker. The
+// messages that ConfirmDelivery produces again are new messages, which
+// may still be written twice. It must be called before Start.
+func (k *KafkaMetricSink) Idempotent() error {
+	if k.config.Producer.RequiredAcks != sarama.WaitForAll {
+		return errors.New("idempotent producing requires the \"all\" ack requirement")
+	}
+	if k.config.Producer.Retry.Max < 1 {
+		return errors.New("idempotent producing requires at least one retry")
+	}
+	k.config.Producer.Idempotent = true
+	k.config.Net.MaxOpenRequests = 1
+	if !k.config.Version.IsAtLeast(sarama.V0_11_0_0) {
+		k.config.Version = sarama.V0_11_0_0
+	}
 	return nil
 }
 
@@ -196,6 +305,7 @@ func (k *KafkaMetricSink) Flush(ctx context.Context, interMetrics []samplers.Int
 	}
 
 	successes := int64(0)
+	var messages []*sarama.ProducerMessage
 	for _, metric := range interMetrics {
 		if !sinks.IsAcceptableMetric(metric, k) {
 			continue
@@ -209,14 +319,102 @@ func (k *KafkaMetricSink) Flush(ctx context.Context, interMetrics []samplers.Int
 			return err
 		}
 
-		k.producer.Input() <- &sarama.ProducerMessage{
+		message := &sarama.ProducerMessage{
 			Topic: k.metricTopic,
+			Key:   k.partitionKey(metric),
 			Value: sarama.StringEncoder(j),
 		}
+		if k.confirmDelivery {
+			messages = append(messages, message)
+			continue
+		}
+		k.producer.Input() <- message
 		successes++
 	}
+
+	var err error
+	if k.confirmDelivery {
+		successes, err = k.produceConfirmed(ctx, messages, samples)
+	} else {
+		samples.Add(ssf.Count("kafka.produce.error_total", float32(atomic.SwapInt64(&k.produceErrors, 0)), map[string]string{"sink": k.Name()}))
+	}
 	samples.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(successes), map[string]string{"sink": k.Name()}))
-	return nil
+	return err
+}
+
+// deliveryBatch marks the messages of one flush that confirms their
+// delivery, so that the results of the messages of an earlier flush
+// that gave up on them aren't mistaken for its own. It isn't empty so
+// that every batch has a distinct address.
+type deliveryBatch struct {
+	size int
+}
+
+// produceConfirmed produces messages and waits until the brokers
+// acknowledge them, returning how many were delivered.
+func (k *KafkaMetricSink) produceConfirmed(ctx context.Context, messages []*sarama.ProducerMessage, samples *ssf.Samples) (int64, error) {
+	k.confirmMtx.Lock()
+	defer k.confirmMtx.Unlock()
+
+	batch := &deliveryBatch{size: len(messages)}
+	for _, message := range messages {
+		message.Metadata = batch
+	}
+	queue := messages
+	attempts := map[*sarama.ProducerMessage]int{}
+	pending := len(messages)
+	var delivered, failed, produceErrors int64
+	defer func() {
+		samples.Add(ssf.Count("kafka.produce.error_total", float32(produceErrors), map[string]string{"sink": k.Name()}))
+	}()
+
+	for pending > 0 {
+		// Results have to be read while messages are produced, or
+		// the producer backs up.
+		var input chan<- *sarama.ProducerMessage
+		var next *sarama.ProducerMessage
+		if len(queue) > 0 {
+			input = k.producer.Input()
+			next = queue[0]
+		}
+		select {
+		case input <- next:
+			queue = queue[1:]
+		case message := <-k.producer.Successes():
+			if message.Metadata != batch {
+				continue
+			}
+			pending--
+			delivered++
+		case perr := <-k.producer.Errors():
+			produceErrors++
+			k.logger.WithError(perr.Err).WithField("topic", perr.Msg.Topic).Debug("Failed to produce a message")
+			if perr.Msg.Metadata != batch {
+				continue
+			}
+			// The failed message is retried as a new one, since the
+			// producer already used up its own retries on it.
+			if attempts[perr.Msg] < k.deliveryRetries {
+				retry := &sarama.ProducerMessage{
+					Topic:    perr.Msg.Topic,
+					Key:      perr.Msg.Key,
+					Value:    perr.Msg.Value,
+					Metadata: batch,
+				}
+				attempts[retry] = attempts[perr.Msg] + 1
+				queue = append(queue, retry)
+				continue
+			}
+			pending--
+			failed++
+		case <-ctx.Done():
+			return delivered, fmt.Errorf("Gave up waiting for Kafka to acknowledge %d messages: %v", pending, ctx.Err())
+		}
+	}
+	if failed > 0 {
+		return delivered, fmt.Errorf("Failed to deliver %d of %d messages to Kafka", failed, len(messages))
+	}
+	return delivered, nil
 }
 
 // FlushOtherSamples flushes non-metric, non-span samples
@@ -224,7 +422,9 @@ func (k *KafkaMetricSink) FlushOtherSamples(ctx context.Context, samples []ssf.S
 	// TODO
 }
 
-// NewKafkaSpanSink creates a new Kafka Plugin.
+// NewKafkaSpanSink creates a new Kafka Plugin. Spans are keyed on their
+// trace ID, so the hash partitioner, which is the default, sends every
+// span of a trace to the same partition.
 func NewKafkaSpanSink(logger *logrus.Logger, cl *trace.Client, brokers string, topic string, partitioner string, ackRequirement string, retries int, bufferBytes int, bufferMessages int, bufferDuration string, serializationFormat string, sampleTag string, sampleRatePercentage float64) (*KafkaSpanSink, error) {
 	if logger == nil {
 		logger = &logrus.Logger{Out: ioutil.Discard}
@@ -236,6 +436,10 @@ func NewKafkaSpanSink(logger *logrus.Logger, cl *trace.Client, brokers string, t
 
 	ll := logger.WithField("span_sink", "kafka")
 
+	if partitioner == "" {
+		partitioner = "hash"
+	}
+
 	serializer := serializationFormat
 	if serializer != "json" && serializer != "protobuf" {
 		ll.WithField("serializer", serializer).Warn("Unknown serializer, defaulting to protobuf")
@@ -282,6 +486,7 @@ func NewKafkaSpanSink(logger *logrus.Logger, cl *trace.Client, brokers string, t
 		serializer:      serializer,
 		sampleTag:       sampleTag,
 		sampleThreshold: sampleThreshold,
+		traceClient:     cl,
 	}, nil
 }
 
@@ -297,6 +502,7 @@ func (k *KafkaSpanSink) Start(cl *trace.Client) error {
 		return err
 	}
 	k.producer = producer
+	go countErrors(k.logger, producer, &k.produceErrors)
 	return nil
 }
 
@@ -371,8 +577,11 @@ func (k *KafkaSpanSink) Ingest(span *ssf.SSFSpan) error {
 		return fmt.Errorf("Unknown serialization format for encoding Kafka message: %s", k.serializer)
 	}
 
+	// Keying the message on the trace ID makes the hash partitioner send
+	// every span of a trace to the same partition.
 	message := &sarama.ProducerMessage{
 		Topic: k.topic,
+		Key:   sarama.StringEncoder(strconv.FormatInt(span.TraceId, 10)),
 		Value: enc,
 	}
 
@@ -381,6 +590,8 @@ func (k *KafkaSpanSink) Ingest(span *ssf.SSFSpan) error {
 		atomic.AddInt64(&k.spansFlushed, 1)
 		return nil
 	case _ = <-time.After(IngestTimeout):
+		// The producer's buffer stayed full, so the span is dropped.
+		samples.Add(ssf.Count(sinks.MetricKeyTotalSpansDropped, 1, map[string]string{"sink": k.Name()}))
 		return IngestTimeoutError
 	}
 }
@@ -395,4 +606,5 @@ func (k *KafkaSpanSink) Flush() {
 	}).Debug("Checkpointing flushed spans for Kafka")
 	metrics.ReportOne(k.traceClient, ssf.Count(sinks.MetricKeyTotalSpansFlushed, float32(atomic.LoadInt64(&k.spansFlushed)), map[string]string{"sink": k.Name()}))
 	atomic.SwapInt64(&k.spansFlushed, 0)
+	metrics.ReportOne(k.traceClient, ssf.Count("kafka.produce.error_total", float32(atomic.SwapInt64(&k.produceErrors, 0)), map[string]string{"sink": k.Name()}))
 }
@@ -2,6 +2,11 @@ package kafka
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"fmt"
+	"hash"
 	"math"
 	"testing"
 	"time"
@@ -11,9 +16,11 @@ import (
 	"github.com/gogo/protobuf/proto"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/v14/samplers"
 	"github.com/stripe/veneur/v14/ssf"
 	"github.com/stripe/veneur/v14/trace"
+	"github.com/xdg-go/scram"
 )
 
 func TestMetricFlush(t *testing.T) {
@@ -28,7 +35,7 @@ func TestMetricFlush(t *testing.T) {
 	// https://github.com/stripe/veneur/issues/277
 	logger := logrus.StandardLogger()
 
-	sink, err := NewKafkaMetricSink(logger, nil, "testing", "testCheckTopic", "testEventTopic", "testMetricTopic", "all", "hash", 0, 0, 0, "")
+	sink, err := NewKafkaMetricSink(logger, nil, "testing", "testCheckTopic", "testEventTopic", "testMetricTopic", "all", "hash", nil, 0, 0, 0, "")
 	assert.NoError(t, err)
 	sink.Start(trace.DefaultClient)
 
@@ -122,7 +129,7 @@ func TestMetricFlushRouting(t *testing.T) {
 			// https://github.com/stripe/veneur/issues/277
 			logger := logrus.StandardLogger()
 
-			sink, err := NewKafkaMetricSink(logger, nil, "testing", "testCheckTopic", "testEventTopic", "testMetricTopic", "all", "hash", 0, 0, 0, "")
+			sink, err := NewKafkaMetricSink(logger, nil, "testing", "testCheckTopic", "testEventTopic", "testMetricTopic", "all", "hash", nil, 0, 0, 0, "")
 			assert.NoError(t, err)
 			sink.Start(trace.DefaultClient)
 
@@ -150,7 +157,7 @@ func TestMetricFlushRouting(t *testing.T) {
 func TestMetricConstructor(t *testing.T) {
 	logger := logrus.StandardLogger()
 
-	sink, err := NewKafkaMetricSink(logger, nil, "testing", "veneur_checks", "veneur_events", "veneur_metrics", "all", "hash", 1, 2, 3, "10s")
+	sink, err := NewKafkaMetricSink(logger, nil, "testing", "veneur_checks", "veneur_events", "veneur_metrics", "all", "hash", nil, 1, 2, 3, "10s")
 	assert.NoError(t, err)
 
 	assert.Equal(t, "kafka", sink.Name())
@@ -166,15 +173,101 @@ func TestMetricConstructor(t *testing.T) {
 	assert.Equal(t, time.Second*10, sink.config.Producer.Flush.Frequency, "flush frequency did not set correctly")
 }
 
+func TestMetricFlushPartitionKey(t *testing.T) {
+	metric := samplers.InterMetric{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     float64(100),
+		Tags:      []string{"baz:quz", "foo:bar"},
+		Type:      samplers.CounterMetric,
+	}
+	tests := []struct {
+		fields []string
+		key    string
+	}{
+		{nil, ""},
+		{[]string{"name"}, "a.b.c"},
+		{[]string{"name", "tags"}, "a.b.c|baz:quz,foo:bar"},
+		{[]string{"type", "name"}, "CounterMetric|a.b.c"},
+	}
+	for _, elt := range tests {
+		test := elt
+		t.Run(fmt.Sprintf("%v", test.fields), func(t *testing.T) {
+			config := sarama.NewConfig()
+			config.Producer.Return.Successes = true
+			producerMock := mocks.NewAsyncProducer(t, config)
+			producerMock.ExpectInputAndSucceed()
+
+			sink, err := NewKafkaMetricSink(logrus.StandardLogger(), nil, "testing", "", "", "testMetricTopic", "all", "hash", test.fields, 0, 0, 0, "")
+			assert.NoError(t, err)
+			sink.Start(trace.DefaultClient)
+			sink.producer = producerMock
+
+			assert.NoError(t, sink.Flush(context.Background(), []samplers.InterMetric{metric}))
+			msg := <-producerMock.Successes()
+			if test.key == "" {
+				assert.Nil(t, msg.Key)
+				return
+			}
+			key, err := msg.Key.Encode()
+			assert.NoError(t, err)
+			assert.Equal(t, test.key, string(key))
+		})
+	}
+}
+
+func TestMetricPartitioner(t *testing.T) {
+	for _, test := range []struct {
+		partitioner string
+		fields      []string
+		expected    string
+	}{
+		{"", nil, "*sarama.roundRobinPartitioner"},
+		{"round_robin", nil, "*sarama.roundRobinPartitioner"},
+		{"random", nil, "*sarama.randomPartitioner"},
+		{"hash", nil, "*sarama.hashPartitioner"},
+		{"", []string{"name"}, "*sarama.hashPartitioner"},
+	} {
+		sink, err := NewKafkaMetricSink(logrus.StandardLogger(), nil, "testing", "", "", "testMetricTopic", "all", test.partitioner, test.fields, 0, 0, 0, "")
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, fmt.Sprintf("%T", sink.config.Producer.Partitioner("testMetricTopic")),
+			"partitioner %q with fields %v", test.partitioner, test.fields)
+	}
+}
+
+func TestSpanPartitioner(t *testing.T) {
+	for _, test := range []struct {
+		partitioner string
+		expected    string
+	}{
+		{"", "*sarama.hashPartitioner"},
+		{"hash", "*sarama.hashPartitioner"},
+		{"round_robin", "*sarama.roundRobinPartitioner"},
+	} {
+		sink, err := NewKafkaSpanSink(logrus.StandardLogger(), nil, "testing", "veneur_spans", test.partitioner, "all", 0, 0, 0, "", "", "", 100)
+		require.NoError(t, err)
+		assert.Equal(t, test.expected, fmt.Sprintf("%T", sink.config.Producer.Partitioner("veneur_spans")),
+			"partitioner %q", test.partitioner)
+	}
+}
+
 func TestMetricInstantiateError(t *testing.T) {
 	logger := logrus.StandardLogger()
 
 	// Busted duration
-	_, err1 := NewKafkaMetricSink(logger, nil, "testing", "veneur_checks", "veneur_events", "veneur_metrics", "all", "hash", 1, 2, 3, "farts")
+	_, err1 := NewKafkaMetricSink(logger, nil, "testing", "veneur_checks", "veneur_events", "veneur_metrics", "all", "hash", nil, 1, 2, 3, "farts")
 	assert.Error(t, err1)
 
 	// No topics
-	_, err := NewKafkaMetricSink(logger, nil, "testing", "", "", "", "all", "hash", 1, 2, 3, "10s")
+	_, err := NewKafkaMetricSink(logger, nil, "testing", "", "", "", "all", "hash", nil, 1, 2, 3, "10s")
+	assert.Error(t, err)
+
+	// Unknown partition field
+	_, err = NewKafkaMetricSink(logger, nil, "testing", "veneur_checks", "veneur_events", "veneur_metrics", "all", "hash", []string{"host"}, 1, 2, 3, "10s")
+	assert.Error(t, err)
+
+	// Partition fields without the hash partitioner
+	_, err = NewKafkaMetricSink(logger, nil, "testing", "veneur_checks", "veneur_events", "veneur_metrics", "all", "random", []string{"name"}, 1, 2, 3, "10s")
 	assert.Error(t, err)
 }
 
@@ -442,6 +535,9 @@ func TestSpanFlushJson(t *testing.T) {
 	contents, err := msg.Value.Encode()
 	assert.NoError(t, err)
 	assert.Contains(t, string(contents), testSpan.Service)
+	key, err := msg.Key.Encode()
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(key), "spans should be keyed on their trace ID")
 }
 
 func TestSpanFlushProtobuf(t *testing.T) {
@@ -491,3 +587,181 @@ func TestSpanFlushProtobuf(t *testing.T) {
 
 	assert.Equal(t, testSpan.Service, span.Service)
 }
+
+func TestSetAuth(t *testing.T) {
+	sink, err := NewKafkaSpanSink(logrus.StandardLogger(), nil, "testing", "testSpanTopic", "hash", "all", 0, 0, 0, "", "protobuf", "", 100)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sink.SetAuth(Auth{}))
+	assert.False(t, sink.config.Net.SASL.Enable)
+	assert.False(t, sink.config.Net.TLS.Enable)
+
+	assert.NoError(t, sink.SetAuth(Auth{SASLMechanism: SASLPlain, SASLUser: "veneur", SASLPassword: "hunter2", TLS: &tls.Config{}}))
+	assert.True(t, sink.config.Net.SASL.Enable)
+	assert.Equal(t, "veneur", sink.config.Net.SASL.User)
+	assert.True(t, sink.config.Net.TLS.Enable)
+	assert.NoError(t, sink.config.Validate())
+}
+
+func TestSetAuthSCRAM(t *testing.T) {
+	for _, test := range []struct {
+		mechanism     string
+		hashGenerator scram.HashGeneratorFcn
+	}{
+		{SASLSCRAMSHA256, func() hash.Hash { return sha256.New() }},
+		{SASLSCRAMSHA512, func() hash.Hash { return sha512.New() }},
+	} {
+		t.Run(test.mechanism, func(t *testing.T) {
+			sink, err := NewKafkaSpanSink(logrus.StandardLogger(), nil, "testing", "testSpanTopic", "hash", "all", 0, 0, 0, "", "protobuf", "", 100)
+			require.NoError(t, err)
+			require.NoError(t, sink.SetAuth(Auth{SASLMechanism: test.mechanism, SASLUser: "veneur", SASLPassword: "hunter2"}))
+			assert.Equal(t, sarama.SASLMechanism(test.mechanism), sink.config.Net.SASL.Mechanism)
+			assert.True(t, sink.config.Version.IsAtLeast(sarama.V1_0_0_0))
+			require.NoError(t, sink.config.Validate())
+
+			// go through the exchange with a SCRAM server that knows the
+			// user's password
+			salt := []byte("salt")
+			client, err := test.hashGenerator.NewClient("veneur", "hunter2", "")
+			require.NoError(t, err)
+			credentials := client.GetStoredCredentials(scram.KeyFactors{Salt: string(salt), Iters: 4096})
+			server, err := test.hashGenerator.NewServer(func(user string) (scram.StoredCredentials, error) {
+				assert.Equal(t, "veneur", user)
+				return credentials, nil
+			})
+			require.NoError(t, err)
+			serverConversation := server.NewConversation()
+
+			scramClient := sink.config.Net.SASL.SCRAMClientGeneratorFunc()
+			require.NoError(t, scramClient.Begin("veneur", "hunter2", ""))
+			msg, err := scramClient.Step("")
+			require.NoError(t, err)
+			for !scramClient.Done() {
+				challenge, err := serverConversation.Step(msg)
+				require.NoError(t, err)
+				msg, err = scramClient.Step(challenge)
+				require.NoError(t, err)
+			}
+			assert.True(t, serverConversation.Valid(), "the server should have authenticated the client")
+		})
+	}
+}
+
+func TestAuthValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		auth  Auth
+		valid bool
+	}{
+		{"none", Auth{}, true},
+		{"plain", Auth{SASLMechanism: SASLPlain, SASLUser: "veneur", SASLPassword: "hunter2"}, true},
+		{"no password", Auth{SASLMechanism: SASLPlain, SASLUser: "veneur"}, false},
+		{"no mechanism", Auth{SASLUser: "veneur", SASLPassword: "hunter2"}, false},
+		{"scram-sha-256", Auth{SASLMechanism: SASLSCRAMSHA256, SASLUser: "veneur", SASLPassword: "hunter2"}, true},
+		{"scram-sha-512", Auth{SASLMechanism: SASLSCRAMSHA512, SASLUser: "veneur", SASLPassword: "hunter2"}, true},
+		{"scram without password", Auth{SASLMechanism: SASLSCRAMSHA512, SASLUser: "veneur"}, false},
+		{"unknown", Auth{SASLMechanism: "GSSAPI", SASLUser: "veneur", SASLPassword: "hunter2"}, false},
+	}
+	for _, elt := range tests {
+		test := elt
+		t.Run(test.name, func(t *testing.T) {
+			err := test.auth.Validate()
+			if test.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestMetricFlushConfirmDelivery(t *testing.T) {
+	metric := samplers.InterMetric{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     float64(100),
+		Type:      samplers.CounterMetric,
+	}
+	tests := []struct {
+		name      string
+		retries   int
+		failures  int
+		delivered bool
+	}{
+		{"delivered", 0, 0, true},
+		{"retried", 1, 1, true},
+		{"failed", 1, 2, false},
+	}
+	for _, elt := range tests {
+		test := elt
+		t.Run(test.name, func(t *testing.T) {
+			config := sarama.NewConfig()
+			config.Producer.Return.Successes = true
+			producerMock := mocks.NewAsyncProducer(t, config)
+			for i := 0; i < test.failures; i++ {
+				producerMock.ExpectInputAndFail(sarama.ErrNotEnoughReplicas)
+			}
+			if test.delivered {
+				producerMock.ExpectInputAndSucceed()
+			}
+
+			sink, err := NewKafkaMetricSink(logrus.StandardLogger(), nil, "testing", "", "", "testMetricTopic", "all", "hash", nil, 0, 0, 0, "")
+			assert.NoError(t, err)
+			sink.ConfirmDelivery(test.retries)
+			sink.producer = producerMock
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			err = sink.Flush(ctx, []samplers.InterMetric{metric})
+			if test.delivered {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+			assert.NoError(t, producerMock.Close())
+		})
+	}
+}
+
+func TestMetricIdempotent(t *testing.T) {
+	tests := []struct {
+		name    string
+		acks    string
+		retries int
+		valid   bool
+	}{
+		{"idempotent", "all", 1, true},
+		{"local acks", "local", 1, false},
+		{"no retries", "all", 0, false},
+	}
+	for _, elt := range tests {
+		test := elt
+		t.Run(test.name, func(t *testing.T) {
+			sink, err := NewKafkaMetricSink(logrus.StandardLogger(), nil, "testing", "testCheckTopic", "testEventTopic", "testMetricTopic", test.acks, "hash", nil, test.retries, 0, 0, "")
+			require.NoError(t, err)
+			err = sink.Idempotent()
+			if !test.valid {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, sink.config.Producer.Idempotent)
+			assert.Equal(t, 1, sink.config.Net.MaxOpenRequests)
+			assert.True(t, sink.config.Version.IsAtLeast(sarama.V0_11_0_0))
+			assert.NoError(t, sink.config.Validate())
+		})
+	}
+}
+
+func TestAckRequirements(t *testing.T) {
+	for ack, required := range map[string]sarama.RequiredAcks{
+		"0":     sarama.NoResponse,
+		"none":  sarama.NoResponse,
+		"1":     sarama.WaitForLocal,
+		"local": sarama.WaitForLocal,
+		"all":   sarama.WaitForAll,
+	} {
+		config, _ := newProducerConfig(logrus.NewEntry(logrus.StandardLogger()), ack, "hash", 0, 0, 0, 0)
+		assert.Equal(t, required, config.Producer.RequiredAcks, "acks %s", ack)
+	}
+}
@@ -0,0 +1,57 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// The names of the SASL/SCRAM mechanisms, which authenticate without
+// sending the password to the broker.
+const (
+	SASLSCRAMSHA256 = sarama.SASLTypeSCRAMSHA256
+	SASLSCRAMSHA512 = sarama.SASLTypeSCRAMSHA512
+)
+
+// scramClient is the client side of a SASL/SCRAM exchange with a broker.
+type scramClient struct {
+	hashGenerator scram.HashGeneratorFcn
+	conversation  *scram.ClientConversation
+}
+
+var _ sarama.SCRAMClient = &scramClient{}
+
+// newSCRAMClientGenerator returns a function that makes the clients of
+// mechanism, one of SASLSCRAMSHA256 or SASLSCRAMSHA512, for sarama.
+func newSCRAMClientGenerator(mechanism string) func() sarama.SCRAMClient {
+	var hashGenerator scram.HashGeneratorFcn = func() hash.Hash { return sha256.New() }
+	if mechanism == SASLSCRAMSHA512 {
+		hashGenerator = func() hash.Hash { return sha512.New() }
+	}
+	return func() sarama.SCRAMClient {
+		return &scramClient{hashGenerator: hashGenerator}
+	}
+}
+
+// Begin starts the exchange for the user.
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+// Step returns the response to the broker's challenge.
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+// Done returns true once the exchange is over.
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
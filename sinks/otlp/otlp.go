@@ -0,0 +1,260 @@
+// Package otlp provides a metric sink that exports metrics to an
+// OpenTelemetry collector (or any other receiver) over OTLP/gRPC.
+package otlp
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/stripe/veneur/v14/internal/protowire"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// exportMethod is the full name of the OTLP metrics service's Export
+// RPC.
+const exportMethod = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+// exportBatchSize is the maximum number of metrics sent in a single
+// export request, which keeps requests well below the default 4MiB
+// limit on gRPC messages that collectors accept.
+const exportBatchSize = 2000
+
+// MetricSink exports metrics over OTLP/gRPC.
+//
+// Veneur's counters are exported as non-monotonic sums with delta
+// temporality over the flush interval, since statsd counters can be
+// decremented, and gauges (including the
+// cardinality of sets and status checks) as gauges. Histograms and timers
+// arrive at the sink as the percentile and aggregate metrics that veneur
+// computed for them, and are exported as gauges and sums in the same
+// way. Tags become string attributes on each data point.
+type MetricSink struct {
+	conn        *grpc.ClientConn
+	target      string
+	interval    time.Duration
+	hostname    string
+	headers     metadata.MD
+	log         *logrus.Logger
+	traceClient *trace.Client
+}
+
+var _ sinks.MetricSink = &MetricSink{}
+
+// NewMetricSink creates a sink that exports metrics to target every
+// flush interval. Each request carries the given headers as gRPC
+// metadata, e.g. for authentication tokens. The dial options should
+// include the transport credentials to use.
+func NewMetricSink(target string, interval time.Duration, hostname string, headers map[string]string, log *logrus.Logger, opts ...grpc.DialOption) (*MetricSink, error) {
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MetricSink{
+		conn:     conn,
+		target:   target,
+		interval: interval,
+		hostname: hostname,
+		headers:  metadata.New(headers),
+		log:      log,
+	}, nil
+}
+
+// Name returns the name of this sink.
+func (s *MetricSink) Name() string {
+	return "otlp"
+}
+
+// Start begins the sink.
+func (s *MetricSink) Start(cl *trace.Client) error {
+	s.traceClient = cl
+	return nil
+}
+
+// Flush exports metrics to the OTLP endpoint.
+func (s *MetricSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	span, ctx := trace.StartSpanFromContext(ctx, "")
+	defer span.ClientFinish(s.traceClient)
+
+	accepted := make([]samplers.InterMetric, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		if sinks.IsAcceptableMetric(metric, s) {
+			accepted = append(accepted, metric)
+		}
+	}
+	if len(accepted) == 0 {
+		return nil
+	}
+
+	flushStart := time.Now()
+	ctx = metadata.NewOutgoingContext(ctx, s.headers)
+	flushed := 0
+	var lastErr error
+	for i := 0; i < len(accepted); i += exportBatchSize {
+		end := i + exportBatchSize
+		if end > len(accepted) {
+			end = len(accepted)
+		}
+
+		req := encodeExportRequest(accepted[i:end], s.hostname, s.interval)
+		var resp []byte
+		err := s.conn.Invoke(ctx, exportMethod, req, &resp, grpc.ForceCodec(rawCodec{}))
+		if err != nil {
+			lastErr = err
+			metrics.ReportOne(s.traceClient, ssf.Count("sinks.otlp.exports_total", 1, map[string]string{"sink": s.Name(), "status": "failure"}))
+			s.log.WithFields(logrus.Fields{
+				logrus.ErrorKey: err,
+				"target":        s.target,
+				"metrics":       end - i,
+			}).Warn("Failed to export metrics over OTLP")
+			continue
+		}
+		metrics.ReportOne(s.traceClient, ssf.Count("sinks.otlp.exports_total", 1, map[string]string{"sink": s.Name(), "status": "success"}))
+		flushed += end - i
+	}
+
+	tags := map[string]string{"sink": s.Name()}
+	span.Add(
+		ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(flushStart), time.Nanosecond, tags),
+		ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(flushed), tags),
+	)
+	return lastErr
+}
+
+// FlushOtherSamples is a no-op, as OTLP metrics have no equivalent of
+// events or service checks.
+func (s *MetricSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+}
+
+// rawCodec passes messages that are already serialized through to
+// gRPC. This lets the sink speak OTLP without depending on the
+// generated OpenTelemetry protobuf packages.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("rawCodec can't marshal %T", v)
+	}
+	return b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("rawCodec can't unmarshal into %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+// encodeExportRequest serializes metrics as an
+// ExportMetricsServiceRequest, as defined in
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/opentelemetry/proto/metrics/v1/metrics.proto
+func encodeExportRequest(metrics []samplers.InterMetric, hostname string, interval time.Duration) []byte {
+	// ScopeMetrics: InstrumentationScope scope = 1; repeated Metric metrics = 2
+	scope := protowire.AppendBytes(nil, 1, protowire.AppendString(nil, 1, "veneur"))
+	var buf []byte
+	for _, metric := range metrics {
+		buf = appendMetric(buf[:0], metric, interval)
+		scope = protowire.AppendBytes(scope, 2, buf)
+	}
+
+	// ResourceMetrics: Resource resource = 1; repeated ScopeMetrics scope_metrics = 2
+	var resource []byte
+	if hostname != "" {
+		resource = protowire.AppendBytes(nil, 1, appendKeyValue(nil, "host.name", hostname))
+	}
+	rm := protowire.AppendBytes(nil, 1, resource)
+	rm = protowire.AppendBytes(rm, 2, scope)
+
+	// ExportMetricsServiceRequest: repeated ResourceMetrics resource_metrics = 1
+	return protowire.AppendBytes(nil, 1, rm)
+}
+
+// appendMetric appends a Metric message with a single data point.
+func appendMetric(b []byte, metric samplers.InterMetric, interval time.Duration) []byte {
+	end := uint64(metric.Timestamp) * uint64(time.Second)
+
+	// NumberDataPoint: fixed64 start_time_unix_nano = 2;
+	// fixed64 time_unix_nano = 3; double as_double = 4;
+	// repeated KeyValue attributes = 7
+	var point []byte
+	if metric.Type == samplers.CounterMetric {
+		point = protowire.AppendFixed64(protowire.AppendTag(point, 2, protowire.Fixed64Type), end-uint64(interval))
+	}
+	point = protowire.AppendFixed64(protowire.AppendTag(point, 3, protowire.Fixed64Type), end)
+	point = protowire.AppendFixed64(protowire.AppendTag(point, 4, protowire.Fixed64Type), math.Float64bits(metric.Value))
+	for _, tag := range metric.Tags {
+		key, value := tag, ""
+		if i := strings.IndexByte(tag, ':'); i >= 0 {
+			key, value = tag[:i], tag[i+1:]
+		}
+		point = protowire.AppendBytes(point, 7, appendKeyValue(nil, key, value))
+	}
+	if metric.Exemplar != nil {
+		// NumberDataPoint: repeated Exemplar exemplars = 5
+		point = protowire.AppendBytes(point, 5, appendExemplar(nil, metric.Exemplar))
+	}
+
+	// Metric: string name = 1; string unit = 3; Gauge gauge = 5; Sum sum = 7
+	b = protowire.AppendString(b, 1, metric.Name)
+	if metric.Timer && metric.Type == samplers.GaugeMetric {
+		// the values computed from a timer are in milliseconds, except
+		// its count
+		b = protowire.AppendString(b, 3, "ms")
+	}
+	switch metric.Type {
+	case samplers.CounterMetric:
+		// Sum: repeated NumberDataPoint data_points = 1;
+		// AggregationTemporality aggregation_temporality = 2 (DELTA is 1);
+		// bool is_monotonic = 3 is left false, since counters can be
+		// negative
+		sum := protowire.AppendBytes(nil, 1, point)
+		sum = protowire.AppendVarint(protowire.AppendTag(sum, 2, protowire.VarintType), 1)
+		b = protowire.AppendBytes(b, 7, sum)
+	default:
+		// Gauge: repeated NumberDataPoint data_points = 1
+		b = protowire.AppendBytes(b, 5, protowire.AppendBytes(nil, 1, point))
+	}
+	return b
+}
+
+// appendExemplar appends an Exemplar message: fixed64 time_unix_nano = 2;
+// double as_double = 3; bytes span_id = 4; bytes trace_id = 5. The 64-bit
+// IDs of SSF fill the low half of OTLP's 16-byte trace IDs.
+func appendExemplar(b []byte, exemplar *samplers.Exemplar) []byte {
+	b = protowire.AppendFixed64(protowire.AppendTag(b, 2, protowire.Fixed64Type), uint64(exemplar.Timestamp))
+	b = protowire.AppendFixed64(protowire.AppendTag(b, 3, protowire.Fixed64Type), math.Float64bits(exemplar.Value))
+	if exemplar.SpanID != 0 {
+		var spanID [8]byte
+		binary.BigEndian.PutUint64(spanID[:], uint64(exemplar.SpanID))
+		b = protowire.AppendBytes(b, 4, spanID[:])
+	}
+	var traceID [16]byte
+	binary.BigEndian.PutUint64(traceID[8:], uint64(exemplar.TraceID))
+	return protowire.AppendBytes(b, 5, traceID[:])
+}
+
+// appendKeyValue appends a KeyValue message with a string value:
+// string key = 1; AnyValue value = 2 { string string_value = 1 }.
+func appendKeyValue(b []byte, key, value string) []byte {
+	b = protowire.AppendString(b, 1, key)
+	return protowire.AppendBytes(b, 2, protowire.AppendString(nil, 1, value))
+}
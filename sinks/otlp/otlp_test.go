@@ -0,0 +1,245 @@
+package otlp
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+// serverCodec is rawCodec in the form that grpc.CustomCodec wants.
+type serverCodec struct{ rawCodec }
+
+func (serverCodec) String() string { return "proto" }
+
+type exportServer struct {
+	requests chan []byte
+	headers  chan metadata.MD
+	fail     bool
+}
+
+func (e *exportServer) handle(srv interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	if method != exportMethod {
+		return status.Errorf(codes.Unimplemented, "unknown method %s", method)
+	}
+	var req []byte
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	e.headers <- md
+	e.requests <- req
+	if e.fail {
+		return status.Error(codes.Unavailable, "nope")
+	}
+	return stream.SendMsg([]byte{})
+}
+
+func startExportServer(t *testing.T, fail bool) (*exportServer, string, func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	es := &exportServer{
+		requests: make(chan []byte, 10),
+		headers:  make(chan metadata.MD, 10),
+		fail:     fail,
+	}
+	srv := grpc.NewServer(grpc.CustomCodec(serverCodec{}), grpc.UnknownServiceHandler(es.handle))
+	go srv.Serve(ln)
+	return es, ln.Addr().String(), srv.Stop
+}
+
+// field is a decoded protobuf field.
+type field struct {
+	num   int
+	bytes []byte
+	num64 uint64
+}
+
+func decode(t *testing.T, b []byte) map[int][]field {
+	fields := map[int][]field{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		require.True(t, n > 0)
+		b = b[n:]
+		f := field{num: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			f.num64, n = binary.Uvarint(b)
+			require.True(t, n > 0)
+			b = b[n:]
+		case 1:
+			f.num64 = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			require.True(t, n > 0)
+			f.bytes = b[n : n+int(l)]
+			b = b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields[f.num] = append(fields[f.num], f)
+	}
+	return fields
+}
+
+func attributes(t *testing.T, kvs []field) map[string]string {
+	attrs := map[string]string{}
+	for _, kv := range kvs {
+		fields := decode(t, kv.bytes)
+		value := decode(t, fields[2][0].bytes)
+		attrs[string(fields[1][0].bytes)] = string(value[1][0].bytes)
+	}
+	return attrs
+}
+
+func TestExport(t *testing.T) {
+	es, addr, stop := startExportServer(t, false)
+	defer stop()
+
+	sink, err := NewMetricSink(addr, 10*time.Second, "myhost", map[string]string{"x-auth-token": "secret"}, logrus.New(), grpc.WithInsecure())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{
+		{
+			Name:      "a.b.counter",
+			Timestamp: 1476119058,
+			Value:     100,
+			Tags:      []string{"foo:bar", "novalue"},
+			Type:      samplers.CounterMetric,
+		},
+		{
+			Name:      "a.b.gauge",
+			Timestamp: 1476119058,
+			Value:     98.5,
+			Tags:      []string{"url:http://example.com"},
+			Type:      samplers.GaugeMetric,
+		},
+	})
+	require.NoError(t, err)
+
+	md := <-es.headers
+	assert.Equal(t, []string{"secret"}, md.Get("x-auth-token"))
+
+	req := decode(t, <-es.requests)
+	require.Len(t, req[1], 1)
+	rm := decode(t, req[1][0].bytes)
+	resource := decode(t, rm[1][0].bytes)
+	assert.Equal(t, map[string]string{"host.name": "myhost"}, attributes(t, resource[1]))
+
+	scope := decode(t, rm[2][0].bytes)
+	assert.Equal(t, "veneur", string(decode(t, scope[1][0].bytes)[1][0].bytes))
+	require.Len(t, scope[2], 2)
+
+	counter := decode(t, scope[2][0].bytes)
+	assert.Equal(t, "a.b.counter", string(counter[1][0].bytes))
+	require.Len(t, counter[7], 1, "counters should be sums")
+	sum := decode(t, counter[7][0].bytes)
+	assert.Equal(t, uint64(1), sum[2][0].num64, "should be delta temporality")
+	assert.Empty(t, sum[3], "shouldn't be monotonic, since counters can be negative")
+	point := decode(t, sum[1][0].bytes)
+	assert.Equal(t, uint64(1476119048*time.Second), point[2][0].num64)
+	assert.Equal(t, uint64(1476119058*time.Second), point[3][0].num64)
+	assert.Equal(t, 100.0, math.Float64frombits(point[4][0].num64))
+	assert.Equal(t, map[string]string{"foo": "bar", "novalue": ""}, attributes(t, point[7]))
+
+	gauge := decode(t, scope[2][1].bytes)
+	assert.Equal(t, "a.b.gauge", string(gauge[1][0].bytes))
+	require.Len(t, gauge[5], 1, "gauges should be gauges")
+	point = decode(t, decode(t, gauge[5][0].bytes)[1][0].bytes)
+	assert.Empty(t, point[2], "gauges have no start time")
+	assert.Equal(t, uint64(1476119058*time.Second), point[3][0].num64)
+	assert.Equal(t, 98.5, math.Float64frombits(point[4][0].num64))
+	assert.Equal(t, map[string]string{"url": "http://example.com"}, attributes(t, point[7]))
+}
+
+func TestExportExemplar(t *testing.T) {
+	es, addr, stop := startExportServer(t, false)
+	defer stop()
+
+	sink, err := NewMetricSink(addr, 10*time.Second, "", nil, logrus.New(), grpc.WithInsecure())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:      "a.b.timer.99percentile",
+		Timestamp: 1476119058,
+		Value:     12,
+		Type:      samplers.GaugeMetric,
+		Exemplar: &samplers.Exemplar{
+			Value:     13,
+			Timestamp: 1476119057 * int64(time.Second),
+			TraceID:   0x0102030405060708,
+			SpanID:    0x1112131415161718,
+		},
+	}})
+	require.NoError(t, err)
+	<-es.headers
+
+	req := decode(t, <-es.requests)
+	scope := decode(t, decode(t, req[1][0].bytes)[2][0].bytes)
+	gauge := decode(t, scope[2][0].bytes)
+	point := decode(t, decode(t, gauge[5][0].bytes)[1][0].bytes)
+	require.Len(t, point[5], 1, "the data point should have an exemplar")
+	exemplar := decode(t, point[5][0].bytes)
+	assert.Equal(t, uint64(1476119057*time.Second), exemplar[2][0].num64)
+	assert.Equal(t, 13.0, math.Float64frombits(exemplar[3][0].num64))
+	assert.Equal(t, []byte{0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18}, exemplar[4][0].bytes)
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8}, exemplar[5][0].bytes)
+}
+
+func TestExportTimerUnit(t *testing.T) {
+	es, addr, stop := startExportServer(t, false)
+	defer stop()
+
+	sink, err := NewMetricSink(addr, 10*time.Second, "", nil, logrus.New(), grpc.WithInsecure())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{
+		{Name: "a.b.timer.max", Timestamp: 1476119058, Value: 12, Type: samplers.GaugeMetric, Timer: true},
+		{Name: "a.b.timer.count", Timestamp: 1476119058, Value: 3, Type: samplers.CounterMetric, Timer: true},
+		{Name: "a.b.histogram.max", Timestamp: 1476119058, Value: 12, Type: samplers.GaugeMetric},
+	})
+	require.NoError(t, err)
+	<-es.headers
+
+	req := decode(t, <-es.requests)
+	scope := decode(t, decode(t, req[1][0].bytes)[2][0].bytes)
+	require.Len(t, scope[2], 3)
+	max := decode(t, scope[2][0].bytes)
+	require.Len(t, max[3], 1)
+	assert.Equal(t, "ms", string(max[3][0].bytes))
+	assert.Empty(t, decode(t, scope[2][1].bytes)[3], "a timer's count isn't in milliseconds")
+	assert.Empty(t, decode(t, scope[2][2].bytes)[3], "histograms have no unit")
+}
+
+func TestExportFailure(t *testing.T) {
+	_, addr, stop := startExportServer(t, true)
+	defer stop()
+
+	sink, err := NewMetricSink(addr, 10*time.Second, "", nil, logrus.New(), grpc.WithInsecure())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:  "a.b.c",
+		Value: 1,
+		Type:  samplers.GaugeMetric,
+	}})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,271 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/sirupsen/logrus"
+
+	"github.com/stripe/veneur/v14/internal/protowire"
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// DefaultRemoteWriteBatchSize is the number of samples sent in a single
+// remote write request if no batch size is configured.
+const DefaultRemoteWriteBatchSize = 500
+
+// remoteWriteRetries is how many times a request that failed with a
+// server error is retried before its samples are dropped.
+const remoteWriteRetries = 3
+
+// remoteWriteBackoff is the delay before the first retry; it doubles
+// with each subsequent one.
+var remoteWriteBackoff = 250 * time.Millisecond
+
+// RemoteWriteSink is a metric sink that sends metrics to a Prometheus
+// remote write endpoint, such as Prometheus itself, Cortex or Thanos.
+//
+// Each metric becomes a time series with a single sample: the metric's
+// name becomes the __name__ label, and each tag becomes a label (tags
+// without a value become a label with an empty value, which Prometheus
+// ignores). Histograms and timers arrive at the sink as the percentile
+// and aggregate gauges that veneur computed for them, so no buckets are
+// sent. Counters are sent as the count over the flush interval.
+type RemoteWriteSink struct {
+	addr        string
+	batchSize   int
+	httpClient  *http.Client
+	logger      *logrus.Logger
+	traceClient *trace.Client
+}
+
+// NewRemoteWriteSink returns a new RemoteWriteSink that sends at most
+// batchSize samples in each request to addr.
+func NewRemoteWriteSink(addr string, batchSize int, httpClient *http.Client, logger *logrus.Logger) (*RemoteWriteSink, error) {
+	if _, err := url.ParseRequestURI(addr); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultRemoteWriteBatchSize
+	}
+
+	return &RemoteWriteSink{
+		addr:       addr,
+		batchSize:  batchSize,
+		httpClient: httpClient,
+		logger:     logger,
+	}, nil
+}
+
+// Name returns the name of this sink.
+func (s *RemoteWriteSink) Name() string {
+	return "prometheus_remote_write"
+}
+
+// Start begins the sink.
+func (s *RemoteWriteSink) Start(cl *trace.Client) error {
+	s.traceClient = cl
+	return nil
+}
+
+// Flush sends metrics to the remote write endpoint in batches of at
+// most batchSize samples.
+func (s *RemoteWriteSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	span, ctx := trace.StartSpanFromContext(ctx, "")
+	defer span.ClientFinish(s.traceClient)
+
+	accepted := make([]samplers.InterMetric, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		if sinks.IsAcceptableMetric(metric, s) {
+			accepted = append(accepted, metric)
+		}
+	}
+	if len(accepted) == 0 {
+		return nil
+	}
+
+	flushStart := time.Now()
+	flushed := 0
+	var lastErr error
+	for i := 0; i < len(accepted); i += s.batchSize {
+		end := i + s.batchSize
+		if end > len(accepted) {
+			end = len(accepted)
+		}
+
+		err := s.write(ctx, encodeWriteRequest(accepted[i:end]))
+		if err != nil {
+			lastErr = err
+			metrics.ReportOne(s.traceClient, ssf.Count("sinks.prometheus.remote_write_failures_total", 1, map[string]string{"sink": s.Name()}))
+			s.logger.WithFields(logrus.Fields{
+				logrus.ErrorKey: err,
+				"samples":       end - i,
+			}).Warn("Failed to send metrics to Prometheus remote write endpoint")
+			continue
+		}
+		flushed += end - i
+	}
+
+	tags := map[string]string{"sink": s.Name()}
+	span.Add(
+		ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(flushStart), time.Nanosecond, tags),
+		ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(flushed), tags),
+	)
+	return lastErr
+}
+
+// FlushOtherSamples is a no-op, as Prometheus does not support events
+// or service checks.
+func (s *RemoteWriteSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+}
+
+// write sends a serialized WriteRequest, retrying with exponential
+// backoff if the server responds with a 5xx status.
+func (s *RemoteWriteSink) write(ctx context.Context, request []byte) error {
+	body := snappy.Encode(nil, request)
+	return sinks.Retry(ctx, remoteWriteRetries, remoteWriteBackoff, func() (bool, error) {
+		return s.post(ctx, body)
+	})
+}
+
+// post makes a single remote write request, and reports whether a
+// failed request may succeed if it is retried.
+func (s *RemoteWriteSink) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.addr, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("remote write failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	return resp.StatusCode/100 == 5, err
+}
+
+// label is a Prometheus label name and value.
+type label struct {
+	name, value string
+}
+
+// encodeWriteRequest serializes metrics as a Prometheus WriteRequest
+// protobuf message, as defined in
+// https://github.com/prometheus/prometheus/blob/master/prompb/remote.proto
+func encodeWriteRequest(metrics []samplers.InterMetric) []byte {
+	var req, series, buf []byte
+	for _, metric := range metrics {
+		series = series[:0]
+		for _, l := range metricLabels(metric) {
+			buf = protowire.AppendString(buf[:0], 1, l.name)
+			buf = protowire.AppendString(buf, 2, l.value)
+			series = protowire.AppendBytes(series, 1, buf)
+		}
+
+		// Sample: double value = 1; int64 timestamp = 2 (in milliseconds)
+		buf = protowire.AppendTag(buf[:0], 1, protowire.Fixed64Type)
+		buf = protowire.AppendFixed64(buf, math.Float64bits(metric.Value))
+		buf = protowire.AppendTag(buf, 2, protowire.VarintType)
+		buf = protowire.AppendVarint(buf, uint64(metric.Timestamp*1000))
+		series = protowire.AppendBytes(series, 2, buf)
+
+		if e := metric.Exemplar; e != nil {
+			// Exemplar: repeated Label labels = 1; double value = 2;
+			// int64 timestamp = 3 (in milliseconds)
+			var exemplar []byte
+			for _, l := range exemplarLabels(e) {
+				buf = protowire.AppendString(buf[:0], 1, l.name)
+				buf = protowire.AppendString(buf, 2, l.value)
+				exemplar = protowire.AppendBytes(exemplar, 1, buf)
+			}
+			exemplar = protowire.AppendTag(exemplar, 2, protowire.Fixed64Type)
+			exemplar = protowire.AppendFixed64(exemplar, math.Float64bits(e.Value))
+			exemplar = protowire.AppendTag(exemplar, 3, protowire.VarintType)
+			exemplar = protowire.AppendVarint(exemplar, uint64(e.Timestamp/int64(time.Millisecond)))
+			series = protowire.AppendBytes(series, 3, exemplar)
+		}
+
+		req = protowire.AppendBytes(req, 1, series)
+	}
+	return req
+}
+
+// metricLabels returns the labels of the series for metric, sorted by
+// name as Prometheus requires.
+func metricLabels(metric samplers.InterMetric) []label {
+	labels := make([]label, 0, len(metric.Tags)+1)
+	labels = append(labels, label{"__name__", sanitizeName(metric.Name, true)})
+	seen := map[string]bool{"__name__": true}
+	for _, tag := range metric.Tags {
+		kv := strings.SplitN(tag, ":", 2)
+		name := sanitizeName(kv[0], false)
+		if name == "" || strings.HasPrefix(name, "__") || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		value := ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		labels = append(labels, label{name, value})
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].name < labels[j].name
+	})
+	return labels
+}
+
+// exemplarLabels returns the labels that identify the trace of an
+// exemplar, with the IDs in hex.
+func exemplarLabels(e *samplers.Exemplar) []label {
+	labels := []label{{"trace_id", fmt.Sprintf("%016x", uint64(e.TraceID))}}
+	if e.SpanID != 0 {
+		labels = append(labels, label{"span_id", fmt.Sprintf("%016x", uint64(e.SpanID))})
+	}
+	return labels
+}
+
+// sanitizeName replaces the characters that are not allowed in
+// Prometheus metric names (or, if metricName is false, label names)
+// with underscores.
+func sanitizeName(name string, metricName bool) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r == ':' && metricName:
+			return r
+		}
+		return '_'
+	}, name)
+	if name != "" && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
@@ -0,0 +1,248 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+type testSample struct {
+	labels    map[string]string
+	value     float64
+	timestamp int64
+	exemplar  *testSample
+}
+
+// protoFields splits a serialized protobuf message into its
+// length-delimited and fixed64/varint fields.
+func protoFields(t *testing.T, b []byte) (fields []int, values [][]byte, nums []uint64) {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		require.True(t, n > 0)
+		b = b[n:]
+		field, wireType := int(key>>3), key&7
+		switch wireType {
+		case 0:
+			v, n := binary.Uvarint(b)
+			require.True(t, n > 0)
+			b = b[n:]
+			fields, values, nums = append(fields, field), append(values, nil), append(nums, v)
+		case 1:
+			require.True(t, len(b) >= 8)
+			fields, values, nums = append(fields, field), append(values, nil), append(nums, binary.LittleEndian.Uint64(b))
+			b = b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			require.True(t, n > 0)
+			b = b[n:]
+			fields, values, nums = append(fields, field), append(values, b[:l]), append(nums, 0)
+			b = b[l:]
+		default:
+			t.Fatalf("unexpected wire type %d", wireType)
+		}
+	}
+	return
+}
+
+func decodeWriteRequest(t *testing.T, b []byte) []testSample {
+	var samples []testSample
+	_, allSeries, _ := protoFields(t, b)
+	for _, series := range allSeries {
+		sample := testSample{labels: map[string]string{}}
+		fields, values, _ := protoFields(t, series)
+		var names []string
+		for i, field := range fields {
+			switch field {
+			case 1:
+				_, kv, _ := protoFields(t, values[i])
+				require.Len(t, kv, 2)
+				sample.labels[string(kv[0])] = string(kv[1])
+				names = append(names, string(kv[0]))
+			case 3:
+				exemplar := &testSample{labels: map[string]string{}}
+				ef, ev, nums := protoFields(t, values[i])
+				for j, f := range ef {
+					switch f {
+					case 1:
+						_, kv, _ := protoFields(t, ev[j])
+						require.Len(t, kv, 2)
+						exemplar.labels[string(kv[0])] = string(kv[1])
+					case 2:
+						exemplar.value = math.Float64frombits(nums[j])
+					case 3:
+						exemplar.timestamp = int64(nums[j])
+					}
+				}
+				sample.exemplar = exemplar
+			case 2:
+				sf, _, nums := protoFields(t, values[i])
+				for j, f := range sf {
+					if f == 1 {
+						sample.value = math.Float64frombits(nums[j])
+					} else {
+						sample.timestamp = int64(nums[j])
+					}
+				}
+			}
+		}
+		assert.True(t, sort.StringsAreSorted(names), "labels must be sorted: %v", names)
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+func TestRemoteWriteFlush(t *testing.T) {
+	var mtx sync.Mutex
+	var requests [][]testSample
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		assert.Equal(t, "0.1.0", r.Header.Get("X-Prometheus-Remote-Write-Version"))
+
+		compressed, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		body, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+
+		mtx.Lock()
+		requests = append(requests, decodeWriteRequest(t, body))
+		mtx.Unlock()
+	}))
+	defer srv.Close()
+
+	sink, err := NewRemoteWriteSink(srv.URL+"/api/v1/write", 2, srv.Client(), logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{
+		{
+			Name:      "a.b.counter",
+			Timestamp: 1476119058,
+			Value:     100,
+			Tags:      []string{"foo:bar", "baz:quz", "novalue"},
+			Type:      samplers.CounterMetric,
+		},
+		{
+			Name:      "a.b.gauge",
+			Timestamp: 1476119058,
+			Value:     98.5,
+			Tags:      []string{"some-tag:x:y"},
+			Type:      samplers.GaugeMetric,
+		},
+		{
+			Name:      "a.b.timer.99percentile",
+			Timestamp: 1476119059,
+			Value:     12,
+			Type:      samplers.GaugeMetric,
+		},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, requests, 2, "should have split the metrics into batches of 2")
+	assert.Equal(t, []testSample{
+		{
+			labels:    map[string]string{"__name__": "a_b_counter", "foo": "bar", "baz": "quz", "novalue": ""},
+			value:     100,
+			timestamp: 1476119058000,
+		},
+		{
+			labels:    map[string]string{"__name__": "a_b_gauge", "some_tag": "x:y"},
+			value:     98.5,
+			timestamp: 1476119058000,
+		},
+	}, requests[0])
+	assert.Equal(t, []testSample{
+		{
+			labels:    map[string]string{"__name__": "a_b_timer_99percentile"},
+			value:     12,
+			timestamp: 1476119059000,
+		},
+	}, requests[1])
+}
+
+func TestRemoteWriteExemplar(t *testing.T) {
+	requests := make(chan []testSample, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		body, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		requests <- decodeWriteRequest(t, body)
+	}))
+	defer srv.Close()
+
+	sink, err := NewRemoteWriteSink(srv.URL+"/api/v1/write", 10, srv.Client(), logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:      "a.b.timer.99percentile",
+		Timestamp: 1476119059,
+		Value:     12,
+		Type:      samplers.GaugeMetric,
+		Exemplar: &samplers.Exemplar{
+			Value:     13,
+			Timestamp: 1476119058500 * int64(time.Millisecond),
+			TraceID:   0xabc,
+			SpanID:    0xdef,
+		},
+	}})
+	require.NoError(t, err)
+
+	assert.Equal(t, []testSample{{
+		labels:    map[string]string{"__name__": "a_b_timer_99percentile"},
+		value:     12,
+		timestamp: 1476119059000,
+		exemplar: &testSample{
+			labels:    map[string]string{"trace_id": "0000000000000abc", "span_id": "0000000000000def"},
+			value:     13,
+			timestamp: 1476119058500,
+		},
+	}}, <-requests)
+}
+
+func TestRemoteWriteRetries(t *testing.T) {
+	defer func(backoff time.Duration) { remoteWriteBackoff = backoff }(remoteWriteBackoff)
+	remoteWriteBackoff = time.Millisecond
+
+	// server errors are retried, but client errors aren't
+	statuses := []int{http.StatusServiceUnavailable, http.StatusBadRequest, http.StatusOK}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[requests])
+		requests++
+	}))
+	defer srv.Close()
+
+	sink, err := NewRemoteWriteSink(srv.URL, 0, srv.Client(), logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:  "a.b.c",
+		Value: 1,
+		Type:  samplers.GaugeMetric,
+	}})
+	assert.Error(t, err)
+	assert.Equal(t, 2, requests)
+}
+
+func TestSanitizeName(t *testing.T) {
+	assert.Equal(t, "a_b:c", sanitizeName("a.b:c", true))
+	assert.Equal(t, "a_b_c", sanitizeName("a.b:c", false))
+	assert.Equal(t, "_9lives", sanitizeName("9lives", true))
+}
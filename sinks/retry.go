@@ -0,0 +1,27 @@
+package sinks
+
+import (
+	"context"
+	"time"
+)
+
+// Retry calls attempt until it succeeds, it fails with an error that
+// attempt reports won't go away by retrying, or it has been retried
+// retries times, and returns attempt's last error. The first retry
+// waits for backoff, which doubles with each subsequent one; if ctx is
+// done while waiting, Retry gives up.
+func Retry(ctx context.Context, retries int, backoff time.Duration, attempt func() (retry bool, err error)) error {
+	for i := 0; ; i++ {
+		retry, err := attempt()
+		if err == nil || !retry || i >= retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
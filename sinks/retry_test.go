@@ -0,0 +1,65 @@
+package sinks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetry(t *testing.T) {
+	errTransient := errors.New("transient")
+	errPermanent := errors.New("permanent")
+
+	for name, tc := range map[string]struct {
+		errs     []error
+		attempts int
+		err      error
+	}{
+		"succeeds right away": {
+			errs:     []error{nil},
+			attempts: 1,
+		},
+		"succeeds after transient errors": {
+			errs:     []error{errTransient, errTransient, nil},
+			attempts: 3,
+		},
+		"gives up after transient errors": {
+			errs:     []error{errTransient, errTransient, errTransient, errTransient, nil},
+			attempts: 4,
+			err:      errTransient,
+		},
+		"does not retry permanent errors": {
+			errs:     []error{errPermanent, nil},
+			attempts: 1,
+			err:      errPermanent,
+		},
+	} {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			attempts := 0
+			err := Retry(context.Background(), 3, time.Millisecond, func() (bool, error) {
+				err := tc.errs[attempts]
+				attempts++
+				return err == errTransient, err
+			})
+			assert.Equal(t, tc.err, err)
+			assert.Equal(t, tc.attempts, attempts)
+		})
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	attempts := 0
+	err := Retry(ctx, 3, time.Hour, func() (bool, error) {
+		attempts++
+		return true, errors.New("transient")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, attempts, "should give up once the context is done")
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -14,6 +15,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/signalfx/golib/datapoint"
 	"github.com/signalfx/golib/datapoint/dpsink"
+	sfxerrors "github.com/signalfx/golib/errors"
 	"github.com/signalfx/golib/event"
 	"github.com/signalfx/golib/sfxclient"
 	"github.com/sirupsen/logrus"
@@ -30,6 +32,14 @@ const EventDescriptionMaxLength = 256
 var datapointURL *url.URL
 var eventURL *url.URL
 
+// submitRetries is how many times a batch of datapoints that failed with
+// a network error, a 5xx status or a 429 is retried before it's dropped.
+const submitRetries = 3
+
+// submitBackoff is the delay before the first retry; it doubles with each
+// subsequent one.
+var submitBackoff = 250 * time.Millisecond
+
 const (
 	datapointAddr string = "/v2/datapoint"
 	eventAddr     string = "/v2/event"
@@ -80,7 +90,7 @@ func submitDatapoints(ctx context.Context, wg *sync.WaitGroup, cl *trace.Client,
 	span.SetTag("datapoint_count", len(points))
 	defer span.ClientFinish(cl)
 
-	err := client.AddDatapoints(ctx, points)
+	err := addDatapoints(ctx, client, points)
 	if err != nil {
 		span.Error(err)
 		span.Add(ssf.Count("flush.error_total", 1, map[string]string{"cause": "io", "sink": "signalfx"}))
@@ -88,6 +98,27 @@ func submitDatapoints(ctx context.Context, wg *sync.WaitGroup, cl *trace.Client,
 	errs <- err
 }
 
+// addDatapoints submits points with client, retrying with exponential
+// backoff if the submission fails in a way that may not last.
+func addDatapoints(ctx context.Context, client dpsink.Sink, points []*datapoint.Datapoint) error {
+	return sinks.Retry(ctx, submitRetries, submitBackoff, func() (bool, error) {
+		err := client.AddDatapoints(ctx, points)
+		return err != nil && retryable(err), err
+	})
+}
+
+// retryable reports whether a failed submission may succeed if it's
+// retried: if SignalFx responded with a server error or asked to slow
+// down, or if the request didn't get a response.
+func retryable(err error) bool {
+	var apiErr sfxclient.SFXAPIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode/100 == 5 || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	_, isNetErr := sfxerrors.Tail(err).(net.Error)
+	return isNetErr
+}
+
 func (c *collection) submit(ctx context.Context, cl *trace.Client, maxPerFlush int) error {
 	span, ctx := trace.StartSpanFromContext(ctx, "")
 	defer span.ClientFinish(cl)
@@ -155,6 +186,7 @@ type SignalFxSink struct {
 	log                       *logrus.Logger
 	traceClient               *trace.Client
 	excludedTags              map[string]struct{}
+	dimensionAllowlist        map[string]struct{}
 	metricNamePrefixDrops     []string
 	metricTagPrefixDrops      []string
 	derivedMetrics            samplers.DerivedMetricsProcessor
@@ -428,6 +460,11 @@ METRICLOOP: // Convenience label so that inner nested loops and `continue` easil
 			kv := strings.SplitN(tag, ":", 2)
 			key := kv[0]
 
+			if sfx.dimensionAllowlist != nil && key != sfx.varyBy {
+				if _, ok := sfx.dimensionAllowlist[key]; !ok {
+					continue
+				}
+			}
 			if len(kv) == 1 {
 				dims[key] = ""
 			} else {
@@ -532,6 +569,22 @@ func (sfx *SignalFxSink) SetExcludedTags(excludes []string) {
 	sfx.excludedTags = tagsSet
 }
 
+// SetDimensionAllowlist limits the tags of metrics that become SignalFx
+// dimensions to those with the provided keys, to keep the number of
+// series down. The hostname tag, the common dimensions and the vary-by
+// tag are always sent. If keys is empty, every tag becomes a dimension.
+func (sfx *SignalFxSink) SetDimensionAllowlist(keys []string) {
+	if len(keys) == 0 {
+		sfx.dimensionAllowlist = nil
+		return
+	}
+	allowed := map[string]struct{}{}
+	for _, key := range keys {
+		allowed[key] = struct{}{}
+	}
+	sfx.dimensionAllowlist = allowed
+}
+
 type ddSampleKind int
 
 const (
@@ -197,6 +197,35 @@ func TestSignalFxFlushGauge(t *testing.T) {
 	assert.Empty(t, derived.samples, "Gauges should not generated derived metrics")
 }
 
+func TestSignalFxDimensionAllowlist(t *testing.T) {
+	fakeSink := NewFakeSink()
+	sink, err := NewSignalFxSink("host", "glooblestoots", map[string]string{"yay": "pie"}, logrus.New(), fakeSink, "test_by", nil, nil, nil, newDerivedProcessor(), 0, "", false, time.Second, "", "", nil)
+	require.NoError(t, err)
+	sink.SetDimensionAllowlist([]string{"foo"})
+
+	interMetrics := []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     float64(100),
+		Tags: []string{
+			"foo:bar",
+			"baz:quz",
+			"request_id:1234",
+			"test_by:first",
+		},
+		Type: samplers.GaugeMetric,
+	}}
+	require.NoError(t, sink.Flush(context.TODO(), interMetrics))
+
+	require.Len(t, fakeSink.points, 1)
+	assert.Equal(t, map[string]string{
+		"foo":     "bar",
+		"test_by": "first",
+		"yay":     "pie",
+		"host":    "glooblestoots",
+	}, fakeSink.points[0].Dimensions)
+}
+
 func TestSignalFxFlushCounter(t *testing.T) {
 	fakeSink := NewFakeSink()
 	derived := newDerivedProcessor()
@@ -593,6 +622,38 @@ func TestSignalFxFlushBatchHang(t *testing.T) {
 	require.Error(t, sink.Flush(ctx, interMetrics))
 }
 
+func TestSignalFxFlushRetries(t *testing.T) {
+	defer func(backoff time.Duration) { submitBackoff = backoff }(submitBackoff)
+	submitBackoff = time.Millisecond
+
+	statuses := []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK, http.StatusBadRequest}
+	var requests int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[atomic.AddInt64(&requests, 1)-1]
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`"OK"`))
+		}
+	}))
+	defer srv.Close()
+
+	client := NewClient(srv.URL, "foo", srv.Client())
+	sink, err := NewSignalFxSink("host", "glooblestoots", nil, logrus.New(), client, "", nil, nil, nil, newDerivedProcessor(), 0, "", false, time.Second, "", "", nil)
+	require.NoError(t, err)
+
+	interMetrics := []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     float64(100),
+		Type:      samplers.GaugeMetric,
+	}}
+	require.NoError(t, sink.Flush(context.TODO(), interMetrics), "should retry server errors and 429s")
+	assert.Equal(t, int64(3), atomic.LoadInt64(&requests))
+
+	assert.Error(t, sink.Flush(context.TODO(), interMetrics))
+	assert.Equal(t, int64(4), atomic.LoadInt64(&requests), "shouldn't retry client errors")
+}
+
 func TestNewSinkDoubleSlashes(t *testing.T) {
 	cl := NewClient("http://example.com/", "foo", nil).(*sfxclient.HTTPSink)
 	assert.Equal(t, "http://example.com/v2/datapoint", cl.DatapointEndpoint)
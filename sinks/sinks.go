@@ -2,6 +2,8 @@ package sinks
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/stripe/veneur/v14/samplers"
 	"github.com/stripe/veneur/v14/ssf"
@@ -23,6 +25,11 @@ const MetricKeyTotalMetricsFlushed = "sink.metrics_flushed_total"
 // skipped, not applicable to this MetricSink.
 const MetricKeyTotalMetricsSkipped = "sink.metrics_skipped_total"
 
+// MetricKeyMetricFlushBytes should be emitted as a histogram by a MetricSink
+// if possible, with the number of bytes that it serialized in a flush.
+// Tagged with `sink:sink.Name()`.
+const MetricKeyMetricFlushBytes = "sink.metric_flush_bytes"
+
 // EventReportedCount number of events processed by a sink. Tagged with
 // `sink:sink.Name()`.
 const EventReportedCount = "sink.events_reported_total"
@@ -46,6 +53,77 @@ type MetricSink interface {
 	FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample)
 }
 
+// MetricFlushError can be returned by a MetricSink's Flush to report
+// which of the metrics it was given it failed to deliver, e.g. because
+// only some of the requests it made failed. If a sink returns any other
+// error, all the metrics it accepts are assumed to have failed.
+type MetricFlushError struct {
+	Failed []samplers.InterMetric
+	Err    error
+}
+
+func (e *MetricFlushError) Error() string {
+	return fmt.Sprintf("failed to flush %d metrics: %v", len(e.Failed), e.Err)
+}
+
+func (e *MetricFlushError) Unwrap() error {
+	return e.Err
+}
+
+// UnwrapMetricSink returns the sink that sink wraps, if it wraps one with
+// an Unwrap method like TagFilterSink's, and sink itself otherwise. The
+// optional interfaces of metric sinks, like CounterRateMetricSink, have
+// to be checked on the sink it returns, since wrappers don't implement
+// them.
+func UnwrapMetricSink(sink MetricSink) MetricSink {
+	for {
+		wrapper, ok := sink.(interface{ Unwrap() MetricSink })
+		if !ok {
+			return sink
+		}
+		sink = wrapper.Unwrap()
+	}
+}
+
+// DistributionMetricSink is a MetricSink that can receive distributions as
+// DistributionMetrics, with all their samples, and aggregate them itself.
+// The other sinks receive them as histograms.
+type DistributionMetricSink interface {
+	MetricSink
+	// AcceptsDistributions only marks the sinks that implement the
+	// interface.
+	AcceptsDistributions()
+}
+
+// CounterRateMetricSink is a MetricSink that converts the counters it
+// receives, which are counts over the flush interval, into per-second
+// rates itself, using the FlushInterval of the context it's flushed with
+// if it's set. Veneur converts them for the other sinks that are
+// configured to receive rates.
+type CounterRateMetricSink interface {
+	MetricSink
+	// SendCountersAsCounts makes the sink send counters as counts
+	// over the flush interval instead. It's called before Start.
+	SendCountersAsCounts()
+}
+
+type flushIntervalKey struct{}
+
+// WithFlushInterval returns a context for flushing metrics that were
+// aggregated over interval, which can differ from the server's interval
+// for metrics in a flush group. CounterRateMetricSinks divide counters
+// by it.
+func WithFlushInterval(ctx context.Context, interval time.Duration) context.Context {
+	return context.WithValue(ctx, flushIntervalKey{}, interval)
+}
+
+// FlushInterval returns the interval that the metrics being flushed with
+// ctx were aggregated over, if it's set.
+func FlushInterval(ctx context.Context) (time.Duration, bool) {
+	interval, ok := ctx.Value(flushIntervalKey{}).(time.Duration)
+	return interval, ok
+}
+
 // IsAcceptableMetric returns true if a metric is meant to be ingested
 // by a given sink.
 func IsAcceptableMetric(metric samplers.InterMetric, sink MetricSink) bool {
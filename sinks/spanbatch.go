@@ -0,0 +1,170 @@
+package sinks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/stripe/veneur/v14/protocol"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// MetricKeySpanBatchSize is a histogram of the number of spans in each
+// batch that a batching sink sent on. Tagged with `sink:sink.Name()`.
+const MetricKeySpanBatchSize = "sink.span_batch_size"
+
+// SpanBatching configures a BatchingSpanSink.
+type SpanBatching struct {
+	// MaxSpans is the number of buffered spans at which a batch is sent
+	// on right away.
+	MaxSpans int
+	// MaxAge is the longest a span is buffered before its batch is sent
+	// on.
+	MaxAge time.Duration
+	// BufferSize is the most spans that are buffered while a batch is
+	// being sent. Spans that arrive while the buffer is full are
+	// dropped.
+	BufferSize int
+}
+
+// BatchingSpanSink is a SpanSink that buffers spans, and passes them on
+// to another sink in batches, flushing that sink after each one: a batch
+// is sent on as soon as it has MaxSpans spans, or after MaxAge, whichever
+// comes first, rather than at veneur's flush interval. The batches are
+// sent from a goroutine of the sink's own, so the wrapped sink is only
+// ever ingested into and flushed by it.
+type BatchingSpanSink struct {
+	SpanSink
+	config      SpanBatching
+	traceClient *trace.Client
+
+	mtx    sync.Mutex
+	buffer []*ssf.SSFSpan
+	// sizes are the sizes of the batches sent since the last flush.
+	sizes   []int
+	dropped int64
+
+	started  bool
+	full     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+var _ SpanSink = &BatchingSpanSink{}
+
+// BatchSpans returns a sink that passes spans on to sink in batches.
+func BatchSpans(sink SpanSink, config SpanBatching) *BatchingSpanSink {
+	return &BatchingSpanSink{
+		SpanSink: sink,
+		config:   config,
+		full:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start starts the wrapped sink, and the goroutine that sends the
+// batches on to it.
+func (b *BatchingSpanSink) Start(cl *trace.Client) error {
+	b.traceClient = cl
+	if err := b.SpanSink.Start(cl); err != nil {
+		return err
+	}
+	b.mtx.Lock()
+	b.started = true
+	b.mtx.Unlock()
+	go b.run()
+	return nil
+}
+
+// Ingest buffers the span until its batch is sent on.
+func (b *BatchingSpanSink) Ingest(span *ssf.SSFSpan) error {
+	if err := protocol.ValidateTrace(span); err != nil {
+		return err
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if len(b.buffer) >= b.config.BufferSize {
+		b.dropped++
+		return nil
+	}
+	b.buffer = append(b.buffer, span)
+	if len(b.buffer) == b.config.MaxSpans {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush reports the sizes of the batches sent since the last flush, and
+// the spans that were dropped. It doesn't send the buffered spans on:
+// they go out with their batch.
+func (b *BatchingSpanSink) Flush() {
+	b.mtx.Lock()
+	sizes, dropped := b.sizes, b.dropped
+	b.sizes, b.dropped = nil, 0
+	b.mtx.Unlock()
+
+	tags := map[string]string{"sink": b.Name()}
+	samples := make([]*ssf.SSFSample, 0, len(sizes)+1)
+	for _, size := range sizes {
+		samples = append(samples, ssf.Histogram(MetricKeySpanBatchSize, float32(size), tags))
+	}
+	samples = append(samples, ssf.Count(MetricKeyTotalSpansDropped, float32(dropped), tags))
+	metrics.ReportBatch(b.traceClient, samples)
+}
+
+// Stop sends the buffered spans on, and stops sending batches. Spans
+// that are ingested afterwards are never sent.
+func (b *BatchingSpanSink) Stop() {
+	b.mtx.Lock()
+	started := b.started
+	b.mtx.Unlock()
+	if !started {
+		return
+	}
+	b.stopOnce.Do(func() { close(b.stop) })
+	<-b.done
+}
+
+func (b *BatchingSpanSink) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.config.MaxAge)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.full:
+		case <-ticker.C:
+		case <-b.stop:
+			b.send()
+			return
+		}
+		b.send()
+	}
+}
+
+// send passes the buffered spans on to the wrapped sink, and flushes it.
+func (b *BatchingSpanSink) send() {
+	b.mtx.Lock()
+	spans := b.buffer
+	b.buffer = make([]*ssf.SSFSpan, 0, len(spans))
+	if len(spans) > 0 {
+		b.sizes = append(b.sizes, len(spans))
+	}
+	b.mtx.Unlock()
+	if len(spans) == 0 {
+		return
+	}
+
+	for _, span := range spans {
+		// the spans were validated on their way in, and the wrapped
+		// sink has no better way to report the errors
+		_ = b.SpanSink.Ingest(span)
+	}
+	b.SpanSink.Flush()
+}
@@ -0,0 +1,101 @@
+package sinks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+)
+
+// batchRecordingSpanSink sends the spans it was ingested since each
+// flush on batches.
+type batchRecordingSpanSink struct {
+	spans   []*ssf.SSFSpan
+	batches chan []*ssf.SSFSpan
+}
+
+func (r *batchRecordingSpanSink) Name() string                 { return "recording" }
+func (r *batchRecordingSpanSink) Start(cl *trace.Client) error { return nil }
+func (r *batchRecordingSpanSink) Ingest(span *ssf.SSFSpan) error {
+	r.spans = append(r.spans, span)
+	return nil
+}
+func (r *batchRecordingSpanSink) Flush() {
+	r.batches <- r.spans
+	r.spans = nil
+}
+
+func batchTestSpan(id int64) *ssf.SSFSpan {
+	return &ssf.SSFSpan{Id: id, TraceId: id, StartTimestamp: 1, EndTimestamp: 2, Name: "op"}
+}
+
+func TestBatchSpans(t *testing.T) {
+	rs := &batchRecordingSpanSink{batches: make(chan []*ssf.SSFSpan, 10)}
+	sink := BatchSpans(rs, SpanBatching{MaxSpans: 2, MaxAge: time.Hour, BufferSize: 3})
+	ch := make(chan *ssf.SSFSpan, 1)
+	cl, err := trace.NewChannelClient(ch)
+	require.NoError(t, err)
+	defer cl.Close()
+	require.NoError(t, sink.Start(cl))
+
+	assert.Error(t, sink.Ingest(&ssf.SSFSpan{Name: "not a trace"}))
+	require.NoError(t, sink.Ingest(batchTestSpan(1)))
+	require.NoError(t, sink.Ingest(batchTestSpan(2)))
+	batch := <-rs.batches
+	require.Len(t, batch, 2, "a full batch should be sent on right away")
+
+	require.NoError(t, sink.Ingest(batchTestSpan(3)))
+	sink.Stop()
+	batch = <-rs.batches
+	require.Len(t, batch, 1, "the buffered spans should be drained on stop")
+	assert.Equal(t, int64(3), batch[0].Id)
+
+	sink.Flush()
+	var sizes []float32
+	for _, sample := range (<-ch).Metrics {
+		if sample.Name == MetricKeySpanBatchSize {
+			sizes = append(sizes, sample.Value)
+		}
+	}
+	assert.Equal(t, []float32{2, 1}, sizes)
+}
+
+func TestBatchSpansAge(t *testing.T) {
+	rs := &batchRecordingSpanSink{batches: make(chan []*ssf.SSFSpan, 10)}
+	sink := BatchSpans(rs, SpanBatching{MaxSpans: 100, MaxAge: 10 * time.Millisecond, BufferSize: 100})
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	require.NoError(t, sink.Ingest(batchTestSpan(1)))
+	select {
+	case batch := <-rs.batches:
+		assert.Len(t, batch, 1)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the batch should have been sent on once it was old enough")
+	}
+}
+
+func TestBatchSpansOverflow(t *testing.T) {
+	rs := &batchRecordingSpanSink{batches: make(chan []*ssf.SSFSpan, 10)}
+	sink := BatchSpans(rs, SpanBatching{MaxSpans: 5, MaxAge: time.Hour, BufferSize: 2})
+	ch := make(chan *ssf.SSFSpan, 1)
+	cl, err := trace.NewChannelClient(ch)
+	require.NoError(t, err)
+	defer cl.Close()
+
+	// without Start, nothing sends the batches on
+	sink.traceClient = cl
+	for id := int64(1); id <= 4; id++ {
+		require.NoError(t, sink.Ingest(batchTestSpan(id)))
+	}
+	sink.Flush()
+	for _, sample := range (<-ch).Metrics {
+		if sample.Name == MetricKeyTotalSpansDropped {
+			assert.Equal(t, float32(2), sample.Value)
+		}
+	}
+}
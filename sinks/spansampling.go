@@ -0,0 +1,138 @@
+package sinks
+
+import (
+	"math"
+	"path"
+	"sync/atomic"
+
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// SpanSamplingRule is the rate, in percent, at which spans whose name
+// matches the Operation glob pattern (see path.Match) are kept.
+type SpanSamplingRule struct {
+	Operation   string
+	RatePercent float64
+}
+
+type spanSamplingRule struct {
+	operation string
+	threshold uint64
+}
+
+// spanSamplingRules are the rules of a SpanSamplingSink, which SetRules
+// replaces as a whole.
+type spanSamplingRules struct {
+	rules            []spanSamplingRule
+	defaultThreshold uint64
+}
+
+// SpanSamplingSink is a SpanSink that samples spans by their operation
+// name before passing them on to another sink.
+type SpanSamplingSink struct {
+	SpanSink
+	// rules holds the *spanSamplingRules in effect.
+	rules       atomic.Value
+	traceClient *trace.Client
+
+	// skipped is the number of spans sampled out since the last
+	// flush; accessed atomically.
+	skipped int64
+}
+
+var _ SpanSink = &SpanSamplingSink{}
+
+// SampleSpans returns a sink that only passes a sample of the spans it
+// ingests on to sink. Each span is kept at the rate of the first rule
+// whose pattern matches its name, or at defaultRatePercent if none
+// does. The patterns must be valid. The decision depends on the span's
+// trace ID, so spans of the same trace that are sampled at the same
+// rate are kept or skipped together, by every veneur instance.
+func SampleSpans(sink SpanSink, rules []SpanSamplingRule, defaultRatePercent float64) *SpanSamplingSink {
+	ss := &SpanSamplingSink{SpanSink: sink}
+	ss.SetRules(rules, defaultRatePercent)
+	return ss
+}
+
+// SetRules replaces the sink's sampling rules and default rate, which
+// is safe to do while it ingests spans. The patterns must be valid.
+func (ss *SpanSamplingSink) SetRules(rules []SpanSamplingRule, defaultRatePercent float64) {
+	sr := &spanSamplingRules{
+		rules:            make([]spanSamplingRule, len(rules)),
+		defaultThreshold: SamplingThreshold(defaultRatePercent),
+	}
+	for i, rule := range rules {
+		sr.rules[i] = spanSamplingRule{
+			operation: rule.Operation,
+			threshold: SamplingThreshold(rule.RatePercent),
+		}
+	}
+	ss.rules.Store(sr)
+}
+
+// SamplingThreshold returns the largest trace ID hash that is kept at
+// ratePercent, for TraceSampled.
+func SamplingThreshold(ratePercent float64) uint64 {
+	if ratePercent >= 100 {
+		return math.MaxUint64
+	}
+	if ratePercent <= 0 {
+		return 0
+	}
+	return uint64(ratePercent / 100 * math.MaxUint64)
+}
+
+// Start starts the wrapped sink.
+func (ss *SpanSamplingSink) Start(cl *trace.Client) error {
+	ss.traceClient = cl
+	return ss.SpanSink.Start(cl)
+}
+
+// Ingest passes span on to the wrapped sink if it is sampled.
+func (ss *SpanSamplingSink) Ingest(span *ssf.SSFSpan) error {
+	sr := ss.rules.Load().(*spanSamplingRules)
+	threshold := sr.defaultThreshold
+	for _, rule := range sr.rules {
+		if ok, _ := path.Match(rule.operation, span.Name); ok {
+			threshold = rule.threshold
+			break
+		}
+	}
+	if !TraceSampled(span.TraceId, threshold) {
+		atomic.AddInt64(&ss.skipped, 1)
+		return nil
+	}
+	return ss.SpanSink.Ingest(span)
+}
+
+// Flush reports the number of spans that were sampled out, and flushes
+// the wrapped sink.
+func (ss *SpanSamplingSink) Flush() {
+	if skipped := atomic.SwapInt64(&ss.skipped, 0); skipped > 0 {
+		metrics.ReportOne(ss.traceClient, ssf.Count(MetricKeyTotalSpansSkipped, float32(skipped), map[string]string{"sink": ss.Name()}))
+	}
+	ss.SpanSink.Flush()
+}
+
+// TraceSampled returns true if the trace with the ID is kept at the
+// threshold that SamplingThreshold returned. The decision only depends
+// on the ID, so all spans of a trace are kept or dropped together, by
+// every veneur instance that samples at the same rate.
+func TraceSampled(id int64, threshold uint64) bool {
+	return threshold > 0 && hashTraceID(id) <= threshold
+}
+
+// hashTraceID spreads trace IDs uniformly over the uint64 range, even if
+// they are e.g. sequential, using the finalizer of the SplitMix64
+// generator.
+func hashTraceID(id int64) uint64 {
+	x := uint64(id)
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,80 @@
+package sinks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+)
+
+type recordingSpanSink struct {
+	ingested []*ssf.SSFSpan
+	flushes  int
+}
+
+func (r *recordingSpanSink) Name() string                 { return "recording" }
+func (r *recordingSpanSink) Start(cl *trace.Client) error { return nil }
+func (r *recordingSpanSink) Ingest(span *ssf.SSFSpan) error {
+	r.ingested = append(r.ingested, span)
+	return nil
+}
+func (r *recordingSpanSink) Flush() { r.flushes++ }
+
+func TestSampleSpans(t *testing.T) {
+	rs := &recordingSpanSink{}
+	sink := SampleSpans(rs, []SpanSamplingRule{
+		{Operation: "checkout.*", RatePercent: 100},
+		{Operation: "health*", RatePercent: 0},
+		{Operation: "*", RatePercent: 50},
+	}, 100)
+	ch := make(chan *ssf.SSFSpan, 1)
+	cl, err := trace.NewChannelClient(ch)
+	require.NoError(t, err)
+	defer cl.Close()
+	require.NoError(t, sink.Start(cl))
+	assert.Equal(t, "recording", sink.Name())
+
+	counts := map[string]int{}
+	const traces = 10000
+	for _, name := range []string{"checkout.charge", "healthcheck", "search"} {
+		for id := int64(1); id <= traces; id++ {
+			require.NoError(t, sink.Ingest(&ssf.SSFSpan{TraceId: id, Id: id, Name: name}))
+		}
+	}
+	for _, span := range rs.ingested {
+		counts[span.Name]++
+	}
+	assert.Equal(t, traces, counts["checkout.charge"])
+	assert.Equal(t, 0, counts["healthcheck"])
+	assert.InDelta(t, traces/2, counts["search"], traces/20)
+
+	sink.Flush()
+	assert.Equal(t, 1, rs.flushes)
+	skipped := <-ch
+	require.Len(t, skipped.Metrics, 1)
+	assert.Equal(t, MetricKeyTotalSpansSkipped, skipped.Metrics[0].Name)
+	assert.Equal(t, float32(2*traces-counts["search"]), skipped.Metrics[0].Value)
+}
+
+func TestSampleSpansDefaultRate(t *testing.T) {
+	rs := &recordingSpanSink{}
+	sink := SampleSpans(rs, []SpanSamplingRule{{Operation: "checkout.*", RatePercent: 100}}, 10)
+	require.NoError(t, sink.Start(nil))
+
+	kept := 0
+	for id := int64(1); id <= 10000; id++ {
+		span := &ssf.SSFSpan{TraceId: id, Id: id, Name: "search"}
+		require.NoError(t, sink.Ingest(span))
+		// another span of the same trace, with the same rate, should
+		// get the same decision:
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{TraceId: id, Id: id + 1, ParentId: id, Name: "search.db"}))
+		if len(rs.ingested) > 0 && rs.ingested[len(rs.ingested)-1].TraceId == id {
+			kept++
+			require.Len(t, rs.ingested, 2*kept, "spans of trace %d should be kept together", id)
+		}
+	}
+	assert.InDelta(t, 1000, kept, 150)
+}
@@ -18,6 +18,9 @@ type metricExtractionSink struct {
 	workers                []Processor
 	indicatorSpanTimerName string
 	objectiveSpanTimerName string
+	spanMetrics            SpanMetrics
+	operations             *valueLimiter
+	tagValues              *valueLimiter
 	log                    *logrus.Logger
 	traceClient            *trace.Client
 	spansProcessed         int64
@@ -41,14 +44,22 @@ type DerivedMetricsSink interface {
 // NewMetricExtractionSink sets up and creates a span sink that
 // extracts metrics ("samples") from SSF spans and reports them to a
 // veneur's metrics workers.
-func NewMetricExtractionSink(mw []Processor, indicatorTimerName, objectiveTimerName string, cl *trace.Client, log *logrus.Logger) (DerivedMetricsSink, error) {
-	return &metricExtractionSink{
+func NewMetricExtractionSink(mw []Processor, indicatorTimerName, objectiveTimerName string, spanMetrics SpanMetrics, cl *trace.Client, log *logrus.Logger) (DerivedMetricsSink, error) {
+	sink := &metricExtractionSink{
 		workers:                mw,
 		indicatorSpanTimerName: indicatorTimerName,
 		objectiveSpanTimerName: objectiveTimerName,
+		spanMetrics:            spanMetrics,
 		traceClient:            cl,
 		log:                    log,
-	}, nil
+	}
+	if spanMetrics.MaxOperations > 0 {
+		sink.operations = newValueLimiter(spanMetrics.MaxOperations)
+	}
+	if spanMetrics.MaxTagValues > 0 {
+		sink.tagValues = newValueLimiter(spanMetrics.MaxTagValues)
+	}
+	return sink, nil
 }
 
 // Name returns "metric_extraction".
@@ -133,7 +144,16 @@ func (m *metricExtractionSink) Ingest(span *ssf.SSFSpan) error {
 	}
 	metricsCount += len(spanMetrics)
 
-	m.sendMetrics(append(indicatorMetrics, spanMetrics...))
+	derivedMetrics, err := m.convertSpanMetrics(span)
+	if err != nil {
+		m.log.WithError(err).
+			WithField("span_name", span.Name).
+			Warn("Couldn't derive metrics for span")
+		return err
+	}
+	metricsCount += len(derivedMetrics)
+
+	m.sendMetrics(append(append(indicatorMetrics, spanMetrics...), derivedMetrics...))
 	return nil
 }
 
@@ -143,4 +163,5 @@ func (m *metricExtractionSink) Flush() {
 		ssf.Count(sinks.MetricKeyTotalSpansFlushed, float32(atomic.SwapInt64(&m.spansProcessed, 0)), tags),
 		ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(atomic.SwapInt64(&m.metricsGenerated, 0)), tags),
 	})
+	m.reportCapped()
 }
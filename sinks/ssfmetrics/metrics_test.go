@@ -1,6 +1,7 @@
 package ssfmetrics_test
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -17,7 +18,7 @@ func TestMetricExtractor(t *testing.T) {
 	logger := logrus.StandardLogger()
 	worker := veneur.NewWorker(0, true, false, nil, logger, nil)
 	workers := []ssfmetrics.Processor{worker}
-	sink, err := ssfmetrics.NewMetricExtractionSink(workers, "foo", "", nil, logger)
+	sink, err := ssfmetrics.NewMetricExtractionSink(workers, "foo", "", ssfmetrics.SpanMetrics{}, nil, logger)
 	require.NoError(t, err)
 
 	start := time.Now()
@@ -58,7 +59,7 @@ func setupBench() (*ssf.SSFSpan, sinks.SpanSink) {
 	logger := logrus.StandardLogger()
 	worker := veneur.NewWorker(0, true, false, nil, logger, nil)
 	workers := []ssfmetrics.Processor{worker}
-	sink, err := ssfmetrics.NewMetricExtractionSink(workers, "foo", "", nil, logger)
+	sink, err := ssfmetrics.NewMetricExtractionSink(workers, "foo", "", ssfmetrics.SpanMetrics{}, nil, logger)
 	if err != nil {
 		panic(err)
 	}
@@ -111,7 +112,7 @@ func TestIndicatorMetricExtractor(t *testing.T) {
 	logger := logrus.StandardLogger()
 	worker := veneur.NewWorker(0, true, false, nil, logger, nil)
 	workers := []ssfmetrics.Processor{worker}
-	sink, err := ssfmetrics.NewMetricExtractionSink(workers, "foo", "bar", nil, logger)
+	sink, err := ssfmetrics.NewMetricExtractionSink(workers, "foo", "bar", ssfmetrics.SpanMetrics{}, nil, logger)
 	require.NoError(t, err)
 
 	start := time.Now()
@@ -146,3 +147,120 @@ func TestIndicatorMetricExtractor(t *testing.T) {
 	close(worker.PacketChan)
 	assert.Equal(t, 2, <-done, "Should have sent the right number of metrics")
 }
+
+func TestSpanDurationMetrics(t *testing.T) {
+	logger := logrus.StandardLogger()
+	worker := veneur.NewWorker(0, true, false, nil, logger, nil)
+	workers := []ssfmetrics.Processor{worker}
+	sink, err := ssfmetrics.NewMetricExtractionSink(workers, "", "", ssfmetrics.SpanMetrics{
+		DurationTimerName: "span.duration_ns",
+		MaxOperations:     2,
+	}, nil, logger)
+	require.NoError(t, err)
+
+	start := time.Now()
+	operations := map[string]int{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range worker.PacketChan {
+			if m.Name != "span.duration_ns" {
+				t.Logf("Received additional metric %#v", m)
+				continue
+			}
+			assert.Equal(t, "histogram", m.Type)
+			assert.Equal(t, float64(time.Second), m.Value)
+			assert.Contains(t, m.Tags, "service:spline")
+			for _, tag := range m.Tags {
+				if strings.HasPrefix(tag, "operation:") {
+					operations[strings.TrimPrefix(tag, "operation:")]++
+				}
+			}
+		}
+	}()
+	for i, name := range []string{"reticulate", "render", "reticulate", "frobnicate", "twiddle"} {
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			Id:             int64(i + 1),
+			TraceId:        5,
+			Service:        "spline",
+			Name:           name,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+		}))
+	}
+	sink.Flush()
+	require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+		Id:             10,
+		TraceId:        5,
+		Service:        "spline",
+		Name:           "twiddle",
+		StartTimestamp: start.UnixNano(),
+		EndTimestamp:   start.Add(time.Second).UnixNano(),
+	}))
+	close(worker.PacketChan)
+	<-done
+
+	assert.Equal(t, map[string]int{
+		"reticulate": 2,
+		"render":     1,
+		"other":      2,
+		"twiddle":    1,
+	}, operations, "the operations past the cap should be counted as other until the next flush")
+}
+
+func TestSpanErrorMetrics(t *testing.T) {
+	logger := logrus.StandardLogger()
+	worker := veneur.NewWorker(0, true, false, nil, logger, nil)
+	workers := []ssfmetrics.Processor{worker}
+	sink, err := ssfmetrics.NewMetricExtractionSink(workers, "", "", ssfmetrics.SpanMetrics{
+		CountCounterName: "span.total",
+		ErrorCounterName: "span.errors",
+		Tags:             []string{"region", "customer"},
+		MaxTagValues:     1,
+	}, nil, logger)
+	require.NoError(t, err)
+
+	start := time.Now()
+	counts := map[string][]string{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range worker.PacketChan {
+			if m.Name != "span.total" && m.Name != "span.errors" {
+				t.Logf("Received additional metric %#v", m)
+				continue
+			}
+			assert.Equal(t, "counter", m.Type)
+			assert.Contains(t, m.Tags, "service:checkout")
+			assert.Contains(t, m.Tags, "operation:charge")
+			counts[m.Name] = append(counts[m.Name], m.JoinedTags)
+		}
+	}()
+	for i, customer := range []string{"a", "b", ""} {
+		tags := map[string]string{"region": "us", "ignored": "x"}
+		if customer != "" {
+			tags["customer"] = customer
+		}
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			Id:             int64(i + 1),
+			TraceId:        5,
+			Service:        "checkout",
+			Name:           "charge",
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+			Error:          i == 1,
+			Tags:           tags,
+		}))
+	}
+	close(worker.PacketChan)
+	<-done
+
+	assert.Equal(t, []string{
+		"customer:a,operation:charge,region:us,service:checkout",
+		"customer:other,operation:charge,region:us,service:checkout",
+		"operation:charge,region:us,service:checkout",
+	}, counts["span.total"])
+	assert.Equal(t, []string{
+		"customer:other,operation:charge,region:us,service:checkout",
+	}, counts["span.errors"], "only the spans with errors should be counted")
+}
@@ -0,0 +1,181 @@
+package ssfmetrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// otherValue is the operation, or tag value, that the spans of a service
+// are counted under once the service has too many distinct ones.
+const otherValue = "other"
+
+// operationKey is the key of the operation of a span among its tags.
+const operationKey = "operation"
+
+// SpanMetrics configures the metrics that the sink derives from every
+// span, tagged with the span's service and its name (its "operation"),
+// unlike the indicator metrics, which only come from indicator spans.
+type SpanMetrics struct {
+	// DurationTimerName, if set, is the name of the histogram of the
+	// durations of the spans, in nanoseconds.
+	DurationTimerName string
+	// CountCounterName and ErrorCounterName, if set, are the names of
+	// the counters of the spans, and of the spans that have their error
+	// flag set, so that their ratio is the error rate of each operation.
+	CountCounterName string
+	ErrorCounterName string
+	// Tags are the keys of the span tags that the metrics are tagged
+	// with too, if the spans have them.
+	Tags []string
+	// MaxOperations, if positive, caps the number of operations of each
+	// service that get metrics of their own in a flush interval, so that
+	// a service that names its spans after e.g. IDs can't create an
+	// unbounded number of series. The spans with the operations past the
+	// cap get the operation "other" instead.
+	MaxOperations int
+	// MaxTagValues, if positive, likewise caps the number of values of
+	// each of the Tags for each service.
+	MaxTagValues int
+}
+
+func (c SpanMetrics) enabled() bool {
+	return c.DurationTimerName != "" || c.CountCounterName != "" || c.ErrorCounterName != ""
+}
+
+// valueLimiter caps the number of values that each key (the operation,
+// or a tag) has for each service in a flush interval.
+type valueLimiter struct {
+	max int
+
+	mtx    sync.Mutex
+	values map[serviceKey]map[string]struct{}
+	// capped counts the spans whose values were past the cap.
+	capped map[serviceKey]int64
+}
+
+type serviceKey struct {
+	service string
+	key     string
+}
+
+func newValueLimiter(max int) *valueLimiter {
+	return &valueLimiter{
+		max:    max,
+		values: map[serviceKey]map[string]struct{}{},
+		capped: map[serviceKey]int64{},
+	}
+}
+
+// value returns the value that a span of the service gets its metrics
+// under for the key: its own, or "other" if the key already has too many
+// values for the service.
+func (v *valueLimiter) value(service, key, value string) string {
+	sk := serviceKey{service: service, key: key}
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	values, ok := v.values[sk]
+	if !ok {
+		values = map[string]struct{}{}
+		v.values[sk] = values
+	}
+	if _, ok := values[value]; ok {
+		return value
+	}
+	if len(values) < v.max {
+		values[value] = struct{}{}
+		return value
+	}
+	v.capped[sk]++
+	return otherValue
+}
+
+// flush starts a new flush interval, and returns the number of spans of
+// each service and key that were past the cap in the one that ended.
+func (v *valueLimiter) flush() map[serviceKey]int64 {
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+	capped := v.capped
+	v.values = map[serviceKey]map[string]struct{}{}
+	v.capped = map[serviceKey]int64{}
+	return capped
+}
+
+// reportCapped reports the spans that were past the caps in the flush
+// interval that ended.
+func (m *metricExtractionSink) reportCapped() {
+	var capped []*ssf.SSFSample
+	for _, limiter := range []*valueLimiter{m.operations, m.tagValues} {
+		if limiter == nil {
+			continue
+		}
+		for sk, spans := range limiter.flush() {
+			if sk.key == operationKey {
+				capped = append(capped, ssf.Count("ssf.span_operations_capped_total", float32(spans), map[string]string{"service": sk.service}))
+			} else {
+				capped = append(capped, ssf.Count("ssf.span_tag_values_capped_total", float32(spans), map[string]string{"service": sk.service, "tag": sk.key}))
+			}
+		}
+	}
+	if len(capped) > 0 {
+		metrics.ReportBatch(m.traceClient, capped)
+	}
+}
+
+// convertSpanMetrics derives the configured metrics from a span.
+func (m *metricExtractionSink) convertSpanMetrics(span *ssf.SSFSpan) ([]samplers.UDPMetric, error) {
+	if !m.spanMetrics.enabled() {
+		return nil, nil
+	}
+	operation := span.Name
+	if m.operations != nil {
+		operation = m.operations.value(span.Service, operationKey, span.Name)
+	}
+	tags := map[string]string{
+		"service":    span.Service,
+		operationKey: operation,
+	}
+	for _, key := range m.spanMetrics.Tags {
+		value, ok := span.Tags[key]
+		if !ok || key == "service" || key == operationKey {
+			continue
+		}
+		if m.tagValues != nil {
+			value = m.tagValues.value(span.Service, key, value)
+		}
+		tags[key] = value
+	}
+
+	var samples []*ssf.SSFSample
+	if name := m.spanMetrics.DurationTimerName; name != "" {
+		duration := time.Duration(span.EndTimestamp - span.StartTimestamp)
+		sample := ssf.Timing(name, duration, time.Nanosecond, tags)
+		sample.Name = name // Ensure the name is free from any name prefixes, like "veneur."
+		samples = append(samples, sample)
+	}
+	if name := m.spanMetrics.CountCounterName; name != "" {
+		sample := ssf.Count(name, 1, tags)
+		sample.Name = name
+		samples = append(samples, sample)
+	}
+	if name := m.spanMetrics.ErrorCounterName; name != "" && span.Error {
+		sample := ssf.Count(name, 1, tags)
+		sample.Name = name
+		samples = append(samples, sample)
+	}
+
+	derived := make([]samplers.UDPMetric, 0, len(samples))
+	for _, sample := range samples {
+		metric, err := samplers.ParseMetricSSF(sample)
+		if err != nil {
+			return derived, err
+		}
+		metric.TraceID = span.TraceId
+		metric.SpanID = span.Id
+		derived = append(derived, metric)
+	}
+	return derived, nil
+}
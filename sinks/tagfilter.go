@@ -0,0 +1,117 @@
+package sinks
+
+import (
+	"context"
+	"strings"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// MetricKeyTotalTagsDropped is emitted as a counter, tagged with
+// `sink:sink.Name()`, by metric sinks that filter tags with
+// FilterMetricTags.
+const MetricKeyTotalTagsDropped = "sink.tags_dropped_total"
+
+// TagFilterSink is a MetricSink that removes tags from metrics before
+// passing them on to another sink.
+type TagFilterSink struct {
+	MetricSink
+	allow       map[string]struct{}
+	deny        map[string]struct{}
+	traceClient *trace.Client
+}
+
+var _ MetricSink = &TagFilterSink{}
+
+// FilterMetricTags returns a sink that filters the tags of the metrics
+// flushed to sink by their key. If allow is non-empty, only tags whose
+// key is in allow are kept; and tags whose key is in deny are always
+// dropped. Metrics are copied rather than modified, so other sinks still
+// see every tag.
+func FilterMetricTags(sink MetricSink, allow, deny []string) *TagFilterSink {
+	fs := &TagFilterSink{
+		MetricSink: sink,
+		deny:       make(map[string]struct{}, len(deny)),
+	}
+	if len(allow) > 0 {
+		fs.allow = make(map[string]struct{}, len(allow))
+		for _, key := range allow {
+			fs.allow[key] = struct{}{}
+		}
+	}
+	for _, key := range deny {
+		fs.deny[key] = struct{}{}
+	}
+	return fs
+}
+
+// Unwrap returns the wrapped sink.
+func (fs *TagFilterSink) Unwrap() MetricSink {
+	return fs.MetricSink
+}
+
+// Start starts the wrapped sink.
+func (fs *TagFilterSink) Start(cl *trace.Client) error {
+	fs.traceClient = cl
+	return fs.MetricSink.Start(cl)
+}
+
+// Flush filters the tags of each metric, and flushes the result to the
+// wrapped sink.
+func (fs *TagFilterSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	filtered := make([]samplers.InterMetric, len(interMetrics))
+	var dropped int64
+	for i, metric := range interMetrics {
+		filtered[i] = metric
+		if !IsAcceptableMetric(metric, fs) {
+			continue
+		}
+		tags := fs.filterTags(metric.Tags)
+		dropped += int64(len(metric.Tags) - len(tags))
+		filtered[i].Tags = tags
+	}
+	if dropped > 0 {
+		metrics.ReportOne(fs.traceClient, ssf.Count(MetricKeyTotalTagsDropped, float32(dropped), map[string]string{"sink": fs.Name()}))
+	}
+	return fs.MetricSink.Flush(ctx, filtered)
+}
+
+// filterTags returns the tags that pass the filter. If all of them do,
+// it returns tags itself; otherwise it returns a new slice.
+func (fs *TagFilterSink) filterTags(tags []string) []string {
+	var kept []string
+	for i, tag := range tags {
+		if fs.keep(tag) {
+			if kept != nil {
+				kept = append(kept, tag)
+			}
+			continue
+		}
+		if kept == nil {
+			kept = make([]string, i, len(tags)-1)
+			copy(kept, tags[:i])
+		}
+	}
+	if kept == nil {
+		return tags
+	}
+	return kept
+}
+
+func (fs *TagFilterSink) keep(tag string) bool {
+	key := tag
+	if i := strings.IndexByte(tag, ':'); i >= 0 {
+		key = tag[:i]
+	}
+	if _, ok := fs.deny[key]; ok {
+		return false
+	}
+	if fs.allow == nil {
+		return true
+	}
+	_, ok := fs.allow[key]
+	return ok
+}
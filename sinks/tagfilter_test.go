@@ -0,0 +1,70 @@
+package sinks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+)
+
+type recordingSink struct {
+	flushed []samplers.InterMetric
+}
+
+func (r *recordingSink) Name() string                 { return "recording" }
+func (r *recordingSink) Start(cl *trace.Client) error { return nil }
+func (r *recordingSink) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	r.flushed = metrics
+	return nil
+}
+func (r *recordingSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {}
+
+func TestFilterMetricTags(t *testing.T) {
+	tags := []string{"host:a", "request_id:1234", "service:web", "novalue"}
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		want  []string
+	}{
+		{"no filter", nil, nil, tags},
+		{"deny", nil, []string{"request_id"}, []string{"host:a", "service:web", "novalue"}},
+		{"deny tag without value", nil, []string{"novalue", "host"}, []string{"request_id:1234", "service:web"}},
+		{"allow", []string{"service", "host"}, nil, []string{"host:a", "service:web"}},
+		{"allow and deny", []string{"service", "host"}, []string{"host"}, []string{"service:web"}},
+		{"allow nothing that's there", []string{"env"}, nil, []string{}},
+	}
+	for _, elt := range tests {
+		test := elt
+		t.Run(test.name, func(t *testing.T) {
+			rs := &recordingSink{}
+			sink := FilterMetricTags(rs, test.allow, test.deny)
+			require.NoError(t, sink.Start(nil))
+			assert.Equal(t, "recording", sink.Name())
+
+			metrics := []samplers.InterMetric{
+				{Name: "a.b.c", Tags: tags},
+				{Name: "a.b.d", Tags: tags, Sinks: samplers.RouteInformation{"other": struct{}{}}},
+			}
+			require.NoError(t, sink.Flush(context.Background(), metrics))
+			require.Len(t, rs.flushed, 2)
+			assert.Equal(t, test.want, rs.flushed[0].Tags)
+			assert.Equal(t, tags, rs.flushed[1].Tags, "metrics not routed to the sink are left alone")
+			assert.Equal(t, []string{"host:a", "request_id:1234", "service:web", "novalue"}, metrics[0].Tags,
+				"the original metric must not be modified")
+		})
+	}
+}
+
+func TestUnwrapMetricSink(t *testing.T) {
+	rs := &recordingSink{}
+	assert.Equal(t, MetricSink(rs), UnwrapMetricSink(rs))
+	assert.Equal(t, MetricSink(rs), UnwrapMetricSink(FilterMetricTags(rs, nil, []string{"host"})))
+	assert.Equal(t, MetricSink(rs), UnwrapMetricSink(FilterMetricTags(FilterMetricTags(rs, nil, nil), nil, nil)),
+		"nested wrappers should be unwrapped")
+}
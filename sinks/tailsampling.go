@@ -0,0 +1,205 @@
+package sinks
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// MetricKeyTailSampledTraces counts the traces that a tail-sampling sink
+// decided on, tagged with the decision: "kept", "dropped", or "evicted"
+// for the traces that were dropped to make room before they were decided.
+const MetricKeyTailSampledTraces = "sink.tail_sampling.traces_total"
+
+// TailSampling configures a TailSamplingSink.
+type TailSampling struct {
+	// DecisionWait is how long the spans of a trace are buffered, from
+	// its first span, before the trace is decided on.
+	DecisionWait time.Duration
+	// LatencyThreshold, if positive, keeps the traces that have a span
+	// that took at least this long.
+	LatencyThreshold time.Duration
+	// RatePercent is the rate at which the traces without errors or slow
+	// spans are kept.
+	RatePercent float64
+	// MaxSpans is the most spans that the sink buffers at once. Past
+	// it, the oldest traces are dropped before they're decided on. Each
+	// sink has its own budget, even if they share the configuration.
+	MaxSpans int
+}
+
+// TailSamplingSink is a SpanSink that decides which traces to pass on to
+// another sink after it has seen their spans, rather than from their
+// trace IDs alone: it buffers the spans of each trace for a while, and
+// then keeps the trace if any of its spans has an error or is slow, and a
+// sample of the other traces otherwise. The spans of a trace that arrive
+// after it was decided on follow the decision, as long as it's
+// remembered.
+type TailSamplingSink struct {
+	SpanSink
+	config      TailSampling
+	threshold   uint64
+	traceClient *trace.Client
+	now         func() time.Time
+
+	mtx sync.Mutex
+	// traces are the buffered traces by ID, and pending holds them in
+	// the order they're due to be decided.
+	traces  map[int64]*list.Element
+	pending *list.List
+	spans   int
+	// decisions remembers the decisions on the last traces, up to one
+	// for each buffered span, and decided holds their IDs in the order
+	// they were made in.
+	decisions map[int64]bool
+	decided   *list.List
+
+	// the decisions since the last flush.
+	kept    int64
+	dropped int64
+	evicted int64
+}
+
+type bufferedTrace struct {
+	id          int64
+	due         time.Time
+	spans       []*ssf.SSFSpan
+	interesting bool
+}
+
+var _ SpanSink = &TailSamplingSink{}
+
+// TailSampleSpans returns a sink that passes the traces that config keeps
+// on to sink. Spans that aren't part of a trace are passed on right away.
+// Traces are only decided on when spans are ingested and when the sink is
+// flushed, so a trace can be buffered until the next flush after it's due.
+func TailSampleSpans(sink SpanSink, config TailSampling) *TailSamplingSink {
+	return &TailSamplingSink{
+		SpanSink:  sink,
+		config:    config,
+		threshold: SamplingThreshold(config.RatePercent),
+		now:       time.Now,
+		traces:    map[int64]*list.Element{},
+		pending:   list.New(),
+		decisions: map[int64]bool{},
+		decided:   list.New(),
+	}
+}
+
+// Start starts the wrapped sink.
+func (ts *TailSamplingSink) Start(cl *trace.Client) error {
+	ts.traceClient = cl
+	return ts.SpanSink.Start(cl)
+}
+
+// Ingest buffers the span until its trace is decided on, and passes on
+// the spans of the traces that were decided on to be kept.
+func (ts *TailSamplingSink) Ingest(span *ssf.SSFSpan) error {
+	if span.TraceId == 0 {
+		return ts.SpanSink.Ingest(span)
+	}
+	now := ts.now()
+
+	ts.mtx.Lock()
+	keep := ts.decideDue(now)
+	if decision, ok := ts.decisions[span.TraceId]; ok {
+		if decision {
+			keep = append(keep, span)
+		}
+	} else {
+		ts.buffer(span, now)
+	}
+	ts.mtx.Unlock()
+
+	return ts.ingest(keep)
+}
+
+// Flush decides on the traces that are due, reports the decisions since
+// the last flush, and flushes the wrapped sink.
+func (ts *TailSamplingSink) Flush() {
+	ts.mtx.Lock()
+	keep := ts.decideDue(ts.now())
+	kept, dropped, evicted := ts.kept, ts.dropped, ts.evicted
+	ts.kept, ts.dropped, ts.evicted = 0, 0, 0
+	ts.mtx.Unlock()
+	ts.ingest(keep)
+
+	tags := func(decision string) map[string]string {
+		return map[string]string{"sink": ts.Name(), "decision": decision}
+	}
+	metrics.ReportBatch(ts.traceClient, []*ssf.SSFSample{
+		ssf.Count(MetricKeyTailSampledTraces, float32(kept), tags("kept")),
+		ssf.Count(MetricKeyTailSampledTraces, float32(dropped), tags("dropped")),
+		ssf.Count(MetricKeyTailSampledTraces, float32(evicted), tags("evicted")),
+	})
+	ts.SpanSink.Flush()
+}
+
+// ingest passes spans on to the wrapped sink, and returns the last error
+// it returned.
+func (ts *TailSamplingSink) ingest(spans []*ssf.SSFSpan) error {
+	var err error
+	for _, span := range spans {
+		if ingestErr := ts.SpanSink.Ingest(span); ingestErr != nil {
+			err = ingestErr
+		}
+	}
+	return err
+}
+
+// buffer adds the span to its trace, and evicts the oldest traces if
+// there are too many spans. It must be called with mtx held.
+func (ts *TailSamplingSink) buffer(span *ssf.SSFSpan, now time.Time) {
+	var bt *bufferedTrace
+	if elem, ok := ts.traces[span.TraceId]; ok {
+		bt = elem.Value.(*bufferedTrace)
+	} else {
+		bt = &bufferedTrace{id: span.TraceId, due: now.Add(ts.config.DecisionWait)}
+		ts.traces[span.TraceId] = ts.pending.PushBack(bt)
+	}
+	bt.spans = append(bt.spans, span)
+	if span.Error || (ts.config.LatencyThreshold > 0 && time.Duration(span.EndTimestamp-span.StartTimestamp) >= ts.config.LatencyThreshold) {
+		bt.interesting = true
+	}
+	ts.spans++
+
+	for ts.spans > ts.config.MaxSpans && ts.pending.Len() > 0 {
+		oldest := ts.pending.Remove(ts.pending.Front()).(*bufferedTrace)
+		delete(ts.traces, oldest.id)
+		ts.spans -= len(oldest.spans)
+		ts.evicted++
+	}
+}
+
+// decideDue decides on the traces that are due at now, and returns the
+// spans of the ones that are kept. It must be called with mtx held.
+func (ts *TailSamplingSink) decideDue(now time.Time) []*ssf.SSFSpan {
+	var keep []*ssf.SSFSpan
+	for ts.pending.Len() > 0 {
+		bt := ts.pending.Front().Value.(*bufferedTrace)
+		if now.Before(bt.due) {
+			break
+		}
+		ts.pending.Remove(ts.pending.Front())
+		delete(ts.traces, bt.id)
+		ts.spans -= len(bt.spans)
+
+		decision := bt.interesting || TraceSampled(bt.id, ts.threshold)
+		if decision {
+			ts.kept++
+			keep = append(keep, bt.spans...)
+		} else {
+			ts.dropped++
+		}
+		ts.decisions[bt.id] = decision
+		ts.decided.PushBack(bt.id)
+		for ts.decided.Len() > ts.config.MaxSpans {
+			delete(ts.decisions, ts.decided.Remove(ts.decided.Front()).(int64))
+		}
+	}
+	return keep
+}
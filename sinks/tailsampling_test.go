@@ -0,0 +1,104 @@
+package sinks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+)
+
+func TestTailSampleSpans(t *testing.T) {
+	rs := &recordingSpanSink{}
+	sink := TailSampleSpans(rs, TailSampling{
+		DecisionWait:     10 * time.Second,
+		LatencyThreshold: time.Second,
+		RatePercent:      0,
+		MaxSpans:         100,
+	})
+	now := time.Unix(1000, 0)
+	sink.now = func() time.Time { return now }
+	ch := make(chan *ssf.SSFSpan, 1)
+	cl, err := trace.NewChannelClient(ch)
+	require.NoError(t, err)
+	defer cl.Close()
+	require.NoError(t, sink.Start(cl))
+
+	span := func(traceID, id int64, duration time.Duration, isError bool) *ssf.SSFSpan {
+		return &ssf.SSFSpan{
+			TraceId:        traceID,
+			Id:             id,
+			Name:           "op",
+			StartTimestamp: now.UnixNano(),
+			EndTimestamp:   now.Add(duration).UnixNano(),
+			Error:          isError,
+		}
+	}
+	require.NoError(t, sink.Ingest(&ssf.SSFSpan{Name: "not a trace"}))
+	assert.Len(t, rs.ingested, 1, "spans that aren't part of a trace should be passed on")
+
+	// trace 1 has an error, trace 2 is slow, and trace 3 is neither
+	require.NoError(t, sink.Ingest(span(1, 1, time.Millisecond, false)))
+	require.NoError(t, sink.Ingest(span(1, 2, time.Millisecond, true)))
+	require.NoError(t, sink.Ingest(span(2, 3, 2*time.Second, false)))
+	require.NoError(t, sink.Ingest(span(3, 4, time.Millisecond, false)))
+	assert.Len(t, rs.ingested, 1, "the traces shouldn't be decided on before they're due")
+
+	now = now.Add(10 * time.Second)
+	sink.Flush()
+	var ids []int64
+	for _, span := range rs.ingested[1:] {
+		ids = append(ids, span.Id)
+	}
+	assert.Equal(t, []int64{1, 2, 3}, ids)
+
+	// the late spans follow the decision on their trace
+	require.NoError(t, sink.Ingest(span(1, 5, time.Millisecond, false)))
+	require.NoError(t, sink.Ingest(span(3, 6, time.Millisecond, true)))
+	assert.Equal(t, int64(5), rs.ingested[len(rs.ingested)-1].Id)
+
+	decisions := map[string]float32{}
+	for _, sample := range (<-ch).Metrics {
+		assert.Equal(t, MetricKeyTailSampledTraces, sample.Name)
+		decisions[sample.Tags["decision"]] = sample.Value
+	}
+	assert.Equal(t, map[string]float32{"kept": 2, "dropped": 1, "evicted": 0}, decisions)
+}
+
+func TestTailSampleSpansRate(t *testing.T) {
+	rs := &recordingSpanSink{}
+	sink := TailSampleSpans(rs, TailSampling{RatePercent: 50, MaxSpans: 100000})
+	const traces = 10000
+	for id := int64(1); id <= traces; id++ {
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{TraceId: id, Id: id, Name: "op"}))
+	}
+	sink.Flush()
+	assert.InDelta(t, traces/2, len(rs.ingested), traces/20)
+}
+
+func TestTailSampleSpansEviction(t *testing.T) {
+	rs := &recordingSpanSink{}
+	sink := TailSampleSpans(rs, TailSampling{
+		DecisionWait: time.Minute,
+		RatePercent:  100,
+		MaxSpans:     3,
+	})
+	now := time.Unix(1000, 0)
+	sink.now = func() time.Time { return now }
+
+	for id := int64(1); id <= 4; id++ {
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{TraceId: id/2 + 1, Id: id, Name: "op"}))
+	}
+	// traces 1 and 2 fit, and trace 3 evicts trace 1
+	now = now.Add(time.Minute)
+	sink.Flush()
+	var ids []int64
+	for _, span := range rs.ingested {
+		ids = append(ids, span.Id)
+	}
+	assert.Equal(t, []int64{2, 3, 4}, ids)
+	assert.Zero(t, sink.spans)
+}
@@ -0,0 +1,244 @@
+package wavefront
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stripe/veneur/v14/samplers"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// bufferSize is how many bytes of points are batched into each write to
+// the proxy.
+const bufferSize = 64 * 1024
+
+// dialTimeout bounds how long connecting to the proxy can take, if the
+// flush's context doesn't have an earlier deadline.
+const dialTimeout = 10 * time.Second
+
+// MetricSink is a metric sink that sends metrics to a Wavefront proxy
+// over TCP, in the Wavefront data format:
+//
+//	<name> <value> <timestamp> source=<source> [<tag key>="<tag value>" ...]
+//
+// The source of each point is the metric's hostname, or veneur's own.
+// Each tag with a value becomes a point tag; tags without one are
+// dropped, since Wavefront requires point tags to have a value.
+// Histograms and timers arrive at the sink as the percentile and
+// aggregate gauges that veneur computed for them, so each is a separate
+// series. Counters are sent as the count over the flush interval.
+//
+// The connection to the proxy is kept open between flushes, and the sink
+// reconnects if the proxy closed it. If writing to it fails, the sink
+// reconnects and sends the whole flush again, which Wavefront
+// deduplicates since the points are the same.
+type MetricSink struct {
+	addr        string
+	hostname    string
+	logger      *logrus.Logger
+	traceClient *trace.Client
+
+	// mtx serializes flushes, which share conn.
+	mtx  sync.Mutex
+	conn net.Conn
+}
+
+// NewMetricSink returns a new MetricSink that sends metrics to the
+// Wavefront proxy listening at addr (a host:port pair), with hostname
+// as the source of the metrics that don't have their own.
+func NewMetricSink(addr string, hostname string, logger *logrus.Logger) (*MetricSink, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+
+	return &MetricSink{
+		addr:     addr,
+		hostname: hostname,
+		logger:   logger,
+	}, nil
+}
+
+// Name returns the name of this sink.
+func (s *MetricSink) Name() string {
+	return "wavefront"
+}
+
+// Start begins the sink.
+func (s *MetricSink) Start(cl *trace.Client) error {
+	s.traceClient = cl
+	return nil
+}
+
+// Flush sends metrics to the Wavefront proxy.
+func (s *MetricSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	span, ctx := trace.StartSpanFromContext(ctx, "")
+	defer span.ClientFinish(s.traceClient)
+
+	accepted := make([]samplers.InterMetric, 0, len(interMetrics))
+	for _, metric := range interMetrics {
+		if sinks.IsAcceptableMetric(metric, s) {
+			accepted = append(accepted, metric)
+		}
+	}
+	if len(accepted) == 0 {
+		return nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	flushStart := time.Now()
+	var err error
+	// A failed flush is tried again once, on a new connection.
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = s.write(ctx, accepted); err == nil {
+			break
+		}
+		s.logger.WithError(err).Warn("Failed to send metrics to the Wavefront proxy")
+		metrics.ReportOne(s.traceClient, ssf.Count("sinks.wavefront.write_errors_total", 1, map[string]string{"sink": s.Name()}))
+	}
+	if err != nil {
+		span.Error(err)
+		return err
+	}
+
+	tags := map[string]string{"sink": s.Name()}
+	span.Add(
+		ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(flushStart), time.Nanosecond, tags),
+		ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(len(accepted)), tags),
+	)
+	return nil
+}
+
+// write writes the points of the metrics to the proxy, connecting to it
+// first if needed. If it fails, it closes the connection, so that the
+// next write reconnects. It must be called with the mutex held.
+func (s *MetricSink) write(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	if s.conn != nil && peerClosed(s.conn) {
+		s.disconnect()
+	}
+	if s.conn == nil {
+		ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+		dialer := net.Dialer{}
+		conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	deadline, _ := ctx.Deadline()
+	s.conn.SetWriteDeadline(deadline)
+
+	w := bufio.NewWriterSize(s.conn, bufferSize)
+	var buf []byte
+	for _, metric := range interMetrics {
+		buf = appendPoint(buf[:0], metric, s.hostname)
+		if _, err := w.Write(buf); err != nil {
+			s.disconnect()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		s.disconnect()
+		return err
+	}
+	return nil
+}
+
+// peerClosed reports whether the proxy closed the connection, which
+// otherwise only becomes apparent once the points written to it are lost.
+// The proxy never writes to it, so reading from it only returns once it's
+// closed.
+func peerClosed(conn net.Conn) bool {
+	conn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	defer conn.SetReadDeadline(time.Time{})
+	var b [1]byte
+	_, err := conn.Read(b[:])
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return false
+	}
+	return err != nil
+}
+
+func (s *MetricSink) disconnect() {
+	s.conn.Close()
+	s.conn = nil
+}
+
+// FlushOtherSamples is a no-op, as the Wavefront data format has no
+// events or service checks.
+func (s *MetricSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+}
+
+// appendPoint appends the line of the metric's point to buf.
+func appendPoint(buf []byte, metric samplers.InterMetric, hostname string) []byte {
+	buf = appendName(buf, metric.Name)
+	buf = append(buf, ' ')
+	buf = strconv.AppendFloat(buf, metric.Value, 'g', -1, 64)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, metric.Timestamp, 10)
+
+	source := metric.HostName
+	if source == "" {
+		source = hostname
+	}
+	buf = append(buf, " source="...)
+	buf = appendQuoted(buf, source)
+
+	for _, tag := range metric.Tags {
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) != 2 || kv[1] == "" || kv[0] == "source" || kv[0] == "veneursinkonly" {
+			continue
+		}
+		buf = append(buf, ' ')
+		buf = appendName(buf, kv[0])
+		buf = append(buf, '=')
+		buf = appendQuoted(buf, kv[1])
+	}
+	return append(buf, '\n')
+}
+
+// appendName appends a metric name or tag key to buf, replacing the
+// characters that Wavefront doesn't allow in them with underscores.
+func appendName(buf []byte, name string) []byte {
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '.', c == '_', c == '-':
+		default:
+			c = '_'
+		}
+		buf = append(buf, c)
+	}
+	return buf
+}
+
+// appendQuoted appends a double-quoted value to buf, escaping the double
+// quotes in it. Newlines, which would end the line, become spaces.
+func appendQuoted(buf []byte, value string) []byte {
+	buf = append(buf, '"')
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '"':
+			buf = append(buf, '\\', '"')
+		case '\n':
+			buf = append(buf, ' ')
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return append(buf, '"')
+}
@@ -0,0 +1,79 @@
+package wavefront
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func TestNewMetricSink(t *testing.T) {
+	_, err := NewMetricSink("localhost:2878", "", nil)
+	assert.NoError(t, err)
+	_, err = NewMetricSink("localhost", "", nil)
+	assert.Error(t, err, "the port is required")
+}
+
+func TestAppendPoint(t *testing.T) {
+	line := appendPoint(nil, samplers.InterMetric{
+		Name:      "a.b/c.99percentile",
+		Timestamp: 1476119058,
+		Value:     12.5,
+		Tags:      []string{"foo:bar", "some tag:x:y", "quoted:say \"hi\"", "novalue", "empty:", "veneursinkonly:wavefront"},
+		Type:      samplers.GaugeMetric,
+	}, "glooblestoots")
+	assert.Equal(t, `a.b_c.99percentile 12.5 1476119058 source="glooblestoots" foo="bar" some_tag="x:y" quoted="say \"hi\""`+"\n", string(line))
+
+	line = appendPoint(nil, samplers.InterMetric{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     100,
+		HostName:  "other-host",
+		Type:      samplers.CounterMetric,
+	}, "glooblestoots")
+	assert.Equal(t, `a.b.c 100 1476119058 source="other-host"`+"\n", string(line))
+}
+
+func TestFlushReconnects(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	sink, err := NewMetricSink(ln.Addr().String(), "glooblestoots", logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	metric := samplers.InterMetric{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     1,
+		Type:      samplers.GaugeMetric,
+	}
+	require.NoError(t, sink.Flush(context.Background(), []samplers.InterMetric{metric}))
+
+	conn, err := ln.Accept()
+	require.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, `a.b.c 1 1476119058 source="glooblestoots"`+"\n", line)
+
+	// the proxy going away shouldn't lose the next flush
+	conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	metric.Value = 2
+	require.NoError(t, sink.Flush(context.Background(), []samplers.InterMetric{metric}))
+
+	conn, err = ln.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	line, err = bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, `a.b.c 2 1476119058 source="glooblestoots"`+"\n", line)
+}
@@ -0,0 +1,309 @@
+// Package zipkin provides a span sink that sends spans to a Zipkin
+// server in the Zipkin v2 JSON format.
+package zipkin
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stripe/veneur/v14/protocol"
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+	"github.com/stripe/veneur/v14/trace/metrics"
+)
+
+// DefaultBatchSize is the number of spans sent in a single request if
+// no batch size is configured.
+const DefaultBatchSize = 500
+
+// DefaultBufferSize is the number of spans held between flushes if no
+// buffer size is configured. Spans that arrive while the buffer is
+// full are dropped.
+const DefaultBufferSize = 1 << 14
+
+// Span tags that follow the OpenTracing semantic conventions, which are
+// translated into the fields of Zipkin spans rather than sent as tags.
+const (
+	TagSpanKind    = "span.kind"
+	TagPeerService = "peer.service"
+	TagPeerIPv4    = "peer.ipv4"
+	TagPeerIPv6    = "peer.ipv6"
+	TagPeerPort    = "peer.port"
+)
+
+// zipkinRetries is how many times a request that failed with a network
+// or server error is retried before its spans are dropped.
+const zipkinRetries = 3
+
+// zipkinBackoff is the delay before the first retry; it doubles with
+// each subsequent one.
+var zipkinBackoff = 250 * time.Millisecond
+
+// Endpoint is the network context of a node in a Zipkin span.
+type Endpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+	IPv4        string `json:"ipv4,omitempty"`
+	IPv6        string `json:"ipv6,omitempty"`
+	Port        int    `json:"port,omitempty"`
+}
+
+// Span is a span in the Zipkin v2 format, as defined in
+// https://zipkin.io/zipkin-api/#/default/post_spans
+type Span struct {
+	TraceID        string            `json:"traceId"`
+	ID             string            `json:"id"`
+	ParentID       string            `json:"parentId,omitempty"`
+	Name           string            `json:"name,omitempty"`
+	Kind           string            `json:"kind,omitempty"`
+	Timestamp      int64             `json:"timestamp"`
+	Duration       int64             `json:"duration"`
+	LocalEndpoint  *Endpoint         `json:"localEndpoint,omitempty"`
+	RemoteEndpoint *Endpoint         `json:"remoteEndpoint,omitempty"`
+	Tags           map[string]string `json:"tags,omitempty"`
+}
+
+// SpanSink sends spans to a Zipkin server's /api/v2/spans endpoint.
+//
+// Spans are buffered until the next flush, and then sent as gzipped
+// JSON in batches. The span's service becomes its local endpoint, and
+// the OpenTracing span.kind and peer.* tags its kind and remote
+// endpoint. SSF trace and span IDs are 64 bits wide, so they are sent
+// as 16 hex characters; a Zipkin server with STRICT_TRACE_ID=false
+// joins them with the 128-bit IDs of other tracers by their low 64
+// bits. Spans that are errors get the "error" tag, and veneur's common
+// tags are added to all spans, unless they have a tag of the same name.
+// SSF spans don't record timestamped events, so spans are sent without
+// annotations.
+type SpanSink struct {
+	addr        string
+	batchSize   int
+	bufferSize  int
+	commonTags  map[string]string
+	httpClient  *http.Client
+	log         *logrus.Logger
+	traceClient *trace.Client
+
+	mtx    sync.Mutex
+	buffer []*ssf.SSFSpan
+
+	spansDropped int64
+}
+
+var _ sinks.SpanSink = &SpanSink{}
+
+// NewSpanSink returns a sink that sends spans to the Zipkin endpoint at
+// addr, in requests of at most batchSize spans, holding at most
+// bufferSize spans between flushes.
+func NewSpanSink(addr string, batchSize int, bufferSize int, commonTags map[string]string, httpClient *http.Client, log *logrus.Logger) (*SpanSink, error) {
+	if _, err := url.ParseRequestURI(addr); err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	return &SpanSink{
+		addr:       addr,
+		batchSize:  batchSize,
+		bufferSize: bufferSize,
+		commonTags: commonTags,
+		httpClient: httpClient,
+		log:        log,
+	}, nil
+}
+
+// Name returns the name of this sink.
+func (s *SpanSink) Name() string {
+	return "zipkin"
+}
+
+// Start begins the sink.
+func (s *SpanSink) Start(cl *trace.Client) error {
+	s.traceClient = cl
+	return nil
+}
+
+// Ingest buffers a span until the next flush.
+func (s *SpanSink) Ingest(span *ssf.SSFSpan) error {
+	if err := protocol.ValidateTrace(span); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.buffer) >= s.bufferSize {
+		atomic.AddInt64(&s.spansDropped, 1)
+		return nil
+	}
+	s.buffer = append(s.buffer, span)
+	return nil
+}
+
+// Flush sends the buffered spans to Zipkin.
+func (s *SpanSink) Flush() {
+	s.mtx.Lock()
+	spans := s.buffer
+	s.buffer = make([]*ssf.SSFSpan, 0, len(spans))
+	s.mtx.Unlock()
+
+	flushStart := time.Now()
+	flushed := 0
+	for i := 0; i < len(spans); i += s.batchSize {
+		end := i + s.batchSize
+		if end > len(spans) {
+			end = len(spans)
+		}
+
+		batch := make([]*Span, 0, end-i)
+		for _, span := range spans[i:end] {
+			batch = append(batch, s.convert(span))
+		}
+		err := s.send(context.TODO(), batch)
+		if err != nil {
+			atomic.AddInt64(&s.spansDropped, int64(end-i))
+			s.log.WithFields(logrus.Fields{
+				logrus.ErrorKey: err,
+				"spans":         end - i,
+			}).Warn("Failed to send spans to Zipkin")
+			continue
+		}
+		flushed += end - i
+	}
+
+	tags := map[string]string{"sink": s.Name()}
+	metrics.ReportBatch(s.traceClient, []*ssf.SSFSample{
+		ssf.Timing(sinks.MetricKeySpanFlushDuration, time.Since(flushStart), time.Nanosecond, tags),
+		ssf.Count(sinks.MetricKeyTotalSpansFlushed, float32(flushed), tags),
+		ssf.Count(sinks.MetricKeyTotalSpansDropped, float32(atomic.SwapInt64(&s.spansDropped, 0)), tags),
+	})
+}
+
+// convert translates an SSF span into a Zipkin span. Zipkin's
+// timestamps and durations are in microseconds, while SSF's are in
+// nanoseconds.
+func (s *SpanSink) convert(span *ssf.SSFSpan) *Span {
+	zs := &Span{
+		TraceID:       formatID(span.TraceId),
+		ID:            formatID(span.Id),
+		Name:          span.Name,
+		Timestamp:     span.StartTimestamp / 1e3,
+		Duration:      (span.EndTimestamp - span.StartTimestamp) / 1e3,
+		LocalEndpoint: &Endpoint{ServiceName: span.Service},
+		Tags:          make(map[string]string, len(s.commonTags)+len(span.Tags)),
+	}
+	if span.ParentId > 0 {
+		zs.ParentID = formatID(span.ParentId)
+	}
+	// Zipkin requires the duration of complete spans to be at least
+	// one microsecond.
+	if zs.Duration < 1 {
+		zs.Duration = 1
+	}
+
+	for k, v := range s.commonTags {
+		zs.Tags[k] = v
+	}
+	remote := &Endpoint{}
+	for k, v := range span.Tags {
+		switch k {
+		case TagSpanKind:
+			zs.Kind = strings.ToUpper(v)
+		case TagPeerService:
+			remote.ServiceName = v
+		case TagPeerIPv4:
+			remote.IPv4 = v
+		case TagPeerIPv6:
+			remote.IPv6 = v
+		case TagPeerPort:
+			port, err := strconv.Atoi(v)
+			if err != nil {
+				zs.Tags[k] = v
+				continue
+			}
+			remote.Port = port
+		default:
+			zs.Tags[k] = v
+		}
+	}
+	if *remote != (Endpoint{}) {
+		zs.RemoteEndpoint = remote
+	}
+	// Zipkin treats any span with an "error" tag as failed, and shows
+	// its value as the error message.
+	if span.Error && zs.Tags["error"] == "" {
+		zs.Tags["error"] = "true"
+	}
+	if span.Indicator {
+		zs.Tags["indicator"] = "true"
+	}
+	return zs
+}
+
+// formatID formats a 64-bit ID as Zipkin's 16 lower-case hex
+// characters. IDs are unsigned in Zipkin, so negative IDs are
+// formatted as their two's complement.
+func formatID(id int64) string {
+	return fmt.Sprintf("%016x", uint64(id))
+}
+
+// send posts a batch of spans as gzipped JSON, retrying with
+// exponential backoff if the request fails with a network error or a
+// 5xx status.
+func (s *SpanSink) send(ctx context.Context, spans []*Span) error {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(spans); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	return sinks.Retry(ctx, zipkinRetries, zipkinBackoff, func() (bool, error) {
+		return s.post(ctx, body.Bytes())
+	})
+}
+
+// post makes a single request to Zipkin, and reports whether a failed
+// request may succeed if it is retried.
+func (s *SpanSink) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.addr, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("zipkin responded with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	return resp.StatusCode/100 == 5, err
+}
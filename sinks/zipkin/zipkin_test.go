@@ -0,0 +1,131 @@
+package zipkin
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/sinks"
+	"github.com/stripe/veneur/v14/ssf"
+	"github.com/stripe/veneur/v14/trace"
+)
+
+func testSpan(traceID, id, parentID int64) *ssf.SSFSpan {
+	start := time.Unix(1600000000, 0)
+	return &ssf.SSFSpan{
+		TraceId:        traceID,
+		Id:             id,
+		ParentId:       parentID,
+		Service:        "frontend",
+		Name:           "request",
+		StartTimestamp: start.UnixNano(),
+		EndTimestamp:   start.Add(1500 * time.Microsecond).UnixNano(),
+		Tags:           map[string]string{"foo": "bar"},
+	}
+}
+
+func TestSpanSinkFlush(t *testing.T) {
+	var batches [][]Span
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		gz, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		var spans []Span
+		require.NoError(t, json.NewDecoder(gz).Decode(&spans))
+		batches = append(batches, spans)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	sink, err := NewSpanSink(srv.URL, 2, 0, map[string]string{"env": "test", "foo": "common"}, srv.Client(), logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	root := testSpan(-2, 2, 0)
+	root.Indicator = true
+	root.Tags[TagSpanKind] = "server"
+	client := testSpan(-2, 3, 2)
+	client.Error = true
+	client.Tags[TagSpanKind] = "client"
+	client.Tags[TagPeerService] = "backend"
+	client.Tags[TagPeerIPv4] = "10.0.0.1"
+	client.Tags[TagPeerPort] = "8080"
+	instant := testSpan(-2, 4, 3)
+	instant.EndTimestamp = instant.StartTimestamp
+	for _, span := range []*ssf.SSFSpan{root, client, instant} {
+		require.NoError(t, sink.Ingest(span))
+	}
+	assert.Error(t, sink.Ingest(&ssf.SSFSpan{}), "invalid spans should be rejected")
+	sink.Flush()
+
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], 2)
+	spans := append(batches[0], batches[1]...)
+	sort.Slice(spans, func(i, j int) bool { return spans[i].ID < spans[j].ID })
+
+	assert.Equal(t, Span{
+		TraceID:       "fffffffffffffffe",
+		ID:            "0000000000000003",
+		ParentID:      "0000000000000002",
+		Name:          "request",
+		Kind:          "CLIENT",
+		Timestamp:     time.Unix(1600000000, 0).UnixNano() / 1e3,
+		Duration:      1500,
+		LocalEndpoint: &Endpoint{ServiceName: "frontend"},
+		RemoteEndpoint: &Endpoint{
+			ServiceName: "backend",
+			IPv4:        "10.0.0.1",
+			Port:        8080,
+		},
+		Tags: map[string]string{"env": "test", "foo": "bar", "error": "true"},
+	}, spans[1])
+	assert.Equal(t, int64(1), spans[2].Duration, "durations should be at least 1µs")
+	assert.Empty(t, spans[0].ParentID)
+	assert.Equal(t, "SERVER", spans[0].Kind)
+	assert.Nil(t, spans[0].RemoteEndpoint)
+	assert.Equal(t, map[string]string{"env": "test", "foo": "bar", "indicator": "true"}, spans[0].Tags)
+}
+
+func TestSpanSinkRetries(t *testing.T) {
+	defer func(backoff time.Duration) { zipkinBackoff = backoff }(zipkinBackoff)
+	zipkinBackoff = time.Millisecond
+
+	// server errors are retried, but client errors aren't
+	statuses := []int{http.StatusServiceUnavailable, http.StatusBadRequest, http.StatusAccepted}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statuses[requests])
+		requests++
+	}))
+	defer srv.Close()
+
+	ch := make(chan *ssf.SSFSpan, 1)
+	cl, err := trace.NewChannelClient(ch)
+	require.NoError(t, err)
+	defer cl.Close()
+	sink, err := NewSpanSink(srv.URL, 0, 0, nil, srv.Client(), logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(cl))
+
+	require.NoError(t, sink.Ingest(testSpan(1, 1, 0)))
+	sink.Flush()
+	assert.Equal(t, 2, requests)
+
+	found := false
+	for _, sample := range (<-ch).Metrics {
+		if sample.Name == sinks.MetricKeyTotalSpansDropped {
+			found = true
+			assert.Equal(t, float32(1), sample.Value)
+		}
+	}
+	assert.True(t, found, "should report the dropped spans")
+}
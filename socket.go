@@ -3,20 +3,45 @@
 package veneur
 
 import (
+	"errors"
 	"net"
 )
 
+var errReusePortUnsupported = errors.New("SO_REUSEPORT not supported on this platform")
+
 // NewSocket creates a socket which is intended for use by a single goroutine.
 func NewSocket(addr *net.UDPAddr, recvBuf int, reuseport bool) (net.PacketConn, error) {
+	sock, _, err := NewSizedSocket(addr, recvBuf, false, reuseport)
+	return sock, err
+}
+
+// ReusePortSupported reports whether the kernel honors SO_REUSEPORT,
+// which it never does on this platform.
+func ReusePortSupported() bool {
+	return false
+}
+
+// NewSizedSocket creates a socket like NewSocket does. On this
+// platform, the granted receive buffer size can't be read back, so it
+// always reports recvBuf, and autosizing is not supported.
+func NewSizedSocket(addr *net.UDPAddr, recvBuf int, autosize bool, reuseport bool) (net.PacketConn, int, error) {
 	if reuseport {
-		panic("SO_REUSEPORT not supported on this platform")
+		return nil, 0, errReusePortUnsupported
 	}
-	serverConn, err := net.ListenUDP("udp", addr)
+	serverConn, err := net.ListenUDP(listenNetwork("udp", addr.IP), addr)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if err := serverConn.SetReadBuffer(recvBuf); err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+	return serverConn, recvBuf, nil
+}
+
+// NewTCPListener creates a listening TCP socket on addr.
+func NewTCPListener(addr *net.TCPAddr, reuseport bool) (net.Listener, error) {
+	if reuseport {
+		return nil, errReusePortUnsupported
 	}
-	return serverConn, nil
+	return net.ListenTCP(listenNetwork("tcp", addr.IP), addr)
 }
@@ -1,23 +1,192 @@
 package veneur
 
 import (
+	"errors"
 	"net"
 	"os"
+	"sync"
 	"syscall"
 
 	"golang.org/x/sys/unix"
 )
 
+// maxAutosizeRcvbuf is the largest receive buffer that NewSizedSocket
+// will probe for when autosizing.
+const maxAutosizeRcvbuf = 256 * 1024 * 1024
+
 // see also https://github.com/jbenet/go-reuseport/blob/master/impl_unix.go#L279
 func NewSocket(addr *net.UDPAddr, recvBuf int, reuseport bool) (net.PacketConn, error) {
-	// default to AF_INET6 to be equivalent to net.ListenUDP()
+	sock, _, err := NewSizedSocket(addr, recvBuf, false, reuseport)
+	return sock, err
+}
+
+var (
+	reusePortOnce      sync.Once
+	reusePortSupported bool
+)
+
+// ReusePortSupported reports whether the kernel honors SO_REUSEPORT. Some
+// older kernels don't know the option, and some container runtimes accept
+// it but still refuse to bind a second socket to the same address, so
+// this probes by binding two UDP sockets with it to the same loopback
+// port. The result is cached after the first call.
+func ReusePortSupported() bool {
+	reusePortOnce.Do(func() {
+		err := probeReusePort()
+		if err != nil {
+			log.WithError(err).Debug("SO_REUSEPORT is not supported")
+		}
+		reusePortSupported = err == nil
+	})
+	return reusePortSupported
+}
+
+func probeReusePort() error {
+	loopback := net.IPv4(127, 0, 0, 1)
+	first, err := newBoundSocket(unix.SOCK_DGRAM, loopback, 0, true, nil)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(first)
+	sa, err := unix.Getsockname(first)
+	if err != nil {
+		return err
+	}
+	inet, ok := sa.(*unix.SockaddrInet4)
+	if !ok {
+		return errors.New("the probe socket isn't bound to an IPv4 address")
+	}
+	second, err := newBoundSocket(unix.SOCK_DGRAM, loopback, inet.Port, true, nil)
+	if err != nil {
+		return err
+	}
+	return unix.Close(second)
+}
+
+// NewSizedSocket creates a socket like NewSocket does, and
+// additionally returns the receive buffer size that the kernel
+// actually granted, which may be smaller than recvBuf if it exceeds
+// net.core.rmem_max. If autosize is set, it keeps doubling the
+// receive buffer past recvBuf for as long as the kernel honors the
+// increase, up to maxAutosizeRcvbuf.
+func NewSizedSocket(addr *net.UDPAddr, recvBuf int, autosize bool, reuseport bool) (net.PacketConn, int, error) {
+	var effective int
+	sockFD, err := newBoundSocket(unix.SOCK_DGRAM, addr.IP, addr.Port, reuseport, func(fd int) error {
+		var err error
+		effective, err = setRcvbuf(fd, recvBuf, autosize)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	osFD := os.NewFile(uintptr(sockFD), "veneursock")
+	// this will close the FD we passed to NewFile
+	defer osFD.Close()
+
+	// however, FilePacketConn duplicates the FD, so closing the File's FD does
+	// not affect this object's FD
+	ret, err := net.FilePacketConn(osFD)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ret, effective, nil
+}
+
+// setRcvbuf sets the receive buffer of fd to size, and returns the
+// size the kernel granted. If autosize is set and the kernel granted
+// the full size, it probes upwards as described in NewSizedSocket.
+func setRcvbuf(fd int, size int, autosize bool) (int, error) {
+	effective, err := trySetRcvbuf(fd, size)
+	if err != nil || !autosize || size <= 0 {
+		return effective, err
+	}
+	for effective >= size && size < maxAutosizeRcvbuf {
+		size *= 2
+		next, err := trySetRcvbuf(fd, size)
+		if err != nil {
+			return effective, err
+		}
+		if next <= effective {
+			// we've hit the ceiling; the kernel leaves the
+			// buffer at its maximum, so there's nothing to
+			// undo.
+			break
+		}
+		effective = next
+	}
+	return effective, nil
+}
+
+func trySetRcvbuf(fd int, size int) (int, error) {
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, size); err != nil {
+		return 0, err
+	}
+	actual, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF)
+	if err != nil {
+		return 0, err
+	}
+	// Linux doubles the requested size to leave room for its own
+	// bookkeeping, and reports the doubled value back; see socket(7).
+	return actual / 2, nil
+}
+
+// NewTCPListener creates a listening TCP socket on addr. If reuseport
+// is set, the socket is created with SO_REUSEPORT, so that multiple
+// listeners can be bound to the same address and the kernel
+// distributes incoming connections across them.
+func NewTCPListener(addr *net.TCPAddr, reuseport bool) (net.Listener, error) {
+	sockFD, err := newBoundSocket(unix.SOCK_STREAM, addr.IP, addr.Port, reuseport, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = unix.Listen(sockFD, unix.SOMAXCONN); err != nil {
+		unix.Close(sockFD)
+		return nil, err
+	}
+
+	osFD := os.NewFile(uintptr(sockFD), "veneursock")
+	// as with NewSocket, FileListener duplicates the FD:
+	defer osFD.Close()
+
+	return net.FileListener(osFD)
+}
+
+// newBoundSocket creates a socket of the given type, sets the
+// requested socket options and binds it to ip and port. If setopts is
+// non-nil, it is invoked before binding the socket. It returns the
+// raw file descriptor, which the caller is responsible for closing.
+//
+// Like net.ListenUDP, it binds an IPv4 ip with an IPv4 socket, and any
+// other ip with an IPv6 socket. A nil ip, which is what
+// protocol.ResolveAddr returns for the unspecified address of a udp or
+// tcp network, binds a dual-stack socket that accepts both IPv4 and IPv6
+// where the host supports IPv6, and an IPv4 one where it doesn't; the
+// IPv6 sockets for any other ip only accept IPv6, whatever the
+// net.ipv6.bindv6only sysctl says.
+func newBoundSocket(sockType int, ip net.IP, port int, reuseport bool, setopts func(fd int) error) (int, error) {
 	domain := unix.AF_INET6
-	if addr.IP.To4() != nil {
+	if ip.To4() != nil {
+		domain = unix.AF_INET
+	}
+	sockFD, err := unix.Socket(domain, sockType|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, 0)
+	if err == unix.EAFNOSUPPORT && ip == nil {
+		// IPv6 is disabled on this host
 		domain = unix.AF_INET
+		sockFD, err = unix.Socket(domain, sockType|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, 0)
 	}
-	sockFD, err := unix.Socket(domain, unix.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, 0)
 	if err != nil {
-		return nil, err
+		return -1, err
+	}
+	if domain == unix.AF_INET6 {
+		v6only := 1
+		if ip == nil {
+			v6only = 0
+		}
+		if err := unix.SetsockoptInt(sockFD, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, v6only); err != nil {
+			unix.Close(sockFD)
+			return -1, err
+		}
 	}
 
 	// unix.SO_REUSEPORT is not defined on linux 386/amd64, see
@@ -25,47 +194,47 @@ func NewSocket(addr *net.UDPAddr, recvBuf int, reuseport bool) (net.PacketConn,
 	if reuseport {
 		if err := unix.SetsockoptInt(sockFD, unix.SOL_SOCKET, 0xf, 1); err != nil {
 			unix.Close(sockFD)
-			return nil, err
+			return -1, err
 		}
 	}
-	if err = unix.SetsockoptInt(sockFD, unix.SOL_SOCKET, unix.SO_RCVBUF, recvBuf); err != nil {
-		unix.Close(sockFD)
-		return nil, err
+	if sockType == unix.SOCK_STREAM {
+		// net.ListenTCP sets SO_REUSEADDR, do the same here so
+		// restarts don't have to wait for TIME_WAIT sockets:
+		if err := unix.SetsockoptInt(sockFD, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+			unix.Close(sockFD)
+			return -1, err
+		}
+	}
+	if setopts != nil {
+		if err = setopts(sockFD); err != nil {
+			unix.Close(sockFD)
+			return -1, err
+		}
 	}
 
 	var sa unix.Sockaddr
 	if domain == unix.AF_INET {
 		sockaddr := &unix.SockaddrInet4{
-			Port: addr.Port,
+			Port: port,
 		}
-		if copied := copy(sockaddr.Addr[:], addr.IP.To4()); copied != net.IPv4len {
+		// ip will be nil for "bind all interfaces" without IPv6
+		if copied := copy(sockaddr.Addr[:], ip.To4()); !(copied == net.IPv4len || ip == nil) {
 			panic("did not copy enough bytes of ip address")
 		}
 		sa = sockaddr
 	} else {
 		sockaddr := &unix.SockaddrInet6{
-			Port: addr.Port,
+			Port: port,
 		}
-		// addr.IP will be length 0 for "bind all interfaces"
-		if copied := copy(sockaddr.Addr[:], addr.IP.To16()); !(copied == net.IPv6len || copied == 0) {
+		// ip will be length 0 for "bind all interfaces"
+		if copied := copy(sockaddr.Addr[:], ip.To16()); !(copied == net.IPv6len || copied == 0) {
 			panic("did not copy enough bytes of ip address")
 		}
 		sa = sockaddr
 	}
 	if err = unix.Bind(sockFD, sa); err != nil {
 		unix.Close(sockFD)
-		return nil, err
-	}
-
-	osFD := os.NewFile(uintptr(sockFD), "veneursock")
-	// this will close the FD we passed to NewFile
-	defer osFD.Close()
-
-	// however, FilePacketConn duplicates the FD, so closing the File's FD does
-	// not affect this object's FD
-	ret, err := net.FilePacketConn(osFD)
-	if err != nil {
-		return nil, err
+		return -1, err
 	}
-	return ret, nil
+	return sockFD, nil
 }
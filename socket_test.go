@@ -3,6 +3,7 @@ package veneur
 import (
 	"fmt"
 	"net"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -66,3 +67,35 @@ func TestSocket(t *testing.T) {
 		t.Run(test.name, writeReadUDP(test.addr, test.sendAddr))
 	}
 }
+
+func TestNewSizedSocket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reading back the receive buffer size is only supported on linux")
+	}
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	sock, rcvbuf, err := NewSizedSocket(addr, 16*1024, false, false)
+	require.NoError(t, err)
+	sock.Close()
+	assert.Equal(t, 16*1024, rcvbuf, "small buffers should be granted as requested")
+
+	sock, rcvbuf, err = NewSizedSocket(addr, 1024*1024*1024, false, false)
+	require.NoError(t, err)
+	sock.Close()
+	assert.True(t, rcvbuf < 1024*1024*1024, "the kernel should clamp a 1GiB buffer, got %d", rcvbuf)
+	clamped := rcvbuf
+
+	sock, rcvbuf, err = NewSizedSocket(addr, 16*1024, true, false)
+	require.NoError(t, err)
+	sock.Close()
+	assert.Equal(t, clamped, rcvbuf, "autosizing should probe up to the kernel's limit")
+}
+
+func TestReusePortSupported(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		assert.False(t, ReusePortSupported())
+		return
+	}
+	assert.True(t, ReusePortSupported(), "SO_REUSEPORT should be supported on linux")
+}
@@ -0,0 +1,157 @@
+package veneur
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errSoftnetStatUnsupported is returned by SoftnetStat on platforms
+// that don't expose /proc/net/softnet_stat.
+var errSoftnetStatUnsupported = errors.New("softnet_stat is not supported on this platform")
+
+// SoftnetStats holds the per-CPU packet processing counters that the
+// kernel reports in /proc/net/softnet_stat.
+type SoftnetStats struct {
+	// Processor is the index of the CPU the counters belong to.
+	Processor uint32
+	// Processed is the number of packets processed by the CPU.
+	Processed uint32
+	// Dropped is the number of packets dropped because the CPU's
+	// input queue was full.
+	Dropped uint32
+	// TimeSqueeze is the number of times the CPU ran out of budget
+	// or time while there was still work to do.
+	TimeSqueeze uint32
+	// FlowLimitCount is the number of times the flow limit was hit.
+	FlowLimitCount uint32
+}
+
+// SoftnetDeltas turns the counters that SoftnetStat reads, which count
+// from boot, into the increase of each since the last reading.
+type SoftnetDeltas struct {
+	previous map[uint32]SoftnetStats
+}
+
+// Update records stats as the latest reading, and returns how much the
+// Dropped, TimeSqueeze and FlowLimitCount counters of each CPU increased
+// since the previous one. CPUs that weren't in the previous reading,
+// or whose counters went down (e.g. because they were reset), are left
+// out until the next reading.
+func (d *SoftnetDeltas) Update(stats []SoftnetStats) []SoftnetStats {
+	var deltas []SoftnetStats
+	current := make(map[uint32]SoftnetStats, len(stats))
+	for _, stat := range stats {
+		current[stat.Processor] = stat
+		prev, ok := d.previous[stat.Processor]
+		if !ok || stat.Dropped < prev.Dropped || stat.TimeSqueeze < prev.TimeSqueeze ||
+			stat.FlowLimitCount < prev.FlowLimitCount {
+			continue
+		}
+		deltas = append(deltas, SoftnetStats{
+			Processor:      stat.Processor,
+			Dropped:        stat.Dropped - prev.Dropped,
+			TimeSqueeze:    stat.TimeSqueeze - prev.TimeSqueeze,
+			FlowLimitCount: stat.FlowLimitCount - prev.FlowLimitCount,
+		})
+	}
+	d.previous = current
+	return deltas
+}
+
+// parseSoftnetStat parses the contents of /proc/net/softnet_stat. Each
+// line holds the counters for a single CPU as hexadecimal numbers;
+// newer kernels report the CPU index in the 13th column, on older ones
+// the line number is the CPU index.
+func parseSoftnetStat(r io.Reader) ([]SoftnetStats, error) {
+	var stats []SoftnetStats
+	scanner := bufio.NewScanner(r)
+	for line := 0; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 11 {
+			return nil, fmt.Errorf("softnet_stat line %d has %d columns, expected at least 11", line, len(fields))
+		}
+		values := make([]uint32, len(fields))
+		for i, field := range fields {
+			v, err := strconv.ParseUint(field, 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("softnet_stat line %d: %v", line, err)
+			}
+			values[i] = uint32(v)
+		}
+		stat := SoftnetStats{
+			Processor:      uint32(line),
+			Processed:      values[0],
+			Dropped:        values[1],
+			TimeSqueeze:    values[2],
+			FlowLimitCount: values[10],
+		}
+		if len(values) >= 13 {
+			stat.Processor = values[12]
+		}
+		stats = append(stats, stat)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// SoftnetStatCollector reports the kernel's per-CPU softnet counters
+// once every interval, until the server shuts down: the number of
+// packets processed as a gauge, and the increase of the others since the
+// last report as counters. If softnet stats aren't enabled, or softnet_stat isn't
+// available on this platform, it returns immediately.
+//
+// It is ideally run from a goroutine.
+func (s *Server) SoftnetStatCollector() {
+	defer func() {
+		ConsumePanic(s.TraceClient, s.Hostname, recover())
+	}()
+
+	if !s.enableSoftnetStats {
+		return
+	}
+	stats, err := SoftnetStat()
+	if err != nil {
+		if err != errSoftnetStatUnsupported {
+			log.WithError(err).Warn("Could not read softnet_stat, not reporting softnet metrics")
+		}
+		return
+	}
+	deltas := &SoftnetDeltas{}
+	deltas.Update(stats)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+			stats, err := SoftnetStat()
+			if err != nil {
+				log.WithError(err).Debug("Could not read softnet_stat")
+				continue
+			}
+			s.reportSoftnetStats(stats, deltas.Update(stats))
+		}
+	}
+}
+
+func (s *Server) reportSoftnetStats(stats []SoftnetStats, deltas []SoftnetStats) {
+	for _, stat := range stats {
+		tags := []string{fmt.Sprintf("processor:%d", stat.Processor)}
+		s.Statsd.Gauge("softnet.processed", float64(stat.Processed), tags, 1.0)
+	}
+	for _, delta := range deltas {
+		tags := []string{fmt.Sprintf("processor:%d", delta.Processor)}
+		s.Statsd.Count("softnet.dropped_total", int64(delta.Dropped), tags, 1.0)
+		s.Statsd.Count("softnet.time_squeeze_total", int64(delta.TimeSqueeze), tags, 1.0)
+		s.Statsd.Count("softnet.flow_limit_count_total", int64(delta.FlowLimitCount), tags, 1.0)
+	}
+}
@@ -0,0 +1,16 @@
+package veneur
+
+import (
+	"os"
+)
+
+// SoftnetStat reads the per-CPU packet processing counters from
+// /proc/net/softnet_stat.
+func SoftnetStat() ([]SoftnetStats, error) {
+	f, err := os.Open("/proc/net/softnet_stat")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseSoftnetStat(f)
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package veneur
+
+// SoftnetStat is not supported on this platform, and always returns
+// an error.
+func SoftnetStat() ([]SoftnetStats, error) {
+	return nil, errSoftnetStatUnsupported
+}
@@ -0,0 +1,69 @@
+package veneur
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSoftnetStat(t *testing.T) {
+	t.Run("with processor index", func(t *testing.T) {
+		input := "" +
+			"00007ef7 00000001 00000002 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000003 00000000 00000000\n" +
+			"0000001a 00000000 00000010 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000003\n"
+		stats, err := parseSoftnetStat(strings.NewReader(input))
+		require.NoError(t, err)
+		assert.Equal(t, []SoftnetStats{
+			{Processor: 0, Processed: 0x7ef7, Dropped: 1, TimeSqueeze: 2, FlowLimitCount: 3},
+			{Processor: 3, Processed: 0x1a, TimeSqueeze: 0x10},
+		}, stats)
+	})
+
+	t.Run("older kernels", func(t *testing.T) {
+		input := "" +
+			"00000010 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000\n" +
+			"00000020 00000004 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000001\n"
+		stats, err := parseSoftnetStat(strings.NewReader(input))
+		require.NoError(t, err)
+		assert.Equal(t, []SoftnetStats{
+			{Processor: 0, Processed: 0x10},
+			{Processor: 1, Processed: 0x20, Dropped: 4, FlowLimitCount: 1},
+		}, stats)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		_, err := parseSoftnetStat(strings.NewReader("00000010 00000000\n"))
+		assert.Error(t, err)
+		_, err = parseSoftnetStat(strings.NewReader("0000001g 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestSoftnetDeltas(t *testing.T) {
+	d := &SoftnetDeltas{}
+	assert.Empty(t, d.Update([]SoftnetStats{
+		{Processor: 0, Processed: 100, Dropped: 1, TimeSqueeze: 2, FlowLimitCount: 3},
+	}), "there's nothing to compare the first reading with")
+
+	assert.Equal(t, []SoftnetStats{
+		{Processor: 0, Dropped: 4, TimeSqueeze: 0, FlowLimitCount: 1},
+	}, d.Update([]SoftnetStats{
+		{Processor: 0, Processed: 200, Dropped: 5, TimeSqueeze: 2, FlowLimitCount: 4},
+		{Processor: 1, Processed: 10, Dropped: 1},
+	}), "a new processor should be skipped")
+
+	assert.Equal(t, []SoftnetStats{
+		{Processor: 1, Dropped: 2},
+	}, d.Update([]SoftnetStats{
+		{Processor: 0, Processed: 1, Dropped: 0, TimeSqueeze: 2, FlowLimitCount: 4},
+		{Processor: 1, Processed: 20, Dropped: 3},
+	}), "a processor whose counters were reset should be skipped")
+
+	assert.Equal(t, []SoftnetStats{
+		{Processor: 0, Dropped: 1},
+	}, d.Update([]SoftnetStats{
+		{Processor: 0, Processed: 2, Dropped: 1, TimeSqueeze: 2, FlowLimitCount: 4},
+	}))
+}
@@ -0,0 +1,113 @@
+package veneur
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stripe/veneur/v14/ssf"
+)
+
+// The reasons that SSF spans are rejected for, in the order they're
+// checked in.
+const (
+	spanMissingTraceID    = "missing_trace_id"
+	spanMissingID         = "missing_id"
+	spanMissingName       = "missing_name"
+	spanMissingTimestamps = "missing_timestamps"
+	spanBadTiming         = "bad_timing"
+)
+
+var spanRejectReasons = [...]string{
+	spanMissingTraceID,
+	spanMissingID,
+	spanMissingName,
+	spanMissingTimestamps,
+	spanBadTiming,
+}
+
+// spanValidator rejects the SSF spans that break the invariants of a
+// span, and counts them by reason, before they're sampled or handed to
+// the span sinks.
+type spanValidator struct {
+	// rejectLog logs some of the rejected spans; nil if none are logged.
+	rejectLog *rejectLogger
+
+	// the spans rejected for each of spanRejectReasons since the last
+	// report; accessed atomically.
+	rejected [len(spanRejectReasons)]int64
+}
+
+// invalidSpanReason returns the index in spanRejectReasons of the first
+// invariant that span breaks, or -1 if it's a valid span. SSF packets
+// that don't have a trace or span ID at all aren't spans, but carriers
+// of SSF samples, and are valid too.
+func invalidSpanReason(span *ssf.SSFSpan) int {
+	if span.TraceId != 0 && span.Id != 0 && span.Name != "" &&
+		span.StartTimestamp != 0 && span.EndTimestamp >= span.StartTimestamp {
+		return -1
+	}
+	if span.TraceId == 0 && span.Id == 0 {
+		return -1
+	}
+	switch {
+	case span.TraceId == 0:
+		return 0
+	case span.Id == 0:
+		return 1
+	case span.Name == "":
+		return 2
+	case span.StartTimestamp == 0 || span.EndTimestamp == 0:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// validateSpan returns the span that veneur should process in place of
+// span: span itself if it's valid, and otherwise nil, or a span that
+// only carries span's SSF samples, so that their metrics are still
+// extracted.
+func (s *Server) validateSpan(span *ssf.SSFSpan) *ssf.SSFSpan {
+	if s.spanValidator == nil {
+		return span
+	}
+	reason := invalidSpanReason(span)
+	if reason < 0 {
+		return span
+	}
+	v := s.spanValidator
+	atomic.AddInt64(&v.rejected[reason], 1)
+	if v.rejectLog != nil && v.rejectLog.allow(time.Now(), "Suppressed the logs of invalid SSF spans; see veneur.ssf.spans.rejected_total for how many there were") {
+		log.WithFields(logrus.Fields{
+			"reason":          spanRejectReasons[reason],
+			"service":         span.Service,
+			"name":            span.Name,
+			"trace_id":        span.TraceId,
+			"id":              span.Id,
+			"start_timestamp": span.StartTimestamp,
+			"end_timestamp":   span.EndTimestamp,
+		}).Warn("Rejected invalid SSF span")
+	}
+	if len(span.Metrics) == 0 {
+		return nil
+	}
+	return &ssf.SSFSpan{Metrics: span.Metrics}
+}
+
+// reportSpanValidation emits the number of spans rejected for each
+// reason since the last report, and resets the counts.
+func (s *Server) reportSpanValidation() {
+	v := s.spanValidator
+	if v == nil {
+		return
+	}
+	for i, reason := range spanRejectReasons {
+		rejected := atomic.SwapInt64(&v.rejected[i], 0)
+		if rejected == 0 {
+			continue
+		}
+		s.Statsd.Count("ssf.spans.rejected_total", rejected, []string{"reason:" + reason}, 1.0)
+	}
+}
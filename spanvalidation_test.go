@@ -0,0 +1,60 @@
+package veneur
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/v14/ssf"
+)
+
+func TestInvalidSpanReason(t *testing.T) {
+	valid := func() *ssf.SSFSpan {
+		return &ssf.SSFSpan{Id: 2, TraceId: 1, Name: "op", StartTimestamp: 10, EndTimestamp: 20}
+	}
+	assert.Equal(t, -1, invalidSpanReason(valid()))
+	assert.Equal(t, -1, invalidSpanReason(&ssf.SSFSpan{Metrics: []*ssf.SSFSample{ssf.Count("a.b.c", 1, nil)}}),
+		"carriers of SSF samples aren't spans")
+
+	tests := map[string]func(*ssf.SSFSpan){
+		spanMissingTraceID:    func(span *ssf.SSFSpan) { span.TraceId = 0 },
+		spanMissingID:         func(span *ssf.SSFSpan) { span.Id = 0 },
+		spanMissingName:       func(span *ssf.SSFSpan) { span.Name = "" },
+		spanMissingTimestamps: func(span *ssf.SSFSpan) { span.EndTimestamp = 0 },
+		spanBadTiming:         func(span *ssf.SSFSpan) { span.EndTimestamp = 5 },
+	}
+	for reason, breakSpan := range tests {
+		span := valid()
+		breakSpan(span)
+		got := invalidSpanReason(span)
+		if assert.True(t, got >= 0, reason) {
+			assert.Equal(t, reason, spanRejectReasons[got])
+		}
+	}
+}
+
+func TestValidateSpan(t *testing.T) {
+	s := &Server{spanValidator: &spanValidator{rejectLog: newRejectLogger(1)}}
+	span := &ssf.SSFSpan{Id: 1, TraceId: 1, Name: "op", StartTimestamp: 20, EndTimestamp: 10}
+	assert.Nil(t, s.validateSpan(span))
+
+	span.Metrics = []*ssf.SSFSample{ssf.Count("a.b.c", 1, nil)}
+	carrier := s.validateSpan(span)
+	require.NotNil(t, carrier)
+	assert.Equal(t, span.Metrics, carrier.Metrics, "the samples of a rejected span should be kept")
+	assert.Zero(t, carrier.TraceId, "the rejected span should not be passed on to trace sinks")
+	assert.Equal(t, int64(2), atomic.LoadInt64(&s.spanValidator.rejected[4]))
+
+	s.spanValidator = nil
+	assert.Equal(t, span, s.validateSpan(span))
+}
+
+func TestSsfInvalidSpanLogsPerSecondInvalid(t *testing.T) {
+	config := localConfig()
+	config.SsfValidateSpans = true
+	config.SsfInvalidSpanLogsPerSecond = -1
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+}
@@ -59,23 +59,122 @@ func (m *Empty) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_Empty proto.InternalMessageInfo
 
+// SSFSpanBatch is a batch of spans sent over the SendSpans stream.
+type SSFSpanBatch struct {
+	Spans []*SSFSpan `protobuf:"bytes,1,rep,name=spans,proto3" json:"spans,omitempty"`
+}
+
+func (m *SSFSpanBatch) Reset()         { *m = SSFSpanBatch{} }
+func (m *SSFSpanBatch) String() string { return proto.CompactTextString(m) }
+func (*SSFSpanBatch) ProtoMessage()    {}
+func (*SSFSpanBatch) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4a81259b8daae8b4, []int{1}
+}
+func (m *SSFSpanBatch) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SSFSpanBatch) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SSFSpanBatch.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *SSFSpanBatch) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SSFSpanBatch.Merge(m, src)
+}
+func (m *SSFSpanBatch) XXX_Size() int {
+	return m.Size()
+}
+func (m *SSFSpanBatch) XXX_DiscardUnknown() {
+	xxx_messageInfo_SSFSpanBatch.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SSFSpanBatch proto.InternalMessageInfo
+
+func (m *SSFSpanBatch) GetSpans() []*SSFSpan {
+	if m != nil {
+		return m.Spans
+	}
+	return nil
+}
+
+// SSFSpanBatchAck acknowledges an SSFSpanBatch, with the number of spans
+// in it that veneur accepted.
+type SSFSpanBatchAck struct {
+	Accepted uint32 `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+}
+
+func (m *SSFSpanBatchAck) Reset()         { *m = SSFSpanBatchAck{} }
+func (m *SSFSpanBatchAck) String() string { return proto.CompactTextString(m) }
+func (*SSFSpanBatchAck) ProtoMessage()    {}
+func (*SSFSpanBatchAck) Descriptor() ([]byte, []int) {
+	return fileDescriptor_4a81259b8daae8b4, []int{2}
+}
+func (m *SSFSpanBatchAck) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *SSFSpanBatchAck) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_SSFSpanBatchAck.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalTo(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *SSFSpanBatchAck) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SSFSpanBatchAck.Merge(m, src)
+}
+func (m *SSFSpanBatchAck) XXX_Size() int {
+	return m.Size()
+}
+func (m *SSFSpanBatchAck) XXX_DiscardUnknown() {
+	xxx_messageInfo_SSFSpanBatchAck.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SSFSpanBatchAck proto.InternalMessageInfo
+
+func (m *SSFSpanBatchAck) GetAccepted() uint32 {
+	if m != nil {
+		return m.Accepted
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Empty)(nil), "ssf.Empty")
+	proto.RegisterType((*SSFSpanBatch)(nil), "ssf.SSFSpanBatch")
+	proto.RegisterType((*SSFSpanBatchAck)(nil), "ssf.SSFSpanBatchAck")
 }
 
 func init() { proto.RegisterFile("ssf/grpc.proto", fileDescriptor_4a81259b8daae8b4) }
 
 var fileDescriptor_4a81259b8daae8b4 = []byte{
-	// 139 bytes of a gzipped FileDescriptorProto
+	// 234 bytes of a gzipped FileDescriptorProto
 	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x2b, 0x2e, 0x4e, 0xd3,
 	0x4f, 0x2f, 0x2a, 0x48, 0xd6, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x2e, 0x2e, 0x4e, 0x93,
 	0x12, 0x00, 0x09, 0x16, 0x27, 0xe6, 0x16, 0xe4, 0xa4, 0x42, 0x84, 0x95, 0xd8, 0xb9, 0x58, 0x5d,
-	0x73, 0x0b, 0x4a, 0x2a, 0x8d, 0xf4, 0xb9, 0xd8, 0x83, 0x83, 0xdd, 0xdc, 0x83, 0x02, 0x9c, 0x85,
-	0x54, 0xb8, 0x38, 0x82, 0x53, 0xf3, 0x52, 0x82, 0x0b, 0x12, 0xf3, 0x84, 0x78, 0xf4, 0x8a, 0x8b,
-	0xd3, 0xf4, 0x82, 0x83, 0xdd, 0x40, 0x3c, 0x29, 0x2e, 0x30, 0x0f, 0xac, 0xc1, 0x49, 0xe2, 0xc4,
-	0x23, 0x39, 0xc6, 0x0b, 0x8f, 0xe4, 0x18, 0x1f, 0x3c, 0x92, 0x63, 0x9c, 0xf0, 0x58, 0x8e, 0xe1,
-	0xc2, 0x63, 0x39, 0x86, 0x1b, 0x8f, 0xe5, 0x18, 0x92, 0xd8, 0xc0, 0x46, 0x1b, 0x03, 0x02, 0x00,
-	0x00, 0xff, 0xff, 0xe5, 0x1b, 0x3e, 0x34, 0x83, 0x00, 0x00, 0x00,
+	0x73, 0x0b, 0x4a, 0x2a, 0x95, 0x8c, 0xb8, 0x78, 0x82, 0x83, 0xdd, 0x82, 0x0b, 0x12, 0xf3, 0x9c,
+	0x12, 0x4b, 0x92, 0x33, 0x84, 0x94, 0xb8, 0x58, 0x8b, 0x0b, 0x12, 0xf3, 0x8a, 0x25, 0x18, 0x15,
+	0x98, 0x35, 0xb8, 0x8d, 0x78, 0xf4, 0x8a, 0x8b, 0xd3, 0xf4, 0xa0, 0x2a, 0x82, 0x20, 0x52, 0x4a,
+	0xba, 0x5c, 0xfc, 0xc8, 0x7a, 0x1c, 0x93, 0xb3, 0x85, 0xa4, 0xb8, 0x38, 0x12, 0x93, 0x93, 0x53,
+	0x0b, 0x4a, 0x52, 0x53, 0x24, 0x18, 0x15, 0x18, 0x35, 0x78, 0x83, 0xe0, 0x7c, 0x23, 0x7d, 0x2e,
+	0xf6, 0xe0, 0x60, 0x37, 0xf7, 0xa0, 0x00, 0x67, 0x21, 0x15, 0x2e, 0x8e, 0xe0, 0xd4, 0xbc, 0x14,
+	0x90, 0x56, 0x21, 0x14, 0xa3, 0xa5, 0xb8, 0xc0, 0x3c, 0xb0, 0x9b, 0x8c, 0x5c, 0xb9, 0x38, 0x41,
+	0xc2, 0x25, 0x45, 0xa9, 0x89, 0xb9, 0x42, 0x16, 0x5c, 0x9c, 0x30, 0x2d, 0xc5, 0x42, 0x82, 0xc8,
+	0x7a, 0xc0, 0x96, 0x4b, 0x89, 0x60, 0x08, 0x39, 0x26, 0x67, 0x6b, 0x30, 0x1a, 0x30, 0x3a, 0x49,
+	0x9c, 0x78, 0x24, 0xc7, 0x78, 0xe1, 0x91, 0x1c, 0xe3, 0x83, 0x47, 0x72, 0x8c, 0x13, 0x1e, 0xcb,
+	0x31, 0x5c, 0x78, 0x2c, 0xc7, 0x70, 0xe3, 0xb1, 0x1c, 0x43, 0x12, 0x1b, 0x38, 0x10, 0x8c, 0x01,
+	0x03, 0x00, 0xe7, 0x3f, 0x49, 0xe6, 0x2d, 0x01, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -150,6 +249,102 @@ var _SSFGRPC_serviceDesc = grpc.ServiceDesc{
 	Metadata: "ssf/grpc.proto",
 }
 
+// SSFStreamClient is the client API for SSFStream service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type SSFStreamClient interface {
+	SendSpans(ctx context.Context, opts ...grpc.CallOption) (SSFStream_SendSpansClient, error)
+}
+
+type sSFStreamClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewSSFStreamClient(cc *grpc.ClientConn) SSFStreamClient {
+	return &sSFStreamClient{cc}
+}
+
+func (c *sSFStreamClient) SendSpans(ctx context.Context, opts ...grpc.CallOption) (SSFStream_SendSpansClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_SSFStream_serviceDesc.Streams[0], "/ssf.SSFStream/SendSpans", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &sSFStreamSendSpansClient{stream}
+	return x, nil
+}
+
+type SSFStream_SendSpansClient interface {
+	Send(*SSFSpanBatch) error
+	Recv() (*SSFSpanBatchAck, error)
+	grpc.ClientStream
+}
+
+type sSFStreamSendSpansClient struct {
+	grpc.ClientStream
+}
+
+func (x *sSFStreamSendSpansClient) Send(m *SSFSpanBatch) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *sSFStreamSendSpansClient) Recv() (*SSFSpanBatchAck, error) {
+	m := new(SSFSpanBatchAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// SSFStreamServer is the server API for SSFStream service.
+type SSFStreamServer interface {
+	SendSpans(SSFStream_SendSpansServer) error
+}
+
+func RegisterSSFStreamServer(s *grpc.Server, srv SSFStreamServer) {
+	s.RegisterService(&_SSFStream_serviceDesc, srv)
+}
+
+func _SSFStream_SendSpans_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SSFStreamServer).SendSpans(&sSFStreamSendSpansServer{stream})
+}
+
+type SSFStream_SendSpansServer interface {
+	Send(*SSFSpanBatchAck) error
+	Recv() (*SSFSpanBatch, error)
+	grpc.ServerStream
+}
+
+type sSFStreamSendSpansServer struct {
+	grpc.ServerStream
+}
+
+func (x *sSFStreamSendSpansServer) Send(m *SSFSpanBatchAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *sSFStreamSendSpansServer) Recv() (*SSFSpanBatch, error) {
+	m := new(SSFSpanBatch)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _SSFStream_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "ssf.SSFStream",
+	HandlerType: (*SSFStreamServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SendSpans",
+			Handler:       _SSFStream_SendSpans_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "ssf/grpc.proto",
+}
+
 func (m *Empty) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
@@ -168,6 +363,59 @@ func (m *Empty) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
+func (m *SSFSpanBatch) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SSFSpanBatch) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if len(m.Spans) > 0 {
+		for _, msg := range m.Spans {
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintGrpc(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return i, nil
+}
+
+func (m *SSFSpanBatchAck) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SSFSpanBatchAck) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.Accepted != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintGrpc(dAtA, i, uint64(m.Accepted))
+	}
+	return i, nil
+}
+
 func encodeVarintGrpc(dAtA []byte, offset int, v uint64) int {
 	for v >= 1<<7 {
 		dAtA[offset] = uint8(v&0x7f | 0x80)
@@ -186,6 +434,33 @@ func (m *Empty) Size() (n int) {
 	return n
 }
 
+func (m *SSFSpanBatch) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Spans) > 0 {
+		for _, e := range m.Spans {
+			l = e.Size()
+			n += 1 + l + sovGrpc(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *SSFSpanBatchAck) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Accepted != 0 {
+		n += 1 + sovGrpc(uint64(m.Accepted))
+	}
+	return n
+}
+
 func sovGrpc(x uint64) (n int) {
 	for {
 		n++
@@ -252,6 +527,165 @@ func (m *Empty) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *SSFSpanBatch) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowGrpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SSFSpanBatch: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SSFSpanBatch: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Spans", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowGrpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthGrpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthGrpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Spans = append(m.Spans, &SSFSpan{})
+			if err := m.Spans[len(m.Spans)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipGrpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthGrpc
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthGrpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *SSFSpanBatchAck) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowGrpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SSFSpanBatchAck: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SSFSpanBatchAck: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Accepted", wireType)
+			}
+			m.Accepted = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowGrpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Accepted |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipGrpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthGrpc
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthGrpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipGrpc(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -0,0 +1,32 @@
+package ssf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSFSpanBatchMarshal(t *testing.T) {
+	batch := &SSFSpanBatch{Spans: []*SSFSpan{
+		{Id: 1, TraceId: 1, Service: "a", Tags: map[string]string{"foo": "bar"}},
+		{Id: 2, TraceId: 1, Service: "a", Metrics: []*SSFSample{Count("a.b.c", 1, nil)}},
+	}}
+	data, err := batch.Marshal()
+	require.NoError(t, err)
+
+	// unknown fields are skipped:
+	data = append(data, 2<<3|0, 0x2a, 3<<3|2, 1, 'x')
+	decoded := &SSFSpanBatch{}
+	require.NoError(t, decoded.Unmarshal(data))
+	assert.Equal(t, batch, decoded)
+
+	assert.Error(t, decoded.Unmarshal(data[:len(data)-1]), "truncated batches should fail to decode")
+
+	ack := &SSFSpanBatchAck{Accepted: 300}
+	data, err = ack.Marshal()
+	require.NoError(t, err)
+	decodedAck := &SSFSpanBatchAck{}
+	require.NoError(t, decodedAck.Unmarshal(data))
+	assert.Equal(t, ack, decodedAck)
+}
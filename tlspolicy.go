@@ -0,0 +1,63 @@
+package veneur
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// defaultCipherSuites are the TLS 1.2 cipher suites that the listeners
+// accept unless tls_cipher_suites is set: the ones with forward secrecy
+// and authenticated encryption.
+var defaultCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// parseTLSMinVersion returns the TLS version that tls_min_version names,
+// which is 1.2 if it's empty. Versions older than 1.2 are rejected.
+func parseTLSMinVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	case "1.0", "1.1":
+		return 0, fmt.Errorf("tls_min_version: TLS %s is too old; it must be at least 1.2", version)
+	default:
+		return 0, fmt.Errorf("tls_min_version: %q must be \"1.2\" or \"1.3\"", version)
+	}
+}
+
+// parseCipherSuites returns the IDs of the cipher suites that
+// tls_cipher_suites names, or defaultCipherSuites if it's empty. Unknown
+// suites, and those that crypto/tls considers insecure, are rejected.
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return defaultCipherSuites, nil
+	}
+	secure := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	insecure := map[string]bool{}
+	for _, suite := range tls.InsecureCipherSuites() {
+		insecure[suite.Name] = true
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for i, name := range names {
+		id, ok := secure[name]
+		switch {
+		case insecure[name]:
+			return nil, fmt.Errorf("tls_cipher_suites[%d]: %s is insecure", i, name)
+		case !ok:
+			return nil, fmt.Errorf("tls_cipher_suites[%d]: unknown cipher suite %q", i, name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
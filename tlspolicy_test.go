@@ -0,0 +1,49 @@
+package veneur
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTLSMinVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected uint16
+		valid    bool
+	}{
+		{"", tls.VersionTLS12, true},
+		{"1.2", tls.VersionTLS12, true},
+		{"1.3", tls.VersionTLS13, true},
+		{"1.1", 0, false},
+		{"1.0", 0, false},
+		{"TLS1.2", 0, false},
+	}
+	for _, test := range tests {
+		version, err := parseTLSMinVersion(test.version)
+		if !test.valid {
+			assert.Error(t, err, "version %q", test.version)
+			continue
+		}
+		require.NoError(t, err, "version %q", test.version)
+		assert.Equal(t, test.expected, version, "version %q", test.version)
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	suites, err := parseCipherSuites(nil)
+	require.NoError(t, err)
+	assert.Equal(t, defaultCipherSuites, suites)
+
+	suites, err = parseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, suites)
+
+	_, err = parseCipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"})
+	assert.EqualError(t, err, "tls_cipher_suites[1]: TLS_RSA_WITH_RC4_128_SHA is insecure")
+
+	_, err = parseCipherSuites([]string{"TLS_NOPE"})
+	assert.Error(t, err)
+}
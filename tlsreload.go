@@ -0,0 +1,116 @@
+package veneur
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultTLSReloadInterval is how often the TLS certificate files are
+// checked for changes unless tls_reload_interval is set.
+const defaultTLSReloadInterval = time.Minute
+
+// certReloader serves the TLS certificate and key in a pair of files, and
+// loads them again when either file changes, so that a rotated
+// certificate is used without restarting. Connections that are already
+// established keep the certificate they were handshaked with.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mtx         sync.RWMutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+// newCertReloader loads the certificate and key in the files.
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// getCertificate returns the certificate that was loaded last, for
+// tls.Config's GetCertificate.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return r.cert, nil
+}
+
+// reload loads the certificate and key again if either file changed since
+// they were last loaded, and returns whether it did. If they can't be
+// loaded, the previous certificate stays in use.
+func (r *certReloader) reload() (bool, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	r.mtx.RLock()
+	unchanged := r.cert != nil && certInfo.ModTime().Equal(r.certModTime) && keyInfo.ModTime().Equal(r.keyModTime)
+	r.mtx.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	// parse the leaf once here, rather than on each handshake, and for
+	// the logs of its expiry
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return false, err
+	}
+
+	r.mtx.Lock()
+	r.cert = &cert
+	r.certModTime = certInfo.ModTime()
+	r.keyModTime = keyInfo.ModTime()
+	r.mtx.Unlock()
+	return true, nil
+}
+
+// watch checks the files for changes every interval until done is closed,
+// and logs each certificate it loads.
+func (r *certReloader) watch(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			reloaded, err := r.reload()
+			if err != nil {
+				log.WithError(err).WithFields(logrus.Fields{
+					"certificate_file": r.certFile,
+					"key_file":         r.keyFile,
+				}).Error("Could not reload the TLS certificate; still using the previous one")
+				continue
+			}
+			if reloaded {
+				r.mtx.RLock()
+				leaf := r.cert.Leaf
+				r.mtx.RUnlock()
+				log.WithFields(logrus.Fields{
+					"certificate_file": r.certFile,
+					"subject":          leaf.Subject.String(),
+					"not_after":        leaf.NotAfter,
+				}).Info("Reloaded the TLS certificate")
+			}
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package veneur
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes a new self-signed certificate for the common name and
+// its key to the files, and sets their modification time to modTime.
+func writeCert(t *testing.T, certFile, keyFile, cn string, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func TestCertReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "veneur-tls")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	start := time.Now().Add(-time.Minute)
+	writeCert(t, certFile, keyFile, "first", start)
+	r, err := newCertReloader(certFile, keyFile)
+	require.NoError(t, err)
+	cn := func() string {
+		cert, err := r.getCertificate(nil)
+		require.NoError(t, err)
+		return cert.Leaf.Subject.CommonName
+	}
+	assert.Equal(t, "first", cn())
+
+	reloaded, err := r.reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded, "the unchanged files shouldn't be loaded again")
+
+	writeCert(t, certFile, keyFile, "second", start.Add(time.Second))
+	reloaded, err = r.reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, "second", cn())
+
+	// a rotation that's only half done shouldn't replace the certificate
+	require.NoError(t, ioutil.WriteFile(keyFile, []byte("not a key"), 0600))
+	_, err = r.reload()
+	assert.Error(t, err)
+	assert.Equal(t, "second", cn())
+}
+
+func TestTLSFilesInvalid(t *testing.T) {
+	config := localConfig()
+	config.TLSKeyFile = "key.pem"
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err, "the certificate file is required too")
+
+	config = localConfig()
+	config.TLSKeyFile = "key.pem"
+	config.TLSCertificateFile = "cert.pem"
+	config.TLSKey = "inline"
+	_, err = NewFromConfig(logrus.New(), config)
+	assert.Error(t, err, "the key can't be both inline and in a file")
+}
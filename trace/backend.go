@@ -36,6 +36,7 @@ type backendParams struct {
 	maxBackoff     time.Duration
 	connectTimeout time.Duration
 	bufferSize     uint
+	compress       bool
 }
 
 func (p *backendParams) params() *backendParams {
@@ -198,7 +199,11 @@ func (ds *streamBackend) SendSync(ctx context.Context, span *ssf.SSFSpan) error
 			return err
 		}
 	}
-	_, err := protocol.WriteSSF(ds.output, span)
+	write := protocol.WriteSSF
+	if ds.compress {
+		write = protocol.WriteSSFCompressed
+	}
+	_, err := write(ds.output, span)
 	if err != nil {
 		if protocol.IsFramingError(err) {
 			_ = ds.conn.Close()
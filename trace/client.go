@@ -66,6 +66,7 @@ type Client struct {
 	records          chan *recordOp
 	spans            chan<- *ssf.SSFSpan
 	sampleNormalizer func(*ssf.SSFSample)
+	sampleDropper    func(*ssf.SSFSample) bool
 
 	// statistics:
 	failedFlushes     int64
@@ -171,6 +172,18 @@ func BufferedSize(size uint) ClientParam {
 	}
 }
 
+// Compressed indicates that a client connected to a streaming (UNIX
+// domain socket) address should compress each span with zstd. Only
+// veneurs that support compressed SSF frames can read them. Clients
+// that send spans over UDP don't compress them.
+func Compressed(cl *Client) error {
+	if cl.backendParams != nil {
+		cl.backendParams.compress = true
+		return nil
+	}
+	return ErrClientNotNetworked
+}
+
 // FlushInterval sets up a buffered client to perform one synchronous
 // flush per time interval in a new goroutine. The goroutine closes
 // down when the Client's Close method is called.
@@ -300,6 +313,16 @@ func NormalizeSamples(normalizer func(*ssf.SSFSample)) ClientParam {
 	}
 }
 
+// DropSamples sets a function that decides, for each metric sample
+// reported as part of a span, whether to drop it from the span instead
+// of recording it. It runs after the normalizer set by NormalizeSamples.
+func DropSamples(drop func(*ssf.SSFSample) bool) ClientParam {
+	return func(cl *Client) error {
+		cl.sampleDropper = drop
+		return nil
+	}
+}
+
 func newFlushNofifier(backend ClientBackend) flushNotifier {
 	fb := flushNotifier{backend: backend}
 	if _, ok := backend.(FlushableClientBackend); ok {
@@ -492,6 +515,9 @@ func Record(cl *Client, span *ssf.SSFSpan, done chan<- error) error {
 			cl.sampleNormalizer(sample)
 		}
 	}
+	if cl.sampleDropper != nil {
+		span.Metrics = dropSamples(span.Metrics, cl.sampleDropper)
+	}
 
 	op := &recordOp{span: span, result: done}
 	select {
@@ -510,6 +536,25 @@ func Record(cl *Client, span *ssf.SSFSpan, done chan<- error) error {
 	return ErrWouldBlock
 }
 
+// dropSamples returns the samples that drop doesn't drop. It doesn't
+// modify samples, which may belong to the caller.
+func dropSamples(samples []*ssf.SSFSample, drop func(*ssf.SSFSample) bool) []*ssf.SSFSample {
+	for i, sample := range samples {
+		if !drop(sample) {
+			continue
+		}
+		kept := make([]*ssf.SSFSample, i, len(samples)-1)
+		copy(kept, samples[:i])
+		for _, sample := range samples[i+1:] {
+			if !drop(sample) {
+				kept = append(kept, sample)
+			}
+		}
+		return kept
+	}
+	return samples
+}
+
 // Flush instructs a client to flush to the upstream veneur all the
 // spans that were serialized up until the moment that the flush was
 // received. It will wait until the flush is completed (including all
@@ -1,6 +1,7 @@
 package trace_test
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -125,6 +126,44 @@ func TestUNIX(t *testing.T) {
 	}
 }
 
+func TestUNIXCompressed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_unix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sockName := filepath.Join(dir, "sock")
+	laddr, err := net.ResolveUnixAddr("unix", sockName)
+	require.NoError(t, err)
+
+	outPkg := make(chan *ssf.SSFSpan, 1)
+	cleanup := serveUNIX(t, laddr, func(in net.Conn) {
+		r := bufio.NewReader(in)
+		for {
+			version, err := r.Peek(1)
+			if err == io.EOF {
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, byte(1), version[0], "the frame should be compressed")
+			pkg, err := protocol.ReadSSF(r)
+			assert.NoError(t, err)
+			outPkg <- pkg
+		}
+	})
+	defer cleanup()
+
+	client, err := trace.NewClient((&url.URL{Scheme: "unix", Path: sockName}).String(), trace.Compressed)
+	require.NoError(t, err)
+	defer client.Close()
+
+	sentCh := make(chan error)
+	tr := trace.StartTrace("Testing-compressed")
+	tr.Sent = sentCh
+	mustRecord(t, client, tr)
+	assert.NoError(t, <-sentCh)
+	assert.Equal(t, tr.TraceID, (<-outPkg).TraceId)
+}
+
 func TestUNIXBuffered(t *testing.T) {
 	dir, err := ioutil.TempDir("", "test_unix")
 	require.NoError(t, err)
@@ -505,3 +544,28 @@ func TestNormalize(t *testing.T) {
 			"sample: %v", sample)
 	}
 }
+
+func TestDropSamples(t *testing.T) {
+	received := make(chan *ssf.SSFSpan, 1)
+
+	drop := func(sample *ssf.SSFSample) bool {
+		return sample.Name == "whee.gauge"
+	}
+	cl, err := trace.NewBackendClient(testbackend.NewBackend(received),
+		trace.Capacity(5),
+		trace.DropSamples(drop))
+	require.NoError(t, err)
+
+	span := trace.StartTrace("hi there")
+	samples := []*ssf.SSFSample{
+		ssf.Gauge("whee.gauge", 20, map[string]string{}),
+		ssf.Count("whee.counter", 20, map[string]string{}),
+	}
+	span.Add(samples...)
+	go mustRecord(t, cl, span)
+
+	out := <-received
+	require.Len(t, out.Metrics, 1)
+	assert.Equal(t, "whee.counter", out.Metrics[0].Name)
+	assert.Equal(t, "whee.gauge", samples[0].Name, "should leave the caller's samples alone")
+}
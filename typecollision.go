@@ -0,0 +1,115 @@
+package veneur
+
+import (
+	"sync"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+const (
+	// typeCollisionsKeep keeps the series of each type of a colliding
+	// metric separate, as they are when collisions aren't detected.
+	typeCollisionsKeep = "keep"
+	// typeCollisionsDrop drops the samples of the types of a colliding
+	// metric other than the first one seen in the flush interval.
+	typeCollisionsDrop = "drop"
+)
+
+// typeCollisions detects the metrics that arrive as more than one type
+// with the same name and tags in a flush interval, like a counter and a
+// gauge from emitters that disagree about the metric. Like
+// cardinalityLimiter, it's shared by all the workers, which only ask it
+// about the series that they haven't seen yet in the interval.
+type typeCollisions struct {
+	drop bool
+
+	mtx sync.Mutex
+	// types are the types that each series was seen as in the interval,
+	// the first one first.
+	types map[seriesName][]string
+	// collisions counts the series of each name that collided with
+	// another type in the interval.
+	collisions map[typeCollision]int64
+}
+
+// seriesName is a MetricKey without its type.
+type seriesName struct {
+	name       string
+	joinedTags string
+}
+
+// typeCollision is a metric that was seen as the type other after it
+// was seen as the type first.
+type typeCollision struct {
+	name  string
+	first string
+	other string
+}
+
+func newTypeCollisions(drop bool) *typeCollisions {
+	return &typeCollisions{
+		drop:       drop,
+		types:      map[seriesName][]string{},
+		collisions: map[typeCollision]int64{},
+	}
+}
+
+// admit returns whether a new series should be kept: unless collisions
+// are dropped, or the series is of the first type that its name and tags
+// were seen as in the interval.
+func (c *typeCollisions) admit(mk samplers.MetricKey) bool {
+	key := seriesName{name: mk.Name, joinedTags: mk.JoinedTags}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	types := c.types[key]
+	if len(types) == 0 {
+		c.types[key] = []string{mk.Type}
+		return true
+	}
+	if types[0] == mk.Type {
+		return true
+	}
+	seen := false
+	for _, typ := range types[1:] {
+		if typ == mk.Type {
+			seen = true
+			break
+		}
+	}
+	if !seen {
+		c.types[key] = append(types, mk.Type)
+		c.collisions[typeCollision{name: mk.Name, first: types[0], other: mk.Type}]++
+	}
+	return !c.drop
+}
+
+// flush starts a new flush interval, and returns the collisions of the
+// one that ended.
+func (c *typeCollisions) flush() map[typeCollision]int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	collisions := c.collisions
+	c.types = map[seriesName][]string{}
+	c.collisions = map[typeCollision]int64{}
+	return collisions
+}
+
+// reportTypeCollisions starts a new interval for the type collision
+// detection, and reports the collisions of the one that ended.
+func (s *Server) reportTypeCollisions() {
+	for _, c := range s.typeCollisions {
+		for collision, series := range c.flush() {
+			tags := []string{"metric:" + collision.name, "type:" + collision.other, "first_type:" + collision.first}
+			s.Statsd.Count("worker.metric_type_collisions_total", series, tags, 1.0)
+			entry := log.WithField("metric", collision.name).
+				WithField("type", collision.other).
+				WithField("first_type", collision.first).
+				WithField("series", series)
+			if c.drop {
+				entry.Warn("Dropped the samples of a metric that was also received as another type")
+			} else {
+				entry.Warn("Received a metric as more than one type")
+			}
+		}
+	}
+}
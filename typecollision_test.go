@@ -0,0 +1,70 @@
+package veneur
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe/veneur/v14/samplers"
+)
+
+func TestTypeCollisions(t *testing.T) {
+	key := func(typ, joinedTags string) samplers.MetricKey {
+		return samplers.MetricKey{Name: "a.b.c", Type: typ, JoinedTags: joinedTags}
+	}
+	c := newTypeCollisions(true)
+	assert.True(t, c.admit(key(counterTypeName, "env:prod")))
+	assert.True(t, c.admit(key(gaugeTypeName, "env:dev")), "series with other tags don't collide")
+	assert.False(t, c.admit(key(gaugeTypeName, "env:prod")))
+	assert.False(t, c.admit(key(gaugeTypeName, "env:prod")))
+	assert.True(t, c.admit(key(counterTypeName, "env:prod")))
+
+	assert.Equal(t, map[typeCollision]int64{
+		{name: "a.b.c", first: counterTypeName, other: gaugeTypeName}: 1,
+	}, c.flush())
+	assert.True(t, c.admit(key(gaugeTypeName, "env:prod")), "the first type should start over in each interval")
+	assert.Empty(t, c.flush())
+
+	c = newTypeCollisions(false)
+	assert.True(t, c.admit(key(counterTypeName, "")))
+	assert.True(t, c.admit(key(gaugeTypeName, "")), "collisions should be kept")
+	assert.Len(t, c.flush(), 1)
+}
+
+func TestMetricTypeCollisionsDrop(t *testing.T) {
+	rcv := make(chan []samplers.InterMetric, 10)
+	sink, err := NewChannelMetricSink(rcv)
+	require.NoError(t, err)
+
+	config := globalConfig()
+	config.MetricTypeCollisions = "drop"
+	global := setupVeneurServer(t, config, nil, sink, nil, nil)
+	defer global.Shutdown()
+
+	for _, typ := range []string{counterTypeName, gaugeTypeName} {
+		global.Workers[0].ProcessMetric(&samplers.UDPMetric{
+			MetricKey: samplers.MetricKey{
+				Name: "a.b.c",
+				Type: typ,
+			},
+			Value:      5.0,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+	}
+	global.Flush(context.Background())
+
+	results := <-rcv
+	require.Len(t, results, 1)
+	assert.Equal(t, samplers.CounterMetric, results[0].Type, "the gauge should have been dropped")
+}
+
+func TestMetricTypeCollisionsInvalid(t *testing.T) {
+	config := localConfig()
+	config.MetricTypeCollisions = "merge"
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err)
+}
@@ -0,0 +1,58 @@
+package veneur
+
+import (
+	"net"
+	"sync"
+)
+
+// fanoutPacket is a datagram that a udpFanout read off its socket, or
+// the error it got instead.
+type fanoutPacket struct {
+	buf  []byte
+	n    int
+	addr net.Addr
+	err  error
+}
+
+// udpFanout shares one UDP socket between several readers, where
+// SO_REUSEPORT can't give each of them a socket of its own: a dispatcher
+// goroutine reads the datagrams off the socket, and each goes to
+// whichever reader asks for the next one. It's a net.PacketConn, so
+// every reader can be passed the same udpFanout in place of a socket.
+type udpFanout struct {
+	net.PacketConn
+	pool    *sync.Pool
+	packets chan fanoutPacket
+}
+
+// newUDPFanout starts dispatching the datagrams of sock, reading them
+// into buffers from pool, which must be the pool the readers use so that
+// the datagrams fit their buffers.
+func newUDPFanout(sock net.PacketConn, pool *sync.Pool, readers int) *udpFanout {
+	f := &udpFanout{
+		PacketConn: sock,
+		pool:       pool,
+		packets:    make(chan fanoutPacket, readers),
+	}
+	go f.dispatch()
+	return f
+}
+
+func (f *udpFanout) dispatch() {
+	for {
+		buf := f.pool.Get().([]byte)
+		n, addr, err := f.PacketConn.ReadFrom(buf)
+		f.packets <- fanoutPacket{buf: buf, n: n, addr: addr, err: err}
+	}
+}
+
+// ReadFrom copies the next datagram into b, like net.PacketConn's
+// ReadFrom. It's safe for concurrent use.
+func (f *udpFanout) ReadFrom(b []byte) (int, net.Addr, error) {
+	p := <-f.packets
+	defer f.pool.Put(p.buf)
+	if p.err != nil {
+		return 0, nil, p.err
+	}
+	return copy(b, p.buf[:p.n]), p.addr, nil
+}
@@ -0,0 +1,55 @@
+package veneur
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUDPFanout(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	sock, err := NewSocket(addr, 2*1024*1024, false)
+	require.NoError(t, err)
+	defer sock.Close()
+
+	pool := &sync.Pool{New: func() interface{} { return make([]byte, 64) }}
+	const readers = 3
+	fanout := newUDPFanout(sock, pool, readers)
+
+	const packets = 30
+	received := make(chan string, packets)
+	for i := 0; i < readers; i++ {
+		go func() {
+			buf := make([]byte, 64)
+			for {
+				n, _, err := fanout.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				received <- string(buf[:n])
+			}
+		}()
+	}
+
+	client, err := net.DialUDP("udp", nil, sock.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer client.Close()
+	var sent []string
+	for i := 0; i < packets; i++ {
+		packet := fmt.Sprintf("a.b.c:%d|c", i)
+		sent = append(sent, packet)
+		_, err := client.Write([]byte(packet))
+		require.NoError(t, err)
+	}
+
+	var got []string
+	for range sent {
+		got = append(got, <-received)
+	}
+	assert.ElementsMatch(t, sent, got, "every datagram should go to exactly one reader")
+}
@@ -0,0 +1,141 @@
+package veneur
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// errUDPSocketStatUnsupported is returned by UDPSocketStat on platforms
+// that don't expose /proc/net/udp.
+var errUDPSocketStatUnsupported = errors.New("/proc/net/udp is not supported on this platform")
+
+// UDPSocketStats holds the counters that the kernel reports in
+// /proc/net/udp and /proc/net/udp6 for the UDP sockets bound to a
+// local address.
+type UDPSocketStats struct {
+	// LocalAddress is the address the sockets are bound to, e.g.
+	// "127.0.0.1:8126" or "[::]:8126".
+	LocalAddress string
+	// Sockets is the number of sockets bound to the address, which is
+	// more than one with SO_REUSEPORT.
+	Sockets int
+	// RxQueue is the number of bytes queued on the sockets, waiting to
+	// be read.
+	RxQueue uint64
+	// Drops is the number of datagrams dropped since the sockets were
+	// created, mostly because their receive buffers were full.
+	Drops uint64
+}
+
+// nativeEndian is the byte order that the kernel prints the words of
+// addresses in.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	var x uint16 = 1
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// parseProcNetUDP parses the contents of /proc/net/udp or
+// /proc/net/udp6 into stats, adding up the sockets bound to the same
+// local address.
+func parseProcNetUDP(r io.Reader, stats map[string]UDPSocketStats) error {
+	scanner := bufio.NewScanner(r)
+	// skip the header
+	scanner.Scan()
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 13 {
+			return fmt.Errorf("udp line %d has %d columns, expected at least 13", line, len(fields))
+		}
+		addr, err := parseProcNetAddr(fields[1])
+		if err != nil {
+			return fmt.Errorf("udp line %d: %v", line, err)
+		}
+		queues := strings.SplitN(fields[4], ":", 2)
+		if len(queues) != 2 {
+			return fmt.Errorf("udp line %d: invalid queues %q", line, fields[4])
+		}
+		rxQueue, err := strconv.ParseUint(queues[1], 16, 64)
+		if err != nil {
+			return fmt.Errorf("udp line %d: %v", line, err)
+		}
+		drops, err := strconv.ParseUint(fields[12], 10, 64)
+		if err != nil {
+			return fmt.Errorf("udp line %d: %v", line, err)
+		}
+		stat := stats[addr]
+		stat.LocalAddress = addr
+		stat.Sockets++
+		stat.RxQueue += rxQueue
+		stat.Drops += drops
+		stats[addr] = stat
+	}
+	return scanner.Err()
+}
+
+// parseProcNetAddr parses an address like "0100007F:1FBE" as the kernel
+// prints them: the IP as 32-bit words in hexadecimal, in the host's byte
+// order, and the port in hexadecimal.
+func parseProcNetAddr(s string) (string, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid address %q", s)
+	}
+	words, err := hex.DecodeString(parts[0])
+	if err != nil || (len(words) != net.IPv4len && len(words) != net.IPv6len) {
+		return "", fmt.Errorf("invalid IP in address %q", s)
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid port in address %q", s)
+	}
+	ip := make(net.IP, len(words))
+	for i := 0; i < len(words); i += 4 {
+		nativeEndian.PutUint32(ip[i:], binary.BigEndian.Uint32(words[i:]))
+	}
+	return (&net.UDPAddr{IP: ip, Port: int(port)}).String(), nil
+}
+
+// reportUDPSocketStats emits the drops and the queued bytes of the
+// sockets bound to the ports of veneur's UDP listeners, tagged with
+// their local address.
+func (s *Server) reportUDPSocketStats() {
+	ports := map[string]bool{}
+	s.listenerStats.mtx.Lock()
+	for _, ls := range s.listenerStats.stats {
+		if ls.protocol != DOGSTATSD_UDP.String() && ls.protocol != SSF_UDP.String() {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(ls.address); err == nil {
+			ports[port] = true
+		}
+	}
+	s.listenerStats.mtx.Unlock()
+	if len(ports) == 0 {
+		return
+	}
+
+	stats, err := UDPSocketStat()
+	if err != nil {
+		log.WithError(err).Debug("Could not read the UDP socket stats")
+		return
+	}
+	for addr, stat := range stats {
+		if _, port, err := net.SplitHostPort(addr); err != nil || !ports[port] {
+			continue
+		}
+		tags := []string{"listener:" + addr}
+		s.Statsd.Gauge("listener.udp_drops", float64(stat.Drops), tags, 1.0)
+		s.Statsd.Gauge("listener.udp_receive_queue_bytes", float64(stat.RxQueue), tags, 1.0)
+	}
+}
@@ -0,0 +1,27 @@
+package veneur
+
+import (
+	"os"
+)
+
+// UDPSocketStat reads the counters of the UDP sockets on the host
+// from /proc/net/udp and /proc/net/udp6, by local address.
+func UDPSocketStat() (map[string]UDPSocketStats, error) {
+	stats := map[string]UDPSocketStats{}
+	for _, path := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			// e.g. udp6 without IPv6 support
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = parseProcNetUDP(f, stats)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
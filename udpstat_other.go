@@ -0,0 +1,9 @@
+// +build !linux
+
+package veneur
+
+// UDPSocketStat is not supported on this platform, and always returns
+// an error.
+func UDPSocketStat() (map[string]UDPSocketStats, error) {
+	return nil, errUDPSocketStatUnsupported
+}
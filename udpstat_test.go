@@ -0,0 +1,45 @@
+package veneur
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcNetUDP(t *testing.T) {
+	if nativeEndian != binary.LittleEndian {
+		t.Skip("the addresses below are printed by a little-endian kernel")
+	}
+	input := "" +
+		"   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n" +
+		"  100: 0100007F:1FBE 00000000:0000 07 00000000:00000200 00:00000000 00000000  1000        0 1001 2 0000000000000000 3\n" +
+		"  100: 0100007F:1FBE 00000000:0000 07 00000000:00000100 00:00000000 00000000  1000        0 1002 2 0000000000000000 4\n" +
+		"  200: 00000000:1FBF 00000000:0000 07 00000000:00000000 00:00000000 00000000  1000        0 1003 2 0000000000000000 0\n"
+	stats := map[string]UDPSocketStats{}
+	require.NoError(t, parseProcNetUDP(strings.NewReader(input), stats))
+
+	input6 := "" +
+		"  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n" +
+		" 300: 00000000000000000000000001000000:1FBE 00000000000000000000000000000000:0000 07 00000000:00000000 00:00000000 00000000  1000        0 1004 2 0000000000000000 7\n"
+	require.NoError(t, parseProcNetUDP(strings.NewReader(input6), stats))
+
+	assert.Equal(t, map[string]UDPSocketStats{
+		"127.0.0.1:8126": {LocalAddress: "127.0.0.1:8126", Sockets: 2, RxQueue: 0x300, Drops: 7},
+		"0.0.0.0:8127":   {LocalAddress: "0.0.0.0:8127", Sockets: 1},
+		"[::1]:8126":     {LocalAddress: "[::1]:8126", Sockets: 1, Drops: 7},
+	}, stats)
+}
+
+func TestParseProcNetUDPInvalid(t *testing.T) {
+	header := "   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n"
+	for _, line := range []string{
+		"  100: 0100007F:1FBE 00000000:0000 07 00000000:00000200\n",
+		"  100: 0100007F 00000000:0000 07 00000000:00000200 00:00000000 00000000  1000        0 1001 2 0000000000000000 3\n",
+		"  100: 0100007F:1FBE 00000000:0000 07 00000000:00000200 00:00000000 00000000  1000        0 1001 2 0000000000000000 x\n",
+	} {
+		assert.Error(t, parseProcNetUDP(strings.NewReader(header+line), map[string]UDPSocketStats{}), line)
+	}
+}
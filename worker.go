@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
+	"path"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -26,6 +28,7 @@ const histogramTypeName = "histogram"
 const setTypeName = "set"
 const timerTypeName = "timer"
 const statusTypeName = "status"
+const distributionTypeName = "distribution"
 
 // Worker is the doodad that does work.
 type Worker struct {
@@ -38,6 +41,7 @@ type Worker struct {
 	ImportChan            chan []samplers.JSONMetric
 	ImportMetricChan      chan []*metricpb.Metric
 	QuitChan              chan struct{}
+	syncChan              chan chan struct{}
 	processed             int64
 	imported              int64
 	mutex                 *sync.Mutex
@@ -45,11 +49,150 @@ type Worker struct {
 	logger                *logrus.Logger
 	wm                    WorkerMetrics
 	stats                 scopedstatsd.Client
+
+	// flushGroups assign the metrics whose names match their patterns to
+	// the server's flush groups, in the order they're matched. The
+	// metrics of each group are sampled into its groupWMs instead of wm,
+	// and flushGroupNames caches the group of each name seen during this
+	// flush interval.
+	flushGroups     []flushGroupPattern
+	flushGroupNames map[string]int
+	groupWMs        []WorkerMetrics
+
+	// aggregatesOnly are the patterns of histogram and timer names that
+	// skip the t-digest, and aggregatesOnlyNames caches whether each name
+	// seen during this flush interval matches one.
+	aggregatesOnly      []string
+	aggregatesOnlyNames map[string]bool
+
+	// reservoirs size the reservoirs that replace the t-digest of the
+	// histograms and timers whose names match their patterns, and
+	// reservoirSizes caches the size for each name seen during this flush
+	// interval, 0 if it has none.
+	reservoirs     []histogramReservoir
+	reservoirSizes map[string]int
+
+	// setMaxCardinality is the default cap on the cardinality of sets,
+	// and setCardinalityOverrides replace it for matching set names.
+	setMaxCardinality       uint64
+	setCardinalityOverrides []setCardinalityOverride
+
+	// valueBounds bound the values of the metrics whose names match their
+	// patterns, and valueBoundNames caches the bound of each name seen
+	// during this flush interval, nil if it has none. outOfBounds counts
+	// the values that were clamped or dropped since the last flush.
+	valueBounds     []valueBound
+	valueBoundNames map[string]*valueBound
+	outOfBounds     map[outOfBoundsKey]int64
+
+	// nonNegative are the patterns of the names of the counters that
+	// only ever count up, and of the gauges that are never negative, and
+	// nonNegativeNames caches the one each name seen during this flush
+	// interval matches, nil if none. negativeValues counts the negative
+	// values that they received since the last flush.
+	nonNegative      []nonNegativeMetric
+	nonNegativeNames map[string]*nonNegativeMetric
+	negativeValues   map[negativeValueKey]int64
+
+	// cardinality, if set, is shared by all the workers to cap the
+	// number of series of each metric name.
+	cardinality *cardinalityLimiter
+
+	// typeCollisions, if set, is shared by all the workers to detect the
+	// metrics that arrive as more than one type.
+	typeCollisions *typeCollisions
+
+	// gaugeTTL, if positive, is how many flush intervals a gauge keeps
+	// being flushed with its last value after it stops being sampled.
+	// recentGauges and recentGlobalGauges are the gauges flushed by the
+	// last flush, and are only used by Flush.
+	gaugeTTL           int
+	recentGauges       map[samplers.MetricKey]*recentGauge
+	recentGlobalGauges map[samplers.MetricKey]*recentGauge
+
+	// dropWhenFull makes IngestUDP drop the metrics that don't fit in
+	// PacketChan rather than wait for room, and dropped counts them.
+	dropWhenFull bool
+	dropped      int64
+}
+
+// recentGauge is a gauge that was flushed recently, and the number of
+// flush intervals since it was last sampled.
+type recentGauge struct {
+	gauge *samplers.Gauge
+	idle  int
+}
+
+// histogramReservoir replaces the t-digest of the histograms and timers
+// whose names match pattern with a reservoir of up to size samples.
+type histogramReservoir struct {
+	pattern string
+	size    int
+}
+
+// setCardinalityOverride caps the cardinality of the sets whose names
+// match pattern at max instead of the default.
+type setCardinalityOverride struct {
+	pattern string
+	max     uint64
+}
+
+// valueBound bounds the values of the counters, gauges, histograms,
+// timers and distributions whose names match pattern to [min, max]: the
+// values outside of it are dropped if drop is set, and clamped to it
+// otherwise.
+type valueBound struct {
+	pattern  string
+	min, max float64
+	drop     bool
+}
+
+// outOfBoundsKey counts the values of a metric that were out of its
+// bounds, apart for the dropped and the clamped ones.
+type outOfBoundsKey struct {
+	name    string
+	dropped bool
+}
+
+// nonNegativeMetric flags the counters and gauges whose names match
+// pattern as never negative: a negative counter delta or gauge value is
+// a client bug, and is dropped if drop is set, or only counted and
+// logged otherwise.
+type nonNegativeMetric struct {
+	pattern string
+	drop    bool
+}
+
+// negativeValueKey counts the negative values of a metric, by its type,
+// apart for the dropped and the kept ones.
+type negativeValueKey struct {
+	name    string
+	typ     string
+	dropped bool
 }
 
 // IngestUDP on a Worker feeds the metric into the worker's PacketChan.
+// If the worker's queue is bounded (see setQueueSize), the metric is
+// dropped when the queue is full.
 func (w *Worker) IngestUDP(metric samplers.UDPMetric) {
-	w.PacketChan <- metric
+	if !w.dropWhenFull {
+		w.PacketChan <- metric
+		return
+	}
+	select {
+	case w.PacketChan <- metric:
+	default:
+		atomic.AddInt64(&w.dropped, 1)
+	}
+}
+
+// setQueueSize makes PacketChan hold up to size metrics, and IngestUDP
+// drop the metrics that arrive while it's full instead of blocking the
+// reader until the worker catches up. It must be called before the
+// worker starts working.
+func (w *Worker) setQueueSize(size int) {
+	w.PacketChan = make(chan samplers.UDPMetric, size)
+	w.dropWhenFull = true
 }
 
 func (w *Worker) IngestMetrics(ms []*metricpb.Metric) {
@@ -81,6 +224,23 @@ type WorkerMetrics struct {
 	localSets         map[samplers.MetricKey]*samplers.Set
 	localTimers       map[samplers.MetricKey]*samplers.Histo
 	localStatusChecks map[samplers.MetricKey]*samplers.StatusCheck
+
+	// distributions are never forwarded, since the sinks that receive
+	// them as they are aggregate them across hosts themselves.
+	distributions map[samplers.MetricKey]*samplers.Distribution
+
+	// these are for counters and gauges whose points carried the
+	// timestamp of an earlier flush interval. They're flushed locally
+	// with that timestamp, and never forwarded.
+	backfilledCounters map[backfillKey]*samplers.Counter
+	backfilledGauges   map[backfillKey]*samplers.Gauge
+}
+
+// backfillKey identifies a backfilled metric by its key and the start
+// of the flush interval that its points were timestamped in.
+type backfillKey struct {
+	samplers.MetricKey
+	timestamp int64
 }
 
 // NewWorkerMetrics initializes a WorkerMetrics struct
@@ -99,7 +259,63 @@ func NewWorkerMetrics() WorkerMetrics {
 		localSets:         map[samplers.MetricKey]*samplers.Set{},
 		localTimers:       map[samplers.MetricKey]*samplers.Histo{},
 		localStatusChecks: map[samplers.MetricKey]*samplers.StatusCheck{},
+		distributions:     map[samplers.MetricKey]*samplers.Distribution{},
+
+		backfilledCounters: map[backfillKey]*samplers.Counter{},
+		backfilledGauges:   map[backfillKey]*samplers.Gauge{},
+	}
+}
+
+// upsertAggregatesOnly creates a local histogram or timer entry that
+// doesn't track a t-digest for the given metrickey, if one does not
+// already exist. Returns true if the entry was created.
+func (wm WorkerMetrics) upsertAggregatesOnly(mk samplers.MetricKey, tags []string) bool {
+	hists := wm.localHistograms
+	if mk.Type == timerTypeName {
+		hists = wm.localTimers
+	}
+	_, present := hists[mk]
+	if !present {
+		hists[mk] = samplers.NewAggregatesOnlyHist(mk.Name, tags)
+	}
+	return !present
+}
+
+// upsertReservoir creates a local histogram or timer entry that computes
+// its percentiles from a reservoir of up to size samples for the given
+// metrickey, if one does not already exist. Returns true if the entry was
+// created.
+func (wm WorkerMetrics) upsertReservoir(mk samplers.MetricKey, tags []string, size int) bool {
+	hists := wm.localHistograms
+	if mk.Type == timerTypeName {
+		hists = wm.localTimers
+	}
+	_, present := hists[mk]
+	if !present {
+		hists[mk] = samplers.NewReservoirHist(mk.Name, tags, size)
+	}
+	return !present
+}
+
+// sampleBackfilled samples a counter or gauge point into the flush
+// interval starting at its timestamp.
+func (wm WorkerMetrics) sampleBackfilled(m *samplers.UDPMetric) {
+	key := backfillKey{MetricKey: m.MetricKey, timestamp: m.Timestamp}
+	if m.Type == counterTypeName {
+		c, present := wm.backfilledCounters[key]
+		if !present {
+			c = samplers.NewCounter(m.Name, m.Tags)
+			wm.backfilledCounters[key] = c
+		}
+		c.Sample(m.Value.(float64), m.SampleRate)
+		return
+	}
+	g, present := wm.backfilledGauges[key]
+	if !present {
+		g = samplers.NewGauge(m.Name, m.Tags)
+		wm.backfilledGauges[key] = g
 	}
+	g.Sample(m.Value.(float64), m.SampleRate)
 }
 
 // Upsert creates an entry on the WorkerMetrics struct for the given metrickey (if one does not already exist)
@@ -170,12 +386,60 @@ func (wm WorkerMetrics) Upsert(mk samplers.MetricKey, Scope samplers.MetricScope
 		if _, present = wm.localStatusChecks[mk]; !present {
 			wm.localStatusChecks[mk] = samplers.NewStatusCheck(mk.Name, tags)
 		}
+	case distributionTypeName:
+		if _, present = wm.distributions[mk]; !present {
+			wm.distributions[mk] = samplers.NewDistribution(mk.Name, tags)
+		}
 		// no need to raise errors on unknown types
 		// the caller will probably end up doing that themselves
 	}
 	return !present
 }
 
+// remove deletes the entry that Upsert created for the given metrickey.
+func (wm WorkerMetrics) remove(mk samplers.MetricKey, Scope samplers.MetricScope) {
+	switch mk.Type {
+	case counterTypeName:
+		if Scope == samplers.GlobalOnly {
+			delete(wm.globalCounters, mk)
+		} else {
+			delete(wm.counters, mk)
+		}
+	case gaugeTypeName:
+		if Scope == samplers.GlobalOnly {
+			delete(wm.globalGauges, mk)
+		} else {
+			delete(wm.gauges, mk)
+		}
+	case histogramTypeName:
+		if Scope == samplers.LocalOnly {
+			delete(wm.localHistograms, mk)
+		} else if Scope == samplers.GlobalOnly {
+			delete(wm.globalHistograms, mk)
+		} else {
+			delete(wm.histograms, mk)
+		}
+	case setTypeName:
+		if Scope == samplers.LocalOnly {
+			delete(wm.localSets, mk)
+		} else {
+			delete(wm.sets, mk)
+		}
+	case timerTypeName:
+		if Scope == samplers.LocalOnly {
+			delete(wm.localTimers, mk)
+		} else if Scope == samplers.GlobalOnly {
+			delete(wm.globalTimers, mk)
+		} else {
+			delete(wm.timers, mk)
+		}
+	case statusTypeName:
+		delete(wm.localStatusChecks, mk)
+	case distributionTypeName:
+		delete(wm.distributions, mk)
+	}
+}
+
 // ForwardableMetrics converts all metrics that should be forwarded to
 // metricpb.Metric (protobuf-compatible).
 func (wm WorkerMetrics) ForwardableMetrics(cl *trace.Client) []*metricpb.Metric {
@@ -250,6 +514,7 @@ func NewWorker(id int, isLocal bool, countUniqueTimeseries bool, cl *trace.Clien
 		ImportChan:            make(chan []samplers.JSONMetric, 32),
 		ImportMetricChan:      make(chan []*metricpb.Metric, 32),
 		QuitChan:              make(chan struct{}),
+		syncChan:              make(chan chan struct{}),
 		processed:             0,
 		imported:              0,
 		mutex:                 &sync.Mutex{},
@@ -278,6 +543,17 @@ func (w *Worker) Work() {
 			for _, m := range ms {
 				w.ImportMetricGRPC(m)
 			}
+		case done := <-w.syncChan:
+			// the caller is done sending, so everything it sent
+			// is in the channel's buffer
+			for len(w.PacketChan) > 0 {
+				m := <-w.PacketChan
+				if w.countUniqueTimeseries {
+					w.SampleTimeseries(&m)
+				}
+				w.ProcessMetric(&m)
+			}
+			close(done)
 		case <-w.QuitChan:
 			// We have been asked to stop.
 			log.WithField("worker", w.id).Error("Stopping")
@@ -286,6 +562,14 @@ func (w *Worker) Work() {
 	}
 }
 
+// Sync waits until the worker has processed the metrics that were sent to
+// its PacketChan before the call.
+func (w *Worker) Sync() {
+	done := make(chan struct{})
+	w.syncChan <- done
+	<-done
+}
+
 // MetricsProcessedCount is a convenince method for testing
 // that allows us to fetch the Worker's processed count
 // in a non-racey way.
@@ -333,7 +617,7 @@ func (w *Worker) SampleTimeseries(m *samplers.UDPMetric) {
 		if m.Scope == samplers.LocalOnly {
 			w.uniqueMTS.Insert(digest)
 		}
-	case statusTypeName:
+	case statusTypeName, distributionTypeName:
 		w.uniqueMTS.Insert(digest)
 	default:
 		log.WithField("type", m.Type).Error("Unknown metric type for counting")
@@ -344,86 +628,144 @@ func (w *Worker) SampleTimeseries(m *samplers.UDPMetric) {
 func (w *Worker) ProcessMetric(m *samplers.UDPMetric) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
+	wm := w.metricsFor(m.Name)
 	w.processed++
-	w.wm.Upsert(m.MetricKey, m.Scope, m.Tags)
+	if !w.boundValue(m) || !w.checkNonNegative(m) {
+		return
+	}
+	if m.Timestamp != 0 && (m.Type == counterTypeName || m.Type == gaugeTypeName) {
+		wm.sampleBackfilled(m)
+		return
+	}
+	created := false
+	if (m.Type == histogramTypeName || m.Type == timerTypeName) && w.isAggregatesOnly(m.Name) {
+		// A global veneur would only add percentiles to these, so
+		// they're never forwarded.
+		m.Scope = samplers.LocalOnly
+		created = wm.upsertAggregatesOnly(m.MetricKey, m.Tags)
+	} else if m.Type == histogramTypeName || m.Type == timerTypeName {
+		if size := w.reservoirSize(m.Name); size > 0 {
+			// reservoirs can't be merged, so these aren't forwarded
+			// either
+			m.Scope = samplers.LocalOnly
+			created = wm.upsertReservoir(m.MetricKey, m.Tags, size)
+		}
+	}
+	if wm.Upsert(m.MetricKey, m.Scope, m.Tags) {
+		created = true
+	}
+	if created && w.typeCollisions != nil && !w.typeCollisions.admit(m.MetricKey) {
+		wm.remove(m.MetricKey, m.Scope)
+		return
+	}
+	// The limiter counts series per flush interval, so the metrics of the
+	// flush groups, which start over on other intervals, aren't limited.
+	if created && w.cardinality != nil && wm == &w.wm && !w.cardinality.admit(m.Name, m.Tags) {
+		wm.remove(m.MetricKey, m.Scope)
+		return
+	}
 
 	switch m.Type {
 	case counterTypeName:
 		if m.Scope == samplers.GlobalOnly {
-			w.wm.globalCounters[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			wm.globalCounters[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
 		} else {
-			w.wm.counters[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			wm.counters[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
 		}
 	case gaugeTypeName:
 		if m.Scope == samplers.GlobalOnly {
-			w.wm.globalGauges[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			wm.globalGauges[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
 		} else {
-			w.wm.gauges[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			wm.gauges[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
 		}
 	case histogramTypeName:
 		if m.Scope == samplers.LocalOnly {
-			w.wm.localHistograms[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			sampleHisto(wm.localHistograms[m.MetricKey], m)
 		} else if m.Scope == samplers.GlobalOnly {
-			w.wm.globalHistograms[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			sampleHisto(wm.globalHistograms[m.MetricKey], m)
 		} else {
-			w.wm.histograms[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			sampleHisto(wm.histograms[m.MetricKey], m)
 		}
 	case setTypeName:
+		set := wm.sets[m.MetricKey]
 		if m.Scope == samplers.LocalOnly {
-			w.wm.localSets[m.MetricKey].Sample(m.Value.(string))
-		} else {
-			w.wm.sets[m.MetricKey].Sample(m.Value.(string))
+			set = wm.localSets[m.MetricKey]
+		}
+		if created {
+			set.MaxCardinality = w.maxSetCardinality(m.Name)
+		}
+		if !set.Capped() {
+			set.Sample(m.Value.(string))
+			if set.Capped() {
+				w.stats.Count("worker.sets_capped_total", 1, []string{"metric:" + m.Name}, 1.0)
+			}
 		}
 	case timerTypeName:
 		if m.Scope == samplers.LocalOnly {
-			w.wm.localTimers[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			sampleHisto(wm.localTimers[m.MetricKey], m)
 		} else if m.Scope == samplers.GlobalOnly {
-			w.wm.globalTimers[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			sampleHisto(wm.globalTimers[m.MetricKey], m)
 		} else {
-			w.wm.timers[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			sampleHisto(wm.timers[m.MetricKey], m)
 		}
 	case statusTypeName:
 		v := float64(m.Value.(ssf.SSFSample_Status))
-		w.wm.localStatusChecks[m.MetricKey].Sample(v, m.SampleRate, m.Message, m.HostName)
+		wm.localStatusChecks[m.MetricKey].Sample(v, m.SampleRate, m.Message, m.HostName)
+	case distributionTypeName:
+		d := wm.distributions[m.MetricKey]
+		d.Sample(m.Value.(float64), m.SampleRate)
+		if m.TraceID != 0 {
+			d.SampleExemplar(m.Value.(float64), m.TraceID, m.SpanID)
+		}
 	default:
 		log.WithField("type", m.Type).Error("Unknown metric type for processing")
 	}
 }
 
+// sampleHisto samples a histogram or timer, offering the sample as its
+// exemplar if it was traced.
+func sampleHisto(h *samplers.Histo, m *samplers.UDPMetric) {
+	h.Sample(m.Value.(float64), m.SampleRate)
+	if m.TraceID != 0 {
+		h.SampleExemplar(m.Value.(float64), m.TraceID, m.SpanID)
+	}
+}
+
 // ImportMetric receives a metric from another veneur instance
 func (w *Worker) ImportMetric(other samplers.JSONMetric) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
+	wm := w.metricsFor(other.Name)
 
 	// we don't increment the processed metric counter here, it was already
 	// counted by the original veneur that sent this to us
 	w.imported++
 	if other.Type == counterTypeName || other.Type == gaugeTypeName {
 		// this is an odd special case -- counters that are imported are global
-		w.wm.Upsert(other.MetricKey, samplers.GlobalOnly, other.Tags)
+		wm.Upsert(other.MetricKey, samplers.GlobalOnly, other.Tags)
 	} else {
-		w.wm.Upsert(other.MetricKey, samplers.MixedScope, other.Tags)
+		wm.Upsert(other.MetricKey, samplers.MixedScope, other.Tags)
 	}
 
 	switch other.Type {
 	case counterTypeName:
-		if err := w.wm.globalCounters[other.MetricKey].Combine(other.Value); err != nil {
+		if err := wm.globalCounters[other.MetricKey].Combine(other.Value); err != nil {
 			log.WithError(err).Error("Could not merge counters")
 		}
 	case gaugeTypeName:
-		if err := w.wm.globalGauges[other.MetricKey].Combine(other.Value); err != nil {
+		if err := wm.globalGauges[other.MetricKey].Combine(other.Value); err != nil {
 			log.WithError(err).Error("Could not merge gauges")
 		}
 	case setTypeName:
-		if err := w.wm.sets[other.MetricKey].Combine(other.Value); err != nil {
+		if err := wm.sets[other.MetricKey].Combine(other.Value); err != nil {
 			log.WithError(err).Error("Could not merge sets")
 		}
 	case histogramTypeName:
-		if err := w.wm.histograms[other.MetricKey].Combine(other.Value); err != nil {
+		if err := wm.histograms[other.MetricKey].Combine(other.Value); err != nil {
 			log.WithError(err).Error("Could not merge histograms")
 		}
 	case timerTypeName:
-		if err := w.wm.timers[other.MetricKey].Combine(other.Value); err != nil {
+		if err := wm.timers[other.MetricKey].Combine(other.Value); err != nil {
 			log.WithError(err).Error("Could not merge timers")
 		}
 	default:
@@ -438,6 +780,7 @@ func (w *Worker) ImportMetric(other samplers.JSONMetric) {
 func (w *Worker) ImportMetricGRPC(other *metricpb.Metric) (err error) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
+	wm := w.metricsFor(other.Name)
 
 	key := samplers.NewMetricKeyFromMetric(other)
 
@@ -450,31 +793,31 @@ func (w *Worker) ImportMetricGRPC(other *metricpb.Metric) (err error) {
 		return fmt.Errorf("gRPC import does not accept local metrics")
 	}
 
-	w.wm.Upsert(key, scope, other.Tags)
+	wm.Upsert(key, scope, other.Tags)
 	w.imported++
 
 	switch v := other.GetValue().(type) {
 	case *metricpb.Metric_Counter:
-		w.wm.globalCounters[key].Merge(v.Counter)
+		wm.globalCounters[key].Merge(v.Counter)
 	case *metricpb.Metric_Gauge:
-		w.wm.globalGauges[key].Merge(v.Gauge)
+		wm.globalGauges[key].Merge(v.Gauge)
 	case *metricpb.Metric_Set:
-		if merr := w.wm.sets[key].Merge(v.Set); merr != nil {
+		if merr := wm.sets[key].Merge(v.Set); merr != nil {
 			err = fmt.Errorf("could not merge a set: %v", err)
 		}
 	case *metricpb.Metric_Histogram:
 		switch other.Type {
 		case metricpb.Type_Histogram:
 			if other.Scope == metricpb.Scope_Mixed {
-				w.wm.histograms[key].Merge(v.Histogram)
+				wm.histograms[key].Merge(v.Histogram)
 			} else if other.Scope == metricpb.Scope_Global {
-				w.wm.globalHistograms[key].Merge(v.Histogram)
+				wm.globalHistograms[key].Merge(v.Histogram)
 			}
 		case metricpb.Type_Timer:
 			if other.Scope == metricpb.Scope_Mixed {
-				w.wm.timers[key].Merge(v.Histogram)
+				wm.timers[key].Merge(v.Histogram)
 			} else if other.Scope == metricpb.Scope_Global {
-				w.wm.globalTimers[key].Merge(v.Histogram)
+				wm.globalTimers[key].Merge(v.Histogram)
 			}
 		}
 	case nil:
@@ -504,18 +847,273 @@ func (w *Worker) Flush() WorkerMetrics {
 	ret := w.wm
 	processed := w.processed
 	imported := w.imported
+	outOfBounds := w.outOfBounds
+	negativeValues := w.negativeValues
 
 	w.wm = wm
 	w.processed = 0
 	w.imported = 0
+	w.aggregatesOnlyNames = nil
+	w.reservoirSizes = nil
+	w.flushGroupNames = nil
+	w.valueBoundNames = nil
+	w.outOfBounds = nil
+	w.nonNegativeNames = nil
+	w.negativeValues = nil
 	w.mutex.Unlock()
 
 	w.stats.Count("worker.metrics_processed_total", processed, []string{}, 1.0)
 	w.stats.Count("worker.metrics_imported_total", imported, []string{}, 1.0)
+	for key, count := range outOfBounds {
+		action := "clamp"
+		if key.dropped {
+			action = "drop"
+		}
+		w.stats.Count("worker.values_out_of_bounds_total", count, []string{"metric:" + key.name, "action:" + action}, 1.0)
+	}
+	for key, count := range negativeValues {
+		action := "log"
+		if key.dropped {
+			action = "drop"
+		}
+		w.stats.Count("worker.negative_values_total", count, []string{"metric:" + key.name, "metric_type:" + key.typ, "action:" + action}, 1.0)
+		w.logger.WithFields(logrus.Fields{
+			"metric": key.name,
+			"type":   key.typ,
+			"count":  count,
+			"action": action,
+		}).Warn("Received negative values of a metric that's never negative")
+	}
+
+	if w.gaugeTTL > 0 {
+		if w.recentGauges == nil {
+			w.recentGauges = map[samplers.MetricKey]*recentGauge{}
+			w.recentGlobalGauges = map[samplers.MetricKey]*recentGauge{}
+		}
+		expired := carryGauges(ret.gauges, w.recentGauges, w.gaugeTTL) +
+			carryGauges(ret.globalGauges, w.recentGlobalGauges, w.gaugeTTL)
+		w.stats.Count("worker.gauges_expired_total", int64(expired), []string{}, 1.0)
+	}
 
 	return ret
 }
 
+// carryGauges adds the recent gauges that weren't sampled in this flush
+// interval to the flushed ones, with their last value, unless they've
+// gone unsampled for more than ttl intervals, in which case they expire.
+// It records the flushed gauges in recent, and returns how many expired.
+func carryGauges(flushed map[samplers.MetricKey]*samplers.Gauge, recent map[samplers.MetricKey]*recentGauge, ttl int) int {
+	expired := 0
+	for key, r := range recent {
+		if _, sampled := flushed[key]; sampled {
+			delete(recent, key)
+			continue
+		}
+		r.idle++
+		if r.idle > ttl {
+			delete(recent, key)
+			expired++
+			continue
+		}
+		flushed[key] = r.gauge
+	}
+	for key, g := range flushed {
+		if _, ok := recent[key]; !ok {
+			recent[key] = &recentGauge{gauge: g}
+		}
+	}
+	return expired
+}
+
+// setFlushGroups sets the patterns that assign metrics to each of the
+// given number of flush groups.
+func (w *Worker) setFlushGroups(patterns []flushGroupPattern, groups int) {
+	w.flushGroups = patterns
+	w.groupWMs = make([]WorkerMetrics, groups)
+	for i := range w.groupWMs {
+		w.groupWMs[i] = NewWorkerMetrics()
+	}
+}
+
+// FlushGroup resets the worker's metrics of a flush group and returns
+// their contents.
+func (w *Worker) FlushGroup(group int) WorkerMetrics {
+	wm := NewWorkerMetrics()
+	w.mutex.Lock()
+	ret := w.groupWMs[group]
+	w.groupWMs[group] = wm
+	w.mutex.Unlock()
+	return ret
+}
+
+// metricsFor returns the metrics that the metric with the given name is
+// sampled into: those of the flush group of the first of the worker's
+// flush group patterns that it matches, or wm if it matches none. Since
+// each name is only ever sampled into one of them, no point is flushed
+// by more than one flush. It must be called with the mutex held.
+func (w *Worker) metricsFor(name string) *WorkerMetrics {
+	if len(w.flushGroups) == 0 {
+		return &w.wm
+	}
+	group, ok := w.flushGroupNames[name]
+	if !ok {
+		group = regularFlush
+		for _, fg := range w.flushGroups {
+			// patterns are validated when the server is created
+			if matched, _ := path.Match(fg.pattern, name); matched {
+				group = fg.group
+				break
+			}
+		}
+		if w.flushGroupNames == nil {
+			w.flushGroupNames = map[string]int{}
+		}
+		w.flushGroupNames[name] = group
+	}
+	if group != regularFlush {
+		return &w.groupWMs[group]
+	}
+	return &w.wm
+}
+
+// isAggregatesOnly returns true if the histogram or timer name matches
+// one of the worker's aggregates-only patterns.
+func (w *Worker) isAggregatesOnly(name string) bool {
+	if len(w.aggregatesOnly) == 0 {
+		return false
+	}
+	if matched, ok := w.aggregatesOnlyNames[name]; ok {
+		return matched
+	}
+	matched := false
+	for _, pattern := range w.aggregatesOnly {
+		// patterns are validated when the server is created
+		if ok, _ := path.Match(pattern, name); ok {
+			matched = true
+			break
+		}
+	}
+	if w.aggregatesOnlyNames == nil {
+		w.aggregatesOnlyNames = map[string]bool{}
+	}
+	w.aggregatesOnlyNames[name] = matched
+	return matched
+}
+
+// reservoirSize returns the size of the reservoir that replaces the
+// t-digest of the histogram or timer with the given name, or 0 if it
+// keeps its t-digest. Only the first matching pattern applies.
+func (w *Worker) reservoirSize(name string) int {
+	if len(w.reservoirs) == 0 {
+		return 0
+	}
+	if size, ok := w.reservoirSizes[name]; ok {
+		return size
+	}
+	size := 0
+	for _, reservoir := range w.reservoirs {
+		// patterns are validated when the server is created
+		if ok, _ := path.Match(reservoir.pattern, name); ok {
+			size = reservoir.size
+			break
+		}
+	}
+	if w.reservoirSizes == nil {
+		w.reservoirSizes = map[string]int{}
+	}
+	w.reservoirSizes[name] = size
+	return size
+}
+
+// boundValue applies the first of the worker's value bounds whose pattern
+// the metric's name matches to its value, and returns false if the
+// metric should be dropped. NaN values are out of every bound, and are
+// always dropped. It must be called with the mutex held.
+func (w *Worker) boundValue(m *samplers.UDPMetric) bool {
+	if len(w.valueBounds) == 0 {
+		return true
+	}
+	// sets and status checks don't have numeric values
+	value, ok := m.Value.(float64)
+	if !ok {
+		return true
+	}
+	bound, ok := w.valueBoundNames[m.Name]
+	if !ok {
+		for i := range w.valueBounds {
+			// patterns are validated when the server is created
+			if matched, _ := path.Match(w.valueBounds[i].pattern, m.Name); matched {
+				bound = &w.valueBounds[i]
+				break
+			}
+		}
+		if w.valueBoundNames == nil {
+			w.valueBoundNames = map[string]*valueBound{}
+		}
+		w.valueBoundNames[m.Name] = bound
+	}
+	if bound == nil || (value >= bound.min && value <= bound.max) {
+		return true
+	}
+
+	drop := bound.drop || math.IsNaN(value)
+	if w.outOfBounds == nil {
+		w.outOfBounds = map[outOfBoundsKey]int64{}
+	}
+	w.outOfBounds[outOfBoundsKey{name: m.Name, dropped: drop}]++
+	if drop {
+		return false
+	}
+	m.Value = math.Min(math.Max(value, bound.min), bound.max)
+	return true
+}
+
+// checkNonNegative counts the negative values of the counters and gauges
+// that match one of the worker's non-negative patterns, and returns false
+// if the metric should be dropped. It must be called with the mutex held.
+func (w *Worker) checkNonNegative(m *samplers.UDPMetric) bool {
+	if len(w.nonNegative) == 0 || (m.Type != counterTypeName && m.Type != gaugeTypeName) {
+		return true
+	}
+	if value, ok := m.Value.(float64); !ok || value >= 0 {
+		return true
+	}
+	rule, ok := w.nonNegativeNames[m.Name]
+	if !ok {
+		for i := range w.nonNegative {
+			// patterns are validated when the server is created
+			if matched, _ := path.Match(w.nonNegative[i].pattern, m.Name); matched {
+				rule = &w.nonNegative[i]
+				break
+			}
+		}
+		if w.nonNegativeNames == nil {
+			w.nonNegativeNames = map[string]*nonNegativeMetric{}
+		}
+		w.nonNegativeNames[m.Name] = rule
+	}
+	if rule == nil {
+		return true
+	}
+	if w.negativeValues == nil {
+		w.negativeValues = map[negativeValueKey]int64{}
+	}
+	w.negativeValues[negativeValueKey{name: m.Name, typ: m.Type, dropped: rule.drop}]++
+	return !rule.drop
+}
+
+// maxSetCardinality returns the cap on the cardinality of the set with the
+// given name. Only the first matching override applies.
+func (w *Worker) maxSetCardinality(name string) uint64 {
+	for _, override := range w.setCardinalityOverrides {
+		// patterns are validated when the server is created
+		if ok, _ := path.Match(override.pattern, name); ok {
+			return override.max
+		}
+	}
+	return w.setMaxCardinality
+}
+
 // Stop tells the worker to stop listening for work requests.
 //
 // Note that the worker will only stop *after* it has finished its work.
@@ -1,6 +1,8 @@
 package veneur
 
 import (
+	"math"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -59,6 +61,380 @@ func TestWorkerLocal(t *testing.T) {
 	assert.Len(t, wm.histograms, 0, "number of global histograms")
 }
 
+func TestWorkerAggregatesOnly(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.aggregatesOnly = []string{"a.*.c", "timer.d"}
+
+	for _, mk := range []samplers.MetricKey{
+		{Name: "a.b.c", Type: "histogram"},
+		{Name: "timer.d", Type: "timer"},
+		{Name: "a.b.d", Type: "histogram"},
+	} {
+		m := samplers.UDPMetric{
+			MetricKey:  mk,
+			Value:      1.0,
+			Digest:     12345,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		}
+		w.ProcessMetric(&m)
+	}
+
+	wm := w.Flush()
+	require.Len(t, wm.localHistograms, 1, "number of local histograms")
+	for _, h := range wm.localHistograms {
+		assert.Equal(t, "a.b.c", h.Name)
+		assert.Nil(t, h.Value, "should not have a digest")
+	}
+	require.Len(t, wm.localTimers, 1, "number of local timers")
+	for _, h := range wm.localTimers {
+		assert.Equal(t, "timer.d", h.Name)
+		assert.Nil(t, h.Value, "should not have a digest")
+	}
+	require.Len(t, wm.histograms, 1, "number of mixed histograms")
+	for _, h := range wm.histograms {
+		assert.Equal(t, "a.b.d", h.Name)
+		assert.NotNil(t, h.Value)
+	}
+	assert.Len(t, wm.ForwardableMetrics(nil), 1, "aggregates-only histograms aren't forwarded")
+}
+
+func TestWorkerReservoirs(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.aggregatesOnly = []string{"a.b.*"}
+	w.reservoirs = []histogramReservoir{
+		{pattern: "a.*", size: 10},
+		{pattern: "timer.*", size: 20},
+	}
+
+	for _, mk := range []samplers.MetricKey{
+		{Name: "a.b.c", Type: "histogram"},
+		{Name: "a.c.d", Type: "histogram"},
+		{Name: "timer.d", Type: "timer"},
+		{Name: "b.c.d", Type: "histogram"},
+	} {
+		for i := 0; i < 50; i++ {
+			m := samplers.UDPMetric{
+				MetricKey:  mk,
+				Value:      float64(i),
+				Digest:     12345,
+				SampleRate: 1.0,
+				Scope:      samplers.MixedScope,
+			}
+			w.ProcessMetric(&m)
+		}
+	}
+
+	wm := w.Flush()
+	require.Len(t, wm.localHistograms, 2, "number of local histograms")
+	for _, h := range wm.localHistograms {
+		assert.Nil(t, h.Value, "should not have a digest")
+		if h.Name == "a.b.c" {
+			assert.Nil(t, h.Reservoir, "aggregates-only histograms have no reservoir")
+		} else {
+			assert.Equal(t, "a.c.d", h.Name)
+			require.NotNil(t, h.Reservoir)
+			assert.Equal(t, 10, h.Reservoir.Len())
+		}
+	}
+	require.Len(t, wm.localTimers, 1, "number of local timers")
+	for _, h := range wm.localTimers {
+		assert.Equal(t, "timer.d", h.Name)
+		require.NotNil(t, h.Reservoir)
+		assert.Equal(t, 20, h.Reservoir.Len())
+		assert.Equal(t, 50.0, h.LocalWeight, "the aggregates count every sample")
+	}
+	require.Len(t, wm.histograms, 1, "number of mixed histograms")
+	assert.Len(t, wm.ForwardableMetrics(nil), 1, "histograms with reservoirs aren't forwarded")
+}
+
+func TestHistogramReservoirsInvalid(t *testing.T) {
+	config := localConfig()
+	config.HistogramReservoirs = append(config.HistogramReservoirs, struct {
+		Metric string `yaml:"metric"`
+		Size   int    `yaml:"size"`
+	}{Metric: "a.*"})
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err, "the size is required")
+}
+
+func TestWorkerValueBounds(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.valueBounds = []valueBound{
+		{pattern: "latency.clamped", min: 0, max: 1000},
+		{pattern: "latency.*", min: 0, max: 1000, drop: true},
+	}
+
+	process := func(name, typ string, value interface{}) {
+		w.ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: name, Type: typ},
+			Value:      value,
+			Digest:     12345,
+			SampleRate: 1.0,
+			Scope:      samplers.LocalOnly,
+		})
+	}
+	for _, value := range []float64{-5, 10, 1e18, math.NaN()} {
+		process("latency.clamped", "histogram", value)
+		process("latency.dropped", "histogram", value)
+		if !math.IsNaN(value) {
+			process("other", "histogram", value)
+		}
+	}
+	process("latency.set", "set", "1e18")
+
+	assert.Equal(t, map[outOfBoundsKey]int64{
+		{name: "latency.clamped", dropped: false}: 2,
+		{name: "latency.clamped", dropped: true}:  1,
+		{name: "latency.dropped", dropped: true}:  3,
+	}, w.outOfBounds)
+
+	wm := w.Flush()
+	require.Len(t, wm.localHistograms, 3, "number of local histograms")
+	for _, h := range wm.localHistograms {
+		switch h.Name {
+		case "latency.clamped":
+			assert.Equal(t, 3.0, h.LocalWeight)
+			assert.Equal(t, 0.0, h.LocalMin)
+			assert.Equal(t, 1000.0, h.LocalMax)
+		case "latency.dropped":
+			assert.Equal(t, 1.0, h.LocalWeight)
+			assert.Equal(t, 10.0, h.LocalMax)
+		default:
+			assert.Equal(t, 3.0, h.LocalWeight, "unbounded metrics should keep every value")
+		}
+	}
+	assert.Len(t, wm.localSets, 1, "sets aren't bounded")
+	assert.Nil(t, w.outOfBounds, "the flush should reset the counts")
+}
+
+func TestMetricValueBoundsInvalid(t *testing.T) {
+	min, max := 10.0, 1.0
+	tests := map[string]struct {
+		min, max *float64
+		action   string
+	}{
+		"no bounds":      {},
+		"min above max":  {min: &min, max: &max},
+		"unknown action": {max: &max, action: "ignore"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := localConfig()
+			config.MetricValueBounds = append(config.MetricValueBounds, struct {
+				Metric string   `yaml:"metric"`
+				Min    *float64 `yaml:"min"`
+				Max    *float64 `yaml:"max"`
+				Action string   `yaml:"action"`
+			}{Metric: "a.*", Min: test.min, Max: test.max, Action: test.action})
+			_, err := NewFromConfig(logrus.New(), config)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestWorkerNonNegative(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.nonNegative = []nonNegativeMetric{
+		{pattern: "requests.logged", drop: false},
+		{pattern: "requests.*", drop: true},
+		{pattern: "queue.depth", drop: true},
+	}
+
+	process := func(name, typ string, value float64) {
+		w.ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: name, Type: typ},
+			Value:      value,
+			Digest:     12345,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+	}
+	for _, value := range []float64{3, -1, 2} {
+		process("requests.logged", counterTypeName, value)
+		process("requests.dropped", counterTypeName, value)
+		process("other", counterTypeName, value)
+	}
+	process("queue.depth", gaugeTypeName, 5)
+	process("queue.depth", gaugeTypeName, -5)
+	process("requests.latency", histogramTypeName, -5)
+
+	assert.Equal(t, map[negativeValueKey]int64{
+		{name: "requests.logged", typ: counterTypeName, dropped: false}: 1,
+		{name: "requests.dropped", typ: counterTypeName, dropped: true}: 1,
+		{name: "queue.depth", typ: gaugeTypeName, dropped: true}:        1,
+	}, w.negativeValues)
+
+	wm := w.Flush()
+	counts := map[string]float64{}
+	for _, c := range wm.counters {
+		counts[c.Name] = c.Flush(time.Second)[0].Value
+	}
+	assert.Equal(t, map[string]float64{
+		"requests.logged":  4,
+		"requests.dropped": 5,
+		"other":            4,
+	}, counts)
+	require.Len(t, wm.gauges, 1)
+	for _, g := range wm.gauges {
+		assert.Equal(t, 5.0, g.Flush()[0].Value, "the negative gauge value should be dropped")
+	}
+	assert.Len(t, wm.histograms, 1, "only counters and gauges are checked")
+	assert.Nil(t, w.negativeValues, "the flush should reset the counts")
+}
+
+func TestNonNegativeMetricsInvalid(t *testing.T) {
+	config := localConfig()
+	config.NonNegativeMetrics = append(config.NonNegativeMetrics, struct {
+		Metric string `yaml:"metric"`
+		Action string `yaml:"action"`
+	}{Metric: "requests.*", Action: "clamp"})
+	_, err := NewFromConfig(logrus.New(), config)
+	assert.Error(t, err, "the action should be drop or log")
+}
+
+func TestWorkerFastFlush(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.setFlushGroups([]flushGroupPattern{{pattern: "fast.*", group: 0}}, 1)
+
+	for _, name := range []string{"fast.a", "fast.a", "slow.a"} {
+		m := samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: name, Type: "counter"},
+			Value:      1.0,
+			Digest:     12345,
+			SampleRate: 1.0,
+		}
+		w.ProcessMetric(&m)
+	}
+	w.ImportMetric(samplers.JSONMetric{
+		MetricKey: samplers.MetricKey{Name: "fast.b", Type: "gauge"},
+		Value:     []byte{0, 0, 0, 0, 0, 0, 0xf0, 0x3f},
+	})
+
+	fast := w.FlushGroup(0)
+	require.Len(t, fast.counters, 1, "number of fast counters")
+	for _, c := range fast.counters {
+		assert.Equal(t, "fast.a", c.Name)
+		assert.Equal(t, 2.0, c.Flush(time.Second)[0].Value)
+	}
+	assert.Len(t, fast.globalGauges, 1, "imported metrics should be partitioned too")
+	assert.Len(t, w.FlushGroup(0).counters, 0, "should flush no fast metrics")
+
+	wm := w.Flush()
+	require.Len(t, wm.counters, 1, "fast counters shouldn't be flushed by the regular flush")
+	for _, c := range wm.counters {
+		assert.Equal(t, "slow.a", c.Name)
+	}
+	assert.Len(t, wm.globalGauges, 0)
+}
+
+func TestWorkerTimerExemplar(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	for _, traceID := range []int64{0, 5} {
+		w.ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: "timer"},
+			Value:      float64(traceID),
+			Digest:     12345,
+			SampleRate: 1.0,
+			Scope:      samplers.LocalOnly,
+			TraceID:    traceID,
+			SpanID:     traceID * 10,
+		})
+	}
+
+	wm := w.Flush()
+	require.Len(t, wm.localTimers, 1)
+	for _, timer := range wm.localTimers {
+		require.NotNil(t, timer.Exemplar, "the traced sample should be the exemplar")
+		assert.Equal(t, 5.0, timer.Exemplar.Value)
+		assert.Equal(t, int64(5), timer.Exemplar.TraceID)
+		assert.Equal(t, int64(50), timer.Exemplar.SpanID)
+	}
+}
+
+func TestWorkerSetMaxCardinality(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.setMaxCardinality = 10
+	w.setCardinalityOverrides = []setCardinalityOverride{
+		{pattern: "big.*", max: 0},
+		{pattern: "big.small", max: 1000},
+	}
+
+	for _, name := range []string{"a.b.c", "big.set"} {
+		for i := 0; i < 500; i++ {
+			m := samplers.UDPMetric{
+				MetricKey: samplers.MetricKey{
+					Name: name,
+					Type: "set",
+				},
+				Value:      strconv.Itoa(i),
+				Digest:     12345,
+				SampleRate: 1.0,
+				Scope:      samplers.MixedScope,
+			}
+			w.ProcessMetric(&m)
+		}
+	}
+	assert.Equal(t, uint64(0), w.maxSetCardinality("big.small"), "only the first override applies")
+
+	wm := w.Flush()
+	require.Len(t, wm.sets, 2)
+	for mk, set := range wm.sets {
+		switch mk.Name {
+		case "a.b.c":
+			assert.True(t, set.Capped(), "should be capped at the default")
+			assert.True(t, set.Hll.Estimate() < 100)
+		case "big.set":
+			assert.False(t, set.Capped(), "should be unlimited by its override")
+		}
+	}
+}
+
+func TestWorkerGaugeTTL(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.gaugeTTL = 2
+
+	sample := func(name string, value float64, scope samplers.MetricScope) {
+		w.ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: name, Type: "gauge"},
+			Value:      value,
+			SampleRate: 1.0,
+			Scope:      scope,
+		})
+	}
+	values := func(gauges map[samplers.MetricKey]*samplers.Gauge) map[string]float64 {
+		res := map[string]float64{}
+		for _, g := range gauges {
+			res[g.Name] = g.Flush()[0].Value
+		}
+		return res
+	}
+
+	sample("a.b.c", 1, samplers.MixedScope)
+	sample("d.e.f", 2, samplers.GlobalOnly)
+	wm := w.Flush()
+	assert.Equal(t, map[string]float64{"a.b.c": 1}, values(wm.gauges))
+	assert.Equal(t, map[string]float64{"d.e.f": 2}, values(wm.globalGauges))
+
+	sample("a.b.c", 3, samplers.MixedScope)
+	wm = w.Flush()
+	assert.Equal(t, map[string]float64{"a.b.c": 3}, values(wm.gauges))
+	assert.Equal(t, map[string]float64{"d.e.f": 2}, values(wm.globalGauges), "should carry the last value over")
+
+	wm = w.Flush()
+	assert.Equal(t, map[string]float64{"a.b.c": 3}, values(wm.gauges), "should carry the last value over")
+	assert.Equal(t, map[string]float64{"d.e.f": 2}, values(wm.globalGauges), "should carry the last value over twice")
+
+	wm = w.Flush()
+	assert.Equal(t, map[string]float64{"a.b.c": 3}, values(wm.gauges), "should carry the last value over twice")
+	assert.Len(t, wm.globalGauges, 0, "should expire after two idle intervals")
+
+	wm = w.Flush()
+	assert.Len(t, wm.gauges, 0, "should expire after two idle intervals")
+	assert.Len(t, w.recentGauges, 0)
+	assert.Len(t, w.recentGlobalGauges, 0)
+}
+
 func TestWorkerGlobal(t *testing.T) {
 	w := NewWorker(1, false, false, nil, logrus.New(), nil)
 
@@ -625,3 +1001,19 @@ func BenchmarkSampleTimeseries(b *testing.B) {
 		w.SampleTimeseries(input[i%Len])
 	}
 }
+
+func TestWorkerQueueDrops(t *testing.T) {
+	w := NewWorker(1, true, false, nil, logrus.New(), nil)
+	w.setQueueSize(2)
+
+	// the worker isn't working, so nothing leaves its queue
+	for i := 0; i < 5; i++ {
+		w.IngestUDP(samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: "counter"},
+			Value:      1.0,
+			SampleRate: 1.0,
+		})
+	}
+	assert.Len(t, w.PacketChan, 2)
+	assert.Equal(t, int64(3), w.dropped, "the metrics that don't fit should be dropped")
+}